@@ -1,13 +1,97 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"text/tabwriter"
 	"time"
+
+	"Go-Internals/abuse"
+	"Go-Internals/accesslog"
+	"Go-Internals/activity"
+	"Go-Internals/adaptive"
+	"Go-Internals/admin"
+	"Go-Internals/approval"
+	"Go-Internals/assets"
+	"Go-Internals/atrest"
+	"Go-Internals/audit"
+	"Go-Internals/auth"
+	"Go-Internals/avatar"
+	"Go-Internals/backup"
+	"Go-Internals/bandwidth"
+	"Go-Internals/blobstore"
+	"Go-Internals/bootstrap"
+	"Go-Internals/boundedqueue"
+	"Go-Internals/buildinfo"
+	"Go-Internals/catalog"
+	"Go-Internals/channels"
+	"Go-Internals/clock"
+	"Go-Internals/compression"
+	"Go-Internals/config"
+	"Go-Internals/cpuprof"
+	"Go-Internals/crashreport"
+	"Go-Internals/ctxutil"
+	"Go-Internals/datamove"
+	"Go-Internals/dedupe"
+	"Go-Internals/emailaddr"
+	"Go-Internals/errortrack"
+	"Go-Internals/eventbus"
+	"Go-Internals/experiment"
+	"Go-Internals/featureflag"
+	"Go-Internals/fieldcrypt"
+	"Go-Internals/flightrec"
+	"Go-Internals/geoip"
+	"Go-Internals/httpapi"
+	"Go-Internals/httpsec"
+	"Go-Internals/i18n"
+	"Go-Internals/impersonate"
+	"Go-Internals/integrity"
+	"Go-Internals/keyset"
+	"Go-Internals/kv"
+	"Go-Internals/loadshed"
+	"Go-Internals/lockout"
+	"Go-Internals/logfile"
+	"Go-Internals/logfilter"
+	"Go-Internals/maintenance"
+	"Go-Internals/normalize"
+	"Go-Internals/oauth"
+	"Go-Internals/plugins"
+	"Go-Internals/privacy"
+	"Go-Internals/query"
+	"Go-Internals/reqsign"
+	"Go-Internals/retention"
+	"Go-Internals/runmode"
+	"Go-Internals/script"
+	"Go-Internals/serviceaccount"
+	"Go-Internals/session"
+	"Go-Internals/sigctl"
+	"Go-Internals/slowop"
+	"Go-Internals/templates"
+	"Go-Internals/twofactor"
+	"Go-Internals/upload"
+	"Go-Internals/users"
+	"Go-Internals/users/kvstore"
+	"Go-Internals/users/mmapstore"
+	"Go-Internals/vacuum"
+	"Go-Internals/window"
+	"Go-Internals/wiring"
 )
 
 /*
@@ -18,199 +102,1352 @@ CONSTANTS & VARIABLES
 
 const AppName = "Go Backend Fundamentals Practice"
 
-var appVersion = "1.0.0"
+/*
+-----------------------------------
+STORAGE BACKEND SELECTION
+-----------------------------------
+*/
+
+// openRepo picks the UserRepository backend. The returned func releases
+// whatever the backend holds open (files, mappings). File-backed stores
+// encrypt emails at rest when $USERS_DATA_KEYS holds a keyring.
+func openRepo(kind, path string, plugs *plugins.Set) (users.UserRepository, func() error, error) {
+	keys, err := atrest.FromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	switch kind {
+	case "memory":
+		return users.NewInMemoryUserRepo(), func() error { return nil }, nil
+	case "mmap":
+		s, err := mmapstore.OpenWith(path, mmapstore.Options{Keys: keys})
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	case "kv":
+		s, err := kvstore.Open(path, kv.Options{Verify: true, Keys: keys})
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	case "plugin":
+		// The plugin set owns the process; closing it stops the store.
+		if r, ok := plugs.Storage(); ok {
+			return r, func() error { return nil }, nil
+		}
+		return nil, nil, errors.New("store plugin: -plugins lists no storage plugin")
+	default:
+		return nil, nil, fmt.Errorf("unknown store %q (want memory, mmap, kv or plugin)", kind)
+	}
+}
 
 /*
 -----------------------------------
-STRUCTS
+HTTP SERVER
 -----------------------------------
 */
 
-// User represents a basic entity (like DB model)
-type User struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
+// jwtSigner signs tokens with keys' current version, or without keys
+// with $USERS_JWT_SECRET; without either a random secret is generated.
+// Without $USERS_JWT_SECRET an admin token is printed, which is only good
+// for local runs.
+func jwtSigner(keys *keyset.Set) (*auth.HS256, error) {
+	signer := &auth.HS256{Key: []byte(os.Getenv("USERS_JWT_SECRET"))}
+	if keys != nil {
+		signer.Keys = keys
+	}
+	if len(signer.Key) == 0 {
+		if keys == nil {
+			signer.Key = []byte(rand.Text())
+		}
+		token, err := signer.Issue("dev-admin", []string{auth.RoleAdmin}, 12*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println("dev admin token:", token)
+	}
+	return signer, nil
+}
+
+// peerVerifier verifies requests signed with the keys in file, nil if
+// file is "".
+func peerVerifier(file string, require []string) (*reqsign.Verifier, error) {
+	if file == "" {
+		if len(require) > 0 {
+			return nil, errors.New("-require-signed needs -peer-keys")
+		}
+		return nil, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keys, err := reqsign.LoadKeys(f)
+	if err != nil {
+		return nil, err
+	}
+	return reqsign.NewVerifier(reqsign.Options{Keys: keys, Require: require})
+}
+
+// backupRestore restores the backups in dir into backend, as usersctl
+// restore does, or is nil without dir. It writes below the service, so
+// the store must be empty and nothing is told of the users it loads.
+func backupRestore(dir string, backend users.UserRepository) (func(context.Context, uint64) (int, error), error) {
+	if dir == "" {
+		return nil, nil
+	}
+	target, ok := backend.(backup.Target)
+	if !ok {
+		return nil, errors.New("-backup-dir: the store cannot take records with their IDs")
+	}
+	keys, err := atrest.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, seq uint64) (int, error) {
+		return backup.Restore(ctx, dir, seq, target, backup.Options{Keys: keys})
+	}, nil
+}
+
+// printReport writes -check-only's table: one line per dependency.
+func printReport(w io.Writer, report bootstrap.Report) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, res := range report {
+		status := "ok"
+		if !res.OK {
+			status = "FAIL"
+		}
+		attempts := "1 attempt"
+		if res.Attempts != 1 {
+			attempts = strconv.Itoa(res.Attempts) + " attempts"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", status, res.Name, attempts, res.Took.Round(time.Millisecond), res.Error)
+	}
+	tw.Flush()
+}
+
+// httpDeps is what the HTTP server is built from: what the rest of main
+// made, and the settings taken from the flags. Nil parts leave their
+// routes or features out, as in httpapi.Config.
+type httpDeps struct {
+	Addr     string
+	Timeout  time.Duration
+	Security httpsec.Options
+	Timeouts httpsec.Timeouts
+	DryRun   bool
+	// DownloadRate and ConnRate cap export downloads, all together and
+	// over one connection, in bytes a second; 0 is unlimited.
+	DownloadRate, ConnRate int64
+
+	Service *users.UserService
+	// Repo is the store under the service, for its optional interfaces
+	// and the dashboard's counts.
+	Repo    users.UserRepository
+	Signer  *auth.HS256
+	Restore func(context.Context, uint64) (int, error)
+	// Reencrypt re-encrypts a store's fields after a key rotation, by
+	// the name of the key set.
+	Reencrypt map[string]func(context.Context) (int, error)
+	KeySets   []*keyset.Set
+
+	Sessions       *session.Manager
+	Machines       *serviceaccount.Manager
+	Impersonations *impersonate.Manager
+	Approvals      *approval.Manager
+	Maintenance    *maintenance.Switch
+	Signatures     *reqsign.Verifier
+	Audit          *audit.Ring
+	Privacy        *privacy.Manager
+	Merges         *dedupe.Manager
+	History        *activity.History
+	Experiments    *experiment.Service
+	Lockout        *lockout.Guard
+	TwoFactor      *twofactor.Manager
+	OAuth          *oauth.Manager
+	Avatars        *avatar.Avatars
+	Uploads        *upload.Manager
+
+	AccessLog *accesslog.Logger
+	LogLevels *logfilter.Levels
+	LogQueue  *boundedqueue.Queue[logEntry]
+	Errors    *errortrack.Tracker
+	SlowOps   *slowop.Detector
+	Traces    *flightrec.Recorder
+	Profiler  *cpuprof.Profiler
+	Crashes   *crashreport.Reporter
+	Assets    *assets.Bundle
 }
 
-// Custom error
-var ErrUserNotFound = errors.New("user not found")
+// httpService serves the API and admin dashboard from Start to Stop,
+// verifying tokens with d.Signer and checking them against d.Sessions'
+// revocations.
+func httpService(d httpDeps) (runmode.Service, error) {
+	requests := window.New(time.Minute, 60, nil)
+	limiter := adaptive.New(adaptive.Options{Initial: 50})
+	downloads := bandwidth.New(d.DownloadRate, nil)
+	downloadConns := bandwidth.NewPool(d.ConnRate, nil)
+	shedder := loadshed.New(loadshed.Options{
+		Signals:       []loadshed.Signal{loadshed.LimiterUtilization(limiter), loadshed.QueueDepth(d.LogQueue)},
+		TargetLatency: 50 * time.Millisecond,
+	})
+	// Only some backends (and none of the wrappers) know when records
+	// changed; without them responses carry ETags alone.
+	modTimes, _ := d.Repo.(users.ModTimes)
+	changes, _ := d.Repo.(users.ChangeFeed)
+	handler := httpapi.New(httpapi.Config{
+		Service:    d.Service,
+		ModTimes:   modTimes,
+		Changes:    changes,
+		Auth:       d.Signer,
+		Lockout:    d.Lockout,
+		TwoFactor:  d.TwoFactor,
+		Signer:     d.Signer,
+		Sessions:   d.Sessions,
+		Keys:       d.KeySets,
+		Signatures: d.Signatures,
+		Reencrypt:  d.Reencrypt,
+		// Impersonations are in memory too: a restart forgets the list,
+		// though their tokens last until they expire.
+		Impersonation: d.Impersonations,
+		Approvals:     d.Approvals,
+		Maintenance:   d.Maintenance,
+		DryRun:        d.DryRun,
+		Restore:       d.Restore,
+		OAuth:         d.OAuth,
+		// Service accounts live in memory, like the catalogue: a restart
+		// forgets them.
+		ServiceAccounts: d.Machines,
+		Requests:        requests,
+		Limiter:         limiter,
+		Shedder:         shedder,
+		Crash:           d.Crashes,
+		Privacy:         d.Privacy,
+		Dedupe:          d.Merges,
+		Activity:        d.History,
+		Experiments:     d.Experiments,
+		Avatars:         d.Avatars,
+		Uploads:         d.Uploads,
+		AccessLog:       d.AccessLog,
+		// Always set: the dashboard shows download traffic even unlimited.
+		Bandwidth:     downloads,
+		ConnBandwidth: downloadConns,
+		Products:      catalog.NewInMemoryProductRepo(),
+		// Defaults: gzip or deflate above 1 KiB, request bodies up to 64 MiB decoded.
+		Compression: &compression.Options{},
+		Errors:      d.Errors,
+		Timeout:     d.Timeout,
+		Security:    &d.Security,
+		Traces:      d.Traces,
+		Debug:       true,
+		Profiler:    d.Profiler,
+		Admin: admin.Handler(admin.Sources{
+			UserCount: func() int {
+				n, _ := users.Count(context.Background(), d.Repo, query.All())
+				return n
+			},
+			Registrations: func(since time.Time) ([]users.DayCount, error) {
+				agg, err := users.Aggregate(context.Background(), d.Repo, query.CreatedAfter(since))
+				return agg.PerDay, err
+			},
+			Audit:     d.Audit,
+			Queues:    []func() []admin.QueueStat{admin.Queue("async-logger", d.LogQueue)},
+			Rates:     map[string]*window.Counter{"http requests": requests},
+			Limiters:  map[string]*adaptive.Limiter{"users api": limiter},
+			Bandwidth: map[string]func() bandwidth.Stats{"downloads": downloads.Stats, "downloads per connection": downloadConns.Stats},
+			LogLevels: d.LogLevels,
+			Errors:    d.Errors.Groups,
+			SlowOps:   d.SlowOps.Recent,
+			Traces:    d.Traces,
+			Profiles:  d.Profiler,
+			Static:    d.Assets.FS("admin"),
+		}),
+		Assets: d.Assets,
+	})
+	srv := &http.Server{Addr: d.Addr, Handler: handler}
+	d.Timeouts.Apply(srv)
+
+	// Listening in Start makes a taken port fail startup rather than
+	// surface later in a log line.
+	return runmode.Func("http",
+		func(context.Context) error {
+			ln, err := net.Listen("tcp", d.Addr)
+			if err != nil {
+				return err
+			}
+			fmt.Println("listening on", ln.Addr(), "(dashboard at /admin/)")
+			go func() {
+				if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+					slog.Error("http: server stopped", "err", err)
+				}
+			}()
+			return nil
+		},
+		srv.Shutdown,
+	), nil
+}
 
 /*
 -----------------------------------
-INTERFACE (VERY IMPORTANT)
+UTILITY FUNCTIONS
 -----------------------------------
 */
 
-type UserRepository interface {
-	Create(user User) (User, error)
-	GetByID(id int) (User, error)
-	List() []User
+// Variadic function
+func Sum(nums ...int) int {
+	total := 0
+	for _, n := range nums {
+		total += n
+	}
+	return total
 }
 
 /*
 -----------------------------------
-IN-MEMORY REPOSITORY
+GOROUTINES & CHANNELS
 -----------------------------------
 */
 
-type InMemoryUserRepo struct {
-	mu     sync.Mutex
-	users  map[int]User
-	nextID int
+// asyncLogger drains the queue until it is closed.
+// The queue (not an unbuffered channel) decides what happens when logging
+// can't keep up, so a slow writer never stalls request handling.
+func asyncLogger(q *boundedqueue.Queue[logEntry], wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for e := range q.All() {
+		ctxutil.Logger(e.ctx).Info("ASYNC LOG: " + e.msg)
+	}
+}
+
+// logEntry is a message for the async logger with the context it was
+// logged in, detached: the line carries the request ID and caller of a
+// request that may be long over by the time it is written.
+type logEntry struct {
+	ctx context.Context
+	msg string
+}
+
+func logAsync(ctx context.Context, q *boundedqueue.Queue[logEntry], msg string) {
+	q.TryEnqueue(logEntry{ctxutil.Detach(ctx), msg})
 }
 
-func NewInMemoryUserRepo() *InMemoryUserRepo {
-	return &InMemoryUserRepo{
-		users:  make(map[int]User),
-		nextID: 1,
+// drainService runs drain, which empties q, from Start; Stop closes q
+// and waits for what was queued to be written.
+func drainService[T any](name string, q *boundedqueue.Queue[T], crashes *crashreport.Reporter, drain func(*sync.WaitGroup)) runmode.Service {
+	var wg sync.WaitGroup
+	return runmode.Func(name,
+		func(context.Context) error {
+			wg.Add(1)
+			crashes.Go(name, func() { drain(&wg) })
+			return nil
+		},
+		func(ctx context.Context) error {
+			q.Close()
+			select {
+			case <-channels.Wait(&wg):
+				return nil
+			case <-ctx.Done():
+				return fmt.Errorf("%d messages not written: %w", q.Len(), ctx.Err())
+			}
+		},
+	)
+}
+
+// accessLogWriter drains access log lines to w, flushing whenever the
+// queue runs dry so a quiet server's lines are not held back.
+func accessLogWriter(q *boundedqueue.Queue[string], w io.Writer, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	bw := bufio.NewWriter(w)
+	for line := range q.All() {
+		bw.WriteString(line)
+		bw.WriteByte('\n')
+		if q.Len() == 0 {
+			bw.Flush()
+		}
 	}
+	bw.Flush()
 }
 
-func (r *InMemoryUserRepo) Create(user User) (User, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+/*
+-----------------------------------
+APPLICATION
+-----------------------------------
+*/
+
+// app is the process's parts. build makes them in steps, each from what
+// the steps before it made, registering whatever has a lifetime as a
+// service with what it needs running first; run checks the process's
+// dependencies, starts the services in that order and stops them in
+// reverse.
+type app struct {
+	cfg    config.Server
+	loaded *config.Loaded
+
+	services   *runmode.Registry
+	supervisor *runmode.Supervisor
+	// checks are what the process depends on outside itself, checked
+	// before anything starts and retried while it comes up.
+	checks []bootstrap.Check
+	// closers run once the services have stopped: the log files.
+	closers []func() error
+
+	logLevel   *slog.LevelVar
+	logLevels  *logfilter.Levels
+	logFilter  *logfilter.Handler
+	reopenLogs []func() error
+	logQueue   *boundedqueue.Queue[logEntry]
+	access     *accesslog.Logger
+	errs       *errortrack.Tracker
+	crashes    *crashreport.Reporter
+	bundle     *assets.Bundle
+
+	// repo is the store as the service sees it, behind any migration
+	// and encryption wrappers; backend is the store itself, for its
+	// optional interfaces.
+	plugs              *plugins.Set
+	repo, backend      users.UserRepository
+	dual               *datamove.DualWriteRepo
+	shadowed           *users.ShadowRepo
+	split              *datamove.SplitRepo
+	encrypted          *users.EncryptedRepo
+	flags              *featureflag.Set
+	auditRing          *audit.Ring
+	jwtKeys, fieldKeys *keyset.Set
+	fields             *fieldcrypt.Codec
 
-	user.ID = r.nextID
-	user.CreatedAt = time.Now()
+	events       *eventbus.Bus
+	hooks        *script.Hooks
+	slow         *slowop.Detector
+	traces       *flightrec.Recorder
+	profiler     *cpuprof.Profiler
+	service      *users.UserService
+	avatars      *avatar.Avatars
+	uploads      *upload.Manager
+	secondFactor *twofactor.Manager
+	external     *oauth.Manager
+	history      *activity.History
+	experiments  *experiment.Service
+	dsr          *privacy.Manager
+	guard        *lockout.Guard
+	merges       *dedupe.Manager
 
-	r.users[user.ID] = user
-	r.nextID++
+	maint        *maintenance.Switch
+	keySets      []*keyset.Set
+	reencrypt    map[string]func(context.Context) (int, error)
+	vacuumJob    *vacuum.Job
+	retentionJob *retention.Job
+}
 
-	return user, nil
+// build makes every part of a, in order.
+func (a *app) build() error {
+	a.services = runmode.NewRegistry(runmode.RegistryOptions{})
+	a.auditRing = audit.NewRing(200, nil)
+	for _, step := range []func() error{
+		a.setupLogging,
+		a.loadAssets,
+		a.openStores,
+		a.loadKeys,
+		a.startEvents,
+		a.buildService,
+		a.buildSubsystems,
+		a.startLogQueues,
+		a.startJobs,
+		a.handleSignals,
+		a.serveHTTP,
+	} {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (r *InMemoryUserRepo) GetByID(id int) (User, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// openLog opens a log file that rotates itself and is reopened on SIGHUP,
+// for when logrotate moves it instead.
+func (a *app) openLog(path string) (*logfile.File, error) {
+	f, err := logfile.Open(logfile.Options{
+		Path: path, MaxSize: a.cfg.LogMaxSize, MaxAge: a.cfg.LogMaxAge, MaxFiles: a.cfg.LogMaxFiles, Compress: a.cfg.LogCompress,
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.reopenLogs = append(a.reopenLogs, f.Reopen)
+	a.closers = append(a.closers, f.Close)
+	return f, nil
+}
 
-	user, ok := r.users[id]
-	if !ok {
-		return User{}, ErrUserNotFound
+// readLevels sets the log levels from spec, as -log-levels writes them.
+func (a *app) readLevels(spec string) error {
+	def, modules, err := logfilter.ParseLevels(spec, slog.LevelInfo)
+	if err != nil {
+		return err
 	}
-	return user, nil
+	a.logLevels.Replace(def, modules)
+	return nil
 }
 
-func (r *InMemoryUserRepo) List() []User {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// setupLogging routes every log line, the log package's too, through one
+// handler, and sets up error tracking and crash reports.
+func (a *app) setupLogging() error {
+	cfg := &a.cfg
+	var logOut io.Writer = os.Stderr
+	if cfg.LogPath != "" {
+		f, err := a.openLog(cfg.LogPath)
+		if err != nil {
+			return err
+		}
+		logOut = f
+	}
+
+	// Keep the last log lines so crash reports show what led up to a panic.
+	// Levels are per module and changeable at runtime: the default is a
+	// LevelVar so SIGUSR2 can switch debug logging on, or re-read
+	// -log-levels-file, and the admin dashboard sets them too. Repeated
+	// messages are sampled.
+	logRing := crashreport.NewLogRing(200)
+	a.logLevel = new(slog.LevelVar)
+	a.logLevels = logfilter.NewLevels(a.logLevel)
+	if err := a.readLevels(cfg.LogLevelSpec); err != nil {
+		return err
+	}
+	if cfg.LogLevelsFile != "" {
+		if b, err := os.ReadFile(cfg.LogLevelsFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		} else if err := a.readLevels(string(b)); err != nil {
+			return err
+		}
+	}
+	var logHandler slog.Handler = slog.NewTextHandler(io.MultiWriter(logOut, logRing), &slog.HandlerOptions{Level: slog.LevelDebug})
+	if cfg.LogFormat == "json" {
+		logHandler = slog.NewJSONHandler(io.MultiWriter(logOut, logRing), &slog.HandlerOptions{Level: slog.LevelDebug})
+	}
+	a.logFilter = logfilter.New(
+		logHandler,
+		logfilter.Options{Levels: a.logLevels, Sampling: logfilter.Sampling{First: cfg.LogSampleFirst, Rate: cfg.LogSampleRate}},
+	)
+	// Every line says which build wrote it; the log package's lines too,
+	// since SetDefault routes them here.
+	slog.SetDefault(slog.New(a.logFilter).With("version", buildinfo.Get().Short()))
 
-	result := make([]User, 0, len(r.users))
-	for _, u := range r.users {
-		result = append(result, u)
+	// Errors answered with a 5xx and panics are grouped, and alerted on
+	// when new or frequent.
+	sinks := []errortrack.Sink{errortrack.Log(nil)}
+	if cfg.AlertWebhook != "" {
+		sinks = append(sinks, &errortrack.Webhook{URL: cfg.AlertWebhook, Client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	if len(cfg.AlertEmail) > 0 {
+		mail := &errortrack.Email{Addr: cfg.SMTPAddr, From: cfg.AlertFrom, To: cfg.AlertEmail}
+		if user := os.Getenv("USERS_SMTP_USER"); user != "" {
+			host, _, _ := strings.Cut(cfg.SMTPAddr, ":")
+			mail.Auth = smtp.PlainAuth("", user, os.Getenv("USERS_SMTP_PASSWORD"), host)
+		}
+		sinks = append(sinks, mail)
+		a.checks = append(a.checks, bootstrap.Dial("smtp", "tcp", cfg.SMTPAddr))
 	}
-	return result
+	a.errs = errortrack.New(errortrack.Options{Sinks: sinks})
+	a.crashes = crashreport.New(crashreport.Options{
+		Dir:  cfg.CrashDir,
+		Logs: logRing,
+		OnCrash: func(where string, value any, path string) {
+			a.errs.Report(&errortrack.Panic{Where: where, Value: value})
+		},
+		// The effective flags, secrets redacted.
+		Config: func() any {
+			flags := map[string]string{}
+			for _, v := range a.loaded.Values() {
+				flags[v.Name] = v.Value
+			}
+			return flags
+		},
+	})
+
+	fmt.Println(AppName, buildinfo.Get())
+	return nil
 }
 
-/*
------------------------------------
-SERVICE LAYER
------------------------------------
-*/
+// loadAssets builds in every file the binary serves; -assets-dir
+// overrides them, a file at a time, while developing. The catalogs are
+// parsed once, so an overridden one is loaded here.
+func (a *app) loadAssets() error {
+	bundle, err := assets.New(map[string]fs.FS{
+		"admin":     admin.Static(),
+		"templates": templates.Files(),
+		"locales":   i18n.Locales(),
+		"geoip":     geoip.TestData(),
+		"seed":      assets.Seed(),
+	}, a.cfg.AssetsDir)
+	if err != nil {
+		return err
+	}
+	a.bundle = bundle
+	if bundle.Overridden("locales") {
+		catalogs, err := i18n.LoadFS(bundle.FS("locales"), ".", i18n.DefaultLocale)
+		if err != nil {
+			return err
+		}
+		i18n.Default = catalogs
+	}
+	return nil
+}
+
+// openStores opens the user store, with a migration's second store if
+// there is one, and the plugins, one of which may be the store.
+func (a *app) openStores() error {
+	cfg := &a.cfg
+	a.plugs = &plugins.Set{}
+	if cfg.PluginsPath != "" {
+		p, err := plugins.Load(cfg.PluginsPath)
+		if err != nil {
+			return err
+		}
+		a.plugs = p
+	}
+	a.services.Register(runmode.Closer("plugins", a.plugs.Close))
+
+	repo, closeRepo, err := openRepo(cfg.Store, cfg.DataPath, a.plugs)
+	if err != nil {
+		return err
+	}
+	a.services.Register(runmode.Closer("repo", closeRepo), "plugins")
+	a.checks = append(a.checks, bootstrap.Store("store", repo))
+	a.backend = repo
+	// The flags are loaded further down, and again on SIGHUP; the split
+	// reads them on every call.
+	a.flags = featureflag.NewSet(nil)
+
+	// During a migration cutover the new store gets every write as well,
+	// and optionally a sample of reads, whose answers are compared with the
+	// old store's. Copy the existing records first (usersctl migrate);
+	// mirroring sits below field encryption, so both stores hold the same
+	// ciphertext.
+	if cfg.ShadowStore != "" {
+		shadow, closeShadow, err := openRepo(cfg.ShadowStore, cfg.ShadowData, a.plugs)
+		if err != nil {
+			return err
+		}
+		a.services.Register(runmode.Closer("shadow-repo", closeShadow), "plugins")
+		target, ok := shadow.(datamove.Target)
+		if !ok {
+			return fmt.Errorf("-shadow-store %s cannot take records with their IDs", cfg.ShadowStore)
+		}
+		a.checks = append(a.checks, bootstrap.Store("shadow-store", shadow), bootstrap.Migrated("shadow-migrated", repo, shadow))
+		a.dual = datamove.DualWrite(repo, target, nil)
+		repo = a.dual
+		if cfg.ShadowReads > 0 {
+			a.shadowed = users.ShadowReads(a.dual, target, users.ShadowOptions{Sample: cfg.ShadowReads})
+			repo = a.shadowed
+		}
+	}
+	// Blue/green runs both stores as peers instead: every write goes to
+	// both, and the repo_green_reads and repo_green_writes flags' rollouts
+	// shift reads and writes to green a share at a time.
+	if cfg.GreenStore != "" {
+		green, closeGreen, err := openRepo(cfg.GreenStore, cfg.GreenData, a.plugs)
+		if err != nil {
+			return err
+		}
+		a.services.Register(runmode.Closer("green-repo", closeGreen), "plugins")
+		blueTarget, ok := repo.(datamove.Target)
+		if !ok {
+			return fmt.Errorf("-store %s cannot take records with their IDs", cfg.Store)
+		}
+		greenTarget, ok := green.(datamove.Target)
+		if !ok {
+			return fmt.Errorf("-green-store %s cannot take records with their IDs", cfg.GreenStore)
+		}
+		a.checks = append(a.checks, bootstrap.Store("green-store", green), bootstrap.Migrated("green-migrated", repo, green))
+		a.split = datamove.Split(blueTarget, greenTarget, datamove.SplitOptions{Flags: a.flags})
+		repo = a.split
+	}
+	a.repo = repo
 
-type UserService struct {
-	repo UserRepository
+	if r, ok := a.backend.(interface{ Problems() []integrity.Problem }); ok {
+		for _, p := range r.Problems() {
+			slog.Warn("quarantined corrupt data", "problem", p.String())
+		}
+	}
+	return a.loadFlags()
 }
 
-func NewUserService(repo UserRepository) *UserService {
-	return &UserService{repo: repo}
+// loadFlags reads -flags-file, if there is one, replacing the flags.
+func (a *app) loadFlags() error {
+	if a.cfg.FlagsPath == "" {
+		return nil
+	}
+	f, err := featureflag.LoadFile(a.cfg.FlagsPath)
+	if err != nil {
+		return err
+	}
+	a.flags.Replace(f)
+	return nil
 }
 
-func (s *UserService) RegisterUser(name, email string) (User, error) {
-	if name == "" || email == "" {
-		return User{}, errors.New("name or email cannot be empty")
+// loadKeys opens the signing and field keys and puts the repo behind
+// field encryption ($USERS_FIELD_KEY). With a key store the keys from the
+// environment are each the first version of a set that rotates; the sets
+// live in the store, sealed with the data keys.
+func (a *app) loadKeys() error {
+	cfg := &a.cfg
+	if cfg.KeyStore != "" {
+		keyBlobs, err := blobstore.Open(cfg.KeyStore)
+		if err != nil {
+			return err
+		}
+		seal, err := atrest.FromEnv()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		a.jwtKeys, err = keyset.Open(ctx, keyset.Options{
+			Name: "jwt", Store: keyBlobs, Seal: seal, Initial: []byte(os.Getenv("USERS_JWT_SECRET")),
+			Every: cfg.JWTKeyRotate, Keep: cfg.JWTKeyKeep, Audit: a.auditRing,
+		})
+		if err != nil {
+			return err
+		}
+		fieldKey, err := fieldcrypt.EnvKey()
+		if err != nil {
+			return err
+		}
+		if fieldKey != nil {
+			a.fieldKeys, err = keyset.Open(ctx, keyset.Options{
+				Name: "fields", Store: keyBlobs, Seal: seal, Initial: fieldKey,
+				Every: cfg.FieldKeyRotate, Audit: a.auditRing,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	var err error
+	if a.fieldKeys != nil {
+		a.fields, err = fieldcrypt.NewRotating(a.fieldKeys)
+	} else {
+		a.fields, err = fieldcrypt.FromEnv()
+	}
+	if err != nil {
+		return err
+	}
+	// encrypted re-encrypts users' fields after a rotation. Two-factor
+	// secrets and provider tokens are resealed when next saved, so field
+	// versions are only dropped by hand.
+	if a.fields != nil {
+		a.encrypted = users.EncryptFields(a.repo, a.fields)
+		a.repo = a.encrypted
 	}
+	return nil
+}
 
-	user := User{
-		Name:  name,
-		Email: email,
+// startEvents makes the event bus and subscribes the plugins and script
+// hooks to it. The bus drains before they go.
+func (a *app) startEvents() error {
+	a.events = eventbus.New()
+	a.services.Register(runmode.Closer("events", func() error {
+		a.events.Close()
+		return nil
+	}), "plugins")
+	if err := a.plugs.Subscribe(a.events); err != nil {
+		return err
 	}
+	a.hooks = script.New(script.Options{})
+	if a.cfg.ScriptsDir != "" {
+		h, err := script.LoadDir(a.cfg.ScriptsDir, script.Options{})
+		if err != nil {
+			return err
+		}
+		a.hooks = h
+	}
+	return a.hooks.Subscribe(a.events)
+}
 
-	return s.repo.Create(user)
+// buildService builds the user service through the container, which
+// gives it whichever of its dependencies it has (see
+// users.ProvideService); a nil one is left out.
+func (a *app) buildService() error {
+	cfg := &a.cfg
+	// Slow calls are reported with their stacks. Only the service sees
+	// the watched repository: the HTTP layer still asserts the backend's
+	// optional interfaces on repo.
+	serviceRepo := a.repo
+	if cfg.SlowOp > 0 {
+		a.slow = slowop.New(slowop.Options{
+			Threshold: cfg.SlowOp,
+			// Exporting every user is expected to take a while.
+			Thresholds: map[string]time.Duration{"users.export": 30 * time.Second, "repo.iterate": 30 * time.Second},
+		})
+		serviceRepo = users.WatchSlowOps(a.repo, a.slow)
+	}
+	if cfg.TraceKeep > 0 {
+		a.traces = flightrec.New(flightrec.Options{Keep: cfg.TraceKeep, Slow: cfg.TraceSlow})
+	}
+	if cfg.ProfileDir != "" {
+		var err error
+		a.profiler, err = cpuprof.New(cpuprof.Options{Dir: cfg.ProfileDir, Keep: cfg.ProfileKeep, CPU: cfg.ProfileCPU, Latency: cfg.ProfileLatency})
+		if err != nil {
+			return err
+		}
+	}
+
+	deps := wiring.New()
+	wiring.Value[users.UserRepository](deps, serviceRepo)
+	wiring.Value[audit.Sink](deps, a.auditRing)
+	wiring.Value(deps, a.flags)
+	wiring.Value(deps, a.events)
+	wiring.Value(deps, a.slow)
+	// A dry run can then tell a registration which ID it would get.
+	if ids, ok := a.backend.(users.IDPeeker); ok {
+		wiring.Value(deps, ids)
+	}
+	wiring.Value(deps, normalize.Options{FoldMailbox: cfg.FoldMailbox, CaseNames: cfg.CaseNames})
+	// Plugin validators run before script hooks.
+	validators := []users.Validator{a.plugs.Validate, a.hooks.Validate}
+	if cfg.CheckMX {
+		validators = append(validators, users.RequireMailServer(emailaddr.NewChecker(emailaddr.CheckerOptions{Timeout: cfg.MXTimeout})))
+	}
+	if cfg.AbusePerIP > 0 {
+		domains := abuse.DefaultDisposable
+		if cfg.DisposableDomains != "" {
+			f, err := os.Open(cfg.DisposableDomains)
+			if err != nil {
+				return err
+			}
+			domains, err = abuse.LoadDomains(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		scorer := abuse.New(abuse.Options{
+			Signals: []abuse.Signal{
+				abuse.DisposableDomains(domains),
+				abuse.Velocity(window.NewKeyed[string](cfg.AbuseWindow, 60, nil), cfg.AbusePerIP),
+				abuse.Honeypot("website"),
+			},
+			Audit: a.auditRing,
+		})
+		validators = append(validators, scorer.Validator())
+	}
+	wiring.Value(deps, validators)
+	wiring.Value(deps, []users.Enricher{users.AccountAge(clock.Real()), users.Gravatar(80), users.DisplayName()})
+	users.ProvideService(deps)
+	var err error
+	a.service, err = wiring.Get[*users.UserService](deps)
+	return err
+}
+
+// openBlobs opens the blob store spec names, checking it at startup
+// unless it is in memory.
+func (a *app) openBlobs(name, spec string) (blobstore.Store, error) {
+	s, err := blobstore.Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	if spec != "mem:" && spec != "memory" {
+		a.checks = append(a.checks, bootstrap.Blob(name, s))
+	}
+	return s, nil
 }
 
-func (s *UserService) GetUser(ctx context.Context, id int) (User, error) {
-	select {
-	case <-ctx.Done():
-		return User{}, ctx.Err()
+// buildSubsystems builds what keeps something about a user besides the
+// store: avatars, uploads, second factors, linked identities and
+// activity. Data-subject requests (export, erasure) and merges cover
+// each of them.
+func (a *app) buildSubsystems() error {
+	cfg := &a.cfg
+	blobs, err := a.openBlobs("avatar-store", cfg.AvatarStore)
+	if err != nil {
+		return err
+	}
+	a.avatars = avatar.New(avatar.Options{Store: blobs})
+	chunks, err := a.openBlobs("upload-store", cfg.UploadStore)
+	if err != nil {
+		return err
+	}
+	a.uploads = upload.New(upload.Options{Store: chunks, TTL: cfg.UploadTTL})
+	if err := a.avatars.Subscribe(a.events); err != nil {
+		return err
+	}
+	factors, err := a.openBlobs("2fa-store", cfg.TwoFactorStore)
+	if err != nil {
+		return err
+	}
+	a.secondFactor = twofactor.New(twofactor.Options{Store: factors, Require: cfg.TwoFactorRoles, Codec: a.fields, Audit: a.auditRing})
+	if err := a.secondFactor.Subscribe(a.events); err != nil {
+		return err
+	}
+	var providers []oauth.ProviderConfig
+	if cfg.OIDCProviders != "" {
+		f, err := os.Open(cfg.OIDCProviders)
+		if err != nil {
+			return err
+		}
+		providers, err = oauth.LoadProviders(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		for i, p := range providers {
+			if p.ClientSecret == "" {
+				providers[i].ClientSecret = os.Getenv("USERS_OIDC_" + strings.ToUpper(strings.ReplaceAll(p.Name, "-", "_")) + "_SECRET")
+			}
+		}
+	}
+	identities, err := a.openBlobs("oidc-store", cfg.OIDCStore)
+	if err != nil {
+		return err
+	}
+	a.external, err = oauth.New(oauth.Options{
+		Providers:   providers,
+		Service:     a.service,
+		Store:       identities,
+		LinkByEmail: cfg.OIDCLinkByEmail,
+		SessionTTL:  cfg.OIDCSession,
+		Codec:       a.fields,
+		Audit:       a.auditRing,
+	})
+	if err != nil {
+		return err
+	}
+	if err := a.external.Subscribe(a.events); err != nil {
+		return err
+	}
+	historyOpts := activity.Options{PerUser: cfg.ActivityKeep}
+	switch cfg.GeoDB {
+	case "":
+	case "test":
+		db, err := geoip.LoadFS(a.bundle.FS("geoip"), "countries.csv")
+		if err != nil {
+			return err
+		}
+		historyOpts.Geo = db
 	default:
-		return s.repo.GetByID(id)
+		db, err := geoip.Open(cfg.GeoDB)
+		if err != nil {
+			return err
+		}
+		historyOpts.Geo = db
+	}
+	a.history = activity.New(historyOpts)
+	if err := a.history.Subscribe(a.events); err != nil {
+		return err
+	}
+	// Experiments are bucketed as flags are, and gated by them; each
+	// exposure goes out on the bus.
+	a.experiments = experiment.New(a.events, a.flags)
+	if cfg.ExperimentsPath != "" {
+		f, err := os.Open(cfg.ExperimentsPath)
+		if err != nil {
+			return err
+		}
+		list, err := experiment.Load(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		for _, e := range list {
+			if err := a.experiments.Define(e); err != nil {
+				return err
+			}
+		}
+	}
+	dsrOpts := privacy.Options{Repo: a.repo, Holders: []privacy.Holder{privacy.AuditTrail(a.auditRing), a.avatars, a.history, a.secondFactor, a.external}, Audit: a.auditRing}
+	if mem, ok := a.backend.(*users.InMemoryUserRepo); ok {
+		dsrOpts.Holders = append(dsrOpts.Holders, privacy.ChangeLog(mem))
 	}
+	if p, ok := a.backend.(privacy.Purger); ok {
+		dsrOpts.Purge = p
+	}
+	a.dsr = privacy.New(dsrOpts)
+	a.guard = lockout.New(lockout.Options{
+		MaxFailures:   cfg.LockFailures,
+		MaxIPFailures: cfg.LockIPFailures,
+		LockFor:       cfg.LockFor,
+		HalfLife:      cfg.LockHalfLife,
+		Audit:         a.auditRing,
+		Events:        a.events,
+	})
+	a.merges = dedupe.New(dedupe.Options{Repo: a.repo, Reassigners: []dedupe.Reassigner{a.avatars, a.history, a.external}, Audit: a.auditRing, Events: a.events})
+	return nil
 }
 
-/*
------------------------------------
-UTILITY FUNCTIONS
------------------------------------
-*/
+// startLogQueues starts the async logger and, with -access-log, the
+// access log's writer.
+func (a *app) startLogQueues() error {
+	cfg := &a.cfg
+	// Bounded queue & goroutine
+	a.logQueue = boundedqueue.New(boundedqueue.Options[logEntry]{
+		Capacity:      64,
+		Policy:        boundedqueue.DropOldest,
+		HighWatermark: 48,
+		LowWatermark:  16,
+		OnHigh: func(depth int) {
+			log.Println("async logger falling behind, queue depth:", depth)
+		},
+	})
+	a.services.Register(drainService("async-logger", a.logQueue, a.crashes, func(wg *sync.WaitGroup) { asyncLogger(a.logQueue, wg) }))
 
-// Variadic function
-func Sum(nums ...int) int {
-	total := 0
-	for _, n := range nums {
-		total += n
+	// The access log has a queue of its own: it is far busier, and a
+	// line it cannot take is dropped (and counted) rather than pushing
+	// out the application's messages.
+	if cfg.AccessLogPath == "" {
+		return nil
 	}
-	return total
+	format, err := accesslog.ParseFormat(cfg.AccessLogFormat)
+	if err != nil {
+		return err
+	}
+	samples, err := accesslog.ParseSamples(cfg.AccessLogSample)
+	if err != nil {
+		return err
+	}
+	var out io.Writer = os.Stdout
+	if cfg.AccessLogPath != "-" {
+		f, err := a.openLog(cfg.AccessLogPath)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+	accessQueue := boundedqueue.New(boundedqueue.Options[string]{Capacity: 4096, Policy: boundedqueue.Reject})
+	a.services.Register(drainService("access-log", accessQueue, a.crashes, func(wg *sync.WaitGroup) { accessLogWriter(accessQueue, out, wg) }))
+	a.access = accesslog.New(accesslog.Options{Format: format, Sink: accessQueue.TryEnqueue, Samples: samples})
+	return nil
 }
 
-/*
------------------------------------
-GOROUTINES & CHANNELS
------------------------------------
-*/
+// startJobs puts the background jobs under a supervisor, which restarts
+// them with backoff if they crash. Maintenance refuses the API's writes
+// and pauses the jobs that write: expiry, key rotation, vacuum and
+// retention.
+func (a *app) startJobs() error {
+	cfg := &a.cfg
+	a.maint = maintenance.New(maintenance.Options{Audit: a.auditRing})
+	if cfg.MaintenanceReason != "" {
+		a.maint.Enable(context.Background(), "", cfg.MaintenanceReason, 0)
+	}
 
-func asyncLogger(ch <-chan string, wg *sync.WaitGroup) {
-	defer wg.Done()
+	supervisor := runmode.NewSupervisor(runmode.SupervisorOptions{Crash: a.crashes})
+	a.supervisor = supervisor
+	if mem, ok := a.backend.(*users.InMemoryUserRepo); ok {
+		supervisor.Add("user-expiry", a.maint.Pausable("user-expiry", func(ctx context.Context) error {
+			mem.RunExpiry(ctx)
+			return nil
+		}))
+	}
+	supervisor.Add("error-alerts", func(ctx context.Context) error {
+		a.errs.Run(ctx)
+		return nil
+	})
+	supervisor.Add("log-sampling", func(ctx context.Context) error {
+		a.logFilter.Run(ctx)
+		return nil
+	})
+	if a.profiler != nil {
+		supervisor.Add("cpu-profiler", a.profiler.Run)
+	}
+	for _, set := range []*keyset.Set{a.jwtKeys, a.fieldKeys} {
+		if set != nil {
+			a.keySets = append(a.keySets, set)
+			supervisor.Add(set.Name()+"-keys", a.maint.Pausable(set.Name()+"-keys", set.Run))
+		}
+	}
+	a.reencrypt = map[string]func(context.Context) (int, error){}
+	if a.encrypted != nil {
+		a.reencrypt["fields"] = a.encrypted.Reencrypt
+	}
+	supervisor.Add("upload-expiry", func(ctx context.Context) error {
+		a.uploads.Run(ctx)
+		return nil
+	})
+	a.vacuumJob = vacuum.NewJob(vacuum.JobOptions{
+		Interval: cfg.VacuumEvery,
+		Throttle: vacuum.NewThrottle(cfg.VacuumRate, nil),
+	})
+	if t, ok := a.backend.(vacuum.Target); ok {
+		a.vacuumJob.Add(cfg.Store, t)
+		supervisor.Add("vacuum", a.maint.Pausable("vacuum", a.vacuumJob.Run))
+	}
+	var rules []retention.Rule
+	if cfg.RetainAudit > 0 {
+		rules = append(rules, retention.AuditOlderThan(a.auditRing, cfg.RetainAudit))
+	}
+	if cfg.AnonymizeAfter > 0 {
+		rules = append(rules, retention.AnonymizeInactive(a.repo, a.dsr, cfg.AnonymizeAfter, a.history.LastActive))
+	}
+	a.retentionJob = retention.NewJob(retention.JobOptions{Rules: rules, DryRun: cfg.RetentionDryRun})
+	if len(rules) > 0 {
+		supervisor.Add("retention", a.maint.Pausable("retention", a.retentionJob.Run))
+	}
+	a.services.Register(supervisor, "repo", "events")
+	return nil
+}
 
-	for msg := range ch {
-		log.Println("ASYNC LOG:", msg)
+// handleSignals has kill -USR1 dump goroutines and stats, -USR2 toggle
+// debug logging (or re-read -log-levels-file), and -HUP reopen the log
+// files and reload the feature flag file.
+func (a *app) handleSignals() error {
+	var reloadLevels func() (string, error)
+	if a.cfg.LogLevelsFile != "" {
+		reloadLevels = func() (string, error) {
+			b, err := os.ReadFile(a.cfg.LogLevelsFile)
+			if err == nil {
+				err = a.readLevels(string(b))
+			}
+			return a.logLevels.String(), err
+		}
 	}
+	sigOpts := sigctl.Options{
+		Level:        a.logLevel,
+		Reload:       a.loadFlags,
+		ReloadLevels: reloadLevels,
+		Reopen: func() error {
+			var errs []error
+			for _, reopen := range a.reopenLogs {
+				errs = append(errs, reopen())
+			}
+			return errors.Join(errs...)
+		},
+		Stats: a.stats,
+	}
+	sigCtx, stopSignals := context.WithCancel(context.Background())
+	a.services.Register(runmode.Func("signals",
+		func(context.Context) error {
+			sigctl.Start(sigCtx, sigOpts)
+			return nil
+		},
+		func(context.Context) error {
+			stopSignals()
+			return nil
+		},
+	))
+	return nil
 }
 
-/*
------------------------------------
-MAIN FUNCTION
------------------------------------
-*/
+// stats is what kill -USR1 prints.
+func (a *app) stats() map[string]any {
+	count, _ := users.Count(context.Background(), a.repo, query.All())
+	stats := map[string]any{
+		"users":           count,
+		"log_queue_depth": a.logQueue.Len(),
+		"crashes":         a.crashes.Crashes(),
+		"services":        a.services.Stats(),
+		"subsystems":      a.supervisor.Stats(),
+		"vacuum":          a.vacuumJob.Stats(),
+		"retention":       a.retentionJob.Stats(),
+		"log_sampling":    a.logFilter.Stats(),
+		"errors":          a.errs.Stats(),
+		"slow_ops":        a.slow.Stats(),
+		"traces":          a.traces.Stats(),
+		"cpu_profiles":    a.profiler.Stats(),
+	}
+	if a.access != nil {
+		stats["access_log"] = a.access.Stats()
+	}
+	if a.dual != nil {
+		stats["dual_write"] = a.dual.Stats()
+	}
+	if a.shadowed != nil {
+		stats["shadow_reads"] = a.shadowed.Stats()
+	}
+	if a.split != nil {
+		stats["blue_green"] = a.split.Stats()
+	}
+	if p := a.plugs.Stats(); len(p) > 0 {
+		stats["plugins"] = p
+	}
+	if h := a.hooks.Stats(); len(h) > 0 {
+		stats["scripts"] = h
+	}
+	return stats
+}
 
-func main() {
-	fmt.Println(AppName, "v"+appVersion)
+// serveHTTP registers the HTTP server with -http, and what only it uses:
+// sessions, service accounts, impersonation and approvals.
+func (a *app) serveHTTP() error {
+	cfg := &a.cfg
+	if cfg.HTTPAddr == "" {
+		return nil
+	}
+	signer, err := jwtSigner(a.jwtKeys)
+	if err != nil {
+		return err
+	}
+	sessions := session.New(session.Options{Signer: signer, AccessTTL: cfg.AccessTTL, RefreshTTL: cfg.RefreshTTL, Audit: a.auditRing})
+	if err := sessions.Subscribe(a.events); err != nil {
+		return err
+	}
+	a.supervisor.Add("session-expiry", func(ctx context.Context) error {
+		sessions.Run(ctx)
+		return nil
+	})
+	// Destructive operations wait for a second admin, who is paged
+	// through the log and the webhook.
+	var approvals *approval.Manager
+	if cfg.ApprovalTTL > 0 {
+		notify := []approval.Notifier{approval.Log(nil)}
+		if cfg.ApprovalWebhook != "" {
+			notify = append(notify, &approval.Webhook{URL: cfg.ApprovalWebhook, Client: &http.Client{Timeout: 10 * time.Second}})
+		}
+		approvals = approval.New(approval.Options{TTL: cfg.ApprovalTTL, Notify: notify, Audit: a.auditRing})
+		a.supervisor.Add("approval-expiry", func(ctx context.Context) error {
+			approvals.Run(ctx)
+			return nil
+		})
+	}
+	restore, err := backupRestore(cfg.BackupDir, a.backend)
+	if err != nil {
+		return err
+	}
+	signatures, err := peerVerifier(cfg.PeerKeys, cfg.RequireSigned)
+	if err != nil {
+		return err
+	}
+	security := httpsec.Options{
+		CORS:    httpsec.CORS{Credentials: cfg.CORSCredentials},
+		Headers: httpsec.Headers{HSTS: cfg.HSTS},
+		MaxBody: cfg.MaxBody,
+	}
+	if cfg.HSTS == 0 {
+		security.Headers.HSTS = -1
+	}
+	if len(cfg.CORSOrigins) > 0 {
+		security.CORS.Origins = cfg.CORSOrigins
+	}
+	timeouts := httpsec.DefaultTimeouts
+	timeouts.ReadHeader, timeouts.Read, timeouts.Idle = cfg.ReadHeaderTimeout, cfg.ReadTimeout, cfg.IdleTimeout
+	srv, err := httpService(httpDeps{
+		Addr:         cfg.HTTPAddr,
+		Timeout:      cfg.RequestTimeout,
+		Security:     security,
+		Timeouts:     timeouts,
+		DryRun:       cfg.DryRun,
+		DownloadRate: cfg.DownloadRate,
+		ConnRate:     cfg.ConnRate,
+		Service:      a.service,
+		Repo:         a.repo,
+		Signer:       signer,
+		Restore:      restore,
+		Reencrypt:    a.reencrypt,
+		KeySets:      a.keySets,
+		Sessions:     sessions,
+		// Service accounts and impersonations live in memory, like the
+		// catalogue: a restart forgets them, though impersonation tokens
+		// last until they expire.
+		Machines:       serviceaccount.New(serviceaccount.Options{Signer: signer, TokenTTL: cfg.ServiceTokenTTL, Revoker: sessions, Audit: a.auditRing}),
+		Impersonations: impersonate.New(impersonate.Options{Signer: signer, MaxTTL: cfg.ImpersonationTTL, Revoker: sessions, Audit: a.auditRing}),
+		Approvals:      approvals,
+		Maintenance:    a.maint,
+		Signatures:     signatures,
+		Audit:          a.auditRing,
+		Privacy:        a.dsr,
+		Merges:         a.merges,
+		History:        a.history,
+		Experiments:    a.experiments,
+		Lockout:        a.guard,
+		TwoFactor:      a.secondFactor,
+		OAuth:          a.external,
+		Avatars:        a.avatars,
+		Uploads:        a.uploads,
+		AccessLog:      a.access,
+		LogLevels:      a.logLevels,
+		LogQueue:       a.logQueue,
+		Errors:         a.errs,
+		SlowOps:        a.slow,
+		Traces:         a.traces,
+		Profiler:       a.profiler,
+		Crashes:        a.crashes,
+		Assets:         a.bundle,
+	})
+	if err != nil {
+		return err
+	}
+	deps := []string{"repo", "events", "supervisor", "async-logger"}
+	if a.access != nil {
+		deps = append(deps, "access-log")
+	}
+	a.services.Register(srv, deps...)
+	return nil
+}
 
-	// Context with timeout (very common in backend)
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
+// run checks the process's dependencies, starts the services, runs the
+// demo and, with -http, serves until interrupted; then it stops them.
+// With -check-only it prints the checks and stops there.
+func (a *app) run() error {
+	interrupted, stopInterrupt := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stopInterrupt()
+	report, err := bootstrap.Run(interrupted, a.checks, bootstrap.Options{Attempts: a.cfg.StartupAttempts, Backoff: a.cfg.StartupBackoff})
+	if a.cfg.CheckOnly {
+		printReport(os.Stdout, report)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	startCtx, cancelStart := context.WithTimeout(context.Background(), 30*time.Second)
+	err = a.services.Start(startCtx)
+	cancelStart()
+	if err != nil {
+		return err
+	}
 
-	repo := NewInMemoryUserRepo()
-	service := NewUserService(repo)
+	a.demo()
+	if a.cfg.HTTPAddr != "" {
+		<-interrupted.Done()
+	}
 
-	// Channel & goroutine
-	logChannel := make(chan string)
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go asyncLogger(logChannel, &wg)
+	// Queued log messages are still drained.
+	stopCtx, cancelStop := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelStop()
+	if err := a.services.Stop(stopCtx); err != nil {
+		log.Println(err)
+	}
+	if st := a.logQueue.Stats(); st.Dropped > 0 {
+		log.Println("async logger dropped messages:", st.Dropped)
+	}
+	fmt.Println("Program finished cleanly")
+	return nil
+}
+
+// close closes what outlives the services: the log files.
+func (a *app) close() {
+	for _, c := range a.closers {
+		c()
+	}
+}
+
+// demo registers the seed users and prints them.
+func (a *app) demo() {
+	// Context with timeout (very common in backend), from here: startup
+	// has its own, and may take longer.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
 
 	// Create users
-	users := []struct {
-		name  string
-		email string
-	}{
-		{"Gaurav", "gaurav@example.com"},
-		{"Amit", "amit@example.com"},
+	var users []struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if b, err := fs.ReadFile(a.bundle.FS("seed"), "users.json"); err != nil {
+		log.Fatal(err)
+	} else if err := json.Unmarshal(b, &users); err != nil {
+		log.Fatalf("seed users.json: %v", err)
 	}
 
 	for _, u := range users {
-		user, err := service.RegisterUser(u.name, u.email)
+		user, err := a.service.RegisterUser(ctx, u.Name, u.Email)
 		if err != nil {
 			log.Println("Error:", err)
 			continue
 		}
 
-		logChannel <- fmt.Sprintf("User created: %+v", user)
+		slog.Debug("registered user", "id", user.ID, "email", user.Email)
+		logAsync(ctx, a.logQueue, fmt.Sprintf("User created: %+v", user))
 	}
 
 	// Get user
-	user, err := service.GetUser(ctx, 1)
+	user, err := a.service.GetUser(ctx, 1)
 	if err != nil {
 		log.Println("Get user error:", err)
 	} else {
@@ -218,7 +1455,7 @@ func main() {
 	}
 
 	// JSON marshal
-	jsonData, err := json.MarshalIndent(repo.List(), "", "  ")
+	jsonData, err := json.MarshalIndent(a.repo.List(), "", "  ")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -227,10 +1464,50 @@ func main() {
 
 	// Use utility function
 	fmt.Println("Sum result:", Sum(1, 2, 3, 4, 5))
+}
 
-	// Close channel & wait
-	close(logChannel)
-	wg.Wait()
+/*
+-----------------------------------
+MAIN FUNCTION
+-----------------------------------
+*/
 
-	fmt.Println("Program finished cleanly")
+func main() {
+	var cfg config.Server
+	cfg.Define(flag.CommandLine)
+	// Flags can also come from the environment, a -config file or the
+	// -env profile; loaded knows which came from where.
+	loaded, err := config.Parse(flag.CommandLine, os.Args[1:], config.ServerOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.Daemon && !cfg.CheckOnly {
+		child, err := runmode.Daemonize(cfg.LogFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !child {
+			return
+		}
+	}
+	if cfg.PIDPath != "" && !cfg.CheckOnly {
+		pid, err := runmode.AcquirePIDFile(cfg.PIDPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer pid.Release()
+	}
+
+	a := &app{cfg: cfg, loaded: loaded}
+	defer a.close()
+	if err := a.build(); err != nil {
+		log.Fatal(err)
+	}
+	if err := a.run(); err != nil {
+		log.Fatal(err)
+	}
 }
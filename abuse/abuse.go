@@ -0,0 +1,206 @@
+// Package abuse scores registrations for how likely they are to be a bot
+// or a spammer, and decides what to do about each: let it through, let
+// it through flagged, ask the client to verify, or refuse it.
+//
+// A score is the weighted sum of Signals, each between 0 and 1: a
+// disposable email domain, a burst of registrations from one address, a
+// honeypot field filled in. The Scorer compares it with its thresholds.
+// Only registrations a transport marks with WithClient are scored, so
+// admin imports and command-line tools are left alone; Validator plugs
+// the Scorer into the service.
+package abuse
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"Go-Internals/audit"
+	"Go-Internals/ctxutil"
+	"Go-Internals/dryrun"
+	"Go-Internals/emailaddr"
+	"Go-Internals/i18n"
+	"Go-Internals/users"
+)
+
+// ErrVerificationRequired refuses a registration that scored high enough
+// to need a verified client; one who has passed a challenge, or signed
+// in, can retry.
+var ErrVerificationRequired = errors.New("abuse: verification required")
+
+// Client is what a transport knows of who is registering.
+type Client struct {
+	// IP is the client's address, "" if unknown.
+	IP string
+	// Honeypot holds the fields a form hides from people, by name: bots
+	// fill them in.
+	Honeypot map[string]string
+	// Verified is set by the transport for a client who has passed a
+	// challenge or signed in; such a client is not asked to verify.
+	Verified bool
+}
+
+type clientKey struct{}
+
+// WithClient marks ctx as carrying a registration from c, to be scored.
+func WithClient(ctx context.Context, c Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// ClientFrom is the Client WithClient put in ctx.
+func ClientFrom(ctx context.Context) (Client, bool) {
+	c, ok := ctx.Value(clientKey{}).(Client)
+	return c, ok
+}
+
+// Registration is what Signals score.
+type Registration struct {
+	Name  string
+	Email string
+	// Domain is the email's domain, in Unicode; "" if it did not parse.
+	Domain string
+	Client Client
+}
+
+// Signal is one thing that makes a registration suspect.
+type Signal struct {
+	// Name identifies the signal in verdicts and the audit trail.
+	Name string
+	// Weight scales Score in the sum; 0 is 1.
+	Weight float64
+	// Score is 0 if the registration shows nothing of the signal, up to
+	// 1 if it could not show more.
+	Score func(ctx context.Context, r Registration) float64
+}
+
+// Action is what a verdict does with the registration.
+type Action string
+
+const (
+	Allow  Action = "allow"
+	Flag   Action = "flag"
+	Verify Action = "verify"
+	Reject Action = "reject"
+)
+
+// Verdict is a registration's score and the action it leads to.
+type Verdict struct {
+	Score  float64 `json:"score"`
+	Action Action  `json:"action"`
+	// Signals are the signals that scored, with their weighted scores.
+	Signals map[string]float64 `json:"signals,omitempty"`
+}
+
+// Options configures New. A registration scoring at least a threshold
+// gets its action; a zero threshold is its default.
+type Options struct {
+	Signals []Signal
+	// Flag defaults to 0.3, Verify to 0.6 and Reject to 0.9.
+	Flag, Verify, Reject float64
+	// Audit records every verdict but Allow, as abuse.flag, abuse.verify
+	// or abuse.reject, with the client's IP, the email's domain and the
+	// signals. Default audit.Discard.
+	Audit audit.Sink
+}
+
+type Scorer struct {
+	opts Options
+}
+
+func New(opts Options) *Scorer {
+	if opts.Flag <= 0 {
+		opts.Flag = 0.3
+	}
+	if opts.Verify <= 0 {
+		opts.Verify = 0.6
+	}
+	if opts.Reject <= 0 {
+		opts.Reject = 0.9
+	}
+	if opts.Audit == nil {
+		opts.Audit = audit.Discard
+	}
+	return &Scorer{opts: opts}
+}
+
+// Check scores r and decides its action. The score is capped at 1.
+func (s *Scorer) Check(ctx context.Context, r Registration) Verdict {
+	if r.Domain == "" {
+		if a, err := emailaddr.Parse(r.Email); err == nil {
+			r.Domain = a.Domain
+		}
+	}
+	var v Verdict
+	for _, sig := range s.opts.Signals {
+		w := sig.Weight
+		if w == 0 {
+			w = 1
+		}
+		if score := w * sig.Score(ctx, r); score > 0 {
+			if v.Signals == nil {
+				v.Signals = make(map[string]float64)
+			}
+			v.Signals[sig.Name] += score
+			v.Score += score
+		}
+	}
+	v.Score = min(v.Score, 1)
+	switch {
+	case v.Score >= s.opts.Reject:
+		v.Action = Reject
+	case v.Score >= s.opts.Verify:
+		v.Action = Verify
+	case v.Score >= s.opts.Flag:
+		v.Action = Flag
+	default:
+		v.Action = Allow
+	}
+	if v.Action != Allow {
+		s.record(ctx, r, v)
+	}
+	return v
+}
+
+// Validator scores registrations (users without an ID yet) whose context
+// carries a Client. Reject refuses them as ErrInvalidInput; Verify
+// refuses an unverified client with ErrVerificationRequired; Flag lets
+// them through, on the audit trail.
+func (s *Scorer) Validator() users.Validator {
+	return func(ctx context.Context, u users.User) error {
+		c, ok := ClientFrom(ctx)
+		if !ok || u.ID != 0 {
+			return nil
+		}
+		switch s.Check(ctx, Registration{Name: u.Name, Email: u.Email, Client: c}).Action {
+		case Reject:
+			return users.Reject("it looks automated")
+		case Verify:
+			if !c.Verified {
+				return i18n.Wrap(ErrVerificationRequired, "abuse.verification_required", nil)
+			}
+		}
+		return nil
+	}
+}
+
+// record is best effort: the verdict stands either way.
+func (s *Scorer) record(ctx context.Context, r Registration, v Verdict) {
+	meta := map[string]string{
+		"ip":     r.Client.IP,
+		"domain": r.Domain,
+		"score":  strconv.FormatFloat(v.Score, 'f', 2, 64),
+	}
+	for name, score := range v.Signals {
+		meta["signal."+name] = strconv.FormatFloat(score, 'f', 2, 64)
+	}
+	sink := s.opts.Audit
+	if p := dryrun.From(ctx); p != nil {
+		sink = p
+	}
+	_ = sink.Record(ctx, audit.Entry{
+		Actor:    ctxutil.UserID(ctx),
+		Action:   "abuse." + string(v.Action),
+		Resource: "registration",
+		Meta:     meta,
+	})
+}
@@ -0,0 +1,116 @@
+package abuse
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"Go-Internals/dryrun"
+	"Go-Internals/window"
+)
+
+/*
+-----------------------------------
+SIGNALS
+-----------------------------------
+*/
+
+// DefaultDisposable are well-known throwaway mail services, for
+// DisposableDomains; a deployment with a maintained list loads it with
+// LoadDomains instead.
+var DefaultDisposable = []string{
+	"10minutemail.com", "dispostable.com", "fakeinbox.com", "getnada.com",
+	"guerrillamail.com", "mailinator.com", "maildrop.cc", "mintemail.com",
+	"mohmal.com", "sharklasers.com", "temp-mail.org", "tempmail.com",
+	"throwawaymail.com", "trashmail.com", "yopmail.com",
+}
+
+// DisposableDomains scores 1 for an email at one of domains or under
+// one: with "mailinator.com" listed, "x.mailinator.com" counts too. Its
+// weight is 0.7, which at the default thresholds asks the client to
+// verify rather than refusing it: people use these too.
+func DisposableDomains(domains []string) Signal {
+	set := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		set[strings.ToLower(strings.TrimSpace(d))] = true
+	}
+	return Signal{Name: "disposable_domain", Weight: 0.7, Score: func(_ context.Context, r Registration) float64 {
+		for d := r.Domain; d != ""; {
+			if set[d] {
+				return 1
+			}
+			_, parent, ok := strings.Cut(d, ".")
+			if !ok {
+				break
+			}
+			d = parent
+		}
+		return 0
+	}}
+}
+
+// LoadDomains reads a domain list, one per line; blank lines and lines
+// starting with # are skipped.
+func LoadDomains(r io.Reader) ([]string, error) {
+	var out []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			out = append(out, line)
+		}
+	}
+	return out, sc.Err()
+}
+
+// Velocity counts registrations per client address in perIP's window and
+// scores the count over limit: 1 from the limit-th registration on. An
+// IPv6 client is counted by its /64, which one host usually gets whole.
+// It prunes perIP every 1024 registrations. A dry run (package dryrun)
+// is scored but not counted.
+func Velocity(perIP *window.Keyed[string], limit int) Signal {
+	var seen atomic.Uint64
+	return Signal{Name: "velocity", Score: func(ctx context.Context, r Registration) float64 {
+		key := velocityKey(r.Client.IP)
+		if key == "" || limit <= 0 {
+			return 0
+		}
+		var n uint64
+		if dryrun.Enabled(ctx) {
+			n = perIP.Sum(key) + 1
+		} else {
+			n = perIP.Add(key, 1)
+		}
+		if seen.Add(1)%1024 == 0 {
+			perIP.Prune()
+		}
+		return min(float64(n)/float64(limit), 1)
+	}}
+}
+
+// velocityKey is what Velocity counts ip under.
+func velocityKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	if addr = addr.Unmap(); addr.Is6() {
+		p, _ := addr.Prefix(64)
+		return p.String()
+	}
+	return addr.String()
+}
+
+// Honeypot scores 1 if any of fields came filled in.
+func Honeypot(fields ...string) Signal {
+	return Signal{Name: "honeypot", Score: func(_ context.Context, r Registration) float64 {
+		for _, f := range fields {
+			if strings.TrimSpace(r.Client.Honeypot[f]) != "" {
+				return 1
+			}
+		}
+		return 0
+	}}
+}
@@ -0,0 +1,257 @@
+// Package accesslog writes one line per HTTP request: who asked for what,
+// the answer's status and size, and how long it took.
+//
+// Lines come in the Common Log Format, which every log tool reads, or as
+// JSON objects for structured pipelines. Either way a line is handed to
+// a Sink (usually a boundedqueue's TryEnqueue) rather than written in
+// the request's goroutine, so a slow disk never slows a response; what
+// the sink refuses is counted, not waited for.
+//
+// Paths hit constantly, such as health checks, can be sampled: one line
+// in N. Errors and slow requests are always logged, sampled or not.
+//
+// The middleware runs outermost, to see the final status and the bytes
+// actually sent, but who the caller is is only known further in, once
+// the token has been checked. SetUser and SetTenant record it from there
+// into the request's entry.
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"Go-Internals/clock"
+	"Go-Internals/reqid"
+)
+
+// Format is how an entry is written.
+type Format int
+
+const (
+	// Common is the Common Log Format with the latency in seconds
+	// appended:
+	//
+	//	203.0.113.9 - alice [14/Oct/2026:17:10:41 +0000] "GET /users/1 HTTP/1.1" 200 112 0.000412
+	Common Format = iota
+	// JSON is one Entry object per line.
+	JSON
+)
+
+// ParseFormat reads a format name, "common" or "json".
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "common", "clf":
+		return Common, nil
+	case "json":
+		return JSON, nil
+	}
+	return 0, fmt.Errorf("accesslog: unknown format %q (want common or json)", s)
+}
+
+// Sample thins out the lines for one path prefix.
+type Sample struct {
+	Prefix string
+	// Every logs one request in Every; 0 or 1 logs them all.
+	Every int
+}
+
+// ParseSamples reads "/healthz=100,/metrics=10": log one request in 100
+// to paths under /healthz, one in 10 under /metrics.
+func ParseSamples(spec string) ([]Sample, error) {
+	var out []Sample
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		prefix, every, ok := strings.Cut(item, "=")
+		n, err := strconv.Atoi(every)
+		if !ok || err != nil || n < 1 {
+			return nil, fmt.Errorf("accesslog: sample %q: want prefix=N", item)
+		}
+		out = append(out, Sample{Prefix: prefix, Every: n})
+	}
+	return out, nil
+}
+
+// Options configures New.
+type Options struct {
+	Format Format
+	// Sink takes each line (without a newline). It must not block; a
+	// false return counts the line as dropped.
+	Sink func(line string) bool
+	// Samples apply to successful requests faster than Slow, the first
+	// matching prefix winning.
+	Samples []Sample
+	// Slow is the latency from which a request is always logged; default
+	// one second.
+	Slow  time.Duration
+	Clock clock.Clock
+}
+
+// Entry is one request.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Remote    string    `json:"remote"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	LatencyMS float64   `json:"latency_ms"`
+	User      string    `json:"user,omitempty"`
+	Tenant    string    `json:"tenant,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Referer   string    `json:"referer,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// Logger is safe for concurrent use.
+type Logger struct {
+	opts    Options
+	clk     clock.Clock
+	counts  []atomic.Uint64 // per Samples rule
+	logged  atomic.Int64
+	sampled atomic.Int64
+	dropped atomic.Int64
+}
+
+func New(opts Options) *Logger {
+	if opts.Slow <= 0 {
+		opts.Slow = time.Second
+	}
+	return &Logger{opts: opts, clk: clock.OrReal(opts.Clock), counts: make([]atomic.Uint64, len(opts.Samples))}
+}
+
+// Stats counts lines handed to the sink, skipped by sampling and
+// refused by the sink.
+type Stats struct {
+	Logged  int64 `json:"logged"`
+	Sampled int64 `json:"sampled_out"`
+	Dropped int64 `json:"dropped"`
+}
+
+func (l *Logger) Stats() Stats {
+	return Stats{Logged: l.logged.Load(), Sampled: l.sampled.Load(), Dropped: l.dropped.Load()}
+}
+
+type entryKey struct{}
+
+// SetUser records who made the request, for its line.
+func SetUser(ctx context.Context, user string) {
+	if e, ok := ctx.Value(entryKey{}).(*Entry); ok {
+		e.User = user
+	}
+}
+
+// SetTenant records the request's tenant, for its line.
+func SetTenant(ctx context.Context, tenant string) {
+	if e, ok := ctx.Value(entryKey{}).(*Entry); ok {
+		e.Tenant = tenant
+	}
+}
+
+// Middleware logs every request through next once it has been answered.
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := l.clk.Now()
+		e := &Entry{
+			Time: start, Remote: r.RemoteAddr, Method: r.Method, Path: r.URL.RequestURI(), Proto: r.Proto,
+			UserAgent: r.UserAgent(), Referer: r.Referer(), RequestID: reqid.From(r.Context()),
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			e.Remote = host
+		}
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			e.Status, e.Bytes = rw.status, rw.bytes
+			if e.Status == 0 {
+				// Nothing written: net/http answers 200, unless the
+				// handler panicked and the connection is dropped.
+				e.Status = http.StatusOK
+			}
+			latency := l.clk.Now().Sub(start)
+			e.LatencyMS = float64(latency.Microseconds()) / 1000
+			l.log(e, r.URL.Path, latency)
+		}()
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), entryKey{}, e)))
+	})
+}
+
+func (l *Logger) log(e *Entry, path string, latency time.Duration) {
+	if e.Status < 400 && latency < l.opts.Slow {
+		for i, s := range l.opts.Samples {
+			if !strings.HasPrefix(path, s.Prefix) {
+				continue
+			}
+			if s.Every > 1 && l.counts[i].Add(1)%uint64(s.Every) != 1 {
+				l.sampled.Add(1)
+				return
+			}
+			break
+		}
+	}
+	if l.opts.Sink == nil {
+		return
+	}
+	if l.opts.Sink(l.format(e)) {
+		l.logged.Add(1)
+	} else {
+		l.dropped.Add(1)
+	}
+}
+
+func (l *Logger) format(e *Entry) string {
+	if l.opts.Format == JSON {
+		b, _ := json.Marshal(e)
+		return string(b)
+	}
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Sprintf("%s - %s [%s] %q %d %s %.6f",
+		e.Remote, dash(e.User), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+e.Path+" "+e.Proto, e.Status, bytes, e.LatencyMS/1000)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	// CLF fields are space-separated; a subject with spaces would shift
+	// every field after it.
+	return strings.ReplaceAll(s, " ", "_")
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *responseWriter) Flush() { _ = http.NewResponseController(w.ResponseWriter).Flush() }
+
+func (w *responseWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,274 @@
+// Package activity keeps each user's access history: when they
+// registered and signed in, from which address, with which user agent,
+// and from which country that address is in.
+//
+// A transport puts what it knows of a request in the context with
+// WithRequest; Record takes it from there and resolves the country with
+// the History's geoip.Resolver. The history is a table of the latest
+// events per user, in memory, so it is as old as the process at most.
+//
+// History is a privacy.Holder, so archives include it and erasure clears
+// it, and a dedupe.Reassigner, so a merge keeps both users' events; its
+// LastActive is a retention.LastActiveFunc.
+package activity
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"Go-Internals/clock"
+	"Go-Internals/eventbus"
+	"Go-Internals/geoip"
+	"Go-Internals/users"
+)
+
+// Kind is what a user did.
+type Kind string
+
+const (
+	Registration Kind = "registration"
+	Login        Kind = "login"
+)
+
+// Event is one entry of a user's history.
+type Event struct {
+	UserID    int       `json:"user_id"`
+	Kind      Kind      `json:"kind"`
+	At        time.Time `json:"at"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// Country is the ISO 3166-1 alpha-2 code of where IP is, "" if the
+	// resolver does not know.
+	Country string `json:"country,omitempty"`
+}
+
+// Request is what a transport knows of the request an event comes from.
+type Request struct {
+	IP        string
+	UserAgent string
+}
+
+type requestKey struct{}
+
+// WithRequest attaches r to ctx, for Record.
+func WithRequest(ctx context.Context, r Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, r)
+}
+
+// RequestFrom is the Request WithRequest put in ctx.
+func RequestFrom(ctx context.Context) (Request, bool) {
+	r, ok := ctx.Value(requestKey{}).(Request)
+	return r, ok
+}
+
+// Options configures New.
+type Options struct {
+	// PerUser is how many events are kept for each user, the oldest
+	// dropped first; default 50.
+	PerUser int
+	// Geo resolves countries; without it events have none.
+	Geo geoip.Resolver
+	// Clock stamps events; default clock.Real().
+	Clock clock.Clock
+}
+
+type History struct {
+	opts Options
+
+	mu    sync.Mutex
+	users map[int]*log
+}
+
+// log is one user's events, oldest first, and the issue time of the
+// newest token they have signed in with.
+type log struct {
+	events []Event
+	issued int64
+}
+
+func New(opts Options) *History {
+	if opts.PerUser <= 0 {
+		opts.PerUser = 50
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	return &History{opts: opts, users: make(map[int]*log)}
+}
+
+// Record adds an event of kind for user id, from the Request in ctx if
+// there is one, and returns it. A failed country lookup leaves the
+// country out; the event is recorded all the same.
+func (h *History) Record(ctx context.Context, id int, kind Kind) Event {
+	e := h.event(ctx, id, kind)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.add(h.logOf(id), e)
+	return e
+}
+
+// SignIn records a Login for user id the first time they use a token:
+// one issued (its iat claim) later than any they have used before. The
+// API has no sign-in of its own, tokens being issued elsewhere, so the
+// first request with a new one is where a session starts. It reports
+// whether it recorded one.
+func (h *History) SignIn(ctx context.Context, id int, issuedAt int64) bool {
+	h.mu.Lock()
+	l, ok := h.users[id]
+	seen := ok && issuedAt <= l.issued
+	h.mu.Unlock()
+	if seen {
+		return false
+	}
+	// The lookup is done unlocked, so a slow resolver holds up no one
+	// else's requests; a concurrent request with the same token can get
+	// here too, and the check is made again.
+	e := h.event(ctx, id, Login)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	l = h.logOf(id)
+	if issuedAt <= l.issued {
+		return false
+	}
+	l.issued = issuedAt
+	h.add(l, e)
+	return true
+}
+
+func (h *History) event(ctx context.Context, id int, kind Kind) Event {
+	e := Event{UserID: id, Kind: kind, At: h.opts.Clock.Now()}
+	r, ok := RequestFrom(ctx)
+	if !ok {
+		return e
+	}
+	e.IP, e.UserAgent = r.IP, r.UserAgent
+	if ip, err := netip.ParseAddr(r.IP); err == nil && h.opts.Geo != nil {
+		e.Country, _ = h.opts.Geo.Country(ctx, ip)
+	}
+	return e
+}
+
+// logOf must be called with h.mu held.
+func (h *History) logOf(id int) *log {
+	l, ok := h.users[id]
+	if !ok {
+		l = &log{}
+		h.users[id] = l
+	}
+	return l
+}
+
+// add must be called with h.mu held.
+func (h *History) add(l *log, e Event) {
+	l.events = append(l.events, e)
+	if n := len(l.events) - h.opts.PerUser; n > 0 {
+		l.events = slices.Delete(l.events, 0, n)
+	}
+}
+
+// Recent returns up to limit of user id's events, newest first; all of
+// them if limit is 0 or less.
+func (h *History) Recent(id, limit int) []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	l, ok := h.users[id]
+	if !ok {
+		return []Event{}
+	}
+	n := len(l.events)
+	if limit > 0 {
+		n = min(n, limit)
+	}
+	out := make([]Event, 0, n)
+	for i := len(l.events) - 1; len(out) < n; i-- {
+		out = append(out, l.events[i])
+	}
+	return out
+}
+
+// LastActive is u's latest event, or CreatedAt if there is none: the
+// history is as old as the process, and a user who registered before it
+// started has none until they sign in.
+func (h *History) LastActive(u users.User) time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if l, ok := h.users[u.ID]; ok && len(l.events) > 0 {
+		return l.events[len(l.events)-1].At
+	}
+	return u.CreatedAt
+}
+
+// Subscribe drops a user's history when the service publishes their
+// deletion.
+func (h *History) Subscribe(bus *eventbus.Bus) error {
+	_, err := bus.Subscribe(users.TopicUserDeleted, func(ev eventbus.Event) {
+		if u, ok := ev.Payload.(users.User); ok {
+			h.mu.Lock()
+			delete(h.users, u.ID)
+			h.mu.Unlock()
+		}
+	}, eventbus.SubscribeOptions{})
+	return err
+}
+
+/*
+-----------------------------------
+PRIVACY
+-----------------------------------
+*/
+
+func (h *History) Name() string { return "activity" }
+
+// Export is the user's events, newest first.
+func (h *History) Export(_ context.Context, id int) (any, error) {
+	return h.Recent(id, 0), nil
+}
+
+func (h *History) Erase(_ context.Context, id int) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	l, ok := h.users[id]
+	if !ok {
+		return 0, nil
+	}
+	delete(h.users, id)
+	return len(l.events), nil
+}
+
+/*
+-----------------------------------
+MERGES
+-----------------------------------
+*/
+
+// Reassign moves the merged user's events to keep, in time order with
+// keep's own, and reports how many; with dryRun, how many it would. The
+// merged history counts towards PerUser like keep's, so the oldest of
+// the two may be dropped.
+func (h *History) Reassign(_ context.Context, keep *users.User, merged users.User, dryRun bool) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	from, ok := h.users[merged.ID]
+	if !ok || len(from.events) == 0 {
+		return 0, nil
+	}
+	n := len(from.events)
+	if dryRun {
+		return n, nil
+	}
+	to := h.logOf(keep.ID)
+	for _, e := range from.events {
+		e.UserID = keep.ID
+		to.events = append(to.events, e)
+	}
+	slices.SortStableFunc(to.events, func(a, b Event) int { return a.At.Compare(b.At) })
+	if extra := len(to.events) - h.opts.PerUser; extra > 0 {
+		to.events = slices.Delete(to.events, 0, extra)
+	}
+	// Tokens are per user, so the merged user's do not count as keep's.
+	delete(h.users, merged.ID)
+	return n, nil
+}
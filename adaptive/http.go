@@ -0,0 +1,52 @@
+package adaptive
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Middleware admits requests through l and rejects the rest with 503 and
+// Retry-After. 5xx responses count as drops, 4xx are ignored (they say
+// nothing about load), everything else is a latency sample.
+func Middleware(l *Limiter, retryAfterSeconds int) func(http.Handler) http.Handler {
+	if retryAfterSeconds <= 0 {
+		retryAfterSeconds = 1
+	}
+	retry := strconv.Itoa(retryAfterSeconds)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tok, ok := l.Acquire()
+			if !ok {
+				w.Header().Set("Retry-After", retry)
+				http.Error(w, "server overloaded", http.StatusServiceUnavailable)
+				return
+			}
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			defer func() {
+				switch {
+				case sw.status >= 500:
+					tok.Dropped()
+				case sw.status >= 400:
+					tok.Ignore()
+				default:
+					tok.Success()
+				}
+			}()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
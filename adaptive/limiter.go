@@ -0,0 +1,231 @@
+// Package adaptive limits concurrency to what the backend can currently
+// sustain, discovering that limit from observed latency instead of a
+// hand-tuned constant.
+//
+// The idea (Little's law): once a service is saturated, more in-flight
+// requests only add queueing delay. So the limiter watches round-trip
+// times, and when they rise above the no-load baseline it lowers the limit;
+// while they stay at baseline it probes upward. Requests over the limit are
+// rejected immediately, which keeps latency for admitted requests low and
+// gives callers a fast, retryable failure instead of a timeout.
+//
+// Two algorithms are provided: AIMD (the TCP congestion-control classic,
+// reacting to drops or an RTT threshold) and Gradient, which compares
+// short-term and long-term RTT averages and needs no threshold.
+package adaptive
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+// Algorithm computes the next limit from one completed request.
+type Algorithm interface {
+	// Update returns the new limit. inflight is the concurrency the
+	// request saw when it started; dropped means it failed in a way that
+	// signals overload (timeout, 503 from downstream, ...).
+	Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64
+}
+
+// Options configures a Limiter.
+type Options struct {
+	Initial   int // starting limit; default 20
+	Min       int // default 1
+	Max       int // default 1000
+	Algorithm Algorithm
+	Clock     clock.Clock
+	// OnChange, if set, is called (outside the lock) when the integer
+	// limit changes.
+	OnChange func(limit int)
+}
+
+// Limiter is safe for concurrent use.
+type Limiter struct {
+	opts Options
+	clk  clock.Clock
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	accepted uint64
+	rejected uint64
+	dropped  uint64
+}
+
+func New(opts Options) *Limiter {
+	if opts.Min <= 0 {
+		opts.Min = 1
+	}
+	if opts.Max <= 0 {
+		opts.Max = 1000
+	}
+	if opts.Initial <= 0 {
+		opts.Initial = 20
+	}
+	opts.Initial = min(max(opts.Initial, opts.Min), opts.Max)
+	if opts.Algorithm == nil {
+		opts.Algorithm = NewGradient()
+	}
+	return &Limiter{opts: opts, clk: clock.OrReal(opts.Clock), limit: float64(opts.Initial)}
+}
+
+// Token is an admitted request. Exactly one of Success, Dropped or Ignore
+// must be called when it finishes.
+type Token struct {
+	l        *Limiter
+	start    time.Time
+	inflight int
+}
+
+// Acquire admits a request if in-flight is below the limit. It never
+// blocks: a false return means reject now (e.g. 503 + Retry-After).
+func (l *Limiter) Acquire() (Token, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight >= int(l.limit) {
+		l.rejected++
+		return Token{}, false
+	}
+	l.inflight++
+	l.accepted++
+	return Token{l: l, start: l.clk.Now(), inflight: l.inflight}, true
+}
+
+// Success releases the slot and feeds the request's latency to the
+// algorithm.
+func (t Token) Success() { t.l.release(t, true, false) }
+
+// Dropped releases the slot and reports an overload signal.
+func (t Token) Dropped() { t.l.release(t, true, true) }
+
+// Ignore releases the slot without sampling, for outcomes that say nothing
+// about load (bad input, not found, ...). Fast failures would otherwise
+// drag the RTT baseline down.
+func (t Token) Ignore() { t.l.release(t, false, false) }
+
+func (l *Limiter) release(t Token, sample, dropped bool) {
+	rtt := l.clk.Since(t.start)
+
+	l.mu.Lock()
+	l.inflight--
+	before := int(l.limit)
+	if sample {
+		if dropped {
+			l.dropped++
+		}
+		next := l.opts.Algorithm.Update(l.limit, rtt, t.inflight, dropped)
+		l.limit = min(max(next, float64(l.opts.Min)), float64(l.opts.Max))
+	}
+	after := int(l.limit)
+	l.mu.Unlock()
+
+	if after != before && l.opts.OnChange != nil {
+		l.opts.OnChange(after)
+	}
+}
+
+// Stats is a point-in-time view for metrics.
+type Stats struct {
+	Limit    int
+	InFlight int
+	Accepted uint64
+	Rejected uint64
+	Dropped  uint64
+}
+
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Stats{
+		Limit:    int(l.limit),
+		InFlight: l.inflight,
+		Accepted: l.accepted,
+		Rejected: l.rejected,
+		Dropped:  l.dropped,
+	}
+}
+
+/*
+-----------------------------------
+ALGORITHMS
+-----------------------------------
+*/
+
+// AIMD grows the limit by one per limit's worth of successful samples
+// (i.e. +1 per "round trip" of the window) and multiplies it by Backoff on
+// a drop or when RTT exceeds Timeout.
+type AIMD struct {
+	Backoff float64       // default 0.9
+	Timeout time.Duration // 0 = only explicit drops back off
+}
+
+func (a AIMD) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	if dropped || (a.Timeout > 0 && rtt > a.Timeout) {
+		backoff := a.Backoff
+		if backoff <= 0 || backoff >= 1 {
+			backoff = 0.9
+		}
+		return limit * backoff
+	}
+	// Only grow when the limit is actually being used; an idle service
+	// shouldn't ratchet its limit to Max.
+	if float64(inflight)*2 >= limit {
+		return limit + 1/limit
+	}
+	return limit
+}
+
+// Gradient tracks a slow (long-term) and a fast (short-term) RTT average.
+// Their ratio is the gradient: 1 when latency is at baseline, below 1 when
+// requests are queueing. The limit is scaled by the gradient and given a
+// sqrt(limit) headroom so it keeps probing upward.
+//
+// Gradient is stateful; use one per Limiter.
+type Gradient struct {
+	mu        sync.Mutex
+	long      float64 // EWMA of RTT, nanoseconds
+	short     float64
+	longTau   float64 // weight of a new sample
+	shortTau  float64
+	smoothing float64
+	tolerance float64
+}
+
+func NewGradient() *Gradient {
+	return &Gradient{longTau: 1.0 / 600, shortTau: 1.0 / 10, smoothing: 0.2, tolerance: 1.5}
+}
+
+func (g *Gradient) Update(limit float64, rtt time.Duration, inflight int, dropped bool) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	sample := float64(rtt)
+	if g.long == 0 {
+		g.long, g.short = sample, sample
+		return limit
+	}
+	g.short += (sample - g.short) * g.shortTau
+	g.long += (sample - g.long) * g.longTau
+
+	// If the long average has drifted far above the short one the
+	// baseline is stale (load dropped); pull it down faster so the limit
+	// can recover.
+	if g.long/g.short > 2 {
+		g.long *= 0.95
+	}
+
+	// Don't grow a limit nobody is using.
+	if float64(inflight) < limit/2 {
+		return limit
+	}
+
+	gradient := math.Max(0.5, math.Min(1, g.tolerance*g.long/g.short))
+	if dropped {
+		gradient = 0.5
+	}
+	next := limit*gradient + math.Sqrt(limit)
+	return limit*(1-g.smoothing) + next*g.smoothing
+}
@@ -0,0 +1,390 @@
+// Package admin serves the operator dashboard: a static page embedded in
+// the binary plus the JSON endpoints it polls.
+//
+// The dashboard only reads from Sources, so it has no opinion about which
+// repository, queues or caches the process runs; main wires in whatever it
+// has. Everything except the login page requires the admin role.
+package admin
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"Go-Internals/adaptive"
+	"Go-Internals/analytics"
+	"Go-Internals/audit"
+	"Go-Internals/auth"
+	"Go-Internals/avro"
+	"Go-Internals/bandwidth"
+	"Go-Internals/boundedqueue"
+	"Go-Internals/cpuprof"
+	"Go-Internals/errortrack"
+	"Go-Internals/eventbus"
+	"Go-Internals/flightrec"
+	"Go-Internals/logfilter"
+	"Go-Internals/slowop"
+	"Go-Internals/users"
+	"Go-Internals/window"
+)
+
+//go:embed static
+var static embed.FS
+
+// Static is the dashboard's files: index.html, login.html and what they
+// load.
+func Static() fs.FS {
+	files, _ := fs.Sub(static, "static")
+	return files
+}
+
+// QueueStat is one queue's counters, labelled.
+type QueueStat struct {
+	Name string `json:"name"`
+	boundedqueue.Stats
+}
+
+// CacheStat is one cache's hit/miss counters.
+type CacheStat struct {
+	Name   string `json:"name"`
+	Hits   int64  `json:"hits"`
+	Misses int64  `json:"misses"`
+}
+
+// HitRate is hits/(hits+misses), 0 when the cache was never read.
+func (c CacheStat) HitRate() float64 {
+	if total := c.Hits + c.Misses; total > 0 {
+		return float64(c.Hits) / float64(total)
+	}
+	return 0
+}
+
+// RateStat is an event rate over a sliding window.
+type RateStat struct {
+	Name      string  `json:"name"`
+	Count     uint64  `json:"count"`
+	PerSecond float64 `json:"per_second"`
+	Window    string  `json:"window"`
+}
+
+// Sources feeds the dashboard. Nil fields are reported as empty.
+type Sources struct {
+	UserCount func() int
+	// Registrations counts the users created since a time by UTC day,
+	// oldest first; the summary asks for the last registrationDays.
+	Registrations func(since time.Time) ([]users.DayCount, error)
+	Audit         *audit.Ring
+	Queues        []func() []QueueStat
+	Caches        []func() []CacheStat
+	Rates         map[string]*window.Counter
+	Limiters      map[string]*adaptive.Limiter
+	// Bandwidth reports bandwidth.Limiter and bandwidth.Pool Stats.
+	Bandwidth map[string]func() bandwidth.Stats
+	// LogLevels is shown and changed at /api/log-levels.
+	LogLevels *logfilter.Levels
+	// Errors lists the tracked error groups, most recent first.
+	Errors func() []errortrack.Group
+	// SlowOps lists recent slow operation reports, newest first.
+	SlowOps func() []slowop.Report
+	// Profiles lists, takes and serves CPU profiles.
+	Profiles *cpuprof.Profiler
+	// Traces serves the recorded traces of slow and failed requests.
+	Traces *flightrec.Recorder
+	// Static is the dashboard's files; default Static().
+	Static fs.FS
+}
+
+// LimiterStat is one concurrency limiter's current state.
+type LimiterStat struct {
+	Name string `json:"name"`
+	adaptive.Stats
+}
+
+// BandwidthStat is one byte stream budget's usage.
+type BandwidthStat struct {
+	Name string `json:"name"`
+	bandwidth.Stats
+}
+
+// BusQueues reports every subscriber queue on bus, named by topic.
+func BusQueues(bus *eventbus.Bus) func() []QueueStat {
+	return func() []QueueStat {
+		var out []QueueStat
+		for _, s := range bus.Subscriptions() {
+			out = append(out, QueueStat{Name: "eventbus:" + s.Topic(), Stats: s.Stats()})
+		}
+		return out
+	}
+}
+
+// Queue reports a single named queue.
+func Queue[T any](name string, q *boundedqueue.Queue[T]) func() []QueueStat {
+	return func() []QueueStat { return []QueueStat{{Name: name, Stats: q.Stats()}} }
+}
+
+type summary struct {
+	At    time.Time `json:"at"`
+	Users int       `json:"users"`
+	// Registrations is empty if they could not be counted.
+	Registrations []users.DayCount `json:"registrations"`
+	Queues        []QueueStat      `json:"queues"`
+	Caches        []cacheJSON      `json:"caches"`
+	Rates         []RateStat       `json:"rates"`
+	Limiters      []LimiterStat    `json:"limiters"`
+	Bandwidth     []BandwidthStat  `json:"bandwidth"`
+	// Errors is the most recent error groups, without their stacks.
+	Errors []errortrack.Group `json:"errors"`
+}
+
+// maxErrors is how many error groups the summary lists.
+const maxErrors = 20
+
+// registrationDays is how many days of registrations the summary counts,
+// today's included.
+const registrationDays = 30
+
+type cacheJSON struct {
+	CacheStat
+	HitRate float64 `json:"hit_rate"`
+}
+
+// Handler serves the dashboard rooted at "/"; mount it with
+// http.StripPrefix. It expects auth.Authenticate to have run.
+func Handler(src Sources) http.Handler {
+	files := src.Static
+	if files == nil {
+		files = Static()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/summary", func(w http.ResponseWriter, r *http.Request) {
+		out := summary{At: time.Now(), Queues: []QueueStat{}, Caches: []cacheJSON{}, Rates: []RateStat{}, Limiters: []LimiterStat{}, Bandwidth: []BandwidthStat{}, Errors: []errortrack.Group{}, Registrations: []users.DayCount{}}
+		if src.UserCount != nil {
+			out.Users = src.UserCount()
+		}
+		if src.Registrations != nil {
+			since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-registrationDays)
+			if days, err := src.Registrations(since); err == nil {
+				out.Registrations = days
+			}
+		}
+		for _, q := range src.Queues {
+			out.Queues = append(out.Queues, q()...)
+		}
+		for _, c := range src.Caches {
+			for _, st := range c() {
+				out.Caches = append(out.Caches, cacheJSON{CacheStat: st, HitRate: st.HitRate()})
+			}
+		}
+		for name, c := range src.Rates {
+			out.Rates = append(out.Rates, RateStat{Name: name, Count: c.Sum(), PerSecond: c.Rate(), Window: c.Window().String()})
+		}
+		slices.SortFunc(out.Rates, func(a, b RateStat) int { return strings.Compare(a.Name, b.Name) })
+		for name, l := range src.Limiters {
+			out.Limiters = append(out.Limiters, LimiterStat{Name: name, Stats: l.Stats()})
+		}
+		slices.SortFunc(out.Limiters, func(a, b LimiterStat) int { return strings.Compare(a.Name, b.Name) })
+		for name, stats := range src.Bandwidth {
+			out.Bandwidth = append(out.Bandwidth, BandwidthStat{Name: name, Stats: stats()})
+		}
+		slices.SortFunc(out.Bandwidth, func(a, b BandwidthStat) int { return strings.Compare(a.Name, b.Name) })
+		if src.Errors != nil {
+			for _, g := range src.Errors() {
+				if len(out.Errors) == maxErrors {
+					break
+				}
+				g.Stack = nil
+				out.Errors = append(out.Errors, g)
+			}
+		}
+		writeJSON(w, out)
+	})
+	// One error group in full, stack included.
+	mux.HandleFunc("GET /api/errors/{fingerprint}", func(w http.ResponseWriter, r *http.Request) {
+		if src.Errors != nil {
+			for _, g := range src.Errors() {
+				if g.Fingerprint == r.PathValue("fingerprint") {
+					writeJSON(w, g)
+					return
+				}
+			}
+		}
+		http.NotFound(w, r)
+	})
+	// Slow operation reports, with their stacks.
+	mux.HandleFunc("GET /api/slow-ops", func(w http.ResponseWriter, r *http.Request) {
+		reports := []slowop.Report{}
+		if src.SlowOps != nil {
+			reports = append(reports, src.SlowOps()...)
+		}
+		writeJSON(w, reports)
+	})
+	// Request traces, newest first, ?limit=N of them (default 20); one
+	// by its request ID.
+	mux.HandleFunc("GET /api/traces", func(w http.ResponseWriter, r *http.Request) {
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 20
+		}
+		traces := []flightrec.Trace{}
+		traces = append(traces, src.Traces.Recent(limit)...)
+		writeJSON(w, traces)
+	})
+	mux.HandleFunc("GET /api/traces/{id}", func(w http.ResponseWriter, r *http.Request) {
+		t, ok := src.Traces.Get(r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, t)
+	})
+	// CPU profiles, newest first. POST takes one, ?seconds=N long
+	// (default 10, at most 120), answering when it is saved; GET by name
+	// downloads it for go tool pprof.
+	mux.HandleFunc("GET /api/profiles", func(w http.ResponseWriter, r *http.Request) {
+		profiles := []cpuprof.Profile{}
+		profiles = append(profiles, src.Profiles.List()...)
+		writeJSON(w, profiles)
+	})
+	mux.HandleFunc("POST /api/profiles", func(w http.ResponseWriter, r *http.Request) {
+		if src.Profiles == nil {
+			http.Error(w, "CPU profiling is not configured", http.StatusNotFound)
+			return
+		}
+		seconds := 10
+		if s := r.URL.Query().Get("seconds"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 || n > 120 {
+				http.Error(w, "seconds must be 1 to 120", http.StatusBadRequest)
+				return
+			}
+			seconds = n
+		}
+		d := time.Duration(seconds) * time.Second
+		if deadline, ok := r.Context().Deadline(); ok && time.Until(deadline) < d {
+			http.Error(w, "seconds is longer than the request's time budget", http.StatusBadRequest)
+			return
+		}
+		p, err := src.Profiles.Capture(r.Context(), "manual", d)
+		switch {
+		case errors.Is(err, cpuprof.ErrBusy):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("admin: CPU profile taken", "name", p.Name, "duration", p.Duration.Round(time.Millisecond))
+		writeJSON(w, p)
+	})
+	mux.HandleFunc("GET /api/profiles/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if src.Profiles == nil {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := src.Profiles.Open(r.PathValue("name"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+r.PathValue("name")+`"`)
+		_, _ = io.Copy(w, f)
+	})
+	mux.HandleFunc("GET /api/audit", func(w http.ResponseWriter, r *http.Request) {
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 50
+		}
+		entries := []audit.Entry{}
+		if src.Audit != nil {
+			entries = src.Audit.Recent(limit)
+		}
+		writeJSON(w, entries)
+	})
+	// Log levels, default and per module. PUT sets one, as {"module":
+	// "vacuum", "level": "debug"}; no module sets the default, no level
+	// returns the module to it.
+	mux.HandleFunc("GET /api/log-levels", func(w http.ResponseWriter, r *http.Request) {
+		writeLogLevels(w, src.LogLevels)
+	})
+	mux.HandleFunc("PUT /api/log-levels", func(w http.ResponseWriter, r *http.Request) {
+		if src.LogLevels == nil {
+			http.Error(w, "log levels are not adjustable", http.StatusNotFound)
+			return
+		}
+		var req struct {
+			Module string `json:"module"`
+			Level  string `json:"level"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if req.Level == "" {
+			if req.Module == "" {
+				http.Error(w, "the default level cannot be unset", http.StatusBadRequest)
+				return
+			}
+			src.LogLevels.Unset(req.Module)
+		} else {
+			var lv slog.Level
+			if err := lv.UnmarshalText([]byte(req.Level)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			src.LogLevels.Set(req.Module, lv)
+		}
+		slog.Info("admin: log level changed", "module", req.Module, "level", req.Level, "levels", src.LogLevels.String())
+		writeLogLevels(w, src.LogLevels)
+	})
+	// The whole ring as Avro, oldest first, for loading into an analytics
+	// tool.
+	mux.HandleFunc("GET /api/audit.avro", func(w http.ResponseWriter, r *http.Request) {
+		var entries []audit.Entry
+		if src.Audit != nil {
+			entries = src.Audit.Matching(func(audit.Entry) bool { return true })
+		}
+		w.Header().Set("Content-Type", "application/avro")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.avro"`)
+		_, _ = analytics.ExportAudit(entries, w, avro.Options{})
+	})
+	mux.Handle("GET /", http.FileServerFS(files))
+
+	gated := auth.RequireRole(auth.RoleAdmin)(mux)
+
+	// The login page has to be reachable without a token: it is where the
+	// operator pastes one.
+	root := http.NewServeMux()
+	root.Handle("GET /login.html", http.FileServerFS(files))
+	root.Handle("/", gated)
+	return root
+}
+
+type logLevels struct {
+	Default slog.Level            `json:"default"`
+	Modules map[string]slog.Level `json:"modules"`
+}
+
+func writeLogLevels(w http.ResponseWriter, l *logfilter.Levels) {
+	out := logLevels{Default: slog.LevelInfo, Modules: map[string]slog.Level{}}
+	if l != nil {
+		out.Default, out.Modules = l.Default().Level(), l.Modules()
+	}
+	writeJSON(w, out)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,78 @@
+"use strict";
+
+const REFRESH_MS = 5000;
+
+async function get(path) {
+  const res = await fetch(path, { credentials: "same-origin" });
+  if (res.status === 401 || res.status === 403) {
+    location.href = "login.html";
+    throw new Error("unauthorized");
+  }
+  if (!res.ok) throw new Error(path + ": " + res.status);
+  return res.json();
+}
+
+function rows(tbody, items, cols, emptyText) {
+  tbody.replaceChildren();
+  if (items.length === 0) {
+    const td = document.createElement("td");
+    td.colSpan = 99;
+    td.className = "empty";
+    td.textContent = emptyText;
+    tbody.appendChild(document.createElement("tr")).appendChild(td);
+    return;
+  }
+  for (const item of items) {
+    const tr = tbody.appendChild(document.createElement("tr"));
+    for (const col of cols) {
+      tr.appendChild(document.createElement("td")).textContent = col(item);
+    }
+  }
+}
+
+function bytes(n) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  for (; n >= 1024 && i < units.length - 1; i++) n /= 1024;
+  return (i ? n.toFixed(1) : Math.round(n)) + " " + units[i];
+}
+
+async function refresh() {
+  const [summary, events, slow, traces, profiles] = await Promise.all([get("api/summary"), get("api/audit?limit=25"),
+    get("api/slow-ops"), get("api/traces?limit=20"), get("api/profiles")]);
+
+  document.getElementById("users").textContent = summary.users;
+  document.getElementById("updated").textContent = "updated " + new Date(summary.at).toLocaleTimeString();
+
+  rows(document.getElementById("registrations"), summary.registrations.slice().reverse(),
+    [d => d.day, d => d.count], "no registrations");
+  rows(document.getElementById("rates"), summary.rates,
+    [r => r.name, r => r.window, r => r.count, r => r.per_second.toFixed(2)], "no counters");
+  rows(document.getElementById("limiters"), summary.limiters,
+    [l => l.name, l => l.Limit, l => l.InFlight, l => l.Rejected, l => l.Dropped], "no limiters");
+  rows(document.getElementById("bandwidth"), summary.bandwidth,
+    [b => b.name, b => b.limit ? bytes(b.limit) : "unlimited", b => bytes(b.per_second), b => bytes(b.total),
+      b => (b.waited / 1e9).toFixed(1) + "s", b => b.active ?? ""], "no bandwidth limits");
+  rows(document.getElementById("queues"), summary.queues,
+    [q => q.name, q => q.Depth, q => q.Capacity, q => q.Dropped, q => q.Rejected], "no queues");
+  rows(document.getElementById("caches"), summary.caches,
+    [c => c.name, c => c.hits, c => c.misses, c => (c.hit_rate * 100).toFixed(1) + "%"], "no caches");
+  rows(document.getElementById("errors"), summary.errors,
+    [e => new Date(e.last_seen).toLocaleString(), e => e.message, e => e.type, e => e.count,
+      e => new Date(e.first_seen).toLocaleString()], "no errors");
+  rows(document.getElementById("slowops"), slow.slice(0, 20),
+    [s => new Date(s.start).toLocaleString(), s => s.op, s => (s.duration / 1e6).toFixed(0) + " ms", s => s.args || "",
+      s => s.request_id || ""], "no slow operations");
+  rows(document.getElementById("traces"), traces,
+    [t => new Date(t.start).toLocaleString(), t => t.method + " " + t.path, t => t.status,
+      t => (t.duration / 1e6).toFixed(0) + " ms", t => t.spans.filter(s => s.depth === 0).map(s => s.name).join(", "),
+      t => t.id || ""], "no traces");
+  rows(document.getElementById("profiles"), profiles,
+    [p => new Date(p.start).toLocaleString(), p => p.reason, p => bytes(p.size), p => p.name], "no CPU profiles");
+  rows(document.getElementById("audit"), events,
+    [e => new Date(e.at).toLocaleString(), e => e.actor || "", e => e.action, e => e.resource + (e.resource_id ? "/" + e.resource_id : "")],
+    "no events yet");
+}
+
+refresh();
+setInterval(() => refresh().catch(console.error), REFRESH_MS);
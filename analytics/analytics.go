@@ -0,0 +1,186 @@
+// Package analytics hands users and audit entries to analytics tools as
+// Avro container files (package avro), and reads such files back.
+//
+// The schemas are fixed here rather than derived from the Go structs, so
+// a struct change cannot silently change what downstream jobs load. New
+// fields are added as optional (null default): older files then still
+// read with the new schema, and ReadUsers accepts files that lack them.
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/avro"
+	"Go-Internals/users"
+)
+
+const namespace = "gointernals"
+
+// UserSchema is the Avro schema users are exported with.
+var UserSchema = avro.Schema{
+	Name:      "User",
+	Namespace: namespace,
+	Fields: []avro.Field{
+		{Name: "id", Type: avro.Long},
+		{Name: "name", Type: avro.String},
+		{Name: "email", Type: avro.String},
+		{Name: "created_at", Type: avro.Timestamp},
+		{Name: "expires_at", Type: avro.Optional(avro.Timestamp), Doc: "null if the record never expires"},
+	},
+}
+
+// AuditSchema is the Avro schema audit entries are exported with.
+var AuditSchema = avro.Schema{
+	Name:      "AuditEntry",
+	Namespace: namespace,
+	Fields: []avro.Field{
+		{Name: "at", Type: avro.Timestamp},
+		{Name: "actor", Type: avro.Optional(avro.String)},
+		{Name: "action", Type: avro.String},
+		{Name: "resource", Type: avro.String},
+		{Name: "resource_id", Type: avro.Optional(avro.String)},
+		{Name: "meta", Type: avro.Map(avro.String)},
+	},
+}
+
+// ErrSchema is returned by ReadUsers for a file missing a required field.
+var ErrSchema = errors.New("analytics: file does not match the user schema")
+
+/*
+-----------------------------------
+EXPORT
+-----------------------------------
+*/
+
+// ExportUsers streams every user matching iopts to w. Like
+// users.ExportJSONLines it iterates, so memory is bounded by one block.
+// It returns how many users were written.
+func ExportUsers(ctx context.Context, repo users.UserRepository, w io.Writer, iopts users.IterateOptions, opts avro.Options) (int64, error) {
+	aw, err := avro.NewWriter(w, UserSchema, opts)
+	if err != nil {
+		return 0, err
+	}
+	for u, err := range repo.Iterate(ctx, iopts) {
+		if err != nil {
+			return aw.Records(), err
+		}
+		if err := aw.Append(int64(u.ID), u.Name, u.Email, u.CreatedAt, optionalTime(u.ExpiresAt)); err != nil {
+			return aw.Records(), err
+		}
+	}
+	return aw.Records(), aw.Close()
+}
+
+// ExportAudit writes entries to w.
+func ExportAudit(entries []audit.Entry, w io.Writer, opts avro.Options) (int64, error) {
+	aw, err := avro.NewWriter(w, AuditSchema, opts)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		if err := aw.Append(e.At, optionalString(e.Actor), e.Action, e.Resource, optionalString(e.ResourceID), e.Meta); err != nil {
+			return aw.Records(), err
+		}
+	}
+	return aw.Records(), aw.Close()
+}
+
+// AuditFromJSONLines reads the entries an audit.WriterSink wrote.
+func AuditFromJSONLines(r io.Reader) ([]audit.Entry, error) {
+	var out []audit.Entry
+	dec := json.NewDecoder(r)
+	for {
+		var e audit.Entry
+		if err := dec.Decode(&e); err == io.EOF {
+			return out, nil
+		} else if err != nil {
+			return out, fmt.Errorf("analytics: audit entry %d: %w", len(out)+1, err)
+		}
+		out = append(out, e)
+	}
+}
+
+func optionalTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+func optionalString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+/*
+-----------------------------------
+IMPORT
+-----------------------------------
+*/
+
+// ReadUsers yields the users in an Avro file written by ExportUsers or by
+// any tool using a compatible schema: fields are matched by name, extra
+// fields are ignored, and expires_at may be absent.
+func ReadUsers(r io.Reader) iter.Seq2[users.User, error] {
+	return func(yield func(users.User, error) bool) {
+		ar, err := avro.NewReader(r)
+		if err != nil {
+			yield(users.User{}, err)
+			return
+		}
+		if err := checkUserSchema(ar.Schema()); err != nil {
+			yield(users.User{}, err)
+			return
+		}
+		for {
+			rec, err := ar.Record()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(users.User{}, err)
+				return
+			}
+			u := users.User{
+				ID:        int(rec["id"].(int64)),
+				Name:      rec["name"].(string),
+				Email:     rec["email"].(string),
+				CreatedAt: rec["created_at"].(time.Time),
+			}
+			if t, ok := rec["expires_at"].(time.Time); ok {
+				u.ExpiresAt = t
+			}
+			if !yield(u, nil) {
+				return
+			}
+		}
+	}
+}
+
+// checkUserSchema makes the type assertions in ReadUsers safe.
+func checkUserSchema(s avro.Schema) error {
+	have := make(map[string]avro.Type, len(s.Fields))
+	for _, f := range s.Fields {
+		have[f.Name] = f.Type
+	}
+	for _, f := range UserSchema.Fields {
+		t, ok := have[f.Name]
+		switch {
+		case !ok && f.Name == "expires_at":
+		case !ok:
+			return fmt.Errorf("%w: no field %q", ErrSchema, f.Name)
+		case !t.Equal(f.Type):
+			return fmt.Errorf("%w: field %q has another type", ErrSchema, f.Name)
+		}
+	}
+	return nil
+}
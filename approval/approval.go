@@ -0,0 +1,379 @@
+// Package approval is the two-person rule for destructive operations.
+// Instead of running, one is submitted: it waits as a pending approval
+// until a second admin approves it, which runs it, or rejects it, or its
+// TTL passes and it expires. Whoever submitted one can never approve it.
+//
+// What each kind of operation does is registered with Handle, so an
+// approval holds only its kind and parameters and can be shown, and
+// decided, by anyone with the list. An approved operation runs as whoever
+// asked for it, with the approver alongside (see ApprovedBy), and not
+// under the approver's request: it finishes even if that request ends.
+// Notifiers hear of every change, to page the other admins; the audit
+// trail records each with who did it.
+//
+// Approvals are kept in memory: a restart forgets the pending ones,
+// which then have to be submitted again.
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/auth"
+	"Go-Internals/clock"
+	"Go-Internals/ctxutil"
+	"Go-Internals/ttl"
+)
+
+var (
+	ErrUnknownKind = errors.New("approval: no such kind of operation")
+	ErrReason      = errors.New("approval: a reason is required")
+	ErrNotFound    = errors.New("approval: no such approval")
+	ErrSelf        = errors.New("approval: an operation must be approved by someone other than who asked for it")
+	ErrExpired     = errors.New("approval: expired before it was approved")
+	ErrDecided     = errors.New("approval: already decided")
+	ErrService     = errors.New("approval: a service account cannot approve an operation")
+)
+
+// Status is where an approval is.
+type Status string
+
+const (
+	Pending  Status = "pending"
+	Running  Status = "running"
+	Executed Status = "executed"
+	// Failed is approved, but the operation returned an error.
+	Failed   Status = "failed"
+	Rejected Status = "rejected"
+	Expired  Status = "expired"
+)
+
+// Func runs an approved operation with its parameters, answering what it
+// did.
+type Func func(ctx context.Context, params map[string]string) (any, error)
+
+// Action is what is asked for.
+type Action struct {
+	// Kind names the Func that runs it.
+	Kind string `json:"kind"`
+	// Summary says what it does, for whoever decides.
+	Summary string            `json:"summary"`
+	Params  map[string]string `json:"params,omitempty"`
+}
+
+// Approval is one operation waiting on, or past, its second admin.
+type Approval struct {
+	ID string `json:"id"`
+	Action
+	Reason      string    `json:"reason"`
+	Status      Status    `json:"status"`
+	RequestedBy string    `json:"requested_by"`
+	RequestedAt time.Time `json:"requested_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	DecidedBy   string    `json:"decided_by,omitempty"`
+	DecidedAt   time.Time `json:"decided_at,omitzero"`
+	// Result is what the operation answered, Error why it failed.
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// requester is who asked for it, as they authenticated; the
+	// operation runs as them.
+	requester auth.Claims
+}
+
+// Event is a change to an approval, as notifiers hear of it. Type is
+// approval.requested, .executed, .failed, .rejected or .expired.
+type Event struct {
+	Type     string   `json:"type"`
+	Approval Approval `json:"approval"`
+}
+
+// Notifier hears of every event. Notify runs on the goroutine that made
+// the change, so one that does I/O should hand it off.
+type Notifier interface {
+	Notify(ctx context.Context, ev Event)
+}
+
+// NotifierFunc adapts a func to Notifier.
+type NotifierFunc func(ctx context.Context, ev Event)
+
+func (f NotifierFunc) Notify(ctx context.Context, ev Event) { f(ctx, ev) }
+
+// Options configures New.
+type Options struct {
+	// TTL is how long an approval waits for its second admin; default 1
+	// hour.
+	TTL time.Duration
+	// Keep is how many decided approvals are kept for the list; default
+	// 200.
+	Keep int
+	// Notify hears of every event.
+	Notify []Notifier
+	// Audit records every event. Default audit.Discard.
+	Audit audit.Sink
+	// Timeout bounds an approved operation; default 10 minutes.
+	Timeout time.Duration
+	// Clock defaults to clock.Real().
+	Clock clock.Clock
+}
+
+type Manager struct {
+	opts    Options
+	clk     clock.Clock
+	sweeper *ttl.Sweeper[string]
+
+	mu    sync.Mutex
+	kinds map[string]Func
+	all   map[string]*Approval
+	// decided is oldest first, for dropping past Keep.
+	decided []string
+}
+
+func New(opts Options) *Manager {
+	if opts.TTL <= 0 {
+		opts.TTL = time.Hour
+	}
+	if opts.Keep <= 0 {
+		opts.Keep = 200
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Minute
+	}
+	if opts.Audit == nil {
+		opts.Audit = audit.Discard
+	}
+	m := &Manager{opts: opts, clk: clock.OrReal(opts.Clock), kinds: map[string]Func{}, all: map[string]*Approval{}}
+	m.sweeper = ttl.New(m.clk, m.expire)
+	return m
+}
+
+// Handle registers what kind does once approved, replacing any before.
+func (m *Manager) Handle(kind string, run Func) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kinds[kind] = run
+}
+
+// Run expires pending approvals at their deadline until ctx is done.
+// Without it they still cannot be approved late, but stay pending in the
+// list and are never announced as expired.
+func (m *Manager) Run(ctx context.Context) { m.sweeper.Run(ctx) }
+
+// Submit asks for a, on requester's behalf, to be approved by someone
+// else.
+func (m *Manager) Submit(ctx context.Context, requester string, a Action, reason string) (Approval, error) {
+	if reason == "" {
+		return Approval{}, ErrReason
+	}
+	now := m.clk.Now()
+	m.mu.Lock()
+	if _, ok := m.kinds[a.Kind]; !ok {
+		m.mu.Unlock()
+		return Approval{}, ErrUnknownKind
+	}
+	ap := &Approval{
+		ID:          randomID(),
+		Action:      a,
+		Reason:      reason,
+		Status:      Pending,
+		RequestedBy: requester,
+		RequestedAt: now.UTC(),
+		ExpiresAt:   now.Add(m.opts.TTL).UTC(),
+		requester:   auth.Claims{Subject: requester},
+	}
+	if c, ok := auth.PrincipalFrom(ctx); ok && c.Subject == requester {
+		ap.requester = c
+	}
+	m.all[ap.ID] = ap
+	snap := *ap
+	m.mu.Unlock()
+	m.sweeper.Schedule(ap.ID, ap.ExpiresAt)
+	m.announce(ctx, "approval.requested", snap)
+	return snap, nil
+}
+
+// Approve runs approval id as approver, who must not be who asked for
+// it, nor a service account: the second pair of eyes is a person's. It
+// answers the approval as it ends: Executed with the result, or Failed
+// with the error, which Approve itself does not return.
+func (m *Manager) Approve(ctx context.Context, id, approver string) (Approval, error) {
+	if c, _ := auth.PrincipalFrom(ctx); c.HasRole(auth.RoleService) {
+		return Approval{}, ErrService
+	}
+	now := m.clk.Now()
+	m.mu.Lock()
+	ap, ok := m.all[id]
+	switch {
+	case !ok:
+		m.mu.Unlock()
+		return Approval{}, ErrNotFound
+	case ap.Status == Pending && !now.Before(ap.ExpiresAt):
+		// The sweeper has not got to it yet.
+		m.mu.Unlock()
+		m.expire(id, now)
+		return Approval{}, ErrExpired
+	case ap.Status == Expired:
+		m.mu.Unlock()
+		return Approval{}, ErrExpired
+	case ap.Status != Pending:
+		m.mu.Unlock()
+		return Approval{}, ErrDecided
+	case ap.RequestedBy == approver:
+		m.mu.Unlock()
+		return Approval{}, ErrSelf
+	}
+	// Running, so that a second approval in the meantime is refused.
+	ap.Status, ap.DecidedBy, ap.DecidedAt = Running, approver, now.UTC()
+	run := m.kinds[ap.Kind]
+	params := ap.Params
+	runCtx := withApprover(auth.WithPrincipal(ctxutil.Detach(ctx), ap.requester), approver)
+	m.mu.Unlock()
+	m.sweeper.Cancel(id)
+
+	// The approver's request may end before the operation does; what it
+	// did is in the list either way.
+	ctx = ctxutil.Detach(ctx)
+	runCtx, cancel := context.WithTimeout(runCtx, m.opts.Timeout)
+	result, err := run(runCtx, params)
+	cancel()
+
+	m.mu.Lock()
+	ap.Status, ap.Result = Executed, result
+	if err != nil {
+		ap.Status, ap.Error = Failed, err.Error()
+	}
+	snap := *ap
+	m.decidedLocked(id)
+	m.mu.Unlock()
+	m.announce(ctx, "approval."+string(snap.Status), snap)
+	return snap, nil
+}
+
+type approverKey struct{}
+
+func withApprover(ctx context.Context, approver string) context.Context {
+	return context.WithValue(ctx, approverKey{}, approver)
+}
+
+// ApprovedBy is who approved the operation a Func is running, "" outside
+// one. The Func's context is otherwise the requester's.
+func ApprovedBy(ctx context.Context) string {
+	a, _ := ctx.Value(approverKey{}).(string)
+	return a
+}
+
+// Reject refuses approval id. Whoever asked for it may, to withdraw it.
+func (m *Manager) Reject(ctx context.Context, id, by string) (Approval, error) {
+	now := m.clk.Now()
+	m.mu.Lock()
+	ap, ok := m.all[id]
+	switch {
+	case !ok:
+		m.mu.Unlock()
+		return Approval{}, ErrNotFound
+	case ap.Status == Expired || ap.Status == Pending && !now.Before(ap.ExpiresAt):
+		m.mu.Unlock()
+		m.expire(id, now)
+		return Approval{}, ErrExpired
+	case ap.Status != Pending:
+		m.mu.Unlock()
+		return Approval{}, ErrDecided
+	}
+	ap.Status, ap.DecidedBy, ap.DecidedAt = Rejected, by, now.UTC()
+	snap := *ap
+	m.decidedLocked(id)
+	m.mu.Unlock()
+	m.sweeper.Cancel(id)
+	m.announce(ctx, "approval.rejected", snap)
+	return snap, nil
+}
+
+// Get returns approval id.
+func (m *Manager) Get(id string) (Approval, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ap, ok := m.all[id]
+	if !ok {
+		return Approval{}, ErrNotFound
+	}
+	return *ap, nil
+}
+
+// List returns the approvals with status, or all of them if it is "",
+// newest first.
+func (m *Manager) List(status Status) []Approval {
+	m.mu.Lock()
+	out := make([]Approval, 0, len(m.all))
+	for _, ap := range m.all {
+		if status == "" || ap.Status == status {
+			out = append(out, *ap)
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].RequestedAt.After(out[j].RequestedAt) })
+	return out
+}
+
+// expire is the sweeper's: approval id's TTL has passed.
+func (m *Manager) expire(id string, at time.Time) {
+	m.mu.Lock()
+	ap, ok := m.all[id]
+	if !ok || ap.Status != Pending {
+		m.mu.Unlock()
+		return
+	}
+	ap.Status, ap.DecidedAt = Expired, at.UTC()
+	snap := *ap
+	m.decidedLocked(id)
+	m.mu.Unlock()
+	m.announce(context.Background(), "approval.expired", snap)
+}
+
+func (m *Manager) decidedLocked(id string) {
+	m.decided = append(m.decided, id)
+	for len(m.decided) > m.opts.Keep {
+		delete(m.all, m.decided[0])
+		m.decided = m.decided[1:]
+	}
+}
+
+// announce records ev and tells the notifiers. Recording is best effort:
+// what it records has happened either way.
+func (m *Manager) announce(ctx context.Context, typ string, ap Approval) {
+	meta := map[string]string{
+		"kind":         ap.Kind,
+		"summary":      ap.Summary,
+		"requested_by": ap.RequestedBy,
+	}
+	if ap.DecidedBy != "" {
+		meta["decided_by"] = ap.DecidedBy
+	}
+	if ap.Error != "" {
+		meta["error"] = ap.Error
+	}
+	if typ == "approval.requested" {
+		meta["reason"] = ap.Reason
+	}
+	_ = m.opts.Audit.Record(ctx, audit.Entry{
+		Actor:      ctxutil.UserID(ctx),
+		Action:     typ,
+		Resource:   "approval",
+		ResourceID: ap.ID,
+		Meta:       meta,
+	})
+	for _, n := range m.opts.Notify {
+		n.Notify(ctx, Event{Type: typ, Approval: ap})
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,80 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+/*
+-----------------------------------
+NOTIFIERS
+-----------------------------------
+*/
+
+// Log writes each event to logger (slog.Default() if nil).
+func Log(logger *slog.Logger) Notifier {
+	return NotifierFunc(func(ctx context.Context, ev Event) {
+		l := logger
+		if l == nil {
+			l = slog.Default()
+		}
+		l.InfoContext(ctx, ev.Type,
+			"approval", ev.Approval.ID,
+			"kind", ev.Approval.Kind,
+			"summary", ev.Approval.Summary,
+			"requested_by", ev.Approval.RequestedBy,
+			"status", string(ev.Approval.Status))
+	})
+}
+
+// Webhook posts each event as JSON to a URL, in the background: a slow
+// or broken receiver does not hold up the operation. A failed delivery
+// is logged, not retried.
+type Webhook struct {
+	URL    string
+	Header http.Header // added to every request, e.g. Authorization
+	Client *http.Client
+}
+
+func (wh *Webhook) Notify(ctx context.Context, ev Event) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := wh.send(ctx, ev); err != nil {
+			slog.WarnContext(ctx, "approval webhook failed", "event", ev.Type, "approval", ev.Approval.ID, "err", err)
+		}
+	}()
+}
+
+func (wh *Webhook) send(ctx context.Context, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, vs := range wh.Header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := wh.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("approval: webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+// Package assets puts the binary's non-Go files in one place. The
+// packages that embed them (the dashboard, the notification templates,
+// the message catalogs, the test GeoIP ranges) and this one, which holds
+// the seed data, hand their files to a Bundle by name; one binary then
+// carries everything it serves.
+//
+// While developing, an override directory stands in for any of them
+// file by file: <dir>/<name>/<path> is read instead of the embedded
+// file, so the dashboard can be edited without a rebuild. Manifest lists
+// every file with its SHA-256, and where it was read from, so what a
+// deploy runs can be compared with what was built.
+package assets
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+//go:embed seed
+var seed embed.FS
+
+// Seed is the demo data: users.json, the users registered at startup.
+func Seed() fs.FS {
+	sub, _ := fs.Sub(seed, "seed")
+	return sub
+}
+
+// Bundle is a set of named file trees, each possibly overridden.
+type Bundle struct {
+	names    []string
+	embedded map[string]fs.FS
+	// over holds the overridden trees' directories.
+	over map[string]string
+}
+
+// New bundles embedded, each tree under its name. With override, a
+// directory named after a tree in it overrides that tree's files.
+func New(embedded map[string]fs.FS, override string) (*Bundle, error) {
+	b := &Bundle{embedded: embedded, over: map[string]string{}}
+	for name := range embedded {
+		b.names = append(b.names, name)
+	}
+	slices.Sort(b.names)
+	if override == "" {
+		return b, nil
+	}
+	abs, err := filepath.Abs(override)
+	if err != nil {
+		return nil, fmt.Errorf("assets: %w", err)
+	}
+	if fi, err := os.Stat(abs); err != nil {
+		return nil, fmt.Errorf("assets: %w", err)
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("assets: %s is not a directory", override)
+	}
+	for _, name := range b.names {
+		dir := filepath.Join(abs, name)
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			b.over[name] = dir
+		}
+	}
+	return b, nil
+}
+
+// Names lists the trees, sorted.
+func (b *Bundle) Names() []string { return slices.Clone(b.names) }
+
+// Overridden reports whether the override directory has name's tree.
+func (b *Bundle) Overridden(name string) bool { return b.over[name] != "" }
+
+// FS is name's tree, with the override's files over the embedded ones,
+// read as they are now: an edited file is served as edited, but what a
+// package parses at startup (templates, catalogs) needs a restart. It
+// panics on a name New was not given.
+func (b *Bundle) FS(name string) fs.FS {
+	e, ok := b.embedded[name]
+	if !ok {
+		panic("assets: no tree named " + name)
+	}
+	if dir := b.over[name]; dir != "" {
+		return overlay{top: os.DirFS(dir), base: e}
+	}
+	return e
+}
+
+/*
+-----------------------------------
+OVERLAY
+-----------------------------------
+*/
+
+// overlay reads top's files before base's, and lists both in a
+// directory.
+type overlay struct {
+	top, base fs.FS
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	if fi, err := fs.Stat(o.top, name); err == nil && !fi.IsDir() {
+		return o.top.Open(name)
+	}
+	if f, err := o.base.Open(name); !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+	// A directory only the override has.
+	return o.top.Open(name)
+}
+
+func (o overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	top, terr := fs.ReadDir(o.top, name)
+	base, berr := fs.ReadDir(o.base, name)
+	if terr != nil && berr != nil {
+		return nil, berr
+	}
+	seen := make(map[string]bool, len(top))
+	out := slices.Clone(top)
+	for _, e := range top {
+		seen[e.Name()] = true
+	}
+	for _, e := range base {
+		if !seen[e.Name()] {
+			out = append(out, e)
+		}
+	}
+	slices.SortFunc(out, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return out, nil
+}
+
+/*
+-----------------------------------
+MANIFEST
+-----------------------------------
+*/
+
+// File is one file of a tree.
+type File struct {
+	Tree   string `json:"tree"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Override is set for a file read from the override directory.
+	Override bool `json:"override,omitempty"`
+}
+
+// Manifest is every file the bundle serves.
+type Manifest struct {
+	// Digest is the SHA-256 of every file's tree, path and SHA-256, so
+	// two deploys serve the same files exactly when it is the same.
+	Digest string `json:"digest"`
+	Files  []File `json:"files"`
+}
+
+// Manifest reads and hashes every file, sorted by tree and path.
+func (b *Bundle) Manifest() (Manifest, error) {
+	m := Manifest{Files: []File{}}
+	all := sha256.New()
+	for _, name := range b.names {
+		fsys := b.FS(name)
+		var top fs.FS
+		if dir := b.over[name]; dir != "" {
+			top = os.DirFS(dir)
+		}
+		err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			f := File{Tree: name, Path: p}
+			if top != nil {
+				if fi, err := fs.Stat(top, p); err == nil && !fi.IsDir() {
+					f.Override = true
+				}
+			}
+			if f.Size, f.SHA256, err = hashFile(fsys, p); err != nil {
+				return err
+			}
+			fmt.Fprintf(all, "%s/%s %s\n", f.Tree, f.Path, f.SHA256)
+			m.Files = append(m.Files, f)
+			return nil
+		})
+		if err != nil {
+			return Manifest{}, fmt.Errorf("assets: %s: %w", name, err)
+		}
+	}
+	m.Digest = hex.EncodeToString(all.Sum(nil))
+	return m, nil
+}
+
+func hashFile(fsys fs.FS, p string) (int64, string, error) {
+	f, err := fsys.Open(p)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,241 @@
+// Package atrest is envelope encryption for data written to disk.
+//
+// A Keyring holds key-encryption keys (KEKs), each with an ID, from
+// configuration ($USERS_DATA_KEYS). Data is never encrypted with a KEK
+// directly: a store asks for a fresh random data key (DEK), encrypts its
+// records with that, and stores the DEK next to the data wrapped (itself
+// encrypted) by the keyring's current KEK. Reading unwraps the DEK with
+// whichever KEK wrapped it, so old files stay readable while the keyring
+// lists their key.
+//
+// Rotation is therefore: put the new key first in $USERS_DATA_KEYS and
+// keep the old one after it. New files (every flush, compaction or
+// vacuum) get DEKs wrapped by the new key; once compaction has rewritten
+// everything, the old key can be dropped.
+//
+// Both layers are AES-GCM with random 96-bit nonces. Callers pass
+// associated data that binds a ciphertext to where it lives (a record
+// key, a slot's ID), so ciphertexts cannot be swapped around on disk.
+package atrest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvVar holds the keyring: comma-separated id:base64key pairs, the
+// current key first ("k2:...,k1:..."). Keys are 16, 24 or 32 bytes.
+const EnvVar = "USERS_DATA_KEYS"
+
+// Overhead is how much DataKey.Seal adds to a plaintext.
+const Overhead = nonceSize + tagSize
+
+const (
+	nonceSize = 12
+	tagSize   = 16
+	dekSize   = 32
+	blobMagic = "ATR1"
+)
+
+var (
+	ErrUnknownKey = errors.New("atrest: data key wrapped by a key not in the keyring")
+	ErrDecrypt    = errors.New("atrest: decryption failed (wrong key or tampered data)")
+)
+
+// Keyring is safe for concurrent use; it is immutable once built.
+type Keyring struct {
+	current string
+	keks    map[string]cipher.AEAD
+}
+
+// NewKeyring builds a keyring whose current key is keys[current].
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	k := &Keyring{current: current, keks: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("atrest: bad key id %q", id)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("atrest: key %q: %w", id, err)
+		}
+		k.keks[id] = aead
+	}
+	if _, ok := k.keks[current]; !ok {
+		return nil, fmt.Errorf("atrest: current key %q not in keyring", current)
+	}
+	return k, nil
+}
+
+// ParseKeyring reads the EnvVar format.
+func ParseKeyring(spec string) (*Keyring, error) {
+	keys := make(map[string][]byte)
+	var current string
+	for _, part := range strings.Split(spec, ",") {
+		id, enc, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("atrest: want id:base64key, got %q", part)
+		}
+		key, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil {
+			return nil, fmt.Errorf("atrest: key %q: %w", id, err)
+		}
+		if current == "" {
+			current = id
+		}
+		keys[id] = key
+	}
+	return NewKeyring(current, keys)
+}
+
+// FromEnv reads the keyring from EnvVar. An unset variable returns a nil
+// keyring and no error: encryption is off.
+func FromEnv() (*Keyring, error) {
+	spec := os.Getenv(EnvVar)
+	if spec == "" {
+		return nil, nil
+	}
+	return ParseKeyring(spec)
+}
+
+// Current is the ID of the key that wraps new data keys.
+func (k *Keyring) Current() string { return k.current }
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+-----------------------------------
+DATA KEYS
+-----------------------------------
+*/
+
+// DataKey encrypts records. Store Wrapped() alongside them.
+type DataKey struct {
+	aead    cipher.AEAD
+	kek     string
+	wrapped []byte
+}
+
+// NewDataKey makes a random DEK wrapped by the current KEK.
+//
+// Wrapped format: [idLen u8][kek id][nonce][sealed DEK].
+func (k *Keyring) NewDataKey() (*DataKey, error) {
+	dek := make([]byte, dekSize)
+	rand.Read(dek)
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+	wrapped := append([]byte{byte(len(k.current))}, k.current...)
+	wrapped = sealTo(k.keks[k.current], wrapped, dek, []byte(k.current))
+	return &DataKey{aead: aead, kek: k.current, wrapped: wrapped}, nil
+}
+
+// OpenDataKey unwraps a DEK stored by NewDataKey.
+func (k *Keyring) OpenDataKey(wrapped []byte) (*DataKey, error) {
+	if len(wrapped) < 1 || len(wrapped) < 1+int(wrapped[0]) {
+		return nil, ErrDecrypt
+	}
+	id := string(wrapped[1 : 1+wrapped[0]])
+	kek, ok := k.keks[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	dek, err := openFrom(kek, nil, wrapped[1+len(id):], []byte(id))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return &DataKey{aead: aead, kek: id, wrapped: wrapped}, nil
+}
+
+// Wrapped is the DEK encrypted by its KEK, safe to store in the clear.
+func (d *DataKey) Wrapped() []byte { return d.wrapped }
+
+// KeyID names the KEK that wrapped this DEK; compare it with
+// Keyring.Current to tell whether data needs re-encrypting.
+func (d *DataKey) KeyID() string { return d.kek }
+
+// Seal appends nonce || ciphertext of plaintext to dst.
+func (d *DataKey) Seal(dst, plaintext, aad []byte) []byte {
+	return sealTo(d.aead, dst, plaintext, aad)
+}
+
+// Open appends the plaintext of sealed to dst.
+func (d *DataKey) Open(dst, sealed, aad []byte) ([]byte, error) {
+	return openFrom(d.aead, dst, sealed, aad)
+}
+
+func sealTo(aead cipher.AEAD, dst, plaintext, aad []byte) []byte {
+	nonce := make([]byte, nonceSize)
+	rand.Read(nonce)
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, aad)
+}
+
+func openFrom(aead cipher.AEAD, dst, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < Overhead {
+		return nil, ErrDecrypt
+	}
+	out, err := aead.Open(dst, sealed[:nonceSize], sealed[nonceSize:], aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return out, nil
+}
+
+/*
+-----------------------------------
+SELF-CONTAINED BLOBS
+-----------------------------------
+*/
+
+// Seal encrypts a whole blob (a snapshot file) under a fresh DEK and
+// returns [magic][wrappedLen u16][wrapped DEK][nonce][ciphertext].
+func (k *Keyring) Seal(plaintext, aad []byte) ([]byte, error) {
+	dk, err := k.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(blobMagic), 0, 0)
+	binary.LittleEndian.PutUint16(out[len(blobMagic):], uint16(len(dk.wrapped)))
+	out = append(out, dk.wrapped...)
+	return dk.Seal(out, plaintext, aad), nil
+}
+
+// Open reverses Seal.
+func (k *Keyring) Open(blob, aad []byte) ([]byte, error) {
+	if !IsSealed(blob) || len(blob) < len(blobMagic)+2 {
+		return nil, ErrDecrypt
+	}
+	rest := blob[len(blobMagic):]
+	n := int(binary.LittleEndian.Uint16(rest))
+	if len(rest) < 2+n {
+		return nil, ErrDecrypt
+	}
+	dk, err := k.OpenDataKey(rest[2 : 2+n])
+	if err != nil {
+		return nil, err
+	}
+	return dk.Open(nil, rest[2+n:], aad)
+}
+
+// IsSealed reports whether blob was produced by Keyring.Seal.
+func IsSealed(blob []byte) bool { return bytes.HasPrefix(blob, []byte(blobMagic)) }
@@ -0,0 +1,87 @@
+// Package audit records who did what to which resource.
+//
+// Recording is on the request path, writing is not: entries are buffered by
+// a batcher and written out in groups, so an audit trail costs one channel
+// send per operation rather than one fsync.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"Go-Internals/batcher"
+	"Go-Internals/clock"
+)
+
+// Entry is one audited operation.
+type Entry struct {
+	At    time.Time `json:"at"`
+	Actor string    `json:"actor,omitempty"`
+	// Impersonator is the admin who acted as Actor, if one did.
+	Impersonator string            `json:"impersonator,omitempty"`
+	Action       string            `json:"action"`
+	Resource     string            `json:"resource"`
+	ResourceID   string            `json:"resource_id,omitempty"`
+	Meta         map[string]string `json:"meta,omitempty"`
+}
+
+// Sink accepts audit entries.
+type Sink interface {
+	Record(ctx context.Context, e Entry) error
+	Close(ctx context.Context) error
+}
+
+// WriterSink writes entries as JSON lines, one batch per write.
+type WriterSink struct {
+	mu  sync.Mutex // serializes writes to w
+	w   io.Writer
+	b   *batcher.Batcher[Entry]
+	clk clock.Clock
+}
+
+// NewWriterSink batches entries into w. opts controls batch size/latency.
+func NewWriterSink(w io.Writer, opts batcher.Options) *WriterSink {
+	s := &WriterSink{w: w, clk: clock.OrReal(opts.Clock)}
+	s.b = batcher.New(s.write, opts)
+	return s
+}
+
+// Record stamps the entry (if At is zero) and queues it.
+func (s *WriterSink) Record(ctx context.Context, e Entry) error {
+	if e.At.IsZero() {
+		e.At = s.clk.Now()
+	}
+	return s.b.Add(ctx, e)
+}
+
+// Flush writes out everything buffered so far.
+func (s *WriterSink) Flush(ctx context.Context) error { return s.b.Flush(ctx) }
+
+// Close flushes remaining entries. It does not close the underlying writer.
+func (s *WriterSink) Close(ctx context.Context) error { return s.b.Close(ctx) }
+
+func (s *WriterSink) write(_ context.Context, batch []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bw := bufio.NewWriter(s.w)
+	enc := json.NewEncoder(bw)
+	for _, e := range batch {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Discard is a Sink that drops everything; useful as a default.
+var Discard Sink = discard{}
+
+type discard struct{}
+
+func (discard) Record(context.Context, Entry) error { return nil }
+func (discard) Close(context.Context) error         { return nil }
@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"fmt"
+	"time"
+
+	"Go-Internals/internals/reflectutil"
+)
+
+/*
+-----------------------------------
+BEFORE / AFTER
+-----------------------------------
+*/
+
+// Changes describes an update as entry metadata: "before.<field>" and
+// "after.<field>" for every field that differs between before and after,
+// two values of one struct type. Fields tagged `redact:"true"` are listed
+// (that they changed is worth keeping) with both values replaced by
+// reflectutil.Redacted, so personal data does not end up in the trail.
+// It returns nil if nothing changed.
+func Changes(before, after any) (map[string]string, error) {
+	changes, err := reflectutil.Diff(before, after)
+	if err != nil || len(changes) == 0 {
+		return nil, err
+	}
+	meta := make(map[string]string, 2*len(changes))
+	for _, c := range changes {
+		b, a := reflectutil.Redacted, reflectutil.Redacted
+		if !reflectutil.Redacts(c.Field) {
+			b, a = metaValue(c.Before), metaValue(c.After)
+		}
+		meta["before."+c.Field.Path] = b
+		meta["after."+c.Field.Path] = a
+	}
+	return meta, nil
+}
+
+func metaValue(v any) string {
+	if t, ok := v.(time.Time); ok {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"Go-Internals/ctxutil"
+)
+
+// Ring keeps the most recent entries in memory (for dashboards) and
+// forwards every entry to an optional next sink.
+type Ring struct {
+	mu   sync.Mutex
+	buf  []Entry
+	head int // index of the next write
+	full bool
+	next Sink
+}
+
+// NewRing keeps the last size entries. next may be nil.
+func NewRing(size int, next Sink) *Ring {
+	if size <= 0 {
+		size = 100
+	}
+	if next == nil {
+		next = Discard
+	}
+	return &Ring{buf: make([]Entry, size), next: next}
+}
+
+// Record stamps the entry (At if zero, and the impersonator acting in
+// ctx), keeps it and forwards it.
+func (r *Ring) Record(ctx context.Context, e Entry) error {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	if e.Impersonator == "" {
+		e.Impersonator = ctxutil.Impersonator(ctx)
+	}
+	r.mu.Lock()
+	r.buf[r.head] = e
+	r.head = (r.head + 1) % len(r.buf)
+	if r.head == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+	return r.next.Record(ctx, e)
+}
+
+// Recent returns up to n entries, newest first.
+func (r *Ring) Recent(n int) []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	size := r.head
+	if r.full {
+		size = len(r.buf)
+	}
+	n = min(n, size)
+	out := make([]Entry, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, r.buf[(r.head-i+len(r.buf))%len(r.buf)])
+	}
+	return out
+}
+
+// Matching returns the kept entries for which match is true, oldest
+// first.
+func (r *Ring) Matching(match func(Entry) bool) []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var out []Entry
+	for i := range r.len() {
+		e := r.buf[r.index(i)]
+		if match(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Redact rewrites the kept entries for which match is true and returns how
+// many it changed. Entries already forwarded to the next sink are not
+// touched; that sink has to be redacted on its own.
+func (r *Ring) Redact(match func(Entry) bool, redact func(*Entry)) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for i := range r.len() {
+		if e := &r.buf[r.index(i)]; match(*e) {
+			redact(e)
+			n++
+		}
+	}
+	return n
+}
+
+// Remove drops the kept entries for which match is true and returns how
+// many it dropped. As with Redact, the next sink is not affected.
+func (r *Ring) Remove(match func(Entry) bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := make([]Entry, 0, len(r.buf))
+	for i := range r.len() {
+		if e := r.buf[r.index(i)]; !match(e) {
+			kept = append(kept, e)
+		}
+	}
+	n := r.len() - len(kept)
+	r.head, r.full = len(kept)%len(r.buf), len(kept) == len(r.buf)
+	r.buf = kept[:len(r.buf)]
+	clear(r.buf[len(kept):])
+	return n
+}
+
+// len and index address kept entries oldest first; r.mu must be held.
+func (r *Ring) len() int {
+	if r.full {
+		return len(r.buf)
+	}
+	return r.head
+}
+
+func (r *Ring) index(i int) int {
+	if !r.full {
+		return i
+	}
+	return (r.head + i) % len(r.buf)
+}
+
+func (r *Ring) Close(ctx context.Context) error { return r.next.Close(ctx) }
@@ -0,0 +1,197 @@
+// Package auth issues and verifies bearer tokens and enforces roles.
+//
+// Tokens are compact JWTs signed with HMAC-SHA256. Only what the service
+// needs is implemented: no alg negotiation (anything but HS256 is
+// rejected, which closes the classic "alg: none" hole), and a small fixed
+// claim set.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("auth: invalid token")
+	ErrExpiredToken = errors.New("auth: token expired")
+	// ErrRevokedToken is a genuine token whose session was ended before
+	// it expired (see session.Manager).
+	ErrRevokedToken = errors.New("auth: token revoked")
+)
+
+// Well-known roles.
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+	// RoleService is a service account's: a client, not a person.
+	RoleService = "service"
+)
+
+// Claims is the token payload.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Roles     []string `json:"roles,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+	// AMR lists how the subject authenticated (RFC 8176): a token that
+	// passed a second factor has AMRMFA.
+	AMR []string `json:"amr,omitempty"`
+	// ID names the token, so it alone can be revoked; Session, the
+	// server-side session it was issued for.
+	ID      string `json:"jti,omitempty"`
+	Session string `json:"sid,omitempty"`
+	// Scope lists, space-separated, the scopes the token is limited to
+	// (see Allows), as RFC 8693 §4.2's scope claim does.
+	Scope string `json:"scope,omitempty"`
+	// Act is who is acting as Subject (RFC 8693 §4.1): set on an
+	// admin's token to impersonate a user (see package impersonate).
+	Act *Actor `json:"act,omitempty"`
+}
+
+// Actor is the party behind an impersonation token.
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+// Impersonator is the subject acting as c's, "" if c is its own.
+func (c Claims) Impersonator() string {
+	if c.Act == nil {
+		return ""
+	}
+	return c.Act.Subject
+}
+
+// Authentication methods for Claims.AMR.
+const (
+	AMROTP = "otp" // a one-time password
+	AMRMFA = "mfa" // more than one factor
+)
+
+// HasRole reports whether the claims grant role.
+func (c Claims) HasRole(role string) bool { return slices.Contains(c.Roles, role) }
+
+// TwoFactor reports whether the subject passed a second factor.
+func (c Claims) TwoFactor() bool { return slices.Contains(c.AMR, AMRMFA) }
+
+// Signer turns claims into a token; HS256 is one.
+type Signer interface {
+	Sign(c Claims) (string, error)
+}
+
+// HS256 signs and verifies tokens with a shared secret.
+type HS256 struct {
+	Key []byte
+	// Keys, if set, is used instead of Key: tokens are signed with its
+	// current version, named in their kid header, and verified with the
+	// version their kid names. Tokens without a kid, from before
+	// rotation, are tried with every version.
+	Keys Keyring
+	// Now is overridable for tests; nil means time.Now.
+	Now func() time.Time
+}
+
+// Keyring is a versioned key; keyset.Set is one.
+type Keyring interface {
+	Current() (id string, key []byte)
+	Lookup(id string) ([]byte, bool)
+	IDs() []string
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Issue signs a token for subject valid for ttl.
+func (h *HS256) Issue(subject string, roles []string, ttl time.Duration) (string, error) {
+	now := h.now()
+	return h.Sign(Claims{
+		Subject:   subject,
+		Roles:     roles,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+}
+
+// Sign encodes and signs arbitrary claims.
+func (h *HS256) Sign(c Claims) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	hdr, key := jwtHeader, h.Key
+	if h.Keys != nil {
+		var kid string
+		kid, key = h.Keys.Current()
+		b, _ := json.Marshal(header{Alg: "HS256", Kid: kid, Typ: "JWT"})
+		hdr = base64.RawURLEncoding.EncodeToString(b)
+	}
+	signing := hdr + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signing + "." + sign(key, signing), nil
+}
+
+// Verify checks signature, algorithm and expiry, and returns the claims.
+func (h *HS256) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrInvalidToken
+	}
+
+	var hdr header
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(raw, &hdr) != nil || hdr.Alg != "HS256" {
+		return Claims{}, ErrInvalidToken
+	}
+	if !h.verify(hdr.Kid, parts[0]+"."+parts[1], parts[2]) {
+		return Claims{}, ErrInvalidToken
+	}
+
+	var c Claims
+	raw, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(raw, &c) != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	if c.ExpiresAt != 0 && h.now().Unix() >= c.ExpiresAt {
+		return Claims{}, ErrExpiredToken
+	}
+	return c, nil
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ"`
+}
+
+// verify reports whether sig is signing's, with the key kid names.
+func (h *HS256) verify(kid, signing, sig string) bool {
+	if h.Keys == nil {
+		return hmac.Equal([]byte(sign(h.Key, signing)), []byte(sig))
+	}
+	ids := []string{kid}
+	if kid == "" {
+		ids = h.Keys.IDs()
+	}
+	for _, id := range ids {
+		if key, ok := h.Keys.Lookup(id); ok && hmac.Equal([]byte(sign(key, signing)), []byte(sig)) {
+			return true
+		}
+	}
+	return false
+}
+
+func sign(key []byte, s string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (h *HS256) now() time.Time {
+	if h.Now != nil {
+		return h.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CookieName is the cookie browsers (the admin UI) carry the token in.
+const CookieName = "session_token"
+
+// Verifier turns a raw token into claims.
+type Verifier interface {
+	Verify(token string) (Claims, error)
+}
+
+type principalKey struct{}
+
+// WithPrincipal stores verified claims in ctx.
+func WithPrincipal(ctx context.Context, c Claims) context.Context {
+	return context.WithValue(ctx, principalKey{}, c)
+}
+
+// PrincipalFrom returns the authenticated caller, if any.
+func PrincipalFrom(ctx context.Context) (Claims, bool) {
+	c, ok := ctx.Value(principalKey{}).(Claims)
+	return c, ok
+}
+
+// Authenticate verifies the bearer token (Authorization header, falling
+// back to the session cookie) and stores the claims in the request context.
+// Requests without a token pass through anonymously; requests with a bad
+// token are rejected with 401 so clients notice expired credentials.
+func Authenticate(v Verifier) func(http.Handler) http.Handler {
+	return AuthenticateGuarded(v, nil)
+}
+
+// Guard watches authentications, to stop tokens being guessed (see
+// lockout.Guard).
+type Guard interface {
+	// Admit is asked before a token is verified, with the subject it
+	// claims, unverified ("" if none can be read), and the client's
+	// address; an error refuses the request.
+	Admit(ctx context.Context, subject, ip string) error
+	// Failed is told of a token that did not verify. Expired and revoked
+	// tokens are not failures: they were genuine, only old.
+	Failed(ctx context.Context, subject, ip string)
+	// Succeeded is told of a token that verified.
+	Succeeded(ctx context.Context, subject, ip string)
+}
+
+// AuthenticateGuarded is Authenticate with g told of every attempt; g
+// may be nil. A request g does not admit is answered 429, with
+// Retry-After if the error has a RetryAfter() time.Duration method.
+func AuthenticateGuarded(v Verifier, g Guard) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := tokenFrom(r)
+			if token == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var subject, ip string
+			if g != nil {
+				subject, ip = claimedSubject(token), clientIP(r)
+				if err := g.Admit(r.Context(), subject, ip); err != nil {
+					var retry interface{ RetryAfter() time.Duration }
+					if errors.As(err, &retry) {
+						w.Header().Set("Retry-After", strconv.Itoa(int((retry.RetryAfter()+time.Second-1)/time.Second)))
+					}
+					http.Error(w, err.Error(), http.StatusTooManyRequests)
+					return
+				}
+			}
+			c, err := v.Verify(token)
+			if err != nil {
+				if g != nil && !errors.Is(err, ErrExpiredToken) && !errors.Is(err, ErrRevokedToken) {
+					g.Failed(r.Context(), subject, ip)
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if g != nil {
+				g.Succeeded(r.Context(), c.Subject, ip)
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), c)))
+		})
+	}
+}
+
+// RequireRole rejects anonymous callers with 401 and callers without role
+// with 403. It must run after Authenticate.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, ok := PrincipalFrom(r.Context())
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "authentication required", http.StatusUnauthorized)
+				return
+			}
+			if !c.HasRole(role) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func tokenFrom(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if t, ok := strings.CutPrefix(h, "Bearer "); ok {
+			return strings.TrimSpace(t)
+		}
+	}
+	if c, err := r.Cookie(CookieName); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// claimedSubject is the sub claim of a JWT, unverified: who the token
+// says it is for, whether or not it is.
+func claimedSubject(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var c Claims
+	if json.Unmarshal(raw, &c) != nil {
+		return ""
+	}
+	return c.Subject
+}
+
+// clientIP is r's peer address: there is no trusted proxy to take
+// X-Forwarded-For from.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+)
+
+// Scopes limit what a token may do beyond its roles: a service
+// account's token carries those it was granted (see package
+// serviceaccount), a user's none.
+const (
+	ScopeUsersRead     = "users:read"
+	ScopeUsersWrite    = "users:write"
+	ScopeProductsRead  = "products:read"
+	ScopeProductsWrite = "products:write"
+	// ScopeAdmin covers every other scope. It is not an admin's role:
+	// admin-only routes stay closed to service accounts.
+	ScopeAdmin = "admin"
+)
+
+var knownScopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeProductsRead, ScopeProductsWrite, ScopeAdmin}
+
+// KnownScope reports whether s is one of the scopes above.
+func KnownScope(s string) bool { return slices.Contains(knownScopes, s) }
+
+// Scopes is c.Scope split into its scopes.
+func (c Claims) Scopes() []string { return strings.Fields(c.Scope) }
+
+// Allows reports whether c's scopes cover scope: ScopeAdmin covers every
+// scope, and a resource's write scope covers its read scope.
+func (c Claims) Allows(scope string) bool {
+	have := c.Scopes()
+	if slices.Contains(have, scope) || slices.Contains(have, ScopeAdmin) {
+		return true
+	}
+	resource, ok := strings.CutSuffix(scope, ":read")
+	return ok && slices.Contains(have, resource+":write")
+}
@@ -0,0 +1,258 @@
+// Package avatar stores users' profile images.
+//
+// Uploads are checked and normalized once, on the way in: the bytes must
+// sniff as JPEG, PNG or GIF (the declared type is not trusted), the
+// header's dimensions are checked before anything is decoded (a small
+// PNG can declare a 100000×100000 canvas), and the image is cropped to a
+// centred square and scaled to one fixed size. Downloads are then small,
+// uniform and served straight from the blob store. Opaque images are
+// stored as JPEG, images with transparency as PNG; a GIF keeps only its
+// first frame.
+//
+// An Avatars is also a privacy.Holder ("avatars") and a
+// dedupe.Reassigner, and Subscribe removes a user's image when the user
+// is deleted.
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"Go-Internals/blobstore"
+	"Go-Internals/eventbus"
+	"Go-Internals/i18n"
+	"Go-Internals/users"
+)
+
+var (
+	ErrTooLarge    = errors.New("avatar: image is too large")
+	ErrUnsupported = errors.New("avatar: not a JPEG, PNG or GIF image")
+	ErrInvalid     = errors.New("avatar: image cannot be decoded")
+	ErrNotFound    = errors.New("avatar: user has no avatar")
+)
+
+// Options configures New.
+type Options struct {
+	Store blobstore.Store
+	// Size is the edge of the stored square, in pixels; default 256.
+	Size int
+	// MaxBytes bounds an upload; default 5 MiB.
+	MaxBytes int64
+	// MaxPixels bounds the source image's width × height, which is what
+	// decoding costs in memory (4 bytes each); default 24 million.
+	MaxPixels int
+	// Quality is the JPEG quality of stored images; default 85.
+	Quality int
+	// Logger reports failed clean-ups; default slog.Default().
+	Logger *slog.Logger
+}
+
+// Avatars is safe for concurrent use.
+type Avatars struct {
+	opts Options
+}
+
+func New(opts Options) *Avatars {
+	if opts.Size <= 0 {
+		opts.Size = 256
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 5 << 20
+	}
+	if opts.MaxPixels <= 0 {
+		opts.MaxPixels = 24_000_000
+	}
+	if opts.Quality <= 0 {
+		opts.Quality = 85
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &Avatars{opts: opts}
+}
+
+// MaxBytes is the largest upload Set accepts.
+func (a *Avatars) MaxBytes() int64 { return a.opts.MaxBytes }
+
+// CheckSize fails with ErrTooLarge if an upload of n bytes is, so a
+// client declaring its size can be turned away before sending it.
+func (a *Avatars) CheckSize(n int64) error {
+	if n > a.opts.MaxBytes {
+		return i18n.Wrap(ErrTooLarge, "avatar.too_large", i18n.Params{"max": strconv.FormatInt(a.opts.MaxBytes>>10, 10) + " KiB"})
+	}
+	return nil
+}
+
+// Key is where a user's avatar is kept in the blob store.
+func Key(id int) string { return "avatars/" + strconv.Itoa(id) }
+
+// accepted are the sniffed types Set takes.
+var accepted = map[string]bool{"image/jpeg": true, "image/png": true, "image/gif": true}
+
+// Set reads an image from r, normalizes it and stores it as the user's
+// avatar, replacing any previous one. It does not check that the user
+// exists.
+func (a *Avatars) Set(ctx context.Context, id int, r io.Reader) (blobstore.Info, error) {
+	data, err := io.ReadAll(io.LimitReader(r, a.opts.MaxBytes+1))
+	if err != nil {
+		return blobstore.Info{}, err
+	}
+	if err := a.CheckSize(int64(len(data))); err != nil {
+		return blobstore.Info{}, err
+	}
+	if ctype := http.DetectContentType(data); !accepted[ctype] {
+		return blobstore.Info{}, i18n.Wrap(ErrUnsupported, "avatar.unsupported", i18n.Params{"type": ctype})
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return blobstore.Info{}, i18n.Wrap(ErrInvalid, "avatar.invalid", nil)
+	}
+	if cfg.Width > a.opts.MaxPixels/cfg.Height {
+		return blobstore.Info{}, i18n.Wrap(ErrTooLarge, "avatar.too_many_pixels", i18n.Params{"width": cfg.Width, "height": cfg.Height})
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return blobstore.Info{}, i18n.Wrap(ErrInvalid, "avatar.invalid", nil)
+	}
+	if err := ctx.Err(); err != nil {
+		return blobstore.Info{}, err
+	}
+
+	img := Fit(src, a.opts.Size)
+	var out bytes.Buffer
+	ctype := "image/jpeg"
+	if img.Opaque() {
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: a.opts.Quality})
+	} else {
+		ctype = "image/png"
+		err = png.Encode(&out, img)
+	}
+	if err != nil {
+		return blobstore.Info{}, err
+	}
+	return a.opts.Store.Put(ctx, Key(id), &out, ctype)
+}
+
+// URL is where the image is served, the user's AvatarURL: v changes with
+// every upload, so a URL whose v matches can be cached for good.
+func URL(id int, info blobstore.Info) string {
+	return fmt.Sprintf("/users/%d/avatar?v=%s", id, Version(info))
+}
+
+// Version is URL's v for the image.
+func Version(info blobstore.Info) string { return info.ETag[:min(16, len(info.ETag))] }
+
+// Open returns the user's avatar; the caller closes it.
+func (a *Avatars) Open(ctx context.Context, id int) (io.ReadCloser, blobstore.Info, error) {
+	rc, info, err := a.opts.Store.Get(ctx, Key(id))
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, blobstore.Info{}, i18n.Wrap(ErrNotFound, "avatar.not_found", nil)
+	}
+	return rc, info, err
+}
+
+// Delete removes the user's avatar, if any.
+func (a *Avatars) Delete(ctx context.Context, id int) error {
+	return a.opts.Store.Delete(ctx, Key(id))
+}
+
+// Subscribe deletes a user's avatar when the service publishes their
+// deletion, so removed users leave no image behind.
+func (a *Avatars) Subscribe(bus *eventbus.Bus) error {
+	_, err := bus.Subscribe(users.TopicUserDeleted, func(ev eventbus.Event) {
+		u, ok := ev.Payload.(users.User)
+		if !ok {
+			return
+		}
+		if err := a.Delete(context.Background(), u.ID); err != nil {
+			a.opts.Logger.Warn("avatar clean-up failed", "user", u.ID, "err", err)
+		}
+	}, eventbus.SubscribeOptions{})
+	return err
+}
+
+/*
+-----------------------------------
+PRIVACY
+-----------------------------------
+*/
+
+func (a *Avatars) Name() string { return "avatars" }
+
+// Export describes the stored image rather than embedding it; the
+// archive's profile has the URL to fetch it from.
+func (a *Avatars) Export(ctx context.Context, id int) (any, error) {
+	rc, info, err := a.opts.Store.Get(ctx, Key(id))
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc.Close()
+	return info, nil
+}
+
+func (a *Avatars) Erase(ctx context.Context, id int) (int, error) {
+	rc, _, err := a.opts.Store.Get(ctx, Key(id))
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	rc.Close()
+	if err := a.Delete(ctx, id); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+/*
+-----------------------------------
+MERGES
+-----------------------------------
+*/
+
+// Reassign gives keep the merged user's image if keep has none, pointing
+// keep's AvatarURL at it, and reports 1; with dryRun it sets only the
+// URL. If keep has an image of its own, theirs stays and the merged
+// user's goes when the user is deleted (see Subscribe). It makes Avatars
+// a dedupe.Reassigner.
+func (a *Avatars) Reassign(ctx context.Context, keep *users.User, merged users.User, dryRun bool) (int, error) {
+	if rc, _, err := a.opts.Store.Get(ctx, Key(keep.ID)); err == nil {
+		rc.Close()
+		return 0, nil
+	} else if !errors.Is(err, blobstore.ErrNotFound) {
+		return 0, err
+	}
+	rc, info, err := a.opts.Store.Get(ctx, Key(merged.ID))
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	if dryRun {
+		// The ETag is of the content, so the copy would have the same.
+		keep.AvatarURL = URL(keep.ID, info)
+		return 1, nil
+	}
+	info, err = a.opts.Store.Put(ctx, Key(keep.ID), rc, info.ContentType)
+	if err != nil {
+		return 0, err
+	}
+	keep.AvatarURL = URL(keep.ID, info)
+	return 1, a.Delete(ctx, merged.ID)
+}
@@ -0,0 +1,325 @@
+package avro
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Codec is a block compression codec.
+type Codec string
+
+const (
+	CodecNull    Codec = "null"
+	CodecDeflate Codec = "deflate"
+)
+
+var magic = []byte{'O', 'b', 'j', 1}
+
+const syncSize = 16
+
+/*
+-----------------------------------
+WRITER
+-----------------------------------
+*/
+
+// Options configures NewWriter.
+type Options struct {
+	Codec Codec // default CodecDeflate
+	// Level is the deflate level, flate.BestSpeed to flate.BestCompression;
+	// default flate.DefaultCompression.
+	Level int
+	// BlockRecords and BlockBytes bound a block: it is written when either
+	// is reached. Defaults 4096 records and 1 MiB (before compression).
+	// Smaller blocks cost compression ratio and buy readers more places
+	// to split a file.
+	BlockRecords int
+	BlockBytes   int
+	// Meta is extra header metadata; keys starting with "avro." are
+	// reserved.
+	Meta map[string]string
+}
+
+// Writer writes one container file. It buffers one block at a time, so
+// memory use is bounded by BlockBytes regardless of the file's size.
+type Writer struct {
+	w      io.Writer
+	schema Schema
+	opts   Options
+	sync   [syncSize]byte
+
+	block   []byte
+	records int
+	total   int64
+	zbuf    bytes.Buffer
+	zw      *flate.Writer
+	err     error
+}
+
+// NewWriter writes the header to w and returns a Writer for records of
+// schema.
+func NewWriter(w io.Writer, schema Schema, opts Options) (*Writer, error) {
+	if opts.Codec == "" {
+		opts.Codec = CodecDeflate
+	}
+	if opts.Codec != CodecNull && opts.Codec != CodecDeflate {
+		return nil, fmt.Errorf("%w: %q", ErrBadCodec, opts.Codec)
+	}
+	if opts.Level == 0 {
+		opts.Level = flate.DefaultCompression
+	}
+	if opts.BlockRecords <= 0 {
+		opts.BlockRecords = 4096
+	}
+	if opts.BlockBytes <= 0 {
+		opts.BlockBytes = 1 << 20
+	}
+	js, err := schema.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	aw := &Writer{w: w, schema: schema, opts: opts}
+	if _, err := rand.Read(aw.sync[:]); err != nil {
+		return nil, err
+	}
+	if opts.Codec == CodecDeflate {
+		if aw.zw, err = flate.NewWriter(&aw.zbuf, opts.Level); err != nil {
+			return nil, err
+		}
+	}
+
+	meta := map[string]string{"avro.schema": string(js), "avro.codec": string(opts.Codec)}
+	for k, v := range opts.Meta {
+		if _, reserved := meta[k]; !reserved {
+			meta[k] = v
+		}
+	}
+	hdr := append([]byte(nil), magic...)
+	if hdr, err = encodeMap(hdr, String, meta); err != nil {
+		return nil, err
+	}
+	hdr = append(hdr, aw.sync[:]...)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+// Append adds one record; values are in schema field order.
+func (w *Writer) Append(values ...any) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(values) != len(w.schema.Fields) {
+		return ErrFieldCount
+	}
+	mark := len(w.block)
+	for i, f := range w.schema.Fields {
+		b, err := encode(w.block, f.Type, values[i])
+		if err != nil {
+			w.block = w.block[:mark]
+			return fmt.Errorf("%w: field %s (%T)", err, f.Name, values[i])
+		}
+		w.block = b
+	}
+	w.records++
+	if w.records >= w.opts.BlockRecords || len(w.block) >= w.opts.BlockBytes {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush writes the buffered records as a block.
+func (w *Writer) Flush() error {
+	if w.err != nil || w.records == 0 {
+		return w.err
+	}
+	data := w.block
+	if w.zw != nil {
+		w.zbuf.Reset()
+		w.zw.Reset(&w.zbuf)
+		if _, err := w.zw.Write(data); err != nil {
+			w.err = err
+			return err
+		}
+		if err := w.zw.Close(); err != nil {
+			w.err = err
+			return err
+		}
+		data = w.zbuf.Bytes()
+	}
+	hdr := appendLong(appendLong(nil, int64(w.records)), int64(len(data)))
+	for _, b := range [][]byte{hdr, data, w.sync[:]} {
+		if _, err := w.w.Write(b); err != nil {
+			w.err = err
+			return err
+		}
+	}
+	w.total += int64(w.records)
+	w.block, w.records = w.block[:0], 0
+	return nil
+}
+
+// Records is how many records have been appended.
+func (w *Writer) Records() int64 { return w.total + int64(w.records) }
+
+// Close flushes the last block. It does not close the underlying writer.
+func (w *Writer) Close() error { return w.Flush() }
+
+/*
+-----------------------------------
+READER
+-----------------------------------
+*/
+
+// Reader reads a container file record by record.
+type Reader struct {
+	r      *bufio.Reader
+	schema Schema
+	codec  Codec
+	meta   map[string]string
+	sync   [syncSize]byte
+
+	dec     decoder
+	pending int64
+	zr      io.ReadCloser
+}
+
+// NewReader reads the header from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, head); err != nil || !bytes.Equal(head, magic) {
+		return nil, ErrNotAvro
+	}
+	meta := map[string]string{}
+	for {
+		n, err := binary.ReadVarint(br)
+		if err != nil {
+			return nil, ErrNotAvro
+		}
+		if n == 0 {
+			break
+		}
+		if n < 0 {
+			n = -n
+			if _, err := binary.ReadVarint(br); err != nil {
+				return nil, ErrNotAvro
+			}
+		}
+		for ; n > 0; n-- {
+			k, err := readBytes(br)
+			if err != nil {
+				return nil, ErrNotAvro
+			}
+			v, err := readBytes(br)
+			if err != nil {
+				return nil, ErrNotAvro
+			}
+			meta[string(k)] = string(v)
+		}
+	}
+	ar := &Reader{r: br, meta: meta, codec: Codec(meta["avro.codec"])}
+	if _, err := io.ReadFull(br, ar.sync[:]); err != nil {
+		return nil, ErrNotAvro
+	}
+	if ar.codec == "" {
+		ar.codec = CodecNull
+	}
+	if ar.codec != CodecNull && ar.codec != CodecDeflate {
+		return nil, fmt.Errorf("%w: %q", ErrBadCodec, ar.codec)
+	}
+	var err error
+	if ar.schema, err = ParseSchema([]byte(meta["avro.schema"])); err != nil {
+		return nil, err
+	}
+	return ar, nil
+}
+
+func (r *Reader) Schema() Schema { return r.schema }
+func (r *Reader) Codec() Codec   { return r.codec }
+
+// Meta returns a header metadata value.
+func (r *Reader) Meta(key string) string { return r.meta[key] }
+
+// Next returns the next record's values in field order, or io.EOF.
+func (r *Reader) Next() ([]any, error) {
+	for r.pending == 0 {
+		if err := r.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	values := make([]any, len(r.schema.Fields))
+	for i, f := range r.schema.Fields {
+		values[i] = r.dec.value(f.Type)
+	}
+	if r.dec.err != nil {
+		return nil, r.dec.err
+	}
+	r.pending--
+	return values, nil
+}
+
+// Record is Next keyed by field name.
+func (r *Reader) Record() (map[string]any, error) {
+	values, err := r.Next()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]any, len(values))
+	for i, f := range r.schema.Fields {
+		m[f.Name] = values[i]
+	}
+	return m, nil
+}
+
+func (r *Reader) readBlock() error {
+	br := r.r
+	count, err := binary.ReadVarint(br)
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil || count < 0 {
+		return ErrCorrupt
+	}
+	size, err := binary.ReadVarint(br)
+	if err != nil || size < 0 || size > 1<<30 {
+		return ErrCorrupt
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return ErrCorrupt
+	}
+	var sync [syncSize]byte
+	if _, err := io.ReadFull(br, sync[:]); err != nil || sync != r.sync {
+		return fmt.Errorf("%w: sync marker mismatch", ErrCorrupt)
+	}
+	if r.codec == CodecDeflate {
+		if r.zr == nil {
+			r.zr = flate.NewReader(bytes.NewReader(data))
+		} else {
+			r.zr.(flate.Resetter).Reset(bytes.NewReader(data), nil)
+		}
+		if data, err = io.ReadAll(r.zr); err != nil {
+			return fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+	}
+	r.dec = decoder{b: data}
+	r.pending = count
+	return nil
+}
+
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadVarint(r)
+	if err != nil || n < 0 || n > 1<<24 {
+		return nil, ErrCorrupt
+	}
+	p := make([]byte, n)
+	_, err = io.ReadFull(r, p)
+	return p, err
+}
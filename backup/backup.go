@@ -0,0 +1,619 @@
+// Package backup writes and restores logical backups of a user repository.
+//
+// A backup is a directory of numbered archives forming chains: a full
+// archive holds every user, and each incremental archive after it holds
+// only what changed since the archive before it (puts and deletes). The
+// chain for restore point N is the newest full archive at or before N plus
+// every incremental up to N. Backups are logical (users, not files), so
+// they restore into any backend and survive format changes.
+//
+// Upload copies a backup directory to a blob store (another disk, or an
+// S3 bucket) and Fetch brings it back, so the archives can outlive the
+// machine they were taken on.
+//
+// Archive format, gzip-compressed JSON lines:
+//
+//	{"format":"usersbak","version":1,"kind":"full","seq":1,"parent":0,...}   header
+//	{"put":{...user...}}  or  {"del":42}                                     entries
+//	{"end":true,"records":2,"sha256":"..."}                                  trailer
+//
+// The trailer's SHA-256 covers every line before it, so truncation and
+// bit rot are both caught (Verify). With a keyring (package atrest) the
+// compressed archive is sealed whole, so a backup is no weaker than an
+// encrypted store.
+//
+// Incrementals are found by comparing every user's digest with the state
+// the chain so far describes. That still reads the whole repository, but
+// writes only the difference, and it needs nothing from the backend
+// beyond Iterate. Archives are written to a temporary name, fsynced and
+// renamed, so a crash never leaves a partial archive in the chain.
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"Go-Internals/atrest"
+	"Go-Internals/bandwidth"
+	"Go-Internals/blobstore"
+	"Go-Internals/users"
+)
+
+const (
+	format  = "usersbak"
+	version = 1
+	ext     = ".bak"
+)
+
+// Kind is full or incremental.
+type Kind string
+
+const (
+	Full        Kind = "full"
+	Incremental Kind = "incremental"
+)
+
+var (
+	ErrCorrupt  = errors.New("backup: archive is damaged")
+	ErrNoChain  = errors.New("backup: no full backup to build on")
+	ErrNotEmpty = errors.New("backup: restore target is not empty")
+	ErrNoKeys   = errors.New("backup: archive is encrypted and no keyring is configured")
+	ErrBadKind  = errors.New("backup: kind must be full or incremental")
+)
+
+// Info describes one archive.
+type Info struct {
+	Path    string    `json:"-"`
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Kind    Kind      `json:"kind"`
+	Seq     uint64    `json:"seq"`
+	Parent  uint64    `json:"parent"` // previous archive in the chain, 0 for full
+	Created time.Time `json:"created"`
+	Store   string    `json:"store,omitempty"` // backend it was taken from, informational
+	Records int       `json:"-"`               // from the trailer
+}
+
+type entry struct {
+	Put *users.User `json:"put,omitempty"`
+	Del int         `json:"del,omitempty"`
+}
+
+type trailer struct {
+	End     bool   `json:"end"`
+	Records int    `json:"records"`
+	SHA256  string `json:"sha256"`
+}
+
+// Options configures Create and Restore.
+type Options struct {
+	Kind  Kind   // default Full
+	Store string // recorded in the header
+	Keys  *atrest.Keyring
+	Now   func() time.Time // default time.Now
+}
+
+/*
+-----------------------------------
+CREATE
+-----------------------------------
+*/
+
+// Create writes the next archive in dir from repo. An incremental needs
+// an existing chain (ErrNoChain otherwise). It returns the new archive's
+// Info.
+func Create(ctx context.Context, dir string, repo users.UserRepository, opts Options) (Info, error) {
+	if opts.Kind == "" {
+		opts.Kind = Full
+	}
+	if opts.Kind != Full && opts.Kind != Incremental {
+		return Info{}, ErrBadKind
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Info{}, err
+	}
+	all, err := List(dir)
+	if err != nil {
+		return Info{}, err
+	}
+
+	info := Info{Format: format, Version: version, Kind: opts.Kind, Created: opts.Now().UTC(), Store: opts.Store}
+	if len(all) > 0 {
+		info.Seq = all[len(all)-1].Seq
+	}
+	info.Seq++
+
+	var base map[int]uint64 // digests of the chain's final state
+	if opts.Kind == Incremental {
+		if len(all) == 0 {
+			return Info{}, ErrNoChain
+		}
+		info.Parent = all[len(all)-1].Seq
+		state, err := replay(dir, info.Parent, opts.Keys)
+		if err != nil {
+			return Info{}, err
+		}
+		base = make(map[int]uint64, len(state))
+		for id, u := range state {
+			base[id] = digest(u)
+		}
+	}
+
+	var w archiveWriter
+	if err := w.begin(info); err != nil {
+		return Info{}, err
+	}
+	seen := make(map[int]bool)
+	for u, err := range repo.Iterate(ctx, users.IterateOptions{}) {
+		if err != nil {
+			return Info{}, err
+		}
+		seen[u.ID] = true
+		if d, ok := base[u.ID]; ok && d == digest(u) {
+			continue
+		}
+		if err := w.add(entry{Put: &u}); err != nil {
+			return Info{}, err
+		}
+	}
+	var gone []int
+	for id := range base {
+		if !seen[id] {
+			gone = append(gone, id)
+		}
+	}
+	slices.Sort(gone)
+	for _, id := range gone {
+		if err := w.add(entry{Del: id}); err != nil {
+			return Info{}, err
+		}
+	}
+
+	data, err := w.finish()
+	if err != nil {
+		return Info{}, err
+	}
+	if opts.Keys != nil {
+		if data, err = opts.Keys.Seal(data, []byte(format)); err != nil {
+			return Info{}, err
+		}
+	}
+	info.Path = filepath.Join(dir, fileName(info))
+	info.Records = w.n
+	return info, writeFileSync(info.Path, bytes.NewReader(data))
+}
+
+// digest identifies a user's content, to notice changes between backups.
+func digest(u users.User) uint64 {
+	b, _ := json.Marshal(u)
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+func fileName(info Info) string {
+	return fmt.Sprintf("%06d-%s%s", info.Seq, info.Kind, ext)
+}
+
+// archiveWriter builds the compressed archive in memory, hashing every
+// line for the trailer.
+type archiveWriter struct {
+	buf bytes.Buffer
+	gz  *gzip.Writer
+	sum hashWriter
+	n   int
+}
+
+type hashWriter struct {
+	w io.Writer
+	h interface {
+		io.Writer
+		Sum([]byte) []byte
+	}
+}
+
+func (h hashWriter) Write(p []byte) (int, error) {
+	h.h.Write(p)
+	return h.w.Write(p)
+}
+
+func (w *archiveWriter) begin(info Info) error {
+	w.gz = gzip.NewWriter(&w.buf)
+	w.sum = hashWriter{w: w.gz, h: sha256.New()}
+	return w.line(info)
+}
+
+func (w *archiveWriter) add(e entry) error {
+	w.n++
+	return w.line(e)
+}
+
+func (w *archiveWriter) line(v any) error {
+	return json.NewEncoder(w.sum).Encode(v)
+}
+
+func (w *archiveWriter) finish() ([]byte, error) {
+	t := trailer{End: true, Records: w.n, SHA256: hex.EncodeToString(w.sum.h.Sum(nil))}
+	if err := json.NewEncoder(w.gz).Encode(t); err != nil {
+		return nil, err
+	}
+	if err := w.gz.Close(); err != nil {
+		return nil, err
+	}
+	return w.buf.Bytes(), nil
+}
+
+func writeFileSync(path string, r io.Reader) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // no-op after the rename
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+/*
+-----------------------------------
+READ & VERIFY
+-----------------------------------
+*/
+
+// List returns the archives in dir by sequence, reading only their
+// names. An archive is listed even if it is damaged; Verify finds out.
+func List(dir string) ([]Info, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+ext))
+	if err != nil {
+		return nil, err
+	}
+	var out []Info
+	for _, p := range names {
+		seqStr, kind, ok := strings.Cut(strings.TrimSuffix(filepath.Base(p), ext), "-")
+		seq, err := strconv.ParseUint(seqStr, 10, 64)
+		if !ok || err != nil || (Kind(kind) != Full && Kind(kind) != Incremental) {
+			continue
+		}
+		out = append(out, Info{Path: p, Seq: seq, Kind: Kind(kind)})
+	}
+	slices.SortFunc(out, func(a, b Info) int { return cmp.Compare(a.Seq, b.Seq) })
+	return out, nil
+}
+
+// Verify reads a whole archive and checks its checksum and structure.
+func Verify(path string, keys *atrest.Keyring) (Info, error) {
+	return read(path, keys, func(entry) error { return nil })
+}
+
+// read streams the entries of one archive to fn and returns its header.
+// fn's effects must be discarded if read fails: the checksum is only
+// known at the end.
+func read(path string, keys *atrest.Keyring, fn func(entry) error) (Info, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Info{}, err
+	}
+	if atrest.IsSealed(data) {
+		if keys == nil {
+			return Info{}, ErrNoKeys
+		}
+		if data, err = keys.Open(data, []byte(format)); err != nil {
+			return Info{}, fmt.Errorf("%w: %s: %w", ErrCorrupt, path, err)
+		}
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return Info{}, fmt.Errorf("%w: %s: %w", ErrCorrupt, path, err)
+	}
+	corrupt := func(why string) error { return fmt.Errorf("%w: %s: %s", ErrCorrupt, path, why) }
+
+	sc := bufio.NewScanner(gz)
+	sc.Buffer(nil, 1<<20)
+	sum := sha256.New()
+	var info Info
+	n := 0
+	for first := true; sc.Scan(); first = false {
+		line := sc.Bytes()
+		if first {
+			if err := json.Unmarshal(line, &info); err != nil || info.Format != format {
+				return Info{}, corrupt("not a backup archive")
+			}
+			if info.Version != version {
+				return Info{}, corrupt("unsupported version " + strconv.Itoa(info.Version))
+			}
+			sum.Write(line)
+			sum.Write([]byte{'\n'})
+			continue
+		}
+		var t trailer
+		if json.Unmarshal(line, &t) == nil && t.End {
+			if t.Records != n || t.SHA256 != hex.EncodeToString(sum.Sum(nil)) {
+				return Info{}, corrupt("checksum mismatch")
+			}
+			if sc.Scan() {
+				return Info{}, corrupt("data after trailer")
+			}
+			info.Path, info.Records = path, n
+			return info, nil
+		}
+		var e entry
+		if err := json.Unmarshal(line, &e); err != nil || (e.Put == nil) == (e.Del == 0) {
+			return Info{}, corrupt("bad entry")
+		}
+		sum.Write(line)
+		sum.Write([]byte{'\n'})
+		n++
+		if err := fn(e); err != nil {
+			return Info{}, err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return Info{}, fmt.Errorf("%w: %s: %w", ErrCorrupt, path, err)
+	}
+	return Info{}, corrupt("truncated (no trailer)")
+}
+
+// Chain returns the archives needed to restore to upto (0 = the latest),
+// verifying that each links to the one before it.
+func Chain(dir string, upto uint64) ([]Info, error) {
+	all, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	start := -1
+	for i, a := range all {
+		if upto != 0 && a.Seq > upto {
+			break
+		}
+		if a.Kind == Full {
+			start = i
+		}
+	}
+	if start < 0 {
+		return nil, ErrNoChain
+	}
+	chain := []Info{all[start]}
+	for _, a := range all[start+1:] {
+		if upto != 0 && a.Seq > upto {
+			break
+		}
+		if a.Kind == Full {
+			break // a later full starts a new chain, which upto stops short of
+		}
+		chain = append(chain, a)
+	}
+	if upto != 0 && chain[len(chain)-1].Seq != upto {
+		return nil, fmt.Errorf("backup: no archive %d in %s", upto, dir)
+	}
+	return chain, nil
+}
+
+// replay reads the chain up to upto and returns the users it describes.
+func replay(dir string, upto uint64, keys *atrest.Keyring) (map[int]users.User, error) {
+	chain, err := Chain(dir, upto)
+	if err != nil {
+		return nil, err
+	}
+	state := make(map[int]users.User)
+	var prev uint64
+	for _, a := range chain {
+		changes := make(map[int]*users.User)
+		info, err := read(a.Path, keys, func(e entry) error {
+			if e.Put != nil {
+				changes[e.Put.ID] = e.Put
+			} else {
+				changes[e.Del] = nil
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if info.Seq != a.Seq || info.Kind != a.Kind || (info.Kind == Incremental && info.Parent != prev) {
+			return nil, fmt.Errorf("%w: %s: header does not match its place in the chain", ErrCorrupt, a.Path)
+		}
+		for id, u := range changes {
+			if u == nil {
+				delete(state, id)
+			} else {
+				state[id] = *u
+			}
+		}
+		prev = info.Seq
+	}
+	return state, nil
+}
+
+/*
+-----------------------------------
+RESTORE
+-----------------------------------
+*/
+
+// Target is a repository that can take records with their own IDs.
+type Target interface {
+	users.UserRepository
+	users.Restorer
+}
+
+// Restore loads the chain up to upto (0 = latest) into repo, which must be
+// empty: merging a backup into live data would silently pick winners.
+// Every archive is verified before the first user is written. It returns
+// how many users were restored.
+func Restore(ctx context.Context, dir string, upto uint64, repo Target, opts Options) (int, error) {
+	if len(repo.List()) > 0 {
+		return 0, ErrNotEmpty
+	}
+	state, err := replay(dir, upto, opts.Keys)
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]int, 0, len(state))
+	for id := range state {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for n, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if err := repo.Restore(state[id]); err != nil {
+			return n, fmt.Errorf("backup: restore user %d: %w", id, err)
+		}
+	}
+	return len(ids), nil
+}
+
+/*
+-----------------------------------
+OFFSITE COPIES
+-----------------------------------
+*/
+
+// manifestName lists the uploaded archives: blob stores are not listed,
+// so Fetch reads it to know what there is.
+const manifestName = "manifest.json"
+
+type manifest struct {
+	Archives []string `json:"archives"`
+}
+
+// Upload copies the archives in dir that the store does not have yet to
+// store under prefix ("backups/"), streaming each from disk, then writes
+// the manifest naming all of them. Archives never change once written,
+// so one the manifest already lists is skipped. bw, if not nil, caps
+// the transfer rate. It returns how many were uploaded.
+func Upload(ctx context.Context, dir string, store blobstore.Store, prefix string, bw *bandwidth.Limiter) (int, error) {
+	all, err := List(dir)
+	if err != nil {
+		return 0, err
+	}
+	m, err := readManifest(ctx, store, prefix)
+	if err != nil {
+		return 0, err
+	}
+	have := make(map[string]bool, len(m.Archives))
+	for _, name := range m.Archives {
+		have[name] = true
+	}
+	n := 0
+	for _, a := range all {
+		name := filepath.Base(a.Path)
+		if have[name] {
+			continue
+		}
+		if err := uploadFile(ctx, store, prefix+name, a.Path, bw); err != nil {
+			return n, fmt.Errorf("backup: upload %s: %w", name, err)
+		}
+		m.Archives = append(m.Archives, name)
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	slices.Sort(m.Archives)
+	data, err := json.Marshal(m)
+	if err != nil {
+		return n, err
+	}
+	_, err = store.Put(ctx, prefix+manifestName, bytes.NewReader(data), "application/json")
+	return n, err
+}
+
+func uploadFile(ctx context.Context, store blobstore.Store, key, path string, bw *bandwidth.Limiter) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = store.Put(ctx, key, bandwidth.Reader(ctx, f, bw), "application/octet-stream")
+	return err
+}
+
+// Fetch downloads the archives under prefix that dir does not have, so
+// Restore can run from them. Each is written to a temporary name and
+// renamed, like Create's; Restore verifies them before use. bw, if not
+// nil, caps the transfer rate.
+func Fetch(ctx context.Context, store blobstore.Store, prefix, dir string, bw *bandwidth.Limiter) (int, error) {
+	m, err := readManifest(ctx, store, prefix)
+	if err != nil {
+		return 0, err
+	}
+	if len(m.Archives) == 0 {
+		return 0, fmt.Errorf("backup: no archives under %q", prefix)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, name := range m.Archives {
+		path := filepath.Join(dir, filepath.Base(name))
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		rc, _, err := store.Get(ctx, prefix+name)
+		if err != nil {
+			return n, fmt.Errorf("backup: fetch %s: %w", name, err)
+		}
+		err = writeFileSync(path, bandwidth.Reader(ctx, rc, bw))
+		rc.Close()
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func readManifest(ctx context.Context, store blobstore.Store, prefix string) (manifest, error) {
+	var m manifest
+	rc, _, err := store.Get(ctx, prefix+manifestName)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		return m, fmt.Errorf("backup: %s%s: %w", prefix, manifestName, err)
+	}
+	return m, nil
+}
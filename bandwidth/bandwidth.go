@@ -0,0 +1,266 @@
+// Package bandwidth caps how fast bytes move through a stream, so one
+// client pulling an export, or one backup going offsite, cannot take the
+// whole link.
+//
+// A Limiter is a token bucket in bytes. Streams are wrapped with Reader
+// or Writer and any number of limiters, and every Read or Write waits on
+// each of them: pass the process-wide limiter and the connection's own
+// (from a Pool) and a stream gets whichever share is smaller. Bytes move
+// in pieces of at most 32 KiB, so a large Write is spread out rather
+// than let through in one burst and then paid for with a long stall.
+//
+// A Limiter also measures: Stats reports the bytes through it, the rate
+// over the last ten seconds and how long streams were held back, whether
+// or not it limits anything.
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Go-Internals/clock"
+	"Go-Internals/window"
+)
+
+// piece is the most a Reader or Writer moves between waits.
+const piece = 32 << 10
+
+// Limiter is safe for concurrent use. A nil *Limiter neither limits nor
+// measures.
+type Limiter struct {
+	rate  float64 // bytes per second; 0 does not limit
+	burst float64
+	clk   clock.Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	recent *window.Counter // bytes, over the last 10s
+	total  atomic.Int64
+	waited atomic.Int64 // nanoseconds
+	parent *Limiter     // also measures what this one does (a Pool's sum)
+}
+
+// New allows bytesPerSec, or any rate if it is 0 (to only measure). The
+// bucket holds a tenth of a second of budget, or one piece if that is
+// more, so a stream starting after a pause bursts only briefly. clk may
+// be nil.
+func New(bytesPerSec int64, clk clock.Clock) *Limiter {
+	clk = clock.OrReal(clk)
+	rate := float64(max(bytesPerSec, 0))
+	burst := max(rate/10, piece)
+	return &Limiter{
+		rate: rate, burst: burst, clk: clk,
+		tokens: burst, last: clk.Now(),
+		recent: window.New(10*time.Second, 10, clk),
+	}
+}
+
+// Wait blocks until n more bytes may move, or ctx is done, and counts
+// them. Tokens are taken up front, so concurrent callers queue up behind
+// the debt instead of all waking at once.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	if l == nil {
+		return ctx.Err()
+	}
+	l.count(n)
+	if l.rate <= 0 {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := l.clk.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	for m := l; m != nil; m = m.parent {
+		m.waited.Add(int64(wait))
+	}
+	timer := l.clk.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Limiter) count(n int) {
+	for m := l; m != nil; m = m.parent {
+		m.total.Add(int64(n))
+		m.recent.Add(uint32(n))
+	}
+}
+
+// Stats is a Limiter's measurements.
+type Stats struct {
+	Limit     int64   `json:"limit"` // bytes per second; 0 is unlimited
+	Total     int64   `json:"total"`
+	PerSecond float64 `json:"per_second"` // over the last ten seconds
+	// Waited is how long streams were held back, summed over streams.
+	Waited time.Duration `json:"waited"`
+	// Active is how many connections hold a Pool's limiters.
+	Active int `json:"active,omitempty"`
+}
+
+func (l *Limiter) Stats() Stats {
+	if l == nil {
+		return Stats{}
+	}
+	return Stats{
+		Limit:     int64(l.rate),
+		Total:     l.total.Load(),
+		PerSecond: float64(l.recent.Sum()) / l.recent.Window().Seconds(),
+		Waited:    time.Duration(l.waited.Load()),
+	}
+}
+
+/*
+-----------------------------------
+STREAMS
+-----------------------------------
+*/
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	ls  []*Limiter
+}
+
+// Reader returns r limited by ls; nil limiters are skipped. Each Read
+// reads at most one piece and then waits for what it got, so the stream
+// runs at the limit on average. Reads fail with ctx's error once it is
+// done.
+func Reader(ctx context.Context, r io.Reader, ls ...*Limiter) io.Reader {
+	return &reader{ctx: ctx, r: r, ls: ls}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p[:min(len(p), piece)])
+	if n > 0 {
+		if werr := waitAll(r.ctx, r.ls, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type writer struct {
+	ctx context.Context
+	w   io.Writer
+	ls  []*Limiter
+}
+
+// Writer returns w limited by ls; nil limiters are skipped. Each piece
+// of a Write waits before it is written.
+func Writer(ctx context.Context, w io.Writer, ls ...*Limiter) io.Writer {
+	return &writer{ctx: ctx, w: w, ls: ls}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), piece)]
+		if err := waitAll(w.ctx, w.ls, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func waitAll(ctx context.Context, ls []*Limiter, n int) error {
+	for _, l := range ls {
+		if err := l.Wait(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+-----------------------------------
+PER-CONNECTION LIMITERS
+-----------------------------------
+*/
+
+// Pool hands out one Limiter per key, such as a connection's remote
+// address, for as long as anyone holds it: two downloads on the same
+// connection share its budget, and a closed connection's limiter goes.
+// Its Stats sum every connection's, past and present.
+type Pool struct {
+	rate int64
+	clk  clock.Clock
+	all  *Limiter // measures the sum; never limits
+
+	mu sync.Mutex
+	m  map[string]*pooled
+}
+
+type pooled struct {
+	l    *Limiter
+	refs int
+}
+
+// NewPool gives each key bytesPerSec. clk may be nil.
+func NewPool(bytesPerSec int64, clk clock.Clock) *Pool {
+	return &Pool{rate: bytesPerSec, clk: clk, all: New(0, clk), m: make(map[string]*pooled)}
+}
+
+// Get returns key's limiter and the func to call when done with it. A
+// nil *Pool returns a nil limiter.
+func (p *Pool) Get(key string) (*Limiter, func()) {
+	if p == nil {
+		return nil, func() {}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.m[key]
+	if !ok {
+		e = &pooled{l: New(p.rate, p.clk)}
+		e.l.parent = p.all
+		p.m[key] = e
+	}
+	e.refs++
+	var once sync.Once
+	return e.l, func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if e.refs--; e.refs == 0 {
+				delete(p.m, key)
+			}
+		})
+	}
+}
+
+// Stats sums every connection's measurements; Limit is each one's.
+func (p *Pool) Stats() Stats {
+	if p == nil {
+		return Stats{}
+	}
+	st := p.all.Stats()
+	st.Limit = p.rate
+	p.mu.Lock()
+	st.Active = len(p.m)
+	p.mu.Unlock()
+	return st
+}
@@ -0,0 +1,228 @@
+// Package batcher groups items and hands them to a flush function when
+// either MaxItems have accumulated or MaxWait has passed since the first
+// item of the batch arrived, whichever comes first. Close performs a final
+// flush so nothing buffered is lost on shutdown.
+package batcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+var ErrClosed = errors.New("batcher: closed")
+
+// FlushFunc receives a batch. The slice is owned by the callee.
+type FlushFunc[T any] func(ctx context.Context, batch []T) error
+
+// Options configures a Batcher.
+type Options struct {
+	MaxItems int           // flush when this many items are buffered (default 100)
+	MaxWait  time.Duration // flush this long after the first buffered item (default 1s)
+
+	// Buffer is the size of the input channel. Add blocks once it is full,
+	// which is the backpressure signal for producers. Default MaxItems.
+	Buffer int
+
+	// FlushTimeout bounds each size/time-triggered flush. 0 means no bound.
+	FlushTimeout time.Duration
+
+	// OnError sees errors from size/time-triggered flushes, which have no
+	// caller to return them to. Errors from Flush and Close are returned.
+	OnError func(err error, batch int)
+
+	Clock clock.Clock // nil means the real clock
+}
+
+// Batcher is safe for concurrent use.
+type Batcher[T any] struct {
+	flush FlushFunc[T]
+	opts  Options
+	clk   clock.Clock
+
+	in       chan T
+	flushReq chan chan error
+	stop     chan struct{}
+	done     chan error
+
+	mu     sync.RWMutex // guards sends on in vs. Close
+	closed bool
+}
+
+func New[T any](flush FlushFunc[T], opts Options) *Batcher[T] {
+	if opts.MaxItems <= 0 {
+		opts.MaxItems = 100
+	}
+	if opts.MaxWait <= 0 {
+		opts.MaxWait = time.Second
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = opts.MaxItems
+	}
+
+	b := &Batcher[T]{
+		flush:    flush,
+		opts:     opts,
+		clk:      clock.OrReal(opts.Clock),
+		in:       make(chan T, opts.Buffer),
+		flushReq: make(chan chan error),
+		stop:     make(chan struct{}),
+		done:     make(chan error, 1),
+	}
+	go b.loop()
+	return b
+}
+
+// Add buffers an item, blocking while the input buffer is full.
+func (b *Batcher[T]) Add(ctx context.Context, item T) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+
+	select {
+	case b.in <- item:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush forces the current batch out and returns the flush error.
+func (b *Batcher[T]) Flush(ctx context.Context) error {
+	reply := make(chan error, 1)
+	select {
+	case b.flushReq <- reply:
+	case <-b.stop:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting items, flushes everything still buffered and waits
+// for that final flush (bounded by ctx).
+func (b *Batcher[T]) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	b.closed = true
+	close(b.stop)
+	b.mu.Unlock()
+
+	select {
+	case err := <-b.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Batcher[T]) loop() {
+	var (
+		batch = make([]T, 0, b.opts.MaxItems)
+		timer clock.Timer
+		timeC <-chan time.Time
+	)
+
+	stopTimer := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timeC = nil, nil
+		}
+	}
+
+	emit := func(ctx context.Context) error {
+		stopTimer()
+		if len(batch) == 0 {
+			return nil
+		}
+		out := batch
+		batch = make([]T, 0, b.opts.MaxItems)
+		return b.flush(ctx, out)
+	}
+
+	triggered := func() {
+		ctx, cancel := b.flushCtx()
+		n := len(batch)
+		err := emit(ctx)
+		cancel()
+		if err != nil && b.opts.OnError != nil {
+			b.opts.OnError(err, n)
+		}
+	}
+
+	for {
+		select {
+		case item := <-b.in:
+			batch = append(batch, item)
+			if len(batch) == 1 {
+				timer = b.clk.NewTimer(b.opts.MaxWait)
+				timeC = timer.C()
+			}
+			if len(batch) >= b.opts.MaxItems {
+				triggered()
+			}
+
+		case <-timeC:
+			timer, timeC = nil, nil
+			triggered()
+
+		case reply := <-b.flushReq:
+			// Pull in whatever producers already handed over.
+			b.drain(&batch)
+			ctx, cancel := b.flushCtx()
+			reply <- emit(ctx)
+			cancel()
+
+		case <-b.stop:
+			// No Add can be in flight any more (Close holds the write lock
+			// while closing stop), so draining here sees every item.
+			b.drain(&batch)
+			var errs []error
+			for len(batch) > 0 {
+				// The chunk's capacity ends with it: the flush owns it, and
+				// appending to it must not write over the rest.
+				n := min(len(batch), b.opts.MaxItems)
+				rest := batch[n:]
+				batch = batch[:n:n]
+				errs = append(errs, emit(context.Background()))
+				batch = rest
+			}
+			stopTimer()
+			b.done <- errors.Join(errs...)
+			return
+		}
+	}
+}
+
+func (b *Batcher[T]) drain(batch *[]T) {
+	for {
+		select {
+		case item := <-b.in:
+			*batch = append(*batch, item)
+		default:
+			return
+		}
+	}
+}
+
+func (b *Batcher[T]) flushCtx() (context.Context, context.CancelFunc) {
+	if b.opts.FlushTimeout > 0 {
+		return context.WithTimeout(context.Background(), b.opts.FlushTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
@@ -0,0 +1,150 @@
+package batcher
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+// recorder is a FlushFunc keeping every batch it was handed.
+type recorder struct {
+	mu      sync.Mutex
+	batches [][]int
+	flushed chan struct{}
+}
+
+func newRecorder() *recorder { return &recorder{flushed: make(chan struct{}, 100)} }
+
+func (r *recorder) flush(_ context.Context, batch []int) error {
+	r.mu.Lock()
+	r.batches = append(r.batches, batch)
+	r.mu.Unlock()
+	r.flushed <- struct{}{}
+	return nil
+}
+
+func (r *recorder) got() [][]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.batches)
+}
+
+func (r *recorder) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no flush")
+	}
+}
+
+func add(t *testing.T, b *Batcher[int], items ...int) {
+	t.Helper()
+	for _, it := range items {
+		if err := b.Add(context.Background(), it); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFlushOnSize(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	r := newRecorder()
+	b := New(r.flush, Options{MaxItems: 3, MaxWait: time.Hour, Clock: clk})
+
+	add(t, b, 1, 2, 3, 4)
+	r.wait(t)
+	if got, want := r.got(), [][]int{{1, 2, 3}}; !slices.EqualFunc(got, want, slices.Equal) {
+		t.Fatalf("batches = %v, want %v", got, want)
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.got(), [][]int{{1, 2, 3}, {4}}; !slices.EqualFunc(got, want, slices.Equal) {
+		t.Fatalf("batches after Close = %v, want %v", got, want)
+	}
+}
+
+func TestFlushOnTime(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	r := newRecorder()
+	b := New(r.flush, Options{MaxItems: 10, MaxWait: time.Second, Clock: clk})
+	defer b.Close(context.Background())
+
+	add(t, b, 1, 2)
+	// The timer starts with the first item.
+	clk.BlockUntil(1)
+	clk.Advance(999 * time.Millisecond)
+	select {
+	case <-r.flushed:
+		t.Fatal("flushed before MaxWait")
+	default:
+	}
+	clk.Advance(time.Millisecond)
+	r.wait(t)
+	if got, want := r.got(), [][]int{{1, 2}}; !slices.EqualFunc(got, want, slices.Equal) {
+		t.Fatalf("batches = %v, want %v", got, want)
+	}
+}
+
+func TestCloseDrains(t *testing.T) {
+	// With items waiting, the loop picks between them and Close at
+	// random; enough runs have it take Close with a backlog to chunk.
+	for range 20 {
+		want := [][]int{{1, 2, -1}, {3, 4, -1}, {5, 6, -1}, {7, -1}}
+		if got := closeWithBacklog(t); !slices.EqualFunc(got, want, slices.Equal) {
+			t.Fatalf("batches = %v, want %v", got, want)
+		}
+	}
+}
+
+// closeWithBacklog closes a batcher of MaxItems 2 holding five items it
+// has not taken in yet, returning the batches it flushed.
+func closeWithBacklog(t *testing.T) [][]int {
+	t.Helper()
+	var batches [][]int
+	release := make(chan struct{})
+	started := make(chan struct{})
+	first := true
+	flush := func(_ context.Context, batch []int) error {
+		// Held up flushing the first batch, the loop leaves the rest
+		// in the buffer for Close.
+		if first {
+			first = false
+			close(started)
+			<-release
+		}
+		// The flush owns its batch: appending to it must not reach the
+		// items still to be flushed.
+		batches = append(batches, append(batch, -1))
+		return nil
+	}
+	b := New(flush, Options{MaxItems: 2, MaxWait: time.Hour, Buffer: 10, Clock: clock.NewFake(time.Now())})
+
+	add(t, b, 1, 2)
+	<-started
+	add(t, b, 3, 4, 5, 6, 7)
+	closed := make(chan error)
+	go func() { closed <- b.Close(context.Background()) }()
+	for {
+		b.mu.RLock()
+		stopped := b.closed
+		b.mu.RUnlock()
+		if stopped {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(context.Background(), 8); err != ErrClosed {
+		t.Fatalf("Add after Close = %v, want ErrClosed", err)
+	}
+	return batches
+}
@@ -0,0 +1,82 @@
+// Package blobstore keeps opaque byte streams (avatar images, backup
+// archives, data exports) under string keys, on local disk (FS), in
+// memory (Memory) or in an S3-compatible object store (S3).
+//
+// A Store streams both ways: Put reads its io.Reader to the end without
+// holding the blob in memory, and Get returns a reader over the stored
+// bytes. FS writes to a temporary file and renames it into place, so a
+// reader never sees half a blob; S3 uploads in parts of PartSize, so
+// memory stays bounded whatever the blob's size. Only Memory buffers,
+// being memory. Readers from FS and Memory also implement io.Seeker,
+// which is what http.ServeContent needs for range requests.
+//
+// Presign hands out a URL that lets its holder GET or PUT one blob
+// until it expires, without credentials: an S3 query-signed URL, or for
+// FS and Memory an HMAC-signed one served by Handler.
+//
+// Every blob carries an Info: its size, content type, modification time
+// and an ETag that changes whenever the content does.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound  = errors.New("blobstore: not found")
+	ErrBadKey    = errors.New("blobstore: invalid key")
+	ErrNoPresign = errors.New("blobstore: store has no URL signer")
+)
+
+// Info describes a stored blob.
+type Info struct {
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	// ETag is the hex SHA-256 of the content for FS and Memory, and the
+	// server's ETag (quotes removed) for S3.
+	ETag    string    `json:"etag"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Store is implemented by FS, Memory and S3.
+type Store interface {
+	// Put stores everything r yields under key, replacing any blob there.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) (Info, error)
+	// Get opens the blob under key; the caller closes it. ErrNotFound if
+	// there is none.
+	Get(ctx context.Context, key string) (io.ReadCloser, Info, error)
+	// Delete removes the blob under key. A missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+	// Presign returns a URL that allows method (GET or PUT) on key for
+	// ttl. ErrNoPresign if the store cannot sign.
+	Presign(ctx context.Context, method, key string, ttl time.Duration) (string, error)
+}
+
+// maxKey bounds a key's length.
+const maxKey = 512
+
+// CheckKey accepts slash-separated keys ("avatars/42") whose segments
+// are non-empty, do not start with '.', and are made of letters, digits,
+// '.', '-' and '_'. Keys map onto file paths in the FS backend, so
+// anything else is refused rather than escaped.
+func CheckKey(key string) error {
+	if key == "" || len(key) > maxKey {
+		return ErrBadKey
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg[0] == '.' {
+			return ErrBadKey
+		}
+		for _, c := range seg {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+				return ErrBadKey
+			}
+		}
+	}
+	return nil
+}
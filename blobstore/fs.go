@@ -0,0 +1,165 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*
+-----------------------------------
+FILESYSTEM
+-----------------------------------
+*/
+
+// FS keeps each blob in one file under its directory, at the key's path:
+// the content, then its Info as JSON, then the JSON's length as 4 bytes
+// big-endian. The Info goes last because its ETag is only known once the
+// content has been written; putting both in one file means a blob and
+// its metadata are replaced by one rename and can never disagree.
+type FS struct {
+	dir string
+	// Signer, if set, makes Presign work; see Handler.
+	Signer *Signer
+}
+
+// NewFS returns a store rooted at dir, creating it if needed.
+func NewFS(dir string) (*FS, error) {
+	if err := os.MkdirAll(filepath.Join(dir, ".tmp"), 0o755); err != nil {
+		return nil, err
+	}
+	return &FS{dir: dir}, nil
+}
+
+func (s *FS) path(key string) string { return filepath.Join(s.dir, filepath.FromSlash(key)) }
+
+var errCorrupt = errors.New("corrupt trailer")
+
+// maxInfo bounds the trailer a Get will read.
+const maxInfo = 4096
+
+func (s *FS) Put(ctx context.Context, key string, r io.Reader, contentType string) (Info, error) {
+	if err := CheckKey(key); err != nil {
+		return Info{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return Info{}, err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, ".tmp"), "put-*")
+	if err != nil {
+		return Info{}, err
+	}
+	defer os.Remove(tmp.Name()) // a no-op once renamed
+	defer tmp.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return Info{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return Info{}, err
+	}
+	info := Info{Key: key, Size: n, ContentType: contentType, ETag: hex.EncodeToString(h.Sum(nil)), ModTime: time.Now().UTC()}
+	meta, err := json.Marshal(info)
+	if err != nil {
+		return Info{}, err
+	}
+	if len(meta) > maxInfo {
+		return Info{}, fmt.Errorf("blobstore: metadata for %s is too large", key)
+	}
+	if _, err := tmp.Write(binary.BigEndian.AppendUint32(meta, uint32(len(meta)))); err != nil {
+		return Info{}, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return Info{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return Info{}, err
+	}
+	dst := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return Info{}, err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
+
+func (s *FS) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	if err := CheckKey(key); err != nil {
+		return nil, Info{}, err
+	}
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, Info{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Info{}, err
+	}
+	info, err := readInfo(f)
+	if err != nil {
+		f.Close()
+		return nil, Info{}, fmt.Errorf("blobstore: %s: %w", key, err)
+	}
+	return &fileReader{SectionReader: io.NewSectionReader(f, 0, info.Size), f: f}, info, nil
+}
+
+func readInfo(f *os.File) (Info, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return Info{}, err
+	}
+	var n [4]byte
+	if _, err := f.ReadAt(n[:], st.Size()-4); err != nil {
+		return Info{}, errCorrupt
+	}
+	size := int64(binary.BigEndian.Uint32(n[:]))
+	if size > maxInfo || size > st.Size()-4 {
+		return Info{}, errCorrupt
+	}
+	meta := make([]byte, size)
+	if _, err := f.ReadAt(meta, st.Size()-4-size); err != nil {
+		return Info{}, err
+	}
+	var info Info
+	if err := json.Unmarshal(meta, &info); err != nil || info.Size != st.Size()-4-size {
+		return Info{}, errCorrupt
+	}
+	return info, nil
+}
+
+func (s *FS) Delete(ctx context.Context, key string) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FS) Presign(ctx context.Context, method, key string, ttl time.Duration) (string, error) {
+	if s.Signer == nil {
+		return "", ErrNoPresign
+	}
+	return s.Signer.Sign(method, key, ttl)
+}
+
+// fileReader reads a blob's content, seekable, and closes its file.
+type fileReader struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (r *fileReader) Close() error { return r.f.Close() }
@@ -0,0 +1,87 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+-----------------------------------
+MEMORY
+-----------------------------------
+*/
+
+// Memory keeps blobs in a map, for tests and single-process setups where
+// losing them on restart is fine. It is safe for concurrent use.
+type Memory struct {
+	mu    sync.RWMutex
+	blobs map[string]memBlob
+	// Signer, if set, makes Presign work; see Handler.
+	Signer *Signer
+}
+
+type memBlob struct {
+	data []byte
+	info Info
+}
+
+func NewMemory() *Memory { return &Memory{blobs: make(map[string]memBlob)} }
+
+func (m *Memory) Put(ctx context.Context, key string, r io.Reader, contentType string) (Info, error) {
+	if err := CheckKey(key); err != nil {
+		return Info{}, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Info{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return Info{}, err
+	}
+	sum := sha256.Sum256(data)
+	info := Info{Key: key, Size: int64(len(data)), ContentType: contentType, ETag: hex.EncodeToString(sum[:]), ModTime: time.Now().UTC()}
+	m.mu.Lock()
+	m.blobs[key] = memBlob{data: data, info: info}
+	m.mu.Unlock()
+	return info, nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	if err := CheckKey(key); err != nil {
+		return nil, Info{}, err
+	}
+	m.mu.RLock()
+	b, ok := m.blobs[key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, Info{}, ErrNotFound
+	}
+	// Put never changes a stored slice, so readers can share it.
+	return memReader{bytes.NewReader(b.data)}, b.info, nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.blobs, key)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) Presign(ctx context.Context, method, key string, ttl time.Duration) (string, error) {
+	if m.Signer == nil {
+		return "", ErrNoPresign
+	}
+	return m.Signer.Sign(method, key, ttl)
+}
+
+type memReader struct{ *bytes.Reader }
+
+func (memReader) Close() error { return nil }
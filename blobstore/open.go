@@ -0,0 +1,52 @@
+package blobstore
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Open returns the store a spec names, for flags and configuration:
+//
+//	mem:                                      a Memory
+//	/var/lib/users/blobs, file:///var/...     an FS at that directory
+//	s3://bucket/prefix?region=eu-west-1       an S3 bucket
+//
+// An s3 spec may also set endpoint= (with path_style=true for most
+// S3-compatible servers) and part_size= in bytes. Credentials come from
+// $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN, the
+// region, if not given, from $AWS_REGION.
+func Open(spec string) (Store, error) {
+	switch {
+	case spec == "mem:" || spec == "memory":
+		return NewMemory(), nil
+	case strings.HasPrefix(spec, "s3://"):
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("blobstore: %q: %w", spec, err)
+		}
+		q := u.Query()
+		opts := S3Options{
+			Bucket:       u.Host,
+			Prefix:       strings.Trim(u.Path, "/"),
+			Region:       q.Get("region"),
+			Endpoint:     q.Get("endpoint"),
+			PathStyle:    q.Get("path_style") == "true",
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if opts.Region == "" {
+			opts.Region = os.Getenv("AWS_REGION")
+		}
+		if ps := q.Get("part_size"); ps != "" {
+			if _, err := fmt.Sscan(ps, &opts.PartSize); err != nil {
+				return nil, fmt.Errorf("blobstore: %q: part_size: %w", spec, err)
+			}
+		}
+		return NewS3(opts)
+	default:
+		return NewFS(strings.TrimPrefix(spec, "file://"))
+	}
+}
@@ -0,0 +1,299 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+/*
+-----------------------------------
+S3
+-----------------------------------
+*/
+
+// minPart is S3's smallest part size for every part but the last.
+const minPart = 5 << 20
+
+// S3Options configures NewS3.
+type S3Options struct {
+	// Endpoint is the service's base URL; default
+	// https://s3.<Region>.amazonaws.com. Point it at MinIO, R2, Ceph and
+	// the like with PathStyle.
+	Endpoint string
+	Region   string // default us-east-1
+	Bucket   string
+	// Prefix is put before every key, e.g. "prod/".
+	Prefix string
+	// PathStyle addresses the bucket as Endpoint/Bucket/key, which most
+	// S3-compatible servers want, instead of Bucket.host/key.
+	PathStyle    bool
+	AccessKey    string
+	SecretKey    string
+	SessionToken string // for temporary credentials; optional
+	// PartSize is how much of a Put is buffered and uploaded at a time;
+	// default 8 MiB, at least 5 MiB. A blob smaller than one part is a
+	// single PUT, a larger one a multipart upload.
+	PartSize int
+	Client   *http.Client // default http.DefaultClient
+	Clock    clock.Clock
+}
+
+// S3 stores blobs as objects in a bucket of an S3-compatible service,
+// signing requests itself (AWS Signature Version 4).
+type S3 struct {
+	opts  S3Options
+	base  *url.URL // the bucket's URL
+	creds credentials
+	clk   clock.Clock
+}
+
+// S3Error is an S3 error response.
+type S3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("blobstore: s3: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func NewS3(opts S3Options) (*S3, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("blobstore: s3 needs a bucket")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://s3." + opts.Region + ".amazonaws.com"
+	}
+	if opts.PartSize == 0 {
+		opts.PartSize = 8 << 20
+	}
+	if opts.PartSize < minPart {
+		return nil, fmt.Errorf("blobstore: s3 part size must be at least %d bytes", minPart)
+	}
+	if opts.Prefix != "" {
+		if err := CheckKey(strings.TrimSuffix(opts.Prefix, "/")); err != nil {
+			return nil, fmt.Errorf("blobstore: s3 prefix %q: %w", opts.Prefix, err)
+		}
+		opts.Prefix = strings.TrimSuffix(opts.Prefix, "/") + "/"
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	base, err := url.Parse(opts.Endpoint)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("blobstore: s3 endpoint %q is not a URL", opts.Endpoint)
+	}
+	if opts.PathStyle {
+		base.Path = strings.TrimSuffix(base.Path, "/") + "/" + opts.Bucket
+	} else {
+		base.Host = opts.Bucket + "." + base.Host
+	}
+	return &S3{
+		opts:  opts,
+		base:  base,
+		creds: credentials{accessKey: opts.AccessKey, secretKey: opts.SecretKey, sessionToken: opts.SessionToken, region: opts.Region},
+		clk:   clock.OrReal(opts.Clock),
+	}, nil
+}
+
+func (s *S3) objectURL(key string, q url.Values) *url.URL {
+	u := *s.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.opts.Prefix + key
+	u.RawQuery = canonicalQuery(q)
+	return &u
+}
+
+// do sends a signed request. body is small (one part at most), so its
+// hash is signed rather than sent unsigned.
+func (s *S3) do(ctx context.Context, method, key string, q url.Values, body []byte, hdr http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key, q).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	s.creds.signHeader(req, hashHex(body), s.clk.Now())
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, errorOf(resp)
+	}
+	return resp, nil
+}
+
+func errorOf(resp *http.Response) error {
+	e := &S3Error{Status: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, e) != nil || e.Code == "" {
+		e.Code, e.Message = http.StatusText(resp.StatusCode), strings.TrimSpace(string(data))
+	}
+	if resp.StatusCode == http.StatusNotFound && (e.Code == "NoSuchKey" || e.Code == http.StatusText(http.StatusNotFound)) {
+		return fmt.Errorf("%w: %w", ErrNotFound, e)
+	}
+	return e
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, contentType string) (Info, error) {
+	if err := CheckKey(key); err != nil {
+		return Info{}, err
+	}
+	buf := make([]byte, s.opts.PartSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := s.do(ctx, http.MethodPut, key, nil, buf[:n], http.Header{"Content-Type": {contentType}})
+		if err != nil {
+			return Info{}, err
+		}
+		resp.Body.Close()
+		return Info{Key: key, Size: int64(n), ContentType: contentType, ETag: unquote(resp.Header.Get("ETag")), ModTime: s.clk.Now().UTC()}, nil
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	return s.putMultipart(ctx, key, r, contentType, buf)
+}
+
+// putMultipart uploads r in parts, starting with the full buffer first.
+// On any failure the upload is aborted, so the bucket is not left
+// holding (and billing for) orphaned parts.
+func (s *S3) putMultipart(ctx context.Context, key string, r io.Reader, contentType string, first []byte) (Info, error) {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil, http.Header{"Content-Type": {contentType}})
+	if err != nil {
+		return Info{}, err
+	}
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if err != nil || created.UploadID == "" {
+		return Info{}, fmt.Errorf("blobstore: s3: starting multipart upload of %s: %v", key, err)
+	}
+	id := created.UploadID
+	abort := func(cause error) (Info, error) {
+		// A fresh context: ctx may be why the upload failed.
+		actx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if resp, err := s.do(actx, http.MethodDelete, key, url.Values{"uploadId": {id}}, nil, nil); err == nil {
+			resp.Body.Close()
+		}
+		return Info{}, cause
+	}
+
+	type part struct {
+		Number int    `xml:"PartNumber"`
+		ETag   string `xml:"ETag"`
+	}
+	var parts []part
+	var size int64
+	buf := first
+	for n := len(buf); n > 0; {
+		resp, err := s.do(ctx, http.MethodPut, key, url.Values{"partNumber": {strconv.Itoa(len(parts) + 1)}, "uploadId": {id}}, buf[:n], nil)
+		if err != nil {
+			return abort(err)
+		}
+		resp.Body.Close()
+		parts = append(parts, part{Number: len(parts) + 1, ETag: resp.Header.Get("ETag")})
+		size += int64(n)
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return abort(err)
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return abort(err)
+	}
+	resp, err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {id}}, body, http.Header{"Content-Type": {"application/xml"}})
+	if err != nil {
+		return abort(err)
+	}
+	defer resp.Body.Close()
+	// A 200 can still carry an error: the server answers before it has
+	// assembled the parts.
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return abort(err)
+	}
+	var done struct {
+		XMLName xml.Name
+		ETag    string `xml:"ETag"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.Unmarshal(data, &done); err != nil {
+		return abort(fmt.Errorf("blobstore: s3: completing upload of %s: %w", key, err))
+	}
+	if done.XMLName.Local == "Error" {
+		return abort(&S3Error{Status: resp.StatusCode, Code: done.Code, Message: done.Message})
+	}
+	return Info{Key: key, Size: size, ContentType: contentType, ETag: unquote(done.ETag), ModTime: s.clk.Now().UTC()}, nil
+}
+
+// Get streams the object; the reader does not seek.
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, Info, error) {
+	if err := CheckKey(key); err != nil {
+		return nil, Info{}, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	info := Info{Key: key, Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type"), ETag: unquote(resp.Header.Get("ETag"))}
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return resp.Body, info, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := CheckKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil // S3 itself answers 204 here; some compatible servers do not
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Presign returns a query-signed URL; the holder needs no credentials.
+func (s *S3) Presign(ctx context.Context, method, key string, ttl time.Duration) (string, error) {
+	if err := checkPresign(method, ttl); err != nil {
+		return "", err
+	}
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	u := s.objectURL(key, nil)
+	s.creds.presign(method, u, ttl, s.clk.Now())
+	return u.String(), nil
+}
+
+func unquote(etag string) string { return strings.Trim(strings.TrimPrefix(etag, "W/"), `"`) }
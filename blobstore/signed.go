@@ -0,0 +1,136 @@
+package blobstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+/*
+-----------------------------------
+SIGNED URLS
+-----------------------------------
+*/
+
+// MaxPresign bounds a presigned URL's lifetime, as S3 does.
+const MaxPresign = 7 * 24 * time.Hour
+
+var (
+	ErrBadMethod = errors.New("blobstore: presigned URLs are for GET or PUT")
+	ErrBadTTL    = errors.New("blobstore: presign lifetime must be between 1s and 7 days")
+	ErrSignature = errors.New("blobstore: invalid or expired signature")
+)
+
+// Signer presigns URLs for stores that cannot do it themselves (FS and
+// Memory): BaseURL/key?expires=...&sig=..., where sig is an HMAC-SHA256
+// of the method, key and expiry. Handler, mounted at BaseURL, checks it.
+type Signer struct {
+	// BaseURL is where Handler is served, such as
+	// "https://api.example.com/blobs".
+	BaseURL string
+	Key     []byte
+	Clock   clock.Clock
+}
+
+func (s *Signer) sig(method, key string, expires int64) string {
+	m := hmac.New(sha256.New, s.Key)
+	fmt.Fprintf(m, "%s\n%s\n%d", method, key, expires)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func checkPresign(method string, ttl time.Duration) error {
+	if method != http.MethodGet && method != http.MethodPut {
+		return ErrBadMethod
+	}
+	if ttl < time.Second || ttl > MaxPresign {
+		return ErrBadTTL
+	}
+	return nil
+}
+
+// Sign returns the URL allowing method on key for ttl.
+func (s *Signer) Sign(method, key string, ttl time.Duration) (string, error) {
+	if err := checkPresign(method, ttl); err != nil {
+		return "", err
+	}
+	if err := CheckKey(key); err != nil {
+		return "", err
+	}
+	expires := clock.OrReal(s.Clock).Now().Add(ttl).Unix()
+	return fmt.Sprintf("%s/%s?expires=%d&sig=%s", strings.TrimSuffix(s.BaseURL, "/"), key, expires, s.sig(method, key, expires)), nil
+}
+
+// Verify checks a URL's expires and sig for method on key. A GET URL
+// also allows HEAD.
+func (s *Signer) Verify(method, key, expires, sig string) error {
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || clock.OrReal(s.Clock).Now().Unix() > exp {
+		return ErrSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.sig(method, key, exp))) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// Handler serves the URLs signer makes for store: GET (and HEAD) return
+// the blob, PUT replaces it with the request body. The request path is
+// the key, so mount it under BaseURL's path with http.StripPrefix.
+func Handler(store Store, signer *Signer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		q := r.URL.Query()
+		if err := signer.Verify(r.Method, key, q.Get("expires"), q.Get("sig")); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			rc, info, err := store.Get(r.Context(), key)
+			if errors.Is(err, ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer rc.Close()
+			w.Header().Set("Content-Type", info.ContentType)
+			w.Header().Set("ETag", `"`+info.ETag+`"`)
+			if rs, ok := rc.(io.ReadSeeker); ok {
+				http.ServeContent(w, r, "", info.ModTime, rs)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+			_, _ = io.Copy(w, rc)
+		case http.MethodPut:
+			ctype := r.Header.Get("Content-Type")
+			if ctype == "" {
+				ctype = "application/octet-stream"
+			}
+			info, err := store.Put(r.Context(), key, r.Body, ctype)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("ETag", `"`+info.ETag+`"`)
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
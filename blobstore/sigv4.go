@@ -0,0 +1,127 @@
+package blobstore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+-----------------------------------
+AWS SIGNATURE VERSION 4
+-----------------------------------
+*/
+
+// unsignedPayload stands in for the body hash of presigned URLs, whose
+// body is not known when they are signed.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// credentials sign requests for one region's S3 service.
+type credentials struct {
+	accessKey, secretKey, sessionToken, region string
+}
+
+func (c credentials) scope(date string) string { return date + "/" + c.region + "/s3/aws4_request" }
+
+func (c credentials) signingKey(date string) []byte {
+	k := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	k = hmacSHA256(k, c.region)
+	k = hmacSHA256(k, "s3")
+	return hmacSHA256(k, "aws4_request")
+}
+
+// signHeader adds the Authorization header (and x-amz-date,
+// x-amz-content-sha256) to req. Every header set on req by then is
+// signed, with Host.
+func (c credentials) signHeader(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		names = append(names, lk)
+		values[lk] = strings.Join(v, ",")
+	}
+	slices.Sort(names)
+	var canonHeaders strings.Builder
+	for _, n := range names {
+		canonHeaders.WriteString(n + ":" + strings.TrimSpace(values[n]) + "\n")
+	}
+	signed := strings.Join(names, ";")
+	sig := c.signature(req.Method, req.URL, canonHeaders.String(), signed, payloadHash, amzDate)
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.accessKey+"/"+c.scope(amzDate[:8])+
+		", SignedHeaders="+signed+", Signature="+sig)
+}
+
+// presign adds the query-string signature to u, valid for ttl.
+func (c credentials) presign(method string, u *url.URL, ttl time.Duration, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", c.accessKey+"/"+c.scope(amzDate[:8]))
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", strconv.FormatInt(int64(ttl/time.Second), 10))
+	q.Set("X-Amz-SignedHeaders", "host")
+	if c.sessionToken != "" {
+		q.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+	u.RawQuery = canonicalQuery(q)
+	sig := c.signature(method, u, "host:"+u.Host+"\n", "host", unsignedPayload, amzDate)
+	u.RawQuery += "&X-Amz-Signature=" + sig
+}
+
+func (c credentials) signature(method string, u *url.URL, canonHeaders, signed, payloadHash, amzDate string) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canon := strings.Join([]string{method, path, canonicalQuery(u.Query()), canonHeaders, signed, payloadHash}, "\n")
+	sum := sha256.Sum256([]byte(canon))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + c.scope(amzDate[:8]) + "\n" + hex.EncodeToString(sum[:])
+	return hex.EncodeToString(hmacSHA256(c.signingKey(amzDate[:8]), toSign))
+}
+
+// canonicalQuery sorts by name and escapes as SigV4 wants: RFC 3986,
+// so a space is %20, not +.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := slices.Clone(q[k])
+		slices.Sort(vs)
+		for _, v := range vs {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
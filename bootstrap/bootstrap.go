@@ -0,0 +1,184 @@
+// Package bootstrap checks, before the server takes any traffic, that
+// what it depends on outside the process answers: the store, a second
+// store during a migration, the blob stores, the mail server. A check
+// that fails is retried with backoff, since at deploy time the database
+// is often still coming up; one that never passes stops the start, so a
+// broken configuration fails its deployment instead of serving errors.
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+// ErrNotReady is what Run fails with when a check never passed.
+var ErrNotReady = errors.New("bootstrap: dependencies not ready")
+
+// Check is one dependency.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+	// Timeout bounds each attempt; default Options.Timeout, negative for
+	// none (a check that reads a whole store).
+	Timeout time.Duration
+}
+
+// Options configures Run.
+type Options struct {
+	// Attempts is how often a check is tried before it counts as failed;
+	// default 5.
+	Attempts int
+	// Backoff is the wait after the first failure, doubled after each
+	// one after it up to MaxBackoff; defaults 500ms and 10s.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds each attempt of a check without its own; default
+	// 10s.
+	Timeout time.Duration
+	Logger  *slog.Logger // default slog.Default()
+	Clock   clock.Clock
+}
+
+func (o Options) withDefaults() Options {
+	if o.Attempts <= 0 {
+		o.Attempts = 5
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 500 * time.Millisecond
+	}
+	if o.MaxBackoff < o.Backoff {
+		o.MaxBackoff = max(10*time.Second, o.Backoff)
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.Logger == nil {
+		o.Logger = slog.Default()
+	}
+	o.Clock = clock.OrReal(o.Clock)
+	return o
+}
+
+// Result is one check's outcome.
+type Result struct {
+	Name     string        `json:"name"`
+	OK       bool          `json:"ok"`
+	Attempts int           `json:"attempts"`
+	Took     time.Duration `json:"took"`
+	// Error is the last attempt's.
+	Error string `json:"error,omitempty"`
+}
+
+// Report is every check's, in the order they were given.
+type Report []Result
+
+// OK reports whether every check passed.
+func (r Report) OK() bool {
+	for _, res := range r {
+		if !res.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// permanent marks an error no retry will fix.
+type permanent struct{ err error }
+
+func (p permanent) Error() string { return p.err.Error() }
+func (p permanent) Unwrap() error { return p.err }
+
+// Permanent wraps err so the check fails at once rather than being
+// retried: the dependency answered, and what it said will not change by
+// asking again.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanent{err}
+}
+
+// Run runs the checks side by side, each until it passes or runs out of
+// attempts, and fails with ErrNotReady, naming them, if any did not
+// pass; with ctx's error if it was done first.
+func Run(ctx context.Context, checks []Check, opts Options) (Report, error) {
+	opts = opts.withDefaults()
+	report := make(Report, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Go(func() { report[i] = run(ctx, c, opts) })
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	var failed []string
+	for _, res := range report {
+		if !res.OK {
+			failed = append(failed, res.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return report, fmt.Errorf("%w: %s", ErrNotReady, strings.Join(failed, ", "))
+	}
+	return report, nil
+}
+
+func run(ctx context.Context, c Check, opts Options) Result {
+	res := Result{Name: c.Name}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = opts.Timeout
+	}
+	start := opts.Clock.Now()
+	delay := opts.Backoff
+	for {
+		res.Attempts++
+		err := attempt(ctx, c, timeout)
+		res.Took = opts.Clock.Since(start)
+		if err == nil {
+			res.OK, res.Error = true, ""
+			opts.Logger.Info("bootstrap: dependency ready", "check", c.Name, "attempts", res.Attempts, "took", res.Took)
+			return res
+		}
+		res.Error = err.Error()
+		var p permanent
+		if res.Attempts >= opts.Attempts || errors.As(err, &p) || ctx.Err() != nil {
+			opts.Logger.Error("bootstrap: dependency not ready", "check", c.Name, "attempts", res.Attempts, "err", err)
+			return res
+		}
+		opts.Logger.Warn("bootstrap: dependency not ready, retrying", "check", c.Name, "attempt", res.Attempts, "err", err, "in", delay)
+
+		t := opts.Clock.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return res
+		case <-t.C():
+		}
+		delay = min(delay*2, opts.MaxBackoff)
+	}
+}
+
+// attempt runs c once within timeout, a panic counting as a failure.
+func attempt(ctx context.Context, c Check, timeout time.Duration) (err error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	return c.Run(ctx)
+}
@@ -0,0 +1,77 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"Go-Internals/blobstore"
+	"Go-Internals/datamove"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+CHECKS
+-----------------------------------
+*/
+
+// Store checks that repo answers a read: the first record, if any.
+func Store(name string, repo users.UserRepository) Check {
+	return Check{Name: name, Run: func(ctx context.Context) error {
+		for _, err := range repo.Iterate(ctx, users.IterateOptions{BatchSize: 1}) {
+			return err
+		}
+		return nil
+	}}
+}
+
+// Migrated checks that dst holds the same records as src, for a second
+// store brought in by a migration: starting to mirror writes into one
+// the records were never copied to (usersctl migrate) leaves it with
+// only some of them. It reads both stores whole, so it has no timeout,
+// and a difference is not retried.
+func Migrated(name string, src, dst users.UserRepository) Check {
+	return Check{Name: name, Timeout: -1, Run: func(ctx context.Context) error {
+		c, err := datamove.Verify(ctx, src, dst)
+		if err != nil {
+			return err
+		}
+		if !c.OK() {
+			return Permanent(fmt.Errorf("bootstrap: the stores differ (%d records missing, %d extra, %d different); copy them with usersctl migrate",
+				c.Missing, c.Extra, c.Differ))
+		}
+		return nil
+	}}
+}
+
+// probeKey is what Blob asks for; it need not exist.
+const probeKey = "bootstrap/probe"
+
+// Blob checks that s answers a read. Not found is an answer.
+func Blob(name string, s blobstore.Store) Check {
+	return Check{Name: name, Run: func(ctx context.Context) error {
+		rc, _, err := s.Get(ctx, probeKey)
+		if errors.Is(err, blobstore.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return rc.Close()
+	}}
+}
+
+// Dial checks that addr takes a connection: a mail server, say, that is
+// only written to when something goes wrong.
+func Dial(name, network, addr string) Check {
+	return Check{Name: name, Run: func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}}
+}
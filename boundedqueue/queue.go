@@ -0,0 +1,268 @@
+// Package boundedqueue wraps a buffered channel with explicit backpressure:
+// producers choose between a non-blocking TryEnqueue and a context-bounded
+// EnqueueCtx, the queue applies a drop policy when full, and watermark
+// callbacks report when consumers fall behind and when they catch up.
+//
+// A plain `ch <- v` either blocks forever or (with select/default) drops
+// silently. Both hide the problem; this type makes it observable.
+package boundedqueue
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrClosed = errors.New("boundedqueue: queue is closed")
+
+// DropPolicy decides what TryEnqueue does when the queue is full.
+type DropPolicy int
+
+const (
+	// Reject refuses the new item; TryEnqueue returns false.
+	Reject DropPolicy = iota
+	// DropNewest accepts the call but drops the new item (counted as dropped).
+	DropNewest
+	// DropOldest evicts the oldest queued item to make room for the new one.
+	DropOldest
+)
+
+func (p DropPolicy) String() string {
+	switch p {
+	case Reject:
+		return "reject"
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	default:
+		return "unknown"
+	}
+}
+
+// Options configures a Queue.
+type Options[T any] struct {
+	Capacity int // must be > 0
+	Policy   DropPolicy
+
+	// OnHigh fires once when depth reaches HighWatermark; OnLow fires once
+	// when it falls back to LowWatermark. 0 disables watermarks.
+	HighWatermark int
+	LowWatermark  int
+	OnHigh        func(depth int)
+	OnLow         func(depth int)
+
+	// OnDrop is called for every item the policy throws away.
+	OnDrop func(item T)
+}
+
+// Queue is safe for concurrent producers and consumers.
+type Queue[T any] struct {
+	opts Options[T]
+	ch   chan T
+
+	// mu guards closing ch: senders hold RLock, Close takes Lock.
+	mu        sync.RWMutex
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
+
+	wmMu sync.Mutex
+	high bool
+
+	enqueued atomic.Int64
+	dropped  atomic.Int64
+	rejected atomic.Int64
+}
+
+func New[T any](opts Options[T]) *Queue[T] {
+	if opts.Capacity <= 0 {
+		opts.Capacity = 1
+	}
+	if opts.HighWatermark > opts.Capacity {
+		opts.HighWatermark = opts.Capacity
+	}
+	if opts.LowWatermark >= opts.HighWatermark {
+		opts.LowWatermark = opts.HighWatermark / 2
+	}
+	return &Queue[T]{
+		opts: opts,
+		ch:   make(chan T, opts.Capacity),
+		done: make(chan struct{}),
+	}
+}
+
+// TryEnqueue never blocks. It returns true if the item is now queued.
+func (q *Queue[T]) TryEnqueue(v T) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+
+	select {
+	case q.ch <- v:
+		q.enqueued.Add(1)
+		q.checkWatermark()
+		return true
+	default:
+	}
+
+	switch q.opts.Policy {
+	case DropNewest:
+		q.drop(v)
+		return false
+
+	case DropOldest:
+		// Other producers may refill the slot between evict and send,
+		// so retry a bounded number of times before giving up.
+		for range 3 {
+			select {
+			case old := <-q.ch:
+				q.drop(old)
+			default:
+			}
+			select {
+			case q.ch <- v:
+				q.enqueued.Add(1)
+				q.checkWatermark()
+				return true
+			default:
+			}
+		}
+		q.drop(v)
+		return false
+
+	default:
+		q.rejected.Add(1)
+		return false
+	}
+}
+
+// EnqueueCtx blocks until there is room, ctx is done or the queue is closed.
+// The drop policy does not apply: waiting is the caller's explicit choice.
+func (q *Queue[T]) EnqueueCtx(ctx context.Context, v T) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrClosed
+	}
+
+	select {
+	case q.ch <- v:
+		q.enqueued.Add(1)
+		q.checkWatermark()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-q.done:
+		return ErrClosed
+	}
+}
+
+// Dequeue waits for the next item. After Close it keeps returning queued
+// items until the queue is drained, then returns ErrClosed.
+func (q *Queue[T]) Dequeue(ctx context.Context) (T, error) {
+	select {
+	case v, ok := <-q.ch:
+		if !ok {
+			var zero T
+			return zero, ErrClosed
+		}
+		q.checkWatermark()
+		return v, nil
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// All ranges over items until the queue is closed and drained.
+func (q *Queue[T]) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for {
+			v, err := q.Dequeue(context.Background())
+			if err != nil {
+				return
+			}
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// Close stops accepting items. Blocked EnqueueCtx calls return ErrClosed.
+func (q *Queue[T]) Close() {
+	q.closeOnce.Do(func() {
+		close(q.done) // wake blocked senders so they release the read lock
+
+		q.mu.Lock()
+		q.closed = true
+		close(q.ch)
+		q.mu.Unlock()
+	})
+}
+
+func (q *Queue[T]) Len() int { return len(q.ch) }
+func (q *Queue[T]) Cap() int { return cap(q.ch) }
+
+// Stats is a snapshot of the queue counters.
+type Stats struct {
+	Depth    int
+	Capacity int
+	Enqueued int64
+	Dropped  int64
+	Rejected int64
+	High     bool // currently above the high watermark
+}
+
+func (q *Queue[T]) Stats() Stats {
+	q.wmMu.Lock()
+	high := q.high
+	q.wmMu.Unlock()
+
+	return Stats{
+		Depth:    len(q.ch),
+		Capacity: cap(q.ch),
+		Enqueued: q.enqueued.Load(),
+		Dropped:  q.dropped.Load(),
+		Rejected: q.rejected.Load(),
+		High:     high,
+	}
+}
+
+func (q *Queue[T]) drop(v T) {
+	q.dropped.Add(1)
+	if q.opts.OnDrop != nil {
+		q.opts.OnDrop(v)
+	}
+}
+
+// checkWatermark flips the high/low state with hysteresis so callbacks fire
+// once per crossing, not on every item.
+func (q *Queue[T]) checkWatermark() {
+	if q.opts.HighWatermark == 0 {
+		return
+	}
+
+	depth := len(q.ch)
+
+	q.wmMu.Lock()
+	var fire func(int)
+	switch {
+	case !q.high && depth >= q.opts.HighWatermark:
+		q.high = true
+		fire = q.opts.OnHigh
+	case q.high && depth <= q.opts.LowWatermark:
+		q.high = false
+		fire = q.opts.OnLow
+	}
+	q.wmMu.Unlock()
+
+	if fire != nil {
+		fire(depth)
+	}
+}
@@ -0,0 +1,180 @@
+// Package breadcrumb records the stages a request goes through, so that
+// when it runs out of time the error says where the time went instead of
+// a bare "context deadline exceeded".
+//
+// New puts a Trail in the request's context. Code along the way marks
+// its stages with Stage, which costs a lock and an append; without a
+// trail in the context it costs nothing. Annotate turns a context error
+// into a *DeadlineError listing every stage with when it started and how
+// long it took, the unfinished ones being where the budget ran out:
+//
+//	context deadline exceeded after 2.003s of a 2s budget:
+//	limiter +0s 1.2ms; users.get +1.3ms 2.002s (unfinished); quota +1.3ms 8µs; repo.get_by_id +1.4ms 2.001s (unfinished)
+package breadcrumb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStages bounds a trail; a stage marked in a loop should not grow it
+// without end. Later stages are counted, not kept.
+const maxStages = 64
+
+// Trail is one request's stages. It is safe for concurrent use.
+type Trail struct {
+	start    time.Time
+	deadline time.Time // zero without one
+
+	mu      sync.Mutex
+	stages  []stage
+	dropped int
+}
+
+type stage struct {
+	name  string
+	start time.Time
+	end   time.Time // zero while running
+}
+
+type ctxKey struct{}
+
+// New starts a trail in ctx, its budget being ctx's deadline, if any.
+func New(ctx context.Context) (context.Context, *Trail) {
+	t := &Trail{start: time.Now()}
+	t.deadline, _ = ctx.Deadline()
+	return context.WithValue(ctx, ctxKey{}, t), t
+}
+
+// From returns ctx's trail, or nil.
+func From(ctx context.Context) *Trail {
+	t, _ := ctx.Value(ctxKey{}).(*Trail)
+	return t
+}
+
+// Stage marks the start of a stage in ctx's trail and returns the func
+// that marks its end; call it once.
+func Stage(ctx context.Context, name string) func() {
+	t := From(ctx)
+	if t == nil {
+		return func() {}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.stages) == maxStages {
+		t.dropped++
+		return func() {}
+	}
+	i := len(t.stages)
+	t.stages = append(t.stages, stage{name: name, start: time.Now()})
+	return func() {
+		now := time.Now()
+		t.mu.Lock()
+		t.stages[i].end = now
+		t.mu.Unlock()
+	}
+}
+
+// StageInfo is one stage, relative to the trail's start.
+type StageInfo struct {
+	Name  string        `json:"name"`
+	Start time.Duration `json:"start"`
+	Took  time.Duration `json:"took"`
+	Done  bool          `json:"done"`
+}
+
+// Stages returns the stages so far, in the order they started. A
+// running stage's Took is up to now.
+func (t *Trail) Stages() []StageInfo {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]StageInfo, len(t.stages))
+	for i, s := range t.stages {
+		end := s.end
+		if end.IsZero() {
+			end = now
+		}
+		out[i] = StageInfo{Name: s.name, Start: s.start.Sub(t.start), Took: end.Sub(s.start), Done: !s.end.IsZero()}
+	}
+	return out
+}
+
+// DeadlineError is a context error with the trail of the request it
+// ended. errors.Is still matches context.DeadlineExceeded or Canceled.
+type DeadlineError struct {
+	Err     error
+	Elapsed time.Duration
+	Budget  time.Duration // 0 without a deadline
+	Stages  []StageInfo
+	// Dropped counts stages past the trail's limit.
+	Dropped int
+}
+
+func (e *DeadlineError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Err.Error())
+	fmt.Fprintf(&b, " after %s", e.Elapsed.Round(time.Millisecond))
+	if e.Budget > 0 {
+		fmt.Fprintf(&b, " of a %s budget", e.Budget.Round(time.Millisecond))
+	}
+	if len(e.Stages) == 0 {
+		return b.String()
+	}
+	b.WriteString(": ")
+	for i, s := range e.Stages {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		fmt.Fprintf(&b, "%s +%s %s", s.Name, round(s.Start), round(s.Took))
+		if !s.Done {
+			b.WriteString(" (unfinished)")
+		}
+	}
+	if e.Dropped > 0 {
+		fmt.Fprintf(&b, "; %d more", e.Dropped)
+	}
+	return b.String()
+}
+
+func (e *DeadlineError) Unwrap() error { return e.Err }
+
+// round keeps three significant digits or so: 2.003s, 1.2ms, 8µs.
+func round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(100 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
+
+// Annotate returns err as a *DeadlineError carrying ctx's trail if it is
+// a context error and not annotated yet; any other error, or any error
+// without a trail, is returned as is.
+func Annotate(ctx context.Context, err error) error {
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	if errors.As(err, new(*DeadlineError)) {
+		return err
+	}
+	t := From(ctx)
+	if t == nil {
+		return err
+	}
+	e := &DeadlineError{Err: err, Elapsed: time.Since(t.start), Stages: t.Stages()}
+	if !t.deadline.IsZero() {
+		e.Budget = t.deadline.Sub(t.start)
+	}
+	t.mu.Lock()
+	e.Dropped = t.dropped
+	t.mu.Unlock()
+	return e
+}
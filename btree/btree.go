@@ -0,0 +1,397 @@
+// Package btree is an in-memory B-tree ordered by a caller-supplied less
+// function. It keeps keys sorted at O(log n) per insert/delete, which is
+// what ordered listing and range queries need without a full scan + sort.
+//
+// Not safe for concurrent use; callers hold their own lock (the repository
+// indexes that use it already do).
+package btree
+
+// BTree maps unique keys (by less) to values.
+type BTree[K any, V any] struct {
+	root   *node[K, V]
+	degree int // minimum degree t: nodes hold t-1 .. 2t-1 items
+	less   func(a, b K) bool
+	length int
+}
+
+type item[K any, V any] struct {
+	key K
+	val V
+}
+
+type node[K any, V any] struct {
+	items    []item[K, V]
+	children []*node[K, V] // empty for leaves
+}
+
+// New creates a tree with minimum degree degree (clamped to >= 2).
+func New[K any, V any](degree int, less func(a, b K) bool) *BTree[K, V] {
+	if degree < 2 {
+		degree = 2
+	}
+	return &BTree[K, V]{degree: degree, less: less}
+}
+
+func (t *BTree[K, V]) Len() int { return t.length }
+
+// Get returns the value stored under key.
+func (t *BTree[K, V]) Get(key K) (V, bool) {
+	for n := t.root; n != nil; {
+		i, found := n.find(key, t.less)
+		if found {
+			return n.items[i].val, true
+		}
+		if n.leaf() {
+			break
+		}
+		n = n.children[i]
+	}
+	var zero V
+	return zero, false
+}
+
+// Set inserts or replaces key. It returns the previous value, if any.
+func (t *BTree[K, V]) Set(key K, val V) (V, bool) {
+	it := item[K, V]{key, val}
+
+	if t.root == nil {
+		t.root = &node[K, V]{items: []item[K, V]{it}}
+		t.length++
+		var zero V
+		return zero, false
+	}
+
+	if len(t.root.items) == t.maxItems() {
+		old := t.root
+		t.root = &node[K, V]{children: []*node[K, V]{old}}
+		t.splitChild(t.root, 0)
+	}
+
+	old, replaced := t.insertNonFull(t.root, it)
+	if !replaced {
+		t.length++
+	}
+	return old, replaced
+}
+
+// Delete removes key and returns its value.
+func (t *BTree[K, V]) Delete(key K) (V, bool) {
+	if t.root == nil {
+		var zero V
+		return zero, false
+	}
+
+	val, ok := t.delete(t.root, key)
+
+	if len(t.root.items) == 0 {
+		if t.root.leaf() {
+			t.root = nil
+		} else {
+			t.root = t.root.children[0]
+		}
+	}
+	if ok {
+		t.length--
+	}
+	return val, ok
+}
+
+// Ascend visits every entry in order until fn returns false.
+func (t *BTree[K, V]) Ascend(fn func(K, V) bool) {
+	if t.root != nil {
+		t.root.ascend(nil, nil, t.less, fn)
+	}
+}
+
+// AscendRange visits entries with from <= key < to, in order.
+func (t *BTree[K, V]) AscendRange(from, to K, fn func(K, V) bool) {
+	if t.root != nil {
+		t.root.ascend(&from, &to, t.less, fn)
+	}
+}
+
+// AscendGreaterOrEqual visits entries with key >= from, in order.
+func (t *BTree[K, V]) AscendGreaterOrEqual(from K, fn func(K, V) bool) {
+	if t.root != nil {
+		t.root.ascend(&from, nil, t.less, fn)
+	}
+}
+
+// Descend visits every entry in reverse order until fn returns false.
+func (t *BTree[K, V]) Descend(fn func(K, V) bool) {
+	if t.root != nil {
+		t.root.descend(fn)
+	}
+}
+
+// Min returns the smallest entry.
+func (t *BTree[K, V]) Min() (K, V, bool) {
+	if t.root == nil {
+		var k K
+		var v V
+		return k, v, false
+	}
+	it := t.root.min()
+	return it.key, it.val, true
+}
+
+// Max returns the largest entry.
+func (t *BTree[K, V]) Max() (K, V, bool) {
+	if t.root == nil {
+		var k K
+		var v V
+		return k, v, false
+	}
+	it := t.root.max()
+	return it.key, it.val, true
+}
+
+/*
+-----------------------------------
+INSERT
+-----------------------------------
+*/
+
+func (t *BTree[K, V]) maxItems() int { return 2*t.degree - 1 }
+
+// splitChild splits the full child n.children[i] around its median, which
+// moves up into n. n itself must not be full.
+func (t *BTree[K, V]) splitChild(n *node[K, V], i int) {
+	child := n.children[i]
+	mid := t.degree - 1
+	median := child.items[mid]
+
+	right := &node[K, V]{
+		items: append([]item[K, V](nil), child.items[mid+1:]...),
+	}
+	if !child.leaf() {
+		right.children = append([]*node[K, V](nil), child.children[mid+1:]...)
+		clear(child.children[mid+1:])
+		child.children = child.children[:mid+1]
+	}
+	clear(child.items[mid:])
+	child.items = child.items[:mid]
+
+	n.items = insertAt(n.items, i, median)
+	n.children = insertAt(n.children, i+1, right)
+}
+
+// insertNonFull descends, splitting full children on the way down so the
+// insert never has to propagate back up.
+func (t *BTree[K, V]) insertNonFull(n *node[K, V], it item[K, V]) (V, bool) {
+	for {
+		i, found := n.find(it.key, t.less)
+		if found {
+			old := n.items[i].val
+			n.items[i] = it
+			return old, true
+		}
+
+		if n.leaf() {
+			n.items = insertAt(n.items, i, it)
+			var zero V
+			return zero, false
+		}
+
+		if len(n.children[i].items) == t.maxItems() {
+			t.splitChild(n, i)
+			switch {
+			case t.less(n.items[i].key, it.key):
+				i++
+			case !t.less(it.key, n.items[i].key):
+				old := n.items[i].val
+				n.items[i] = it
+				return old, true
+			}
+		}
+		n = n.children[i]
+	}
+}
+
+/*
+-----------------------------------
+DELETE
+-----------------------------------
+*/
+
+// delete removes key from the subtree rooted at n. Before descending into a
+// child it makes sure the child has at least t items, so removing one never
+// underflows (CLRS 18.3).
+func (t *BTree[K, V]) delete(n *node[K, V], key K) (V, bool) {
+	i, found := n.find(key, t.less)
+
+	if n.leaf() {
+		if !found {
+			var zero V
+			return zero, false
+		}
+		val := n.items[i].val
+		n.items = removeAt(n.items, i)
+		return val, true
+	}
+
+	if found {
+		val := n.items[i].val
+		switch {
+		case len(n.children[i].items) >= t.degree:
+			pred := n.children[i].max()
+			n.items[i] = pred
+			t.delete(n.children[i], pred.key)
+		case len(n.children[i+1].items) >= t.degree:
+			succ := n.children[i+1].min()
+			n.items[i] = succ
+			t.delete(n.children[i+1], succ.key)
+		default:
+			t.merge(n, i)
+			t.delete(n.children[i], key)
+		}
+		return val, true
+	}
+
+	if len(n.children[i].items) < t.degree {
+		i = t.fill(n, i)
+	}
+	return t.delete(n.children[i], key)
+}
+
+// fill gives n.children[i] an extra item by borrowing from a sibling or
+// merging with one. It returns the index of the child that now covers i.
+func (t *BTree[K, V]) fill(n *node[K, V], i int) int {
+	switch {
+	case i > 0 && len(n.children[i-1].items) >= t.degree:
+		// Rotate right: separator comes down, left's max goes up.
+		child, left := n.children[i], n.children[i-1]
+		child.items = insertAt(child.items, 0, n.items[i-1])
+		n.items[i-1] = left.items[len(left.items)-1]
+		left.items = removeAt(left.items, len(left.items)-1)
+		if !left.leaf() {
+			child.children = insertAt(child.children, 0, left.children[len(left.children)-1])
+			left.children = removeAt(left.children, len(left.children)-1)
+		}
+		return i
+
+	case i < len(n.items) && len(n.children[i+1].items) >= t.degree:
+		// Rotate left: separator comes down, right's min goes up.
+		child, right := n.children[i], n.children[i+1]
+		child.items = append(child.items, n.items[i])
+		n.items[i] = right.items[0]
+		right.items = removeAt(right.items, 0)
+		if !right.leaf() {
+			child.children = append(child.children, right.children[0])
+			right.children = removeAt(right.children, 0)
+		}
+		return i
+
+	case i < len(n.items):
+		t.merge(n, i)
+		return i
+
+	default:
+		t.merge(n, i-1)
+		return i - 1
+	}
+}
+
+// merge folds n.items[i] and n.children[i+1] into n.children[i].
+func (t *BTree[K, V]) merge(n *node[K, V], i int) {
+	left, right := n.children[i], n.children[i+1]
+	left.items = append(left.items, n.items[i])
+	left.items = append(left.items, right.items...)
+	left.children = append(left.children, right.children...)
+
+	n.items = removeAt(n.items, i)
+	n.children = removeAt(n.children, i+1)
+}
+
+/*
+-----------------------------------
+NODE HELPERS
+-----------------------------------
+*/
+
+func (n *node[K, V]) leaf() bool { return len(n.children) == 0 }
+
+// find returns the index of the first item >= key and whether it equals key.
+func (n *node[K, V]) find(key K, less func(a, b K) bool) (int, bool) {
+	lo, hi := 0, len(n.items)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if less(n.items[mid].key, key) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	found := lo < len(n.items) && !less(key, n.items[lo].key)
+	return lo, found
+}
+
+func (n *node[K, V]) min() item[K, V] {
+	for !n.leaf() {
+		n = n.children[0]
+	}
+	return n.items[0]
+}
+
+func (n *node[K, V]) max() item[K, V] {
+	for !n.leaf() {
+		n = n.children[len(n.children)-1]
+	}
+	return n.items[len(n.items)-1]
+}
+
+// ascend walks in order, skipping subtrees entirely below from and stopping
+// at the first key >= to. It returns false once iteration should stop.
+func (n *node[K, V]) ascend(from, to *K, less func(a, b K) bool, fn func(K, V) bool) bool {
+	start := 0
+	if from != nil {
+		start, _ = n.find(*from, less)
+	}
+
+	for i := start; i < len(n.items); i++ {
+		if !n.leaf() && !n.children[i].ascend(from, to, less, fn) {
+			return false
+		}
+		if to != nil && !less(n.items[i].key, *to) {
+			return false
+		}
+		if !fn(n.items[i].key, n.items[i].val) {
+			return false
+		}
+	}
+
+	if !n.leaf() {
+		return n.children[len(n.items)].ascend(from, to, less, fn)
+	}
+	return true
+}
+
+func (n *node[K, V]) descend(fn func(K, V) bool) bool {
+	for i := len(n.items) - 1; i >= 0; i-- {
+		if !n.leaf() && !n.children[i+1].descend(fn) {
+			return false
+		}
+		if !fn(n.items[i].key, n.items[i].val) {
+			return false
+		}
+	}
+	if !n.leaf() {
+		return n.children[0].descend(fn)
+	}
+	return true
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removeAt[T any](s []T, i int) []T {
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
@@ -0,0 +1,132 @@
+// Package buildinfo says which build is running: the version, the commit
+// it was built from and when, so a log line, a crash report or a bug
+// report can be matched to the code.
+//
+// Most of it comes from runtime/debug.ReadBuildInfo, which the go command
+// fills in: the module version when built with `go install module@v1.2.3`
+// (a pseudo-version built inside a git checkout), and there the VCS
+// revision, commit time and dirty flag too. Building a file rather than
+// a package, as `go build ./Basic-Go/Basic2.go` does, records none of
+// it. A release build sets what the go command cannot know:
+//
+//	go build -ldflags "-X Go-Internals/buildinfo.version=v1.4.0 -X Go-Internals/buildinfo.date=$(date -u +%FT%TZ)" ./Basic-Go/Basic2.go
+//
+// Without either, Version is "dev".
+package buildinfo
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Set at link time with -X; see the package doc.
+var (
+	version string
+	date    string // RFC 3339
+)
+
+// Info is a build's identity.
+type Info struct {
+	Version string `json:"version"`
+	// Module is the main module's path.
+	Module string `json:"module,omitempty"`
+	// Revision is the VCS commit, empty outside a checkout.
+	Revision string `json:"revision,omitempty"`
+	// Modified is set when the checkout had uncommitted changes.
+	Modified bool `json:"modified,omitempty"`
+	// CommitTime is the revision's commit time.
+	CommitTime time.Time `json:"commit_time,omitzero"`
+	// BuildTime is set only by -X; the go command records no such thing,
+	// to keep builds reproducible.
+	BuildTime time.Time `json:"build_time,omitzero"`
+	GoVersion string    `json:"go_version"`
+	Platform  string    `json:"platform"`
+}
+
+// Get returns the running binary's Info.
+var Get = sync.OnceValue(read)
+
+func read() Info {
+	info := Info{Version: version, GoVersion: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	if t, err := time.Parse(time.RFC3339, date); err == nil {
+		info.BuildTime = t
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if ok {
+		info.Module = bi.Main.Path
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.Revision = s.Value
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			case "vcs.time":
+				info.CommitTime, _ = time.Parse(time.RFC3339, s.Value)
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+}
+
+// Short is the version and abbreviated revision, "v1.4.0 (3f2a9c1)" or
+// "dev (3f2a9c1, modified)": what goes on every log line.
+func (i Info) Short() string {
+	var extra []string
+	if i.Revision != "" {
+		extra = append(extra, i.Revision[:min(len(i.Revision), 7)])
+	}
+	if i.Modified {
+		extra = append(extra, "modified")
+	}
+	if len(extra) == 0 {
+		return i.Version
+	}
+	return i.Version + " (" + strings.Join(extra, ", ") + ")"
+}
+
+// String is Short with the times and toolchain.
+func (i Info) String() string {
+	var b strings.Builder
+	b.WriteString(i.Short())
+	if !i.CommitTime.IsZero() {
+		fmt.Fprintf(&b, ", committed %s", i.CommitTime.UTC().Format(time.RFC3339))
+	}
+	if !i.BuildTime.IsZero() {
+		fmt.Fprintf(&b, ", built %s", i.BuildTime.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, ", %s %s", i.GoVersion, i.Platform)
+	return b.String()
+}
+
+// LogValue logs an Info as its fields, grouped.
+func (i Info) LogValue() slog.Value {
+	attrs := []slog.Attr{slog.String("version", i.Version)}
+	if i.Revision != "" {
+		attrs = append(attrs, slog.String("revision", i.Revision), slog.Bool("modified", i.Modified))
+	}
+	if !i.BuildTime.IsZero() {
+		attrs = append(attrs, slog.Time("built", i.BuildTime))
+	}
+	return slog.GroupValue(append(attrs, slog.String("go", i.GoVersion))...)
+}
+
+// Handler serves Get() as JSON.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Get())
+	})
+}
@@ -0,0 +1,471 @@
+// Code generated by repogen -type Product; DO NOT EDIT.
+
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/index"
+	"Go-Internals/openapi"
+)
+
+var (
+	ErrProductNotFound = errors.New("catalog: product not found")
+	ErrProductSKUTaken = errors.New("catalog: product SKU already taken")
+)
+
+/*
+-----------------------------------
+INTERFACE
+-----------------------------------
+*/
+
+// ProductRepository is the storage contract for Product.
+type ProductRepository interface {
+	// Create assigns ID and CreatedAt.
+	Create(v Product) (Product, error)
+	GetByID(id int) (Product, error)
+	// List returns every product in ID order.
+	List() []Product
+	// Update replaces a stored product, keeping its CreatedAt.
+	Update(v Product) (Product, error)
+	Delete(id int) error
+	Len() int
+
+	GetBySKU(sku string) (Product, error)
+	// RangeBySKU returns from <= SKU < to, in SKU order.
+	RangeBySKU(from, to string) []Product
+	FindByCategory(category string) []Product
+	// RangeByCategory returns from <= Category < to, in Category order.
+	RangeByCategory(from, to string) []Product
+	FindByPriceCents(priceCents int64) []Product
+	// RangeByPriceCents returns from <= PriceCents < to, in PriceCents order.
+	RangeByPriceCents(from, to int64) []Product
+}
+
+/*
+-----------------------------------
+IN-MEMORY REPOSITORY
+-----------------------------------
+*/
+
+// InMemoryProductRepo keeps products in a map with one index per indexed
+// field, maintained on every write.
+type InMemoryProductRepo struct {
+	mu     sync.Mutex
+	items  map[int]Product
+	nextID int
+
+	indexes      *index.Set[Product]
+	bySKU        *index.Ordered[Product, string]
+	byCategory   *index.Ordered[Product, string]
+	byPriceCents *index.Ordered[Product, int64]
+}
+
+func NewInMemoryProductRepo() *InMemoryProductRepo {
+	r := &InMemoryProductRepo{items: make(map[int]Product), nextID: 1}
+	r.bySKU = index.NewOrderedCmp("SKU", func(v Product) string { return strings.ToLower(v.SKU) },
+		index.Options{Unique: true, ErrDuplicate: ErrProductSKUTaken})
+	r.byCategory = index.NewOrderedCmp("Category", func(v Product) string { return v.Category },
+		index.Options{})
+	r.byPriceCents = index.NewOrderedCmp("PriceCents", func(v Product) int64 { return v.PriceCents },
+		index.Options{})
+	r.indexes = index.NewSet[Product](r.bySKU, r.byCategory, r.byPriceCents)
+	return r
+}
+
+func (r *InMemoryProductRepo) Create(v Product) (Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v.ID = r.nextID
+	v.CreatedAt = time.Now()
+	if err := r.indexes.Insert(v.ID, v); err != nil {
+		return Product{}, err
+	}
+	r.items[v.ID] = v
+	r.nextID++
+	return v, nil
+}
+
+func (r *InMemoryProductRepo) GetByID(id int) (Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v, ok := r.items[id]
+	if !ok {
+		return Product{}, ErrProductNotFound
+	}
+	return v, nil
+}
+
+func (r *InMemoryProductRepo) List() []Product {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(slices.Sorted(maps.Keys(r.items)))
+}
+
+func (r *InMemoryProductRepo) Update(v Product) (Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.items[v.ID]
+	if !ok {
+		return Product{}, ErrProductNotFound
+	}
+	v.CreatedAt = old.CreatedAt
+	if err := r.indexes.Update(v.ID, old, v); err != nil {
+		return Product{}, err
+	}
+	r.items[v.ID] = v
+	return v, nil
+}
+
+func (r *InMemoryProductRepo) Delete(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.items[id]
+	if !ok {
+		return ErrProductNotFound
+	}
+	r.indexes.Remove(id, old)
+	delete(r.items, id)
+	return nil
+}
+
+func (r *InMemoryProductRepo) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.items)
+}
+
+func (r *InMemoryProductRepo) GetBySKU(sku string) (Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := r.bySKU.Lookup(strings.ToLower(sku))
+	if len(ids) == 0 {
+		return Product{}, ErrProductNotFound
+	}
+	return r.items[ids[0]], nil
+}
+
+func (r *InMemoryProductRepo) RangeBySKU(from, to string) []Product {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(r.bySKU.Range(strings.ToLower(from), strings.ToLower(to)))
+}
+
+func (r *InMemoryProductRepo) FindByCategory(category string) []Product {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(r.byCategory.Lookup(category))
+}
+
+func (r *InMemoryProductRepo) RangeByCategory(from, to string) []Product {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(r.byCategory.Range(from, to))
+}
+
+func (r *InMemoryProductRepo) FindByPriceCents(priceCents int64) []Product {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(r.byPriceCents.Lookup(priceCents))
+}
+
+func (r *InMemoryProductRepo) RangeByPriceCents(from, to int64) []Product {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(r.byPriceCents.Range(from, to))
+}
+
+func (r *InMemoryProductRepo) resolveLocked(ids []int) []Product {
+	out := make([]Product, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, r.items[id])
+	}
+	return out
+}
+
+/*
+-----------------------------------
+FAKE
+-----------------------------------
+*/
+
+// FakeProductRepo is an in-memory repository for tests of code that uses
+// one: it records every call by method name and fails the methods it is
+// told to.
+type FakeProductRepo struct {
+	repo *InMemoryProductRepo
+
+	mu    sync.Mutex
+	calls []string
+	fail  map[string]error
+}
+
+func NewFakeProductRepo() *FakeProductRepo {
+	return &FakeProductRepo{repo: NewInMemoryProductRepo(), fail: make(map[string]error)}
+}
+
+// FailOn makes every call to method ("Create", "GetByID", ...) return err
+// without touching the data; a nil err clears it. Methods without an
+// error result ignore it.
+func (f *FakeProductRepo) FailOn(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.fail, method)
+	} else {
+		f.fail[method] = err
+	}
+}
+
+// Calls returns the methods called so far, in order.
+func (f *FakeProductRepo) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+func (f *FakeProductRepo) call(method string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, method)
+	return f.fail[method]
+}
+
+func (f *FakeProductRepo) Create(v Product) (Product, error) {
+	if err := f.call("Create"); err != nil {
+		return Product{}, err
+	}
+	return f.repo.Create(v)
+}
+
+func (f *FakeProductRepo) GetByID(id int) (Product, error) {
+	if err := f.call("GetByID"); err != nil {
+		return Product{}, err
+	}
+	return f.repo.GetByID(id)
+}
+
+func (f *FakeProductRepo) List() []Product {
+	f.call("List")
+	return f.repo.List()
+}
+
+func (f *FakeProductRepo) Update(v Product) (Product, error) {
+	if err := f.call("Update"); err != nil {
+		return Product{}, err
+	}
+	return f.repo.Update(v)
+}
+
+func (f *FakeProductRepo) Delete(id int) error {
+	if err := f.call("Delete"); err != nil {
+		return err
+	}
+	return f.repo.Delete(id)
+}
+
+func (f *FakeProductRepo) Len() int {
+	f.call("Len")
+	return f.repo.Len()
+}
+
+func (f *FakeProductRepo) GetBySKU(sku string) (Product, error) {
+	if err := f.call("GetBySKU"); err != nil {
+		return Product{}, err
+	}
+	return f.repo.GetBySKU(sku)
+}
+
+func (f *FakeProductRepo) RangeBySKU(from, to string) []Product {
+	f.call("RangeBySKU")
+	return f.repo.RangeBySKU(from, to)
+}
+
+func (f *FakeProductRepo) FindByCategory(category string) []Product {
+	f.call("FindByCategory")
+	return f.repo.FindByCategory(category)
+}
+
+func (f *FakeProductRepo) RangeByCategory(from, to string) []Product {
+	f.call("RangeByCategory")
+	return f.repo.RangeByCategory(from, to)
+}
+
+func (f *FakeProductRepo) FindByPriceCents(priceCents int64) []Product {
+	f.call("FindByPriceCents")
+	return f.repo.FindByPriceCents(priceCents)
+}
+
+func (f *FakeProductRepo) RangeByPriceCents(from, to int64) []Product {
+	f.call("RangeByPriceCents")
+	return f.repo.RangeByPriceCents(from, to)
+}
+
+/*
+-----------------------------------
+HTTP HANDLERS
+-----------------------------------
+*/
+
+// ProductHandlers serves a ProductRepository as JSON:
+//
+//	GET    {prefix}        list
+//	POST   {prefix}        create (201, Location header)
+//	GET    {prefix}/{id}   get
+//	PUT    {prefix}/{id}   update (the path's ID wins over the body's)
+//	DELETE {prefix}/{id}   delete (204)
+//
+// A Product with a Validate() error method is validated before create and
+// update; a failure answers 400.
+type ProductHandlers struct {
+	Repo ProductRepository
+}
+
+// Routes describes the routes under prefix, e.g. "/products", for an
+// openapi.API, which documents and validates them.
+func (h *ProductHandlers) Routes(prefix string) []openapi.Route {
+	var errBody map[string]string
+	id := []openapi.Param{openapi.PathInt("id")}
+	tags := []string{"products"}
+	return []openapi.Route{
+		{Operation: openapi.Operation{Pattern: "GET " + prefix, Summary: "List products", Tags: tags,
+			Responses: map[int]any{http.StatusOK: []Product{}}}, Handler: http.HandlerFunc(h.list)},
+		{Operation: openapi.Operation{Pattern: "POST " + prefix, Summary: "Create a product", Tags: tags, Body: Product{},
+			Responses: map[int]any{http.StatusCreated: Product{}, http.StatusBadRequest: errBody, http.StatusConflict: errBody}}, Handler: http.HandlerFunc(h.create)},
+		{Operation: openapi.Operation{Pattern: "GET " + prefix + "/{id}", Summary: "Get a product", Tags: tags, Params: id,
+			Responses: map[int]any{http.StatusOK: Product{}, http.StatusNotFound: errBody}}, Handler: http.HandlerFunc(h.get)},
+		{Operation: openapi.Operation{Pattern: "PUT " + prefix + "/{id}", Summary: "Replace a product", Tags: tags, Params: id, Body: Product{},
+			Responses: map[int]any{http.StatusOK: Product{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody, http.StatusConflict: errBody}}, Handler: http.HandlerFunc(h.update)},
+		{Operation: openapi.Operation{Pattern: "DELETE " + prefix + "/{id}", Summary: "Delete a product", Tags: tags, Params: id,
+			Responses: map[int]any{http.StatusNoContent: nil, http.StatusNotFound: errBody}}, Handler: http.HandlerFunc(h.delete)},
+	}
+}
+
+// Register adds the routes under prefix to mux, undocumented.
+func (h *ProductHandlers) Register(mux *http.ServeMux, prefix string) {
+	for _, rt := range h.Routes(prefix) {
+		mux.Handle(rt.Pattern, rt.Handler)
+	}
+}
+
+func (h *ProductHandlers) list(w http.ResponseWriter, r *http.Request) {
+	writeProductJSON(w, http.StatusOK, h.Repo.List())
+}
+
+func (h *ProductHandlers) get(w http.ResponseWriter, r *http.Request) {
+	id, ok := productPathID(w, r)
+	if !ok {
+		return
+	}
+	v, err := h.Repo.GetByID(id)
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+	writeProductJSON(w, http.StatusOK, v)
+}
+
+func (h *ProductHandlers) create(w http.ResponseWriter, r *http.Request) {
+	v, ok := decodeProduct(w, r)
+	if !ok {
+		return
+	}
+	v, err := h.Repo.Create(v)
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.Itoa(v.ID))
+	writeProductJSON(w, http.StatusCreated, v)
+}
+
+func (h *ProductHandlers) update(w http.ResponseWriter, r *http.Request) {
+	id, ok := productPathID(w, r)
+	if !ok {
+		return
+	}
+	v, ok := decodeProduct(w, r)
+	if !ok {
+		return
+	}
+	v.ID = id
+	v, err := h.Repo.Update(v)
+	if err != nil {
+		writeProductError(w, err)
+		return
+	}
+	writeProductJSON(w, http.StatusOK, v)
+}
+
+func (h *ProductHandlers) delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := productPathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Repo.Delete(id); err != nil {
+		writeProductError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func productPathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func decodeProduct(w http.ResponseWriter, r *http.Request) (Product, bool) {
+	var v Product
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&v); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return v, false
+	}
+	if val, ok := any(&v).(interface{ Validate() error }); ok {
+		if err := val.Validate(); err != nil {
+			writeProductJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return v, false
+		}
+	}
+	return v, true
+}
+
+func writeProductError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrProductNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrProductSKUTaken):
+		status = http.StatusConflict
+	}
+	writeProductJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeProductJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
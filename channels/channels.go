@@ -0,0 +1,162 @@
+// Package channels has the channel patterns that keep being written by
+// hand: closing without panicking, fanning in, stopping a range with a
+// context, duplicating a stream and flattening a stream of streams.
+//
+// Every function that starts a goroutine takes a context and the
+// goroutine returns when it is done or its input is closed, whichever
+// comes first, closing what it returned; none of them leak a goroutine
+// blocked on a send nobody will receive. After ctx is done a reader may
+// still see a value or two that was in flight.
+package channels
+
+import (
+	"context"
+	"sync"
+)
+
+// SafeClose closes ch, reporting false instead of panicking if it was
+// already closed.
+//
+// A close that might come twice usually means nobody owns the channel:
+// the fix is to make closing one place's job (the last sender's, after a
+// WaitGroup; see internals/races). SafeClose is for where that cannot be
+// arranged, such as a shutdown that several paths may trigger. A send on
+// a closed channel still panics.
+func SafeClose[T any](ch chan T) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	close(ch)
+	return true
+}
+
+// SafeSend sends v on ch, reporting false instead of panicking if ch is
+// closed. It blocks like a send, so only for a channel whose reader keeps
+// reading until it is closed.
+func SafeSend[T any](ch chan<- T, v T) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	ch <- v
+	return true
+}
+
+// OrDone relays in until it is closed or ctx is done, so that
+//
+//	for v := range channels.OrDone(ctx, in)
+//
+// stops with the context instead of blocking on an in that never closes.
+func OrDone[T any](ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				if !send(ctx, out, v) {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Merge relays every value of chs onto one channel, which is closed once
+// all of them are (or ctx is done). Values from one input keep their
+// order; across inputs there is none.
+func Merge[T any](ctx context.Context, chs ...<-chan T) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+	for _, ch := range chs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for v := range OrDone(ctx, ch) {
+				if !send(ctx, out, v) {
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// Tee sends every value of in to both outputs, the next value only once
+// both have taken this one: the slower reader sets the pace, and one that
+// stops reading stops both, so each must read to the end or cancel ctx.
+func Tee[T any](ctx context.Context, in <-chan T) (<-chan T, <-chan T) {
+	out1, out2 := make(chan T), make(chan T)
+	go func() {
+		defer close(out1)
+		defer close(out2)
+		for v := range OrDone(ctx, in) {
+			// A nil channel is never ready: each output gets v once.
+			o1, o2 := out1, out2
+			for range 2 {
+				select {
+				case <-ctx.Done():
+					return
+				case o1 <- v:
+					o1 = nil
+				case o2 <- v:
+					o2 = nil
+				}
+			}
+		}
+	}()
+	return out1, out2
+}
+
+// Bridge reads the channels sent on chans one after another, relaying
+// each to the end before the next, as one channel: a sequence of pages or
+// batches, each its own channel, read as a single stream.
+func Bridge[T any](ctx context.Context, chans <-chan <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for ch := range OrDone(ctx, chans) {
+			for v := range OrDone(ctx, ch) {
+				if !send(ctx, out, v) {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Wait returns a channel closed once wg's count reaches zero, so waiting
+// for it can be a case in a select beside a deadline. Until then it
+// holds a goroutine blocked in wg.Wait.
+func Wait(wg *sync.WaitGroup) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// send sends v on out unless ctx is done first.
+func send[T any](ctx context.Context, out chan<- T, v T) bool {
+	select {
+	case out <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,176 @@
+package channels
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gen sends vs on a channel it then closes, or stops when ctx is done.
+func gen(ctx context.Context, vs ...int) <-chan int {
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for _, v := range vs {
+			if !send(ctx, out, v) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// collect reads ch until it is closed, failing if that takes too long.
+func collect[T any](t *testing.T, ch <-chan T) []T {
+	t.Helper()
+	var out []T
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case v, ok := <-ch:
+			if !ok {
+				return out
+			}
+			out = append(out, v)
+		case <-timeout:
+			t.Fatalf("channel not closed; read %v", out)
+		}
+	}
+}
+
+// closes waits for ch to close, discarding what is still in flight.
+func closes[T any](t *testing.T, ch <-chan T) {
+	t.Helper()
+	collect(t, ch)
+}
+
+func TestSafeClose(t *testing.T) {
+	ch := make(chan int)
+	if !SafeClose(ch) {
+		t.Fatal("first close reported false")
+	}
+	if SafeClose(ch) {
+		t.Fatal("second close reported true")
+	}
+	if SafeSend(ch, 1) {
+		t.Fatal("send on a closed channel reported true")
+	}
+}
+
+func TestOrDone(t *testing.T) {
+	ctx := context.Background()
+	if got := collect(t, OrDone(ctx, gen(ctx, 1, 2, 3))); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Fatalf("OrDone = %v, want [1 2 3]", got)
+	}
+}
+
+func TestOrDoneCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	never := make(chan int) // never sent on nor closed
+	out := OrDone(ctx, never)
+	cancel()
+	closes(t, out)
+}
+
+func TestMerge(t *testing.T) {
+	ctx := context.Background()
+	got := collect(t, Merge(ctx, gen(ctx, 1, 2, 3), gen(ctx, 10, 20), gen(ctx)))
+	var first, second []int
+	for _, v := range got {
+		if v < 10 {
+			first = append(first, v)
+		} else {
+			second = append(second, v)
+		}
+	}
+	// Each input's order is kept, whatever the interleaving.
+	if !slices.Equal(first, []int{1, 2, 3}) || !slices.Equal(second, []int{10, 20}) {
+		t.Fatalf("Merge = %v", got)
+	}
+}
+
+func TestMergeCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// Inputs that never end, and a reader that stops after one value:
+	// cancelling must still end every relay, which closes out.
+	endless := func() <-chan int {
+		ch := make(chan int)
+		go func() {
+			defer close(ch)
+			for i := 0; send(ctx, ch, i); i++ {
+			}
+		}()
+		return ch
+	}
+	out := Merge(ctx, endless(), endless(), endless())
+	<-out
+	cancel()
+	closes(t, out)
+}
+
+func TestTee(t *testing.T) {
+	ctx := context.Background()
+	a, b := Tee(ctx, gen(ctx, 1, 2, 3))
+	// Tee waits for both readers, so they read at once.
+	var gotB []int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for v := range b {
+			gotB = append(gotB, v)
+		}
+	}()
+	gotA := collect(t, a)
+	<-done
+	if !slices.Equal(gotA, []int{1, 2, 3}) || !slices.Equal(gotB, []int{1, 2, 3}) {
+		t.Fatalf("Tee = %v, %v", gotA, gotB)
+	}
+}
+
+func TestTeeCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a, b := Tee(ctx, gen(ctx, 1, 2, 3))
+	// Only a reads: the tee blocks on b until cancelled, then closes both.
+	<-a
+	cancel()
+	closes(t, a)
+	closes(t, b)
+}
+
+func TestBridge(t *testing.T) {
+	ctx := context.Background()
+	chans := make(chan (<-chan int))
+	go func() {
+		defer close(chans)
+		chans <- gen(ctx, 1, 2)
+		chans <- gen(ctx)
+		chans <- gen(ctx, 3)
+	}()
+	if got := collect(t, Bridge(ctx, chans)); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Fatalf("Bridge = %v, want [1 2 3]", got)
+	}
+}
+
+func TestBridgeCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	chans := make(chan (<-chan int), 1)
+	chans <- make(chan int) // a stream that never ends
+	out := Bridge(ctx, chans)
+	cancel()
+	closes(t, out)
+}
+
+func TestWait(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	done := Wait(&wg)
+	select {
+	case <-done:
+		t.Fatal("closed before the count reached zero")
+	case <-time.After(10 * time.Millisecond):
+	}
+	wg.Done()
+	closes(t, done)
+}
@@ -0,0 +1,203 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a manually advanced Clock. Timers and tickers fire only when
+// Advance or Set moves time past their deadline, in deadline order.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	changed chan struct{} // closed and replaced whenever waiters change
+}
+
+type fakeWaiter struct {
+	clock    *Fake
+	deadline time.Time
+	period   time.Duration // > 0 for tickers
+	ch       chan time.Time
+	active   bool
+}
+
+// NewFake returns a fake clock starting at start.
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start, changed: make(chan struct{})}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration { return f.Now().Sub(t) }
+
+func (f *Fake) After(d time.Duration) <-chan time.Time { return f.NewTimer(d).C() }
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.add(d, 0)
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(d, d)}
+}
+
+// Advance moves time forward by d, firing everything that becomes due.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves time to t (never backwards), firing everything that becomes due.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if t.Before(f.now) {
+		return
+	}
+
+	for {
+		w := f.nextDueLocked(t)
+		if w == nil {
+			break
+		}
+		f.now = w.deadline
+		select {
+		case w.ch <- f.now:
+		default: // like time.Ticker, drop ticks nobody read
+		}
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+			f.sortLocked()
+		} else {
+			f.removeLocked(w)
+		}
+	}
+	f.now = t
+}
+
+// Waiters returns how many timers and tickers are pending.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil waits until at least n timers/tickers are pending. It lets a
+// test wait for a goroutine to reach its select before calling Advance.
+func (f *Fake) BlockUntil(n int) {
+	for {
+		f.mu.Lock()
+		if len(f.waiters) >= n {
+			f.mu.Unlock()
+			return
+		}
+		ch := f.changed
+		f.mu.Unlock()
+		<-ch
+	}
+}
+
+func (f *Fake) add(d, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &fakeWaiter{
+		clock:    f,
+		deadline: f.now.Add(d),
+		period:   period,
+		ch:       make(chan time.Time, 1),
+	}
+	if d <= 0 && period == 0 {
+		w.ch <- f.now
+		return w
+	}
+	f.insertLocked(w)
+	return w
+}
+
+func (f *Fake) nextDueLocked(t time.Time) *fakeWaiter {
+	if len(f.waiters) == 0 || f.waiters[0].deadline.After(t) {
+		return nil
+	}
+	return f.waiters[0]
+}
+
+func (f *Fake) insertLocked(w *fakeWaiter) {
+	w.active = true
+	f.waiters = append(f.waiters, w)
+	f.sortLocked()
+	f.notifyLocked()
+}
+
+func (f *Fake) removeLocked(w *fakeWaiter) bool {
+	if !w.active {
+		return false
+	}
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			break
+		}
+	}
+	w.active = false
+	f.notifyLocked()
+	return true
+}
+
+func (f *Fake) sortLocked() {
+	sort.SliceStable(f.waiters, func(i, j int) bool {
+		return f.waiters[i].deadline.Before(f.waiters[j].deadline)
+	})
+}
+
+func (f *Fake) notifyLocked() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// fakeWaiter implements Timer directly.
+
+func (w *fakeWaiter) C() <-chan time.Time { return w.ch }
+
+func (w *fakeWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.clock.removeLocked(w)
+}
+
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	f := w.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	wasActive := f.removeLocked(w)
+	w.deadline = f.now.Add(d)
+	if d <= 0 && w.period == 0 {
+		select {
+		case w.ch <- f.now:
+		default:
+		}
+		return wasActive
+	}
+	f.insertLocked(w)
+	return wasActive
+}
+
+type fakeTicker struct{ w *fakeWaiter }
+
+func (t fakeTicker) C() <-chan time.Time { return t.w.ch }
+func (t fakeTicker) Stop()               { t.w.Stop() }
+func (t fakeTicker) Reset(d time.Duration) {
+	t.w.clock.mu.Lock()
+	t.w.period = d
+	t.w.clock.mu.Unlock()
+	t.w.Reset(d)
+}
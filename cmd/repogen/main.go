@@ -0,0 +1,275 @@
+// repogen writes the repository stack for an entity struct: the storage
+// interface, an in-memory implementation with indexes (package index),
+// a fake for callers' tests, and HTTP handlers. It is what the users
+// package was written by hand, generated, so a new entity is one struct
+// and one go:generate line:
+//
+//	//go:generate go run Go-Internals/cmd/repogen -type Product
+//	type Product struct {
+//		ID        int       `json:"id" repo:"id"`
+//		SKU       string    `json:"sku" repo:"unique,fold"`
+//		Category  string    `json:"category" repo:"index"`
+//		CreatedAt time.Time `json:"created_at" repo:"created"`
+//	}
+//
+// Tags (key "repo", comma-separated):
+//
+//	id       the primary key, an int assigned by Create (required)
+//	created  a time.Time stamped by Create and kept by Update
+//	unique   a unique index: GetBy<Field>, and Err<Type><Field>Taken on conflict
+//	index    a non-unique index: FindBy<Field>
+//	fold     with unique or index on a string: compare case-insensitively
+//
+// Indexed fields must be strings, integers, floats or time.Time, and get
+// RangeBy<Field>(from, to) too. The output is <type>_repo_gen.go next to
+// the source (-o overrides); it is meant to be committed, like any
+// generated code, so building never needs the generator.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+func main() {
+	typeName := flag.String("type", "", "entity struct to generate for (required)")
+	dir := flag.String("dir", ".", "package directory")
+	out := flag.String("o", "", "output file (default <type>_repo_gen.go in -dir)")
+	flag.Parse()
+	if *typeName == "" {
+		fmt.Fprintln(os.Stderr, "usage: repogen -type Name [-dir DIR] [-o FILE]")
+		os.Exit(2)
+	}
+	if *out == "" {
+		*out = filepath.Join(*dir, strings.ToLower(*typeName)+"_repo_gen.go")
+	}
+	if err := run(*dir, *typeName, *out); err != nil {
+		fmt.Fprintln(os.Stderr, "repogen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, typeName, out string) error {
+	e, err := load(dir, typeName, filepath.Base(out))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, e); err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		// Write it anyway: the line numbers in err point into it.
+		os.WriteFile(out, buf.Bytes(), 0o644)
+		return fmt.Errorf("generated code does not parse (written unformatted to %s): %w", out, err)
+	}
+	return os.WriteFile(out, src, 0o644)
+}
+
+/*
+-----------------------------------
+ENTITY DESCRIPTION
+-----------------------------------
+*/
+
+// entity is what the templates are executed with.
+type entity struct {
+	Package string
+	Type    string
+	Noun    string // lowercased Type, for messages
+	ID      string
+	Created string
+	Fields  []indexed
+}
+
+// indexed is one field with a unique or non-unique index.
+type indexed struct {
+	Name   string
+	Type   string // as written: string, int64, time.Time
+	Unique bool
+	Fold   bool
+}
+
+// Param is the field name as a parameter: SKU → sku, PriceCents →
+// priceCents, URLPath → urlPath.
+func (f indexed) Param() string {
+	n := 0
+	for n < len(f.Name) && unicode.IsUpper(rune(f.Name[n])) {
+		n++
+	}
+	if n > 1 && n < len(f.Name) {
+		n-- // the last capital starts the next word
+	}
+	p := strings.ToLower(f.Name[:n]) + f.Name[n:]
+	if token.IsKeyword(p) {
+		return "key"
+	}
+	return p
+}
+
+func (f indexed) IsTime() bool { return f.Type == "time.Time" }
+
+// Key is the index key of the entity e, as an expression.
+func (f indexed) Key(e string) string {
+	if f.Fold {
+		return "strings.ToLower(" + e + "." + f.Name + ")"
+	}
+	return e + "." + f.Name
+}
+
+// Arg is a lookup argument normalized like the key.
+func (f indexed) Arg(name string) string {
+	if f.Fold {
+		return "strings.ToLower(" + name + ")"
+	}
+	return name
+}
+
+func (e entity) HasFold() bool {
+	for _, f := range e.Fields {
+		if f.Fold {
+			return true
+		}
+	}
+	return false
+}
+
+func (e entity) HasUnique() bool {
+	for _, f := range e.Fields {
+		if f.Unique {
+			return true
+		}
+	}
+	return false
+}
+
+func (e entity) HasTime() bool {
+	if e.Created != "" {
+		return true
+	}
+	for _, f := range e.Fields {
+		if f.IsTime() {
+			return true
+		}
+	}
+	return false
+}
+
+var errNoID = errors.New(`no field tagged repo:"id"`)
+
+var ordered = map[string]bool{
+	"string": true, "int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"float32": true, "float64": true, "time.Time": true,
+}
+
+// load finds typeName among the package's files, skipping tests and the
+// file being generated.
+func load(dir, typeName, skip string) (entity, error) {
+	fset := token.NewFileSet()
+	matches, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return entity{}, err
+	}
+	for _, path := range matches {
+		if strings.HasSuffix(path, "_test.go") || filepath.Base(path) == skip {
+			continue
+		}
+		f, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return entity{}, err
+		}
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ts.Name.Name != typeName {
+					continue
+				}
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					return entity{}, fmt.Errorf("%s is not a struct", typeName)
+				}
+				e, err := describe(st)
+				e.Package, e.Type, e.Noun = f.Name.Name, typeName, strings.ToLower(typeName)
+				if err != nil {
+					return e, fmt.Errorf("%s: %w", typeName, err)
+				}
+				return e, nil
+			}
+		}
+	}
+	return entity{}, fmt.Errorf("type %s not found in %s", typeName, dir)
+}
+
+func describe(st *ast.StructType) (entity, error) {
+	var e entity
+	for _, fd := range st.Fields.List {
+		if fd.Tag == nil || len(fd.Names) == 0 {
+			continue
+		}
+		tag := reflect.StructTag(strings.Trim(fd.Tag.Value, "`")).Get("repo")
+		if tag == "" {
+			continue
+		}
+		typ := types.ExprString(fd.Type)
+		for _, name := range fd.Names {
+			f := indexed{Name: name.Name, Type: typ}
+			var index bool
+			for _, opt := range strings.Split(tag, ",") {
+				switch opt {
+				case "id":
+					if typ != "int" {
+						return e, fmt.Errorf("id field %s is %s, want int", f.Name, typ)
+					}
+					e.ID = f.Name
+				case "created":
+					if typ != "time.Time" {
+						return e, fmt.Errorf("created field %s is %s, want time.Time", f.Name, typ)
+					}
+					e.Created = f.Name
+				case "unique":
+					f.Unique, index = true, true
+				case "index":
+					index = true
+				case "fold":
+					f.Fold = true
+				default:
+					return e, fmt.Errorf("field %s: unknown repo tag option %q", f.Name, opt)
+				}
+			}
+			if f.Fold && typ != "string" {
+				return e, fmt.Errorf("field %s: fold needs a string, not %s", f.Name, typ)
+			}
+			if index {
+				if !ordered[typ] {
+					return e, fmt.Errorf("field %s: cannot index %s", f.Name, typ)
+				}
+				e.Fields = append(e.Fields, f)
+			}
+		}
+	}
+	if e.ID == "" {
+		return e, errNoID
+	}
+	return e, nil
+}
+
+var tmpl = template.Must(template.New("repo").Parse(repoTemplate))
@@ -0,0 +1,459 @@
+package main
+
+// repoTemplate is executed with an entity. The output is gofmt'ed, so
+// whitespace here only has to be valid, not pretty.
+const repoTemplate = `// Code generated by repogen -type {{.Type}}; DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+{{- if .HasFold}}
+	"strings"
+{{- end}}
+	"sync"
+{{- if .HasTime}}
+	"time"
+{{- end}}
+
+	"Go-Internals/index"
+	"Go-Internals/openapi"
+)
+
+{{$T := .Type}}{{$ID := .ID}}{{$created := .Created}}
+var (
+	Err{{$T}}NotFound = errors.New("{{.Package}}: {{.Noun}} not found")
+{{- range .Fields}}{{if .Unique}}
+	Err{{$T}}{{.Name}}Taken = errors.New("{{$.Package}}: {{$.Noun}} {{.Name}} already taken")
+{{- end}}{{end}}
+)
+
+/*
+-----------------------------------
+INTERFACE
+-----------------------------------
+*/
+
+// {{$T}}Repository is the storage contract for {{$T}}.
+type {{$T}}Repository interface {
+	// Create assigns {{$ID}}{{if $created}} and {{$created}}{{end}}.
+	Create(v {{$T}}) ({{$T}}, error)
+	GetByID(id int) ({{$T}}, error)
+	// List returns every {{.Noun}} in {{$ID}} order.
+	List() []{{$T}}
+	// Update replaces a stored {{.Noun}}{{if $created}}, keeping its {{$created}}{{end}}.
+	Update(v {{$T}}) ({{$T}}, error)
+	Delete(id int) error
+	Len() int
+{{range .Fields}}
+{{- if .Unique}}
+	GetBy{{.Name}}({{.Param}} {{.Type}}) ({{$T}}, error)
+{{- else}}
+	FindBy{{.Name}}({{.Param}} {{.Type}}) []{{$T}}
+{{- end}}
+	// RangeBy{{.Name}} returns from <= {{.Name}} < to, in {{.Name}} order.
+	RangeBy{{.Name}}(from, to {{.Type}}) []{{$T}}
+{{- end}}
+}
+
+/*
+-----------------------------------
+IN-MEMORY REPOSITORY
+-----------------------------------
+*/
+
+// InMemory{{$T}}Repo keeps {{.Noun}}s in a map with one index per indexed
+// field, maintained on every write.
+type InMemory{{$T}}Repo struct {
+	mu     sync.Mutex
+	items  map[int]{{$T}}
+	nextID int
+
+	indexes *index.Set[{{$T}}]
+{{- range .Fields}}
+	by{{.Name}} *index.Ordered[{{$T}}, {{.Type}}]
+{{- end}}
+}
+
+func NewInMemory{{$T}}Repo() *InMemory{{$T}}Repo {
+	r := &InMemory{{$T}}Repo{items: make(map[int]{{$T}}), nextID: 1}
+{{- range .Fields}}
+{{- if .IsTime}}
+	r.by{{.Name}} = index.NewOrdered("{{.Name}}", func(v {{$T}}) time.Time { return v.{{.Name}} },
+		time.Time.Compare, index.Options{ {{- if .Unique}}Unique: true, ErrDuplicate: Err{{$T}}{{.Name}}Taken{{end -}} })
+{{- else}}
+	r.by{{.Name}} = index.NewOrderedCmp("{{.Name}}", func(v {{$T}}) {{.Type}} { return {{.Key "v"}} },
+		index.Options{ {{- if .Unique}}Unique: true, ErrDuplicate: Err{{$T}}{{.Name}}Taken{{end -}} })
+{{- end}}
+{{- end}}
+	r.indexes = index.NewSet[{{$T}}]({{range $i, $f := .Fields}}{{if $i}}, {{end}}r.by{{$f.Name}}{{end}})
+	return r
+}
+
+func (r *InMemory{{$T}}Repo) Create(v {{$T}}) ({{$T}}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v.{{$ID}} = r.nextID
+{{- if $created}}
+	v.{{$created}} = time.Now()
+{{- end}}
+	if err := r.indexes.Insert(v.{{$ID}}, v); err != nil {
+		return {{$T}}{}, err
+	}
+	r.items[v.{{$ID}}] = v
+	r.nextID++
+	return v, nil
+}
+
+func (r *InMemory{{$T}}Repo) GetByID(id int) ({{$T}}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v, ok := r.items[id]
+	if !ok {
+		return {{$T}}{}, Err{{$T}}NotFound
+	}
+	return v, nil
+}
+
+func (r *InMemory{{$T}}Repo) List() []{{$T}} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(slices.Sorted(maps.Keys(r.items)))
+}
+
+func (r *InMemory{{$T}}Repo) Update(v {{$T}}) ({{$T}}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.items[v.{{$ID}}]
+	if !ok {
+		return {{$T}}{}, Err{{$T}}NotFound
+	}
+{{- if $created}}
+	v.{{$created}} = old.{{$created}}
+{{- end}}
+	if err := r.indexes.Update(v.{{$ID}}, old, v); err != nil {
+		return {{$T}}{}, err
+	}
+	r.items[v.{{$ID}}] = v
+	return v, nil
+}
+
+func (r *InMemory{{$T}}Repo) Delete(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.items[id]
+	if !ok {
+		return Err{{$T}}NotFound
+	}
+	r.indexes.Remove(id, old)
+	delete(r.items, id)
+	return nil
+}
+
+func (r *InMemory{{$T}}Repo) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.items)
+}
+{{range .Fields}}
+{{- if .Unique}}
+func (r *InMemory{{$T}}Repo) GetBy{{.Name}}({{.Param}} {{.Type}}) ({{$T}}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := r.by{{.Name}}.Lookup({{.Arg .Param}})
+	if len(ids) == 0 {
+		return {{$T}}{}, Err{{$T}}NotFound
+	}
+	return r.items[ids[0]], nil
+}
+{{- else}}
+func (r *InMemory{{$T}}Repo) FindBy{{.Name}}({{.Param}} {{.Type}}) []{{$T}} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(r.by{{.Name}}.Lookup({{.Arg .Param}}))
+}
+{{- end}}
+
+func (r *InMemory{{$T}}Repo) RangeBy{{.Name}}(from, to {{.Type}}) []{{$T}} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(r.by{{.Name}}.Range({{.Arg "from"}}, {{.Arg "to"}}))
+}
+{{end}}
+func (r *InMemory{{$T}}Repo) resolveLocked(ids []int) []{{$T}} {
+	out := make([]{{$T}}, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, r.items[id])
+	}
+	return out
+}
+
+/*
+-----------------------------------
+FAKE
+-----------------------------------
+*/
+
+// Fake{{$T}}Repo is an in-memory repository for tests of code that uses
+// one: it records every call by method name and fails the methods it is
+// told to.
+type Fake{{$T}}Repo struct {
+	repo *InMemory{{$T}}Repo
+
+	mu    sync.Mutex
+	calls []string
+	fail  map[string]error
+}
+
+func NewFake{{$T}}Repo() *Fake{{$T}}Repo {
+	return &Fake{{$T}}Repo{repo: NewInMemory{{$T}}Repo(), fail: make(map[string]error)}
+}
+
+// FailOn makes every call to method ("Create", "GetByID", ...) return err
+// without touching the data; a nil err clears it. Methods without an
+// error result ignore it.
+func (f *Fake{{$T}}Repo) FailOn(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.fail, method)
+	} else {
+		f.fail[method] = err
+	}
+}
+
+// Calls returns the methods called so far, in order.
+func (f *Fake{{$T}}Repo) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+func (f *Fake{{$T}}Repo) call(method string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, method)
+	return f.fail[method]
+}
+
+func (f *Fake{{$T}}Repo) Create(v {{$T}}) ({{$T}}, error) {
+	if err := f.call("Create"); err != nil {
+		return {{$T}}{}, err
+	}
+	return f.repo.Create(v)
+}
+
+func (f *Fake{{$T}}Repo) GetByID(id int) ({{$T}}, error) {
+	if err := f.call("GetByID"); err != nil {
+		return {{$T}}{}, err
+	}
+	return f.repo.GetByID(id)
+}
+
+func (f *Fake{{$T}}Repo) List() []{{$T}} {
+	f.call("List")
+	return f.repo.List()
+}
+
+func (f *Fake{{$T}}Repo) Update(v {{$T}}) ({{$T}}, error) {
+	if err := f.call("Update"); err != nil {
+		return {{$T}}{}, err
+	}
+	return f.repo.Update(v)
+}
+
+func (f *Fake{{$T}}Repo) Delete(id int) error {
+	if err := f.call("Delete"); err != nil {
+		return err
+	}
+	return f.repo.Delete(id)
+}
+
+func (f *Fake{{$T}}Repo) Len() int {
+	f.call("Len")
+	return f.repo.Len()
+}
+{{range .Fields}}
+{{- if .Unique}}
+func (f *Fake{{$T}}Repo) GetBy{{.Name}}({{.Param}} {{.Type}}) ({{$T}}, error) {
+	if err := f.call("GetBy{{.Name}}"); err != nil {
+		return {{$T}}{}, err
+	}
+	return f.repo.GetBy{{.Name}}({{.Param}})
+}
+{{- else}}
+func (f *Fake{{$T}}Repo) FindBy{{.Name}}({{.Param}} {{.Type}}) []{{$T}} {
+	f.call("FindBy{{.Name}}")
+	return f.repo.FindBy{{.Name}}({{.Param}})
+}
+{{- end}}
+
+func (f *Fake{{$T}}Repo) RangeBy{{.Name}}(from, to {{.Type}}) []{{$T}} {
+	f.call("RangeBy{{.Name}}")
+	return f.repo.RangeBy{{.Name}}(from, to)
+}
+{{end}}
+/*
+-----------------------------------
+HTTP HANDLERS
+-----------------------------------
+*/
+
+// {{$T}}Handlers serves a {{$T}}Repository as JSON:
+//
+//	GET    {prefix}        list
+//	POST   {prefix}        create (201, Location header)
+//	GET    {prefix}/{id}   get
+//	PUT    {prefix}/{id}   update (the path's ID wins over the body's)
+//	DELETE {prefix}/{id}   delete (204)
+//
+// A {{$T}} with a Validate() error method is validated before create and
+// update; a failure answers 400.
+type {{$T}}Handlers struct {
+	Repo {{$T}}Repository
+}
+
+// Routes describes the routes under prefix, e.g. "/products", for an
+// openapi.API, which documents and validates them.
+func (h *{{$T}}Handlers) Routes(prefix string) []openapi.Route {
+	var errBody map[string]string
+	id := []openapi.Param{openapi.PathInt("id")}
+	tags := []string{"{{.Noun}}s"}
+	return []openapi.Route{
+		{Operation: openapi.Operation{Pattern: "GET " + prefix, Summary: "List {{.Noun}}s", Tags: tags,
+			Responses: map[int]any{http.StatusOK: []{{$T}}{}}}, Handler: http.HandlerFunc(h.list)},
+		{Operation: openapi.Operation{Pattern: "POST " + prefix, Summary: "Create a {{.Noun}}", Tags: tags, Body: {{$T}}{},
+			Responses: map[int]any{http.StatusCreated: {{$T}}{}, http.StatusBadRequest: errBody{{if .HasUnique}}, http.StatusConflict: errBody{{end}}}}, Handler: http.HandlerFunc(h.create)},
+		{Operation: openapi.Operation{Pattern: "GET " + prefix + "/{id}", Summary: "Get a {{.Noun}}", Tags: tags, Params: id,
+			Responses: map[int]any{http.StatusOK: {{$T}}{}, http.StatusNotFound: errBody}}, Handler: http.HandlerFunc(h.get)},
+		{Operation: openapi.Operation{Pattern: "PUT " + prefix + "/{id}", Summary: "Replace a {{.Noun}}", Tags: tags, Params: id, Body: {{$T}}{},
+			Responses: map[int]any{http.StatusOK: {{$T}}{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody{{if .HasUnique}}, http.StatusConflict: errBody{{end}}}}, Handler: http.HandlerFunc(h.update)},
+		{Operation: openapi.Operation{Pattern: "DELETE " + prefix + "/{id}", Summary: "Delete a {{.Noun}}", Tags: tags, Params: id,
+			Responses: map[int]any{http.StatusNoContent: nil, http.StatusNotFound: errBody}}, Handler: http.HandlerFunc(h.delete)},
+	}
+}
+
+// Register adds the routes under prefix to mux, undocumented.
+func (h *{{$T}}Handlers) Register(mux *http.ServeMux, prefix string) {
+	for _, rt := range h.Routes(prefix) {
+		mux.Handle(rt.Pattern, rt.Handler)
+	}
+}
+
+func (h *{{$T}}Handlers) list(w http.ResponseWriter, r *http.Request) {
+	write{{$T}}JSON(w, http.StatusOK, h.Repo.List())
+}
+
+func (h *{{$T}}Handlers) get(w http.ResponseWriter, r *http.Request) {
+	id, ok := {{.Noun}}PathID(w, r)
+	if !ok {
+		return
+	}
+	v, err := h.Repo.GetByID(id)
+	if err != nil {
+		write{{$T}}Error(w, err)
+		return
+	}
+	write{{$T}}JSON(w, http.StatusOK, v)
+}
+
+func (h *{{$T}}Handlers) create(w http.ResponseWriter, r *http.Request) {
+	v, ok := decode{{$T}}(w, r)
+	if !ok {
+		return
+	}
+	v, err := h.Repo.Create(v)
+	if err != nil {
+		write{{$T}}Error(w, err)
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.Itoa(v.{{$ID}}))
+	write{{$T}}JSON(w, http.StatusCreated, v)
+}
+
+func (h *{{$T}}Handlers) update(w http.ResponseWriter, r *http.Request) {
+	id, ok := {{.Noun}}PathID(w, r)
+	if !ok {
+		return
+	}
+	v, ok := decode{{$T}}(w, r)
+	if !ok {
+		return
+	}
+	v.{{$ID}} = id
+	v, err := h.Repo.Update(v)
+	if err != nil {
+		write{{$T}}Error(w, err)
+		return
+	}
+	write{{$T}}JSON(w, http.StatusOK, v)
+}
+
+func (h *{{$T}}Handlers) delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := {{.Noun}}PathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Repo.Delete(id); err != nil {
+		write{{$T}}Error(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func {{.Noun}}PathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func decode{{$T}}(w http.ResponseWriter, r *http.Request) ({{$T}}, bool) {
+	var v {{$T}}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&v); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return v, false
+	}
+	if val, ok := any(&v).(interface{ Validate() error }); ok {
+		if err := val.Validate(); err != nil {
+			write{{$T}}JSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return v, false
+		}
+	}
+	return v, true
+}
+
+func write{{$T}}Error(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, Err{{$T}}NotFound):
+		status = http.StatusNotFound
+{{- range .Fields}}{{if .Unique}}
+	case errors.Is(err, Err{{$T}}{{.Name}}Taken):
+		status = http.StatusConflict
+{{- end}}{{end}}
+	}
+	write{{$T}}JSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func write{{$T}}JSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+`
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"Go-Internals/atrest"
+	"Go-Internals/backup"
+	"Go-Internals/bandwidth"
+	"Go-Internals/blobstore"
+)
+
+func init() {
+	register("backup", "write a full or incremental backup of a store (or -verify one)", runBackup)
+	register("restore", "restore a backup chain into an empty store", runRestore)
+}
+
+// Backups are taken from the bare backend, so fields encrypted by
+// $USERS_FIELD_KEY stay sealed in the archive; archives themselves are
+// sealed with $USERS_DATA_KEYS when it is set.
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	store := addStoreFlags(fs)
+	dir := fs.String("dir", "backups", "backup directory")
+	incremental := fs.Bool("incremental", false, "only write what changed since the last archive")
+	verify := fs.Bool("verify", false, "check every archive in -dir instead of writing one")
+	upload := fs.String("upload", "", "then copy new archives to this blob store (s3://bucket/prefix, a directory)")
+	bwlimit := fs.Int64("bwlimit", 0, "cap -upload at this many bytes per second (0 = unlimited)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	keys, err := atrest.FromEnv()
+	if err != nil {
+		return err
+	}
+	if *verify {
+		return verifyBackups(*dir, keys)
+	}
+
+	repo, closeRepo, err := store.openBackend()
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+	kind := backup.Full
+	if *incremental {
+		kind = backup.Incremental
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	info, err := backup.Create(ctx, *dir, repo, backup.Options{Kind: kind, Store: *store.kind, Keys: keys})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s backup #%d, %d records\n", info.Path, info.Kind, info.Seq, info.Records)
+	if *upload == "" {
+		return nil
+	}
+	blobs, err := blobstore.Open(*upload)
+	if err != nil {
+		return err
+	}
+	n, err := backup.Upload(ctx, *dir, blobs, "backups/", bandwidth.New(*bwlimit, nil))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("uploaded %d archives to %s\n", n, *upload)
+	return nil
+}
+
+func verifyBackups(dir string, keys *atrest.Keyring) error {
+	all, err := backup.List(dir)
+	if err != nil {
+		return err
+	}
+	if len(all) == 0 {
+		return fmt.Errorf("no archives in %s", dir)
+	}
+	bad := 0
+	for _, a := range all {
+		info, err := backup.Verify(a.Path, keys)
+		if err != nil {
+			fmt.Printf("%s: %v\n", a.Path, err)
+			bad++
+			continue
+		}
+		fmt.Printf("%s: ok (%s #%d, parent %d, %d records, %s)\n", a.Path, info.Kind, info.Seq, info.Parent, info.Records, info.Created.Format("2006-01-02 15:04:05"))
+	}
+	if bad > 0 {
+		return fmt.Errorf("%d of %d archives failed verification", bad, len(all))
+	}
+	return nil
+}
+
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	store := addStoreFlags(fs)
+	dir := fs.String("dir", "backups", "backup directory")
+	seq := fs.Uint64("seq", 0, "restore point: archive sequence number (0 = latest)")
+	fetch := fs.String("fetch", "", "first download missing archives into -dir from this blob store")
+	bwlimit := fs.Int64("bwlimit", 0, "cap -fetch at this many bytes per second (0 = unlimited)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	keys, err := atrest.FromEnv()
+	if err != nil {
+		return err
+	}
+
+	repo, closeRepo, err := store.openBackend()
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+	target, ok := repo.(backup.Target)
+	if !ok {
+		return errors.New("restore: store " + *store.kind + " cannot take records with their IDs")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *fetch != "" {
+		blobs, err := blobstore.Open(*fetch)
+		if err != nil {
+			return err
+		}
+		got, err := backup.Fetch(ctx, blobs, "backups/", *dir, bandwidth.New(*bwlimit, nil))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("fetched %d archives from %s\n", got, *fetch)
+	}
+	n, err := backup.Restore(ctx, *dir, *seq, target, backup.Options{Keys: keys})
+	if errors.Is(err, backup.ErrNotEmpty) {
+		return fmt.Errorf("%w; restore into a new -data path", err)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %d users from %s\n", n, *dir)
+	return nil
+}
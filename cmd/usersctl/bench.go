@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"Go-Internals/histogram"
+	"Go-Internals/users"
+)
+
+func init() {
+	register("bench", "load-generate create/get/list against a service", runBench)
+}
+
+// benchTarget is what the load generator drives.
+type benchTarget interface {
+	Create(ctx context.Context, name, email string) (int, error)
+	Get(ctx context.Context, id int) error
+	List(ctx context.Context) error
+}
+
+type benchOp int
+
+const (
+	opCreate benchOp = iota
+	opGet
+	opList
+	numOps
+)
+
+var opNames = [numOps]string{"create", "get", "list"}
+
+// runBench implements
+//
+//	usersctl bench [-target inproc|http://host:port|grpc://...] [-mix create=1,get=8,list=1]
+//	               [-c 8] [-d 10s] [-seed 100] [-token jwt] [-store memory|mmap]
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	target := fs.String("target", "inproc", "inproc, or the base URL of an HTTP server")
+	mixFlag := fs.String("mix", "create=1,get=8,list=1", "relative weights of the operations")
+	conc := fs.Int("c", 8, "concurrent workers")
+	dur := fs.Duration("d", 10*time.Second, "test duration")
+	seed := fs.Int("seed", 100, "users to create before measuring")
+	token := fs.String("token", "", "bearer token for HTTP targets")
+	store := addStoreFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	mix, err := parseMix(*mixFlag)
+	if err != nil {
+		return err
+	}
+
+	var t benchTarget
+	var repoLat *users.InstrumentedRepo
+	switch {
+	case *target == "inproc":
+		repo, closeRepo, err := store.open()
+		if err != nil {
+			return err
+		}
+		defer closeRepo()
+		repoLat = users.Instrument(repo)
+		t = inprocTarget{users.NewUserService(repoLat)}
+	case strings.HasPrefix(*target, "http://"), strings.HasPrefix(*target, "https://"):
+		t = newHTTPTarget(*target, *token, *conc)
+	case strings.HasPrefix(*target, "grpc://"):
+		return errors.New("bench: no gRPC server exists yet; use inproc or an HTTP target")
+	default:
+		return fmt.Errorf("bench: unknown target %q", *target)
+	}
+
+	b := &bench{target: t, mix: mix}
+	if err := b.seed(*seed); err != nil {
+		return fmt.Errorf("bench: seeding: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "running %s against %s with %d workers...\n", *dur, *target, *conc)
+	res := b.run(*conc, *dur)
+	res.report(os.Stdout)
+
+	if repoLat != nil {
+		fmt.Println()
+		fmt.Println("repository latency (includes seeding):")
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "method\tcalls\tp50\tp99\tmax\t")
+		for _, m := range []string{users.MethodCreate, users.MethodGetByID, users.MethodList} {
+			h := repoLat.Latencies()[m]
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t\n", m, h.Count(),
+				h.QuantileDuration(0.50), h.QuantileDuration(0.99), time.Duration(h.Max()))
+		}
+		tw.Flush()
+	}
+	return nil
+}
+
+// parseMix turns "create=1,get=8" into cumulative weights.
+func parseMix(s string) ([numOps]int, error) {
+	var w [numOps]int
+	for part := range strings.SplitSeq(s, ",") {
+		name, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		i := slices.Index(opNames[:], name)
+		n, err := strconv.Atoi(val)
+		if !ok || i < 0 || err != nil || n < 0 {
+			return w, fmt.Errorf("bench: bad -mix entry %q", part)
+		}
+		w[i] = n
+	}
+	total := 0
+	for i := range w {
+		total += w[i]
+		w[i] = total
+	}
+	if total == 0 {
+		return w, errors.New("bench: -mix has no non-zero weight")
+	}
+	return w, nil
+}
+
+type bench struct {
+	target benchTarget
+	mix    [numOps]int // cumulative weights
+	maxID  atomic.Int64
+	seq    atomic.Int64 // unique emails
+}
+
+func (b *bench) seed(n int) error {
+	ctx := context.Background()
+	for range n {
+		if _, err := b.create(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *bench) create(ctx context.Context) (int, error) {
+	n := b.seq.Add(1)
+	id, err := b.target.Create(ctx, "bench user", fmt.Sprintf("bench-%d-%d@example.com", time.Now().UnixNano(), n))
+	if err == nil {
+		for cur := b.maxID.Load(); int64(id) > cur && !b.maxID.CompareAndSwap(cur, int64(id)); cur = b.maxID.Load() {
+		}
+	}
+	return id, err
+}
+
+func (b *bench) pick(r *rand.Rand) benchOp {
+	x := r.IntN(b.mix[numOps-1])
+	for op := range numOps {
+		if x < b.mix[op] {
+			return op
+		}
+	}
+	return opList
+}
+
+// benchResult holds one latency histogram per op, shared by all workers.
+type benchResult struct {
+	elapsed time.Duration
+	lat     [numOps]*histogram.Histogram
+	errs    [numOps]atomic.Int64
+}
+
+func (b *bench) run(conc int, dur time.Duration) *benchResult {
+	ctx, cancel := context.WithTimeout(context.Background(), dur)
+	defer cancel()
+
+	res := &benchResult{}
+	for op := range numOps {
+		res.lat[op] = histogram.New()
+	}
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := range conc {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := rand.New(rand.NewPCG(uint64(w), uint64(start.UnixNano())))
+			for ctx.Err() == nil {
+				op := b.pick(r)
+				t0 := time.Now()
+				var err error
+				switch op {
+				case opCreate:
+					_, err = b.create(ctx)
+				case opGet:
+					err = b.target.Get(ctx, 1+r.IntN(int(max(b.maxID.Load(), 1))))
+				case opList:
+					err = b.target.List(ctx)
+				}
+				if ctx.Err() != nil {
+					return // cut off by the deadline: not a real sample
+				}
+				res.lat[op].RecordDuration(time.Since(t0))
+				if err != nil {
+					res.errs[op].Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	return res
+}
+
+func (r *benchResult) report(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\tcount\terrors\treq/s\tp50\tp95\tp99\tmax\t")
+	all := histogram.New()
+	var errs int64
+	for op := range numOps {
+		all.Merge(r.lat[op])
+		errs += r.errs[op].Load()
+		r.row(tw, opNames[op], r.lat[op], r.errs[op].Load())
+	}
+	r.row(tw, "total", all, errs)
+	tw.Flush()
+}
+
+func (r *benchResult) row(w io.Writer, name string, h *histogram.Histogram, errs int64) {
+	if h.Count() == 0 {
+		return
+	}
+	rps := float64(h.Count()) / r.elapsed.Seconds()
+	fmt.Fprintf(w, "%s\t%d\t%d\t%.0f\t%s\t%s\t%s\t%s\t\n", name, h.Count(), errs, rps,
+		h.QuantileDuration(0.50), h.QuantileDuration(0.95), h.QuantileDuration(0.99), time.Duration(h.Max()))
+}
+
+/*
+-----------------------------------
+TARGETS
+-----------------------------------
+*/
+
+// inprocTarget calls the service directly: no serialization, no network,
+// so it measures the service and repository alone.
+type inprocTarget struct{ svc *users.UserService }
+
+func (t inprocTarget) Create(ctx context.Context, name, email string) (int, error) {
+	u, err := t.svc.RegisterUser(ctx, name, email)
+	return u.ID, err
+}
+
+func (t inprocTarget) Get(ctx context.Context, id int) error {
+	_, err := t.svc.GetUser(ctx, id)
+	if errors.Is(err, users.ErrUserNotFound) {
+		return nil // ids race with creates; a miss is a valid answer
+	}
+	return err
+}
+
+func (t inprocTarget) List(ctx context.Context) error {
+	_, err := t.svc.ListUsers(ctx)
+	return err
+}
+
+// httpTarget drives the httpapi server.
+type httpTarget struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func newHTTPTarget(base, token string, conc int) *httpTarget {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConnsPerHost = conc // otherwise workers churn connections
+	return &httpTarget{
+		base:   strings.TrimSuffix(base, "/"),
+		token:  token,
+		client: &http.Client{Transport: tr, Timeout: 10 * time.Second},
+	}
+}
+
+func (t *httpTarget) do(ctx context.Context, method, path string, body []byte, want int, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, t.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want && !(method == http.MethodGet && resp.StatusCode == http.StatusNotFound) {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out != nil && resp.StatusCode == want {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	_, err = io.Copy(io.Discard, resp.Body) // drain so the connection is reused
+	return err
+}
+
+func (t *httpTarget) Create(ctx context.Context, name, email string) (int, error) {
+	body, _ := json.Marshal(map[string]string{"name": name, "email": email})
+	var u users.User
+	err := t.do(ctx, http.MethodPost, "/users", body, http.StatusCreated, &u)
+	return u.ID, err
+}
+
+func (t *httpTarget) Get(ctx context.Context, id int) error {
+	return t.do(ctx, http.MethodGet, "/users/"+strconv.Itoa(id), nil, http.StatusOK, nil)
+}
+
+func (t *httpTarget) List(ctx context.Context) error {
+	return t.do(ctx, http.MethodGet, "/users", nil, http.StatusOK, nil)
+}
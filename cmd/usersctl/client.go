@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"Go-Internals/reqsign"
+)
+
+// signingKeyEnv names a file holding the key, one of the server's
+// -peer-keys with its secret, that usersctl signs its calls to a running
+// server with.
+const signingKeyEnv = "USERS_SIGNING_KEY"
+
+// httpClient is the client for calls to a running server, signing them
+// when $USERS_SIGNING_KEY is set.
+func httpClient(timeout time.Duration) (*http.Client, error) {
+	client := &http.Client{Timeout: timeout}
+	path := os.Getenv(signingKeyEnv)
+	if path == "" {
+		return client, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var k reqsign.Key
+	if err := json.Unmarshal(b, &k); err != nil {
+		return nil, fmt.Errorf("$%s: %w", signingKeyEnv, err)
+	}
+	signer, err := reqsign.NewSigner(k)
+	if err != nil {
+		return nil, err
+	}
+	client.Transport = &reqsign.Transport{Signer: signer}
+	return client, nil
+}
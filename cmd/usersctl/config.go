@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"Go-Internals/config"
+)
+
+func init() {
+	register("config", "print the server's effective configuration, or validate it, from the same flags, environment and -config file", runConfig)
+}
+
+// runConfig loads the server's configuration as the server would, from
+// the flags after --, the environment, the -config file and the -env
+// profile they name:
+//
+//	usersctl config print [-json] [-changed] [-- server flags]
+//	usersctl config validate [-- server flags]
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "print" && args[0] != "validate" {
+		return errors.New("config: want print or validate")
+	}
+	sub, args := args[0], args[1:]
+	fs := flag.NewFlagSet("config "+sub, flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print: as JSON")
+	changed := fs.Bool("changed", false, "print: only the values not left at their defaults")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var cfg config.Server
+	server := flag.NewFlagSet("server", flag.ContinueOnError)
+	cfg.Define(server)
+	loaded, err := config.Parse(server, fs.Args(), config.ServerOptions)
+	if err != nil {
+		return err
+	}
+
+	if sub == "validate" {
+		err := cfg.Validate()
+		if err == nil {
+			fmt.Println("config: ok")
+			return nil
+		}
+		var problems []error
+		if j, ok := err.(interface{ Unwrap() []error }); ok {
+			problems = j.Unwrap()
+		} else {
+			problems = []error{err}
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		return fmt.Errorf("config: %d problems", len(problems))
+	}
+
+	var values []config.Value
+	for _, v := range loaded.Values() {
+		if !*changed || v.Source != config.Default {
+			values = append(values, v)
+		}
+	}
+	secrets := make(map[string]bool, len(config.SecretEnv))
+	for _, name := range config.SecretEnv {
+		_, secrets[name] = os.LookupEnv(name)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			File    string          `json:"file,omitempty"`
+			Profile string          `json:"profile,omitempty"`
+			Flags   []config.Value  `json:"flags"`
+			Secrets map[string]bool `json:"secrets"`
+		}{loaded.File(), loaded.Profile(), values, secrets})
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FLAG\tVALUE\tSOURCE")
+	for _, v := range values {
+		source := string(v.Source)
+		switch v.Source {
+		case config.Env:
+			source += " $" + v.Env
+		case config.File:
+			source += " " + loaded.File()
+		case config.Profile:
+			source += " " + loaded.Profile()
+		}
+		value := v.Value
+		if value == "" {
+			value = `""`
+		}
+		fmt.Fprintf(tw, "-%s\t%s\t%s\n", v.Name, value, source)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "SECRET\tVALUE")
+	for _, name := range config.SecretEnv {
+		state := "unset"
+		if secrets[name] {
+			state = config.Redacted
+		}
+		fmt.Fprintf(tw, "$%s\t%s\n", name, state)
+	}
+	return tw.Flush()
+}
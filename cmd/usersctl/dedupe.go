@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"Go-Internals/dedupe"
+	"Go-Internals/users"
+)
+
+func init() {
+	register("find-duplicates", "list pairs of users that are likely one person", runFindDuplicates)
+	register("merge-users", "merge a duplicate user into the one kept, or with -dry-run report what it would do", runMergeUsers)
+}
+
+// openDedupe opens the store offline. Like openPrivacy, it covers only the
+// repository: avatars and the audit trail are the server's, so a merge
+// that should move them goes through its HTTP API.
+func openDedupe(store storeFlags) (*dedupe.Manager, func() error, error) {
+	backend, closeRepo, err := store.openBackend()
+	if err != nil {
+		return nil, nil, err
+	}
+	repo, err := wrapFields(backend)
+	if err != nil {
+		closeRepo()
+		return nil, nil, err
+	}
+	return dedupe.New(dedupe.Options{Repo: repo}), closeRepo, nil
+}
+
+func runFindDuplicates(args []string) error {
+	fs := flag.NewFlagSet("find-duplicates", flag.ContinueOnError)
+	store := addStoreFlags(fs)
+	similarity := fs.Float64("similarity", 0.85, "least name similarity, 0 to 1, for a pair")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	m, closeRepo, err := openDedupe(store)
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+	found, err := m.Find(context.Background(), dedupe.FindOptions{NameSimilarity: *similarity})
+	if err != nil {
+		return err
+	}
+	for _, c := range found {
+		fmt.Printf("%6d %6d  %-5s %.2f  %q <%s>  %q <%s>\n", c.Keep.ID, c.Duplicate.ID, c.Reason, c.Score,
+			c.Keep.Name, c.Keep.Email, c.Duplicate.Name, c.Duplicate.Email)
+	}
+	fmt.Printf("likely duplicates: %d\n", len(found))
+	return nil
+}
+
+func runMergeUsers(args []string) error {
+	fs := flag.NewFlagSet("merge-users", flag.ContinueOnError)
+	store := addStoreFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "report what the merge would do without doing it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("want the kept user's ID, then the duplicate's")
+	}
+	keepID, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	mergeID, err := strconv.Atoi(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	m, closeRepo, err := openDedupe(store)
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+	merge := m.Merge
+	if *dryRun {
+		merge = m.DryRun
+	}
+	rep, err := merge(context.Background(), keepID, mergeID)
+	if errors.Is(err, users.ErrUserNotFound) {
+		return fmt.Errorf("user %d or %d not found", keepID, mergeID)
+	}
+	if err != nil {
+		return err
+	}
+	for _, s := range rep.Steps {
+		status := "ok"
+		if s.Error != "" {
+			status = "FAILED: " + s.Error
+		}
+		fmt.Printf("%-14s %4d records  %s\n", s.Store, s.Records, status)
+	}
+	for _, field := range slices.Sorted(maps.Keys(rep.Changes)) {
+		fmt.Printf("%-14s %s\n", field, rep.Changes[field])
+	}
+	if !rep.Complete() {
+		return errors.New("merge stopped; rerun once the failing store is fixed")
+	}
+	verb := "merged into"
+	if rep.DryRun {
+		verb = "would be merged into"
+	}
+	fmt.Printf("user %d %s user %d\n", mergeID, verb, keepID)
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"Go-Internals/atrest"
+	"Go-Internals/integrity"
+	"Go-Internals/kv"
+	"Go-Internals/users/kvstore"
+	"Go-Internals/users/mmapstore"
+)
+
+func init() {
+	register("fsck", "verify checksums of a file-backed store (-repair quarantines damage)", runFsck)
+}
+
+// runFsck checks a store offline. Without -repair nothing is written; with
+// it the store is opened in verifying mode, which moves damaged records to
+// the quarantine directory next to the data, exactly as a server start
+// would.
+func runFsck(args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	kind := fs.String("store", "kv", "storage backend: mmap or kv")
+	path := fs.String("data", "users.db", "data file (mmap) or directory (kv)")
+	repair := fs.Bool("repair", false, "quarantine damaged records instead of only reporting them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var rep integrity.Report
+	var err error
+	switch {
+	case *repair:
+		rep.Problems, err = repairStore(*kind, *path)
+	case *kind == "kv":
+		rep, err = kv.Verify(*path)
+	case *kind == "mmap":
+		rep, err = mmapstore.Verify(*path)
+	default:
+		return fmt.Errorf("fsck: store %q has no files to check (want mmap or kv)", *kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, p := range rep.Problems {
+		fmt.Println(p)
+	}
+	if !*repair {
+		fmt.Printf("%d files, %d records checked\n", rep.Files, rep.Records)
+	}
+	switch {
+	case rep.OK():
+		fmt.Println("clean")
+	case *repair:
+		fmt.Printf("%d problems quarantined\n", len(rep.Problems))
+	default:
+		return fmt.Errorf("%d problems found; rerun with -repair to quarantine them", len(rep.Problems))
+	}
+	return nil
+}
+
+func repairStore(kind, path string) ([]integrity.Problem, error) {
+	keys, err := atrest.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "kv":
+		s, err := kvstore.Open(path, kv.Options{Verify: true, Keys: keys})
+		if err != nil {
+			return nil, err
+		}
+		defer s.Close()
+		return s.Problems(), nil
+	case "mmap":
+		s, err := mmapstore.OpenWith(path, mmapstore.Options{Keys: keys})
+		if err != nil {
+			return nil, err
+		}
+		defer s.Close()
+		return s.Problems(), nil
+	default:
+		return nil, fmt.Errorf("fsck: store %q has no files to check (want mmap or kv)", kind)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"Go-Internals/goroutines"
+)
+
+func init() {
+	register("goroutines", "group a running server's goroutines by creation site and show what grew", runGoroutines)
+}
+
+// runGoroutines fetches /debug/goroutines, sampling twice -interval
+// apart, and prints one line per creation site, the grown ones first.
+// With -stacks the stack of each grown site's oldest goroutine follows.
+func runGoroutines(args []string) error {
+	fs := flag.NewFlagSet("goroutines", flag.ContinueOnError)
+	base := fs.String("url", "http://localhost:8080", "server address")
+	token := fs.String("token", "", "admin bearer token")
+	interval := fs.Duration("interval", 10*time.Second, "time between the two samples (0 = one sample)")
+	minCount := fs.Int("min", 1, "leave out sites with fewer goroutines, unless they changed")
+	stacks := fs.Bool("stacks", false, "print the stack of each site that grew")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	q := url.Values{"stacks": {fmt.Sprint(*stacks)}}
+	if *interval > 0 {
+		q.Set("interval", interval.String())
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(*base, "/")+"/debug/goroutines?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	client, err := httpClient(*interval + 30*time.Second)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("goroutines: %s", resp.Status)
+	}
+	var rep goroutines.Report
+	if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+		return fmt.Errorf("goroutines: %w", err)
+	}
+
+	delta := map[string]int{}
+	for _, c := range rep.Growth {
+		delta[goroutines.Group{CreatedBy: c.CreatedBy, Site: c.Site}.Key()] = c.Delta
+	}
+	fmt.Printf("%d goroutines at %s", rep.Total, rep.At.Format(time.TimeOnly))
+	if !rep.Since.IsZero() {
+		fmt.Printf(", compared with %s", rep.Since.Format(time.TimeOnly))
+	}
+	fmt.Println()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "COUNT\tCHANGE\tOLDEST\tSTATES\tCREATED BY")
+	var grown []goroutines.Group
+	// Grown sites first, keeping the server's largest-first order among
+	// equals.
+	groups := rep.Groups
+	for pass := range 2 {
+		for _, g := range groups {
+			d := delta[g.Key()]
+			if (d > 0) != (pass == 0) {
+				continue
+			}
+			if g.Count < *minCount && d == 0 {
+				continue
+			}
+			change := ""
+			if d != 0 {
+				change = fmt.Sprintf("%+d", d)
+			}
+			oldest := ""
+			if g.Oldest > 0 {
+				oldest = g.Oldest.String()
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", g.Count, change, oldest, states(g.States), g.Key())
+			if d > 0 {
+				grown = append(grown, g)
+			}
+		}
+	}
+	for _, c := range rep.Growth {
+		if c.After == 0 {
+			fmt.Fprintf(tw, "0\t%+d\t\t\t%s\n", c.Delta, goroutines.Group{CreatedBy: c.CreatedBy, Site: c.Site}.Key())
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if *stacks {
+		for _, g := range grown {
+			fmt.Printf("\n---- %s ----\n%s\n", g.Key(), g.Stack)
+		}
+	}
+	return nil
+}
+
+// states renders {"chan receive": 310, "select": 2} as
+// "chan receive 310, select 2", largest first.
+func states(m map[string]int) string {
+	type state struct {
+		name string
+		n    int
+	}
+	var list []state
+	for name, n := range m {
+		list = append(list, state{name, n})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].n != list[j].n {
+			return list[i].n > list[j].n
+		}
+		return list[i].name < list[j].name
+	})
+	parts := make([]string, len(list))
+	for i, s := range list {
+		parts[i] = fmt.Sprintf("%s %d", s.name, s.n)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"Go-Internals/atrest"
+	"Go-Internals/blobstore"
+	"Go-Internals/fieldcrypt"
+	"Go-Internals/keyset"
+	"Go-Internals/users"
+)
+
+func init() {
+	register("keys", "list the versions of the signing and field keys in a key store", runKeys)
+	register("rotate-key", "make a new version of a key current (jwt or fields)", runRotateKey)
+	register("drop-key", "drop a retired version of a key", runDropKey)
+	register("reencrypt", "re-encrypt users' fields under the current field key version", runReencrypt)
+}
+
+// keyStoreEnv names the key store when -key-store is not given; it is
+// the server's -key-store. wrapFields reads field keys from it too.
+const keyStoreEnv = "USERS_KEY_STORE"
+
+func addKeyStoreFlag(fs *flag.FlagSet) *string {
+	return fs.String("key-store", os.Getenv(keyStoreEnv), "blob store of the key versions, as the server's -key-store (default $"+keyStoreEnv+")")
+}
+
+// openKeySet opens set name from store as the server does, starting it
+// from the key in the environment if the store has none yet. A running
+// server takes up changes to it within a minute.
+func openKeySet(ctx context.Context, store, name string) (*keyset.Set, error) {
+	if store == "" {
+		return nil, errors.New("no key store: set -key-store or $" + keyStoreEnv)
+	}
+	var initial []byte
+	switch name {
+	case "jwt":
+		initial = []byte(os.Getenv("USERS_JWT_SECRET"))
+	case "fields":
+		key, err := fieldcrypt.EnvKey()
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return nil, errors.New("fields: $" + fieldcrypt.EnvVar + " is unset, so no fields are encrypted")
+		}
+		initial = key
+	default:
+		return nil, fmt.Errorf("unknown key %q (want jwt or fields)", name)
+	}
+	blobs, err := blobstore.Open(store)
+	if err != nil {
+		return nil, err
+	}
+	seal, err := atrest.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return keyset.Open(ctx, keyset.Options{Name: name, Store: blobs, Seal: seal, Initial: initial})
+}
+
+func runKeys(args []string) error {
+	fs := flag.NewFlagSet("keys", flag.ContinueOnError)
+	store := addKeyStoreFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	names := fs.Args()
+	if len(names) == 0 {
+		names = []string{"jwt", "fields"}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVERSION\tCREATED\tRETIRED\t")
+	for _, name := range names {
+		set, err := openKeySet(context.Background(), *store, name)
+		if err != nil {
+			return err
+		}
+		for _, v := range set.Versions() {
+			retired := "current"
+			if !v.Current {
+				retired = v.RetiredAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", name, v.ID, v.CreatedAt.Format(time.RFC3339), retired)
+		}
+	}
+	return w.Flush()
+}
+
+func runRotateKey(args []string) error {
+	fs := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	store := addKeyStoreFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("want the key to rotate: jwt or fields")
+	}
+
+	ctx := context.Background()
+	set, err := openKeySet(ctx, *store, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	v, err := set.Rotate(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s is current\n", set.Name(), v.ID)
+	return nil
+}
+
+func runDropKey(args []string) error {
+	fs := flag.NewFlagSet("drop-key", flag.ContinueOnError)
+	store := addKeyStoreFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("want the key and the version to drop, e.g. fields k0")
+	}
+
+	ctx := context.Background()
+	set, err := openKeySet(ctx, *store, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := set.Drop(ctx, fs.Arg(1)); err != nil {
+		return err
+	}
+	fmt.Printf("%s: dropped %s\n", set.Name(), fs.Arg(1))
+	return nil
+}
+
+// runReencrypt reseals users' fields offline (the server must not have
+// the store open), so the field key versions before the current one
+// can be dropped.
+func runReencrypt(args []string) error {
+	fs := flag.NewFlagSet("reencrypt", flag.ContinueOnError)
+	store := addStoreFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, closeRepo, err := store.open()
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+	encrypted, ok := repo.(*users.EncryptedRepo)
+	if !ok {
+		return errors.New("reencrypt: $" + fieldcrypt.EnvVar + " is unset, so no fields are encrypted")
+	}
+	n, err := encrypted.Reencrypt(context.Background())
+	fmt.Printf("re-encrypted %d users\n", n)
+	return err
+}
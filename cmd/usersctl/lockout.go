@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"Go-Internals/lockout"
+)
+
+func init() {
+	register("lockouts", "list a running server's locked and failing accounts and addresses, or show one", runLockouts)
+	register("unlock", "lift a running server's lock on an account or, with -ip, an address", runUnlock)
+}
+
+// lockoutFlags are the server flags both commands take.
+type lockoutFlags struct {
+	base, token *string
+}
+
+func addLockoutFlags(fs *flag.FlagSet) lockoutFlags {
+	return lockoutFlags{
+		base:  fs.String("url", "http://localhost:8080", "server address"),
+		token: fs.String("token", "", "admin bearer token"),
+	}
+}
+
+// do sends method to the server's path and decodes a JSON answer into
+// out, if out is not nil.
+func (f lockoutFlags) do(method, path string, out any) error {
+	return f.send(method, path, nil, out)
+}
+
+// send is do with in, if not nil, sent as the JSON body.
+func (f lockoutFlags) send(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(*f.base, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if *f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+*f.token)
+	}
+	client, err := httpClient(30 * time.Second)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// lockoutPath is the API path of an account's or address's state.
+func lockoutPath(ip bool, key string) string {
+	kind := lockout.Account
+	if ip {
+		kind = lockout.IP
+	}
+	return "/lockouts/" + string(kind) + "/" + url.PathEscape(key)
+}
+
+func runLockouts(args []string) error {
+	fs := flag.NewFlagSet("lockouts", flag.ContinueOnError)
+	srv := addLockoutFlags(fs)
+	ip := fs.Bool("ip", false, "the argument is a client address, not an account")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var states []lockout.State
+	switch fs.NArg() {
+	case 0:
+		if err := srv.do(http.MethodGet, "/lockouts", &states); err != nil {
+			return err
+		}
+	case 1:
+		var s lockout.State
+		if err := srv.do(http.MethodGet, lockoutPath(*ip, fs.Arg(0)), &s); err != nil {
+			return err
+		}
+		states = append(states, s)
+	default:
+		return errors.New("want at most one account (or, with -ip, address)")
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tKEY\tFAILURES\tLOCKS\tLOCKED UNTIL")
+	for _, s := range states {
+		until := "-"
+		if !s.LockedUntil.IsZero() {
+			until = s.LockedUntil.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%d\t%s\n", s.Kind, s.Key, s.Failures, s.Locks, until)
+	}
+	return tw.Flush()
+}
+
+func runUnlock(args []string) error {
+	fs := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	srv := addLockoutFlags(fs)
+	ip := fs.Bool("ip", false, "the argument is a client address, not an account")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("want the account (a user's ID for their tokens) or, with -ip, the address")
+	}
+	if err := srv.do(http.MethodDelete, lockoutPath(*ip, fs.Arg(0)), nil); err != nil {
+		return err
+	}
+	fmt.Println("unlocked", fs.Arg(0))
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"Go-Internals/maintenance"
+)
+
+func init() {
+	register("maintenance", "show a running server's maintenance state, or switch it on or off", runMaintenance)
+}
+
+func runMaintenance(args []string) error {
+	fs := flag.NewFlagSet("maintenance", flag.ContinueOnError)
+	srv := addLockoutFlags(fs)
+	reason := fs.String("reason", "", "on: why, told to refused writes")
+	retryAfter := fs.Duration("retry-after", 0, "on: what refused writes are told to wait (default the server's, a minute)")
+	wait := fs.Duration("wait", 30*time.Second, "on: how long to wait for the writes and jobs under way to finish")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var st maintenance.State
+	switch fs.Arg(0) {
+	case "", "status":
+		if err := srv.do(http.MethodGet, "/maintenance", &st); err != nil {
+			return err
+		}
+	case "on":
+		req := map[string]any{"on": true, "reason": *reason}
+		if *retryAfter > 0 {
+			req["retry_after"] = max(int(*retryAfter/time.Second), 1)
+		}
+		if err := srv.send(http.MethodPut, "/maintenance", req, &st); err != nil {
+			return err
+		}
+		// The server answers at once; what it admitted before may still
+		// be writing.
+		for deadline := time.Now().Add(*wait); st.InFlight > 0 && time.Now().Before(deadline); {
+			time.Sleep(200 * time.Millisecond)
+			if err := srv.do(http.MethodGet, "/maintenance", &st); err != nil {
+				return err
+			}
+		}
+	case "off":
+		if err := srv.send(http.MethodPut, "/maintenance", map[string]any{"on": false}, &st); err != nil {
+			return err
+		}
+	default:
+		return errors.New("want status, on or off")
+	}
+
+	if !st.On {
+		fmt.Println("maintenance: off")
+		return nil
+	}
+	fmt.Printf("maintenance: on since %s", st.Since.Local().Format(time.DateTime))
+	if st.By != "" {
+		fmt.Printf(" by %s", st.By)
+	}
+	if st.Reason != "" {
+		fmt.Printf(": %s", st.Reason)
+	}
+	fmt.Println()
+	if len(st.Paused) > 0 {
+		fmt.Println("paused:", strings.Join(st.Paused, ", "))
+	}
+	if st.InFlight > 0 {
+		fmt.Printf("still draining: %d writes and jobs under way\n", st.InFlight)
+	} else {
+		fmt.Println("drained: nothing is writing")
+	}
+	return nil
+}
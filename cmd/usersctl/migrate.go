@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"Go-Internals/datamove"
+)
+
+func init() {
+	register("migrate", "copy every user to another backend and verify the copy", runMigrate)
+}
+
+// Both stores are opened bare, so records move as stored: fields sealed
+// by $USERS_FIELD_KEY stay sealed and stay readable with the same key.
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := addStoreFlags(fs)
+	to := storeFlags{
+		kind: fs.String("to", "kv", "target backend: memory, mmap or kv"),
+		path: fs.String("to-data", "users.kv", "target data file or directory"),
+	}
+	batch := fs.Int("batch", 500, "records per batch")
+	prune := fs.Bool("prune", false, "delete target records missing from the source")
+	verifyOnly := fs.Bool("verify-only", false, "only compare the two stores")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from.kind == *to.kind && *from.path == *to.path {
+		return errors.New("migrate: source and target are the same store")
+	}
+
+	src, closeSrc, err := from.openBackend()
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+	dstRepo, closeDst, err := to.openBackend()
+	if err != nil {
+		return err
+	}
+	defer closeDst()
+	dst, ok := dstRepo.(datamove.Target)
+	if !ok {
+		return errors.New("migrate: store " + *to.kind + " cannot take records with their IDs")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if !*verifyOnly {
+		p, err := datamove.Migrate(ctx, src, dst, datamove.Options{
+			BatchSize: *batch,
+			Prune:     *prune,
+			Progress: func(p datamove.Progress) {
+				fmt.Fprintf(os.Stderr, "\rread %d: %d copied, %d replaced, %d unchanged (%.0f/s)", p.Read, p.Copied, p.Replaced, p.Unchanged, p.Rate())
+			},
+		})
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+		if p.Pruned > 0 {
+			fmt.Printf("pruned %d users\n", p.Pruned)
+		}
+	}
+
+	c, err := datamove.Verify(ctx, src, dst)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	if !c.OK() {
+		return errors.New("migrate: stores differ")
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"Go-Internals/blobstore"
+	"Go-Internals/privacy"
+	"Go-Internals/users"
+)
+
+func init() {
+	register("export-user", "write everything stored about a user as a JSON archive", runExportUser)
+	register("erase-user", "erase or anonymize a user and print the completion report", runEraseUser)
+}
+
+// openPrivacy opens the store offline. Only the repository and the
+// storage itself are covered: audit entries and change events live in
+// the server's memory, so those requests go through its HTTP API.
+func openPrivacy(store storeFlags) (*privacy.Manager, users.UserRepository, func() error, error) {
+	backend, closeRepo, err := store.openBackend()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	repo, err := wrapFields(backend)
+	if err != nil {
+		closeRepo()
+		return nil, nil, nil, err
+	}
+	opts := privacy.Options{Repo: repo}
+	if p, ok := backend.(privacy.Purger); ok {
+		opts.Purge = p
+	}
+	return privacy.New(opts), repo, closeRepo, nil
+}
+
+func userArg(fs *flag.FlagSet) (int, error) {
+	if fs.NArg() != 1 {
+		return 0, errors.New("want exactly one user ID")
+	}
+	return strconv.Atoi(fs.Arg(0))
+}
+
+func runExportUser(args []string) error {
+	fs := flag.NewFlagSet("export-user", flag.ContinueOnError)
+	store := addStoreFlags(fs)
+	out := fs.String("o", "-", "archive file (- for stdout)")
+	to := fs.String("to", "", "upload the archive to this blob store instead and print a download link")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	id, err := userArg(fs)
+	if err != nil {
+		return err
+	}
+
+	m, _, closeRepo, err := openPrivacy(store)
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+	archive, err := m.Export(context.Background(), id)
+	if err != nil {
+		return err
+	}
+
+	if *to != "" {
+		return uploadExport(*to, id, archive)
+	}
+	w := os.Stdout
+	if *out != "-" {
+		if w, err = os.Create(*out); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(archive)
+}
+
+// uploadExport streams the archive into the store, so a large one is
+// never held encoded in memory, and prints a link valid for a day.
+func uploadExport(spec string, id int, archive privacy.Archive) error {
+	blobs, err := blobstore.Open(spec)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	key := fmt.Sprintf("exports/user-%d-%s.json", id, time.Now().UTC().Format("20060102T150405Z"))
+	pr, pw := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(pw)
+		enc.SetIndent("", "  ")
+		pw.CloseWithError(enc.Encode(archive))
+	}()
+	info, err := blobs.Put(ctx, key, pr, "application/json")
+	pr.Close()
+	if err != nil {
+		return err
+	}
+	link, err := blobs.Presign(ctx, "GET", key, 24*time.Hour)
+	if errors.Is(err, blobstore.ErrNoPresign) {
+		fmt.Printf("%s (%d bytes)\n", key, info.Size)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s (%d bytes)\n%s\n", key, info.Size, link)
+	return nil
+}
+
+func runEraseUser(args []string) error {
+	fs := flag.NewFlagSet("erase-user", flag.ContinueOnError)
+	store := addStoreFlags(fs)
+	anonymize := fs.Bool("anonymize", false, "keep the record with its personal fields overwritten instead of deleting it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	id, err := userArg(fs)
+	if err != nil {
+		return err
+	}
+	mode := privacy.Delete
+	if *anonymize {
+		mode = privacy.Anonymize
+	}
+
+	m, _, closeRepo, err := openPrivacy(store)
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+	rep, err := m.Erase(context.Background(), id, mode)
+	if errors.Is(err, users.ErrUserNotFound) {
+		return fmt.Errorf("user %d not found", id)
+	}
+	if err != nil {
+		return err
+	}
+	for _, s := range rep.Steps {
+		status := "ok"
+		if s.Error != "" {
+			status = "FAILED: " + s.Error
+		}
+		fmt.Printf("%-14s %4d records  %s\n", s.Store, s.Records, status)
+	}
+	if !rep.Complete() {
+		return errors.New("erasure incomplete; rerun once the failing store is fixed")
+	}
+	fmt.Printf("user %d: %s complete in %s\n", id, rep.Mode, rep.Finished.Sub(rep.Started))
+	return nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/dryrun"
+	"Go-Internals/i18n"
+	"Go-Internals/query"
+	"Go-Internals/users"
+)
+
+func init() {
+	register("repl", "interactive shell against an in-process service", runRepl)
+}
+
+// historyLimit caps the persisted history file.
+const historyLimit = 500
+
+// repl is one interactive session.
+type repl struct {
+	repo    users.UserRepository
+	service *users.UserService
+	ring    *audit.Ring
+	level   *slog.LevelVar
+	log     *slog.Logger
+	out     io.Writer
+	locale  string
+	// dryRun runs creates and deletes dry: checked, not stored.
+	dryRun bool
+
+	history     []string
+	historyPath string
+	started     time.Time
+}
+
+// runRepl implements
+//
+//	usersctl repl [-store memory|mmap] [-data users.db] [-history file] [-dry-run]
+//
+// With -dry-run, or after `dryrun on`, creates and deletes are checked
+// and what they would do is printed, but nothing is stored.
+// History is kept across sessions; `history` lists it, `!!` repeats the
+// last line and `!N` repeats entry N. There is no line editing: arrow keys
+// need a raw-mode terminal, which the standard library doesn't provide.
+func runRepl(args []string) error {
+	fs := flag.NewFlagSet("repl", flag.ContinueOnError)
+	store := addStoreFlags(fs)
+	histPath := fs.String("history", defaultHistoryPath(), "history file (empty disables persistence)")
+	dryRun := fs.Bool("dry-run", false, "check creates and deletes and print what they would do, without storing anything")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repo, closeRepo, err := store.open()
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+
+	r := &repl{
+		repo:        repo,
+		ring:        audit.NewRing(100, nil),
+		level:       new(slog.LevelVar),
+		out:         os.Stdout,
+		locale:      i18n.DefaultLocale,
+		dryRun:      *dryRun,
+		historyPath: *histPath,
+		started:     time.Now(),
+	}
+	r.log = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: r.level}))
+	opts := []users.ServiceOption{users.WithAudit(r.ring)}
+	if ids, ok := repo.(users.IDPeeker); ok {
+		opts = append(opts, users.WithIDPeeker(ids))
+	}
+	r.service = users.NewUserService(repo, opts...)
+	r.loadHistory()
+
+	fmt.Fprintln(r.out, "usersctl repl — type `help` for commands, `quit` to exit")
+	return r.loop(os.Stdin)
+}
+
+func (r *repl) loop(in io.Reader) error {
+	sc := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(r.out, "users> ")
+		if !sc.Scan() {
+			fmt.Fprintln(r.out)
+			return errors.Join(sc.Err(), r.saveHistory())
+		}
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		line, ok := r.expand(line)
+		if !ok {
+			continue
+		}
+		r.history = append(r.history, line)
+
+		if line == "quit" || line == "exit" {
+			return r.saveHistory()
+		}
+		start := time.Now()
+		if err := r.exec(strings.Fields(line)); err != nil {
+			fmt.Fprintln(r.out, "error:", i18n.Message(i18n.WithLocale(context.Background(), r.locale), err))
+		}
+		r.log.Debug("command", "line", line, "took", time.Since(start))
+	}
+}
+
+// expand resolves !! and !N against history.
+func (r *repl) expand(line string) (string, bool) {
+	if !strings.HasPrefix(line, "!") {
+		return line, true
+	}
+	if line == "!!" {
+		if len(r.history) == 0 {
+			fmt.Fprintln(r.out, "error: history is empty")
+			return "", false
+		}
+		line = r.history[len(r.history)-1]
+	} else {
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 1 || n > len(r.history) {
+			fmt.Fprintln(r.out, "error: no such history entry")
+			return "", false
+		}
+		line = r.history[n-1]
+	}
+	fmt.Fprintln(r.out, line)
+	return line, true
+}
+
+func (r *repl) exec(f []string) error {
+	ctx := i18n.WithLocale(context.Background(), r.locale)
+	var plan *dryrun.Plan
+	if r.dryRun {
+		ctx, plan = dryrun.With(ctx)
+		defer func() { r.printPlan(plan.Report()) }()
+	}
+
+	switch f[0] {
+	case "help":
+		fmt.Fprint(r.out, replHelp)
+
+	case "create":
+		if len(f) < 3 {
+			return errors.New("usage: create <email> <name...>")
+		}
+		u, err := r.service.RegisterUser(ctx, strings.Join(f[2:], " "), f[1])
+		if err != nil {
+			return err
+		}
+		r.printUsers(u)
+
+	case "get":
+		id, err := argID(f)
+		if err != nil {
+			return err
+		}
+		u, err := r.service.GetUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		r.printUsers(u)
+
+	case "list":
+		list, err := r.service.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+		r.printUsers(list...)
+
+	case "delete":
+		id, err := argID(f)
+		if err != nil {
+			return err
+		}
+		if err := r.service.DeleteUser(ctx, id); err != nil {
+			return err
+		}
+		if !r.dryRun {
+			fmt.Fprintln(r.out, "deleted", id)
+		}
+
+	case "dryrun":
+		switch {
+		case len(f) == 1:
+		case len(f) == 2 && (f[1] == "on" || f[1] == "off"):
+			r.dryRun = f[1] == "on"
+		default:
+			return errors.New("usage: dryrun [on|off]")
+		}
+		state := "off"
+		if r.dryRun {
+			state = "on"
+		}
+		fmt.Fprintln(r.out, "dry run:", state)
+
+	case "loglevel":
+		if len(f) != 2 {
+			fmt.Fprintln(r.out, "log level:", r.level.Level())
+			return nil
+		}
+		var lvl slog.Level
+		if err := lvl.UnmarshalText([]byte(f[1])); err != nil {
+			return err
+		}
+		r.level.Set(lvl)
+		fmt.Fprintln(r.out, "log level:", lvl)
+
+	case "locale":
+		if len(f) == 2 {
+			r.locale = f[1]
+		}
+		fmt.Fprintln(r.out, "locale:", r.locale)
+
+	case "stats":
+		r.printStats()
+
+	case "audit":
+		for _, e := range r.ring.Recent(20) {
+			fmt.Fprintf(r.out, "%s  %-8s %s/%s\n", e.At.Format(time.TimeOnly), e.Action, e.Resource, e.ResourceID)
+		}
+
+	case "history":
+		for i, h := range r.history {
+			fmt.Fprintf(r.out, "%4d  %s\n", i+1, h)
+		}
+
+	default:
+		return fmt.Errorf("unknown command %q (try `help`)", f[0])
+	}
+	return nil
+}
+
+const replHelp = `commands:
+  create <email> <name...>   register a user
+  get <id>                   fetch one user
+  list                       list all users
+  delete <id>                delete a user
+  dryrun [on|off]            check creates and deletes without storing them
+  loglevel [debug|info|warn|error]
+  locale [tag]               language for error messages
+  stats                      repository and runtime stats
+  audit                      recent audit entries
+  history                    numbered history (!! and !N repeat)
+  quit
+`
+
+func argID(f []string) (int, error) {
+	if len(f) != 2 {
+		return 0, fmt.Errorf("usage: %s <id>", f[0])
+	}
+	return strconv.Atoi(f[1])
+}
+
+func (r *repl) printUsers(list ...users.User) {
+	tw := tabwriter.NewWriter(r.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tEMAIL\tCREATED")
+	for _, u := range list {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", u.ID, u.Name, u.Email, u.CreatedAt.Format(time.DateTime))
+	}
+	tw.Flush()
+}
+
+// printPlan shows what a dry run would have done, if anything.
+func (r *repl) printPlan(rep dryrun.Report) {
+	if len(rep.Writes) == 0 && len(rep.Events) == 0 && len(rep.Audit) == 0 {
+		return
+	}
+	fmt.Fprintln(r.out, "dry run, nothing stored; would have:")
+	tw := tabwriter.NewWriter(r.out, 0, 4, 2, ' ', 0)
+	for _, w := range rep.Writes {
+		id := w.ID
+		if id == "" {
+			id = "(new)"
+		}
+		fmt.Fprintf(tw, "  %s\t%s %s\n", w.Op, w.Resource, id)
+	}
+	for _, e := range rep.Events {
+		fmt.Fprintf(tw, "  publish\t%s\n", e.Topic)
+	}
+	for _, e := range rep.Audit {
+		fmt.Fprintf(tw, "  audit\t%s %s/%s\n", e.Action, e.Resource, e.ResourceID)
+	}
+	tw.Flush()
+}
+
+func (r *repl) printStats() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	tw := tabwriter.NewWriter(r.out, 0, 4, 2, ' ', 0)
+	if n, err := users.Count(context.Background(), r.repo, query.All()); err == nil {
+		fmt.Fprintf(tw, "users\t%d\n", n)
+	}
+	if feed, ok := r.repo.(users.ChangeFeed); ok {
+		fmt.Fprintf(tw, "last change seq\t%d\n", feed.LastSeq())
+	}
+	fmt.Fprintf(tw, "uptime\t%s\n", time.Since(r.started).Round(time.Second))
+	fmt.Fprintf(tw, "goroutines\t%d\n", runtime.NumGoroutine())
+	fmt.Fprintf(tw, "heap alloc\t%d KiB\n", ms.HeapAlloc/1024)
+	fmt.Fprintf(tw, "heap objects\t%d\n", ms.HeapObjects)
+	fmt.Fprintf(tw, "gc cycles\t%d\n", ms.NumGC)
+	tw.Flush()
+}
+
+func defaultHistoryPath() string {
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, ".usersctl_history")
+}
+
+func (r *repl) loadHistory() {
+	if r.historyPath == "" {
+		return
+	}
+	data, err := os.ReadFile(r.historyPath)
+	if err != nil {
+		return // first run
+	}
+	for line := range strings.SplitSeq(strings.TrimSpace(string(data)), "\n") {
+		if line != "" {
+			r.history = append(r.history, line)
+		}
+	}
+}
+
+func (r *repl) saveHistory() error {
+	if r.historyPath == "" {
+		return nil
+	}
+	h := r.history
+	if len(h) > historyLimit {
+		h = h[len(h)-historyLimit:]
+	}
+	return os.WriteFile(r.historyPath, []byte(strings.Join(h, "\n")+"\n"), 0o600)
+}
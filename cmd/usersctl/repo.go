@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"Go-Internals/atrest"
+	"Go-Internals/fieldcrypt"
+	"Go-Internals/kv"
+	"Go-Internals/users"
+	"Go-Internals/users/kvstore"
+	"Go-Internals/users/mmapstore"
+)
+
+// storeFlags adds -store/-data to a command that runs an in-process
+// service.
+type storeFlags struct {
+	kind, path *string
+}
+
+func addStoreFlags(fs *flag.FlagSet) storeFlags {
+	return storeFlags{
+		kind: fs.String("store", "memory", "storage backend: memory, mmap or kv"),
+		path: fs.String("data", "users.db", "data file for -store mmap, directory for -store kv"),
+	}
+}
+
+// open returns the repository and a func releasing it. With
+// $USERS_FIELD_KEY set, PII fields are encrypted before they reach it,
+// with the versions of that key in $USERS_KEY_STORE if it is set.
+func (f storeFlags) open() (users.UserRepository, func() error, error) {
+	repo, closeRepo, err := f.openBackend()
+	if err != nil {
+		return nil, nil, err
+	}
+	if repo, err = wrapFields(repo); err != nil {
+		closeRepo()
+		return nil, nil, err
+	}
+	return repo, closeRepo, nil
+}
+
+func wrapFields(repo users.UserRepository) (users.UserRepository, error) {
+	fields, err := fieldcrypt.FromEnv()
+	if err != nil || fields == nil {
+		return repo, err
+	}
+	if store := os.Getenv(keyStoreEnv); store != "" {
+		set, err := openKeySet(context.Background(), store, "fields")
+		if err != nil {
+			return nil, err
+		}
+		if fields, err = fieldcrypt.NewRotating(set); err != nil {
+			return nil, err
+		}
+	}
+	return users.EncryptFields(repo, fields), nil
+}
+
+// openBackend returns the backend itself, for commands that need its
+// optional interfaces (vacuum.Target, ...). File-backed stores are
+// encrypted with the keyring in $USERS_DATA_KEYS, if set.
+func (f storeFlags) openBackend() (users.UserRepository, func() error, error) {
+	keys, err := atrest.FromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	switch *f.kind {
+	case "memory":
+		return users.NewInMemoryUserRepo(), func() error { return nil }, nil
+	case "mmap":
+		s, err := mmapstore.OpenWith(*f.path, mmapstore.Options{Keys: keys})
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	case "kv":
+		s, err := kvstore.Open(*f.path, kv.Options{Keys: keys})
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown store %q (want memory, mmap or kv)", *f.kind)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"Go-Internals/retention"
+)
+
+func init() {
+	register("retention", "anonymize inactive users (dry run unless -apply)", runRetention)
+}
+
+// runRetention evaluates the user retention rule offline. Audit entries
+// only exist in a running server, whose own job handles them.
+func runRetention(args []string) error {
+	fs := flag.NewFlagSet("retention", flag.ContinueOnError)
+	store := addStoreFlags(fs)
+	inactive := fs.Duration("inactive", 0, "anonymize users inactive for longer than this, e.g. 4380h (~6 months)")
+	apply := fs.Bool("apply", false, "anonymize for real instead of reporting what would be")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *inactive <= 0 {
+		return errors.New("retention: -inactive is required")
+	}
+
+	m, repo, closeRepo, err := openPrivacy(store)
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+	job := retention.NewJob(retention.JobOptions{
+		Rules:  []retention.Rule{retention.AnonymizeInactive(repo, m, *inactive, nil)},
+		DryRun: !*apply,
+	})
+	rep, err := job.RunOnce(context.Background())
+	for _, o := range rep.Outcomes {
+		if o.DryRun {
+			fmt.Printf("%s: would anonymize %d\n", o.Rule, o.Matched)
+		} else {
+			fmt.Printf("%s: %d matched, %d anonymized\n", o.Rule, o.Matched, o.Applied)
+		}
+		for _, s := range o.Samples {
+			fmt.Println("  ", s)
+		}
+	}
+	if rep.DryRun {
+		fmt.Println("dry run as of", rep.At.Format(time.RFC3339), "- rerun with -apply to act")
+	}
+	return err
+}
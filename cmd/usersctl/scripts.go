@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"Go-Internals/eventbus"
+	"Go-Internals/script"
+	"Go-Internals/users"
+)
+
+func init() {
+	register("scripts", "compile hook scripts and dry-run them against a sample user", runScripts)
+}
+
+// runScripts implements
+//
+//	usersctl scripts [-user user.json] [-topic user.deleted] <dir or file>...
+//
+// Every hook is compiled, then run once: validate hooks on the user,
+// event hooks on an event carrying it (topic -topic for "*" hooks).
+// Scripts' log() lines go to stderr.
+func runScripts(args []string) error {
+	fs := flag.NewFlagSet("scripts", flag.ContinueOnError)
+	userPath := fs.String("user", "", "JSON file with the user to run on (default: a sample user)")
+	topic := fs.String("topic", users.TopicUserCreated, `topic of the event for "*" hooks`)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("scripts: want hook files or directories")
+	}
+	u := users.User{ID: 42, Name: "Gaurav", Email: "gaurav@example.com", CreatedAt: time.Now()}
+	if *userPath != "" {
+		raw, err := os.ReadFile(*userPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(raw, &u); err != nil {
+			return fmt.Errorf("scripts: %s: %w", *userPath, err)
+		}
+	}
+
+	var hooks []*script.Hook
+	for _, path := range fs.Args() {
+		hs, err := loadHooks(path)
+		if err != nil {
+			return err
+		}
+		hooks = append(hooks, hs...)
+	}
+	opts := script.Options{Logger: slog.New(slog.NewTextHandler(os.Stderr, nil))}
+	failed := false
+	for _, hk := range hooks {
+		// One set per hook, so the outcome and the stats are its own.
+		set := script.New(opts, hk)
+		var err error
+		at := "validate"
+		if hk.Validates() {
+			err = set.Validate(context.Background(), u)
+		} else {
+			t := hk.Topic
+			if t == eventbus.AllTopics {
+				t = *topic
+			}
+			at = "on " + t
+			err = set.Handle(context.Background(), eventbus.Event{Topic: t, Payload: u, At: time.Now()})
+		}
+		outcome := "ok"
+		switch {
+		case errors.Is(err, users.ErrInvalidInput):
+			outcome = "rejects: " + err.Error()
+		case err != nil:
+			outcome = "FAILS: " + err.Error()
+			failed = true
+		}
+		fmt.Printf("%-24s %-18s %5d steps  %s\n", hk.Name, at, set.Stats()[hk.Name].Steps, outcome)
+	}
+	if failed {
+		return errors.New("scripts: some hooks failed")
+	}
+	return nil
+}
+
+// loadHooks reads a hook file, or every hook of a directory.
+func loadHooks(path string) ([]*script.Hook, error) {
+	if st, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if st.IsDir() {
+		set, err := script.LoadDir(path, script.Options{})
+		if err != nil {
+			return nil, err
+		}
+		return set.Hooks(), nil
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := script.ParseHook(path, string(src))
+	if err != nil {
+		return nil, err
+	}
+	return []*script.Hook{h}, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"Go-Internals/templates"
+	"Go-Internals/users"
+)
+
+func init() {
+	register("templates", "list or preview notification templates", runTemplates)
+}
+
+// runTemplates implements
+//
+//	usersctl templates list
+//	usersctl templates preview [-locale hi] [-part html] [-data user.json] <name>
+func runTemplates(args []string) error {
+	if len(args) == 0 {
+		return errors.New("templates: want `list` or `preview`")
+	}
+
+	switch args[0] {
+	case "list":
+		for _, n := range templates.Default.Names() {
+			fmt.Println(n)
+		}
+		return nil
+
+	case "preview":
+		fs := flag.NewFlagSet("templates preview", flag.ContinueOnError)
+		locale := fs.String("locale", "en", "render locale")
+		part := fs.String("part", "", "only this part: subject, txt, html or webhook (default: all)")
+		dataPath := fs.String("data", "", "JSON file with the template data (default: a sample user)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New("templates preview: want exactly one template name")
+		}
+		name := fs.Arg(0)
+
+		data, err := previewData(*dataPath)
+		if err != nil {
+			return err
+		}
+
+		if *part != "" {
+			return templates.Default.RenderPart(os.Stdout, *locale, name, templates.Part(*part), data)
+		}
+
+		r, err := templates.Default.Render(*locale, name, data)
+		if err != nil {
+			return err
+		}
+		for _, p := range []struct{ label, body string }{
+			{"subject", r.Subject}, {"text", r.Text}, {"html", r.HTML},
+		} {
+			if p.body == "" {
+				continue
+			}
+			fmt.Printf("===== %s %s\n%s\n\n", p.label, strings.Repeat("=", 40), p.body)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("templates: unknown subcommand %q", args[0])
+	}
+}
+
+func previewData(path string) (any, error) {
+	if path == "" {
+		return map[string]any{
+			"User": users.User{ID: 42, Name: "Gaurav", Email: "gaurav@example.com", CreatedAt: time.Now()},
+		}, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]any
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("templates preview: %s: %w", path, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"Go-Internals/buildinfo"
+)
+
+func init() {
+	register("version", "print this binary's build, and with -url the server's", runVersion)
+}
+
+// runVersion prints usersctl's own build and, given -url, the build the
+// server reports at /version, so a mismatch between the two is one
+// command away.
+func runVersion(args []string) error {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	base := fs.String("url", "", "also ask the server at this address")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Println("usersctl:", buildinfo.Get())
+	if *base == "" {
+		return nil
+	}
+	client, err := httpClient(10 * time.Second)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Get(strings.TrimSuffix(*base, "/") + "/version")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("version: %s", resp.Status)
+	}
+	var server buildinfo.Info
+	if err := json.NewDecoder(resp.Body).Decode(&server); err != nil {
+		return fmt.Errorf("version: %w", err)
+	}
+	fmt.Println("server:  ", server)
+	return nil
+}
@@ -0,0 +1,237 @@
+// Package collections has the containers the standard library does not:
+// a map that remembers insertion order, a sorted set and a multiset.
+// Each encodes to JSON in its order, so two runs over the same data
+// produce the same bytes, which a Go map ranged over does not.
+//
+// None is safe for concurrent use; guard one with the lock of whatever
+// holds it, as the repositories do their maps.
+package collections
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"reflect"
+	"strconv"
+)
+
+/*
+-----------------------------------
+ORDERED MAP
+-----------------------------------
+*/
+
+// OrderedMap is a map whose iteration order is the order keys were first
+// set. Setting an existing key keeps its place; deleting one and setting
+// it again moves it to the end. Get, Set and Delete are O(1). The zero
+// value is an empty map ready to use; hold it by pointer, as the JSON
+// methods are on *OrderedMap.
+type OrderedMap[K comparable, V any] struct {
+	entries    map[K]*omEntry[K, V]
+	head, tail *omEntry[K, V]
+}
+
+type omEntry[K comparable, V any] struct {
+	key        K
+	val        V
+	prev, next *omEntry[K, V]
+}
+
+func NewOrderedMap[K comparable, V any]() *OrderedMap[K, V] {
+	return &OrderedMap[K, V]{}
+}
+
+func (m *OrderedMap[K, V]) Len() int { return len(m.entries) }
+
+func (m *OrderedMap[K, V]) Get(k K) (V, bool) {
+	if e, ok := m.entries[k]; ok {
+		return e.val, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (m *OrderedMap[K, V]) Has(k K) bool {
+	_, ok := m.entries[k]
+	return ok
+}
+
+// Set stores v under k, at the end if k is new.
+func (m *OrderedMap[K, V]) Set(k K, v V) {
+	if e, ok := m.entries[k]; ok {
+		e.val = v
+		return
+	}
+	if m.entries == nil {
+		m.entries = make(map[K]*omEntry[K, V])
+	}
+	e := &omEntry[K, V]{key: k, val: v, prev: m.tail}
+	if m.tail != nil {
+		m.tail.next = e
+	} else {
+		m.head = e
+	}
+	m.tail = e
+	m.entries[k] = e
+}
+
+// Delete removes k, reporting whether it was there.
+func (m *OrderedMap[K, V]) Delete(k K) bool {
+	e, ok := m.entries[k]
+	if !ok {
+		return false
+	}
+	delete(m.entries, k)
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		m.head = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else {
+		m.tail = e.prev
+	}
+	return true
+}
+
+// All yields the entries in order. Deleting the entry being visited is
+// allowed; other changes during the loop are not.
+func (m *OrderedMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for e := m.head; e != nil; {
+			next := e.next
+			if !yield(e.key, e.val) {
+				return
+			}
+			e = next
+		}
+	}
+}
+
+// Keys yields the keys in order.
+func (m *OrderedMap[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range m.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// Values yields the values in order.
+func (m *OrderedMap[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for _, v := range m.All() {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// MarshalJSON encodes m as an object with its keys in order. Keys follow
+// encoding/json's rules for map keys: strings, integers, or types that
+// implement encoding.TextMarshaler.
+func (m *OrderedMap[K, V]) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	first := true
+	for k, v := range m.All() {
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		ks, err := keyString(k)
+		if err != nil {
+			return nil, err
+		}
+		kb, _ := json.Marshal(ks)
+		buf.Write(kb)
+		buf.WriteByte(':')
+		vb, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(vb)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON adds an object's entries to m in the order they appear.
+// A key given twice keeps its first place and its last value.
+func (m *OrderedMap[K, V]) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil {
+		return err
+	} else if t == nil {
+		return nil
+	} else if t != json.Delim('{') {
+		return fmt.Errorf("collections: cannot decode %v into an ordered map", t)
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var k K
+		if err := parseKey(t.(string), &k); err != nil {
+			return err
+		}
+		var v V
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+		m.Set(k, v)
+	}
+	_, err := dec.Token()
+	return err
+}
+
+func keyString(k any) (string, error) {
+	if tm, ok := k.(encoding.TextMarshaler); ok {
+		b, err := tm.MarshalText()
+		return string(b), err
+	}
+	rv := reflect.ValueOf(k)
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("collections: unsupported JSON key type %T", k)
+}
+
+func parseKey(s string, k any) error {
+	if tu, ok := k.(encoding.TextUnmarshaler); ok {
+		return tu.UnmarshalText([]byte(s))
+	}
+	rv := reflect.ValueOf(k).Elem()
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(s)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, rv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("collections: key %q: %w", s, err)
+		}
+		rv.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, rv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("collections: key %q: %w", s, err)
+		}
+		rv.SetUint(n)
+		return nil
+	}
+	return fmt.Errorf("collections: unsupported JSON key type %s", rv.Type())
+}
@@ -0,0 +1,183 @@
+package collections
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"iter"
+
+	"Go-Internals/btree"
+)
+
+/*
+-----------------------------------
+SORTED SET
+-----------------------------------
+*/
+
+// SortedSet keeps distinct values in order: O(log n) to add, remove or
+// look up, in order to iterate. It is a btree.BTree with no values.
+type SortedSet[T any] struct {
+	tree *btree.BTree[T, struct{}]
+}
+
+// NewSortedSet orders values naturally.
+func NewSortedSet[T cmp.Ordered]() *SortedSet[T] {
+	return NewSortedSetFunc(cmp.Compare[T])
+}
+
+// NewSortedSetFunc orders values by compare; values it calls equal are
+// one value.
+func NewSortedSetFunc[T any](compare func(a, b T) int) *SortedSet[T] {
+	return &SortedSet[T]{tree: btree.New[T, struct{}](32, func(a, b T) bool { return compare(a, b) < 0 })}
+}
+
+func (s *SortedSet[T]) Len() int { return s.tree.Len() }
+
+// Add adds v, reporting whether it was new.
+func (s *SortedSet[T]) Add(v T) bool {
+	_, existed := s.tree.Set(v, struct{}{})
+	return !existed
+}
+
+// Remove removes v, reporting whether it was there.
+func (s *SortedSet[T]) Remove(v T) bool {
+	_, ok := s.tree.Delete(v)
+	return ok
+}
+
+func (s *SortedSet[T]) Has(v T) bool {
+	_, ok := s.tree.Get(v)
+	return ok
+}
+
+func (s *SortedSet[T]) Min() (T, bool) {
+	v, _, ok := s.tree.Min()
+	return v, ok
+}
+
+func (s *SortedSet[T]) Max() (T, bool) {
+	v, _, ok := s.tree.Max()
+	return v, ok
+}
+
+// All yields the values in order. The set must not change during the loop.
+func (s *SortedSet[T]) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		s.tree.Ascend(func(v T, _ struct{}) bool { return yield(v) })
+	}
+}
+
+// Range yields the values from <= v < to, in order.
+func (s *SortedSet[T]) Range(from, to T) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		s.tree.AscendRange(from, to, func(v T, _ struct{}) bool { return yield(v) })
+	}
+}
+
+// MarshalJSON encodes the set as an array, in order.
+func (s *SortedSet[T]) MarshalJSON() ([]byte, error) {
+	out := make([]T, 0, s.Len())
+	for v := range s.All() {
+		out = append(out, v)
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON adds an array's values to s, which must have been made by
+// NewSortedSet or NewSortedSetFunc: the order is not in the JSON.
+func (s *SortedSet[T]) UnmarshalJSON(data []byte) error {
+	if s.tree == nil {
+		return errors.New("collections: decoding into a SortedSet without an order; make it with NewSortedSet")
+	}
+	var vals []T
+	if err := json.Unmarshal(data, &vals); err != nil {
+		return err
+	}
+	for _, v := range vals {
+		s.Add(v)
+	}
+	return nil
+}
+
+/*
+-----------------------------------
+MULTISET
+-----------------------------------
+*/
+
+// Multiset counts occurrences of values, iterating them in the order each
+// was first added. The zero value is an empty multiset ready to use.
+type Multiset[T comparable] struct {
+	counts OrderedMap[T, int]
+	total  int
+}
+
+func NewMultiset[T comparable]() *Multiset[T] { return &Multiset[T]{} }
+
+// Add adds n occurrences of v; n below 1 adds none.
+func (m *Multiset[T]) Add(v T, n int) {
+	if n < 1 {
+		return
+	}
+	c, _ := m.counts.Get(v)
+	m.counts.Set(v, c+n)
+	m.total += n
+}
+
+// Remove removes up to n occurrences of v and returns how many are left.
+// The last one gone, v loses its place in the order.
+func (m *Multiset[T]) Remove(v T, n int) int {
+	c, ok := m.counts.Get(v)
+	if !ok || n < 1 {
+		return c
+	}
+	n = min(n, c)
+	m.total -= n
+	if c -= n; c == 0 {
+		m.counts.Delete(v)
+		return 0
+	}
+	m.counts.Set(v, c)
+	return c
+}
+
+// Count is how many times v is in the set.
+func (m *Multiset[T]) Count(v T) int {
+	c, _ := m.counts.Get(v)
+	return c
+}
+
+// Len is the number of occurrences; Distinct, of values.
+func (m *Multiset[T]) Len() int      { return m.total }
+func (m *Multiset[T]) Distinct() int { return m.counts.Len() }
+
+// All yields each value and its count, in the order first added.
+func (m *Multiset[T]) All() iter.Seq2[T, int] { return m.counts.All() }
+
+type multisetEntry[T any] struct {
+	Value T   `json:"value"`
+	Count int `json:"count"`
+}
+
+// MarshalJSON encodes the multiset as an array of {"value", "count"}, in
+// order; an array because a value need not be a valid object key.
+func (m *Multiset[T]) MarshalJSON() ([]byte, error) {
+	out := make([]multisetEntry[T], 0, m.Distinct())
+	for v, c := range m.All() {
+		out = append(out, multisetEntry[T]{v, c})
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON adds what MarshalJSON wrote to m.
+func (m *Multiset[T]) UnmarshalJSON(data []byte) error {
+	var in []multisetEntry[T]
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	for _, e := range in {
+		m.Add(e.Value, e.Count)
+	}
+	return nil
+}
@@ -0,0 +1,432 @@
+// Package commitlog is an append-only record log split into segments.
+//
+// Each record gets a sequential offset. Records are framed as
+//
+//	[len u32][crc32c u32][payload]   (little endian)
+//
+// and appended to the active segment until it passes Options.SegmentBytes,
+// at which point it is sealed and a new one started. Segments are named by
+// the offset of their first record:
+//
+//	00000000000000000000.log     frames
+//	00000000000000000000.index   sparse [relOffset u32][position u32] entries
+//
+// The index gets an entry every Options.IndexInterval bytes, so reading an
+// offset is a binary search over segments, then over the index, then a
+// short forward scan of at most one interval.
+//
+// Crash recovery: only the active segment can have a torn append, so Open
+// scans it, cuts the log at the first frame that is short or fails its
+// CRC, and rebuilds its index. Sealed segments were fsynced when they were
+// sealed and are trusted as-is unless Options.Verify is set (their index
+// is rebuilt only if missing or malformed). Verify scans them too; a bad
+// frame in a sealed segment cuts that segment short, and the offsets it
+// held become a gap that Read reports as ErrOutOfRange and Scan skips.
+// Cut bytes are never just discarded: they go to the quarantine directory
+// (see package integrity) and are listed by Problems. Verify checks a log
+// without opening it for writing.
+//
+// Space is reclaimed from either end: TruncateBefore deletes whole
+// segments below an offset (retention, or a WAL whose contents are now
+// durable elsewhere), Truncate drops everything from an offset on
+// (rolling back an unacknowledged tail).
+package commitlog
+
+import (
+	"errors"
+	"iter"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"Go-Internals/integrity"
+)
+
+var (
+	ErrOutOfRange = errors.New("commitlog: offset out of range")
+	ErrCorrupt    = errors.New("commitlog: corrupt record")
+	ErrClosed     = errors.New("commitlog: log is closed")
+	ErrTooLarge   = errors.New("commitlog: record larger than a segment")
+)
+
+// Options tunes a Log.
+type Options struct {
+	SegmentBytes  int64 // roll to a new segment past this size; default 16 MiB
+	IndexInterval int64 // bytes between index entries; default 4 KiB
+	SyncWrites    bool  // fsync after every Append
+	Verify        bool  // scan sealed segments on Open, not just the active one
+}
+
+// Record is one entry read back from the log.
+type Record struct {
+	Offset uint64
+	Data   []byte
+}
+
+// Log is safe for concurrent use. Reads run in parallel with each other;
+// appends are serialized.
+type Log struct {
+	dir  string
+	opts Options
+
+	mu       sync.RWMutex
+	segments []*segment // oldest first; the last one is active
+	buf      []byte
+	closed   bool
+	gen      uint64 // bumped by Truncate; invalidates scan positions
+	problems []integrity.Problem
+}
+
+// Open opens (or creates) the log in dir.
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = 16 << 20
+	}
+	opts.SegmentBytes = min(opts.SegmentBytes, 1<<32-1) // positions are u32
+	if opts.IndexInterval <= 0 {
+		opts.IndexInterval = 4 << 10
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	bases, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{dir: dir, opts: opts}
+	for i, base := range bases {
+		s, p, err := openSegment(dir, base, i == len(bases)-1, opts.Verify, opts.IndexInterval)
+		if err != nil {
+			l.closeSegments()
+			return nil, err
+		}
+		if p != nil {
+			l.problems = append(l.problems, *p)
+		}
+		l.segments = append(l.segments, s)
+	}
+	if len(l.segments) == 0 {
+		if err := l.roll(0); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var bases []uint64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".log")
+		if !ok {
+			continue
+		}
+		base, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	slices.Sort(bases)
+	return bases, nil
+}
+
+func (l *Log) active() *segment { return l.segments[len(l.segments)-1] }
+
+// roll seals the active segment (if any) and starts a new one at base.
+func (l *Log) roll(base uint64) error {
+	if len(l.segments) > 0 {
+		if err := l.active().sync(); err != nil {
+			return err
+		}
+	}
+	s, err := createSegment(l.dir, base)
+	if err != nil {
+		return err
+	}
+	l.segments = append(l.segments, s)
+	return syncDir(l.dir)
+}
+
+/*
+-----------------------------------
+WRITES
+-----------------------------------
+*/
+
+// Append writes data as one record and returns its offset. The record is
+// all-or-nothing: after a crash it is either readable in full or absent.
+func (l *Log) Append(data []byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrClosed
+	}
+	if frameHeader+int64(len(data)) > l.opts.SegmentBytes {
+		return 0, ErrTooLarge
+	}
+
+	l.buf = appendFrame(l.buf[:0], data)
+	if s := l.active(); s.size > 0 && s.size+int64(len(l.buf)) > l.opts.SegmentBytes {
+		if err := l.roll(s.next); err != nil {
+			return 0, err
+		}
+	}
+	s := l.active()
+	off := s.next
+	if err := s.append(l.buf, l.opts.IndexInterval); err != nil {
+		return 0, err
+	}
+	if l.opts.SyncWrites {
+		if err := s.log.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	return off, nil
+}
+
+// Sync flushes the active segment to stable storage.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	return l.active().sync()
+}
+
+// Problems lists what Open cut from the log.
+func (l *Log) Problems() []integrity.Problem {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return slices.Clone(l.problems)
+}
+
+// TruncateBefore deletes every segment whose records are all below off.
+// The segment holding off is kept whole, so records just below off may
+// survive; pass NextOffset() to drop everything.
+func (l *Log) TruncateBefore(off uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	// Everything is going: start a fresh segment so there is one to keep.
+	if s := l.active(); off >= s.next && s.next > s.base {
+		if err := l.roll(s.next); err != nil {
+			return err
+		}
+	}
+
+	drop := 0
+	for drop < len(l.segments)-1 && l.segments[drop].next <= off {
+		drop++
+	}
+	var errs []error
+	for _, s := range l.segments[:drop] {
+		errs = append(errs, s.remove())
+	}
+	l.segments = slices.Delete(l.segments, 0, drop)
+	if drop > 0 {
+		errs = append(errs, syncDir(l.dir))
+	}
+	return errors.Join(errs...)
+}
+
+// Truncate drops off and every record after it; the next Append gets off.
+func (l *Log) Truncate(off uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if off < l.segments[0].base || off > l.active().next {
+		return ErrOutOfRange
+	}
+	keep := len(l.segments)
+	for keep > 1 && l.segments[keep-1].base >= off {
+		keep--
+	}
+	if off > l.segments[keep-1].next {
+		return ErrOutOfRange // inside a quarantined gap
+	}
+	var errs []error
+	for _, s := range l.segments[keep:] {
+		errs = append(errs, s.remove())
+	}
+	l.segments = l.segments[:keep]
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	l.gen++
+	s := l.active()
+	if err := s.truncate(off, l.opts.IndexInterval); err != nil {
+		return err
+	}
+	return errors.Join(s.sync(), syncDir(l.dir))
+}
+
+/*
+-----------------------------------
+READS
+-----------------------------------
+*/
+
+// OldestOffset is the first offset still in the log.
+func (l *Log) OldestOffset() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.segments[0].base
+}
+
+// NextOffset is the offset the next Append will get.
+func (l *Log) NextOffset() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.active().next
+}
+
+// Read returns the record at off.
+func (l *Log) Read(off uint64) ([]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	s, err := l.find(off)
+	if err != nil {
+		return nil, err
+	}
+	pos, err := s.position(off)
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := readFrame(s.log, pos, s.size)
+	return data, err
+}
+
+// find returns the segment holding off.
+func (l *Log) find(off uint64) (*segment, error) {
+	i, _ := slices.BinarySearchFunc(l.segments, off, func(s *segment, off uint64) int {
+		switch {
+		case off < s.base:
+			return 1
+		case off >= s.next:
+			return -1
+		default:
+			return 0
+		}
+	})
+	if i == len(l.segments) || off < l.segments[i].base || off >= l.segments[i].next {
+		return nil, ErrOutOfRange
+	}
+	return l.segments[i], nil
+}
+
+// after returns the first segment starting above off.
+func (l *Log) after(off uint64) *segment {
+	for _, s := range l.segments {
+		if s.base > off {
+			return s
+		}
+	}
+	return nil
+}
+
+// Scan yields records from offset from up to the end of the log as of
+// each step; records appended while scanning are picked up. The lock is
+// taken per record, never held while yielding, so a slow consumer does
+// not block writers. Offsets in a quarantined gap are skipped. If the
+// segment being read is truncated away the scan ends with ErrOutOfRange.
+func (l *Log) Scan(from uint64) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		var c cursor
+		for off := from; ; off++ {
+			data, done, err := l.step(&off, &c)
+			if done {
+				return
+			}
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			if !yield(Record{Offset: off, Data: data}, nil) {
+				return
+			}
+		}
+	}
+}
+
+// cursor remembers where the previous Scan step ended.
+type cursor struct {
+	seg *segment
+	pos int64
+	gen uint64
+}
+
+// step reads *off, reusing the cursor when it is still in the same
+// segment and nothing was truncated in between. An offset in a gap moves
+// *off up to the next segment.
+func (l *Log) step(off *uint64, c *cursor) ([]byte, bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return nil, false, ErrClosed
+	}
+	if *off >= l.active().next {
+		return nil, true, nil
+	}
+	s, err := l.find(*off)
+	if err != nil {
+		if s = l.after(*off); s == nil || *off < l.segments[0].base {
+			return nil, false, err
+		}
+		*off = s.base
+	}
+	if s != c.seg || c.gen != l.gen {
+		if c.pos, err = s.position(*off); err != nil {
+			return nil, false, err
+		}
+		c.seg, c.gen = s, l.gen
+	}
+	data, n, err := readFrame(s.log, c.pos, s.size)
+	if err != nil {
+		return nil, false, err
+	}
+	c.pos += n
+	return data, false, nil
+}
+
+// Close syncs and closes every segment.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	return errors.Join(l.active().sync(), l.closeSegments())
+}
+
+func (l *Log) closeSegments() error {
+	var errs []error
+	for _, s := range l.segments {
+		errs = append(errs, s.close())
+	}
+	return errors.Join(errs...)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,304 @@
+package commitlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"Go-Internals/integrity"
+)
+
+const (
+	frameHeader = 8 // [len u32][crc32 u32]
+	indexEntry  = 8 // [relOffset u32][position u32]
+)
+
+// segment is one .log file and its sparse .index.
+type segment struct {
+	base    uint64 // offset of the first record
+	next    uint64 // offset the next appended record gets
+	log     *os.File
+	index   *os.File
+	size    int64
+	entries []entry // mirror of the index file
+
+	sinceIndex int64 // bytes appended since the last index entry
+}
+
+type entry struct {
+	rel uint32 // offset - base
+	pos uint32 // byte position in the log file
+}
+
+func segmentPath(dir string, base uint64, ext string) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, ext))
+}
+
+func createSegment(dir string, base uint64) (*segment, error) {
+	s := &segment{base: base, next: base}
+	var err error
+	if s.log, err = os.OpenFile(segmentPath(dir, base, ".log"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644); err != nil {
+		return nil, err
+	}
+	if s.index, err = os.OpenFile(segmentPath(dir, base, ".index"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644); err != nil {
+		s.log.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// openSegment opens an existing segment. The active segment, and every
+// segment when verify is set, is scanned frame by frame (see recover). A
+// sealed segment otherwise trusts its index file, which is what it is
+// for, and only scans the records after the last index entry to find its
+// end; a missing or malformed index falls back to the full scan.
+func openSegment(dir string, base uint64, active, verify bool, interval int64) (*segment, *integrity.Problem, error) {
+	s := &segment{base: base, next: base}
+	var err error
+	if s.log, err = os.OpenFile(segmentPath(dir, base, ".log"), os.O_RDWR, 0); err != nil {
+		return nil, nil, err
+	}
+	if s.index, err = os.OpenFile(segmentPath(dir, base, ".index"), os.O_RDWR|os.O_CREATE, 0o644); err != nil {
+		s.log.Close()
+		return nil, nil, err
+	}
+	fi, err := s.log.Stat()
+	if err != nil {
+		s.close()
+		return nil, nil, err
+	}
+	s.size = fi.Size()
+
+	if !active && !verify && s.loadIndex() == nil && s.findEnd() == nil {
+		return s, nil, nil
+	}
+	p, err := s.recover(interval)
+	if err != nil {
+		s.close()
+		return nil, nil, err
+	}
+	return s, p, nil
+}
+
+func (s *segment) loadIndex() error {
+	data, err := io.ReadAll(io.NewSectionReader(s.index, 0, 1<<32))
+	if err != nil {
+		return err
+	}
+	if len(data)%indexEntry != 0 {
+		return ErrCorrupt
+	}
+	s.entries = s.entries[:0]
+	for b := data; len(b) > 0; b = b[indexEntry:] {
+		e := entry{binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint32(b[4:])}
+		if int64(e.pos) >= s.size {
+			return ErrCorrupt
+		}
+		s.entries = append(s.entries, e)
+	}
+	return nil
+}
+
+// findEnd counts the records after the last index entry to set next.
+func (s *segment) findEnd() error {
+	var pos int64
+	off := s.base
+	if n := len(s.entries); n > 0 {
+		pos, off = int64(s.entries[n-1].pos), s.base+uint64(s.entries[n-1].rel)
+	}
+	for pos < s.size {
+		n, err := frameSize(s.log, pos, s.size)
+		if err != nil {
+			return err
+		}
+		pos += n
+		off++
+	}
+	s.next = off
+	return nil
+}
+
+// recover rebuilds the index by scanning every frame and cuts the segment
+// at the first frame that is short or fails its CRC. In the active
+// segment that is normally a torn append; in a sealed one it is damage,
+// and the records it hid become a gap in the offsets. Either way the cut
+// bytes are saved to the quarantine directory and reported.
+func (s *segment) recover(interval int64) (*integrity.Problem, error) {
+	s.entries = s.entries[:0]
+	s.sinceIndex = 0
+	var pos int64
+	off := s.base
+	var problem *integrity.Problem
+	for pos < s.size {
+		n, err := frameSize(s.log, pos, s.size)
+		if err != nil {
+			problem = &integrity.Problem{File: s.log.Name(), Offset: pos,
+				Detail: fmt.Sprintf("bad frame at record %d, %d bytes cut", off, s.size-pos)}
+			tail := make([]byte, s.size-pos)
+			if _, err := s.log.ReadAt(tail, pos); err != nil {
+				return nil, err
+			}
+			if problem.Quarantined, err = integrity.Save(filepath.Dir(s.log.Name()), s.log.Name(), pos, tail); err != nil {
+				return nil, err
+			}
+			if err := s.log.Truncate(pos); err != nil {
+				return nil, err
+			}
+			s.size = pos
+			break
+		}
+		s.track(off, pos, n, interval)
+		pos += n
+		off++
+	}
+	s.next = off
+	return problem, s.writeIndex()
+}
+
+// track adds an index entry when interval bytes have gone by since the
+// last one. The first record is always indexed.
+func (s *segment) track(off uint64, pos, n, interval int64) {
+	if len(s.entries) == 0 || s.sinceIndex >= interval {
+		s.entries = append(s.entries, entry{uint32(off - s.base), uint32(pos)})
+		s.sinceIndex = 0
+	}
+	s.sinceIndex += n
+}
+
+func (s *segment) writeIndex() error {
+	buf := make([]byte, 0, len(s.entries)*indexEntry)
+	for _, e := range s.entries {
+		buf = binary.LittleEndian.AppendUint32(buf, e.rel)
+		buf = binary.LittleEndian.AppendUint32(buf, e.pos)
+	}
+	if err := s.index.Truncate(0); err != nil {
+		return err
+	}
+	_, err := s.index.WriteAt(buf, 0)
+	return err
+}
+
+func (s *segment) append(frame []byte, interval int64) error {
+	had := len(s.entries)
+	s.track(s.next, s.size, int64(len(frame)), interval)
+	if _, err := s.log.WriteAt(frame, s.size); err != nil {
+		s.entries = s.entries[:had]
+		return err
+	}
+	if len(s.entries) > had {
+		e := s.entries[had]
+		var b [indexEntry]byte
+		binary.LittleEndian.PutUint32(b[:], e.rel)
+		binary.LittleEndian.PutUint32(b[4:], e.pos)
+		if _, err := s.index.WriteAt(b[:], int64(had)*indexEntry); err != nil {
+			return err
+		}
+	}
+	s.size += int64(len(frame))
+	s.next++
+	return nil
+}
+
+// position finds the byte position of off, starting from the closest
+// index entry at or before it.
+func (s *segment) position(off uint64) (int64, error) {
+	rel := uint32(off - s.base)
+	lo, hi := 0, len(s.entries)
+	for lo < hi {
+		m := (lo + hi) / 2
+		if s.entries[m].rel <= rel {
+			lo = m + 1
+		} else {
+			hi = m
+		}
+	}
+	var pos int64
+	cur := s.base
+	if lo > 0 {
+		pos, cur = int64(s.entries[lo-1].pos), s.base+uint64(s.entries[lo-1].rel)
+	}
+	for ; cur < off; cur++ {
+		n, err := frameSize(s.log, pos, s.size)
+		if err != nil {
+			return 0, err
+		}
+		pos += n
+	}
+	return pos, nil
+}
+
+// truncate drops off and everything after it from the segment.
+func (s *segment) truncate(off uint64, interval int64) error {
+	pos, err := s.position(off)
+	if err != nil {
+		return err
+	}
+	if err := s.log.Truncate(pos); err != nil {
+		return err
+	}
+	s.size = pos
+	s.next = off
+	_, err = s.recover(interval)
+	return err
+}
+
+func (s *segment) sync() error {
+	return errors.Join(s.log.Sync(), s.index.Sync())
+}
+
+func (s *segment) close() error {
+	return errors.Join(s.log.Close(), s.index.Close())
+}
+
+func (s *segment) remove() error {
+	name := s.log.Name()
+	idx := s.index.Name()
+	return errors.Join(s.close(), os.Remove(name), os.Remove(idx))
+}
+
+/*
+-----------------------------------
+FRAMING
+-----------------------------------
+*/
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func appendFrame(buf, data []byte) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(data, crcTable))
+	return append(buf, data...)
+}
+
+// readFrame returns the payload at pos and the frame's total size.
+func readFrame(f io.ReaderAt, pos, size int64) ([]byte, int64, error) {
+	var h [frameHeader]byte
+	if pos+frameHeader > size {
+		return nil, 0, ErrCorrupt
+	}
+	if _, err := f.ReadAt(h[:], pos); err != nil {
+		return nil, 0, err
+	}
+	n := int64(binary.LittleEndian.Uint32(h[:]))
+	if pos+frameHeader+n > size {
+		return nil, 0, ErrCorrupt
+	}
+	data := make([]byte, n)
+	if _, err := f.ReadAt(data, pos+frameHeader); err != nil {
+		return nil, 0, err
+	}
+	if crc32.Checksum(data, crcTable) != binary.LittleEndian.Uint32(h[4:]) {
+		return nil, 0, ErrCorrupt
+	}
+	return data, frameHeader + n, nil
+}
+
+// frameSize validates the frame at pos (CRC included) and returns its size.
+func frameSize(f io.ReaderAt, pos, size int64) (int64, error) {
+	_, n, err := readFrame(f, pos, size)
+	return n, err
+}
@@ -0,0 +1,219 @@
+// Package compression compresses HTTP responses for the clients that
+// accept it and decompresses request bodies that arrive compressed.
+//
+// A response is held back until MinSize bytes are written (or the
+// handler returns, or flushes): below that, framing costs more than it
+// saves, and only then is the decision made and the status and headers
+// sent. Responses that are already encoded, of a type that does not
+// compress (images, archives), partial (206) or bodiless (HEAD, 204,
+// 304) pass through untouched. Every response of a compressible type
+// carries Vary: Accept-Encoding, compressed or not, so a shared cache
+// does not hand a gzip body to a client that did not ask for one.
+//
+// Compressors are pooled per encoding: a gzip.Writer allocates hundreds
+// of kilobytes of state, so making one per response would dominate the
+// cost of compressing small JSON bodies.
+//
+// gzip and deflate come from the standard library. Others, such as zstd,
+// plug in as an Encoding built on a third-party codec.
+package compression
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrUnsupported is returned for a request body in an encoding the
+// middleware does not know.
+var ErrUnsupported = errors.New("compression: unsupported content encoding")
+
+// Writer is a compressor that can be reused for another stream.
+// *gzip.Writer, *zlib.Writer and *flate.Writer satisfy it.
+type Writer interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Encoding is a content coding, named as in Accept-Encoding and
+// Content-Encoding.
+type Encoding struct {
+	Name string
+	// NewWriter returns a compressor writing to w. It only runs when the
+	// pool is empty; pooled writers are Reset instead.
+	NewWriter func(w io.Writer) Writer
+	// NewReader decodes a request body. Nil means the encoding is only
+	// produced, never accepted.
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// Gzip is the gzip coding at level (gzip.DefaultCompression, or 1-9).
+func Gzip(level int) Encoding {
+	return Encoding{
+		Name: "gzip",
+		NewWriter: func(w io.Writer) Writer {
+			zw, err := gzip.NewWriterLevel(w, level)
+			if err != nil {
+				panic(err) // an invalid level is a programming error
+			}
+			return zw
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	}
+}
+
+// Deflate is HTTP's "deflate" coding: zlib-wrapped, despite the name.
+// Some old clients send raw deflate instead; the reader accepts both.
+func Deflate(level int) Encoding {
+	return Encoding{
+		Name: "deflate",
+		NewWriter: func(w io.Writer) Writer {
+			zw, err := zlib.NewWriterLevel(w, level)
+			if err != nil {
+				panic(err)
+			}
+			return zw
+		},
+		NewReader: newDeflateReader,
+	}
+}
+
+// newDeflateReader tells zlib from raw deflate by the zlib header: its
+// first byte is 0x?8 (deflate, a window size) and the two header bytes
+// are a multiple of 31 as a big-endian number.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	if h, err := br.Peek(2); err == nil && h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// Options configures Middleware and Decompress.
+type Options struct {
+	// Encodings are offered in order of preference when a client accepts
+	// several equally; default gzip then deflate, at their default levels.
+	Encodings []Encoding
+	// MinSize is the smallest body compressed; default 1024 bytes.
+	MinSize int
+	// Types are the compressible media types. An entry ending in "/"
+	// matches a whole family ("text/"); default text, JSON, NDJSON, XML,
+	// JavaScript, SVG and any +json or +xml type.
+	Types []string
+	// MaxDecoded bounds a decompressed request body: a few kilobytes of
+	// gzip can expand to gigabytes. Reading past it fails with
+	// *http.MaxBytesError; default 64 MiB.
+	MaxDecoded int64
+}
+
+func (o Options) withDefaults() Options {
+	if len(o.Encodings) == 0 {
+		o.Encodings = []Encoding{Gzip(gzip.DefaultCompression), Deflate(zlib.DefaultCompression)}
+	}
+	if o.MinSize <= 0 {
+		o.MinSize = 1024
+	}
+	if len(o.Types) == 0 {
+		o.Types = []string{
+			"text/", "application/json", "application/x-ndjson", "application/xml",
+			"application/javascript", "image/svg+xml", "+json", "+xml",
+		}
+	}
+	if o.MaxDecoded <= 0 {
+		o.MaxDecoded = 64 << 20
+	}
+	return o
+}
+
+// compressible reports whether ctype is one of types.
+func compressible(types []string, ctype string) bool {
+	mt, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		switch {
+		case strings.HasPrefix(t, "+"):
+			if strings.HasSuffix(mt, t) {
+				return true
+			}
+		case strings.HasSuffix(t, "/"):
+			if strings.HasPrefix(mt, t) {
+				return true
+			}
+		case mt == t:
+			return true
+		}
+	}
+	return false
+}
+
+// negotiate picks the encoding to answer Accept-Encoding with, nil for
+// identity. The highest q wins, ties going to the earlier encoding; "*"
+// stands for every coding the header does not name, and q=0 refuses.
+func negotiate(encodings []*pooled, header string) *pooled {
+	if header == "" {
+		return nil
+	}
+	qs := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				} else {
+					q = 0
+				}
+			}
+		}
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		qs[name] = q
+	}
+	var best *pooled
+	bestQ := 0.0
+	for _, e := range encodings {
+		q, ok := qs[e.Name]
+		if !ok {
+			q = qs["*"]
+		}
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best
+}
+
+// pooled is an Encoding with its pool of idle writers.
+type pooled struct {
+	Encoding
+	pool sync.Pool
+}
+
+func (p *pooled) get(w io.Writer) Writer {
+	if zw, ok := p.pool.Get().(Writer); ok {
+		zw.Reset(w)
+		return zw
+	}
+	return p.NewWriter(w)
+}
+
+func (p *pooled) put(zw Writer) {
+	zw.Reset(io.Discard) // drop the reference to the response
+	p.pool.Put(zw)
+}
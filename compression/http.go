@@ -0,0 +1,226 @@
+package compression
+
+import (
+	"net/http"
+	"strings"
+)
+
+/*
+-----------------------------------
+RESPONSES
+-----------------------------------
+*/
+
+// Middleware compresses responses in the encoding the client prefers
+// among opts.Encodings.
+func Middleware(opts Options) func(http.Handler) http.Handler {
+	opts = opts.withDefaults()
+	encs := make([]*pooled, len(opts.Encodings))
+	for i, e := range opts.Encodings {
+		encs[i] = &pooled{Encoding: e}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cw := &responseWriter{
+				ResponseWriter: w,
+				opts:           &opts,
+				enc:            negotiate(encs, r.Header.Get("Accept-Encoding")),
+				head:           r.Method == http.MethodHead,
+			}
+			// Not deferred: after a panic, whatever was buffered is dropped
+			// and the crash handler writes its 500 to w itself.
+			next.ServeHTTP(cw, r)
+			cw.close()
+		})
+	}
+}
+
+// responseWriter buffers the start of a response until it knows whether
+// to compress it.
+type responseWriter struct {
+	http.ResponseWriter
+	opts *Options
+	enc  *pooled // nil: the client takes identity only
+	head bool
+
+	status  int // 0 until WriteHeader
+	buf     []byte
+	decided bool
+	zw      Writer // set once compressing
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code) // informational: more headers follow
+		return
+	}
+	w.status = code
+	if !bodyAllowed(code) || w.head {
+		w.decide(false)
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.opts.MinSize {
+			return len(p), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.zw != nil {
+		return w.zw.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush decides at once, compressing if the response qualifies at all:
+// a flushing handler is streaming, and its total size is unknown.
+func (w *responseWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.zw != nil {
+		w.zw.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the connection, for
+// deadlines and hijacking.
+func (w *responseWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// decide sends the status and headers and whatever is buffered, through
+// a compressor if big is set and the response qualifies.
+func (w *responseWriter) decide(big bool) error {
+	w.decided = true
+	h := w.Header()
+	if _, set := h["Content-Type"]; !set && len(w.buf) > 0 && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Type", http.DetectContentType(w.buf)) // what net/http would do
+	}
+	negotiable := compressible(w.opts.Types, h.Get("Content-Type")) && h.Get("Content-Encoding") == ""
+	if negotiable || w.status == http.StatusNotModified {
+		addVary(h, "Accept-Encoding")
+	}
+	if big && negotiable && w.enc != nil && !w.head && bodyAllowed(w.status) && w.status != http.StatusPartialContent {
+		h.Set("Content-Encoding", w.enc.Name)
+		h.Del("Content-Length")
+		// The bytes differ from the identity body the tag was made for.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.ResponseWriter.WriteHeader(w.status)
+		w.zw = w.enc.get(w.ResponseWriter)
+		_, err := w.zw.Write(w.buf)
+		w.buf = nil
+		return err
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	var err error
+	if len(w.buf) > 0 {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// close ends the response once the handler has returned.
+func (w *responseWriter) close() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.decided {
+		w.decide(false) // under MinSize
+	}
+	if w.zw != nil {
+		w.zw.Close()
+		w.enc.put(w.zw)
+		w.zw = nil
+	}
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+func addVary(h http.Header, field string) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+/*
+-----------------------------------
+REQUESTS
+-----------------------------------
+*/
+
+// Decompress decodes request bodies sent with a Content-Encoding among
+// opts.Encodings, so handlers read plain bytes. The decoded body is
+// capped at opts.MaxDecoded and the request's Content-Encoding and
+// Content-Length are removed, since neither describes it any more. An
+// unknown encoding is refused with 415 and the Accept-Encoding the
+// server does take (RFC 7694).
+//
+// Decoding happens as the handler reads, so the handler's own body
+// limit, if lower, is the one that applies.
+func Decompress(opts Options) func(http.Handler) http.Handler {
+	opts = opts.withDefaults()
+	var names []string
+	readers := map[string]Encoding{}
+	for _, e := range opts.Encodings {
+		if e.NewReader != nil {
+			names = append(names, e.Name)
+			readers[e.Name] = e
+		}
+	}
+	if e, ok := readers["gzip"]; ok {
+		readers["x-gzip"] = e
+	}
+	accept := strings.Join(names, ", ")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ce := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+			if ce == "" || ce == "identity" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			e, ok := readers[ce]
+			if !ok {
+				w.Header().Set("Accept-Encoding", accept)
+				http.Error(w, ErrUnsupported.Error()+": "+ce, http.StatusUnsupportedMediaType)
+				return
+			}
+			body, err := e.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "invalid "+ce+" body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer body.Close()
+			r2 := r.WithContext(r.Context())
+			r2.Header = r.Header.Clone()
+			r2.Header.Del("Content-Encoding")
+			r2.Header.Del("Content-Length")
+			r2.ContentLength = -1
+			r2.Body = http.MaxBytesReader(w, body, opts.MaxDecoded)
+			next.ServeHTTP(w, r2)
+		})
+	}
+}
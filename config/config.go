@@ -0,0 +1,269 @@
+// Package config loads a command's flags from, in rising order of
+// precedence, their defaults, a named profile's, a JSON file, the
+// environment and the command line, and remembers where each value came
+// from, so the configuration a process runs with can be printed, secrets
+// redacted, and checked before anything starts.
+//
+// Server is the users server's: its flags, and the rules they must
+// follow, shared by the server and by usersctl config.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Source is where a value came from.
+type Source string
+
+const (
+	Default Source = "default"
+	Profile Source = "profile"
+	File    Source = "file"
+	Env     Source = "env"
+	Flag    Source = "flag"
+)
+
+// Options configures Parse.
+type Options struct {
+	// EnvPrefix, if set, reads flag foo-bar from $<EnvPrefix>FOO_BAR.
+	EnvPrefix string
+	// FileFlag, if set, names the flag holding the path of a JSON file
+	// of flag values, {"foo-bar": "value", "list": ["a", "b"], ...}. It
+	// is read from the command line or the environment, not from the
+	// file.
+	FileFlag string
+	// ProfileFlag, if set, names the flag that picks one of Profiles,
+	// read, like FileFlag, from the command line or the environment.
+	ProfileFlag string
+	Profiles    map[string]Defaults
+	// Secret reports whether a flag's value is redacted when shown.
+	Secret func(name string) bool
+	// LookupEnv defaults to os.LookupEnv.
+	LookupEnv func(key string) (string, bool)
+}
+
+// Defaults are a profile's: flag values that replace the built-in
+// defaults, on top of those of the profile it Extends, if any.
+type Defaults struct {
+	Extends string
+	Values  map[string]string
+}
+
+// Loaded is a parsed flag set, with the sources of its values.
+type Loaded struct {
+	fs      *flag.FlagSet
+	opts    Options
+	sources map[string]Source
+	file    string
+	profile string
+}
+
+// Value is one flag's.
+type Value struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Default string `json:"default"`
+	Source  Source `json:"source"`
+	// Env is the variable the flag is read from.
+	Env    string `json:"env,omitempty"`
+	Secret bool   `json:"secret,omitempty"`
+}
+
+// Parse parses args into fs, then sets every flag the command line left
+// alone from the environment or, failing that, the file or the profile.
+func Parse(fs *flag.FlagSet, args []string, opts Options) (*Loaded, error) {
+	if opts.LookupEnv == nil {
+		opts.LookupEnv = os.LookupEnv
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	l := &Loaded{fs: fs, opts: opts, sources: map[string]Source{}}
+	fs.Visit(func(f *flag.Flag) { l.sources[f.Name] = Flag })
+
+	// The file's path and the profile first, since either may come from
+	// the environment.
+	for _, name := range []string{opts.FileFlag, opts.ProfileFlag} {
+		if name != "" && l.sources[name] == "" {
+			if err := l.setEnv(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	var profile map[string]string
+	if opts.ProfileFlag != "" {
+		if f := fs.Lookup(opts.ProfileFlag); f != nil && f.Value.String() != "" {
+			l.profile = f.Value.String()
+			var err error
+			if profile, err = resolve(opts.Profiles, l.profile); err != nil {
+				return nil, err
+			}
+			for name := range profile {
+				if fs.Lookup(name) == nil {
+					return nil, fmt.Errorf("config: profile %s: no flag -%s", l.profile, name)
+				}
+			}
+		}
+	}
+	var file map[string]json.RawMessage
+	if opts.FileFlag != "" {
+		if f := fs.Lookup(opts.FileFlag); f != nil && f.Value.String() != "" {
+			l.file = f.Value.String()
+			b, err := os.ReadFile(l.file)
+			if err != nil {
+				return nil, fmt.Errorf("config: %w", err)
+			}
+			if err := json.Unmarshal(b, &file); err != nil {
+				return nil, fmt.Errorf("config: %s: %w", l.file, err)
+			}
+		}
+	}
+	for name := range file {
+		if name == opts.FileFlag || name == opts.ProfileFlag {
+			return nil, fmt.Errorf("config: %s: -%s cannot be set from the file itself", l.file, name)
+		}
+		if fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("config: %s: no flag -%s", l.file, name)
+		}
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if l.sources[f.Name] != "" || f.Name == opts.FileFlag || f.Name == opts.ProfileFlag {
+			return
+		}
+		if err := l.setEnv(f.Name); err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if l.sources[f.Name] != "" {
+			return
+		}
+		raw, ok := file[f.Name]
+		if !ok {
+			l.sources[f.Name] = Default
+			if v, ok := profile[f.Name]; ok {
+				if err := f.Value.Set(v); err != nil {
+					errs = append(errs, fmt.Errorf("config: profile %s: -%s: %w", l.profile, f.Name, err))
+					return
+				}
+				l.sources[f.Name] = Profile
+			}
+			return
+		}
+		// Strings are as is, lists of them joined with commas (see
+		// ListVar); numbers and booleans as written.
+		v := string(raw)
+		var s string
+		var list []string
+		if json.Unmarshal(raw, &s) == nil {
+			v = s
+		} else if json.Unmarshal(raw, &list) == nil {
+			v = strings.Join(list, ",")
+		}
+		if err := f.Value.Set(v); err != nil {
+			errs = append(errs, fmt.Errorf("config: %s: -%s: %w", l.file, f.Name, err))
+			return
+		}
+		l.sources[f.Name] = File
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Loaded) setEnv(name string) error {
+	key := l.EnvName(name)
+	if key == "" {
+		return nil
+	}
+	v, ok := l.opts.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+	if err := l.fs.Set(name, v); err != nil {
+		return fmt.Errorf("config: $%s (-%s): %w", key, name, err)
+	}
+	l.sources[name] = Env
+	return nil
+}
+
+// EnvName is the variable flag name is read from, "" without a prefix.
+func (l *Loaded) EnvName(name string) string {
+	if l.opts.EnvPrefix == "" {
+		return ""
+	}
+	return l.opts.EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// resolve flattens profile name's defaults onto those it extends.
+func resolve(profiles map[string]Defaults, name string) (map[string]string, error) {
+	var chain []string
+	for p := name; p != ""; p = profiles[p].Extends {
+		if slices.Contains(chain, p) {
+			return nil, fmt.Errorf("config: profiles extend each other: %s", strings.Join(append(chain, p), " -> "))
+		}
+		if _, ok := profiles[p]; !ok {
+			names := slices.Sorted(maps.Keys(profiles))
+			return nil, fmt.Errorf("config: no profile %q (want %s)", p, strings.Join(names, ", "))
+		}
+		chain = append(chain, p)
+	}
+	out := map[string]string{}
+	for _, p := range slices.Backward(chain) {
+		maps.Copy(out, profiles[p].Values)
+	}
+	return out, nil
+}
+
+// File is the config file read, if any.
+func (l *Loaded) File() string { return l.file }
+
+// Profile is the profile picked, if any.
+func (l *Loaded) Profile() string { return l.profile }
+
+// Source is where flag name's value came from.
+func (l *Loaded) Source(name string) Source { return l.sources[name] }
+
+// Values lists every flag, sorted by name, secrets redacted.
+func (l *Loaded) Values() []Value {
+	var out []Value
+	l.fs.VisitAll(func(f *flag.Flag) {
+		v := Value{Name: f.Name, Value: f.Value.String(), Default: f.DefValue, Source: l.sources[f.Name], Env: l.EnvName(f.Name)}
+		if v.Source == "" {
+			v.Source = Default
+		}
+		if l.opts.Secret != nil && l.opts.Secret(f.Name) {
+			v.Secret = true
+			v.Value, v.Default = Redact(v.Value), Redact(v.Default)
+		}
+		out = append(out, v)
+	})
+	slices.SortFunc(out, func(a, b Value) int { return strings.Compare(a.Name, b.Name) })
+	return out
+}
+
+// Redacted stands in for a secret.
+const Redacted = "[redacted]"
+
+// Redact hides a secret value: a URL keeps its scheme and host, since
+// tokens ride in its path, query or user info; anything else goes
+// whole. An empty value stays empty, to show it is unset.
+func Redact(v string) string {
+	if v == "" {
+		return ""
+	}
+	if u, err := url.Parse(v); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Scheme + "://" + u.Host + "/" + Redacted
+	}
+	return Redacted
+}
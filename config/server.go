@@ -0,0 +1,546 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"Go-Internals/accesslog"
+	"Go-Internals/blobstore"
+	"Go-Internals/experiment"
+	"Go-Internals/featureflag"
+	"Go-Internals/geoip"
+	"Go-Internals/httpsec"
+	"Go-Internals/logfilter"
+	"Go-Internals/oauth"
+	"Go-Internals/reqsign"
+)
+
+// ServerOptions is how the server loads a Server.
+var ServerOptions = Options{
+	EnvPrefix:   "USERS_",
+	FileFlag:    "config",
+	ProfileFlag: "env",
+	Profiles:    Profiles,
+	// Webhook URLs carry their tokens.
+	Secret: func(name string) bool { return name == "alert-webhook" || name == "approval-webhook" },
+}
+
+// SecretEnv are the variables the server reads secrets from directly,
+// never from a flag; usersctl config print shows whether each is set.
+var SecretEnv = []string{
+	"USERS_JWT_SECRET",
+	"USERS_FIELD_KEY",
+	"USERS_DATA_KEYS",
+	"USERS_SMTP_USER",
+	"USERS_SMTP_PASSWORD",
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+}
+
+// Profiles are the environments -env picks, each a set of defaults:
+// test starts from dev, staging from prod.
+var Profiles = map[string]Defaults{
+	// On a laptop: nothing kept, everything logged, plain HTTP.
+	"dev": {Values: map[string]string{
+		"store":            "memory",
+		"log-levels":       "debug",
+		"log-sample-first": "0",
+		"geoip-db":         "test",
+		"hsts":             "0s",
+	}},
+	// Under go test or CI: dev, quieter, and failing fast.
+	"test": {Extends: "dev", Values: map[string]string{
+		"log-levels":       "warn",
+		"startup-attempts": "1",
+		"slow-op":          "0s",
+	}},
+	// Deployed: a file-backed store and logs for a collector.
+	"prod": {Values: map[string]string{
+		"store":             "kv",
+		"log-levels":        "info",
+		"log-format":        "json",
+		"access-log-format": "json",
+		"startup-attempts":  "10",
+	}},
+	"staging": {Extends: "prod", Values: map[string]string{
+		"log-levels": "info,httpapi=debug",
+	}},
+}
+
+/*
+-----------------------------------
+SERVER
+-----------------------------------
+*/
+
+// Server is the users server's configuration, a field per flag; Define
+// says what each does.
+type Server struct {
+	ConfigFile        string
+	Env               string
+	Store             string
+	DataPath          string
+	HTTPAddr          string
+	CrashDir          string
+	FlagsPath         string
+	ExperimentsPath   string
+	FoldMailbox       bool
+	CaseNames         bool
+	CheckMX           bool
+	AbusePerIP        int
+	AbuseWindow       time.Duration
+	DisposableDomains string
+	GeoDB             string
+	ActivityKeep      int
+	LockFailures      float64
+	LockIPFailures    float64
+	LockFor           time.Duration
+	LockHalfLife      time.Duration
+	MXTimeout         time.Duration
+	Daemon            bool
+	LogFile           string
+	PIDPath           string
+	CheckOnly         bool
+	StartupAttempts   int
+	StartupBackoff    time.Duration
+	VacuumEvery       time.Duration
+	VacuumRate        int64
+	RetainAudit       time.Duration
+	AnonymizeAfter    time.Duration
+	RetentionDryRun   bool
+	ShadowStore       string
+	ShadowData        string
+	GreenStore        string
+	GreenData         string
+	ShadowReads       float64
+	PluginsPath       string
+	ScriptsDir        string
+	AvatarStore       string
+	TwoFactorStore    string
+	TwoFactorRoles    []string
+	AccessTTL         time.Duration
+	RefreshTTL        time.Duration
+	KeyStore          string
+	JWTKeyRotate      time.Duration
+	JWTKeyKeep        time.Duration
+	FieldKeyRotate    time.Duration
+	PeerKeys          string
+	RequireSigned     []string
+	ImpersonationTTL  time.Duration
+	ServiceTokenTTL   time.Duration
+	OIDCProviders     string
+	OIDCStore         string
+	OIDCLinkByEmail   bool
+	OIDCSession       time.Duration
+	UploadStore       string
+	DownloadRate      int64
+	ConnRate          int64
+	AccessLogPath     string
+	AccessLogFormat   string
+	AccessLogSample   string
+	UploadTTL         time.Duration
+	LogPath           string
+	LogMaxSize        int64
+	LogMaxAge         time.Duration
+	LogMaxFiles       int
+	LogCompress       bool
+	LogLevelSpec      string
+	LogFormat         string
+	LogLevelsFile     string
+	LogSampleFirst    int
+	LogSampleRate     float64
+	MaintenanceReason string
+	DryRun            bool
+	ApprovalTTL       time.Duration
+	ApprovalWebhook   string
+	BackupDir         string
+	AlertWebhook      string
+	AlertEmail        []string
+	SMTPAddr          string
+	AlertFrom         string
+	CORSOrigins       []string
+	CORSCredentials   bool
+	MaxBody           int64
+	HSTS              time.Duration
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	IdleTimeout       time.Duration
+	RequestTimeout    time.Duration
+	SlowOp            time.Duration
+	TraceKeep         int
+	TraceSlow         time.Duration
+	ProfileDir        string
+	ProfileCPU        float64
+	ProfileLatency    time.Duration
+	ProfileKeep       int
+	AssetsDir         string
+}
+
+// Define registers s's flags on fs. Any of them may also be set from
+// $USERS_<FLAG> (-log-levels from $USERS_LOG_LEVELS) or the -config
+// file, and -env's profile changes their defaults; see ServerOptions.
+func (s *Server) Define(fs *flag.FlagSet) {
+	fs.StringVar(&s.ConfigFile, "config", "", "JSON file of flag values, e.g. {\"store\": \"kv\", \"http\": \":8080\"}; $USERS_<FLAG> and the command line override it")
+	fs.StringVar(&s.Env, "env", "", "profile of defaults to start from: dev, test, staging or prod; the -config file, $USERS_<FLAG> and the command line override it (empty: none)")
+	fs.StringVar(&s.Store, "store", "memory", "user storage backend: memory, mmap, kv or plugin")
+	fs.StringVar(&s.DataPath, "data", "users.db", "data file (mmap) or directory (kv) for file-backed stores")
+	fs.StringVar(&s.HTTPAddr, "http", "", "serve the API and admin dashboard on this address after the demo (e.g. :8080)")
+	fs.StringVar(&s.CrashDir, "crash-dir", os.TempDir(), "directory for crash reports")
+	fs.StringVar(&s.FlagsPath, "flags", "", "feature flag JSON file (reloaded on SIGHUP)")
+	fs.StringVar(&s.ExperimentsPath, "experiments", "", "A/B experiments to assign users to (JSON array of name, variants with weights, and an optional gating flag)")
+	fs.BoolVar(&s.FoldMailbox, "fold-mailbox", false, "store emails as their mailbox: without a +tag and, for Gmail, without dots")
+	fs.BoolVar(&s.CaseNames, "case-names", false, "capitalize the words of names typed all in one case")
+	fs.BoolVar(&s.CheckMX, "check-mx", false, "refuse emails whose domain, by DNS, takes no mail")
+	fs.IntVar(&s.AbusePerIP, "abuse-per-ip", 0, "score registrations for abuse, counting this many per client address per -abuse-window as too many (0: no scoring)")
+	DurationVar(fs, &s.AbuseWindow, "abuse-window", time.Hour, "the window -abuse-per-ip counts registrations in")
+	fs.StringVar(&s.DisposableDomains, "disposable-domains", "", "file of disposable email domains, one per line, for abuse scoring (default: a short built-in list)")
+	fs.StringVar(&s.GeoDB, "geoip-db", "", "CSV of IP ranges and countries for the access history, or test for the built-in one of documentation addresses (empty: no countries)")
+	fs.IntVar(&s.ActivityKeep, "activity-keep", 50, "registrations and sign-ins kept per user in the access history")
+	fs.Float64Var(&s.LockFailures, "lockout-failures", 5, "failed token verifications, decayed, that lock an account")
+	fs.Float64Var(&s.LockIPFailures, "lockout-ip-failures", 20, "failed token verifications, decayed, that throttle a client address")
+	DurationVar(fs, &s.LockFor, "lockout-for", 15*time.Minute, "how long a lockout lasts")
+	DurationVar(fs, &s.LockHalfLife, "lockout-half-life", 10*time.Minute, "how long a failed verification takes to count half")
+	DurationVar(fs, &s.MXTimeout, "mx-timeout", 2*time.Second, "how long -check-mx waits for DNS before letting an email through")
+	fs.BoolVar(&s.Daemon, "daemon", false, "detach and run in the background (requires -http)")
+	fs.StringVar(&s.LogFile, "log-file", "users.log", "stdout/stderr of the detached process")
+	fs.StringVar(&s.PIDPath, "pidfile", "", "PID file guarding against a second instance")
+	fs.BoolVar(&s.CheckOnly, "check-only", false, "check that the stores and servers this configuration depends on answer, print the result and exit: 0 if all do, 1 if not")
+	fs.IntVar(&s.StartupAttempts, "startup-attempts", 5, "how often a dependency is checked at startup before the server gives up")
+	DurationVar(fs, &s.StartupBackoff, "startup-backoff", 500*time.Millisecond, "wait after a dependency's first failed check, doubled after each failure after it, up to 10s")
+	DurationVar(fs, &s.VacuumEvery, "vacuum-every", time.Hour, "how often file-backed stores are compacted")
+	SizeVar(fs, &s.VacuumRate, "vacuum-rate", 8<<20, "compaction write budget per second, e.g. 8MiB")
+	DurationVar(fs, &s.RetainAudit, "retain-audit", 90*24*time.Hour, "delete audit entries older than this (0 = keep)")
+	DurationVar(fs, &s.AnonymizeAfter, "anonymize-inactive", 0, "anonymize users inactive for longer than this (0 = never)")
+	fs.BoolVar(&s.RetentionDryRun, "retention-dry-run", false, "only log what the retention rules would delete")
+	fs.StringVar(&s.ShadowStore, "shadow-store", "", "mirror every write to this backend too, for a migration cutover (memory, mmap or kv)")
+	fs.StringVar(&s.ShadowData, "shadow-data", "users.shadow", "data file or directory of -shadow-store")
+	fs.StringVar(&s.GreenStore, "green-store", "", "run this backend beside -store, blue/green: both take every write, and the repo_green_reads and repo_green_writes flags shift traffic to it (memory, mmap or kv)")
+	fs.StringVar(&s.GreenData, "green-data", "users.green", "data file or directory of -green-store")
+	fs.Float64Var(&s.ShadowReads, "shadow-reads", 0, "fraction of reads also served by -shadow-store and compared (0 = none)")
+	fs.StringVar(&s.PluginsPath, "plugins", "", "plugin manifest (JSON): validators, event subscribers and a storage backend")
+	fs.StringVar(&s.ScriptsDir, "scripts", "", "directory of *.script hooks run on registrations and events")
+	fs.StringVar(&s.AvatarStore, "avatar-store", "mem:", "blob store for avatar images: mem:, a directory, or s3://bucket/prefix")
+	fs.StringVar(&s.TwoFactorStore, "2fa-store", "mem:", "blob store for two-factor enrollments, their secrets sealed with $USERS_FIELD_KEY if set")
+	ListVar(fs, &s.TwoFactorRoles, "2fa-require", nil, "roles whose tokens must pass a second factor, comma-separated, e.g. admin")
+	DurationVar(fs, &s.AccessTTL, "access-ttl", 15*time.Minute, "lifetime of the access tokens sessions issue")
+	DurationVar(fs, &s.RefreshTTL, "refresh-ttl", 30*24*time.Hour, "how long a session lasts without a refresh before it ends")
+	fs.StringVar(&s.KeyStore, "key-store", "", "blob store for versioned signing and field encryption keys, sealed with $USERS_DATA_KEYS if set (empty: the keys in the environment, never rotated)")
+	DurationVar(fs, &s.JWTKeyRotate, "jwt-key-rotate", 0, "rotate the token signing key this often, with -key-store (0 = on demand only)")
+	DurationVar(fs, &s.JWTKeyKeep, "jwt-key-keep", 48*time.Hour, "how long a retired signing key still verifies tokens; longer than any token lasts")
+	DurationVar(fs, &s.FieldKeyRotate, "field-key-rotate", 0, "rotate the field encryption key this often, with -key-store (0 = on demand only); retired versions are kept until dropped")
+	fs.StringVar(&s.PeerKeys, "peer-keys", "", "JSON array of the keys other services sign their requests with (HMAC secrets or Ed25519 public keys, with the roles they act as)")
+	ListVar(fs, &s.RequireSigned, "require-signed", nil, "path prefixes whose requests must be signed with a -peer-keys key, comma-separated, e.g. /debug/")
+	DurationVar(fs, &s.ImpersonationTTL, "impersonation-max-ttl", time.Hour, "the longest an admin may impersonate a user for with one token")
+	DurationVar(fs, &s.ServiceTokenTTL, "service-token-ttl", time.Hour, "lifetime of the tokens service accounts are issued at /auth/token (at most 24h, to stay revocable)")
+	fs.StringVar(&s.OIDCProviders, "oidc-providers", "", "OpenID Connect providers to sign in with (JSON array); a missing client_secret is read from $USERS_OIDC_<NAME>_SECRET")
+	fs.StringVar(&s.OIDCStore, "oidc-store", "mem:", "blob store for linked provider identities, their tokens sealed with $USERS_FIELD_KEY if set")
+	fs.BoolVar(&s.OIDCLinkByEmail, "oidc-link-by-email", false, "link a new provider identity to the user with its verified email instead of refusing it")
+	DurationVar(fs, &s.OIDCSession, "oidc-session-ttl", 12*time.Hour, "lifetime of the tokens provider sign-ins issue")
+	fs.StringVar(&s.UploadStore, "upload-store", "mem:", "blob store for the chunks of resumable uploads")
+	SizeVar(fs, &s.DownloadRate, "download-rate", 0, "cap on all export downloads together, in bytes per second (0 = unlimited)")
+	SizeVar(fs, &s.ConnRate, "conn-download-rate", 0, "cap on export downloads over one connection, in bytes per second (0 = unlimited)")
+	fs.StringVar(&s.AccessLogPath, "access-log", "", "write an HTTP access log to this file (- for stdout)")
+	fs.StringVar(&s.AccessLogFormat, "access-log-format", "common", "access log format: common or json")
+	fs.StringVar(&s.AccessLogSample, "access-log-sample", "/healthz=100", "log one in N requests under these paths, as prefix=N,... (errors and slow requests always)")
+	DurationVar(fs, &s.UploadTTL, "upload-ttl", 24*time.Hour, "how long an untouched resumable upload is kept")
+	fs.StringVar(&s.LogPath, "log", "", "write the application log to this file instead of stderr")
+	SizeVar(fs, &s.LogMaxSize, "log-max-size", 100<<20, "rotate -log and -access-log files at this size, e.g. 100MiB")
+	DurationVar(fs, &s.LogMaxAge, "log-max-age", 0, "also rotate log files this often (0 = on size only)")
+	fs.IntVar(&s.LogMaxFiles, "log-max-files", 7, "rotated log files to keep (-1 = all)")
+	fs.BoolVar(&s.LogCompress, "log-compress", true, "gzip rotated log files")
+	fs.StringVar(&s.LogLevelSpec, "log-levels", "info", "log levels, default and per module: info,vacuum=debug,httpapi=warn")
+	fs.StringVar(&s.LogFormat, "log-format", "text", "application log format: text or json")
+	fs.StringVar(&s.LogLevelsFile, "log-levels-file", "", "file of -log-levels, re-read on SIGUSR2 (instead of toggling debug)")
+	fs.IntVar(&s.LogSampleFirst, "log-sample-first", 20, "write the first N of a repeated message per second, then sample (0 = write all)")
+	fs.Float64Var(&s.LogSampleRate, "log-sample-rate", 0.01, "fraction of a repeated message written past -log-sample-first")
+	fs.StringVar(&s.MaintenanceReason, "maintenance", "", "start in maintenance, with this reason: writes are refused and jobs paused until an admin switches it off")
+	fs.BoolVar(&s.DryRun, "dry-run", false, "run every write that can run dry (see the Dry-Run header) as a dry run, answering with what it would do, and refuse the others with 503")
+	DurationVar(fs, &s.ApprovalTTL, "approval-ttl", time.Hour, "how long a destructive operation waits for a second admin's approval (0 = no two-person rule)")
+	URLVar(fs, &s.ApprovalWebhook, "approval-webhook", "", "POST approval events (requested, executed, rejected, expired) as JSON to this URL")
+	fs.StringVar(&s.BackupDir, "backup-dir", "", "serve POST /backups/restore from the backups in this directory (into an empty store)")
+	URLVar(fs, &s.AlertWebhook, "alert-webhook", "", "POST error alerts as JSON to this URL")
+	ListVar(fs, &s.AlertEmail, "alert-email", nil, "mail error alerts to these addresses, comma-separated (needs -smtp)")
+	fs.StringVar(&s.SMTPAddr, "smtp", "localhost:25", "SMTP server for -alert-email; USERS_SMTP_USER and USERS_SMTP_PASSWORD log in")
+	fs.StringVar(&s.AlertFrom, "alert-from", "users@localhost", "sender of -alert-email mails")
+	ListVar(fs, &s.CORSOrigins, "cors-origins", nil, "browser origins allowed to call the API, comma-separated: exact, https://*.example.com or * (empty: none)")
+	fs.BoolVar(&s.CORSCredentials, "cors-credentials", false, "let -cors-origins send cookies (never for *)")
+	SizeVar(fs, &s.MaxBody, "max-body", 1<<20, "cap on request bodies, e.g. 1MiB, answered 413; uploads, imports and avatars have their own (-1 = none)")
+	DurationVar(fs, &s.HSTS, "hsts", 180*24*time.Hour, "Strict-Transport-Security max-age (0 = leave it out)")
+	DurationVar(fs, &s.ReadHeaderTimeout, "read-header-timeout", httpsec.DefaultTimeouts.ReadHeader, "how long a client may take to send a request's headers")
+	DurationVar(fs, &s.ReadTimeout, "read-timeout", httpsec.DefaultTimeouts.Read, "how long a client may take to send a whole request, body included (0 = no limit)")
+	DurationVar(fs, &s.IdleTimeout, "idle-timeout", httpsec.DefaultTimeouts.Idle, "how long a kept-alive connection may wait for its next request")
+	DurationVar(fs, &s.RequestTimeout, "request-timeout", 30*time.Second, "budget of an API request, exports and uploads aside (0 = none)")
+	DurationVar(fs, &s.SlowOp, "slow-op", 100*time.Millisecond, "report service and repository calls slower than this, with stacks (0 = off)")
+	fs.IntVar(&s.TraceKeep, "traces", 100, "keep the stages of this many recent slow or failed requests (0 = off)")
+	DurationVar(fs, &s.TraceSlow, "trace-slow", 500*time.Millisecond, "keep the trace of requests slower than this, as well as of those failing")
+	fs.StringVar(&s.ProfileDir, "profile-dir", "", "save CPU profiles here, taken on demand from the dashboard API or when over -profile-cpu or -profile-latency (empty = off)")
+	fs.Float64Var(&s.ProfileCPU, "profile-cpu", 0.9, "profile when the process uses this share of the CPU over 10s (0 = never)")
+	DurationVar(fs, &s.ProfileLatency, "profile-latency", time.Second, "profile when the p99 of API requests over 10s reaches this (0 = never)")
+	fs.IntVar(&s.ProfileKeep, "profile-keep", 10, "CPU profiles kept in -profile-dir")
+	fs.StringVar(&s.AssetsDir, "assets-dir", "", "read built-in files from this directory first, one subdirectory per tree (admin, templates, locales, geoip, seed), for local development")
+}
+
+// maxServiceTokenTTL bounds -service-token-ttl: a service account's
+// token outlives its revocation by at most this.
+const maxServiceTokenTTL = 24 * time.Hour
+
+// Validate checks s without starting anything: that the values go
+// together and that the files they name exist and parse. Stores and
+// servers are not contacted; that is -check-only's. Every problem is
+// reported, joined.
+func (s *Server) Validate() error {
+	var errs []error
+	bad := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+	check := func(flag string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", flag, err))
+		}
+	}
+
+	// Stores.
+	if !slices.Contains([]string{"memory", "mmap", "kv", "plugin"}, s.Store) {
+		bad("-store %q: want memory, mmap, kv or plugin", s.Store)
+	}
+	if s.Store == "plugin" && s.PluginsPath == "" {
+		bad("-store plugin needs -plugins")
+	}
+	second := func(flag, kind, data string) {
+		switch {
+		case kind == "":
+		case !slices.Contains([]string{"memory", "mmap", "kv"}, kind):
+			bad("-%s %q: want memory, mmap or kv", flag, kind)
+		case kind == s.Store && kind != "memory" && data == s.DataPath:
+			bad("-%s is -store itself: give it its own data path", flag)
+		}
+	}
+	// Deployed, what a demo gets away with loses data or logs everyone
+	// out on a restart.
+	if s.Env == "prod" || s.Env == "staging" {
+		if s.Store == "memory" {
+			bad("-env %s: -store memory keeps no users across a restart", s.Env)
+		}
+		if _, ok := os.LookupEnv("USERS_JWT_SECRET"); !ok && s.KeyStore == "" {
+			bad("-env %s needs $USERS_JWT_SECRET or -key-store: tokens would be signed with a random secret, and stop verifying on a restart", s.Env)
+		}
+	}
+	second("shadow-store", s.ShadowStore, s.ShadowData)
+	second("green-store", s.GreenStore, s.GreenData)
+	if s.ShadowStore != "" && s.GreenStore != "" {
+		bad("-shadow-store and -green-store are two ways of running a second store; pick one")
+	}
+	if s.ShadowReads < 0 || s.ShadowReads > 1 {
+		bad("-shadow-reads %v: want a fraction, 0 to 1", s.ShadowReads)
+	} else if s.ShadowReads > 0 && s.ShadowStore == "" {
+		bad("-shadow-reads needs -shadow-store")
+	}
+	for _, b := range []struct{ flag, spec string }{
+		{"avatar-store", s.AvatarStore}, {"2fa-store", s.TwoFactorStore}, {"oidc-store", s.OIDCStore},
+		{"upload-store", s.UploadStore}, {"key-store", s.KeyStore},
+	} {
+		check(b.flag, blobSpec(b.spec))
+	}
+
+	// The process.
+	if s.Daemon && s.HTTPAddr == "" && !s.CheckOnly {
+		bad("-daemon needs -http: the demo alone exits immediately")
+	}
+	if s.HTTPAddr != "" {
+		_, _, err := net.SplitHostPort(s.HTTPAddr)
+		check("http", err)
+	}
+	if s.StartupAttempts < 1 {
+		bad("-startup-attempts %d: want at least 1", s.StartupAttempts)
+	}
+
+	// Files, parsed as the server will.
+	for _, f := range []struct {
+		flag, path string
+		dir        bool
+	}{
+		{"plugins", s.PluginsPath, false}, {"disposable-domains", s.DisposableDomains, false},
+		{"scripts", s.ScriptsDir, true}, {"backup-dir", s.BackupDir, true}, {"assets-dir", s.AssetsDir, true},
+	} {
+		check(f.flag, exists(f.path, f.dir))
+	}
+	if s.FlagsPath != "" {
+		_, err := featureflag.LoadFile(s.FlagsPath)
+		check("flags", err)
+	}
+	if s.ExperimentsPath != "" {
+		check("experiments", parseFile(s.ExperimentsPath, func(f *os.File) error { _, err := experiment.Load(f); return err }))
+	}
+	if s.GeoDB != "" && s.GeoDB != "test" {
+		_, err := geoip.Open(s.GeoDB)
+		check("geoip-db", err)
+	}
+	if s.PeerKeys != "" {
+		check("peer-keys", parseFile(s.PeerKeys, func(f *os.File) error { _, err := reqsign.LoadKeys(f); return err }))
+	} else if len(s.RequireSigned) > 0 {
+		bad("-require-signed needs -peer-keys")
+	}
+	if s.OIDCProviders != "" {
+		check("oidc-providers", parseFile(s.OIDCProviders, func(f *os.File) error { _, err := oauth.LoadProviders(f); return err }))
+	}
+
+	// Logs.
+	if s.LogFormat != "text" && s.LogFormat != "json" {
+		bad("-log-format %q: want text or json", s.LogFormat)
+	}
+	_, _, err := logfilter.ParseLevels(s.LogLevelSpec, slog.LevelInfo)
+	check("log-levels", err)
+	if s.LogLevelsFile != "" {
+		// A missing file is allowed: it is written later, to be read on
+		// SIGUSR2.
+		if b, err := os.ReadFile(s.LogLevelsFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			check("log-levels-file", err)
+		} else if err == nil {
+			_, _, err := logfilter.ParseLevels(string(b), slog.LevelInfo)
+			check("log-levels-file", err)
+		}
+	}
+	if s.AccessLogPath != "" {
+		_, err := accesslog.ParseFormat(s.AccessLogFormat)
+		check("access-log-format", err)
+		_, err = accesslog.ParseSamples(s.AccessLogSample)
+		check("access-log-sample", err)
+	}
+	if s.LogSampleRate < 0 || s.LogSampleRate > 1 {
+		bad("-log-sample-rate %v: want a fraction, 0 to 1", s.LogSampleRate)
+	}
+	if s.LogMaxFiles < -1 {
+		bad("-log-max-files %d: want -1 (all) or more", s.LogMaxFiles)
+	}
+
+	// Tokens and sessions.
+	if s.AccessTTL <= 0 {
+		bad("-access-ttl %v: want more than 0", s.AccessTTL)
+	}
+	if s.RefreshTTL < s.AccessTTL {
+		bad("-refresh-ttl %v is shorter than -access-ttl %v", s.RefreshTTL, s.AccessTTL)
+	}
+	if s.ServiceTokenTTL <= 0 || s.ServiceTokenTTL > maxServiceTokenTTL {
+		bad("-service-token-ttl %v: want more than 0, at most %v", s.ServiceTokenTTL, maxServiceTokenTTL)
+	}
+	if s.ImpersonationTTL <= 0 {
+		bad("-impersonation-max-ttl %v: want more than 0", s.ImpersonationTTL)
+	}
+	if s.KeyStore == "" {
+		if s.JWTKeyRotate > 0 {
+			bad("-jwt-key-rotate needs -key-store")
+		}
+		if s.FieldKeyRotate > 0 {
+			bad("-field-key-rotate needs -key-store")
+		}
+	} else if longest := max(s.AccessTTL, s.ServiceTokenTTL, s.OIDCSession); s.JWTKeyKeep < longest {
+		bad("-jwt-key-keep %v is shorter than the longest token lasts, %v: tokens signed with a retired key would stop verifying", s.JWTKeyKeep, longest)
+	}
+	if s.AbusePerIP < 0 {
+		bad("-abuse-per-ip %d: want 0 (off) or more", s.AbusePerIP)
+	} else if s.AbusePerIP > 0 && s.AbuseWindow <= 0 {
+		bad("-abuse-window %v: want more than 0", s.AbuseWindow)
+	}
+	if s.LockFailures <= 0 || s.LockIPFailures <= 0 || s.LockFor <= 0 || s.LockHalfLife <= 0 {
+		bad("-lockout-failures, -lockout-ip-failures, -lockout-for and -lockout-half-life: want more than 0")
+	}
+
+	// Alerts and approvals.
+	if s.ApprovalTTL < 0 {
+		bad("-approval-ttl %v: want 0 (off) or more", s.ApprovalTTL)
+	}
+	if len(s.AlertEmail) > 0 {
+		for _, to := range s.AlertEmail {
+			_, err := mail.ParseAddress(to)
+			check("alert-email", err)
+		}
+		_, err = mail.ParseAddress(s.AlertFrom)
+		check("alert-from", err)
+		_, _, err = net.SplitHostPort(s.SMTPAddr)
+		check("smtp", err)
+	}
+
+	// HTTP.
+	for _, o := range s.CORSOrigins {
+		if o == "*" {
+			continue
+		}
+		if u, err := url.Parse(o); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			bad("-cors-origins: %q is not an origin, e.g. https://app.example.com", o)
+		}
+	}
+	if s.MaxBody < -1 {
+		bad("-max-body %d: want -1 (none) or more", s.MaxBody)
+	}
+	if s.ReadHeaderTimeout <= 0 {
+		bad("-read-header-timeout %v: want more than 0", s.ReadHeaderTimeout)
+	}
+	for _, d := range []struct {
+		flag string
+		d    time.Duration
+	}{
+		{"read-timeout", s.ReadTimeout}, {"idle-timeout", s.IdleTimeout}, {"request-timeout", s.RequestTimeout},
+		{"hsts", s.HSTS}, {"slow-op", s.SlowOp}, {"retain-audit", s.RetainAudit}, {"anonymize-inactive", s.AnonymizeAfter},
+	} {
+		if d.d < 0 {
+			bad("-%s %v: want 0 or more", d.flag, d.d)
+		}
+	}
+	if s.ProfileCPU < 0 || s.ProfileCPU > 1 {
+		bad("-profile-cpu %v: want a share, 0 to 1", s.ProfileCPU)
+	}
+	if s.ProfileDir != "" && s.ProfileKeep < 1 {
+		bad("-profile-keep %d: want at least 1", s.ProfileKeep)
+	}
+	return errors.Join(errs...)
+}
+
+// blobSpec checks a blobstore.Open spec without opening it: an S3 one
+// is parsed, and a directory only needs not to be a file, since the
+// store creates it.
+func blobSpec(spec string) error {
+	switch {
+	case spec == "" || spec == "mem:" || spec == "memory":
+		return nil
+	case strings.HasPrefix(spec, "s3://"):
+		_, err := blobstore.Open(spec)
+		return err
+	}
+	if fi, err := os.Stat(strings.TrimPrefix(spec, "file://")); err == nil && !fi.IsDir() {
+		return fmt.Errorf("%s is a file, not a directory", spec)
+	}
+	return nil
+}
+
+func exists(path string, dir bool) error {
+	if path == "" {
+		return nil
+	}
+	fi, err := os.Stat(path)
+	switch {
+	case err != nil:
+		return err
+	case dir && !fi.IsDir():
+		return fmt.Errorf("%s is not a directory", path)
+	case !dir && fi.IsDir():
+		return fmt.Errorf("%s is a directory", path)
+	}
+	return nil
+}
+
+func parseFile(path string, parse func(*os.File) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return parse(f)
+}
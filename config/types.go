@@ -0,0 +1,155 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+-----------------------------------
+TYPED VALUES
+-----------------------------------
+*/
+
+// The flag package's own duration says only "parse error", and it
+// counts bytes in bytes; these say what they want, and Parse adds where
+// the value came from, so a bad value names its flag and its source.
+
+// DurationVar defines a duration flag: 2s, 1h30m.
+func DurationVar(fs *flag.FlagSet, p *time.Duration, name string, value time.Duration, usage string) {
+	*p = value
+	fs.Var((*durationValue)(p), name, usage)
+}
+
+type durationValue time.Duration
+
+func (d *durationValue) Set(s string) error {
+	v, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%q is not a duration: want a number and a unit, e.g. 500ms, 2s or 1h30m", s)
+	}
+	*d = durationValue(v)
+	return nil
+}
+
+func (d *durationValue) String() string { return (*time.Duration)(d).String() }
+
+// SizeVar defines a size flag, in bytes: 1048576, 64MiB, 1.5GB, -1.
+// Units are B, KB, MB, GB and TB, of 1000, and KiB, MiB, GiB and TiB, of
+// 1024, in any case.
+func SizeVar(fs *flag.FlagSet, p *int64, name string, value int64, usage string) {
+	*p = value
+	fs.Var((*sizeValue)(p), name, usage)
+}
+
+type sizeValue int64
+
+var sizeUnits = map[string]float64{
+	"": 1, "b": 1,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+}
+
+func (z *sizeValue) Set(s string) error {
+	t := strings.TrimSpace(s)
+	i := strings.IndexFunc(t, func(r rune) bool { return (r < '0' || r > '9') && r != '.' && r != '-' && r != '+' })
+	if i < 0 {
+		i = len(t)
+	}
+	num, unit := t[:i], strings.ToLower(strings.TrimSpace(t[i:]))
+	mult, ok := sizeUnits[unit]
+	if !ok {
+		return fmt.Errorf("%q: unknown unit %q: want B, KB, MB, GB, TB, KiB, MiB, GiB or TiB", s, t[i:])
+	}
+	// Whole numbers are exact; fractions go through a float.
+	if n, err := strconv.ParseInt(num, 10, 64); err == nil && mult == 1 {
+		*z = sizeValue(n)
+		return nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || num == "" {
+		return fmt.Errorf("%q is not a size: want bytes, or a number and a unit, e.g. 64MiB", s)
+	}
+	v := f * mult
+	if v != math.Trunc(v) {
+		return fmt.Errorf("%q is not a whole number of bytes", s)
+	}
+	if v >= math.MaxInt64 || v <= math.MinInt64 {
+		return fmt.Errorf("%q is too large", s)
+	}
+	*z = sizeValue(v)
+	return nil
+}
+
+// String is in the largest binary unit that divides the size.
+func (z *sizeValue) String() string {
+	n := int64(*z)
+	for _, u := range []struct {
+		name string
+		size int64
+	}{{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}} {
+		if n != 0 && n%u.size == 0 {
+			return strconv.FormatInt(n/u.size, 10) + u.name
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// ListVar defines a comma-separated list flag, each item trimmed; empty
+// is none. A -config file may give it as a JSON array of strings.
+func ListVar(fs *flag.FlagSet, p *[]string, name string, value []string, usage string) {
+	*p = value
+	fs.Var((*listValue)(p), name, usage)
+}
+
+type listValue []string
+
+func (l *listValue) Set(s string) error {
+	if strings.TrimSpace(s) == "" {
+		*l = nil
+		return nil
+	}
+	items := strings.Split(s, ",")
+	for i, item := range items {
+		if items[i] = strings.TrimSpace(item); items[i] == "" {
+			return fmt.Errorf("%q: item %d of %d is empty", s, i+1, len(items))
+		}
+	}
+	*l = items
+	return nil
+}
+
+func (l *listValue) String() string { return strings.Join(*l, ",") }
+
+// URLVar defines a flag holding an http or https URL, or empty for
+// none. Its errors leave the value out, since a URL may carry a token.
+func URLVar(fs *flag.FlagSet, p *string, name string, value string, usage string) {
+	*p = value
+	fs.Var((*urlValue)(p), name, usage)
+}
+
+type urlValue string
+
+func (v *urlValue) Set(s string) error {
+	if s == "" {
+		*v = ""
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return errors.New("not a URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return errors.New("want an http or https URL, e.g. https://hooks.example.com/alerts")
+	}
+	*v = urlValue(s)
+	return nil
+}
+
+func (v *urlValue) String() string { return string(*v) }
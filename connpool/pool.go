@@ -0,0 +1,422 @@
+// Package connpool is a generic connection pool: bounded open connections,
+// a bounded idle set with expiry, health checks on checkout and a FIFO wait
+// queue that respects context cancellation.
+//
+// It is deliberately not tied to net.Conn so the same pool can hold TCP
+// connections, Redis clients or anything else that is expensive to create.
+package connpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClosed        = errors.New("connpool: pool is closed")
+	ErrInvalidConfig = errors.New("connpool: Dial is required")
+)
+
+// Config describes how the pool creates, checks and closes connections.
+type Config[T any] struct {
+	// Dial creates a new connection. Required.
+	Dial func(ctx context.Context) (T, error)
+
+	// Close releases a connection. Optional.
+	Close func(T) error
+
+	// HealthCheck runs on idle connections before they are handed out.
+	// A non-nil error discards the connection and the pool tries again.
+	HealthCheck func(ctx context.Context, conn T) error
+
+	MaxOpen     int           // 0 means unlimited
+	MaxIdle     int           // idle connections kept around; 0 means none
+	IdleTimeout time.Duration // 0 means idle connections never expire
+}
+
+// Stats is a point-in-time snapshot of pool metrics (same idea as sql.DBStats).
+type Stats struct {
+	MaxOpen int
+	Open    int
+	Idle    int
+	InUse   int
+
+	WaitCount    int64
+	WaitDuration time.Duration
+
+	Dials               int64
+	DialErrors          int64
+	HealthCheckFailures int64
+	IdleClosed          int64
+}
+
+// Conn is a checked-out connection. Call Release when done,
+// or Discard if the connection is known to be broken.
+type Conn[T any] struct {
+	Value T
+
+	pool      *Pool[T]
+	createdAt time.Time
+	idleSince time.Time
+	done      bool
+}
+
+// CreatedAt reports when the underlying connection was dialed.
+func (c *Conn[T]) CreatedAt() time.Time { return c.createdAt }
+
+// Release returns the connection to the pool.
+func (c *Conn[T]) Release() {
+	if c.done {
+		return
+	}
+	c.done = true
+	c.pool.put(c)
+}
+
+// Discard closes the connection instead of returning it to the pool.
+func (c *Conn[T]) Discard() {
+	if c.done {
+		return
+	}
+	c.done = true
+	c.pool.discard(c)
+}
+
+// Pool hands out connections of type T.
+type Pool[T any] struct {
+	cfg Config[T]
+
+	mu      sync.Mutex
+	idle    []*Conn[T] // LIFO: most recently used at the end
+	numOpen int
+	waiters []chan *Conn[T] // nil value means "you may dial a new connection"
+	closed  bool
+
+	waitCount    int64
+	waitDuration time.Duration
+	dials        int64
+	dialErrors   int64
+	healthFails  int64
+	idleClosed   int64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func New[T any](cfg Config[T]) (*Pool[T], error) {
+	if cfg.Dial == nil {
+		return nil, ErrInvalidConfig
+	}
+	if cfg.MaxIdle < 0 {
+		cfg.MaxIdle = 0
+	}
+	if cfg.MaxOpen > 0 && cfg.MaxIdle > cfg.MaxOpen {
+		cfg.MaxIdle = cfg.MaxOpen
+	}
+
+	p := &Pool[T]{
+		cfg:  cfg,
+		stop: make(chan struct{}),
+	}
+
+	if cfg.IdleTimeout > 0 {
+		p.wg.Add(1)
+		go p.reaper()
+	}
+	return p, nil
+}
+
+// Get returns an idle connection if a healthy one exists, dials a new one if
+// MaxOpen allows it, and otherwise waits in line until one is released or
+// ctx is done.
+func (p *Pool[T]) Get(ctx context.Context) (*Conn[T], error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrClosed
+		}
+
+		if c := p.popIdleLocked(); c != nil {
+			p.mu.Unlock()
+
+			if err := p.check(ctx, c); err != nil {
+				p.discard(c)
+				continue
+			}
+			c.done = false
+			return c, nil
+		}
+
+		if p.cfg.MaxOpen > 0 && p.numOpen >= p.cfg.MaxOpen {
+			c, err := p.wait(ctx) // unlocks p.mu
+			if err != nil {
+				return nil, err
+			}
+			if c != nil {
+				c.done = false
+				return c, nil
+			}
+			// Got a dial permit: numOpen was already reserved for us.
+			return p.dial(ctx)
+		}
+
+		p.numOpen++
+		p.mu.Unlock()
+		return p.dial(ctx)
+	}
+}
+
+// Stats returns current pool metrics.
+func (p *Pool[T]) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return Stats{
+		MaxOpen:             p.cfg.MaxOpen,
+		Open:                p.numOpen,
+		Idle:                len(p.idle),
+		InUse:               p.numOpen - len(p.idle),
+		WaitCount:           p.waitCount,
+		WaitDuration:        p.waitDuration,
+		Dials:               p.dials,
+		DialErrors:          p.dialErrors,
+		HealthCheckFailures: p.healthFails,
+		IdleClosed:          p.idleClosed,
+	}
+}
+
+// Close closes all idle connections and makes further Get calls fail.
+// Connections still checked out are closed when they are released.
+func (p *Pool[T]) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.numOpen -= len(idle)
+	waiters := p.waiters
+	p.waiters = nil
+	p.mu.Unlock()
+
+	close(p.stop)
+	p.wg.Wait()
+
+	// Waiters wake up, see closed and return ErrClosed.
+	for _, w := range waiters {
+		close(w)
+	}
+
+	var errs []error
+	for _, c := range idle {
+		if err := p.closeConn(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+/*
+-----------------------------------
+INTERNALS
+-----------------------------------
+*/
+
+func (p *Pool[T]) popIdleLocked() *Conn[T] {
+	for len(p.idle) > 0 {
+		n := len(p.idle) - 1
+		c := p.idle[n]
+		p.idle[n] = nil
+		p.idle = p.idle[:n]
+
+		if p.expired(c, time.Now()) {
+			p.numOpen--
+			p.idleClosed++
+			go p.closeConn(c)
+			continue
+		}
+		return c
+	}
+	return nil
+}
+
+// wait queues the caller. Must be called with p.mu held; returns unlocked.
+func (p *Pool[T]) wait(ctx context.Context) (*Conn[T], error) {
+	w := make(chan *Conn[T], 1)
+	p.waiters = append(p.waiters, w)
+	p.waitCount++
+	p.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		p.mu.Lock()
+		p.waitDuration += time.Since(start)
+		p.mu.Unlock()
+	}()
+
+	select {
+	case c, ok := <-w:
+		if !ok {
+			return nil, ErrClosed
+		}
+		return c, nil
+
+	case <-ctx.Done():
+		p.mu.Lock()
+		for i, other := range p.waiters {
+			if other == w {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				p.mu.Unlock()
+				return nil, ctx.Err()
+			}
+		}
+		p.mu.Unlock()
+
+		// Lost the race: someone already handed us a conn or a permit.
+		// Pass it on so it is not leaked.
+		if c, ok := <-w; ok {
+			if c != nil {
+				p.put(c)
+			} else {
+				p.releasePermit()
+			}
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (p *Pool[T]) dial(ctx context.Context) (*Conn[T], error) {
+	v, err := p.cfg.Dial(ctx)
+
+	p.mu.Lock()
+	p.dials++
+	if err != nil {
+		p.dialErrors++
+		p.mu.Unlock()
+		p.releasePermit()
+		return nil, err
+	}
+	p.mu.Unlock()
+
+	return &Conn[T]{Value: v, pool: p, createdAt: time.Now()}, nil
+}
+
+func (p *Pool[T]) check(ctx context.Context, c *Conn[T]) error {
+	if p.cfg.HealthCheck == nil {
+		return nil
+	}
+	if err := p.cfg.HealthCheck(ctx, c.Value); err != nil {
+		p.mu.Lock()
+		p.healthFails++
+		p.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (p *Pool[T]) put(c *Conn[T]) {
+	p.mu.Lock()
+	if p.closed {
+		p.numOpen--
+		p.mu.Unlock()
+		p.closeConn(c)
+		return
+	}
+
+	// Hand off directly to the longest waiter.
+	if len(p.waiters) > 0 {
+		w := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		w <- c
+		p.mu.Unlock()
+		return
+	}
+
+	if len(p.idle) < p.cfg.MaxIdle {
+		c.idleSince = time.Now()
+		p.idle = append(p.idle, c)
+		p.mu.Unlock()
+		return
+	}
+
+	p.numOpen--
+	p.mu.Unlock()
+	p.closeConn(c)
+}
+
+func (p *Pool[T]) discard(c *Conn[T]) {
+	p.closeConn(c)
+	p.releasePermit()
+}
+
+// releasePermit gives up one open slot. If someone is waiting, the slot is
+// transferred to them as a dial permit instead.
+func (p *Pool[T]) releasePermit() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.closed && len(p.waiters) > 0 {
+		w := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		w <- nil
+		return
+	}
+	p.numOpen--
+}
+
+func (p *Pool[T]) closeConn(c *Conn[T]) error {
+	if p.cfg.Close == nil {
+		return nil
+	}
+	return p.cfg.Close(c.Value)
+}
+
+func (p *Pool[T]) expired(c *Conn[T], now time.Time) bool {
+	return p.cfg.IdleTimeout > 0 && now.Sub(c.idleSince) > p.cfg.IdleTimeout
+}
+
+// reaper closes idle connections that outlived IdleTimeout, so an unused
+// pool does not hold sockets open forever.
+func (p *Pool[T]) reaper() {
+	defer p.wg.Done()
+
+	interval := p.cfg.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			p.mu.Lock()
+			var stale []*Conn[T]
+			kept := p.idle[:0]
+			for _, c := range p.idle {
+				if p.expired(c, now) {
+					stale = append(stale, c)
+					continue
+				}
+				kept = append(kept, c)
+			}
+			for i := len(kept); i < len(p.idle); i++ {
+				p.idle[i] = nil
+			}
+			p.idle = kept
+			p.numOpen -= len(stale)
+			p.idleClosed += int64(len(stale))
+			p.mu.Unlock()
+
+			for _, c := range stale {
+				p.closeConn(c)
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// TCPDialer returns a Dial func for plain TCP connections to addr.
+func TCPDialer(addr string, timeout time.Duration) func(ctx context.Context) (net.Conn, error) {
+	d := net.Dialer{Timeout: timeout}
+	return func(ctx context.Context) (net.Conn, error) {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+}
+
+// TCPHealthCheck detects connections the peer has already closed.
+//
+// It does a 1ms read: a timeout means the socket is alive and simply has
+// nothing to say, EOF or any other error means it is dead. Protocols where
+// the server may push unsolicited bytes should use their own ping instead,
+// because a successful read here consumes data.
+func TCPHealthCheck(_ context.Context, c net.Conn) error {
+	if err := c.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return err
+	}
+	defer c.SetReadDeadline(time.Time{})
+
+	var buf [1]byte
+	_, err := c.Read(buf[:])
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return nil
+	case err == nil:
+		return errors.New("connpool: unexpected data on idle connection")
+	case errors.Is(err, io.EOF):
+		return io.ErrUnexpectedEOF
+	default:
+		return err
+	}
+}
+
+// NewTCP builds a pool of TCP connections with the dialer and health check
+// above filled in. Other Config fields are taken from cfg.
+func NewTCP(addr string, cfg Config[net.Conn]) (*Pool[net.Conn], error) {
+	if cfg.Dial == nil {
+		cfg.Dial = TCPDialer(addr, 5*time.Second)
+	}
+	if cfg.HealthCheck == nil {
+		cfg.HealthCheck = TCPHealthCheck
+	}
+	if cfg.Close == nil {
+		cfg.Close = func(c net.Conn) error { return c.Close() }
+	}
+	return New(cfg)
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package cpuprof
+
+import "time"
+
+// processCPU is not measured outside unix; the CPU trigger never fires
+// and only latency and Capture take profiles.
+func processCPU() (time.Duration, bool) { return 0, false }
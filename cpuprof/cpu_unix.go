@@ -0,0 +1,17 @@
+//go:build unix
+
+package cpuprof
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPU is the user and system time the process has used.
+func processCPU() (time.Duration, bool) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, false
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano()), true
+}
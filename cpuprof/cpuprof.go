@@ -0,0 +1,329 @@
+// Package cpuprof captures CPU profiles when the process is in trouble,
+// so there is one to look at for the incident that happened at 3 a.m.
+// instead of a plan to catch the next one.
+//
+// A Profiler watches two signals every Interval: the share of the CPU
+// the process used (its user and system time over GOMAXPROCS cores' worth
+// of wall time; unix only) and the 99th percentile of the latencies
+// Observe was told about. When either crosses its threshold it profiles
+// for Duration and saves the result in Dir as
+// cpu-20060102T150405Z-<reason>.pprof, keeping the last Keep. Cooldown
+// spaces automatic profiles out: a sustained overload is worth one
+// profile, not one per Interval. Capture takes one on demand.
+//
+// Profiling costs a few percent of CPU while it runs and nothing
+// otherwise. The runtime allows one CPU profile at a time, so a capture
+// fails with ErrBusy while another runs, this package's or net/http/pprof's.
+package cpuprof
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Go-Internals/histogram"
+)
+
+// ErrBusy is returned by Capture while a profile is already running.
+var ErrBusy = errors.New("cpuprof: a CPU profile is already running")
+
+// Options configures New.
+type Options struct {
+	// Dir is where profiles are saved; created if missing.
+	Dir string
+	// Keep is how many profiles are kept; default 10.
+	Keep int
+	// Duration is how long an automatic profile runs; default 30s.
+	Duration time.Duration
+	// Interval is how often the signals are checked; default 10s.
+	Interval time.Duration
+	// Cooldown is the least time between automatic profiles; default
+	// 10m.
+	Cooldown time.Duration
+	// CPU is the share of the CPU, 0 to 1, from which a profile is
+	// taken; 0 never takes one for CPU.
+	CPU float64
+	// Latency is the p99 from which a profile is taken; 0 never takes
+	// one for latency.
+	Latency time.Duration
+	// MinSamples is how many latencies an interval needs for its p99 to
+	// count; default 20.
+	MinSamples int
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Profile is one saved profile.
+type Profile struct {
+	Name     string        `json:"name"`
+	Reason   string        `json:"reason"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Size     int64         `json:"size"`
+}
+
+// Profiler is safe for concurrent use. A nil *Profiler ignores Observe.
+type Profiler struct {
+	opts Options
+	log  *slog.Logger
+
+	// latencies is the current interval's; Run swaps it for a fresh
+	// one every check.
+	latencies atomic.Pointer[histogram.Histogram]
+
+	running sync.Mutex // held while profiling
+	// Run's goroutine only.
+	lastAuto time.Time
+	prevCPU  time.Duration
+	prevAt   time.Time
+
+	captured  atomic.Int64
+	automatic atomic.Int64
+	failed    atomic.Int64
+}
+
+func New(opts Options) (*Profiler, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("cpuprof: no directory")
+	}
+	if opts.Keep <= 0 {
+		opts.Keep = 10
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = 30 * time.Second
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 10 * time.Minute
+	}
+	if opts.MinSamples <= 0 {
+		opts.MinSamples = 20
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cpuprof: %w", err)
+	}
+	p := &Profiler{opts: opts, log: opts.Logger}
+	if p.log == nil {
+		p.log = slog.Default()
+	}
+	p.latencies.Store(histogram.New())
+	p.prevCPU, _ = processCPU()
+	p.prevAt = time.Now()
+	return p, nil
+}
+
+// Observe records one latency for the p99 trigger.
+func (p *Profiler) Observe(d time.Duration) {
+	if p == nil {
+		return
+	}
+	p.latencies.Load().RecordDuration(d)
+}
+
+// Run checks the signals every Interval until ctx is done.
+func (p *Profiler) Run(ctx context.Context) error {
+	t := time.NewTicker(p.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		if reason, attrs := p.check(); reason != "" && time.Since(p.lastAuto) >= p.opts.Cooldown {
+			p.log.Warn("cpuprof: "+reason+" over threshold, profiling", attrs...)
+			p.lastAuto = time.Now()
+			p.automatic.Add(1)
+			// Errors are logged and counted by capture.
+			_, _ = p.Capture(ctx, reason, p.opts.Duration)
+			// What happened while profiling is not the next interval's.
+			p.check()
+		}
+	}
+}
+
+// check reads both signals since the last check and names the one that
+// crossed its threshold, "" if none did, with what it read for the log.
+func (p *Profiler) check() (string, []any) {
+	now := time.Now()
+	used, haveCPU := processCPU()
+	var cpu float64
+	if wall := now.Sub(p.prevAt); wall > 0 {
+		cpu = float64(used-p.prevCPU) / float64(wall) / float64(runtime.GOMAXPROCS(0))
+	}
+	p.prevCPU, p.prevAt = used, now
+	h := p.latencies.Swap(histogram.New())
+
+	switch {
+	case haveCPU && p.opts.CPU > 0 && cpu >= p.opts.CPU:
+		return "cpu", []any{"cpu", fmt.Sprintf("%.0f%%", cpu*100), "threshold", fmt.Sprintf("%.0f%%", p.opts.CPU*100)}
+	case p.opts.Latency > 0 && h.Count() >= uint64(p.opts.MinSamples):
+		if p99 := h.QuantileDuration(0.99); p99 >= p.opts.Latency {
+			return "latency", []any{"p99", p99, "threshold", p.opts.Latency, "requests", h.Count()}
+		}
+	}
+	return "", nil
+}
+
+// Capture profiles for d, or until ctx is done, and saves the profile.
+// reason goes in its name, so it should be a short word.
+func (p *Profiler) Capture(ctx context.Context, reason string, d time.Duration) (Profile, error) {
+	if !p.running.TryLock() {
+		return Profile{}, ErrBusy
+	}
+	defer p.running.Unlock()
+
+	start := time.Now().UTC()
+	name := "cpu-" + start.Format("20060102T150405Z") + "-" + sanitize(reason) + ".pprof"
+	prof, err := p.capture(ctx, name, d)
+	if err != nil {
+		p.failed.Add(1)
+		p.log.Error("cpuprof: profile failed", "reason", reason, "err", err)
+		return Profile{}, err
+	}
+	prof.Reason, prof.Start = reason, start
+	p.captured.Add(1)
+	p.log.Info("cpuprof: profile saved", "name", prof.Name, "reason", reason, "duration", prof.Duration.Round(time.Millisecond))
+	p.prune()
+	return prof, nil
+}
+
+func (p *Profiler) capture(ctx context.Context, name string, d time.Duration) (Profile, error) {
+	path := filepath.Join(p.opts.Dir, name)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return Profile{}, err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly after the rename
+
+	start := time.Now()
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		// Another profile, most likely net/http/pprof's.
+		return Profile{}, fmt.Errorf("%w: %v", ErrBusy, err)
+	}
+	t := time.NewTimer(d)
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		t.Stop()
+	}
+	pprof.StopCPUProfile()
+	took := time.Since(start)
+
+	fi, err := f.Stat()
+	if err := errors.Join(err, f.Close()); err != nil {
+		return Profile{}, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return Profile{}, err
+	}
+	return Profile{Name: name, Duration: took, Size: fi.Size()}, nil
+}
+
+// sanitize keeps a reason usable in a file name.
+func sanitize(reason string) string {
+	s := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return '-'
+	}, reason)
+	if s == "" {
+		return "manual"
+	}
+	return s[:min(len(s), 32)]
+}
+
+// prune removes the oldest profiles beyond Keep.
+func (p *Profiler) prune() {
+	all := p.List()
+	for _, prof := range all[min(len(all), p.opts.Keep):] {
+		if err := os.Remove(filepath.Join(p.opts.Dir, prof.Name)); err != nil {
+			p.log.Warn("cpuprof: removing old profile", "name", prof.Name, "err", err)
+		}
+	}
+}
+
+// List returns the saved profiles, newest first.
+func (p *Profiler) List() []Profile {
+	if p == nil {
+		return nil
+	}
+	entries, err := os.ReadDir(p.opts.Dir)
+	if err != nil {
+		return nil
+	}
+	var out []Profile
+	for _, e := range entries {
+		prof, ok := parseName(e.Name())
+		if !ok {
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			prof.Size = fi.Size()
+		}
+		out = append(out, prof)
+	}
+	slices.SortFunc(out, func(a, b Profile) int { return b.Start.Compare(a.Start) })
+	return out
+}
+
+// parseName reads "cpu-20060102T150405Z-latency.pprof". Duration is not
+// in the name and stays 0.
+func parseName(name string) (Profile, bool) {
+	rest, ok := strings.CutPrefix(name, "cpu-")
+	if !ok {
+		return Profile{}, false
+	}
+	rest, ok = strings.CutSuffix(rest, ".pprof")
+	if !ok {
+		return Profile{}, false
+	}
+	stamp, reason, ok := strings.Cut(rest, "-")
+	if !ok {
+		return Profile{}, false
+	}
+	start, err := time.Parse("20060102T150405Z", stamp)
+	if err != nil {
+		return Profile{}, false
+	}
+	return Profile{Name: name, Reason: reason, Start: start}, true
+}
+
+// Open opens a saved profile by name.
+func (p *Profiler) Open(name string) (*os.File, error) {
+	if _, ok := parseName(name); !ok || filepath.Base(name) != name {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(filepath.Join(p.opts.Dir, name))
+}
+
+// Stats counts profiles taken, on demand and automatically, and failures.
+type Stats struct {
+	Captured  int64 `json:"captured"`
+	Automatic int64 `json:"automatic"`
+	Failed    int64 `json:"failed"`
+}
+
+func (p *Profiler) Stats() Stats {
+	if p == nil {
+		return Stats{}
+	}
+	return Stats{Captured: p.captured.Load(), Automatic: p.automatic.Load(), Failed: p.failed.Load()}
+}
@@ -0,0 +1,164 @@
+// Package crashreport turns panics into crash files instead of silent
+// process death or a one-line log.
+//
+// A crash file is plain text with everything needed to debug a panic after
+// the fact: the panic value, the panicking goroutine's stack, the most
+// recent log lines, a snapshot of the effective configuration and a dump
+// of every goroutine. Recovering keeps the rest of the process serving;
+// whether that is safe depends on the caller, so each entry point (Recover,
+// Go, Middleware) documents what survives.
+package crashreport
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"Go-Internals/buildinfo"
+)
+
+// Options configures a Reporter.
+type Options struct {
+	Dir string // where crash files go; default os.TempDir()
+
+	// Logs, if set, supplies recent log lines (see LogRing).
+	Logs interface{ Lines() []string }
+	// Config, if set, returns the configuration to snapshot. It is
+	// marshalled as JSON; redact secrets before returning them.
+	Config func() any
+	// OnCrash, if set, is called after a crash file is written (for
+	// alerting); path is empty if writing failed.
+	OnCrash func(where string, value any, path string)
+}
+
+// Reporter writes crash files. Safe for concurrent use.
+type Reporter struct {
+	opts    Options
+	crashes atomic.Int64
+}
+
+func New(opts Options) *Reporter {
+	opts.Dir = cmp.Or(opts.Dir, os.TempDir())
+	return &Reporter{opts: opts}
+}
+
+// Crashes is how many panics have been reported.
+func (r *Reporter) Crashes() int64 { return r.crashes.Load() }
+
+// Recover must be deferred directly:
+//
+//	defer rep.Recover("worker")
+//
+// It stops the panic, writes a crash file and lets the function return
+// normally (with zero results). Use it where the caller can carry on.
+func (r *Reporter) Recover(where string) {
+	if v := recover(); v != nil {
+		r.Capture(where, v, debug.Stack())
+	}
+}
+
+// Go runs fn in a goroutine that reports panics instead of crashing the
+// process. The goroutine is not restarted.
+func (r *Reporter) Go(where string, fn func()) {
+	go func() {
+		defer r.Recover(where)
+		fn()
+	}()
+}
+
+// Middleware reports handler panics and answers 500. http.ErrAbortHandler
+// is re-panicked: it is net/http's way of aborting a response, not a bug.
+func (r *Reporter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			r.Capture(req.Method+" "+req.URL.Path, v, debug.Stack())
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Capture writes a crash file for an already recovered value and returns
+// its path.
+func (r *Reporter) Capture(where string, value any, stack []byte) string {
+	n := r.crashes.Add(1)
+	name := fmt.Sprintf("crash-%s-%d-%d.txt", time.Now().UTC().Format("20060102T150405"), os.Getpid(), n)
+	path := filepath.Join(r.opts.Dir, name)
+
+	if err := os.WriteFile(path, r.render(where, value, stack), 0o600); err != nil {
+		log.Printf("crashreport: panic in %s: %v (writing crash file failed: %v)", where, value, err)
+		path = ""
+	} else {
+		log.Printf("crashreport: panic in %s: %v (details in %s)", where, value, path)
+	}
+	if r.opts.OnCrash != nil {
+		r.opts.OnCrash(where, value, path)
+	}
+	return path
+}
+
+func (r *Reporter) render(where string, value any, stack []byte) []byte {
+	var b strings.Builder
+	section := func(title string) { fmt.Fprintf(&b, "\n=== %s ===\n", title) }
+
+	fmt.Fprintf(&b, "panic: %v\n", value)
+	fmt.Fprintf(&b, "where: %s\n", where)
+	fmt.Fprintf(&b, "time:  %s\n", time.Now().Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "pid:   %d\n", os.Getpid())
+	info := buildinfo.Get()
+	fmt.Fprintf(&b, "build: %s %s\n", info.Module, info)
+
+	section("stack")
+	b.Write(stack)
+
+	if r.opts.Logs != nil {
+		section("recent logs")
+		for _, l := range r.opts.Logs.Lines() {
+			b.WriteString(l)
+			b.WriteByte('\n')
+		}
+	}
+
+	if r.opts.Config != nil {
+		section("config")
+		cfg, err := json.MarshalIndent(r.opts.Config(), "", "  ")
+		if err != nil {
+			fmt.Fprintf(&b, "(config not serializable: %v)\n", err)
+		} else {
+			b.Write(cfg)
+			b.WriteByte('\n')
+		}
+	}
+
+	section("goroutines")
+	b.Write(allStacks())
+	return []byte(b.String())
+}
+
+// allStacks grows the buffer until runtime.Stack fits.
+func allStacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
@@ -0,0 +1,62 @@
+package crashreport
+
+import (
+	"bytes"
+	"sync"
+)
+
+// LogRing is an io.Writer that keeps the last N lines written to it. Tee
+// the process log into it so crash files show what led up to a panic:
+//
+//	log.SetOutput(io.MultiWriter(os.Stderr, ring))
+type LogRing struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	partial []byte // unterminated tail of the last write
+}
+
+func NewLogRing(lines int) *LogRing {
+	if lines <= 0 {
+		lines = 200
+	}
+	return &LogRing{lines: make([]string, lines)}
+}
+
+func (r *LogRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data := p
+	if len(r.partial) > 0 {
+		data = append(r.partial, p...)
+		r.partial = nil
+	}
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		r.lines[r.next] = string(data[:i])
+		r.next = (r.next + 1) % len(r.lines)
+		if r.next == 0 {
+			r.full = true
+		}
+		data = data[i+1:]
+	}
+	if len(data) > 0 {
+		r.partial = append([]byte(nil), data...)
+	}
+	return len(p), nil
+}
+
+// Lines returns the kept lines, oldest first.
+func (r *LogRing) Lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	return append(append([]string(nil), r.lines[r.next:]...), r.lines[:r.next]...)
+}
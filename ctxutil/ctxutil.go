@@ -0,0 +1,100 @@
+// Package ctxutil reads what a request context carries, in one place and
+// with one type each: the caller, the tenant, the request ID and the
+// logger to use. The values are still set by the packages that own them
+// (auth.Authenticate, tenant.With, reqid.Middleware); this is the reading
+// side, for code that would otherwise import all three and know which
+// returns a pair.
+//
+// It also has the two helpers for time limits that request code keeps
+// writing by hand: MustDeadline, for code that must not run unbounded,
+// and Detach, for work that outlives the request that started it.
+package ctxutil
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"Go-Internals/auth"
+	"Go-Internals/reqid"
+	"Go-Internals/tenant"
+)
+
+// UserID is the authenticated caller's subject, "" for an anonymous one.
+func UserID(ctx context.Context) string {
+	c, _ := auth.PrincipalFrom(ctx)
+	return c.Subject
+}
+
+// Impersonator is the admin acting as the caller, "" unless the request
+// is made with an impersonation token.
+func Impersonator(ctx context.Context) string {
+	c, _ := auth.PrincipalFrom(ctx)
+	return c.Impersonator()
+}
+
+// TenantID is the request's tenant, tenant.Default if it names none.
+func TenantID(ctx context.Context) string {
+	return tenant.From(ctx)
+}
+
+// RequestID is the request's ID, "" outside a request.
+func RequestID(ctx context.Context) string {
+	return reqid.From(ctx)
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context whose Logger is l.
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// Logger returns the logger set by WithLogger, or slog.Default(), with
+// the request ID, a tenant other than the default and the user added as
+// attributes when ctx has them, so that a line logged deep in a call can
+// be matched to its request.
+func Logger(ctx context.Context) *slog.Logger {
+	l, _ := ctx.Value(loggerKey{}).(*slog.Logger)
+	if l == nil {
+		l = slog.Default()
+	}
+	var attrs []any
+	if id := RequestID(ctx); id != "" {
+		attrs = append(attrs, "request_id", id)
+	}
+	if t := TenantID(ctx); t != tenant.Default {
+		attrs = append(attrs, "tenant", t)
+	}
+	if u := UserID(ctx); u != "" {
+		attrs = append(attrs, "user", u)
+	}
+	if a := Impersonator(ctx); a != "" {
+		attrs = append(attrs, "impersonator", a)
+	}
+	if len(attrs) == 0 {
+		return l
+	}
+	return l.With(attrs...)
+}
+
+// MustDeadline returns ctx's deadline and panics if it has none: it is
+// for code that has to be bounded, whose callers all set one (every API
+// request runs under httpapi's Config.Timeout), so that a new caller
+// without one fails its first run instead of hanging one day.
+func MustDeadline(ctx context.Context) time.Time {
+	d, ok := ctx.Deadline()
+	if !ok {
+		panic("ctxutil: context has no deadline")
+	}
+	return d
+}
+
+// Detach returns a context for work a request hands off: it keeps ctx's
+// values (the request ID, tenant and caller, for the logs and the audit
+// trail) but not its cancellation or deadline, as context.WithoutCancel,
+// so the request ending does not cut the work short. Work that can hang
+// wants a timeout of its own on top.
+func Detach(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
+}
@@ -0,0 +1,262 @@
+// Package datamove migrates users from one backend to another.
+//
+// A migration has three parts. Migrate copies every record, with its ID
+// and timestamps, in batches, reporting progress after each. Verify then
+// compares the two stores by count and by a checksum over every record,
+// and names the records that differ. Between the copy and the cutover
+// the service keeps writing, so DualWrite mirrors every write to the new
+// store while the old one still serves; once Verify is clean the new
+// store can become the primary.
+//
+// Migrate is idempotent: records already copied unchanged are skipped,
+// changed ones are replaced, so an interrupted or repeated run converges
+// instead of failing on the first existing ID.
+//
+// Only the backends in this tree exist (memory, mmap, kv); a SQL backend
+// would be one more Target.
+package datamove
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"Go-Internals/clock"
+	"Go-Internals/users"
+)
+
+// Target is a backend that can take records with their own IDs.
+type Target interface {
+	users.UserRepository
+	users.Restorer
+}
+
+// Options configures Migrate.
+type Options struct {
+	// BatchSize is how many records are copied between progress reports
+	// and cancellation checks; default 500.
+	BatchSize int
+	// Progress, if set, is called after every batch and once at the end.
+	Progress func(Progress)
+	// Prune deletes records the target has and the source does not, so a
+	// rerun after deletions in the source still converges.
+	Prune bool
+	Clock clock.Clock
+}
+
+// Progress is a migration's running totals.
+type Progress struct {
+	Read      int           `json:"read"`
+	Copied    int           `json:"copied"`    // new in the target
+	Replaced  int           `json:"replaced"`  // present but different
+	Unchanged int           `json:"unchanged"` // present and identical
+	Pruned    int           `json:"pruned"`
+	Batches   int           `json:"batches"`
+	Elapsed   time.Duration `json:"elapsed"`
+}
+
+// Rate is records read per second.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Read) / p.Elapsed.Seconds()
+}
+
+// Migrate copies every record of src into dst. It stops at the first
+// record it cannot write; rerunning it resumes, since what was already
+// copied is skipped.
+func Migrate(ctx context.Context, src users.UserRepository, dst Target, opts Options) (Progress, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	clk := clock.OrReal(opts.Clock)
+	start := clk.Now()
+	var p Progress
+	report := func() {
+		p.Elapsed = clk.Now().Sub(start)
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+	}
+
+	var seen map[int]struct{}
+	if opts.Prune {
+		seen = make(map[int]struct{})
+	}
+	batch := make([]users.User, 0, opts.BatchSize)
+	flush := func() error {
+		for _, u := range batch {
+			if err := copyOne(dst, u, &p); err != nil {
+				return fmt.Errorf("datamove: user %d: %w", u.ID, err)
+			}
+		}
+		batch = batch[:0]
+		p.Batches++
+		report()
+		return ctx.Err()
+	}
+	for u, err := range src.Iterate(ctx, users.IterateOptions{}) {
+		if err != nil {
+			return p, err
+		}
+		p.Read++
+		if seen != nil {
+			seen[u.ID] = struct{}{}
+		}
+		if batch = append(batch, u); len(batch) == opts.BatchSize {
+			if err := flush(); err != nil {
+				return p, err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return p, err
+		}
+	}
+
+	if opts.Prune {
+		// Collected first: deleting while iterating is not safe on every
+		// backend.
+		var extra []int
+		for u, err := range dst.Iterate(ctx, users.IterateOptions{}) {
+			if err != nil {
+				return p, err
+			}
+			if _, ok := seen[u.ID]; !ok {
+				extra = append(extra, u.ID)
+			}
+		}
+		for _, id := range extra {
+			if err := dst.Delete(id); err != nil && !errors.Is(err, users.ErrUserNotFound) {
+				return p, fmt.Errorf("datamove: prune user %d: %w", id, err)
+			}
+			p.Pruned++
+		}
+	}
+	report()
+	return p, nil
+}
+
+// copyOne restores u, or replaces the target's record if it differs.
+// Replacing is delete plus restore, not Update: backends keep their own
+// CreatedAt on Update, and the copy has to be exact.
+func copyOne(dst Target, u users.User, p *Progress) error {
+	err := dst.Restore(u)
+	if err == nil {
+		p.Copied++
+		return nil
+	}
+	if !errors.Is(err, users.ErrUserExists) {
+		return err
+	}
+	cur, err := dst.GetByID(u.ID)
+	if err != nil {
+		return err
+	}
+	if Digest(cur) == Digest(u) {
+		p.Unchanged++
+		return nil
+	}
+	if err := dst.Delete(u.ID); err != nil {
+		return err
+	}
+	if err := dst.Restore(u); err != nil {
+		return err
+	}
+	p.Replaced++
+	return nil
+}
+
+/*
+-----------------------------------
+VERIFICATION
+-----------------------------------
+*/
+
+// Digest identifies a record's content. Times are compared as instants,
+// since backends hand them back in different locations.
+func Digest(u users.User) uint64 {
+	h := fnv.New64a()
+	var expires int64
+	if !u.ExpiresAt.IsZero() {
+		expires = u.ExpiresAt.UnixNano()
+	}
+	fmt.Fprintf(h, "%d\x00%s\x00%s\x00%d\x00%d\x00%s", u.ID, u.Name, u.Email, u.CreatedAt.UnixNano(), expires, u.AvatarURL)
+	return h.Sum64()
+}
+
+// Summary is one store's side of a Check.
+type Summary struct {
+	Count int `json:"count"`
+	// Checksum is the sum of every record's Digest: order does not matter,
+	// so stores that iterate differently still agree.
+	Checksum uint64 `json:"checksum"`
+}
+
+// maxSamples bounds Check.Samples.
+const maxSamples = 20
+
+// Check is the result of Verify.
+type Check struct {
+	Source  Summary  `json:"source"`
+	Target  Summary  `json:"target"`
+	Missing int      `json:"missing"` // in the source only
+	Extra   int      `json:"extra"`   // in the target only
+	Differ  int      `json:"differ"`  // in both, with different content
+	Samples []string `json:"samples,omitempty"`
+}
+
+// OK reports whether the stores hold the same records.
+func (c Check) OK() bool {
+	return c.Source == c.Target && c.Missing == 0 && c.Extra == 0 && c.Differ == 0
+}
+
+func (c *Check) sample(what string, id int) {
+	if len(c.Samples) < maxSamples {
+		c.Samples = append(c.Samples, what+" "+strconv.Itoa(id))
+	}
+}
+
+// Verify compares src and dst. It keeps one digest per source record in
+// memory, not the records.
+func Verify(ctx context.Context, src, dst users.UserRepository) (Check, error) {
+	var c Check
+	want := make(map[int]uint64)
+	for u, err := range src.Iterate(ctx, users.IterateOptions{}) {
+		if err != nil {
+			return c, err
+		}
+		d := Digest(u)
+		want[u.ID] = d
+		c.Source.Count++
+		c.Source.Checksum += d
+	}
+	for u, err := range dst.Iterate(ctx, users.IterateOptions{}) {
+		if err != nil {
+			return c, err
+		}
+		d := Digest(u)
+		c.Target.Count++
+		c.Target.Checksum += d
+		w, ok := want[u.ID]
+		switch {
+		case !ok:
+			c.Extra++
+			c.sample("extra", u.ID)
+		case w != d:
+			c.Differ++
+			c.sample("differs", u.ID)
+		}
+		delete(want, u.ID)
+	}
+	for id := range want {
+		c.Missing++
+		c.sample("missing", id)
+	}
+	return c, nil
+}
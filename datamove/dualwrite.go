@@ -0,0 +1,105 @@
+package datamove
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+DUAL WRITE
+-----------------------------------
+*/
+
+// DualWriteRepo serves everything from the primary and mirrors every
+// successful write to the shadow, the store being migrated to. The
+// primary stays authoritative: a shadow failure is logged and counted,
+// never returned, so the cutover cannot break the service. Verify (or a
+// later Migrate) repairs whatever the shadow missed.
+//
+// Writes are serialized so the shadow applies them in the primary's
+// order; two concurrent updates to one user could otherwise land in
+// opposite orders. That costs write concurrency for the length of the
+// cutover. Reads are not affected.
+type DualWriteRepo struct {
+	users.UserRepository
+	shadow Target
+	log    *slog.Logger
+
+	mu     sync.Mutex
+	writes int64
+	failed int64
+}
+
+// DualWrite wraps primary. logger defaults to slog.Default().
+func DualWrite(primary users.UserRepository, shadow Target, logger *slog.Logger) *DualWriteRepo {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &DualWriteRepo{UserRepository: primary, shadow: shadow, log: logger}
+}
+
+// Create assigns the ID in the primary and restores the record under the
+// same ID in the shadow.
+func (r *DualWriteRepo) Create(u users.User) (users.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, err := r.UserRepository.Create(u)
+	if err == nil {
+		r.mirror("create", u.ID, r.shadow.Restore(u))
+	}
+	return u, err
+}
+
+// Update mirrors the record as the primary now holds it; a record the
+// shadow lacks is restored.
+func (r *DualWriteRepo) Update(u users.User) (users.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, err := r.UserRepository.Update(u)
+	if err == nil {
+		_, serr := r.shadow.Update(u)
+		if errors.Is(serr, users.ErrUserNotFound) {
+			serr = r.shadow.Restore(u)
+		}
+		r.mirror("update", u.ID, serr)
+	}
+	return u, err
+}
+
+func (r *DualWriteRepo) Delete(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.UserRepository.Delete(id)
+	if err == nil {
+		serr := r.shadow.Delete(id)
+		if errors.Is(serr, users.ErrUserNotFound) {
+			serr = nil
+		}
+		r.mirror("delete", id, serr)
+	}
+	return err
+}
+
+func (r *DualWriteRepo) mirror(op string, id int, err error) {
+	r.writes++
+	if err != nil {
+		r.failed++
+		r.log.Warn("shadow write failed", "op", op, "user", id, "err", err)
+	}
+}
+
+// DualWriteStats counts mirrored writes.
+type DualWriteStats struct {
+	Writes         int64 `json:"writes"`
+	ShadowFailures int64 `json:"shadow_failures"`
+}
+
+func (r *DualWriteRepo) Stats() DualWriteStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return DualWriteStats{Writes: r.writes, ShadowFailures: r.failed}
+}
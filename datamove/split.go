@@ -0,0 +1,310 @@
+package datamove
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+
+	"Go-Internals/featureflag"
+	"Go-Internals/query"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+BLUE/GREEN
+-----------------------------------
+*/
+
+// Default flag names for SplitOptions.
+const (
+	GreenReadsFlag  = "repo_green_reads"
+	GreenWritesFlag = "repo_green_writes"
+)
+
+// SplitOptions configures Split.
+type SplitOptions struct {
+	// Flags holds the two flags; their rollout is the share green gets.
+	// A flag that is off or missing sends everything to blue, so an
+	// empty set is DualWrite, and rolling back is turning a flag off.
+	Flags *featureflag.Set
+	// ReadFlag and WriteFlag name them; default GreenReadsFlag and
+	// GreenWritesFlag. Reads and writes of one user always go to the
+	// same side for a given rollout; lists and creates, which have no
+	// user yet, are drawn at random.
+	ReadFlag  string
+	WriteFlag string
+	// Logger receives one warning per failed mirror write. Default
+	// slog.Default().
+	Logger *slog.Logger
+}
+
+// SplitRepo runs two backends side by side for a migration: blue, the
+// one in service, and green, the one being moved to. Feature flags
+// decide which serves each read and takes each write first; rolling a
+// flag from 0 to 100 shifts the traffic over gradually, and Stats
+// compares the two sides' error rates on the share each one served.
+//
+// Every write goes to both, so either side can serve any read and the
+// rollout can move either way: the side the flag picks is authoritative
+// (its answer and its error are returned, and the record as it stored
+// it is what the other gets) and the other is mirrored, as DualWrite
+// does, its failures logged and counted, never returned. Writes are
+// serialized, which also keeps both sides assigning the same next ID.
+// Copy the records over first (Migrate) for reads to find them on
+// green.
+type SplitRepo struct {
+	blue, green Target
+	opts        SplitOptions
+
+	mu sync.Mutex // serializes writes
+
+	statsMu sync.Mutex
+	stats   [2]SideStats
+}
+
+// SideStats counts what one side served. Errors are failures of the
+// store: not found, a taken email and invalid input are answers.
+type SideStats struct {
+	Reads       int64 `json:"reads"`
+	ReadErrors  int64 `json:"read_errors"`
+	Writes      int64 `json:"writes"`
+	WriteErrors int64 `json:"write_errors"`
+	// Mirrored counts the writes it took second, MirrorFailures those
+	// it failed.
+	Mirrored       int64 `json:"mirrored"`
+	MirrorFailures int64 `json:"mirror_failures"`
+}
+
+// ErrorRate is the fraction of the reads and writes it served that
+// failed.
+func (s SideStats) ErrorRate() float64 {
+	if n := s.Reads + s.Writes; n > 0 {
+		return float64(s.ReadErrors+s.WriteErrors) / float64(n)
+	}
+	return 0
+}
+
+// SplitStats are both sides', with the rollouts they were served under
+// and their error rates, to compare before the cutover.
+type SplitStats struct {
+	GreenReads     float64   `json:"green_reads"`
+	GreenWrites    float64   `json:"green_writes"`
+	Blue           SideStats `json:"blue"`
+	Green          SideStats `json:"green"`
+	BlueErrorRate  float64   `json:"blue_error_rate"`
+	GreenErrorRate float64   `json:"green_error_rate"`
+}
+
+const (
+	blue = iota
+	green
+)
+
+// Split wraps blue and green.
+func Split(blueRepo, greenRepo Target, opts SplitOptions) *SplitRepo {
+	if opts.Flags == nil {
+		opts.Flags = featureflag.NewSet(nil)
+	}
+	if opts.ReadFlag == "" {
+		opts.ReadFlag = GreenReadsFlag
+	}
+	if opts.WriteFlag == "" {
+		opts.WriteFlag = GreenWritesFlag
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &SplitRepo{blue: blueRepo, green: greenRepo, opts: opts}
+}
+
+// pick is the side flag sends key to; key < 0 draws one at random.
+func (r *SplitRepo) pick(flag string, key int) int {
+	if key < 0 {
+		key = rand.IntN(1 << 30)
+	}
+	if r.opts.Flags.Enabled(flag, key) {
+		return green
+	}
+	return blue
+}
+
+func (r *SplitRepo) side(s int) Target {
+	if s == green {
+		return r.green
+	}
+	return r.blue
+}
+
+// failed reports whether err is the store's failure rather than an
+// answer.
+func failed(err error) bool {
+	return err != nil && !errors.Is(err, users.ErrUserNotFound) && !errors.Is(err, users.ErrEmailTaken) &&
+		!errors.Is(err, users.ErrUserExists) && !errors.Is(err, users.ErrInvalidInput)
+}
+
+func (r *SplitRepo) count(s int, f func(*SideStats)) {
+	r.statsMu.Lock()
+	f(&r.stats[s])
+	r.statsMu.Unlock()
+}
+
+func (r *SplitRepo) read(s int, err error) {
+	r.count(s, func(st *SideStats) {
+		st.Reads++
+		if failed(err) {
+			st.ReadErrors++
+		}
+	})
+}
+
+func (r *SplitRepo) wrote(s int, err error) {
+	r.count(s, func(st *SideStats) {
+		st.Writes++
+		if failed(err) {
+			st.WriteErrors++
+		}
+	})
+}
+
+func (r *SplitRepo) mirror(s int, op string, id int, err error) {
+	r.count(s, func(st *SideStats) {
+		st.Mirrored++
+		if err != nil {
+			st.MirrorFailures++
+		}
+	})
+	if err != nil {
+		name := "blue"
+		if s == green {
+			name = "green"
+		}
+		r.opts.Logger.Warn("mirror write failed", "op", op, "user", id, "side", name, "err", err)
+	}
+}
+
+/*
+-----------------------------------
+READS
+-----------------------------------
+*/
+
+func (r *SplitRepo) GetByID(id int) (users.User, error) {
+	s := r.pick(r.opts.ReadFlag, id)
+	u, err := r.side(s).GetByID(id)
+	r.read(s, err)
+	return u, err
+}
+
+func (r *SplitRepo) List() []users.User {
+	s := r.pick(r.opts.ReadFlag, -1)
+	list := r.side(s).List()
+	r.read(s, nil)
+	return list
+}
+
+func (r *SplitRepo) Search(spec query.Spec) ([]users.User, error) {
+	s := r.pick(r.opts.ReadFlag, -1)
+	list, err := r.side(s).Search(spec)
+	r.read(s, err)
+	return list, err
+}
+
+// Iterate counts an error only if the caller reads as far as it.
+func (r *SplitRepo) Iterate(ctx context.Context, opts users.IterateOptions) iter.Seq2[users.User, error] {
+	s := r.pick(r.opts.ReadFlag, -1)
+	seq := r.side(s).Iterate(ctx, opts)
+	return func(yield func(users.User, error) bool) {
+		var ferr error
+		defer func() { r.read(s, ferr) }()
+		for u, err := range seq {
+			if err != nil {
+				ferr = err
+			}
+			if !yield(u, err) {
+				return
+			}
+		}
+	}
+}
+
+/*
+-----------------------------------
+WRITES
+-----------------------------------
+*/
+
+// Create assigns the ID on the side drawn and restores the record under
+// it on the other.
+func (r *SplitRepo) Create(u users.User) (users.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.pick(r.opts.WriteFlag, -1)
+	u, err := r.side(s).Create(u)
+	r.wrote(s, err)
+	if err == nil {
+		r.mirror(1-s, "create", u.ID, r.side(1-s).Restore(u))
+	}
+	return u, err
+}
+
+// Update mirrors the record as the authoritative side now holds it; a
+// record the other lacks is restored.
+func (r *SplitRepo) Update(u users.User) (users.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.pick(r.opts.WriteFlag, u.ID)
+	u, err := r.side(s).Update(u)
+	r.wrote(s, err)
+	if err == nil {
+		other := r.side(1 - s)
+		_, merr := other.Update(u)
+		if errors.Is(merr, users.ErrUserNotFound) {
+			merr = other.Restore(u)
+		}
+		r.mirror(1-s, "update", u.ID, merr)
+	}
+	return u, err
+}
+
+func (r *SplitRepo) Delete(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.pick(r.opts.WriteFlag, id)
+	err := r.side(s).Delete(id)
+	r.wrote(s, err)
+	if err == nil {
+		merr := r.side(1 - s).Delete(id)
+		if errors.Is(merr, users.ErrUserNotFound) {
+			merr = nil
+		}
+		r.mirror(1-s, "delete", id, merr)
+	}
+	return err
+}
+
+// Stats reports both sides and the current rollouts.
+func (r *SplitRepo) Stats() SplitStats {
+	r.statsMu.Lock()
+	st := SplitStats{Blue: r.stats[blue], Green: r.stats[green]}
+	r.statsMu.Unlock()
+	st.BlueErrorRate, st.GreenErrorRate = st.Blue.ErrorRate(), st.Green.ErrorRate()
+	flags := r.opts.Flags.Snapshot()
+	st.GreenReads = rollout(flags[r.opts.ReadFlag])
+	st.GreenWrites = rollout(flags[r.opts.WriteFlag])
+	return st
+}
+
+// rollout is the percentage f sends to green, allow lists aside.
+func rollout(f featureflag.Flag) float64 {
+	switch {
+	case !f.Enabled:
+		return 0
+	case f.Rollout == nil:
+		return 100
+	}
+	return *f.Rollout
+}
@@ -0,0 +1,194 @@
+// Package dedupe finds users who are likely one person registered twice
+// and merges them.
+//
+// Find pairs users with the same mailbox (normalize.Mailbox: case, +tags
+// and Gmail's dots do not tell two apart) or with names close enough by
+// edit distance. It only suggests: two people can share a name.
+//
+// A merge keeps one record and deletes the other. The kept record takes
+// the merged one's name if it has none; everything else it keeps. What
+// other stores hold about the merged user moves with it through each
+// Reassigner, before the record is deleted, so a failed move leaves both
+// users in place. A dry run reports what a merge would do without doing
+// any of it.
+package dedupe
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/clock"
+	"Go-Internals/ctxutil"
+	"Go-Internals/eventbus"
+	"Go-Internals/users"
+)
+
+// Reassigner is a store, other than the repository, that keeps records
+// belonging to a user.
+type Reassigner interface {
+	// Name identifies the store in reports.
+	Name() string
+	// Reassign moves to keep what the store holds for merged, updating
+	// keep's record if it points at it, and returns how many records
+	// moved; with dryRun, how many would.
+	Reassign(ctx context.Context, keep *users.User, merged users.User, dryRun bool) (int, error)
+}
+
+var ErrSameUser = errors.New("dedupe: cannot merge a user into itself")
+
+// Options configures New.
+type Options struct {
+	Repo        users.UserRepository
+	Reassigners []Reassigner
+	// Audit records each merge, with the kept record's changes and the
+	// merged ID. Default audit.Discard.
+	Audit audit.Sink
+	// Events, if set, is told of the kept user's update and the merged
+	// one's deletion, as the service would tell it, so subscribers (the
+	// avatar clean-up, caches) keep up.
+	Events *eventbus.Bus
+	Clock  clock.Clock
+}
+
+type Manager struct {
+	opts Options
+	clk  clock.Clock
+}
+
+func New(opts Options) *Manager {
+	if opts.Audit == nil {
+		opts.Audit = audit.Discard
+	}
+	return &Manager{opts: opts, clk: clock.OrReal(opts.Clock)}
+}
+
+// Find is the package's Find over the manager's repository.
+func (m *Manager) Find(ctx context.Context, opts FindOptions) ([]Candidate, error) {
+	return Find(ctx, m.opts.Repo, opts)
+}
+
+/*
+-----------------------------------
+MERGE
+-----------------------------------
+*/
+
+// Step is what one store did, or would do, during a merge.
+type Step struct {
+	Store   string `json:"store"`
+	Records int    `json:"records"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Report is what a merge did or, for a dry run, would do.
+type Report struct {
+	DryRun bool `json:"dry_run"`
+	// Keep is the kept record as the merge leaves it.
+	Keep     users.User `json:"keep"`
+	MergedID int        `json:"merged_id"`
+	// Changes are Keep's changed fields, as audit.Changes lists them.
+	Changes  map[string]string `json:"changes,omitempty"`
+	Steps    []Step            `json:"steps"`
+	Finished time.Time         `json:"finished"`
+}
+
+// Complete reports whether every store succeeded, so the merged user is
+// gone (or, in a dry run, would be).
+func (r Report) Complete() bool {
+	for _, s := range r.Steps {
+		if s.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge merges mergeID into keepID. A store that fails stops the merge
+// before the merged user is deleted; the report says which, and running
+// the merge again after fixing it moves the rest.
+func (m *Manager) Merge(ctx context.Context, keepID, mergeID int) (Report, error) {
+	return m.merge(ctx, keepID, mergeID, false)
+}
+
+// DryRun reports what Merge would do, changing nothing.
+func (m *Manager) DryRun(ctx context.Context, keepID, mergeID int) (Report, error) {
+	return m.merge(ctx, keepID, mergeID, true)
+}
+
+func (m *Manager) merge(ctx context.Context, keepID, mergeID int, dryRun bool) (Report, error) {
+	if keepID == mergeID {
+		return Report{}, ErrSameUser
+	}
+	before, err := m.opts.Repo.GetByID(keepID)
+	if err != nil {
+		return Report{}, err
+	}
+	merged, err := m.opts.Repo.GetByID(mergeID)
+	if err != nil {
+		return Report{}, err
+	}
+	keep := before
+	if keep.Name == "" {
+		keep.Name = merged.Name
+	}
+
+	rep := Report{DryRun: dryRun, MergedID: mergeID}
+	for _, r := range m.opts.Reassigners {
+		n, err := r.Reassign(ctx, &keep, merged, dryRun)
+		s := Step{Store: r.Name(), Records: n}
+		if err != nil {
+			s.Error = err.Error()
+		}
+		rep.Steps = append(rep.Steps, s)
+	}
+	rep.Changes, _ = audit.Changes(before, keep)
+	if !dryRun && rep.Complete() {
+		if keep, err = m.apply(ctx, before, keep, mergeID, rep.Changes); err != nil {
+			return rep, err
+		}
+	}
+	rep.Keep = keep
+	rep.Finished = m.clk.Now()
+	return rep, nil
+}
+
+// apply stores the kept record and deletes the merged one, then tells the
+// audit trail and the event bus.
+func (m *Manager) apply(ctx context.Context, before, keep users.User, mergeID int, changes map[string]string) (users.User, error) {
+	changed := keep != before
+	if changed {
+		var err error
+		if keep, err = m.opts.Repo.Update(keep); err != nil {
+			return before, err
+		}
+	}
+	if err := m.opts.Repo.Delete(mergeID); err != nil {
+		return keep, err
+	}
+	m.record(ctx, keep.ID, mergeID, changes)
+	if m.opts.Events != nil {
+		if changed {
+			_, _ = m.opts.Events.Publish(ctx, users.TopicUserUpdated, keep)
+		}
+		_, _ = m.opts.Events.Publish(ctx, users.TopicUserDeleted, users.User{ID: mergeID})
+	}
+	return keep, nil
+}
+
+// record is best effort, like the service's: the merge already happened.
+func (m *Manager) record(ctx context.Context, keepID, mergeID int, changes map[string]string) {
+	meta := map[string]string{"merged_id": strconv.Itoa(mergeID)}
+	for k, v := range changes {
+		meta[k] = v
+	}
+	_ = m.opts.Audit.Record(ctx, audit.Entry{
+		Actor:      ctxutil.UserID(ctx),
+		Action:     "dedupe.merge",
+		Resource:   "user",
+		ResourceID: strconv.Itoa(keepID),
+		Meta:       meta,
+	})
+}
@@ -0,0 +1,169 @@
+package dedupe
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"unicode"
+
+	"Go-Internals/normalize"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+FINDING DUPLICATES
+-----------------------------------
+*/
+
+// Candidate is a pair of users that are likely one person.
+type Candidate struct {
+	// Keep is the older of the two, the one a merge would keep.
+	Keep      users.User `json:"keep"`
+	Duplicate users.User `json:"duplicate"`
+	// Reason is "email" for the same normalized email, "name" for
+	// similar names.
+	Reason string `json:"reason"`
+	// Score is 1 for an email match, the names' similarity otherwise.
+	Score float64 `json:"score"`
+}
+
+// FindOptions configures Find.
+type FindOptions struct {
+	// NameSimilarity is the least similarity of two normalized names, 1
+	// minus their Levenshtein distance over the longer length, for them
+	// to be a candidate; default 0.85.
+	NameSimilarity float64
+	// TrigramOverlap is the least share of trigrams (Jaccard) two names
+	// must have to be compared at all: the index that keeps Find from
+	// comparing every pair; default 0.4.
+	TrigramOverlap float64
+}
+
+// Find lists the likely duplicates among repo's users, best first. Users
+// with the same normalize.Mailbox are paired with the oldest of them; the
+// names of the rest are compared, through a trigram index, to those of
+// every user before them. It holds every user in memory.
+func Find(ctx context.Context, repo users.UserRepository, opts FindOptions) ([]Candidate, error) {
+	if opts.NameSimilarity <= 0 {
+		opts.NameSimilarity = 0.85
+	}
+	if opts.TrigramOverlap <= 0 {
+		opts.TrigramOverlap = 0.4
+	}
+
+	type entry struct {
+		u     users.User
+		name  []rune
+		grams []string
+	}
+	var all []entry
+	for u, err := range repo.Iterate(ctx, users.IterateOptions{}) {
+		if err != nil {
+			return nil, err
+		}
+		n := NormalizeName(u.Name)
+		all = append(all, entry{u: u, name: []rune(n), grams: trigrams(n)})
+	}
+	// Oldest first, so the earlier of a pair is the one to keep.
+	slices.SortFunc(all, func(a, b entry) int {
+		return cmp.Or(a.u.CreatedAt.Compare(b.u.CreatedAt), cmp.Compare(a.u.ID, b.u.ID))
+	})
+
+	var out []Candidate
+	byEmail := make(map[string]int, len(all))
+	index := make(map[string][]int)
+	for i, e := range all {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		email := normalize.Mailbox(e.u.Email)
+		if first, ok := byEmail[email]; ok {
+			out = append(out, Candidate{Keep: all[first].u, Duplicate: e.u, Reason: "email", Score: 1})
+			continue
+		}
+		byEmail[email] = i
+
+		shared := make(map[int]int)
+		for _, g := range e.grams {
+			for _, j := range index[g] {
+				shared[j]++
+			}
+			index[g] = append(index[g], i)
+		}
+		best, bestScore := -1, 0.0
+		for j, n := range shared {
+			if jaccard := float64(n) / float64(len(e.grams)+len(all[j].grams)-n); jaccard < opts.TrigramOverlap {
+				continue
+			}
+			if s := similarity(e.name, all[j].name); s >= opts.NameSimilarity && (s > bestScore || s == bestScore && j < best) {
+				best, bestScore = j, s
+			}
+		}
+		if best >= 0 {
+			out = append(out, Candidate{Keep: all[best].u, Duplicate: e.u, Reason: "name", Score: bestScore})
+		}
+	}
+	slices.SortStableFunc(out, func(a, b Candidate) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(a.Keep.ID, b.Keep.ID), cmp.Compare(a.Duplicate.ID, b.Duplicate.ID))
+	})
+	return out, nil
+}
+
+// NormalizeName is the form names are compared in: normalize.Name
+// lowercased, letters and digits only, words separated by one space.
+func NormalizeName(name string) string {
+	clean := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, normalize.NFC(name))
+	return strings.Join(strings.Fields(clean), " ")
+}
+
+// trigrams are the distinct three-rune windows of the name padded with
+// spaces, so that short names and word starts have some.
+func trigrams(name string) []string {
+	if name == "" {
+		return nil
+	}
+	r := []rune("  " + name + " ")
+	var out []string
+	for i := 0; i+3 <= len(r); i++ {
+		if g := string(r[i : i+3]); !slices.Contains(out, g) {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// similarity is 1 - Levenshtein(a, b) / max(len(a), len(b)).
+func similarity(a, b []rune) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(a, b))/float64(max(len(a), len(b)))
+}
+
+// levenshtein is the edit distance in runes, in two rows of memory.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
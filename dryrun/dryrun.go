@@ -0,0 +1,110 @@
+// Package dryrun marks a request as a dry run: the mutations it makes
+// are checked as usual (validation, quotas, conflicts) but not stored,
+// and what they would have done, the records written, the events
+// published and the audit entries recorded, is collected in a Plan for
+// the caller to look at.
+//
+// Code that writes asks From(ctx) first and, in a dry run, adds to the
+// plan instead. Only code that does so may be run dry: the HTTP layer
+// refuses a dry run of a route that does not.
+package dryrun
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/ctxutil"
+)
+
+type ctxKey struct{}
+
+// With marks ctx as a dry run, collected in the returned Plan.
+func With(ctx context.Context) (context.Context, *Plan) {
+	p := &Plan{}
+	return context.WithValue(ctx, ctxKey{}, p), p
+}
+
+// From is ctx's plan, nil outside a dry run.
+func From(ctx context.Context) *Plan {
+	p, _ := ctx.Value(ctxKey{}).(*Plan)
+	return p
+}
+
+// Enabled reports whether ctx is a dry run.
+func Enabled(ctx context.Context) bool { return From(ctx) != nil }
+
+// Write is a record that would have been stored or deleted.
+type Write struct {
+	// Op is create, update or delete.
+	Op       string `json:"op"`
+	Resource string `json:"resource"`
+	// ID is the record's; for a create, the one it would have been
+	// given, "" if the store cannot tell.
+	ID string `json:"id"`
+	// Record is what would have been stored, nil for a delete.
+	Record any `json:"record,omitempty"`
+}
+
+// Event is an event that would have been published.
+type Event struct {
+	Topic   string `json:"topic"`
+	Payload any    `json:"payload"`
+}
+
+// Report is a plan's contents, each in the order it happened.
+type Report struct {
+	Writes []Write       `json:"writes"`
+	Events []Event       `json:"events"`
+	Audit  []audit.Entry `json:"audit"`
+}
+
+// Plan collects what a dry run would have done. It is an audit.Sink, so
+// a component that records to one can be pointed at it.
+type Plan struct {
+	mu     sync.Mutex
+	report Report
+}
+
+// Write adds a write.
+func (p *Plan) Write(w Write) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report.Writes = append(p.report.Writes, w)
+}
+
+// Publish adds an event.
+func (p *Plan) Publish(topic string, payload any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report.Events = append(p.report.Events, Event{Topic: topic, Payload: payload})
+}
+
+// Record adds an audit entry, stamped as audit.Ring stamps one.
+func (p *Plan) Record(ctx context.Context, e audit.Entry) error {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	if e.Impersonator == "" {
+		e.Impersonator = ctxutil.Impersonator(ctx)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report.Audit = append(p.report.Audit, e)
+	return nil
+}
+
+// Close is a no-op.
+func (p *Plan) Close(context.Context) error { return nil }
+
+// Report is what the plan holds so far; every list is non-nil.
+func (p *Plan) Report() Report {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Report{
+		Writes: append([]Write{}, p.report.Writes...),
+		Events: append([]Event{}, p.report.Events...),
+		Audit:  append([]audit.Entry{}, p.report.Audit...),
+	}
+}
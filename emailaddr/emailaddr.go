@@ -0,0 +1,325 @@
+// Package emailaddr checks email addresses as mail servers take them:
+// the syntax of RFC 5321 with RFC 6531's UTF-8, its length limits, and
+// internationalized domains, which Parse converts to and from the
+// Punycode DNS knows them by.
+//
+// What it accepts is what one can register with, which is a little
+// narrower than what the RFCs allow: the domain needs at least two
+// labels, so "root@localhost" is refused, and comments, folding
+// whitespace and display names ("Ada <ada@example.com>") are not
+// addresses here. Whether the domain takes mail at all is a DNS
+// question; see Checker.
+package emailaddr
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"Go-Internals/normalize"
+)
+
+// The limits of RFC 5321 section 4.5.3.1, in octets of the ASCII form.
+const (
+	MaxLocal  = 64
+	MaxDomain = 255
+	MaxLabel  = 63
+	// MaxAddress is the longest path, 256 octets, less its angle
+	// brackets.
+	MaxAddress = 254
+)
+
+var ErrInvalid = errors.New("emailaddr: invalid address")
+
+// Error is why Parse refused an address. It matches ErrInvalid.
+type Error struct {
+	Reason string
+}
+
+func (e *Error) Error() string        { return ErrInvalid.Error() + ": " + e.Reason }
+func (e *Error) Is(target error) bool { return target == ErrInvalid }
+
+func invalid(reason string) error { return &Error{Reason: reason} }
+
+// Address is a parsed address. Domain is in lowercase Unicode, with any
+// A-label decoded; a domain literal is kept in its brackets.
+type Address struct {
+	Local  string
+	Domain string
+	// ASCIIDomain is Domain as DNS has it, each label of it in Punycode
+	// that needs to be.
+	ASCIIDomain string
+}
+
+// String is the address in its Unicode form.
+func (a Address) String() string { return a.Local + "@" + a.Domain }
+
+// ASCII is the address in the form a server without SMTPUTF8 takes;
+// false if there is none, because the local part is not ASCII.
+func (a Address) ASCII() (string, bool) {
+	if !isASCII(a.Local) {
+		return "", false
+	}
+	return a.Local + "@" + a.ASCIIDomain, true
+}
+
+// Valid reports whether Parse accepts s.
+func Valid(s string) bool {
+	_, err := Parse(s)
+	return err == nil
+}
+
+// Parse checks s and splits it into its parts. s is put in NFC first, so
+// two spellings of one address parse the same; the local part keeps its
+// case, which only its server may fold.
+func Parse(s string) (Address, error) {
+	if !utf8.ValidString(s) {
+		return Address{}, invalid("not valid UTF-8")
+	}
+	s = normalize.NFC(s)
+	at := strings.LastIndexByte(s, '@')
+	if at < 0 {
+		return Address{}, invalid("no @")
+	}
+	local, domain := s[:at], s[at+1:]
+	if err := checkLocal(local); err != nil {
+		return Address{}, err
+	}
+
+	var a Address
+	var err error
+	if strings.HasPrefix(domain, "[") {
+		a, err = parseLiteral(domain)
+	} else {
+		a, err = parseDomain(domain)
+	}
+	if err != nil {
+		return Address{}, err
+	}
+	a.Local = local
+	if len(local)+1+len(a.ASCIIDomain) > MaxAddress {
+		return Address{}, invalid("longer than 254 octets")
+	}
+	return a, nil
+}
+
+/*
+-----------------------------------
+LOCAL PART
+-----------------------------------
+*/
+
+// checkLocal accepts RFC 5321's Local-part: a Dot-string of atoms, or a
+// Quoted-string, either with RFC 6531's UTF-8 beyond ASCII.
+func checkLocal(local string) error {
+	switch {
+	case local == "":
+		return invalid("empty local part")
+	case len(local) > MaxLocal:
+		return invalid("local part longer than 64 octets")
+	case strings.HasPrefix(local, `"`):
+		return checkQuoted(local)
+	}
+	for atom := range strings.SplitSeq(local, ".") {
+		if atom == "" {
+			return invalid("local part has an empty atom (a dot at the start, the end or twice)")
+		}
+		for _, r := range atom {
+			if !isAtext(r) {
+				return invalid("local part has " + quoteRune(r) + "; quote it")
+			}
+		}
+	}
+	return nil
+}
+
+func checkQuoted(local string) error {
+	if len(local) < 2 || !strings.HasSuffix(local, `"`) {
+		return invalid("unterminated quoted local part")
+	}
+	inner := local[1 : len(local)-1]
+	for i := 0; i < len(inner); {
+		r, n := utf8.DecodeRuneInString(inner[i:])
+		i += n
+		switch {
+		case r == '\\':
+			if i == len(inner) || inner[i] < ' ' || inner[i] > '~' {
+				return invalid(`quoted local part has a \ before nothing it can escape`)
+			}
+			i++
+		case r == '"':
+			return invalid(`quoted local part has an unescaped "`)
+		case r >= ' ' && r <= '~', r >= utf8.RuneSelf && !isControl(r):
+		default:
+			return invalid("quoted local part has " + quoteRune(r))
+		}
+	}
+	return nil
+}
+
+// isAtext is RFC 5322's atext, with RFC 6531's UTF8-non-ascii.
+func isAtext(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	case r >= utf8.RuneSelf:
+		return !isControl(r) && !unicode.IsSpace(r)
+	}
+	return strings.ContainsRune("!#$%&'*+-/=?^_`{|}~", r)
+}
+
+func isControl(r rune) bool { return unicode.IsControl(r) || r == utf8.RuneError }
+
+func quoteRune(r rune) string {
+	if r == ' ' {
+		return "a space"
+	}
+	return fmt.Sprintf("%q", r)
+}
+
+/*
+-----------------------------------
+DOMAIN
+-----------------------------------
+*/
+
+// parseLiteral accepts RFC 5321's address literals, [192.0.2.1] and
+// [IPv6:2001:db8::1].
+func parseLiteral(domain string) (Address, error) {
+	if !strings.HasSuffix(domain, "]") {
+		return Address{}, invalid("unterminated domain literal")
+	}
+	inner := domain[1 : len(domain)-1]
+	v6, isV6 := strings.CutPrefix(inner, "IPv6:")
+	ip, err := netip.ParseAddr(v6)
+	if err != nil || ip.Zone() != "" || ip.Is4() == isV6 {
+		return Address{}, invalid("domain literal is not an IPv4 address or IPv6: and an IPv6 address")
+	}
+	return Address{Domain: domain, ASCIIDomain: domain}, nil
+}
+
+// dots are the full stops IDNA takes as label separators.
+var dots = strings.NewReplacer("。", ".", "．", ".", "｡", ".")
+
+// parseDomain checks each label of a domain name, converting between
+// U-labels and A-labels.
+func parseDomain(domain string) (Address, error) {
+	domain = strings.ToLower(dots.Replace(domain))
+	if domain == "" {
+		return Address{}, invalid("empty domain")
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return Address{}, invalid("domain needs a dot")
+	}
+	uni := make([]string, len(labels))
+	ascii := make([]string, len(labels))
+	for i, l := range labels {
+		var err error
+		if uni[i], ascii[i], err = label(l); err != nil {
+			return Address{}, err
+		}
+	}
+	if tld := ascii[len(ascii)-1]; strings.Trim(tld, "0123456789") == "" {
+		return Address{}, invalid("top-level domain is all digits")
+	}
+	a := Address{Domain: strings.Join(uni, "."), ASCIIDomain: strings.Join(ascii, ".")}
+	if len(a.ASCIIDomain) > MaxDomain {
+		return Address{}, invalid("domain longer than 255 octets")
+	}
+	return a, nil
+}
+
+// label returns a lowercase label in both of its forms.
+func label(l string) (uni, ascii string, err error) {
+	switch {
+	case l == "":
+		return "", "", invalid("domain has an empty label")
+	case !isASCII(l):
+		uni = l
+		if err := checkULabel(l); err != nil {
+			return "", "", err
+		}
+		enc, err := encode(l)
+		if err != nil {
+			return "", "", invalid("label " + l + " cannot be encoded")
+		}
+		ascii = acePrefix + enc
+	case strings.HasPrefix(l, acePrefix):
+		ascii = l
+		dec, err := decode(l[len(acePrefix):])
+		if err != nil || isASCII(dec) {
+			return "", "", invalid("label " + l + " is not valid Punycode")
+		}
+		if checkULabel(dec) != nil {
+			return "", "", invalid("label " + l + " does not decode to a valid label")
+		}
+		// An A-label must be the one its U-label encodes to.
+		if enc, _ := encode(dec); acePrefix+enc != l {
+			return "", "", invalid("label " + l + " is not valid Punycode")
+		}
+		uni = dec
+	default:
+		uni, ascii = l, l
+		if err := checkLDH(l); err != nil {
+			return "", "", err
+		}
+	}
+	if len(ascii) > MaxLabel {
+		return "", "", invalid("label " + uni + " longer than 63 octets")
+	}
+	return uni, ascii, nil
+}
+
+// checkLDH accepts a host name label: letters, digits and hyphens, not at
+// either end, and no "--" where it would mark an encoding.
+func checkLDH(l string) error {
+	for _, c := range []byte(l) {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return invalid("domain label " + l + " has " + quoteRune(rune(c)))
+		}
+	}
+	return checkHyphens(l)
+}
+
+// checkULabel is an approximation of IDNA2008's rules for a U-label:
+// lowercase letters, marks and digits, in NFC (Parse made sure of both),
+// hyphens as in checkLDH, and no mark to start.
+func checkULabel(l string) error {
+	for i, r := range l {
+		switch {
+		case unicode.IsMark(r):
+			if i == 0 {
+				return invalid("domain label " + l + " starts with a combining mark")
+			}
+		case unicode.IsUpper(r):
+			return invalid("domain label " + l + " has an uppercase letter")
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-':
+		default:
+			return invalid("domain label " + l + " has " + quoteRune(r))
+		}
+	}
+	return checkHyphens(l)
+}
+
+func checkHyphens(l string) error {
+	if strings.HasPrefix(l, "-") || strings.HasSuffix(l, "-") {
+		return invalid("domain label " + l + " starts or ends with a hyphen")
+	}
+	if len(l) >= 4 && l[2:4] == "--" && !strings.HasPrefix(l, acePrefix) {
+		return invalid("domain label " + l + " has -- in the third and fourth places")
+	}
+	return nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
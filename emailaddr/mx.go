@@ -0,0 +1,83 @@
+package emailaddr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+/*
+-----------------------------------
+MAIL SERVERS
+-----------------------------------
+*/
+
+// Resolver is the part of *net.Resolver a Checker uses.
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// ErrNoMailServer is Check's answer for a domain that, by DNS, takes no
+// mail: one that does not exist, or has a null MX record (RFC 7505).
+var ErrNoMailServer = errors.New("emailaddr: the domain takes no mail")
+
+// CheckerOptions configures NewChecker.
+type CheckerOptions struct {
+	// Resolver defaults to net.DefaultResolver.
+	Resolver Resolver
+	// Timeout bounds each Check; default 2s.
+	Timeout time.Duration
+}
+
+// Checker asks DNS whether an address's domain takes mail.
+type Checker struct {
+	opts CheckerOptions
+}
+
+func NewChecker(opts CheckerOptions) *Checker {
+	if opts.Resolver == nil {
+		opts.Resolver = net.DefaultResolver
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	return &Checker{opts: opts}
+}
+
+// Check looks up a's mail servers: its MX records or, with none, the
+// domain's own address, which RFC 5321 section 5.1 has mail go to. It
+// returns ErrNoMailServer for a domain there is no delivering to, and
+// the resolver's error, a timeout say, if it could not tell; a domain
+// literal is not looked up.
+func (c *Checker) Check(ctx context.Context, a Address) error {
+	if strings.HasPrefix(a.ASCIIDomain, "[") {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	mx, err := c.opts.Resolver.LookupMX(ctx, a.ASCIIDomain)
+	switch {
+	case err == nil && len(mx) == 1 && (mx[0].Host == "." || mx[0].Host == ""):
+		return fmt.Errorf("%w: %s has a null MX record", ErrNoMailServer, a.Domain)
+	case err == nil && len(mx) > 0:
+		return nil
+	case err != nil && !notFound(err):
+		return fmt.Errorf("emailaddr: looking up %s: %w", a.ASCIIDomain, err)
+	}
+	if _, err := c.opts.Resolver.LookupHost(ctx, a.ASCIIDomain); notFound(err) {
+		return fmt.Errorf("%w: %s has no MX record or address", ErrNoMailServer, a.Domain)
+	} else if err != nil {
+		return fmt.Errorf("emailaddr: looking up %s: %w", a.ASCIIDomain, err)
+	}
+	return nil
+}
+
+func notFound(err error) bool {
+	var dns *net.DNSError
+	return errors.As(err, &dns) && dns.IsNotFound
+}
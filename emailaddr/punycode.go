@@ -0,0 +1,168 @@
+package emailaddr
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+-----------------------------------
+PUNYCODE
+-----------------------------------
+*/
+
+// The parameters of RFC 3492 section 5, for IDNA.
+const (
+	base        = 36
+	tMin        = 1
+	tMax        = 26
+	skew        = 38
+	damp        = 700
+	initialBias = 72
+	initialN    = 128
+)
+
+// acePrefix marks a label that is Punycode (an A-label).
+const acePrefix = "xn--"
+
+var errPunycode = errors.New("bad punycode")
+
+// encode is RFC 3492's encoding of a label: its ASCII runes as they are,
+// then a delimiter and the rest as deltas, without the xn-- prefix.
+func encode(label string) (string, error) {
+	runes := []rune(label)
+	var b strings.Builder
+	for _, r := range runes {
+		if r < initialN {
+			b.WriteRune(r)
+		}
+	}
+	basic := b.Len()
+	handled := basic
+	if basic > 0 {
+		b.WriteByte('-')
+	}
+	n, delta, bias := rune(initialN), 0, initialBias
+	for handled < len(runes) {
+		m := rune(utf8.MaxRune)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		if int(m-n) > (1<<31-1-delta)/(handled+1) {
+			return "", errPunycode
+		}
+		delta += int(m-n) * (handled + 1)
+		n = m
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := base; ; k += base {
+				t := threshold(k, bias)
+				if q < t {
+					break
+				}
+				b.WriteByte(digit(t + (q-t)%(base-t)))
+				q = (q - t) / (base - t)
+			}
+			b.WriteByte(digit(q))
+			bias = adapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return b.String(), nil
+}
+
+// decode reverses encode.
+func decode(s string) (string, error) {
+	var out []rune
+	rest := s
+	if i := strings.LastIndexByte(s, '-'); i >= 0 {
+		for _, r := range s[:i] {
+			if r >= initialN {
+				return "", errPunycode
+			}
+			out = append(out, r)
+		}
+		rest = s[i+1:]
+	}
+	n, i, bias := rune(initialN), 0, initialBias
+	for pos := 0; pos < len(rest); {
+		old, w := i, 1
+		for k := base; ; k += base {
+			if pos == len(rest) {
+				return "", errPunycode
+			}
+			d, ok := value(rest[pos])
+			pos++
+			if !ok || d > (1<<31-1-i)/w {
+				return "", errPunycode
+			}
+			i += d * w
+			t := threshold(k, bias)
+			if d < t {
+				break
+			}
+			w *= base - t
+		}
+		bias = adapt(i-old, len(out)+1, old == 0)
+		n += rune(i / (len(out) + 1))
+		i %= len(out) + 1
+		if n > utf8.MaxRune || n < initialN {
+			return "", errPunycode
+		}
+		out = append(out, 0)
+		copy(out[i+1:], out[i:])
+		out[i] = n
+		i++
+	}
+	return string(out), nil
+}
+
+func threshold(k, bias int) int {
+	return min(max(k-bias, tMin), tMax)
+}
+
+func adapt(delta, points int, first bool) int {
+	if first {
+		delta /= damp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > (base-tMin)*tMax/2 {
+		delta /= base - tMin
+		k += base
+	}
+	return k + (base-tMin+1)*delta/(delta+skew)
+}
+
+func digit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func value(c byte) (int, bool) {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a'), true
+	case c >= 'A' && c <= 'Z':
+		return int(c - 'A'), true
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 26, true
+	}
+	return 0, false
+}
@@ -0,0 +1,360 @@
+// Package errortrack groups the errors a process reports so an operator
+// sees "this failed 4,000 times since 09:12" once, instead of 4,000 log
+// lines, and is told when something new starts failing or an old failure
+// gets worse.
+//
+// Errors are grouped by fingerprint: the type of the root cause (the
+// innermost wrapped error) and the functions on the stack where it was
+// reported. Messages are left out on purpose, since they carry IDs and
+// addresses that differ every time; line numbers too, so the same
+// failure keeps its fingerprint across a deploy that only moved code.
+//
+// Each group counts occurrences over every Rule's window. A Rule whose
+// count is reached raises an Alert, at most once per Cooldown for that
+// group, and Run hands alerts to the Sinks (log, webhook, email) off the
+// reporting goroutine: a slow mail server never slows a request.
+package errortrack
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Go-Internals/boundedqueue"
+	"Go-Internals/clock"
+	"Go-Internals/window"
+)
+
+// maxFrames is how much of the stack goes into a fingerprint.
+const maxFrames = 32
+
+// Rule raises an alert when a group reaches Count occurrences within
+// Window.
+type Rule struct {
+	Name   string
+	Count  int
+	Window time.Duration
+	// Cooldown is how long a group stays quiet after alerting for this
+	// rule; default Window.
+	Cooldown time.Duration
+}
+
+// DefaultRules alert on an error's first occurrence (and daily while it
+// keeps occurring) and on one occurring 100 times within five minutes.
+var DefaultRules = []Rule{
+	{Name: "new", Count: 1, Window: 24 * time.Hour},
+	{Name: "burst", Count: 100, Window: 5 * time.Minute},
+}
+
+// Options configures New.
+type Options struct {
+	// Rules default to DefaultRules.
+	Rules []Rule
+	Sinks []Sink
+	// MaxGroups bounds memory; past it the group seen least recently is
+	// forgotten. Default 1000.
+	MaxGroups int
+	// Queue is how many alerts may wait for the sinks; more are dropped
+	// and counted. Default 256.
+	Queue int
+	// SendTimeout bounds one sink's delivery of one alert; default 30s.
+	SendTimeout time.Duration
+	Clock       clock.Clock
+	// Logger reports failed deliveries; default slog.Default().
+	Logger *slog.Logger
+}
+
+// Group is one fingerprint's occurrences.
+type Group struct {
+	Fingerprint string `json:"fingerprint"`
+	Type        string `json:"type"`
+	// Message is the latest occurrence's.
+	Message string `json:"message"`
+	// Stack is the first occurrence's, innermost frame first.
+	Stack     []string  `json:"stack"`
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Recent is the count within each rule's window, by rule name.
+	Recent map[string]uint64 `json:"recent"`
+}
+
+// Alert is a rule's threshold crossed by a group.
+type Alert struct {
+	Rule   string        `json:"rule"`
+	Count  uint64        `json:"count"` // within Window
+	Window time.Duration `json:"window"`
+	At     time.Time     `json:"at"`
+	Group  Group         `json:"group"`
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("%s: %q (%s) %d times in %s", a.Rule, a.Group.Message, a.Group.Type, a.Count, a.Window)
+}
+
+// Tracker is safe for concurrent use. A nil *Tracker ignores reports.
+type Tracker struct {
+	opts   Options
+	clk    clock.Clock
+	alerts *boundedqueue.Queue[Alert]
+
+	mu     sync.Mutex
+	groups map[string]*group
+
+	reported   atomic.Int64
+	raised     atomic.Int64
+	sinkErrors atomic.Int64
+}
+
+type group struct {
+	Group
+	counters []*window.Counter // per rule
+	alerted  []time.Time       // per rule, last alert
+}
+
+func New(opts Options) *Tracker {
+	if len(opts.Rules) == 0 {
+		opts.Rules = DefaultRules
+	}
+	opts.Rules = slices.Clone(opts.Rules)
+	for i := range opts.Rules {
+		if opts.Rules[i].Cooldown <= 0 {
+			opts.Rules[i].Cooldown = opts.Rules[i].Window
+		}
+	}
+	if opts.MaxGroups <= 0 {
+		opts.MaxGroups = 1000
+	}
+	if opts.Queue <= 0 {
+		opts.Queue = 256
+	}
+	if opts.SendTimeout <= 0 {
+		opts.SendTimeout = 30 * time.Second
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &Tracker{
+		opts:   opts,
+		clk:    clock.OrReal(opts.Clock),
+		alerts: boundedqueue.New(boundedqueue.Options[Alert]{Capacity: opts.Queue, Policy: boundedqueue.Reject}),
+		groups: make(map[string]*group),
+	}
+}
+
+// Report records err with the caller's stack and returns its
+// fingerprint. Nil errors are ignored.
+func (t *Tracker) Report(err error) string {
+	if t == nil || err == nil {
+		return ""
+	}
+	pcs := make([]uintptr, maxFrames)
+	return t.ReportStack(err, pcs[:runtime.Callers(2, pcs)])
+}
+
+// ReportStack records err with a stack captured elsewhere, such as where
+// a panic was recovered.
+func (t *Tracker) ReportStack(err error, pcs []uintptr) string {
+	if t == nil || err == nil {
+		return ""
+	}
+	typ := fmt.Sprintf("%T", rootCause(err))
+	funcs, stack := frames(pcs)
+	sum := sha256.Sum256([]byte(typ + "\n" + strings.Join(funcs, "\n")))
+	fp := hex.EncodeToString(sum[:8])
+
+	now := t.clk.Now()
+	t.reported.Add(1)
+	t.mu.Lock()
+	g, ok := t.groups[fp]
+	if !ok {
+		if len(t.groups) >= t.opts.MaxGroups {
+			t.evict()
+		}
+		g = &group{
+			Group:    Group{Fingerprint: fp, Type: typ, Stack: stack, FirstSeen: now},
+			counters: make([]*window.Counter, len(t.opts.Rules)),
+			alerted:  make([]time.Time, len(t.opts.Rules)),
+		}
+		for i, r := range t.opts.Rules {
+			g.counters[i] = window.New(r.Window, 10, t.clk)
+		}
+		t.groups[fp] = g
+	}
+	g.Message, g.LastSeen = err.Error(), now
+	g.Count++
+	var raised []Alert
+	for i, r := range t.opts.Rules {
+		g.counters[i].Inc()
+		n := g.counters[i].Sum()
+		if n < uint64(r.Count) || !g.alerted[i].IsZero() && now.Sub(g.alerted[i]) < r.Cooldown {
+			continue
+		}
+		g.alerted[i] = now
+		raised = append(raised, Alert{Rule: r.Name, Count: n, Window: r.Window, At: now, Group: t.snapshot(g)})
+	}
+	t.mu.Unlock()
+
+	for _, a := range raised {
+		t.raised.Add(1)
+		t.alerts.TryEnqueue(a)
+	}
+	return fp
+}
+
+// rootCause follows Unwrap to the innermost error; of a join, the first.
+func rootCause(err error) error {
+	for {
+		switch u := err.(type) {
+		case interface{ Unwrap() error }:
+			next := u.Unwrap()
+			if next == nil {
+				return err
+			}
+			err = next
+		case interface{ Unwrap() []error }:
+			errs := u.Unwrap()
+			if len(errs) == 0 || errs[0] == nil {
+				return err
+			}
+			err = errs[0]
+		default:
+			return err
+		}
+	}
+}
+
+// frames returns the stack's function names, for the fingerprint, and
+// their "function file:line" for display. Runtime frames are skipped:
+// a panic's stack has them whichever code panicked.
+func frames(pcs []uintptr) (funcs, stack []string) {
+	fs := runtime.CallersFrames(pcs)
+	for {
+		f, more := fs.Next()
+		if f.Function != "" && !strings.HasPrefix(f.Function, "runtime.") {
+			funcs = append(funcs, f.Function)
+			stack = append(stack, fmt.Sprintf("%s %s:%d", f.Function, f.File, f.Line))
+		}
+		if !more {
+			return funcs, stack
+		}
+	}
+}
+
+// evict forgets the group seen least recently. t.mu is held.
+func (t *Tracker) evict() {
+	var oldest *group
+	for _, g := range t.groups {
+		if oldest == nil || g.LastSeen.Before(oldest.LastSeen) {
+			oldest = g
+		}
+	}
+	if oldest != nil {
+		delete(t.groups, oldest.Fingerprint)
+	}
+}
+
+// snapshot copies g for outside the lock. t.mu is held.
+func (t *Tracker) snapshot(g *group) Group {
+	out := g.Group
+	out.Recent = make(map[string]uint64, len(t.opts.Rules))
+	for i, r := range t.opts.Rules {
+		out.Recent[r.Name] = g.counters[i].Sum()
+	}
+	return out
+}
+
+// Groups returns every group, most recently seen first.
+func (t *Tracker) Groups() []Group {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	out := make([]Group, 0, len(t.groups))
+	for _, g := range t.groups {
+		out = append(out, t.snapshot(g))
+	}
+	t.mu.Unlock()
+	slices.SortFunc(out, func(a, b Group) int { return b.LastSeen.Compare(a.LastSeen) })
+	return out
+}
+
+// Run delivers alerts to the sinks until ctx is done. Sinks are tried one
+// after another; one failing does not stop the others.
+func (t *Tracker) Run(ctx context.Context) {
+	for {
+		a, err := t.alerts.Dequeue(ctx)
+		if err != nil {
+			return
+		}
+		for _, s := range t.opts.Sinks {
+			sctx, cancel := context.WithTimeout(ctx, t.opts.SendTimeout)
+			err := s.Send(sctx, a)
+			cancel()
+			if err != nil {
+				t.sinkErrors.Add(1)
+				t.opts.Logger.Warn("errortrack: delivering alert failed", "sink", fmt.Sprintf("%T", s), "alert", a.String(), "err", err)
+			}
+		}
+	}
+}
+
+// Stats counts reports, groups and alerts.
+type Stats struct {
+	Reported   int64 `json:"reported"`
+	Groups     int   `json:"groups"`
+	Alerts     int64 `json:"alerts"`
+	Dropped    int64 `json:"alerts_dropped"` // the queue was full
+	SinkErrors int64 `json:"sink_errors"`
+}
+
+func (t *Tracker) Stats() Stats {
+	if t == nil {
+		return Stats{}
+	}
+	t.mu.Lock()
+	n := len(t.groups)
+	t.mu.Unlock()
+	return Stats{
+		Reported: t.reported.Load(), Groups: n, Alerts: t.raised.Load(),
+		Dropped: t.alerts.Stats().Rejected, SinkErrors: t.sinkErrors.Load(),
+	}
+}
+
+type trackerKey struct{}
+
+// WithTracker returns ctx carrying t, for code that reports errors far
+// from where the tracker is configured.
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, trackerKey{}, t)
+}
+
+// From returns ctx's tracker, or nil, which ignores reports.
+func From(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(trackerKey{}).(*Tracker)
+	return t
+}
+
+// Panic is the error Report is given for a recovered panic: its Value
+// and where it was recovered.
+type Panic struct {
+	Where string
+	Value any
+}
+
+func (p *Panic) Error() string { return fmt.Sprintf("panic in %s: %v", p.Where, p.Value) }
+
+// Unwrap returns the panic value if it is an error, so the root cause's
+// type is the value's.
+func (p *Panic) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
@@ -0,0 +1,124 @@
+package errortrack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+/*
+-----------------------------------
+ALERT SINKS
+-----------------------------------
+*/
+
+// Sink delivers alerts somewhere a person will see them.
+type Sink interface {
+	Send(ctx context.Context, a Alert) error
+}
+
+// SinkFunc adapts a function to Sink.
+type SinkFunc func(ctx context.Context, a Alert) error
+
+func (f SinkFunc) Send(ctx context.Context, a Alert) error { return f(ctx, a) }
+
+// Log writes alerts to l (slog.Default() if nil) at Error level.
+func Log(l *slog.Logger) Sink {
+	return SinkFunc(func(ctx context.Context, a Alert) error {
+		if l == nil {
+			l = slog.Default()
+		}
+		l.ErrorContext(ctx, "errortrack: alert", "rule", a.Rule, "count", a.Count, "window", a.Window,
+			"fingerprint", a.Group.Fingerprint, "type", a.Group.Type, "message", a.Group.Message, "total", a.Group.Count)
+		return nil
+	})
+}
+
+// Webhook posts each alert as JSON to a URL. Anything but a 2xx answer
+// is a failed delivery.
+type Webhook struct {
+	URL    string
+	Header http.Header // added to every request, e.g. Authorization
+	Client *http.Client
+}
+
+func (wh *Webhook) Send(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, vs := range wh.Header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := wh.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("errortrack: webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Email mails each alert as plain text through an SMTP server.
+type Email struct {
+	Addr string // host:port
+	Auth smtp.Auth
+	From string
+	To   []string
+}
+
+func (e *Email) Send(ctx context.Context, a Alert) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [errortrack] %s\r\n", subjectOf(a))
+	fmt.Fprintf(&msg, "Date: %s\r\n", a.At.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "Rule:        %s (%d in %s)\r\n", a.Rule, a.Count, a.Window)
+	fmt.Fprintf(&msg, "Error:       %s\r\n", a.Group.Message)
+	fmt.Fprintf(&msg, "Type:        %s\r\n", a.Group.Type)
+	fmt.Fprintf(&msg, "Fingerprint: %s\r\n", a.Group.Fingerprint)
+	fmt.Fprintf(&msg, "Seen:        %d times since %s\r\n\r\n", a.Group.Count, a.Group.FirstSeen.Format(time.RFC3339))
+	for _, f := range a.Group.Stack {
+		fmt.Fprintf(&msg, "    %s\r\n", f)
+	}
+
+	// net/smtp takes no context; the send runs apart so a hung server
+	// costs only the timeout.
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(e.Addr, e.Auth, e.From, e.To, []byte(msg.String())) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// subjectOf keeps the subject to one short line whatever the message.
+func subjectOf(a Alert) string {
+	s := strings.Join(strings.Fields(a.Group.Message), " ")
+	if r := []rune(s); len(r) > 80 {
+		s = string(r[:77]) + "..."
+	}
+	return fmt.Sprintf("%s: %s", a.Rule, s)
+}
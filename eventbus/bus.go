@@ -0,0 +1,200 @@
+// Package eventbus is an in-process publish/subscribe bus.
+//
+// Every subscriber gets its own bounded queue and goroutine, so a slow
+// handler only ever backs up its own queue. What happens when that queue is
+// full is the subscriber's drop policy, and drops are counted per
+// subscription instead of disappearing.
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"Go-Internals/boundedqueue"
+)
+
+// AllTopics subscribes to every topic.
+const AllTopics = "*"
+
+var ErrClosed = errors.New("eventbus: bus is closed")
+
+// Event is what subscribers receive.
+type Event struct {
+	Topic   string
+	Payload any
+	At      time.Time
+}
+
+type Handler func(Event)
+
+// SubscribeOptions tunes one subscriber's queue.
+type SubscribeOptions struct {
+	Buffer int // default 64
+	// Policy when the subscriber's queue is full. boundedqueue.Reject makes
+	// Publish wait (bounded by the publish context) instead of dropping.
+	Policy boundedqueue.DropPolicy
+}
+
+// Subscription is returned by Subscribe.
+type Subscription struct {
+	bus     *Bus
+	topic   string
+	policy  boundedqueue.DropPolicy
+	queue   *boundedqueue.Queue[Event]
+	handler Handler
+	done    chan struct{}
+}
+
+// Stats reports the subscriber's queue counters.
+func (s *Subscription) Stats() boundedqueue.Stats { return s.queue.Stats() }
+
+// Topic returns the subscribed topic.
+func (s *Subscription) Topic() string { return s.topic }
+
+// Subscriptions returns a snapshot of the live subscriptions, for
+// reporting queue depths.
+func (b *Bus) Subscriptions() []*Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []*Subscription
+	for _, subs := range b.subs {
+		out = append(out, subs...)
+	}
+	return out
+}
+
+// Unsubscribe stops delivery, lets the handler drain what is already queued
+// and waits for it to finish.
+func (s *Subscription) Unsubscribe() {
+	s.bus.remove(s)
+	s.queue.Close()
+	<-s.done
+}
+
+// Bus routes events by topic.
+type Bus struct {
+	mu     sync.Mutex
+	subs   map[string][]*Subscription
+	closed bool
+}
+
+func New() *Bus {
+	return &Bus{subs: make(map[string][]*Subscription)}
+}
+
+// Subscribe registers h for topic (or AllTopics).
+func (b *Bus) Subscribe(topic string, h Handler, opts SubscribeOptions) (*Subscription, error) {
+	if opts.Buffer <= 0 {
+		opts.Buffer = 64
+	}
+
+	s := &Subscription{
+		bus:     b,
+		topic:   topic,
+		policy:  opts.Policy,
+		handler: h,
+		done:    make(chan struct{}),
+	}
+	s.queue = boundedqueue.New(boundedqueue.Options[Event]{
+		Capacity:      opts.Buffer,
+		Policy:        opts.Policy,
+		HighWatermark: opts.Buffer * 3 / 4,
+		LowWatermark:  opts.Buffer / 4,
+		OnHigh: func(depth int) {
+			log.Printf("eventbus: subscriber on %q is falling behind (depth %d/%d)", topic, depth, opts.Buffer)
+		},
+	})
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, ErrClosed
+	}
+	b.subs[topic] = append(b.subs[topic], s)
+	b.mu.Unlock()
+
+	go s.run()
+	return s, nil
+}
+
+// Publish delivers the event to every matching subscriber and returns how
+// many accepted it. Drop-policy subscribers never block the publisher;
+// Reject-policy subscribers make Publish wait until ctx is done.
+func (b *Bus) Publish(ctx context.Context, topic string, payload any) (int, error) {
+	ev := Event{Topic: topic, Payload: payload, At: time.Now()}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return 0, ErrClosed
+	}
+	targets := make([]*Subscription, 0, len(b.subs[topic])+len(b.subs[AllTopics]))
+	targets = append(targets, b.subs[topic]...)
+	if topic != AllTopics {
+		targets = append(targets, b.subs[AllTopics]...)
+	}
+	b.mu.Unlock()
+
+	delivered := 0
+	var errs []error
+	for _, s := range targets {
+		if s.policy == boundedqueue.Reject {
+			if err := s.queue.EnqueueCtx(ctx, ev); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			delivered++
+			continue
+		}
+		if s.queue.TryEnqueue(ev) {
+			delivered++
+		}
+	}
+	return delivered, errors.Join(errs...)
+}
+
+// Close unsubscribes everyone, draining queued events first.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	var all []*Subscription
+	for _, list := range b.subs {
+		all = append(all, list...)
+	}
+	b.subs = nil
+	b.mu.Unlock()
+
+	for _, s := range all {
+		s.queue.Close()
+	}
+	for _, s := range all {
+		<-s.done
+	}
+}
+
+func (b *Bus) remove(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list := b.subs[s.topic]
+	for i, other := range list {
+		if other == s {
+			b.subs[s.topic] = append(list[:i:i], list[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *Subscription) run() {
+	defer close(s.done)
+	for ev := range s.queue.All() {
+		s.handler(ev)
+	}
+}
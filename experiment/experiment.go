@@ -0,0 +1,236 @@
+// Package experiment assigns users to A/B test variants.
+//
+// Assignment reuses the feature-flag bucketing (a stable hash of experiment
+// name and user ID), so a user sees the same variant on every request and
+// on every instance without storing anything. Each assignment is an
+// exposure: it is counted for the stats endpoint and published on the
+// event bus for downstream analysis.
+package experiment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Go-Internals/eventbus"
+	"Go-Internals/featureflag"
+)
+
+// TopicExposure is the event bus topic exposures are published on.
+const TopicExposure = "experiment.exposure"
+
+var (
+	ErrUnknownExperiment = errors.New("experiment: unknown experiment")
+	ErrInvalidDefinition = errors.New("experiment: invalid definition")
+)
+
+// Variant is one arm of an experiment. Weights are relative.
+type Variant struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// Experiment is a named set of weighted variants, optionally gated behind
+// a feature flag (users outside the flag are not enrolled at all).
+type Experiment struct {
+	Name     string    `json:"name"`
+	Variants []Variant `json:"variants"`
+	Flag     string    `json:"flag,omitempty"`
+}
+
+// Exposure is the event payload published for every assignment.
+type Exposure struct {
+	Experiment string    `json:"experiment"`
+	Variant    string    `json:"variant"`
+	UserID     int       `json:"user_id"`
+	At         time.Time `json:"at"`
+}
+
+// Service holds definitions and exposure counters.
+type Service struct {
+	bus   *eventbus.Bus
+	flags *featureflag.Set
+
+	mu          sync.RWMutex
+	experiments map[string]*definition
+}
+
+type definition struct {
+	Experiment
+	total     int
+	exposures []atomic.Int64 // parallel to Variants
+}
+
+// New creates a service. bus and flags may be nil.
+func New(bus *eventbus.Bus, flags *featureflag.Set) *Service {
+	return &Service{
+		bus:         bus,
+		flags:       flags,
+		experiments: make(map[string]*definition),
+	}
+}
+
+// Load reads a JSON array of Experiment, checked as Define checks them.
+func Load(r io.Reader) ([]Experiment, error) {
+	var list []Experiment
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&list); err != nil {
+		return nil, fmt.Errorf("experiment: definitions: %w", err)
+	}
+	for _, e := range list {
+		if _, err := e.check(); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// check validates e and returns its total weight.
+func (e Experiment) check() (int, error) {
+	if e.Name == "" || len(e.Variants) == 0 {
+		return 0, fmt.Errorf("%w: name and at least one variant are required", ErrInvalidDefinition)
+	}
+	total := 0
+	seen := map[string]bool{}
+	for _, v := range e.Variants {
+		if v.Weight <= 0 || v.Name == "" || seen[v.Name] {
+			return 0, fmt.Errorf("%w: variant %q", ErrInvalidDefinition, v.Name)
+		}
+		seen[v.Name] = true
+		total += v.Weight
+	}
+	return total, nil
+}
+
+// Define adds or replaces an experiment. Replacing resets its counters.
+func (s *Service) Define(e Experiment) error {
+	total, err := e.check()
+	if err != nil {
+		return err
+	}
+
+	d := &definition{
+		Experiment: e,
+		total:      total,
+		exposures:  make([]atomic.Int64, len(e.Variants)),
+	}
+	d.Variants = slices.Clone(e.Variants)
+
+	s.mu.Lock()
+	s.experiments[e.Name] = d
+	s.mu.Unlock()
+	return nil
+}
+
+// Assign returns the user's variant and records an exposure. ok is false
+// when the experiment's gating flag is off for this user.
+func (s *Service) Assign(ctx context.Context, name string, userID int) (variant string, ok bool, err error) {
+	s.mu.RLock()
+	d := s.experiments[name]
+	s.mu.RUnlock()
+	if d == nil {
+		return "", false, fmt.Errorf("%w: %q", ErrUnknownExperiment, name)
+	}
+
+	if d.Flag != "" && (s.flags == nil || !s.flags.Enabled(d.Flag, userID)) {
+		return "", false, nil
+	}
+
+	i := d.pick(userID)
+	d.exposures[i].Add(1)
+	v := d.Variants[i].Name
+
+	if s.bus != nil {
+		// Exposure logging must never fail the request; drops show up in the
+		// subscriber's queue stats.
+		s.bus.Publish(ctx, TopicExposure, Exposure{
+			Experiment: name,
+			Variant:    v,
+			UserID:     userID,
+			At:         time.Now(),
+		})
+	}
+	return v, true, nil
+}
+
+// pick maps the user's stable bucket onto the cumulative weights.
+func (d *definition) pick(userID int) int {
+	b := int(featureflag.Bucket("experiment:"+d.Name, userID))
+	point := b * d.total / 10_000
+	for i, v := range d.Variants {
+		if point < v.Weight {
+			return i
+		}
+		point -= v.Weight
+	}
+	return len(d.Variants) - 1
+}
+
+/*
+-----------------------------------
+STATS
+-----------------------------------
+*/
+
+// VariantStats summarizes one variant.
+type VariantStats struct {
+	Name      string  `json:"name"`
+	Weight    int     `json:"weight"`
+	Exposures int64   `json:"exposures"`
+	Share     float64 `json:"share"` // observed fraction of exposures
+}
+
+// Stats summarizes one experiment.
+type Stats struct {
+	Experiment string         `json:"experiment"`
+	Flag       string         `json:"flag,omitempty"`
+	Exposures  int64          `json:"exposures"`
+	Variants   []VariantStats `json:"variants"`
+}
+
+// Stats returns exposure counts per variant for every experiment.
+func (s *Service) Stats() []Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]Stats, 0, len(s.experiments))
+	for _, d := range s.experiments {
+		st := Stats{Experiment: d.Name, Flag: d.Flag}
+		for i, v := range d.Variants {
+			n := d.exposures[i].Load()
+			st.Exposures += n
+			st.Variants = append(st.Variants, VariantStats{Name: v.Name, Weight: v.Weight, Exposures: n})
+		}
+		for i := range st.Variants {
+			if st.Exposures > 0 {
+				st.Variants[i].Share = float64(st.Variants[i].Exposures) / float64(st.Exposures)
+			}
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Experiment < out[j].Experiment })
+	return out
+}
+
+// StatsHandler serves Stats as JSON (httpapi mounts it at
+// /experiments/stats).
+func (s *Service) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Stats())
+	})
+}
@@ -0,0 +1,74 @@
+package featureflag
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// LoadFile reads flag definitions from a JSON file of the form
+//
+//	{"user_dto_v2": {"enabled": true, "rollout": 25}}
+func LoadFile(path string) (map[string]Flag, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var flags map[string]Flag
+	if err := json.Unmarshal(data, &flags); err != nil {
+		return nil, fmt.Errorf("featureflag: %s: %w", path, err)
+	}
+	for name, f := range flags {
+		if f.Rollout != nil && (*f.Rollout < 0 || *f.Rollout > 100) {
+			return nil, fmt.Errorf("featureflag: %s: flag %q rollout %v out of range 0-100", path, name, *f.Rollout)
+		}
+	}
+	return flags, nil
+}
+
+// WatchFile hot-reloads the set from path, polling its size and mtime
+// every interval until ctx is done. A file that fails to parse is reported
+// through onErr (if non-nil) and the previous definitions stay active.
+func (s *Set) WatchFile(ctx context.Context, path string, interval time.Duration, onErr func(error)) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	var lastMod time.Time
+	var lastSize int64 = -1
+
+	reload := func() {
+		st, err := os.Stat(path)
+		if err != nil {
+			if onErr != nil {
+				onErr(err)
+			}
+			return
+		}
+		if st.ModTime().Equal(lastMod) && st.Size() == lastSize {
+			return
+		}
+		flags, err := LoadFile(path)
+		if err != nil {
+			if onErr != nil {
+				onErr(err)
+			}
+			return
+		}
+		lastMod, lastSize = st.ModTime(), st.Size()
+		s.Replace(flags)
+	}
+
+	reload()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reload()
+		}
+	}
+}
@@ -0,0 +1,441 @@
+// Package fieldcrypt encrypts individual struct fields before a record is
+// persisted, driven by struct tags:
+//
+//	Name  string `encrypt:"true"`          // randomized
+//	Email string `encrypt:"deterministic"` // same plaintext, same ciphertext
+//
+// This sits above storage encryption (package atrest), not instead of it:
+// atrest protects files on disk, fieldcrypt keeps the values opaque to the
+// backend itself, its indexes, its logs and its backups.
+//
+// Randomized fields use AES-GCM with a random nonce, so equal values look
+// different and nothing can be learned by comparing them. Deterministic
+// fields derive the nonce from an HMAC of the plaintext (the SIV idea),
+// which leaks equality and nothing else: that is exactly what a
+// uniqueness index or an equality lookup needs, so such a field stays
+// queryable by encrypting the query value the same way (Seal).
+//
+// The field name is bound in as associated data, so a ciphertext cannot
+// be moved to another field. Encrypted values are text
+// ("enc1:" + base64url), so they fit any backend that stores strings;
+// values without the prefix are passed through by Decrypt, which keeps
+// records written before encryption was switched on readable.
+//
+// A Codec from NewRotating takes its key from a Keyring of versions and
+// names the version in each value it seals ("enc2:" + ID + ":" +
+// base64url), so the key can be rotated: new values are sealed with the
+// current version, old ones still open with theirs, and Stale finds
+// those to re-encrypt before their version is dropped.
+//
+// Only string fields (and *string) are supported; a tag on anything else
+// is a programming error and makes Encrypt fail. Fields of nested structs
+// are found too (reflectutil.Fields) and bound under their path,
+// "Address.City".
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"Go-Internals/internals/reflectutil"
+)
+
+// EnvVar holds the base64 field key (16, 24 or 32 bytes).
+const EnvVar = "USERS_FIELD_KEY"
+
+// Tag is the struct tag key.
+const Tag = "encrypt"
+
+// prefix starts sealed values; versionedPrefix, those that name the key
+// version they were sealed with.
+const (
+	prefix          = "enc1:"
+	versionedPrefix = "enc2:"
+)
+
+// Mode is how a field is encrypted.
+type Mode int
+
+const (
+	Plain         Mode = iota // not encrypted
+	Random                    // `encrypt:"true"`
+	Deterministic             // `encrypt:"deterministic"`
+)
+
+var (
+	ErrDecrypt   = errors.New("fieldcrypt: decryption failed (wrong key or tampered value)")
+	ErrNotStruct = errors.New("fieldcrypt: want a pointer to a struct")
+)
+
+// Codec is safe for concurrent use.
+type Codec struct {
+	static *version // New's; nil for NewRotating's
+	keys   Keyring
+	// derived caches the versions of keys by ID.
+	derived sync.Map // string → *version
+}
+
+// Keyring is a versioned key; keyset.Set is one.
+type Keyring interface {
+	Current() (id string, key []byte)
+	Lookup(id string) ([]byte, bool)
+	IDs() []string
+}
+
+// version is the keys derived from one secret.
+type version struct {
+	aead cipher.AEAD
+	mac  []byte // nonce derivation key for deterministic fields
+}
+
+// New derives the encryption and nonce keys from key, so one secret
+// configures both.
+func New(key []byte) (*Codec, error) {
+	v, err := derive(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Codec{static: v}, nil
+}
+
+// NewRotating seals with keys' current version and opens with the
+// version a value names. Values sealed by a Codec from New name none and
+// are tried with each version, so a key put in keys as its first
+// version keeps them readable.
+func NewRotating(keys Keyring) (*Codec, error) {
+	c := &Codec{keys: keys}
+	if _, _, err := c.current(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// EnvKey is the key in $USERS_FIELD_KEY, nil if it is unset.
+func EnvKey() ([]byte, error) {
+	v := os.Getenv(EnvVar)
+	if v == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("fieldcrypt: $%s: %w", EnvVar, err)
+	}
+	return key, nil
+}
+
+// FromEnv builds a codec from $USERS_FIELD_KEY, or returns nil if it is
+// unset.
+func FromEnv() (*Codec, error) {
+	key, err := EnvKey()
+	if err != nil || key == nil {
+		return nil, err
+	}
+	return New(key)
+}
+
+func derive(key []byte) (*version, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("fieldcrypt: key is %d bytes, want 16, 24 or 32", len(key))
+	}
+	block, err := aes.NewCipher(subkey(key, "fieldcrypt enc")[:len(key)])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &version{aead: aead, mac: subkey(key, "fieldcrypt nonce")}, nil
+}
+
+func subkey(key []byte, label string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(label))
+	return m.Sum(nil)
+}
+
+// current is the version values are sealed with, and its ID ("" for
+// New's).
+func (c *Codec) current() (string, *version, error) {
+	if c.keys == nil {
+		return "", c.static, nil
+	}
+	id, _ := c.keys.Current()
+	v, err := c.version(id)
+	return id, v, err
+}
+
+// version is keys' version id, derived once.
+func (c *Codec) version(id string) (*version, error) {
+	key, ok := c.keys.Lookup(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: key version %q not held", ErrDecrypt, id)
+	}
+	if v, ok := c.derived.Load(id); ok {
+		return v.(*version), nil
+	}
+	v, err := derive(key)
+	if err != nil {
+		return nil, fmt.Errorf("fieldcrypt: key version %q: %w", id, err)
+	}
+	c.derived.Store(id, v)
+	return v, nil
+}
+
+/*
+-----------------------------------
+VALUES
+-----------------------------------
+*/
+
+// Seal encrypts one value as field would be. Use it to build query values
+// for deterministic fields. Plain returns the value unchanged.
+//
+// A rotating Codec's values are "enc2:" + version ID + ":" + base64url.
+func (c *Codec) Seal(field, value string, mode Mode) string {
+	if mode == Plain {
+		return value
+	}
+	id, v, err := c.current()
+	if err != nil {
+		// NewRotating derived the current version; a keyring whose next
+		// one is unusable is broken beyond what a caller can handle.
+		panic(err)
+	}
+	out := base64.RawURLEncoding.EncodeToString(v.seal(field, value, mode))
+	if c.keys == nil {
+		return prefix + out
+	}
+	return versionedPrefix + id + ":" + out
+}
+
+// SealEach is Seal under each key version held, current first: a
+// deterministic value is found among records not re-encrypted yet by
+// looking for any of them.
+func (c *Codec) SealEach(field, value string, mode Mode) []string {
+	if c.keys == nil || mode == Plain {
+		return []string{c.Seal(field, value, mode)}
+	}
+	var out []string
+	for _, id := range c.keys.IDs() {
+		if v, err := c.version(id); err == nil {
+			out = append(out, versionedPrefix+id+":"+base64.RawURLEncoding.EncodeToString(v.seal(field, value, mode)))
+		}
+	}
+	return out
+}
+
+func (v *version) seal(field, value string, mode Mode) []byte {
+	nonce := make([]byte, v.aead.NonceSize(), v.aead.NonceSize()+len(value)+v.aead.Overhead())
+	if mode == Deterministic {
+		m := hmac.New(sha256.New, v.mac)
+		m.Write([]byte(field))
+		m.Write([]byte{0})
+		m.Write([]byte(value))
+		copy(nonce, m.Sum(nil))
+	} else {
+		rand.Read(nonce)
+	}
+	return v.aead.Seal(nonce, nonce, []byte(value), []byte(field))
+}
+
+// Open reverses Seal. A value that was never sealed is returned as is.
+func (c *Codec) Open(field, value string) (string, error) {
+	if rest, ok := strings.CutPrefix(value, versionedPrefix); ok {
+		id, enc, ok := strings.Cut(rest, ":")
+		if !ok || c.keys == nil {
+			return "", ErrDecrypt
+		}
+		v, err := c.version(id)
+		if err != nil {
+			return "", err
+		}
+		return v.open(field, enc)
+	}
+	enc, ok := strings.CutPrefix(value, prefix)
+	if !ok {
+		return value, nil
+	}
+	if c.keys == nil {
+		return c.static.open(field, enc)
+	}
+	for _, id := range c.keys.IDs() {
+		if v, err := c.version(id); err == nil {
+			if pt, err := v.open(field, enc); err == nil {
+				return pt, nil
+			}
+		}
+	}
+	return "", ErrDecrypt
+}
+
+func (v *version) open(field, enc string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil || len(raw) < v.aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+	n := v.aead.NonceSize()
+	pt, err := v.aead.Open(nil, raw[:n], raw[n:], []byte(field))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(pt), nil
+}
+
+// Stale reports whether value is sealed, but not with the current key
+// version: re-encrypting would seal it anew.
+func (c *Codec) Stale(value string) bool {
+	if !IsSealed(value) {
+		return false
+	}
+	id, _, _ := c.current()
+	if c.keys == nil {
+		return !strings.HasPrefix(value, prefix)
+	}
+	return !strings.HasPrefix(value, versionedPrefix+id+":")
+}
+
+// IsSealed reports whether value came out of Seal.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, prefix) || strings.HasPrefix(value, versionedPrefix)
+}
+
+// SealedLen is the length of a sealed n-byte value, for backends with
+// fixed-width columns. A rotating Codec's are longer by the version ID
+// and a colon.
+func SealedLen(n int) int { return len(prefix) + base64.RawURLEncoding.EncodedLen(12+n+16) }
+
+/*
+-----------------------------------
+STRUCTS
+-----------------------------------
+*/
+
+// Encrypt seals every tagged field of *ptr in place.
+func (c *Codec) Encrypt(ptr any) error {
+	return c.walk(ptr, func(name string, mode Mode, s string) (string, error) {
+		return c.Seal(name, s, mode), nil
+	})
+}
+
+// Decrypt opens every tagged field of *ptr in place.
+func (c *Codec) Decrypt(ptr any) error {
+	return c.walk(ptr, func(name string, _ Mode, s string) (string, error) {
+		return c.Open(name, s)
+	})
+}
+
+func (c *Codec) walk(ptr any, f func(name string, mode Mode, s string) (string, error)) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+	v = v.Elem()
+	fields, err := fieldsOf(v.Type())
+	if err != nil {
+		return err
+	}
+	for _, fd := range fields {
+		fv := v.FieldByIndex(fd.index)
+		if fv.Kind() == reflect.Pointer {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		out, err := f(fd.name, fd.mode, fv.String())
+		if err != nil {
+			return fmt.Errorf("%w: field %s", err, fd.name)
+		}
+		fv.SetString(out)
+	}
+	return nil
+}
+
+// ModeOf reports how field of struct type T is encrypted.
+func ModeOf[T any](field string) Mode {
+	fields, _ := fieldsOf(reflect.TypeFor[T]())
+	for _, fd := range fields {
+		if fd.name == field {
+			return fd.mode
+		}
+	}
+	return Plain
+}
+
+type field struct {
+	index []int
+	name  string
+	mode  Mode
+}
+
+// Tags are parsed once per type.
+var cache sync.Map // reflect.Type → []field or error
+
+func fieldsOf(t reflect.Type) ([]field, error) {
+	if v, ok := cache.Load(t); ok {
+		if err, bad := v.(error); bad {
+			return nil, err
+		}
+		return v.([]field), nil
+	}
+	all, err := reflectutil.Fields(t, Tag)
+	if err != nil {
+		return nil, ErrNotStruct
+	}
+	// Fields skips unexported fields; a tag on one must not be ignored.
+	for i := range t.NumField() {
+		if sf := t.Field(i); !sf.IsExported() && sf.Tag.Get(Tag) != "" {
+			err = fmt.Errorf("fieldcrypt: %s.%s: only exported string fields can be encrypted", t, sf.Name)
+		}
+	}
+	var fields []field
+	for _, f := range all {
+		if err != nil {
+			break
+		}
+		if !f.HasTag {
+			continue
+		}
+		var mode Mode
+		switch f.Tag {
+		case "true":
+			mode = Random
+		case "deterministic":
+			mode = Deterministic
+		case "false", "-":
+			continue
+		default:
+			err = fmt.Errorf("fieldcrypt: %s.%s: unknown %s tag %q", t, f.Path, Tag, f.Tag)
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if err == nil && ft.Kind() != reflect.String {
+			err = fmt.Errorf("fieldcrypt: %s.%s: only string fields can be encrypted", t, f.Path)
+		}
+		if err != nil {
+			break
+		}
+		fields = append(fields, field{index: f.Index, name: f.Path, mode: mode})
+	}
+	if err != nil {
+		cache.Store(t, err)
+		return nil, err
+	}
+	cache.Store(t, fields)
+	return fields, nil
+}
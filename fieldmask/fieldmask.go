@@ -0,0 +1,211 @@
+// Package fieldmask serves partial responses: a client names the fields
+// it wants, ?fields=id,name, and gets only those, so a list for a picker
+// does not carry every user's email.
+//
+// A Mask names top-level JSON fields of a struct type, as its json tags
+// spell them. Apply zeroes the others, for callers that keep working
+// with the struct; Select keeps only the masked ones, in declaration
+// order, for encoding. Both go by reflection, with each type's fields
+// worked out once.
+package fieldmask
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"Go-Internals/collections"
+)
+
+var ErrUnknownField = errors.New("fieldmask: unknown field")
+
+// Mask is a set of JSON field names. A nil Mask is every field.
+type Mask []string
+
+// Parse reads a comma-separated list of T's JSON field names. An empty
+// list is the nil Mask; a name given twice counts once.
+func Parse[T any](s string) (Mask, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	fs, err := fieldsOf(reflect.TypeFor[T]())
+	if err != nil {
+		return nil, err
+	}
+	m := Mask{}
+	for name := range strings.SplitSeq(s, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.ContainsFunc(fs, func(f field) bool { return f.name == name }) {
+			return nil, fmt.Errorf("%w %q; fields are %s", ErrUnknownField, name, names(fs))
+		}
+		if !slices.Contains(m, name) {
+			m = append(m, name)
+		}
+	}
+	return m, nil
+}
+
+// Has reports whether the field named name is in m.
+func (m Mask) Has(name string) bool { return m == nil || slices.Contains(m, name) }
+
+// String is the Parse form.
+func (m Mask) String() string { return strings.Join(m, ",") }
+
+// Apply returns v with the fields outside m set to their zero value. T
+// must be a struct; otherwise v comes back unchanged. Fields promoted
+// from an embedded pointer are kept: zeroing them would change whatever
+// else shares the pointer.
+func Apply[T any](m Mask, v T) T {
+	if m == nil {
+		return v
+	}
+	fs, err := fieldsOf(reflect.TypeFor[T]())
+	if err != nil {
+		return v
+	}
+	rv := reflect.ValueOf(&v).Elem()
+	for _, f := range fs {
+		if m.Has(f.name) || f.viaPointer {
+			continue
+		}
+		if fv, err := rv.FieldByIndexErr(f.index); err == nil {
+			fv.SetZero()
+		}
+	}
+	return v
+}
+
+// Select is v's fields in m as their JSON names and values, in the order
+// they are declared: encoded, it is v's JSON with only those fields. A
+// field tagged omitempty or omitzero is left out when it would be.
+func Select[T any](m Mask, v T) *collections.OrderedMap[string, any] {
+	out := collections.NewOrderedMap[string, any]()
+	fs, err := fieldsOf(reflect.TypeFor[T]())
+	if err != nil {
+		return out
+	}
+	rv := reflect.ValueOf(v)
+	for _, f := range fs {
+		if !m.Has(f.name) {
+			continue
+		}
+		fv, err := rv.FieldByIndexErr(f.index)
+		if err != nil || (f.omitZero && fv.IsZero()) || (f.omitEmpty && empty(fv)) {
+			continue
+		}
+		out.Set(f.name, fv.Interface())
+	}
+	return out
+}
+
+// SelectAll is Select over a list.
+func SelectAll[T any](m Mask, vs []T) []*collections.OrderedMap[string, any] {
+	out := make([]*collections.OrderedMap[string, any], len(vs))
+	for i, v := range vs {
+		out[i] = Select(m, v)
+	}
+	return out
+}
+
+/*
+-----------------------------------
+FIELDS
+-----------------------------------
+*/
+
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	omitZero  bool
+	// viaPointer marks a field promoted from an embedded pointer.
+	viaPointer bool
+}
+
+// fieldCache holds one []field per struct type.
+var fieldCache sync.Map
+
+// fieldsOf lists the fields encoding/json would write for t, with their
+// names: exported, not tagged "-", and those of embedded structs without
+// a tag promoted.
+func fieldsOf(t reflect.Type) ([]field, error) {
+	if fs, ok := fieldCache.Load(t); ok {
+		return fs.([]field), nil
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("fieldmask: %s is not a struct", t)
+	}
+	var fs []field
+	for _, sf := range reflect.VisibleFields(t) {
+		tag, hasTag := sf.Tag.Lookup("json")
+		name, opts, _ := strings.Cut(tag, ",")
+		switch {
+		case !sf.IsExported() || name == "-" && opts == "":
+			continue
+		case sf.Anonymous && !hasTag && isStruct(sf.Type):
+			continue // its fields are listed in its place
+		}
+		promoted, viaPointer := embeddedIn(t, sf.Index)
+		if !promoted {
+			continue // a field of a named struct field
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		f := field{name: name, index: sf.Index, viaPointer: viaPointer}
+		for o := range strings.SplitSeq(opts, ",") {
+			f.omitEmpty = f.omitEmpty || o == "omitempty"
+			f.omitZero = f.omitZero || o == "omitzero"
+		}
+		fs = append(fs, f)
+	}
+	fieldCache.Store(t, fs)
+	return fs, nil
+}
+
+// embeddedIn reports whether every struct on the way to the field at
+// index is embedded without a tag, so the field is promoted in JSON, and
+// whether one of them is a pointer.
+func embeddedIn(t reflect.Type, index []int) (promoted, viaPointer bool) {
+	for _, i := range index[:len(index)-1] {
+		sf := t.Field(i)
+		if _, tagged := sf.Tag.Lookup("json"); !sf.Anonymous || tagged {
+			return false, false
+		}
+		if t = sf.Type; t.Kind() == reflect.Pointer {
+			t, viaPointer = t.Elem(), true
+		}
+	}
+	return true, viaPointer
+}
+
+func isStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+func names(fs []field) string {
+	out := make([]string, len(fs))
+	for i, f := range fs {
+		out[i] = f.name
+	}
+	return strings.Join(out, ", ")
+}
+
+// empty is encoding/json's omitempty test.
+func empty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+// Package flightrec keeps the traces of recent slow and failed requests
+// in memory, so the question "what was that request doing?" can be
+// answered after the fact without a tracing collector.
+//
+// The spans are the request's breadcrumb stages: everything that already
+// marks a stage for deadline errors (the limiter, the service calls,
+// quota, repository calls) shows up in the trace without more
+// instrumentation. Nesting is read from the timing, a stage inside
+// another's interval being its child, which is exact for the sequential
+// code a request runs.
+//
+// Only requests slower than Slow or answered with a 5xx are kept, in a
+// ring of the last Keep, so the cost of a fast, successful request is
+// its trail and nothing else.
+package flightrec
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Go-Internals/breadcrumb"
+	"Go-Internals/reqid"
+)
+
+// Options configures New.
+type Options struct {
+	// Keep is how many traces are kept; default 100.
+	Keep int
+	// Slow is the duration from which a successful request is kept;
+	// default 500ms. Negative keeps every request.
+	Slow time.Duration
+}
+
+// Span is one stage of a request, relative to its start.
+type Span struct {
+	Name     string        `json:"name"`
+	Start    time.Duration `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Done     bool          `json:"done"`
+	// Depth is how many spans enclose this one. Spans are in start
+	// order, so a span's parent is the nearest one before it with a
+	// smaller Depth.
+	Depth int `json:"depth"`
+}
+
+// Trace is one request.
+type Trace struct {
+	ID       string        `json:"id"` // the request ID
+	Method   string        `json:"method"`
+	Path     string        `json:"path"`
+	Status   int           `json:"status"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Spans    []Span        `json:"spans"`
+}
+
+// Recorder is safe for concurrent use.
+type Recorder struct {
+	opts Options
+
+	mu     sync.Mutex
+	traces []Trace // ring, next at next
+	next   int
+
+	seen     atomic.Int64
+	recorded atomic.Int64
+}
+
+func New(opts Options) *Recorder {
+	if opts.Keep <= 0 {
+		opts.Keep = 100
+	}
+	if opts.Slow == 0 {
+		opts.Slow = 500 * time.Millisecond
+	}
+	return &Recorder{opts: opts}
+}
+
+// Middleware records the requests through next that are slow or fail. It
+// starts a breadcrumb trail unless an outer handler already has.
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := r.Context()
+		trail := breadcrumb.From(ctx)
+		if trail == nil {
+			ctx, trail = breadcrumb.New(ctx)
+			r = r.WithContext(ctx)
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			rec.seen.Add(1)
+			elapsed := time.Since(start)
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if status < 500 && rec.opts.Slow >= 0 && elapsed < rec.opts.Slow {
+				return
+			}
+			rec.add(Trace{
+				ID: reqid.From(ctx), Method: r.Method, Path: r.URL.Path, Status: status,
+				Start: start, Duration: elapsed, Spans: nest(trail.Stages()),
+			})
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// nest works out each stage's depth from which stages' intervals hold
+// it.
+func nest(stages []breadcrumb.StageInfo) []Span {
+	spans := make([]Span, len(stages))
+	var open []time.Duration // ends of the enclosing spans
+	for i, s := range stages {
+		end := s.Start + s.Took
+		for len(open) > 0 && open[len(open)-1] < end {
+			open = open[:len(open)-1]
+		}
+		spans[i] = Span{Name: s.Name, Start: s.Start, Duration: s.Took, Done: s.Done, Depth: len(open)}
+		open = append(open, end)
+	}
+	return spans
+}
+
+func (rec *Recorder) add(t Trace) {
+	rec.recorded.Add(1)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.traces) < rec.opts.Keep {
+		rec.traces = append(rec.traces, t)
+		return
+	}
+	rec.traces[rec.next] = t
+	rec.next = (rec.next + 1) % rec.opts.Keep
+}
+
+// Recent returns up to n traces, newest first; n <= 0 returns them all.
+func (rec *Recorder) Recent(n int) []Trace {
+	if rec == nil {
+		return nil
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if n <= 0 || n > len(rec.traces) {
+		n = len(rec.traces)
+	}
+	out := make([]Trace, 0, n)
+	for i := range n {
+		out = append(out, rec.traces[(rec.next+len(rec.traces)-1-i)%len(rec.traces)])
+	}
+	return out
+}
+
+// Get returns the trace of the request with ID id.
+func (rec *Recorder) Get(id string) (Trace, bool) {
+	all := rec.Recent(0)
+	if i := slices.IndexFunc(all, func(t Trace) bool { return t.ID == id }); i >= 0 {
+		return all[i], true
+	}
+	return Trace{}, false
+}
+
+// Stats counts the requests seen and those recorded.
+type Stats struct {
+	Seen     int64 `json:"seen"`
+	Recorded int64 `json:"recorded"`
+}
+
+func (rec *Recorder) Stats() Stats {
+	if rec == nil {
+		return Stats{}
+	}
+	return Stats{Seen: rec.seen.Load(), Recorded: rec.recorded.Load()}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) Flush() { _ = http.NewResponseController(w.ResponseWriter).Flush() }
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
@@ -0,0 +1,183 @@
+// Package geoip tells which country an IP address is in.
+//
+// A Resolver is anything that can answer that: a DB loaded from a CSV
+// export of one of the usual databases, or a client of a lookup service.
+// A DB holds address ranges, each with an ISO 3166-1 alpha-2 code, and
+// answers by binary search. Test is a small one, embedded, that covers
+// only the documentation blocks (192.0.2.0/24, 198.51.100.0/24,
+// 203.0.113.0/24 and 2001:db8::/32), for examples and local runs.
+package geoip
+
+import (
+	"context"
+	"embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Resolver looks up the country of an address: its ISO 3166-1 alpha-2
+// code, uppercase, or "" if it does not know.
+type Resolver interface {
+	Country(ctx context.Context, ip netip.Addr) (string, error)
+}
+
+var ErrMalformed = errors.New("geoip: malformed database")
+
+// span is a range of addresses, both ends included, in one country.
+type span struct {
+	first, last netip.Addr
+	country     string
+}
+
+// DB is an in-memory Resolver. Its zero value knows no address.
+type DB struct {
+	spans []span // sorted by first, not overlapping
+}
+
+// Open loads the CSV database at path; see Load.
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	db, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+// LoadFS loads the CSV database name in fsys; see Load.
+func LoadFS(fsys fs.FS, name string) (*DB, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	db, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return db, nil
+}
+
+// Load reads a CSV database whose records are either "network,country",
+// with the network in CIDR notation, or "first,last,country"; lines
+// starting with # are skipped. IPv4 and IPv6 ranges may be mixed, in any
+// order, but may not overlap.
+func Load(r io.Reader) (*DB, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	var db DB
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+		}
+		line, _ := cr.FieldPos(0)
+		s, err := parseSpan(rec)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrMalformed, line, err)
+		}
+		db.spans = append(db.spans, s)
+	}
+	slices.SortFunc(db.spans, func(a, b span) int { return a.first.Compare(b.first) })
+	for i := 1; i < len(db.spans); i++ {
+		if prev, s := db.spans[i-1], db.spans[i]; s.first.Compare(prev.last) <= 0 {
+			return nil, fmt.Errorf("%w: %s-%s overlaps %s-%s", ErrMalformed, s.first, s.last, prev.first, prev.last)
+		}
+	}
+	return &db, nil
+}
+
+func parseSpan(rec []string) (span, error) {
+	var s span
+	switch len(rec) {
+	case 2:
+		p, err := netip.ParsePrefix(strings.TrimSpace(rec[0]))
+		if err != nil {
+			return span{}, err
+		}
+		p = p.Masked()
+		s.first, s.last = p.Addr(), lastOf(p)
+	case 3:
+		var err error
+		if s.first, err = netip.ParseAddr(strings.TrimSpace(rec[0])); err != nil {
+			return span{}, err
+		}
+		if s.last, err = netip.ParseAddr(strings.TrimSpace(rec[1])); err != nil {
+			return span{}, err
+		}
+		s.first, s.last = s.first.Unmap(), s.last.Unmap()
+		if s.first.Is4() != s.last.Is4() || s.last.Less(s.first) {
+			return span{}, fmt.Errorf("%s-%s is not a range", s.first, s.last)
+		}
+	default:
+		return span{}, fmt.Errorf("%d fields, want 2 or 3", len(rec))
+	}
+	s.country = strings.ToUpper(strings.TrimSpace(rec[len(rec)-1]))
+	if len(s.country) != 2 || strings.Trim(s.country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return span{}, fmt.Errorf("%q is not a two-letter country code", rec[len(rec)-1])
+	}
+	return s, nil
+}
+
+// lastOf is the highest address in p.
+func lastOf(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
+
+// Country never fails; "" is an address in none of db's ranges.
+func (db *DB) Country(_ context.Context, ip netip.Addr) (string, error) {
+	ip = ip.Unmap().WithZone("")
+	i, found := slices.BinarySearchFunc(db.spans, ip, func(s span, ip netip.Addr) int { return s.first.Compare(ip) })
+	if !found {
+		i--
+	}
+	if i < 0 || db.spans[i].last.Less(ip) {
+		return "", nil
+	}
+	return db.spans[i].country, nil
+}
+
+// Len is how many ranges db holds.
+func (db *DB) Len() int { return len(db.spans) }
+
+//go:embed testdata/countries.csv
+var testdata embed.FS
+
+// TestData is the test database's file, countries.csv.
+func TestData() fs.FS {
+	sub, _ := fs.Sub(testdata, "testdata")
+	return sub
+}
+
+var testDB = sync.OnceValue(func() *DB {
+	db, err := LoadFS(TestData(), "countries.csv")
+	if err != nil {
+		panic(err)
+	}
+	return db
+})
+
+// Test is the embedded test database, loaded on first use.
+func Test() *DB { return testDB() }
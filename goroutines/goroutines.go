@@ -0,0 +1,220 @@
+// Package goroutines takes the goroutine dump apart: it groups the
+// goroutines by the go statement that started them and, given two
+// samples, says which groups grew.
+//
+// A raw dump of a busy server is thousands of stacks; what a leak looks
+// like in it is one creation site, a worker pool's or an event bus
+// subscriber's, with more goroutines each time, all waiting on the same
+// channel for longer and longer. Grouped, that is one line:
+//
+//	312 (+48)  Go-Internals/eventbus.(*Bus).Subscribe at eventbus/bus.go:88  chan receive 312  oldest 17m
+//
+// How long a goroutine has been blocked is all the runtime says about its
+// age, and only once it is a minute or more, so ages are whole minutes
+// and a goroutine that keeps waking up looks young.
+package goroutines
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Goroutine is one goroutine of a dump.
+type Goroutine struct {
+	ID    int
+	State string
+	// Wait is how long it has been blocked, in whole minutes; 0 under a
+	// minute.
+	Wait time.Duration
+	// CreatedBy is the function with the go statement and Site its file
+	// and line; both empty for the main goroutine and the runtime's own.
+	CreatedBy string
+	Site      string
+	Stack     string
+}
+
+// Group is the goroutines started by one go statement.
+type Group struct {
+	CreatedBy string         `json:"created_by"`
+	Site      string         `json:"site"`
+	Count     int            `json:"count"`
+	States    map[string]int `json:"states"`
+	// Oldest is the longest any of them has been blocked.
+	Oldest time.Duration `json:"oldest"`
+	// Stack is the oldest one's.
+	Stack string `json:"stack,omitempty"`
+}
+
+// Key names the group's go statement.
+func (g Group) Key() string {
+	if g.CreatedBy == "" {
+		return "(not created by a go statement)"
+	}
+	return g.CreatedBy + " at " + g.Site
+}
+
+// Sample is the grouped goroutines at one moment, largest group first.
+type Sample struct {
+	At     time.Time `json:"at"`
+	Total  int       `json:"total"`
+	Groups []Group   `json:"groups"`
+}
+
+// Capture dumps and groups every goroutine. It stops the world for as
+// long as the dump takes, a millisecond or so per thousand goroutines.
+func Capture() Sample {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		if len(buf) >= 64<<20 {
+			// Truncated: the last stack is cut short, the rest are fine.
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	return Summarize(Parse(buf), time.Now())
+}
+
+// Parse reads a runtime.Stack(buf, true) or debug=2 pprof dump.
+func Parse(dump []byte) []Goroutine {
+	var out []Goroutine
+	for _, block := range bytes.Split(dump, []byte("\n\n")) {
+		if g, ok := parseOne(string(bytes.TrimSpace(block))); ok {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// parseOne reads one stack:
+//
+//	goroutine 7 [chan receive, 5 minutes]:
+//	Go-Internals/eventbus.(*Bus).loop(...)
+//		/src/eventbus/bus.go:120 +0x8c
+//	created by Go-Internals/eventbus.(*Bus).Subscribe in goroutine 1
+//		/src/eventbus/bus.go:88 +0x1d
+func parseOne(block string) (Goroutine, bool) {
+	header, rest, _ := strings.Cut(block, "\n")
+	idText, ok := strings.CutPrefix(header, "goroutine ")
+	if !ok {
+		return Goroutine{}, false
+	}
+	idText, status, ok := strings.Cut(idText, " [")
+	if !ok {
+		return Goroutine{}, false
+	}
+	id, err := strconv.Atoi(idText)
+	if err != nil {
+		return Goroutine{}, false
+	}
+	g := Goroutine{ID: id, Stack: block}
+	status = strings.TrimSuffix(status, "]:")
+	for i, part := range strings.Split(status, ", ") {
+		if i == 0 {
+			g.State = part
+			continue
+		}
+		if m, ok := strings.CutSuffix(part, " minutes"); ok {
+			if n, err := strconv.Atoi(m); err == nil {
+				g.Wait = time.Duration(n) * time.Minute
+			}
+		}
+	}
+
+	sc := bufio.NewScanner(strings.NewReader(rest))
+	for sc.Scan() {
+		fn, ok := strings.CutPrefix(sc.Text(), "created by ")
+		if !ok {
+			continue
+		}
+		fn, _, _ = strings.Cut(fn, " in goroutine ")
+		g.CreatedBy = fn
+		if sc.Scan() {
+			g.Site = site(sc.Text())
+		}
+	}
+	return g, true
+}
+
+// site cuts "\t/src/eventbus/bus.go:88 +0x1d" to "eventbus/bus.go:88".
+func site(line string) string {
+	line = strings.TrimSpace(line)
+	line, _, _ = strings.Cut(line, " +0x")
+	dir, file := filepath.Split(line)
+	return filepath.Join(filepath.Base(dir), file)
+}
+
+// Summarize groups goroutines by creation site.
+func Summarize(gs []Goroutine, at time.Time) Sample {
+	byKey := map[string]*Group{}
+	var order []*Group
+	for _, g := range gs {
+		key := g.CreatedBy + "\x00" + g.Site
+		grp := byKey[key]
+		if grp == nil {
+			grp = &Group{CreatedBy: g.CreatedBy, Site: g.Site, States: map[string]int{}, Stack: g.Stack, Oldest: g.Wait}
+			byKey[key] = grp
+			order = append(order, grp)
+		}
+		grp.Count++
+		grp.States[g.State]++
+		if g.Wait > grp.Oldest {
+			grp.Oldest, grp.Stack = g.Wait, g.Stack
+		}
+	}
+	s := Sample{At: at, Total: len(gs), Groups: make([]Group, len(order))}
+	for i, grp := range order {
+		s.Groups[i] = *grp
+	}
+	slices.SortStableFunc(s.Groups, func(a, b Group) int { return cmp.Compare(b.Count, a.Count) })
+	return s
+}
+
+// Change is one creation site's count in two samples.
+type Change struct {
+	CreatedBy string `json:"created_by"`
+	Site      string `json:"site"`
+	Before    int    `json:"before"`
+	After     int    `json:"after"`
+	Delta     int    `json:"delta"`
+}
+
+// Growth compares two samples, returning the sites whose count changed,
+// the most grown first. A site that only grows, sample after sample, is a
+// leak.
+func Growth(before, after Sample) []Change {
+	count := func(s Sample) map[[2]string]int {
+		m := map[[2]string]int{}
+		for _, g := range s.Groups {
+			m[[2]string{g.CreatedBy, g.Site}] = g.Count
+		}
+		return m
+	}
+	b, a := count(before), count(after)
+	var out []Change
+	for k, n := range a {
+		if n != b[k] {
+			out = append(out, Change{CreatedBy: k[0], Site: k[1], Before: b[k], After: n, Delta: n - b[k]})
+		}
+	}
+	for k, n := range b {
+		if _, ok := a[k]; !ok {
+			out = append(out, Change{CreatedBy: k[0], Site: k[1], Before: n, Delta: -n})
+		}
+	}
+	slices.SortFunc(out, func(x, y Change) int {
+		return cmp.Or(cmp.Compare(y.Delta, x.Delta), cmp.Compare(x.CreatedBy, y.CreatedBy), cmp.Compare(x.Site, y.Site))
+	})
+	return out
+}
@@ -0,0 +1,79 @@
+package goroutines
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxInterval bounds ?interval, which holds the request open.
+const maxInterval = time.Minute
+
+// Report is what Handler serves: a sample and how it differs from an
+// earlier one.
+type Report struct {
+	Sample
+	// Since is when the earlier sample was taken; zero without one.
+	Since  time.Time `json:"since,omitzero"`
+	Growth []Change  `json:"growth"`
+}
+
+// Handler serves a Report as JSON. With ?interval=10s (at most a minute)
+// it samples twice that far apart; without, the growth is since the
+// sample the previous request took, so polling the endpoint tracks a
+// leak. ?stacks=0 leaves the stacks out.
+func Handler() http.Handler {
+	var mu sync.Mutex
+	var last *Sample
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var interval time.Duration
+		if s := q.Get("interval"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d < 0 || d > maxInterval {
+				http.Error(w, "interval must be a duration up to "+maxInterval.String(), http.StatusBadRequest)
+				return
+			}
+			interval = d
+		}
+		if deadline, ok := r.Context().Deadline(); ok && time.Until(deadline) < interval {
+			http.Error(w, "interval is longer than the request's time budget", http.StatusBadRequest)
+			return
+		}
+
+		var before *Sample
+		if interval > 0 {
+			s := Capture()
+			before = &s
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		now := Capture()
+		mu.Lock()
+		if before == nil {
+			before = last
+		}
+		last = &now
+		mu.Unlock()
+
+		rep := Report{Sample: now, Growth: []Change{}}
+		if before != nil {
+			rep.Since = before.At
+			rep.Growth = append(rep.Growth, Growth(*before, now)...)
+		}
+		if stacks, err := strconv.ParseBool(q.Get("stacks")); err == nil && !stacks {
+			rep.Groups = append([]Group(nil), rep.Groups...)
+			for i := range rep.Groups {
+				rep.Groups[i].Stack = ""
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(rep)
+	})
+}
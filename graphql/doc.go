@@ -0,0 +1,27 @@
+// Package graphql executes GraphQL queries and mutations against a
+// schema built in Go.
+//
+// A schema is types and resolvers: Objects whose Fields each have a
+// Resolver, called with the parent's value and the field's coerced
+// arguments. New checks the types once; Execute (or ServeHTTP) parses a
+// document, validates all of it against the schema — unknown fields,
+// missing arguments, bad variables, fragment cycles, and depth and size
+// limits — and only then runs it, so a mistake in the last field of a
+// mutation cannot leave the first one half applied.
+//
+// Execution is breadth first. The fields of every object at one depth
+// are resolved before any of their values is completed, and a resolver
+// may answer with a Thunk rather than a value. A Loader uses that: Load
+// records a key and returns a Thunk, and the first Thunk forced fetches
+// every key recorded at that depth in one call. Fifty users asked for
+// by ID in one document are one repository call, not fifty (the N+1
+// problem resolvers otherwise have).
+//
+// Errors follow the spec: a resolver's error becomes an entry of errors
+// with its path, and the field is null; if the field is non-null, the
+// null moves up to the nearest nullable position.
+//
+// What is left out: interfaces, unions, subscriptions, custom
+// directives (only @skip and @include) and introspection. Schema.SDL
+// prints the schema for clients in place of introspection.
+package graphql
@@ -0,0 +1,842 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Request is a GraphQL request as clients send it.
+type Request struct {
+	Query         string         `json:"query" schema:"required"`
+	OperationName string         `json:"operationName,omitempty" schema:"nullable"`
+	Variables     map[string]any `json:"variables,omitempty" schema:"nullable"`
+}
+
+// Response is the result of a request. Data is absent when the request
+// was rejected before it ran, and null when a non-null root field failed.
+type Response struct {
+	Data   json.RawMessage `json:"data,omitempty"`
+	Errors []*Error        `json:"errors,omitempty"`
+}
+
+// Error is one entry of a Response's errors. Err is the resolver's error
+// behind a field error; request errors (syntax, validation) match
+// ErrInvalid instead.
+type Error struct {
+	Message   string     `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+	Path      []any      `json:"path,omitempty"`
+	Err       error      `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Locations) > 0 {
+		return e.Locations[0].String() + ": " + e.Message
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	if e.Err != nil {
+		return e.Err
+	}
+	if e.Path == nil {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func errorAt(loc Location, format string, args ...any) *Error {
+	return &Error{Message: fmt.Sprintf(format, args...), Locations: []Location{loc}}
+}
+
+// Execute runs the request's operation. It never returns nil.
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	return s.execute(ctx, req, true)
+}
+
+func (s *Schema) execute(ctx context.Context, req Request, mutations bool) *Response {
+	invalid := func(err error) *Response {
+		e, ok := err.(*Error)
+		if !ok {
+			e = &Error{Message: err.Error()}
+		}
+		return &Response{Errors: []*Error{e}}
+	}
+	doc, err := parse(req.Query)
+	if err != nil {
+		return invalid(err)
+	}
+	op, err := pickOperation(doc, req.OperationName)
+	if err != nil {
+		return invalid(err)
+	}
+	root := s.query
+	if op.kind == "mutation" {
+		if root = s.mutation; root == nil {
+			return invalid(errorAt(op.loc, "the schema has no mutations"))
+		}
+		if !mutations {
+			return invalid(errorAt(op.loc, "mutations are not allowed here"))
+		}
+	}
+
+	v := &validator{s: s, doc: doc, op: op, args: make(map[*fieldNode]map[string]any)}
+	v.variables(req.Variables)
+	if len(v.errs) == 0 {
+		v.selections(root, op.sel, 1, nil)
+	}
+	if len(v.errs) > 0 {
+		return &Response{Errors: v.errs}
+	}
+
+	if s.opts.Context != nil {
+		ctx = s.opts.Context(ctx)
+	}
+	e := &executor{s: s, ctx: ctx, doc: doc, vars: v.vars, args: v.args}
+	return e.run(root, op)
+}
+
+func pickOperation(doc *document, name string) (*operation, error) {
+	if name == "" {
+		if len(doc.ops) > 1 {
+			return nil, &Error{Message: "the document has several operations; name one with operationName"}
+		}
+		return doc.ops[0], nil
+	}
+	for _, op := range doc.ops {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, &Error{Message: fmt.Sprintf("no operation named %q", name)}
+}
+
+/*
+-----------------------------------
+VALIDATION
+-----------------------------------
+*/
+
+// validator checks an operation against the schema before anything runs,
+// and coerces every field's arguments on the way, so execution neither
+// fails on a typo halfway through a mutation nor coerces twice.
+type validator struct {
+	s      *Schema
+	doc    *document
+	op     *operation
+	vars   map[string]any // as sent, with defaults filled in
+	args   map[*fieldNode]map[string]any
+	fields int
+	errs   []*Error
+}
+
+func (v *validator) fail(loc Location, format string, args ...any) {
+	e := errorAt(loc, format, args...)
+	for _, prev := range v.errs {
+		if prev.Message == e.Message && prev.Locations[0] == loc {
+			return
+		}
+	}
+	v.errs = append(v.errs, e)
+}
+
+func (v *validator) variables(given map[string]any) {
+	v.vars = make(map[string]any, len(v.op.vars))
+	for _, d := range v.op.vars {
+		if _, dup := v.vars[d.name]; dup {
+			v.fail(d.loc, "variable $%s is defined twice", d.name)
+			continue
+		}
+		t, err := v.resolveType(d.typ)
+		if err != nil {
+			v.fail(d.loc, "variable $%s: %v", d.name, err)
+			continue
+		}
+		val, ok := given[d.name]
+		if !ok && d.def != nil {
+			val, ok = literal(d.def, nil), true
+		}
+		if !ok {
+			if _, required := t.(*NonNull); required {
+				v.fail(d.loc, "variable $%s of type %s was not provided", d.name, t)
+			}
+			continue
+		}
+		// Checked here so the error names the variable; the arguments
+		// that use it coerce the value as sent.
+		if _, err := coerce(t, val); err != nil {
+			v.fail(d.loc, "variable $%s: %v", d.name, err)
+			continue
+		}
+		v.vars[d.name] = val
+	}
+}
+
+func (v *validator) resolveType(r *typeRef) (Type, error) {
+	var t Type
+	if r.elem != nil {
+		elem, err := v.resolveType(r.elem)
+		if err != nil {
+			return nil, err
+		}
+		t = ListOf(elem)
+	} else {
+		named, ok := v.s.types[r.name]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %s", r.name)
+		}
+		if !isInput(named) {
+			return nil, fmt.Errorf("%s is not an input type", r.name)
+		}
+		t = named
+	}
+	if r.nonNull {
+		t = NonNullOf(t)
+	}
+	return t, nil
+}
+
+func (v *validator) declared(name string) bool {
+	for _, d := range v.op.vars {
+		if d.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkVars reports variables a value uses but the operation does not
+// define.
+func (v *validator) checkVars(val valueNode) {
+	switch val := val.(type) {
+	case *varValue:
+		if !v.declared(val.name) {
+			v.fail(val.loc, "variable $%s is not defined", val.name)
+		}
+	case *listValue:
+		for _, item := range val.items {
+			v.checkVars(item)
+		}
+	case *objectValue:
+		for _, f := range val.fields {
+			v.checkVars(f.val)
+		}
+	}
+}
+
+// selections checks sel against t. frags is the chain of fragments being
+// expanded, to catch cycles.
+func (v *validator) selections(t *Object, sel []selection, depth int, frags []string) {
+	if depth > v.s.opts.MaxDepth {
+		v.fail(sel[0].location(), "selections nest deeper than %d", v.s.opts.MaxDepth)
+		return
+	}
+	seen := make(map[string]*fieldNode)
+	v.collectChecked(t, sel, depth, frags, seen)
+}
+
+func (v *validator) collectChecked(t *Object, sel []selection, depth int, frags []string, seen map[string]*fieldNode) {
+	for _, s := range sel {
+		switch s := s.(type) {
+		case *fieldNode:
+			v.directives(s.dirs)
+			v.field(t, s, depth, frags, seen)
+
+		case *spreadNode:
+			v.directives(s.dirs)
+			f, ok := v.doc.frags[s.name]
+			if !ok {
+				v.fail(s.loc, "unknown fragment %q", s.name)
+				continue
+			}
+			if slices.Contains(frags, s.name) {
+				v.fail(s.loc, "fragment %q spreads itself", s.name)
+				continue
+			}
+			if !v.typeCondition(t, f.typeCond, s.loc) {
+				continue
+			}
+			v.directives(f.dirs)
+			v.collectChecked(t, f.sel, depth, append(frags, s.name), seen)
+
+		case *inlineNode:
+			v.directives(s.dirs)
+			if s.typeCond != "" && !v.typeCondition(t, s.typeCond, s.loc) {
+				continue
+			}
+			v.collectChecked(t, s.sel, depth, frags, seen)
+		}
+	}
+}
+
+// typeCondition: every type is an object type, so a fragment applies
+// only to the type it names.
+func (v *validator) typeCondition(t *Object, cond string, loc Location) bool {
+	named, ok := v.s.types[cond]
+	switch {
+	case !ok:
+		v.fail(loc, "unknown type %s", cond)
+	case named != Type(t):
+		v.fail(loc, "a fragment on %s cannot be spread where %s is selected", cond, t.Name)
+	default:
+		return true
+	}
+	return false
+}
+
+func (v *validator) field(t *Object, f *fieldNode, depth int, frags []string, seen map[string]*fieldNode) {
+	if v.fields++; v.fields == v.s.opts.MaxFields+1 {
+		v.fail(f.loc, "the operation selects more than %d fields", v.s.opts.MaxFields)
+	}
+	if f.name == "__typename" {
+		if len(f.args) > 0 || f.sel != nil {
+			v.fail(f.loc, "__typename takes no arguments or selections")
+		}
+		v.merge(f, seen)
+		return
+	}
+	def := t.field(f.name)
+	if def == nil {
+		v.fail(f.loc, "type %s has no field %q", t.Name, f.name)
+		return
+	}
+	if _, done := v.args[f]; !done {
+		v.args[f] = v.arguments(def.Args, f.args, t.Name+"."+f.name, f.loc)
+	}
+	v.merge(f, seen)
+
+	switch {
+	case isLeaf(def.Type) && f.sel != nil:
+		v.fail(f.loc, "%s.%s is a %s and has no fields to select", t.Name, f.name, def.Type)
+	case !isLeaf(def.Type) && f.sel == nil:
+		v.fail(f.loc, "%s.%s is a %s; select its fields", t.Name, f.name, def.Type)
+	case !isLeaf(def.Type):
+		v.selections(unwrap(def.Type).(*Object), f.sel, depth+1, frags)
+	}
+}
+
+// merge rejects two selections of one response key that would answer
+// differently: different fields, or the same field with other arguments.
+func (v *validator) merge(f *fieldNode, seen map[string]*fieldNode) {
+	prev, ok := seen[f.alias]
+	if !ok {
+		seen[f.alias] = f
+		return
+	}
+	if prev.name != f.name || !reflect.DeepEqual(v.args[prev], v.args[f]) {
+		v.fail(f.loc, "%q selects both %s and %s; use an alias", f.alias, fieldLabel(prev), fieldLabel(f))
+	}
+}
+
+func fieldLabel(f *fieldNode) string {
+	if len(f.args) == 0 {
+		return f.name
+	}
+	return f.name + "(...) at " + f.loc.String()
+}
+
+// arguments coerces the given arguments to defs. An argument set to a
+// variable that was not provided counts as not given, so its default
+// applies.
+func (v *validator) arguments(defs []*Arg, given []*argNode, owner string, loc Location) map[string]any {
+	out := make(map[string]any, len(defs))
+	byName := make(map[string]*argNode, len(given))
+	for _, a := range given {
+		if _, dup := byName[a.name]; dup {
+			v.fail(a.loc, "argument %q is given twice", a.name)
+		}
+		byName[a.name] = a
+		v.checkVars(a.val)
+		if !slices.ContainsFunc(defs, func(d *Arg) bool { return d.Name == a.name }) {
+			v.fail(a.loc, "%s has no argument %q", owner, a.name)
+		}
+	}
+	for _, d := range defs {
+		var raw any
+		present := false
+		if a, ok := byName[d.Name]; ok {
+			present = true
+			if vv, isVar := a.val.(*varValue); isVar {
+				raw, present = v.vars[vv.name]
+			} else {
+				raw = literal(a.val, v.vars)
+			}
+		}
+		if !present && d.Default != nil {
+			raw, present = d.Default, true
+		}
+		if !present {
+			if _, required := d.Type.(*NonNull); required {
+				v.fail(loc, "%s requires argument %q of type %s", owner, d.Name, d.Type)
+			}
+			continue
+		}
+		val, err := coerce(d.Type, raw)
+		if err != nil {
+			v.fail(loc, "%s argument %q: %v", owner, d.Name, err)
+			continue
+		}
+		out[d.Name] = val
+	}
+	return out
+}
+
+var ifArg = []*Arg{{Name: "if", Type: NonNullOf(Boolean)}}
+
+func (v *validator) directives(dirs []*directive) {
+	for _, d := range dirs {
+		if d.name != "skip" && d.name != "include" {
+			v.fail(d.loc, "unknown directive @%s", d.name)
+			continue
+		}
+		v.arguments(ifArg, d.args, "@"+d.name, d.loc)
+	}
+}
+
+/*
+-----------------------------------
+INPUT COERCION
+-----------------------------------
+*/
+
+// coerce converts an input value (from literal or variables) to what
+// resolvers receive for type t.
+func coerce(t Type, v any) (any, error) {
+	if nn, ok := t.(*NonNull); ok {
+		if v == nil {
+			return nil, fmt.Errorf("expected a non-null %s", nn.Of)
+		}
+		t = nn.Of
+	}
+	if v == nil {
+		return nil, nil
+	}
+	switch t := t.(type) {
+	case *List:
+		items, ok := v.([]any)
+		if !ok {
+			// A single value is accepted where a list goes.
+			item, err := coerce(t.Of, v)
+			if err != nil {
+				return nil, err
+			}
+			return []any{item}, nil
+		}
+		out := make([]any, len(items))
+		for i, item := range items {
+			c, err := coerce(t.Of, item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %w", i, err)
+			}
+			out[i] = c
+		}
+		return out, nil
+
+	case *Scalar:
+		if _, isEnum := v.(enumValue); isEnum {
+			return nil, fmt.Errorf("expected a %s, found %s", t.Name, describe(v))
+		}
+		return t.ParseValue(numberOf(v))
+
+	case *Enum:
+		var name string
+		switch e := v.(type) {
+		case enumValue:
+			name = string(e)
+		case string:
+			name = e // from variables, where enums are strings
+		default:
+			return nil, fmt.Errorf("expected a %s, found %s", t.Name, describe(v))
+		}
+		if !slices.Contains(t.Values, name) {
+			return nil, fmt.Errorf("%s is not a value of %s", name, t.Name)
+		}
+		return name, nil
+
+	case *InputObject:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("expected a %s object, found %s", t.Name, describe(v))
+		}
+		out := make(map[string]any, len(t.Fields))
+		for k := range m {
+			if !slices.ContainsFunc(t.Fields, func(f *Arg) bool { return f.Name == k }) {
+				return nil, fmt.Errorf("%s has no field %q", t.Name, k)
+			}
+		}
+		for _, f := range t.Fields {
+			raw, present := m[f.Name]
+			if !present && f.Default != nil {
+				raw, present = f.Default, true
+			}
+			if !present {
+				if _, required := f.Type.(*NonNull); required {
+					return nil, fmt.Errorf("%s.%s is required", t.Name, f.Name)
+				}
+				continue
+			}
+			c, err := coerce(f.Type, raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", t.Name, f.Name, err)
+			}
+			out[f.Name] = c
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("%s is not an input type", t)
+}
+
+// numberOf lets Go numbers in Arg.Default reach ParseValue in the form
+// literals and variables have.
+func numberOf(v any) any {
+	switch n := v.(type) {
+	case int:
+		return json.Number(strconv.Itoa(n))
+	case int64:
+		return json.Number(strconv.FormatInt(n, 10))
+	case float64:
+		return json.Number(strconv.FormatFloat(n, 'g', -1, 64))
+	}
+	return v
+}
+
+/*
+-----------------------------------
+EXECUTION
+-----------------------------------
+*/
+
+// object is a response object; it keeps keys in selection order, which
+// a map would lose.
+type object struct {
+	keys []string
+	vals map[string]any
+}
+
+func (o *object) set(k string, v any) {
+	if _, ok := o.vals[k]; !ok {
+		o.keys = append(o.keys, k)
+	}
+	o.vals[k] = v
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		b.Write(key)
+		b.WriteByte(':')
+		val, err := json.Marshal(o.vals[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(val)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// node is an object or list in the response, where a field or item is
+// written, with what is needed to null it when a non-null position
+// inside it fails: the error propagates up to the nearest nullable
+// position.
+type node struct {
+	obj     *object
+	list    []any
+	up      *node
+	key     any  // this node's key (string) or index (int) in up
+	nonNull bool // this node's own position is non-null
+	dead    bool
+}
+
+func (n *node) put(key, v any) {
+	if n.obj != nil {
+		n.obj.set(key.(string), v)
+	} else {
+		n.list[key.(int)] = v
+	}
+}
+
+func (n *node) alive() bool {
+	for ; n != nil; n = n.up {
+		if n.dead {
+			return false
+		}
+	}
+	return true
+}
+
+// job is a field or list item waiting for its value to be completed.
+type job struct {
+	at    *node
+	key   any
+	path  []any
+	typ   Type
+	nodes []*fieldNode // the selections merged into this response key
+	value any          // possibly a Thunk
+	err   error
+}
+
+type executor struct {
+	s        *Schema
+	ctx      context.Context
+	doc      *document
+	vars     map[string]any
+	args     map[*fieldNode]map[string]any
+	errs     []*Error
+	dataNull bool
+}
+
+// run executes breadth first. Each round resolves the fields of every
+// object at one depth, then forces their thunks, then completes them,
+// which resolves the next depth's fields: a Loader sees the keys of a
+// whole depth before the first of them is needed. Mutation fields run
+// one after another, each to completion, as the spec requires.
+func (e *executor) run(root *Object, op *operation) *Response {
+	top := &node{obj: &object{vals: map[string]any{}}}
+	groups := e.collect(root, [][]selection{op.sel})
+	if op.kind == "mutation" {
+		for _, g := range groups {
+			e.drain(e.resolveFields(top, root, e.s.opts.Root, []group{g}, nil))
+		}
+	} else {
+		e.drain(e.resolveFields(top, root, e.s.opts.Root, groups, nil))
+	}
+
+	resp := &Response{Errors: e.errs, Data: json.RawMessage("null")}
+	if !e.dataNull {
+		data, err := json.Marshal(top.obj)
+		if err != nil {
+			resp.Errors = append(resp.Errors, &Error{Message: err.Error(), Path: []any{}})
+		} else {
+			resp.Data = data
+		}
+	}
+	return resp
+}
+
+func (e *executor) drain(queue []job) {
+	for len(queue) > 0 {
+		for i := range queue {
+			j := &queue[i]
+			if th, ok := j.value.(Thunk); ok && j.err == nil && j.at.alive() {
+				j.value, j.err = th()
+			}
+		}
+		var next []job
+		for _, j := range queue {
+			if !j.at.alive() {
+				continue
+			}
+			if j.err != nil {
+				e.fieldError(j, j.err)
+				continue
+			}
+			e.complete(j, j.value, &next)
+		}
+		queue = next
+	}
+}
+
+func (e *executor) fieldError(j job, err error) {
+	e.errs = append(e.errs, &Error{
+		Message:   e.s.opts.ErrorMessage(e.ctx, err),
+		Locations: []Location{j.nodes[0].loc},
+		Path:      j.path,
+		Err:       err,
+	})
+	j.at.put(j.key, nil)
+	if _, required := j.typ.(*NonNull); required {
+		e.nullify(j.at)
+	}
+}
+
+// nullify sets n's own position to null, and keeps going up while that
+// position is non-null too.
+func (e *executor) nullify(n *node) {
+	for n != nil {
+		n.dead = true
+		if n.up == nil {
+			e.dataNull = true
+			return
+		}
+		n.up.put(n.key, nil)
+		if !n.nonNull {
+			return
+		}
+		n = n.up
+	}
+}
+
+func (e *executor) complete(j job, v any, next *[]job) {
+	if th, ok := v.(Thunk); ok {
+		j.value = th
+		*next = append(*next, j)
+		return
+	}
+	t := j.typ
+	nonNull := false
+	if nn, ok := t.(*NonNull); ok {
+		t, nonNull = nn.Of, true
+	}
+	if isNil(v) {
+		if nonNull {
+			e.fieldError(j, fmt.Errorf("%v resolved to null but is %s", pathString(j.path), j.typ))
+			return
+		}
+		j.at.put(j.key, nil)
+		return
+	}
+
+	switch t := t.(type) {
+	case *List:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.fieldError(j, fmt.Errorf("%v resolved to a %T, not a list", pathString(j.path), v))
+			return
+		}
+		n := &node{list: make([]any, rv.Len()), up: j.at, key: j.key, nonNull: nonNull}
+		j.at.put(j.key, n.list)
+		for i := range rv.Len() {
+			item := j
+			item.at, item.key, item.typ = n, i, t.Of
+			item.path = append(slices.Clip(j.path), i)
+			e.complete(item, rv.Index(i).Interface(), next)
+		}
+
+	case *Scalar:
+		out, err := t.Serialize(v)
+		if err != nil {
+			e.fieldError(j, err)
+			return
+		}
+		j.at.put(j.key, out)
+
+	case *Enum:
+		s, ok := v.(string)
+		if !ok || !slices.Contains(t.Values, s) {
+			e.fieldError(j, fmt.Errorf("%v is not a value of %s", v, t.Name))
+			return
+		}
+		j.at.put(j.key, s)
+
+	case *Object:
+		n := &node{obj: &object{vals: map[string]any{}}, up: j.at, key: j.key, nonNull: nonNull}
+		j.at.put(j.key, n.obj)
+		sub := make([][]selection, len(j.nodes))
+		for i, f := range j.nodes {
+			sub[i] = f.sel
+		}
+		*next = append(*next, e.resolveFields(n, t, v, e.collect(t, sub), j.path)...)
+	}
+}
+
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func:
+		return rv.IsNil()
+	}
+	return false
+}
+
+func pathString(path []any) string {
+	parts := make([]string, len(path))
+	for i, p := range path {
+		parts[i] = fmt.Sprint(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// resolveFields calls the resolvers of an object's selected fields and
+// returns their jobs; the response keys are reserved now so the object
+// keeps selection order.
+func (e *executor) resolveFields(n *node, t *Object, source any, groups []group, path []any) []job {
+	jobs := make([]job, 0, len(groups))
+	for _, g := range groups {
+		f := g.nodes[0]
+		if f.name == "__typename" {
+			n.put(g.key, t.Name)
+			continue
+		}
+		def := t.field(f.name)
+		n.put(g.key, nil)
+		j := job{at: n, key: g.key, path: append(slices.Clip(path), g.key), typ: def.Type, nodes: g.nodes}
+		if def.Resolve != nil {
+			j.value, j.err = def.Resolve(e.ctx, Params{Source: source, Args: e.args[f]})
+		} else if m, ok := source.(map[string]any); ok {
+			j.value = m[def.Name]
+		} else {
+			j.err = fmt.Errorf("%s.%s has no resolver for a %T", t.Name, def.Name, source)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+// group is one response key and the field selections merged into it.
+type group struct {
+	key   string
+	nodes []*fieldNode
+}
+
+// collect flattens selection sets (fragments expanded, @skip and
+// @include applied) into response keys in order. Validation has already
+// checked every name and type condition.
+func (e *executor) collect(t *Object, sets [][]selection) []group {
+	var groups []group
+	index := map[string]int{}
+	var walk func(sel []selection)
+	walk = func(sel []selection) {
+		for _, s := range sel {
+			switch s := s.(type) {
+			case *fieldNode:
+				if !e.included(s.dirs) {
+					continue
+				}
+				if i, ok := index[s.alias]; ok {
+					groups[i].nodes = append(groups[i].nodes, s)
+					continue
+				}
+				index[s.alias] = len(groups)
+				groups = append(groups, group{key: s.alias, nodes: []*fieldNode{s}})
+			case *spreadNode:
+				f := e.doc.frags[s.name]
+				if e.included(s.dirs) && e.included(f.dirs) {
+					walk(f.sel)
+				}
+			case *inlineNode:
+				if e.included(s.dirs) {
+					walk(s.sel)
+				}
+			}
+		}
+	}
+	for _, sel := range sets {
+		walk(sel)
+	}
+	return groups
+}
+
+func (e *executor) included(dirs []*directive) bool {
+	for _, d := range dirs {
+		cond, _ := literal(d.args[0].val, e.vars).(bool)
+		if d.name == "skip" && cond || d.name == "include" && !cond {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package graphql
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+/*
+-----------------------------------
+HTTP
+-----------------------------------
+*/
+
+// maxBody bounds a POSTed request.
+const maxBody = 1 << 20
+
+// ServeHTTP answers GraphQL over HTTP: a POST with a JSON Request, or a
+// GET with query, operationName and variables (JSON) in the URL. A GET
+// may only run a query, since it must not change anything.
+//
+// The status is 200 whenever the operation ran, field errors or not; a
+// request rejected before running (bad JSON, syntax, validation) is 400.
+func (s *Schema) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if vars := q.Get("variables"); vars != "" {
+			if err := decode(strings.NewReader(vars), &req.Variables); err != nil {
+				writeResponse(w, http.StatusBadRequest, &Response{Errors: []*Error{{Message: "variables: " + err.Error()}}})
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := decode(http.MaxBytesReader(w, r.Body, maxBody), &req); err != nil {
+			writeResponse(w, http.StatusBadRequest, &Response{Errors: []*Error{{Message: "request body: " + err.Error()}}})
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Query == "" {
+		writeResponse(w, http.StatusBadRequest, &Response{Errors: []*Error{{Message: "no query"}}})
+		return
+	}
+
+	resp := s.execute(r.Context(), req, r.Method == http.MethodPost)
+	status := http.StatusOK
+	if resp.Data == nil {
+		status = http.StatusBadRequest
+	}
+	writeResponse(w, status, resp)
+}
+
+// decode keeps numbers as json.Number, the form an Int or ID argument
+// is coerced from.
+func decode(r io.Reader, v any) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp *Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,286 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+-----------------------------------
+LEXER
+-----------------------------------
+*/
+
+type tokenKind int
+
+const (
+	tEOF tokenKind = iota
+	tName
+	tInt
+	tFloat
+	tString
+	tPunct
+)
+
+// Location is a line and column in the query document, both from 1.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (l Location) String() string { return fmt.Sprintf("%d:%d", l.Line, l.Column) }
+
+type token struct {
+	kind tokenKind
+	text string // the name, number or punctuator; a string's decoded value
+	loc  Location
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tEOF:
+		return "end of document"
+	case tString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// lex splits a document into tokens. Commas are insignificant in GraphQL
+// and are dropped with whitespace and # comments.
+func lex(src string) ([]token, error) {
+	var toks []token
+	line, lineStart := 1, 0
+	i := 0
+	errAt := func(at int, format string, args ...any) error {
+		return &Error{Message: "syntax error: " + fmt.Sprintf(format, args...),
+			Locations: []Location{{line, at - lineStart + 1}}}
+	}
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\n':
+			i++
+			line, lineStart = line+1, i
+			continue
+		case c == '\r':
+			i++
+			if i < len(src) && src[i] == '\n' {
+				i++
+			}
+			line, lineStart = line+1, i
+			continue
+		case c == ' ' || c == '\t' || c == ',':
+			i++
+			continue
+		case strings.HasPrefix(src[i:], "\uFEFF"):
+			i += len("\uFEFF")
+			continue
+		case c == '#':
+			for i < len(src) && src[i] != '\n' && src[i] != '\r' {
+				i++
+			}
+			continue
+		}
+
+		loc := Location{line, i - lineStart + 1}
+		switch {
+		case isNameStart(c):
+			j := i + 1
+			for j < len(src) && (isNameStart(src[j]) || isDigit(src[j])) {
+				j++
+			}
+			toks = append(toks, token{tName, src[i:j], loc})
+			i = j
+
+		case c == '-' || isDigit(c):
+			j, kind, err := scanNumber(src, i)
+			if err != "" {
+				return nil, errAt(j, "%s", err)
+			}
+			toks = append(toks, token{kind, src[i:j], loc})
+			i = j
+
+		case strings.HasPrefix(src[i:], `"""`):
+			j := i + 3
+			var raw strings.Builder
+			for {
+				if j >= len(src) {
+					return nil, errAt(i, "unterminated block string")
+				}
+				if strings.HasPrefix(src[j:], `\"""`) {
+					raw.WriteString(`"""`)
+					j += 4
+					continue
+				}
+				if strings.HasPrefix(src[j:], `"""`) {
+					j += 3
+					break
+				}
+				if src[j] == '\n' {
+					line, lineStart = line+1, j+1
+				}
+				raw.WriteByte(src[j])
+				j++
+			}
+			toks = append(toks, token{tString, blockString(raw.String()), loc})
+			i = j
+
+		case c == '"':
+			s, j, err := scanString(src, i)
+			if err != "" {
+				return nil, errAt(j, "%s", err)
+			}
+			toks = append(toks, token{tString, s, loc})
+			i = j
+
+		case strings.HasPrefix(src[i:], "..."):
+			toks = append(toks, token{tPunct, "...", loc})
+			i += 3
+
+		case strings.IndexByte("!$&()=:@[]{}|", c) >= 0:
+			toks = append(toks, token{tPunct, string(c), loc})
+			i++
+
+		default:
+			r, _ := utf8.DecodeRuneInString(src[i:])
+			return nil, errAt(i, "unexpected character %q", r)
+		}
+	}
+	return append(toks, token{tEOF, "", Location{line, i - lineStart + 1}}), nil
+}
+
+func isNameStart(c byte) bool { return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' }
+func isDigit(c byte) bool     { return '0' <= c && c <= '9' }
+
+// scanNumber reads an IntValue or FloatValue starting at i. A number may
+// not be followed directly by a name start or a dot ("1x", "1.2.3").
+func scanNumber(src string, i int) (int, tokenKind, string) {
+	j := i
+	if src[j] == '-' {
+		j++
+	}
+	if j >= len(src) || !isDigit(src[j]) {
+		return j, 0, "expected a digit after -"
+	}
+	if src[j] == '0' && j+1 < len(src) && isDigit(src[j+1]) {
+		return j, 0, "leading zero in number"
+	}
+	digits := func() bool {
+		start := j
+		for j < len(src) && isDigit(src[j]) {
+			j++
+		}
+		return j > start
+	}
+	digits()
+	kind := tInt
+	if j < len(src) && src[j] == '.' {
+		j++
+		if !digits() {
+			return j, 0, "expected a digit after ."
+		}
+		kind = tFloat
+	}
+	if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+		j++
+		if j < len(src) && (src[j] == '+' || src[j] == '-') {
+			j++
+		}
+		if !digits() {
+			return j, 0, "expected a digit in exponent"
+		}
+		kind = tFloat
+	}
+	if j < len(src) && (isNameStart(src[j]) || src[j] == '.') {
+		return j, 0, fmt.Sprintf("unexpected %q after number", src[j])
+	}
+	return j, kind, ""
+}
+
+// scanString decodes the quoted string at i and returns the offset after
+// its closing quote.
+func scanString(src string, i int) (string, int, string) {
+	var b strings.Builder
+	j := i + 1
+	for {
+		if j >= len(src) || src[j] == '\n' || src[j] == '\r' {
+			return "", j, "unterminated string"
+		}
+		c := src[j]
+		if c == '"' {
+			return b.String(), j + 1, ""
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			j++
+			continue
+		}
+		if j+1 >= len(src) {
+			return "", j, "unterminated string"
+		}
+		switch e := src[j+1]; e {
+		case '"', '\\', '/':
+			b.WriteByte(e)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if j+6 > len(src) {
+				return "", j, "bad unicode escape"
+			}
+			n, err := strconv.ParseUint(src[j+2:j+6], 16, 32)
+			if err != nil {
+				return "", j, "bad unicode escape"
+			}
+			b.WriteRune(rune(n))
+			j += 4
+		default:
+			return "", j, fmt.Sprintf("bad escape \\%c", e)
+		}
+		j += 2
+	}
+}
+
+// blockString applies the spec's block string rules: the common
+// indentation of all lines but the first is removed, as are leading and
+// trailing blank lines.
+func blockString(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	indent := -1
+	for _, l := range lines[1:] {
+		trimmed := strings.TrimLeft(l, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(l) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	if indent > 0 {
+		for k := 1; k < len(lines); k++ {
+			if len(lines[k]) >= indent {
+				lines[k] = lines[k][indent:]
+			} else {
+				lines[k] = ""
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
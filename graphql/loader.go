@@ -0,0 +1,99 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+-----------------------------------
+LOADER
+-----------------------------------
+*/
+
+// Loader batches lookups by key. Load only records the key and returns a
+// Thunk; the first Thunk forced fetches every key recorded so far in one
+// call. Since the executor forces thunks only after a whole depth has
+// been resolved, a hundred `user(id:)` fields cost one fetch, not a
+// hundred.
+//
+// A Loader also caches what it fetched, so it belongs to one request:
+// create it in Options.Context. Prime and Clear keep the cache right
+// after a mutation.
+type Loader[K comparable, V any] struct {
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	pending []K
+	queued  map[K]bool
+	results map[K]loaded[V]
+	batches int
+}
+
+type loaded[V any] struct {
+	val   V
+	found bool
+	err   error
+}
+
+// NewLoader returns a Loader over fetch, which returns the values it
+// found; a key missing from its map resolves to null. An error from
+// fetch fails every key of that batch.
+func NewLoader[K comparable, V any](fetch func(ctx context.Context, keys []K) (map[K]V, error)) *Loader[K, V] {
+	return &Loader[K, V]{fetch: fetch, queued: make(map[K]bool), results: make(map[K]loaded[V])}
+}
+
+// Load returns a Thunk resolving to key's value, or nil if there is none.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) Thunk {
+	l.mu.Lock()
+	if _, done := l.results[key]; !done && !l.queued[key] {
+		l.queued[key] = true
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+	return func() (any, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		r, done := l.results[key]
+		if !done {
+			l.dispatchLocked(ctx)
+			r = l.results[key]
+		}
+		if r.err != nil || !r.found {
+			return nil, r.err
+		}
+		return r.val, nil
+	}
+}
+
+func (l *Loader[K, V]) dispatchLocked(ctx context.Context) {
+	keys := l.pending
+	l.pending, l.queued = nil, make(map[K]bool)
+	l.batches++
+	found, err := l.fetch(ctx, keys)
+	for _, k := range keys {
+		v, ok := found[k]
+		l.results[k] = loaded[V]{val: v, found: ok && err == nil, err: err}
+	}
+}
+
+// Prime stores a value, as a mutation that just wrote it knows it.
+func (l *Loader[K, V]) Prime(key K, v V) {
+	l.mu.Lock()
+	l.results[key] = loaded[V]{val: v, found: true}
+	l.mu.Unlock()
+}
+
+// Clear forgets key, so the next Load fetches it again.
+func (l *Loader[K, V]) Clear(key K) {
+	l.mu.Lock()
+	delete(l.results, key)
+	l.mu.Unlock()
+}
+
+// Batches is how many fetches the Loader has made.
+func (l *Loader[K, V]) Batches() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.batches
+}
@@ -0,0 +1,435 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+/*
+-----------------------------------
+DOCUMENT
+-----------------------------------
+*/
+
+type document struct {
+	ops   []*operation
+	frags map[string]*fragment
+}
+
+type operation struct {
+	kind string // "query" or "mutation"
+	name string
+	vars []*varDef
+	sel  []selection
+	loc  Location
+}
+
+type varDef struct {
+	name string
+	typ  *typeRef
+	def  valueNode // nil without a default
+	loc  Location
+}
+
+// typeRef is a type as written in a variable definition: a name, or a
+// list of another typeRef, possibly non-null.
+type typeRef struct {
+	name    string
+	elem    *typeRef
+	nonNull bool
+}
+
+func (t *typeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+type fragment struct {
+	name     string
+	typeCond string
+	dirs     []*directive
+	sel      []selection
+	loc      Location
+}
+
+type selection interface{ location() Location }
+
+type fieldNode struct {
+	alias string // the response key; the name if there is no alias
+	name  string
+	args  []*argNode
+	dirs  []*directive
+	sel   []selection
+	loc   Location
+}
+
+type spreadNode struct {
+	name string
+	dirs []*directive
+	loc  Location
+}
+
+type inlineNode struct {
+	typeCond string // "" applies to any type
+	dirs     []*directive
+	sel      []selection
+	loc      Location
+}
+
+func (f *fieldNode) location() Location  { return f.loc }
+func (f *spreadNode) location() Location { return f.loc }
+func (f *inlineNode) location() Location { return f.loc }
+
+type argNode struct {
+	name string
+	val  valueNode
+	loc  Location
+}
+
+type directive struct {
+	name string
+	args []*argNode
+	loc  Location
+}
+
+// A valueNode is a literal as written in the document.
+type valueNode interface{ location() Location }
+
+type (
+	varValue struct {
+		name string
+		loc  Location
+	}
+	// scalarValue is an Int, Float, String, Boolean, null or enum literal.
+	scalarValue struct {
+		kind tokenKind // tInt, tFloat, tString, or tName for true/false/null/enums
+		text string
+		loc  Location
+	}
+	listValue struct {
+		items []valueNode
+		loc   Location
+	}
+	objectValue struct {
+		fields []*argNode
+		loc    Location
+	}
+)
+
+func (v *varValue) location() Location    { return v.loc }
+func (v *scalarValue) location() Location { return v.loc }
+func (v *listValue) location() Location   { return v.loc }
+func (v *objectValue) location() Location { return v.loc }
+
+// enumValue is an enum literal once a valueNode has been turned into an
+// input value: distinct from a string, which an enum does not accept as a
+// literal.
+type enumValue string
+
+/*
+-----------------------------------
+PARSER
+-----------------------------------
+*/
+
+// parse reads an executable document: operations and fragments. Type
+// system definitions (schemas written in SDL) are not accepted; the
+// schema is built in Go.
+func parse(src string) (*document, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	doc := &document{frags: make(map[string]*fragment)}
+	err = p.catch(func() {
+		for p.peek().kind != tEOF {
+			t := p.peek()
+			switch {
+			case t.kind == tPunct && t.text == "{":
+				doc.ops = append(doc.ops, &operation{kind: "query", sel: p.selectionSet(), loc: t.loc})
+			case t.kind == tName && (t.text == "query" || t.text == "mutation"):
+				doc.ops = append(doc.ops, p.operation())
+			case t.kind == tName && t.text == "subscription":
+				p.failAt(t.loc, "subscriptions are not supported")
+			case t.kind == tName && t.text == "fragment":
+				f := p.fragment()
+				if _, dup := doc.frags[f.name]; dup {
+					p.failAt(f.loc, "fragment %q is defined twice", f.name)
+				}
+				doc.frags[f.name] = f
+			default:
+				p.failAt(t.loc, "unexpected %s", t)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.ops) == 0 {
+		return nil, &Error{Message: "the document has no operation"}
+	}
+	return doc, nil
+}
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+// syntaxError unwinds the parser; catch turns it back into an error.
+type syntaxError struct{ err *Error }
+
+func (p *parser) catch(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(syntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = se.err
+		}
+	}()
+	f()
+	return nil
+}
+
+func (p *parser) failAt(loc Location, format string, args ...any) {
+	panic(syntaxError{&Error{Message: "syntax error: " + fmt.Sprintf(format, args...), Locations: []Location{loc}}})
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) is(punct string) bool {
+	t := p.peek()
+	return t.kind == tPunct && t.text == punct
+}
+
+func (p *parser) skip(punct string) bool {
+	if p.is(punct) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(punct string) token {
+	t := p.next()
+	if t.kind != tPunct || t.text != punct {
+		p.failAt(t.loc, "expected %q, found %s", punct, t)
+	}
+	return t
+}
+
+func (p *parser) name() token {
+	t := p.next()
+	if t.kind != tName {
+		p.failAt(t.loc, "expected a name, found %s", t)
+	}
+	return t
+}
+
+func (p *parser) operation() *operation {
+	kw := p.next()
+	op := &operation{kind: kw.text, loc: kw.loc}
+	if p.peek().kind == tName {
+		op.name = p.next().text
+	}
+	if p.skip("(") {
+		for !p.skip(")") {
+			loc := p.expect("$").loc
+			v := &varDef{name: p.name().text, loc: loc}
+			p.expect(":")
+			v.typ = p.typeRef()
+			if p.skip("=") {
+				v.def = p.value(true)
+			}
+			op.vars = append(op.vars, v)
+		}
+	}
+	if dirs := p.directives(); len(dirs) > 0 {
+		p.failAt(dirs[0].loc, "directives on operations are not supported")
+	}
+	op.sel = p.selectionSet()
+	return op
+}
+
+func (p *parser) typeRef() *typeRef {
+	var t *typeRef
+	if p.skip("[") {
+		t = &typeRef{elem: p.typeRef()}
+		p.expect("]")
+	} else {
+		t = &typeRef{name: p.name().text}
+	}
+	t.nonNull = p.skip("!")
+	return t
+}
+
+func (p *parser) fragment() *fragment {
+	loc := p.next().loc
+	f := &fragment{loc: loc}
+	f.name = p.name().text
+	if f.name == "on" {
+		p.failAt(loc, "a fragment cannot be named \"on\"")
+	}
+	if on := p.name(); on.text != "on" {
+		p.failAt(on.loc, "expected \"on\", found %s", on)
+	}
+	f.typeCond = p.name().text
+	f.dirs = p.directives()
+	f.sel = p.selectionSet()
+	return f
+}
+
+func (p *parser) selectionSet() []selection {
+	open := p.expect("{")
+	var sel []selection
+	for !p.skip("}") {
+		if p.peek().kind == tEOF {
+			p.failAt(open.loc, "selection set is not closed")
+		}
+		sel = append(sel, p.selection())
+	}
+	if len(sel) == 0 {
+		p.failAt(open.loc, "empty selection set")
+	}
+	return sel
+}
+
+func (p *parser) selection() selection {
+	if p.is("...") {
+		loc := p.next().loc
+		if t := p.peek(); t.kind == tName && t.text != "on" {
+			p.next()
+			return &spreadNode{name: t.text, dirs: p.directives(), loc: loc}
+		}
+		in := &inlineNode{loc: loc}
+		if t := p.peek(); t.kind == tName && t.text == "on" {
+			p.next()
+			in.typeCond = p.name().text
+		}
+		in.dirs = p.directives()
+		in.sel = p.selectionSet()
+		return in
+	}
+
+	t := p.name()
+	f := &fieldNode{alias: t.text, name: t.text, loc: t.loc}
+	if p.skip(":") {
+		f.name = p.name().text
+	}
+	f.args = p.arguments(false)
+	f.dirs = p.directives()
+	if p.is("{") {
+		f.sel = p.selectionSet()
+	}
+	return f
+}
+
+func (p *parser) arguments(constant bool) []*argNode {
+	if !p.skip("(") {
+		return nil
+	}
+	var args []*argNode
+	for !p.skip(")") {
+		t := p.name()
+		p.expect(":")
+		args = append(args, &argNode{name: t.text, val: p.value(constant), loc: t.loc})
+	}
+	return args
+}
+
+func (p *parser) directives() []*directive {
+	var dirs []*directive
+	for p.is("@") {
+		loc := p.next().loc
+		dirs = append(dirs, &directive{name: p.name().text, args: p.arguments(false), loc: loc})
+	}
+	return dirs
+}
+
+// value parses a literal. constant is set where variables are not
+// allowed, i.e. in a variable's default.
+func (p *parser) value(constant bool) valueNode {
+	t := p.next()
+	switch {
+	case t.kind == tPunct && t.text == "$":
+		if constant {
+			p.failAt(t.loc, "a default value cannot use a variable")
+		}
+		return &varValue{name: p.name().text, loc: t.loc}
+	case t.kind == tPunct && t.text == "[":
+		l := &listValue{loc: t.loc}
+		for !p.skip("]") {
+			l.items = append(l.items, p.value(constant))
+		}
+		return l
+	case t.kind == tPunct && t.text == "{":
+		o := &objectValue{loc: t.loc}
+		for !p.skip("}") {
+			n := p.name()
+			p.expect(":")
+			o.fields = append(o.fields, &argNode{name: n.text, val: p.value(constant), loc: n.loc})
+		}
+		return o
+	case t.kind == tInt, t.kind == tFloat, t.kind == tString, t.kind == tName:
+		return &scalarValue{kind: t.kind, text: t.text, loc: t.loc}
+	default:
+		p.failAt(t.loc, "expected a value, found %s", t)
+		return nil
+	}
+}
+
+// literal turns a valueNode into the form input coercion takes, the one
+// encoding/json produces with UseNumber (so variables and literals are
+// coerced by the same code), with enum literals as enumValue.
+func literal(v valueNode, vars map[string]any) any {
+	switch v := v.(type) {
+	case *varValue:
+		return vars[v.name]
+	case *scalarValue:
+		switch v.kind {
+		case tInt, tFloat:
+			return json.Number(v.text)
+		case tString:
+			return v.text
+		}
+		switch v.text {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return enumValue(v.text)
+	case *listValue:
+		out := make([]any, len(v.items))
+		for i, item := range v.items {
+			out[i] = literal(item, vars)
+		}
+		return out
+	case *objectValue:
+		out := make(map[string]any, len(v.fields))
+		for _, f := range v.fields {
+			out[f.name] = literal(f.val, vars)
+		}
+		return out
+	}
+	return nil
+}
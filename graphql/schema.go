@@ -0,0 +1,583 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+-----------------------------------
+TYPES
+-----------------------------------
+*/
+
+// Type is one of *Scalar, *Enum, *Object, *InputObject, *List or
+// *NonNull.
+type Type interface {
+	String() string
+	isType()
+}
+
+// Scalar is a leaf type. Serialize turns what a resolver returned into
+// its JSON form; ParseValue turns an input (a string, bool, json.Number
+// or enum literal as the document or the variables hold it) into the
+// value resolvers receive. Either rejects what it cannot convert.
+type Scalar struct {
+	Name        string
+	Description string
+	Serialize   func(v any) (any, error)
+	ParseValue  func(v any) (any, error)
+}
+
+// Enum is a leaf type with a closed set of values. Resolvers return, and
+// receive, the value's name as a string.
+type Enum struct {
+	Name        string
+	Description string
+	Values      []string
+}
+
+// Object is a type with fields, the only composite output type: there
+// are no interfaces or unions.
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+}
+
+// InputObject is the type of a structured argument. Resolvers receive it
+// as a map[string]any holding the fields that were given (or defaulted).
+type InputObject struct {
+	Name        string
+	Description string
+	Fields      []*Arg
+}
+
+type List struct{ Of Type }
+
+type NonNull struct{ Of Type }
+
+func ListOf(t Type) *List       { return &List{Of: t} }
+func NonNullOf(t Type) *NonNull { return &NonNull{Of: t} }
+
+func (t *Scalar) String() string      { return t.Name }
+func (t *Enum) String() string        { return t.Name }
+func (t *Object) String() string      { return t.Name }
+func (t *InputObject) String() string { return t.Name }
+func (t *List) String() string        { return "[" + t.Of.String() + "]" }
+func (t *NonNull) String() string     { return t.Of.String() + "!" }
+
+func (*Scalar) isType()      {}
+func (*Enum) isType()        {}
+func (*Object) isType()      {}
+func (*InputObject) isType() {}
+func (*List) isType()        {}
+func (*NonNull) isType()     {}
+
+// Field is an Object's field. A nil Resolve reads the field's name from
+// a map[string]any source, which is enough for small value types.
+type Field struct {
+	Name        string
+	Description string
+	Type        Type
+	Args        []*Arg
+	Resolve     Resolver
+}
+
+// Arg is a field argument or an input object field. A non-null Arg
+// without a Default is required.
+type Arg struct {
+	Name        string
+	Description string
+	Type        Type
+	// Default is an input value: a string, bool, number (int, float64
+	// or json.Number), or a []any or map[string]any of those.
+	Default any
+}
+
+// Resolver computes a field. It may return a Thunk instead of the value,
+// to be forced only after every sibling field at the same depth has been
+// resolved: that is how a Loader collects keys into one batch.
+type Resolver func(ctx context.Context, p Params) (any, error)
+
+// Params is what a Resolver is called with.
+type Params struct {
+	// Source is the value the parent field resolved to; for a root field,
+	// the Options.Root value.
+	Source any
+	// Args holds the field's coerced arguments. An argument that was not
+	// given and has no default is absent.
+	Args map[string]any
+}
+
+// Thunk is a deferred field value.
+type Thunk func() (any, error)
+
+func (o *Object) field(name string) *Field {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func unwrap(t Type) Type {
+	for {
+		switch w := t.(type) {
+		case *NonNull:
+			t = w.Of
+		case *List:
+			t = w.Of
+		default:
+			return t
+		}
+	}
+}
+
+func isLeaf(t Type) bool {
+	switch unwrap(t).(type) {
+	case *Scalar, *Enum:
+		return true
+	}
+	return false
+}
+
+func isInput(t Type) bool {
+	switch unwrap(t).(type) {
+	case *Scalar, *Enum, *InputObject:
+		return true
+	}
+	return false
+}
+
+/*
+-----------------------------------
+BUILT-IN SCALARS
+-----------------------------------
+*/
+
+var (
+	Int = &Scalar{Name: "Int", Description: "A signed 32-bit integer.",
+		Serialize: func(v any) (any, error) {
+			n, err := toInt(v)
+			if err != nil {
+				return nil, err
+			}
+			return n, nil
+		},
+		ParseValue: func(v any) (any, error) {
+			n, ok := v.(json.Number)
+			if !ok {
+				return nil, fmt.Errorf("expected an Int, found %s", describe(v))
+			}
+			i, err := strconv.ParseInt(string(n), 10, 32)
+			if err != nil {
+				// Variables come through JSON, where 3.0 is a valid way to
+				// write 3.
+				f, ferr := n.Float64()
+				if ferr != nil || f != math.Trunc(f) || f < math.MinInt32 || f > math.MaxInt32 {
+					return nil, fmt.Errorf("expected an Int, found %s", n)
+				}
+				i = int64(f)
+			}
+			return int(i), nil
+		},
+	}
+	Float = &Scalar{Name: "Float", Description: "A double-precision number.",
+		Serialize: func(v any) (any, error) {
+			switch n := v.(type) {
+			case float64:
+				return n, nil
+			case float32:
+				return float64(n), nil
+			}
+			i, err := toInt(v)
+			return float64(i), err
+		},
+		ParseValue: func(v any) (any, error) {
+			n, ok := v.(json.Number)
+			if !ok {
+				return nil, fmt.Errorf("expected a Float, found %s", describe(v))
+			}
+			return n.Float64()
+		},
+	}
+	String = &Scalar{Name: "String", Description: "UTF-8 text.",
+		Serialize: func(v any) (any, error) {
+			switch s := v.(type) {
+			case string:
+				return s, nil
+			case fmt.Stringer:
+				return s.String(), nil
+			}
+			return nil, fmt.Errorf("cannot serialize %T as a String", v)
+		},
+		ParseValue: func(v any) (any, error) {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected a String, found %s", describe(v))
+			}
+			return s, nil
+		},
+	}
+	Boolean = &Scalar{Name: "Boolean",
+		Serialize: func(v any) (any, error) {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("cannot serialize %T as a Boolean", v)
+			}
+			return b, nil
+		},
+		ParseValue: func(v any) (any, error) {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("expected a Boolean, found %s", describe(v))
+			}
+			return b, nil
+		},
+	}
+	// ID is serialized as a string; it accepts a string or an integer.
+	ID = &Scalar{Name: "ID", Description: "An opaque identifier.",
+		Serialize: func(v any) (any, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			n, err := toInt(v)
+			if err != nil {
+				return nil, err
+			}
+			return strconv.Itoa(n), nil
+		},
+		ParseValue: func(v any) (any, error) {
+			switch id := v.(type) {
+			case string:
+				return id, nil
+			case json.Number:
+				if _, err := strconv.ParseInt(string(id), 10, 64); err == nil {
+					return string(id), nil
+				}
+			}
+			return nil, fmt.Errorf("expected an ID, found %s", describe(v))
+		},
+	}
+)
+
+var builtinScalars = []*Scalar{Int, Float, String, Boolean, ID}
+
+func toInt(v any) (int, error) {
+	var n int64
+	switch i := v.(type) {
+	case int:
+		n = int64(i)
+	case int8:
+		n = int64(i)
+	case int16:
+		n = int64(i)
+	case int32:
+		n = int64(i)
+	case int64:
+		n = i
+	case uint8:
+		n = int64(i)
+	case uint16:
+		n = int64(i)
+	case uint32:
+		n = int64(i)
+	default:
+		return 0, fmt.Errorf("cannot serialize %T as an Int", v)
+	}
+	if n < math.MinInt32 || n > math.MaxInt32 {
+		return 0, fmt.Errorf("%d does not fit in an Int", n)
+	}
+	return int(n), nil
+}
+
+// describe names an input value in an error.
+func describe(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(v)
+	case enumValue:
+		return string(v)
+	case []any:
+		return "a list"
+	case map[string]any:
+		return "an object"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+/*
+-----------------------------------
+SCHEMA
+-----------------------------------
+*/
+
+var (
+	ErrSchema = errors.New("graphql: invalid schema")
+	// ErrInvalid matches every error that stopped a request before it
+	// ran: a syntax error, a field the schema does not have, a missing
+	// argument.
+	ErrInvalid = errors.New("graphql: invalid request")
+)
+
+// Options configures New.
+type Options struct {
+	// Root is the Source of root fields.
+	Root any
+	// Context, if set, derives each request's context before it runs:
+	// the place to attach per-request state such as Loaders.
+	Context func(context.Context) context.Context
+	// ErrorMessage renders a resolver's error for the response; default
+	// err.Error().
+	ErrorMessage func(ctx context.Context, err error) string
+	// MaxDepth bounds how deeply selections nest; default 12.
+	MaxDepth int
+	// MaxFields bounds the fields one operation selects, fragments
+	// expanded, so aliases cannot multiply the work of one request;
+	// default 500.
+	MaxFields int
+}
+
+// Schema is a validated set of types with a query root and, optionally,
+// a mutation root.
+type Schema struct {
+	query    *Object
+	mutation *Object
+	types    map[string]Type
+	order    []Type // reachable named types, in discovery order, for SDL
+	opts     Options
+}
+
+// New checks the types reachable from the roots: names are unique,
+// arguments are input types, fields are output types.
+func New(query, mutation *Object, opts Options) (*Schema, error) {
+	if query == nil {
+		return nil, fmt.Errorf("%w: no query type", ErrSchema)
+	}
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = 12
+	}
+	if opts.MaxFields <= 0 {
+		opts.MaxFields = 500
+	}
+	if opts.ErrorMessage == nil {
+		opts.ErrorMessage = func(_ context.Context, err error) string { return err.Error() }
+	}
+	s := &Schema{query: query, mutation: mutation, types: make(map[string]Type), opts: opts}
+	for _, sc := range builtinScalars {
+		s.types[sc.Name] = sc
+	}
+	if err := s.add(query); err != nil {
+		return nil, err
+	}
+	if mutation != nil {
+		if err := s.add(mutation); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Schema) add(t Type) error {
+	switch w := t.(type) {
+	case *NonNull:
+		if _, ok := w.Of.(*NonNull); ok {
+			return fmt.Errorf("%w: %s is non-null twice", ErrSchema, t)
+		}
+		return s.add(w.Of)
+	case *List:
+		return s.add(w.Of)
+	}
+	name := t.String()
+	if prev, ok := s.types[name]; ok {
+		if prev != t {
+			return fmt.Errorf("%w: two types named %s", ErrSchema, name)
+		}
+		return nil
+	}
+	if !validName(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("%w: bad type name %q", ErrSchema, name)
+	}
+	s.types[name] = t
+	s.order = append(s.order, t)
+
+	switch t := t.(type) {
+	case *Scalar:
+		if t.Serialize == nil || t.ParseValue == nil {
+			return fmt.Errorf("%w: scalar %s needs Serialize and ParseValue", ErrSchema, name)
+		}
+	case *Enum:
+		if len(t.Values) == 0 {
+			return fmt.Errorf("%w: enum %s has no values", ErrSchema, name)
+		}
+	case *Object:
+		if len(t.Fields) == 0 {
+			return fmt.Errorf("%w: %s has no fields", ErrSchema, name)
+		}
+		seen := map[string]bool{}
+		for _, f := range t.Fields {
+			if !validName(f.Name) || seen[f.Name] || strings.HasPrefix(f.Name, "__") {
+				return fmt.Errorf("%w: bad or duplicate field %s.%s", ErrSchema, name, f.Name)
+			}
+			seen[f.Name] = true
+			if f.Type == nil || isInputOnly(f.Type) {
+				return fmt.Errorf("%w: %s.%s needs an output type", ErrSchema, name, f.Name)
+			}
+			if err := s.add(f.Type); err != nil {
+				return err
+			}
+			if err := s.addArgs(name+"."+f.Name, f.Args); err != nil {
+				return err
+			}
+		}
+	case *InputObject:
+		if len(t.Fields) == 0 {
+			return fmt.Errorf("%w: %s has no fields", ErrSchema, name)
+		}
+		if err := s.addArgs(name, t.Fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) addArgs(owner string, args []*Arg) error {
+	seen := map[string]bool{}
+	for _, a := range args {
+		if !validName(a.Name) || seen[a.Name] {
+			return fmt.Errorf("%w: bad or duplicate argument %s(%s)", ErrSchema, owner, a.Name)
+		}
+		seen[a.Name] = true
+		if a.Type == nil || !isInput(a.Type) {
+			return fmt.Errorf("%w: %s(%s) needs an input type", ErrSchema, owner, a.Name)
+		}
+		if err := s.add(a.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isInputOnly(t Type) bool {
+	_, ok := unwrap(t).(*InputObject)
+	return ok
+}
+
+func validName(s string) bool {
+	if s == "" || !isNameStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isNameStart(s[i]) && !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+-----------------------------------
+SDL
+-----------------------------------
+*/
+
+// SDL writes the schema in the GraphQL schema definition language, for
+// clients and code generators; there is no introspection.
+func (s *Schema) SDL() string {
+	var b strings.Builder
+	b.WriteString("schema {\n  query: " + s.query.Name + "\n")
+	if s.mutation != nil {
+		b.WriteString("  mutation: " + s.mutation.Name + "\n")
+	}
+	b.WriteString("}\n")
+	for _, t := range s.order {
+		b.WriteString("\n")
+		switch t := t.(type) {
+		case *Scalar:
+			writeDescription(&b, "", t.Description)
+			b.WriteString("scalar " + t.Name + "\n")
+		case *Enum:
+			writeDescription(&b, "", t.Description)
+			b.WriteString("enum " + t.Name + " {\n")
+			for _, v := range t.Values {
+				b.WriteString("  " + v + "\n")
+			}
+			b.WriteString("}\n")
+		case *InputObject:
+			writeDescription(&b, "", t.Description)
+			b.WriteString("input " + t.Name + " {\n")
+			for _, f := range t.Fields {
+				writeDescription(&b, "  ", f.Description)
+				b.WriteString("  " + argSDL(f) + "\n")
+			}
+			b.WriteString("}\n")
+		case *Object:
+			writeDescription(&b, "", t.Description)
+			b.WriteString("type " + t.Name + " {\n")
+			for _, f := range t.Fields {
+				writeDescription(&b, "  ", f.Description)
+				b.WriteString("  " + f.Name)
+				if len(f.Args) > 0 {
+					args := make([]string, len(f.Args))
+					for i, a := range f.Args {
+						args[i] = argSDL(a)
+					}
+					b.WriteString("(" + strings.Join(args, ", ") + ")")
+				}
+				b.WriteString(": " + f.Type.String() + "\n")
+			}
+			b.WriteString("}\n")
+		}
+	}
+	return b.String()
+}
+
+func argSDL(a *Arg) string {
+	s := a.Name + ": " + a.Type.String()
+	if a.Default != nil {
+		s += " = " + valueSDL(a.Default)
+	}
+	return s
+}
+
+func valueSDL(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case enumValue:
+		return string(v)
+	case []any:
+		items := make([]string, len(v))
+		for i, it := range v {
+			items[i] = valueSDL(it)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]any:
+		keys := slices.Sorted(maps.Keys(v))
+		fields := make([]string, len(keys))
+		for i, k := range keys {
+			fields[i] = k + ": " + valueSDL(v[k])
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func writeDescription(b *strings.Builder, indent, d string) {
+	if d != "" {
+		b.WriteString(indent + strconv.Quote(d) + "\n")
+	}
+}
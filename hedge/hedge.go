@@ -0,0 +1,127 @@
+// Package hedge issues backup requests for slow reads.
+//
+// Tail latency usually comes from a small fraction of slow calls (a GC
+// pause, a cold cache, a busy replica), not from every call being slow. If
+// a call hasn't answered by roughly the p95, sending the same request to
+// another replica and taking whichever answers first cuts the p99 sharply
+// for a few percent of extra load. The losing call is cancelled through
+// its context.
+//
+// Only hedge idempotent reads: every attempt may run to completion.
+package hedge
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+// Options configures Do.
+type Options struct {
+	// Delay before each backup attempt. DelayFunc, if set, is consulted
+	// per call instead (e.g. a live p95 from a histogram).
+	Delay     time.Duration
+	DelayFunc func() time.Duration
+
+	// Retryable reports whether an error should make Do try the next
+	// attempt right away. Errors it rejects (say, "not found") are final
+	// answers and returned as-is. nil means every error is retryable.
+	Retryable func(error) bool
+
+	Stats *Stats // optional counters
+	Clock clock.Clock
+}
+
+// Stats counts hedging outcomes. Safe for concurrent use.
+type Stats struct {
+	Calls  atomic.Int64
+	Hedges atomic.Int64 // backup attempts launched
+	Wins   atomic.Int64 // calls answered by a backup attempt
+}
+
+func (o Options) delay() time.Duration {
+	if o.DelayFunc != nil {
+		return o.DelayFunc()
+	}
+	return o.Delay
+}
+
+// Do runs attempts[0] and, each time Delay passes without an answer (or
+// immediately when an attempt fails retryably), starts the next one. The
+// first success wins and the rest are cancelled. If every attempt fails
+// the errors are joined.
+func Do[T any](ctx context.Context, opts Options, attempts ...func(context.Context) (T, error)) (T, error) {
+	var zero T
+	if len(attempts) == 0 {
+		return zero, errors.New("hedge: no attempts")
+	}
+	if opts.Stats != nil {
+		opts.Stats.Calls.Add(1)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		v   T
+		err error
+		i   int
+	}
+	// Buffered so losers can finish and exit after Do has returned.
+	results := make(chan result, len(attempts))
+	next, pending := 0, 0
+	launch := func() {
+		i := next
+		next++
+		pending++
+		go func() {
+			v, err := attempts[i](ctx)
+			results <- result{v, err, i}
+		}()
+	}
+
+	clk := clock.OrReal(opts.Clock)
+	timer := clk.NewTimer(opts.delay())
+	defer timer.Stop()
+
+	launch()
+	var errs []error
+	for {
+		var hedgeC <-chan time.Time
+		if next < len(attempts) {
+			hedgeC = timer.C()
+		}
+
+		select {
+		case r := <-results:
+			pending--
+			final := opts.Retryable != nil && !opts.Retryable(r.err)
+			if r.err == nil || final {
+				if r.err == nil && r.i > 0 && opts.Stats != nil {
+					opts.Stats.Wins.Add(1)
+				}
+				return r.v, r.err
+			}
+			errs = append(errs, r.err)
+			if next < len(attempts) {
+				launch()
+				timer.Reset(opts.delay())
+			} else if pending == 0 {
+				return zero, errors.Join(errs...)
+			}
+
+		case <-hedgeC:
+			launch()
+			if opts.Stats != nil {
+				opts.Stats.Hedges.Add(1)
+			}
+			timer.Reset(opts.delay())
+
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		}
+	}
+}
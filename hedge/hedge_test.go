@@ -0,0 +1,167 @@
+package hedge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+var errBusy = errors.New("busy")
+
+// answer is an attempt that answers v at once.
+func answer(v string, err error) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) { return v, err }
+}
+
+// stuck is an attempt that answers only when cancelled, closing started
+// as it begins and cancelled as it ends.
+func stuck(started, cancelled chan struct{}) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return "", ctx.Err()
+	}
+}
+
+func wait(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for", what)
+	}
+}
+
+type outcome struct {
+	v   string
+	err error
+}
+
+func TestFastAnswerDoesNotHedge(t *testing.T) {
+	var stats Stats
+	replica := func(context.Context) (string, error) {
+		t.Error("the replica was asked")
+		return "", nil
+	}
+	v, err := Do(context.Background(), Options{Delay: time.Second, Stats: &stats, Clock: clock.NewFake(time.Now())},
+		answer("primary", nil), replica)
+	if v != "primary" || err != nil {
+		t.Fatalf("Do = %q, %v", v, err)
+	}
+	if stats.Calls.Load() != 1 || stats.Hedges.Load() != 0 || stats.Wins.Load() != 0 {
+		t.Fatalf("stats = %d calls, %d hedges, %d wins", stats.Calls.Load(), stats.Hedges.Load(), stats.Wins.Load())
+	}
+}
+
+func TestSlowAnswerHedges(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	var stats Stats
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	done := make(chan outcome)
+	go func() {
+		v, err := Do(context.Background(), Options{Delay: 10 * time.Millisecond, Stats: &stats, Clock: clk},
+			stuck(started, cancelled), answer("replica", nil))
+		done <- outcome{v, err}
+	}()
+
+	wait(t, started, "the first attempt")
+	clk.BlockUntil(1)
+	clk.Advance(9 * time.Millisecond)
+	select {
+	case o := <-done:
+		t.Fatalf("Do answered %v before the delay", o)
+	default:
+	}
+	clk.Advance(time.Millisecond)
+
+	o := <-done
+	if o.v != "replica" || o.err != nil {
+		t.Fatalf("Do = %q, %v", o.v, o.err)
+	}
+	// The loser is cancelled.
+	wait(t, cancelled, "the first attempt to be cancelled")
+	if stats.Hedges.Load() != 1 || stats.Wins.Load() != 1 {
+		t.Fatalf("stats = %d hedges, %d wins, want 1 and 1", stats.Hedges.Load(), stats.Wins.Load())
+	}
+}
+
+func TestRetryableErrorTriesNextAtOnce(t *testing.T) {
+	// The delay never passes: only the failure can start the replica.
+	v, err := Do(context.Background(), Options{Delay: time.Hour, Clock: clock.NewFake(time.Now())},
+		answer("", errBusy), answer("replica", nil))
+	if v != "replica" || err != nil {
+		t.Fatalf("Do = %q, %v", v, err)
+	}
+}
+
+func TestFinalErrorIsTheAnswer(t *testing.T) {
+	notFound := errors.New("not found")
+	replica := func(context.Context) (string, error) {
+		t.Error("the replica was asked")
+		return "", nil
+	}
+	_, err := Do(context.Background(), Options{
+		Delay:     time.Hour,
+		Retryable: func(err error) bool { return !errors.Is(err, notFound) },
+		Clock:     clock.NewFake(time.Now()),
+	}, answer("", notFound), replica)
+	if !errors.Is(err, notFound) {
+		t.Fatalf("Do = %v, want not found", err)
+	}
+}
+
+func TestEveryAttemptFails(t *testing.T) {
+	errDown := errors.New("down")
+	_, err := Do(context.Background(), Options{Delay: time.Hour, Clock: clock.NewFake(time.Now())},
+		answer("", errBusy), answer("", errDown))
+	if !errors.Is(err, errBusy) || !errors.Is(err, errDown) {
+		t.Fatalf("Do = %v, want both errors", err)
+	}
+}
+
+func TestCallerCancels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	done := make(chan outcome)
+	go func() {
+		v, err := Do(ctx, Options{Delay: time.Hour, Clock: clock.NewFake(time.Now())}, stuck(started, cancelled))
+		done <- outcome{v, err}
+	}()
+	wait(t, started, "the attempt")
+	cancel()
+	if o := <-done; !errors.Is(o.err, context.Canceled) {
+		t.Fatalf("Do = %v, want context.Canceled", o.err)
+	}
+	wait(t, cancelled, "the attempt to be cancelled")
+}
+
+func TestDelayFunc(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	done := make(chan outcome)
+	go func() {
+		v, err := Do(context.Background(), Options{
+			Delay:     time.Hour,
+			DelayFunc: func() time.Duration { return time.Millisecond },
+			Clock:     clk,
+		}, stuck(started, cancelled), answer("replica", nil))
+		done <- outcome{v, err}
+	}()
+	wait(t, started, "the first attempt")
+	clk.BlockUntil(1)
+	clk.Advance(time.Millisecond)
+	if o := <-done; o.v != "replica" {
+		t.Fatalf("Do = %q, %v; DelayFunc not used", o.v, o.err)
+	}
+	wait(t, cancelled, "the first attempt to be cancelled")
+}
+
+func TestNoAttempts(t *testing.T) {
+	if _, err := Do[string](context.Background(), Options{}); err == nil {
+		t.Fatal("Do with no attempts succeeded")
+	}
+}
@@ -0,0 +1,200 @@
+// Package histogram records latency-style values into log-linear buckets
+// (the HdrHistogram layout) and answers percentile queries.
+//
+// Averages hide tails; keeping every sample costs memory proportional to
+// traffic. A log-linear histogram is the usual middle ground: values below
+// 256 are counted exactly, and every power-of-two range above that is split
+// into 128 equal buckets, so any recorded value is reported within 1/128
+// (<0.8%) of itself. The whole int64 range fits in ~7.4k counters (~58 KiB),
+// fixed at construction.
+//
+// Recording is lock-free (atomic adds), so one Histogram can be shared by
+// all goroutines on a hot path. Queries read the counters without stopping
+// writers, which makes them slightly fuzzy under concurrent load; take a
+// Snapshot when consistent numbers matter.
+package histogram
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	subBits   = 7
+	subCount  = 1 << subBits // buckets per power of two
+	linearMax = 2 * subCount // values below this are exact
+
+	numBuckets = linearMax + (64-subBits-1)*subCount
+)
+
+// Histogram is safe for concurrent use. The zero value is not usable; call
+// New.
+type Histogram struct {
+	counts []atomic.Uint64
+	total  atomic.Uint64
+	sum    atomic.Int64
+	min    atomic.Int64
+	max    atomic.Int64
+}
+
+func New() *Histogram {
+	h := &Histogram{counts: make([]atomic.Uint64, numBuckets)}
+	h.min.Store(math.MaxInt64)
+	return h
+}
+
+// bucketOf maps a non-negative value to its counter index.
+func bucketOf(v int64) int {
+	if v < linearMax {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBits - 1
+	mantissa := int(v >> shift) // in [subCount, 2*subCount)
+	return linearMax + (shift-1)*subCount + mantissa - subCount
+}
+
+// bucketRange is the inverse of bucketOf: the lowest value in bucket i and
+// the bucket's width.
+func bucketRange(i int) (low, width int64) {
+	if i < linearMax {
+		return int64(i), 1
+	}
+	shift := (i-linearMax)/subCount + 1
+	mantissa := int64((i-linearMax)%subCount + subCount)
+	return mantissa << shift, 1 << shift
+}
+
+// Record adds one observation. Negative values are recorded as 0.
+func (h *Histogram) Record(v int64) { h.RecordN(v, 1) }
+
+// RecordDuration records d in nanoseconds.
+func (h *Histogram) RecordDuration(d time.Duration) { h.RecordN(int64(d), 1) }
+
+// RecordN adds n observations of v.
+func (h *Histogram) RecordN(v int64, n uint64) {
+	if n == 0 {
+		return
+	}
+	v = max(v, 0)
+	h.counts[bucketOf(v)].Add(n)
+	h.total.Add(n)
+	h.sum.Add(v * int64(n))
+	for cur := h.min.Load(); v < cur && !h.min.CompareAndSwap(cur, v); cur = h.min.Load() {
+	}
+	for cur := h.max.Load(); v > cur && !h.max.CompareAndSwap(cur, v); cur = h.max.Load() {
+	}
+}
+
+// Count is the number of recorded observations.
+func (h *Histogram) Count() uint64 { return h.total.Load() }
+
+// Min and Max are exact (not bucketed). Both are 0 when empty.
+func (h *Histogram) Min() int64 {
+	if h.Count() == 0 {
+		return 0
+	}
+	return h.min.Load()
+}
+
+func (h *Histogram) Max() int64 { return h.max.Load() }
+
+// Mean is exact as long as the sum hasn't overflowed int64.
+func (h *Histogram) Mean() float64 {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+	return float64(h.sum.Load()) / float64(n)
+}
+
+// Quantile returns the value at quantile q (0..1), e.g. 0.99 for p99. The
+// answer is the midpoint of the bucket holding that rank, clamped to the
+// exact min/max.
+func (h *Histogram) Quantile(q float64) int64 {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+	if q >= 1 {
+		return h.Max()
+	}
+	q = max(q, 0)
+	rank := uint64(math.Ceil(q * float64(n)))
+	rank = max(rank, 1)
+
+	var seen uint64
+	for i := range h.counts {
+		seen += h.counts[i].Load()
+		if seen >= rank {
+			low, width := bucketRange(i)
+			return min(max(low+(width-1)/2, h.Min()), h.Max())
+		}
+	}
+	return h.Max()
+}
+
+// Percentile is Quantile(p/100).
+func (h *Histogram) Percentile(p float64) int64 { return h.Quantile(p / 100) }
+
+// QuantileDuration is Quantile for histograms of nanoseconds.
+func (h *Histogram) QuantileDuration(q float64) time.Duration {
+	return time.Duration(h.Quantile(q))
+}
+
+// Merge adds every observation in other into h.
+func (h *Histogram) Merge(other *Histogram) {
+	for i := range other.counts {
+		if c := other.counts[i].Load(); c != 0 {
+			h.counts[i].Add(c)
+		}
+	}
+	if other.Count() == 0 {
+		return
+	}
+	h.total.Add(other.total.Load())
+	h.sum.Add(other.sum.Load())
+	for v, cur := other.min.Load(), h.min.Load(); v < cur && !h.min.CompareAndSwap(cur, v); cur = h.min.Load() {
+	}
+	for v, cur := other.max.Load(), h.max.Load(); v > cur && !h.max.CompareAndSwap(cur, v); cur = h.max.Load() {
+	}
+}
+
+// Snapshot copies the histogram. The copy is consistent with itself only
+// if no writer was active during the call.
+func (h *Histogram) Snapshot() *Histogram {
+	s := New()
+	s.Merge(h)
+	return s
+}
+
+// Reset clears all observations.
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+	h.total.Store(0)
+	h.sum.Store(0)
+	h.min.Store(math.MaxInt64)
+	h.max.Store(0)
+}
+
+// Bucket is one non-empty bucket, as exported by Buckets.
+type Bucket struct {
+	Low, High int64 // inclusive range
+	Count     uint64
+}
+
+// Buckets returns the non-empty buckets in ascending order, for exporters
+// that want the raw distribution.
+func (h *Histogram) Buckets() []Bucket {
+	var out []Bucket
+	for i := range h.counts {
+		if c := h.counts[i].Load(); c != 0 {
+			low, width := bucketRange(i)
+			out = append(out, Bucket{Low: low, High: low + width - 1, Count: c})
+		}
+	}
+	return out
+}
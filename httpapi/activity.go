@@ -0,0 +1,71 @@
+package httpapi
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"Go-Internals/activity"
+	"Go-Internals/auth"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+ACCESS HISTORY
+-----------------------------------
+*/
+
+// maxActivity bounds ?limit= on GET /users/{id}/activity.
+const maxActivity = 100
+
+type activityHandlers struct {
+	svc     *users.UserService
+	history *activity.History
+}
+
+// recent answers with the user's latest events, newest first.
+func (h *activityHandlers) recent(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	limit := 20
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxActivity {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxActivity), http.StatusBadRequest)
+			return
+		}
+	}
+	if !mayEdit(w, r, id) {
+		return
+	}
+	if _, err := h.svc.GetUser(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.history.Recent(id, limit))
+}
+
+// trackActivity puts the request's address and user agent in its
+// context, for the registrations it makes, and records a sign-in for a
+// user's token on its first use. It runs inside auth.Authenticate.
+func trackActivity(history *activity.History, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		ctx := activity.WithRequest(r.Context(), activity.Request{IP: ip, UserAgent: r.UserAgent()})
+		// Only users' tokens have their ID as the subject; dev-admin and
+		// other service tokens have no history to add to. An admin
+		// impersonating a user is not the user signing in.
+		if c, ok := auth.PrincipalFrom(ctx); ok && c.Act == nil {
+			if id, err := strconv.Atoi(c.Subject); err == nil {
+				history.SignIn(ctx, id, c.IssuedAt)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,242 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"Go-Internals/approval"
+	"Go-Internals/auth"
+	"Go-Internals/privacy"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+APPROVALS
+-----------------------------------
+*/
+
+// The destructive operations; with Config.Approvals, each waits for a
+// second admin.
+const (
+	KindErase      = "user.erase"
+	KindBulkDelete = "users.bulk_delete"
+	KindRestore    = "backup.restore"
+)
+
+// gated runs an operation straight away, or, with approvals, submits it
+// for someone else to approve and answers 202 with the approval.
+type gated struct {
+	approvals *approval.Manager
+}
+
+// submit reports whether the operation was submitted (the response is
+// written) rather than left to the caller to run.
+func (g gated) submit(w http.ResponseWriter, r *http.Request, a approval.Action, reason string) bool {
+	if g.approvals == nil {
+		return false
+	}
+	c, _ := auth.PrincipalFrom(r.Context())
+	ap, err := g.approvals.Submit(r.Context(), c.Subject, a, strings.TrimSpace(reason))
+	if err != nil {
+		writeError(w, r, err)
+		return true
+	}
+	w.Header().Set("Location", "/approvals/"+ap.ID)
+	writeJSON(w, http.StatusAccepted, ap)
+	return true
+}
+
+type approvalHandlers struct {
+	m *approval.Manager
+}
+
+func (h *approvalHandlers) list(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.m.List(approval.Status(r.URL.Query().Get("status"))))
+}
+
+func (h *approvalHandlers) get(w http.ResponseWriter, r *http.Request) {
+	ap, err := h.m.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ap)
+}
+
+// approve answers 200 once the operation has run, whether or not it
+// succeeded: status failed, with the error, says it did not.
+func (h *approvalHandlers) approve(w http.ResponseWriter, r *http.Request) {
+	c, _ := auth.PrincipalFrom(r.Context())
+	ap, err := h.m.Approve(r.Context(), r.PathValue("id"), c.Subject)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ap)
+}
+
+func (h *approvalHandlers) reject(w http.ResponseWriter, r *http.Request) {
+	c, _ := auth.PrincipalFrom(r.Context())
+	ap, err := h.m.Reject(r.Context(), r.PathValue("id"), c.Subject)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ap)
+}
+
+// eraseFunc is KindErase's: params id and mode.
+func eraseFunc(m *privacy.Manager) approval.Func {
+	return func(ctx context.Context, params map[string]string) (any, error) {
+		id, err := strconv.Atoi(params["id"])
+		if err != nil {
+			return nil, err
+		}
+		rep, err := m.Erase(ctx, id, privacy.Mode(params["mode"]))
+		if err != nil {
+			return nil, err
+		}
+		return eraseResponse{Complete: rep.Complete(), Report: rep}, nil
+	}
+}
+
+/*
+-----------------------------------
+BULK DELETE
+-----------------------------------
+*/
+
+type bulkDeleteRequest struct {
+	IDs    []int  `json:"ids" schema:"required,minItems=1,maxItems=1000"`
+	Reason string `json:"reason,omitempty" doc:"Required when deletions need approval."`
+}
+
+type bulkDeleteResponse struct {
+	Deleted []int `json:"deleted"`
+	// Missing were already gone.
+	Missing []int `json:"missing,omitempty"`
+	// Failed maps the rest to why.
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+type bulkDeleteHandlers struct {
+	gated
+	svc *users.UserService
+}
+
+func (h *bulkDeleteHandlers) delete(w http.ResponseWriter, r *http.Request) {
+	var req bulkDeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badJSON(w, r, err)
+		return
+	}
+	ids := slices.Compact(slices.Sorted(slices.Values(req.IDs)))
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = strconv.Itoa(id)
+	}
+	a := approval.Action{
+		Kind:    KindBulkDelete,
+		Summary: "delete " + strconv.Itoa(len(ids)) + " users",
+		Params:  map[string]string{"ids": strings.Join(list, ",")},
+	}
+	if h.submit(w, r, a, req.Reason) {
+		return
+	}
+	res, _ := bulkDeleteFunc(h.svc)(r.Context(), a.Params)
+	writeJSON(w, http.StatusOK, res)
+}
+
+// bulkDeleteFunc is KindBulkDelete's: params ids, comma-separated. One
+// failing does not stop the rest.
+func bulkDeleteFunc(svc *users.UserService) approval.Func {
+	return func(ctx context.Context, params map[string]string) (any, error) {
+		res := bulkDeleteResponse{Deleted: []int{}}
+		for _, s := range strings.Split(params["ids"], ",") {
+			id, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, err
+			}
+			switch err := svc.DeleteUser(ctx, id); {
+			case err == nil:
+				res.Deleted = append(res.Deleted, id)
+			case errors.Is(err, users.ErrUserNotFound):
+				res.Missing = append(res.Missing, id)
+			default:
+				if res.Failed == nil {
+					res.Failed = map[string]string{}
+				}
+				res.Failed[s] = err.Error()
+			}
+		}
+		return res, nil
+	}
+}
+
+/*
+-----------------------------------
+RESTORE
+-----------------------------------
+*/
+
+type restoreRequest struct {
+	// Seq is the restore point; 0 for the latest.
+	Seq    uint64 `json:"seq,omitempty" doc:"The archive sequence number to restore to; default the latest."`
+	Reason string `json:"reason,omitempty" doc:"Required when restores need approval."`
+}
+
+type restoreResponse struct {
+	Restored int `json:"restored"`
+}
+
+type restoreHandlers struct {
+	gated
+	restore func(ctx context.Context, seq uint64) (int, error)
+}
+
+func (h *restoreHandlers) run(w http.ResponseWriter, r *http.Request) {
+	var req restoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badJSON(w, r, err)
+		return
+	}
+	point := "the latest backup"
+	if req.Seq > 0 {
+		point = "backup " + strconv.FormatUint(req.Seq, 10)
+	}
+	a := approval.Action{
+		Kind:    KindRestore,
+		Summary: "restore " + point,
+		Params:  map[string]string{"seq": strconv.FormatUint(req.Seq, 10)},
+	}
+	if h.submit(w, r, a, req.Reason) {
+		return
+	}
+	res, err := restoreFunc(h.restore)(r.Context(), a.Params)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// restoreFunc is KindRestore's: param seq.
+func restoreFunc(restore func(context.Context, uint64) (int, error)) approval.Func {
+	return func(ctx context.Context, params map[string]string) (any, error) {
+		seq, err := strconv.ParseUint(params["seq"], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		n, err := restore(ctx, seq)
+		if err != nil {
+			return nil, err
+		}
+		return restoreResponse{Restored: n}, nil
+	}
+}
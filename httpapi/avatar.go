@@ -0,0 +1,150 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+
+	"Go-Internals/auth"
+	"Go-Internals/avatar"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+AVATARS
+-----------------------------------
+*/
+
+// avatarField is the multipart field an upload is read from.
+const avatarField = "avatar"
+
+type avatarHandlers struct {
+	svc     *users.UserService
+	avatars *avatar.Avatars
+}
+
+// mayEdit lets users change their own avatar or read their own history
+// (with a token whose subject is their ID), and admins and service
+// accounts with the route's scope anyone's.
+func mayEdit(w http.ResponseWriter, r *http.Request, id int) bool {
+	c, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return false
+	}
+	if c.Subject != strconv.Itoa(id) && !c.HasRole(auth.RoleAdmin) && !serviceMay(c, r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// put reads the image from the multipart field "avatar", streaming: the
+// parts before it are skipped, not buffered, and nothing is written to
+// temporary files.
+func (h *avatarHandlers) put(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if !mayEdit(w, r, id) {
+		return
+	}
+	if _, err := h.svc.GetUser(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Room for the image plus the multipart framing around it.
+	r.Body = http.MaxBytesReader(w, r.Body, h.avatars.MaxBytes()+64<<10)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected a multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			http.Error(w, "no "+avatarField+" field in the form", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "invalid multipart body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != avatarField {
+			continue
+		}
+		u, err := h.set(r.Context(), id, part)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, h.svc.Enrich(r.Context(), u))
+		return
+	}
+}
+
+// set stores the image and points the user's avatar_url at it.
+func (h *avatarHandlers) set(ctx context.Context, id int, r io.Reader) (users.User, error) {
+	info, err := h.avatars.Set(ctx, id, r)
+	if err != nil {
+		return users.User{}, err
+	}
+	return h.svc.SetAvatarURL(ctx, id, avatar.URL(id, info))
+}
+
+// get serves the image with its ETag and Last-Modified. Through the
+// versioned URL from AvatarURL it may be cached for a year; through any
+// other it must be revalidated, since the next upload changes it.
+func (h *avatarHandlers) get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	rc, info, err := h.avatars.Open(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer rc.Close()
+	hdr := w.Header()
+	hdr.Set("Content-Type", info.ContentType)
+	hdr.Set("ETag", `"`+info.ETag+`"`)
+	hdr.Set("X-Content-Type-Options", "nosniff")
+	if r.URL.Query().Get("v") == avatar.Version(info) {
+		hdr.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		hdr.Set("Cache-Control", "public, no-cache")
+	}
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", info.ModTime, rs)
+		return
+	}
+	hdr.Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	_, _ = io.Copy(w, rc)
+}
+
+func (h *avatarHandlers) delete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if !mayEdit(w, r, id) {
+		return
+	}
+	if err := h.avatars.Delete(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if _, err := h.svc.SetAvatarURL(r.Context(), id, ""); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
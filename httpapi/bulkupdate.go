@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"Go-Internals/i18n"
+	"Go-Internals/query"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+BULK UPDATE
+-----------------------------------
+*/
+
+type bulkUpdateRequest struct {
+	Filter userFilter      `json:"filter" schema:"required"`
+	Patch  json.RawMessage `json:"patch" schema:"required" doc:"A JSON Merge Patch, applied to every matching user."`
+}
+
+// userFilter is GraphQL's UserFilter: every field set must match.
+type userFilter struct {
+	IDs           []int     `json:"ids,omitempty" schema:"maxItems=1000"`
+	NamePrefix    string    `json:"name_prefix,omitempty"`
+	Email         string    `json:"email,omitempty"`
+	CreatedAfter  time.Time `json:"created_after,omitzero"`
+	CreatedBefore time.Time `json:"created_before,omitzero"`
+}
+
+// spec is f as a query; false if f is empty, which would match every
+// user.
+func (f userFilter) spec() (query.Spec, bool) {
+	var spec query.And
+	if len(f.IDs) > 0 {
+		byID := make(query.Or, len(f.IDs))
+		for i, id := range f.IDs {
+			byID[i] = query.Eq(query.FieldID, id)
+		}
+		spec = append(spec, byID)
+	}
+	if f.NamePrefix != "" {
+		spec = append(spec, query.HasPrefix(query.FieldName, f.NamePrefix))
+	}
+	if f.Email != "" {
+		spec = append(spec, query.Eq(query.FieldEmail, f.Email))
+	}
+	if !f.CreatedAfter.IsZero() {
+		spec = append(spec, query.CreatedAfter(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		spec = append(spec, query.CreatedBefore(f.CreatedBefore))
+	}
+	return spec, len(spec) > 0
+}
+
+type bulkUpdateHandlers struct {
+	svc *users.UserService
+}
+
+// update patches every user the filter matches, all or none.
+func (h *bulkUpdateHandlers) update(w http.ResponseWriter, r *http.Request) {
+	var req bulkUpdateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		badJSON(w, r, err)
+		return
+	}
+	spec, ok := req.Filter.spec()
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: "the filter is empty: set at least one of its fields"})
+		return
+	}
+	p, err := users.ParsePatch(users.MergePatchType, req.Patch)
+	if err != nil {
+		writeError(w, r, i18n.Wrap(err, "user.patch_invalid", i18n.Params{"reason": err.Error()}))
+		return
+	}
+	res, err := h.svc.UpdateWhere(r.Context(), spec, p)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,165 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+-----------------------------------
+CONDITIONAL REQUESTS
+-----------------------------------
+*/
+
+// etagOf is a strong validator over a response body: the same bytes, the
+// same tag. Hashing what is sent, rather than versioning the store,
+// keeps it right for every backend, at the price of building the body
+// before a 304 can be answered.
+func etagOf(body []byte) string {
+	h := fnv.New64a()
+	h.Write(body)
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+// encode is the body writeJSON would send for v.
+func encode(v any) ([]byte, error) {
+	var b bytes.Buffer
+	err := json.NewEncoder(&b).Encode(v)
+	return b.Bytes(), err
+}
+
+// writeCached writes v with its ETag and, if modified is known, its
+// Last-Modified, and leaves the conditional headers to
+// http.ServeContent: If-None-Match (which wins when present) or
+// If-Modified-Since answer 304, If-Match and If-Unmodified-Since 412.
+// Last-Modified has one-second resolution, so a client that only sends
+// If-Modified-Since can miss a second write within the same second; the
+// ETag does not.
+func writeCached(w http.ResponseWriter, r *http.Request, v any, modified time.Time) {
+	body, err := encode(v)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "application/json")
+	h.Set("ETag", etagOf(body))
+	// Cached copies are fine as long as they are revalidated: that is
+	// what the validators are for.
+	h.Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, "", modified, bytes.NewReader(body))
+}
+
+// etagMatch is If-None-Match's weak comparison: W/ is ignored, and *
+// matches any current representation.
+func etagMatch(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+-----------------------------------
+LONG POLLING
+-----------------------------------
+*/
+
+const (
+	// maxWait caps GET /users?wait=, below the proxies' usual idle
+	// timeouts.
+	maxWait = 60 * time.Second
+	// pollInterval is how often a waiting list is rebuilt when the
+	// backend has no change feed to wait on. Each rebuild is a ListUsers
+	// call and counts against the tenant's quota.
+	pollInterval = time.Second
+)
+
+// pollList makes GET /users?wait=30s a long poll: while the list still
+// matches the request's If-None-Match, it holds the request until the
+// list changes or wait runs out, then hands it to next, which answers
+// 200 with the new list or 304. Without wait or If-None-Match, next
+// answers at once.
+//
+// The wait happens outside next (which is the concurrency-limited list
+// handler), so parked polls do not hold limiter slots or count as slow
+// requests; classify sheds them as Batch.
+func (h *handlers) pollList(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wait, err := waitParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		have := r.Header.Get("If-None-Match")
+		if wait == 0 || have == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		for {
+			var seq uint64
+			if h.changes != nil {
+				// Read before the list: a write in between then ends the
+				// wait at once instead of being missed.
+				seq = h.changes.LastSeq()
+			}
+			list, err := h.listFor(ctx, r)
+			if err != nil {
+				break
+			}
+			body, err := encode(list)
+			if err != nil || !etagMatch(have, etagOf(body)) {
+				break
+			}
+			if !h.waitChange(ctx, seq) {
+				break
+			}
+		}
+		if r.Context().Err() != nil {
+			return // the client is gone
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// waitChange blocks until the store may have changed since seq; false
+// means ctx ended first.
+func (h *handlers) waitChange(ctx context.Context, seq uint64) bool {
+	if h.changes != nil {
+		for _, err := range h.changes.Changes(ctx, seq+1) {
+			return err == nil
+		}
+		return false
+	}
+	t := time.NewTimer(pollInterval)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func waitParam(r *http.Request) (time.Duration, error) {
+	s := r.URL.Query().Get("wait")
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid wait %q: want a duration such as 30s", s)
+	}
+	return min(d, maxWait), nil
+}
@@ -0,0 +1,119 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"Go-Internals/dryrun"
+	"Go-Internals/openapi"
+)
+
+/*
+-----------------------------------
+DRY RUNS
+-----------------------------------
+*/
+
+// DryRunHeader asks for a write to be run dry: Dry-Run: true.
+const DryRunHeader = "Dry-Run"
+
+// dryRunRoutes are the writes that can run dry: those that change
+// users only through the service, which honours a dry run (see package
+// dryrun). The others write elsewhere as well (blobs, sessions,
+// approvals) and refuse one.
+var dryRunRoutes = map[string]bool{
+	"POST /users":             true,
+	"PATCH /users/{id}":       true,
+	"POST /users/bulk-update": true,
+	"POST /graphql":           true,
+}
+
+// dryRunParam documents DryRunHeader on dryRunRoutes.
+var dryRunParam = openapi.Header(DryRunHeader, "true to check the write and answer with what it would do, without doing it",
+	&openapi.Schema{Type: "boolean"})
+
+// dryRunResponse answers a dry run that would have succeeded. A failing
+// one answers as the write would have.
+type dryRunResponse struct {
+	DryRun bool `json:"dry_run"`
+	// Status and Response are what the write would have answered.
+	Status   int `json:"status"`
+	Response any `json:"response,omitempty"`
+	dryrun.Report
+}
+
+// withDryRun runs the writes asking for it, or with global every write,
+// as dry runs; mux tells which route a request is for. Reads are served
+// as they are. In global mode the writes that cannot run dry are
+// refused with 503, signing in and out aside.
+func withDryRun(mux *http.ServeMux, global bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked := false
+		if v := r.Header.Get(DryRunHeader); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, errorBody{Error: DryRunHeader + ": want true or false"})
+				return
+			}
+			asked = b
+		}
+		if !asked && !global || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, pattern := mux.Handler(r); !dryRunRoutes[pattern] {
+			switch {
+			case asked:
+				writeJSON(w, http.StatusBadRequest, errorBody{Error: "this request cannot be run dry"})
+			case maintenanceExempt(r):
+				next.ServeHTTP(w, r)
+			default:
+				writeJSON(w, http.StatusServiceUnavailable, errorBody{Error: "the server only runs writes dry, and this one cannot be"})
+			}
+			return
+		}
+
+		ctx, plan := dryrun.With(r.Context())
+		rec := &bufferedWriter{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		w.Header().Set(DryRunHeader, "true")
+		if rec.status < 200 || rec.status > 299 {
+			for k, v := range rec.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.status)
+			_, _ = w.Write(rec.body.Bytes())
+			return
+		}
+		resp := dryRunResponse{DryRun: true, Status: rec.status, Report: plan.Report()}
+		if b := rec.body.Bytes(); json.Valid(b) {
+			resp.Response = json.RawMessage(b)
+		} else if len(b) > 0 {
+			resp.Response = string(b)
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+// bufferedWriter keeps a response to be answered with later.
+type bufferedWriter struct {
+	header http.Header
+	status int
+	wrote  bool
+	body   bytes.Buffer
+}
+
+func (b *bufferedWriter) Header() http.Header { return b.header }
+
+func (b *bufferedWriter) WriteHeader(status int) {
+	if !b.wrote {
+		b.status, b.wrote = status, true
+	}
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	b.wrote = true
+	return b.body.Write(p)
+}
@@ -0,0 +1,52 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+
+	"Go-Internals/experiment"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+EXPERIMENTS
+-----------------------------------
+*/
+
+type assignment struct {
+	Experiment string `json:"experiment"`
+	// Variant is empty when the user is not enrolled.
+	Variant string `json:"variant,omitempty"`
+	// Enrolled is false for users the experiment's flag leaves out.
+	Enrolled bool `json:"enrolled"`
+}
+
+type experimentHandlers struct {
+	svc         *users.UserService
+	experiments *experiment.Service
+}
+
+// assign answers with the user's variant, the same on every request,
+// and counts it as an exposure: clients ask as they show it.
+func (h *experimentHandlers) assign(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if !mayEdit(w, r, id) {
+		return
+	}
+	if _, err := h.svc.GetUser(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	name := r.PathValue("name")
+	variant, ok, err := h.experiments.Assign(r.Context(), name, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, assignment{Experiment: name, Variant: variant, Enrolled: ok})
+}
@@ -0,0 +1,99 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"Go-Internals/auth"
+	"Go-Internals/experiment"
+	"Go-Internals/users"
+)
+
+// experimentServer is a server with one experiment, "checkout", and two
+// users, IDs 1 and 2.
+func experimentServer(t *testing.T) (http.Handler, *auth.HS256) {
+	t.Helper()
+	signer := &auth.HS256{Key: []byte("test key")}
+	svc := users.NewUserService(users.NewInMemoryUserRepo())
+	for _, u := range [][2]string{{"Ada", "ada@example.com"}, {"Bo", "bo@example.com"}} {
+		if _, err := svc.RegisterUser(context.Background(), u[0], u[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	experiments := experiment.New(nil, nil)
+	if err := experiments.Define(experiment.Experiment{Name: "checkout", Variants: []experiment.Variant{{Name: "a", Weight: 1}, {Name: "b", Weight: 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	return New(Config{Service: svc, Auth: signer, Experiments: experiments}), signer
+}
+
+func TestExperimentAssign(t *testing.T) {
+	h, signer := experimentServer(t)
+	ada := userToken(t, signer, "1")
+	tests := []struct {
+		name, token, path string
+		want              int
+	}{
+		{"anonymous", "", "/users/1/experiments/checkout", http.StatusUnauthorized},
+		{"another user", userToken(t, signer, "2"), "/users/1/experiments/checkout", http.StatusForbidden},
+		{"unknown experiment", ada, "/users/1/experiments/nope", http.StatusNotFound},
+		{"unknown user", userToken(t, signer, "99", auth.RoleAdmin), "/users/3/experiments/checkout", http.StatusNotFound},
+		{"the user", ada, "/users/1/experiments/checkout", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(h, tt.token, "GET", tt.path, "", ""); w.Code != tt.want {
+				t.Fatalf("GET %s = %d, want %d: %s", tt.path, w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	// The same variant every time.
+	var first assignment
+	for i := range 3 {
+		var got assignment
+		w := serve(h, ada, "GET", "/users/1/experiments/checkout", "", "")
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !got.Enrolled || got.Variant == "" || (i > 0 && got != first) {
+			t.Fatalf("assignment %d = %+v, first %+v", i, got, first)
+		}
+		first = got
+	}
+}
+
+func TestExperimentStats(t *testing.T) {
+	h, signer := experimentServer(t)
+	for range 2 {
+		serve(h, userToken(t, signer, "1"), "GET", "/users/1/experiments/checkout", "", "")
+	}
+	serve(h, userToken(t, signer, "2"), "GET", "/users/2/experiments/checkout", "", "")
+
+	if w := serve(h, "", "GET", "/experiments/stats", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous GET /experiments/stats = %d, want 401", w.Code)
+	}
+	if w := serve(h, userToken(t, signer, "1"), "GET", "/experiments/stats", "", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("user's GET /experiments/stats = %d, want 403", w.Code)
+	}
+	w := serve(h, userToken(t, signer, "99", auth.RoleAdmin), "GET", "/experiments/stats", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin's GET /experiments/stats = %d: %s", w.Code, w.Body)
+	}
+	var stats []experiment.Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 || stats[0].Experiment != "checkout" || stats[0].Exposures != 3 {
+		t.Fatalf("stats = %+v, want 3 exposures of checkout", stats)
+	}
+	var sum int64
+	for _, v := range stats[0].Variants {
+		sum += v.Exposures
+	}
+	if sum != 3 {
+		t.Fatalf("variants' exposures add up to %d, want 3", sum)
+	}
+}
@@ -0,0 +1,401 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"Go-Internals/activity"
+	"Go-Internals/approval"
+	"Go-Internals/auth"
+	"Go-Internals/dryrun"
+	"Go-Internals/graphql"
+	"Go-Internals/i18n"
+	"Go-Internals/query"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+GRAPHQL
+-----------------------------------
+*/
+
+// maxPage bounds users(first:).
+const maxPage = 100
+
+var (
+	errAuthRequired = errors.New("authentication required")
+	errForbidden    = errors.New("forbidden")
+)
+
+type loaderKey struct{}
+
+type userLoader = graphql.Loader[int, users.User]
+
+// newGraphQL builds the schema served at /graphql:
+//
+//	user(id: ID!): User
+//	users(first: Int = 20, after: String, filter: UserFilter): UserConnection!
+//	createUser / updateUser / deleteUser
+//
+// user goes through a per-request Loader, so a document asking for many
+// users by ID (aliases, or fragments from different parts of a client)
+// makes one GetUsers call. users pages by ID: the cursor is the last ID
+// seen, so a page does not shift when users are created before it.
+//
+// updateUser and deleteUser are checked as PATCH /users/{id} is (see
+// mayChange); with approvals, deleteUser submits the delete as a bulk
+// delete of one user and answers with the approval's ID.
+func newGraphQL(svc *users.UserService, history *activity.History, approvals *approval.Manager) *graphql.Schema {
+	timeType := &graphql.Scalar{Name: "Time", Description: "An RFC 3339 timestamp.",
+		Serialize: func(v any) (any, error) {
+			t, ok := v.(time.Time)
+			if !ok {
+				return nil, fmt.Errorf("cannot serialize %T as a Time", v)
+			}
+			return t.Format(time.RFC3339Nano), nil
+		},
+		ParseValue: func(v any) (any, error) {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected an RFC 3339 string")
+			}
+			return time.Parse(time.RFC3339Nano, s)
+		},
+	}
+
+	// JSON is output only: derived fields are computed, never sent.
+	jsonType := &graphql.Scalar{Name: "JSON", Description: "Any JSON value.",
+		Serialize: func(v any) (any, error) { return v, nil },
+		ParseValue: func(any) (any, error) {
+			return nil, errors.New("JSON is not an input type")
+		},
+	}
+
+	field := func(name string, t graphql.Type, get func(u users.User) any) *graphql.Field {
+		return &graphql.Field{Name: name, Type: t, Resolve: func(_ context.Context, p graphql.Params) (any, error) {
+			return get(p.Source.(users.User)), nil
+		}}
+	}
+	user := &graphql.Object{Name: "User", Fields: []*graphql.Field{
+		field("id", graphql.NonNullOf(graphql.ID), func(u users.User) any { return u.ID }),
+		field("name", graphql.NonNullOf(graphql.String), func(u users.User) any { return u.Name }),
+		field("email", graphql.NonNullOf(graphql.String), func(u users.User) any { return u.Email }),
+		field("createdAt", graphql.NonNullOf(timeType), func(u users.User) any { return u.CreatedAt }),
+		{Name: "expiresAt", Type: timeType, Description: "Null if the record does not expire.",
+			Resolve: func(_ context.Context, p graphql.Params) (any, error) {
+				if u := p.Source.(users.User); !u.ExpiresAt.IsZero() {
+					return u.ExpiresAt, nil
+				}
+				return nil, nil
+			}},
+		{Name: "avatarURL", Type: graphql.String, Description: "Null if the user has no avatar.",
+			Resolve: func(_ context.Context, p graphql.Params) (any, error) {
+				if u := p.Source.(users.User); u.AvatarURL != "" {
+					return u.AvatarURL, nil
+				}
+				return nil, nil
+			}},
+		{Name: "derived", Type: jsonType, Description: "The fields computed on read, by name; null if there are none.",
+			Resolve: func(ctx context.Context, p graphql.Params) (any, error) {
+				if d := svc.Enrich(ctx, p.Source.(users.User)).Derived; d != nil {
+					return d, nil
+				}
+				return nil, nil
+			}},
+	}}
+
+	// Edges and PageInfo are maps, read by the default resolver.
+	edge := &graphql.Object{Name: "UserEdge", Fields: []*graphql.Field{
+		{Name: "cursor", Type: graphql.NonNullOf(graphql.String)},
+		{Name: "node", Type: graphql.NonNullOf(user)},
+	}}
+	pageInfo := &graphql.Object{Name: "PageInfo", Fields: []*graphql.Field{
+		{Name: "hasNextPage", Type: graphql.NonNullOf(graphql.Boolean)},
+		{Name: "endCursor", Type: graphql.String},
+	}}
+	connection := &graphql.Object{Name: "UserConnection", Fields: []*graphql.Field{
+		{Name: "edges", Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(edge))),
+			Resolve: func(ctx context.Context, p graphql.Params) (any, error) {
+				page, err := p.Source.(*userPage).load(ctx)
+				if err != nil {
+					return nil, err
+				}
+				edges := make([]map[string]any, len(page))
+				for i, u := range page {
+					edges[i] = map[string]any{"cursor": cursorOf(u.ID), "node": u}
+				}
+				return edges, nil
+			}},
+		{Name: "nodes", Type: graphql.NonNullOf(graphql.ListOf(graphql.NonNullOf(user))),
+			Resolve: func(ctx context.Context, p graphql.Params) (any, error) {
+				return p.Source.(*userPage).load(ctx)
+			}},
+		{Name: "pageInfo", Type: graphql.NonNullOf(pageInfo),
+			Resolve: func(ctx context.Context, p graphql.Params) (any, error) {
+				pg := p.Source.(*userPage)
+				page, err := pg.load(ctx)
+				if err != nil {
+					return nil, err
+				}
+				info := map[string]any{"hasNextPage": pg.more, "endCursor": nil}
+				if len(page) > 0 {
+					info["endCursor"] = cursorOf(page[len(page)-1].ID)
+				}
+				return info, nil
+			}},
+		{Name: "totalCount", Type: graphql.NonNullOf(graphql.Int), Description: "Users matching the filter, on every page.",
+			Resolve: func(ctx context.Context, p graphql.Params) (any, error) {
+				all, err := svc.SearchUsers(ctx, p.Source.(*userPage).filter)
+				return len(all), err
+			}},
+	}}
+
+	filter := &graphql.InputObject{Name: "UserFilter", Description: "Every field given must match.", Fields: []*graphql.Arg{
+		{Name: "ids", Type: graphql.ListOf(graphql.NonNullOf(graphql.ID))},
+		{Name: "namePrefix", Type: graphql.String},
+		{Name: "email", Type: graphql.String},
+		{Name: "createdAfter", Type: timeType},
+		{Name: "createdBefore", Type: timeType},
+	}}
+
+	queryType := &graphql.Object{Name: "Query", Fields: []*graphql.Field{
+		{Name: "user", Type: user, Description: "Null if there is no such user.",
+			Args: []*graphql.Arg{{Name: "id", Type: graphql.NonNullOf(graphql.ID)}},
+			Resolve: func(ctx context.Context, p graphql.Params) (any, error) {
+				id, err := idArg(p.Args["id"])
+				if err != nil {
+					return nil, err
+				}
+				return loaderFrom(ctx).Load(ctx, id), nil
+			}},
+		{Name: "users", Type: graphql.NonNullOf(connection), Description: "Users in ID order.",
+			Args: []*graphql.Arg{
+				{Name: "first", Type: graphql.Int, Default: 20},
+				{Name: "after", Type: graphql.String, Description: "An endCursor from a previous page."},
+				{Name: "filter", Type: filter},
+			},
+			Resolve: func(_ context.Context, p graphql.Params) (any, error) {
+				return newUserPage(svc, p.Args)
+			}},
+	}}
+
+	createInput := &graphql.InputObject{Name: "CreateUserInput", Fields: []*graphql.Arg{
+		{Name: "name", Type: graphql.NonNullOf(graphql.String)},
+		{Name: "email", Type: graphql.NonNullOf(graphql.String)},
+	}}
+	updateInput := &graphql.InputObject{Name: "UpdateUserInput", Description: "Fields left out keep their value.", Fields: []*graphql.Arg{
+		{Name: "name", Type: graphql.String},
+		{Name: "email", Type: graphql.String},
+	}}
+	str := func(m map[string]any, k string) string { s, _ := m[k].(string); return s }
+	// A map, read by the default resolver.
+	deletion := &graphql.Object{Name: "UserDeletion", Fields: []*graphql.Field{
+		{Name: "deleted", Type: graphql.NonNullOf(graphql.Boolean)},
+		{Name: "approvalId", Type: graphql.ID, Description: "Set if the delete waits for another admin: see /approvals."},
+	}}
+	mutationType := &graphql.Object{Name: "Mutation", Fields: []*graphql.Field{
+		{Name: "createUser", Type: user,
+			Args: []*graphql.Arg{{Name: "input", Type: graphql.NonNullOf(createInput)}},
+			Resolve: func(ctx context.Context, p graphql.Params) (any, error) {
+				in := p.Args["input"].(map[string]any)
+				u, err := svc.RegisterUser(ctx, str(in, "name"), str(in, "email"))
+				if err != nil {
+					return nil, err
+				}
+				if history != nil && !dryrun.Enabled(ctx) {
+					history.Record(ctx, u.ID, activity.Registration)
+				}
+				loaderFrom(ctx).Prime(u.ID, u)
+				return u, nil
+			}},
+		{Name: "updateUser", Type: user,
+			Args: []*graphql.Arg{{Name: "id", Type: graphql.NonNullOf(graphql.ID)}, {Name: "input", Type: graphql.NonNullOf(updateInput)}},
+			Resolve: func(ctx context.Context, p graphql.Params) (any, error) {
+				id, err := idArg(p.Args["id"])
+				if err != nil {
+					return nil, err
+				}
+				if err := mayChange(ctx, id, auth.ScopeUsersWrite); err != nil {
+					return nil, err
+				}
+				in := p.Args["input"].(map[string]any)
+				u, err := svc.UpdateUser(ctx, id, str(in, "name"), str(in, "email"))
+				if err != nil {
+					return nil, err
+				}
+				loaderFrom(ctx).Prime(u.ID, u)
+				return u, nil
+			}},
+		{Name: "deleteUser", Type: graphql.NonNullOf(deletion),
+			Args: []*graphql.Arg{
+				{Name: "id", Type: graphql.NonNullOf(graphql.ID)},
+				{Name: "reason", Type: graphql.String, Description: "Why; required when deletions need approval."},
+			},
+			Resolve: func(ctx context.Context, p graphql.Params) (any, error) {
+				id, err := idArg(p.Args["id"])
+				if err != nil {
+					return nil, err
+				}
+				if err := mayChange(ctx, id, ""); err != nil {
+					return nil, err
+				}
+				if approvals != nil && !dryrun.Enabled(ctx) {
+					c, _ := auth.PrincipalFrom(ctx)
+					ap, err := approvals.Submit(ctx, c.Subject, approval.Action{
+						Kind:    KindBulkDelete,
+						Summary: "delete user " + strconv.Itoa(id),
+						Params:  map[string]string{"ids": strconv.Itoa(id)},
+					}, strings.TrimSpace(str(p.Args, "reason")))
+					if err != nil {
+						return nil, err
+					}
+					return map[string]any{"deleted": false, "approvalId": ap.ID}, nil
+				}
+				if err := svc.DeleteUser(ctx, id); err != nil {
+					return nil, err
+				}
+				loaderFrom(ctx).Clear(id)
+				return map[string]any{"deleted": true, "approvalId": nil}, nil
+			}},
+	}}
+
+	schema, err := graphql.New(queryType, mutationType, graphql.Options{
+		Context: func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, loaderKey{}, graphql.NewLoader(svc.GetUsers))
+		},
+		ErrorMessage: i18n.Message,
+	})
+	if err != nil {
+		panic(err) // the schema is fixed; this is a programming error
+	}
+	return schema
+}
+
+// mayChange is mayEdit for a mutation of user id: the user themselves
+// or an admin may make it, and, if scope is set, a service account
+// allowed scope. Deletes pass no scope, as bulk deletes are closed to
+// service accounts.
+func mayChange(ctx context.Context, id int, scope string) error {
+	c, ok := auth.PrincipalFrom(ctx)
+	if !ok {
+		return errAuthRequired
+	}
+	if c.Subject == strconv.Itoa(id) || c.HasRole(auth.RoleAdmin) {
+		return nil
+	}
+	if scope != "" && c.HasRole(auth.RoleService) && c.Allows(scope) {
+		return nil
+	}
+	return errForbidden
+}
+
+func loaderFrom(ctx context.Context) *userLoader { return ctx.Value(loaderKey{}).(*userLoader) }
+
+func idArg(v any) (int, error) {
+	id, err := strconv.Atoi(v.(string))
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %q", v)
+	}
+	return id, nil
+}
+
+func cursorOf(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("user:" + strconv.Itoa(id)))
+}
+
+func parseCursor(c string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(c)
+	if num, ok := strings.CutPrefix(string(raw), "user:"); err == nil && ok {
+		if id, err := strconv.Atoi(num); err == nil {
+			return id, nil
+		}
+	}
+	return 0, errors.New("invalid cursor")
+}
+
+// userPage is a UserConnection: the filter, and the page, loaded the
+// first time a field needs it.
+type userPage struct {
+	svc    *users.UserService
+	filter query.Spec
+	after  int
+	first  int
+
+	loaded bool
+	list   []users.User
+	more   bool
+	err    error
+}
+
+func newUserPage(svc *users.UserService, args map[string]any) (*userPage, error) {
+	p := &userPage{svc: svc, first: args["first"].(int)}
+	if p.first < 1 || p.first > maxPage {
+		return nil, fmt.Errorf("first must be between 1 and %d", maxPage)
+	}
+	if after, ok := args["after"].(string); ok {
+		id, err := parseCursor(after)
+		if err != nil {
+			return nil, err
+		}
+		p.after = id
+	}
+	var spec query.And
+	if f, ok := args["filter"].(map[string]any); ok {
+		if ids, ok := f["ids"].([]any); ok {
+			byID := make(query.Or, 0, len(ids))
+			for _, v := range ids {
+				id, err := idArg(v)
+				if err != nil {
+					return nil, err
+				}
+				byID = append(byID, query.Cmp{Field: query.FieldID, Op: query.OpEq, Value: id})
+			}
+			spec = append(spec, byID)
+		}
+		if s, ok := f["namePrefix"].(string); ok {
+			spec = append(spec, query.Cmp{Field: query.FieldName, Op: query.OpPrefix, Value: s})
+		}
+		if s, ok := f["email"].(string); ok {
+			spec = append(spec, query.Cmp{Field: query.FieldEmail, Op: query.OpEq, Value: s})
+		}
+		if t, ok := f["createdAfter"].(time.Time); ok {
+			spec = append(spec, query.Cmp{Field: query.FieldCreatedAt, Op: query.OpGt, Value: t})
+		}
+		if t, ok := f["createdBefore"].(time.Time); ok {
+			spec = append(spec, query.Cmp{Field: query.FieldCreatedAt, Op: query.OpLt, Value: t})
+		}
+	}
+	if len(spec) > 0 {
+		p.filter = spec
+	}
+	return p, nil
+}
+
+func (p *userPage) load(ctx context.Context) ([]users.User, error) {
+	if p.loaded {
+		return p.list, p.err
+	}
+	p.loaded = true
+	spec := query.And{query.Cmp{Field: query.FieldID, Op: query.OpGt, Value: p.after}}
+	if p.filter != nil {
+		spec = append(spec, p.filter)
+	}
+	list, err := p.svc.SearchUsers(ctx, spec)
+	if err != nil {
+		p.err = err
+		return nil, err
+	}
+	slices.SortFunc(list, func(a, b users.User) int { return a.ID - b.ID })
+	if len(list) > p.first {
+		list, p.more = list[:p.first], true
+	}
+	p.list = list
+	return list, nil
+}
@@ -0,0 +1,140 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"Go-Internals/approval"
+	"Go-Internals/auth"
+	"Go-Internals/users"
+)
+
+// gqlServer is a server with approvals and two users, Ada (ID 1) and
+// Bo (ID 2).
+func gqlServer(t *testing.T) (http.Handler, *auth.HS256, *users.UserService, *approval.Manager) {
+	t.Helper()
+	signer := &auth.HS256{Key: []byte("test key")}
+	svc := users.NewUserService(users.NewInMemoryUserRepo())
+	for _, u := range [][2]string{{"Ada", "ada@example.com"}, {"Bo", "bo@example.com"}} {
+		if _, err := svc.RegisterUser(context.Background(), u[0], u[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	approvals := approval.New(approval.Options{})
+	return New(Config{Service: svc, Auth: signer, Signer: signer, Approvals: approvals}), signer, svc, approvals
+}
+
+// userToken is a token for subject with roles.
+func userToken(t *testing.T, signer *auth.HS256, subject string, roles ...string) string {
+	t.Helper()
+	now := time.Now()
+	tok, err := signer.Sign(auth.Claims{Subject: subject, Roles: roles, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+type gqlResult struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func mutate(t *testing.T, h http.Handler, token, query string) gqlResult {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"query": query})
+	w := serve(h, token, "POST", "/graphql", "application/json", string(body))
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /graphql = %d: %s", w.Code, w.Body)
+	}
+	var res gqlResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+const (
+	updateAda = `mutation { updateUser(id: "1", input: {email: "mallory@example.com"}) { email } }`
+	deleteAda = `mutation { deleteUser(id: "1", reason: "asked to") { deleted approvalId } }`
+)
+
+func TestGraphQLMutationsRefused(t *testing.T) {
+	h, signer, svc, _ := gqlServer(t)
+	tests := []struct {
+		name, token, query, want string
+	}{
+		{"anonymous update", "", updateAda, "authentication required"},
+		{"anonymous delete", "", deleteAda, "authentication required"},
+		{"another user updates", userToken(t, signer, "2"), updateAda, "forbidden"},
+		{"another user deletes", userToken(t, signer, "2"), deleteAda, "forbidden"},
+		{"service deletes", serviceToken(t, signer, "users:write"), deleteAda, "forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := mutate(t, h, tt.token, tt.query)
+			if len(res.Errors) != 1 || res.Errors[0].Message != tt.want {
+				t.Fatalf("errors = %+v, want %q", res.Errors, tt.want)
+			}
+		})
+	}
+	u, err := svc.GetUser(context.Background(), 1)
+	if err != nil || u.Email != "ada@example.com" {
+		t.Fatalf("Ada is now %+v, %v", u, err)
+	}
+}
+
+func TestGraphQLUpdateAllowed(t *testing.T) {
+	h, signer, _, _ := gqlServer(t)
+	for name, token := range map[string]string{
+		"the user":        userToken(t, signer, "1"),
+		"an admin":        userToken(t, signer, "99", auth.RoleAdmin),
+		"a write service": serviceToken(t, signer, "users:write"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := mutate(t, h, token, `mutation { updateUser(id: "1", input: {name: "Ada L"}) { name } }`)
+			if len(res.Errors) > 0 || !strings.Contains(string(res.Data["updateUser"]), "Ada L") {
+				t.Fatalf("updateUser = %s, %+v", res.Data["updateUser"], res.Errors)
+			}
+		})
+	}
+}
+
+// With approvals, a delete waits for a second admin, however it is asked.
+func TestGraphQLDeleteNeedsApproval(t *testing.T) {
+	h, signer, svc, approvals := gqlServer(t)
+	res := mutate(t, h, userToken(t, signer, "99", auth.RoleAdmin), deleteAda)
+	var del struct {
+		Deleted    bool   `json:"deleted"`
+		ApprovalID string `json:"approvalId"`
+	}
+	if err := json.Unmarshal(res.Data["deleteUser"], &del); err != nil || len(res.Errors) > 0 {
+		t.Fatalf("deleteUser = %s, %+v", res.Data["deleteUser"], res.Errors)
+	}
+	if del.Deleted || del.ApprovalID == "" {
+		t.Fatalf("deleteUser = %+v, want an approval", del)
+	}
+	if _, err := svc.GetUser(context.Background(), 1); err != nil {
+		t.Fatalf("Ada deleted before approval: %v", err)
+	}
+
+	ctx := auth.WithPrincipal(context.Background(), auth.Claims{Subject: "98", Roles: []string{auth.RoleAdmin}})
+	if ap, err := approvals.Approve(ctx, del.ApprovalID, "98"); err != nil || ap.Status != approval.Executed {
+		t.Fatalf("Approve = %+v, %v", ap, err)
+	}
+	if _, err := svc.GetUser(context.Background(), 1); err == nil {
+		t.Fatal("Ada still there after approval")
+	}
+
+	// A reason is still required.
+	res = mutate(t, h, userToken(t, signer, "2"), `mutation { deleteUser(id: "2") { deleted } }`)
+	if len(res.Errors) != 1 {
+		t.Fatalf("deleteUser without a reason = %s, %+v", res.Data["deleteUser"], res.Errors)
+	}
+}
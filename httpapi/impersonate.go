@@ -0,0 +1,124 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Go-Internals/auth"
+	"Go-Internals/impersonate"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+IMPERSONATION
+-----------------------------------
+*/
+
+type impersonationHandlers struct {
+	m   *impersonate.Manager
+	svc *users.UserService
+}
+
+type impersonateRequest struct {
+	// Reason is kept in the audit trail.
+	Reason string `json:"reason" schema:"required,minLength=1,maxLength=500"`
+	Scope  string `json:"scope,omitempty" doc:"Space-separated, of users:read, users:write, products:read and products:write; default users:read."`
+	// TTL is in seconds.
+	TTL int `json:"ttl,omitempty" schema:"minimum=1" doc:"Seconds; default 15 minutes, at most an hour."`
+}
+
+func (h *impersonationHandlers) start(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var req impersonateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil {
+		badJSON(w, r, err)
+		return
+	}
+	if _, err := h.svc.GetUser(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	admin, _ := auth.PrincipalFrom(r.Context())
+	g, err := h.m.Start(r.Context(), admin.Subject, strconv.Itoa(id), req.Reason, req.Scope, time.Duration(req.TTL)*time.Second)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, g)
+}
+
+func (h *impersonationHandlers) list(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.m.List())
+}
+
+func (h *impersonationHandlers) end(w http.ResponseWriter, r *http.Request) {
+	if err := h.m.End(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ImpersonatedByHeader names, on every response to an impersonation
+// token, the admin behind it, so neither side can mistake it for the
+// user's own.
+const ImpersonatedByHeader = "X-Impersonated-By"
+
+// guardImpersonation limits impersonation tokens to the routes in
+// serviceScopes their scopes cover, mux telling which route a request
+// is for. It marks the responses and, with m, records every request made
+// with one. It runs inside auth.Authenticate.
+func guardImpersonation(mux *http.ServeMux, m *impersonate.Manager, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := auth.PrincipalFrom(r.Context())
+		if !ok || c.Act == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set(ImpersonatedByHeader, c.Act.Subject)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		if m != nil {
+			defer func() { m.Request(r.Context(), c, r.Method, r.URL.Path, sw.status) }()
+		}
+		switch scope, ok := routeScope(mux, r); {
+		case !ok:
+			http.Error(sw, "impersonation tokens cannot be used for admin operations", http.StatusForbidden)
+			return
+		case scope != "" && !c.Allows(scope):
+			sw.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			http.Error(sw, "the impersonation's scopes do not include "+scope, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// statusWriter keeps the status a response was sent with.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wrote {
+		w.status, w.wrote = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
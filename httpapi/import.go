@@ -0,0 +1,123 @@
+package httpapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"Go-Internals/i18n"
+	"Go-Internals/result"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+BULK IMPORT
+-----------------------------------
+*/
+
+// maxImportErrors bounds the per-line errors an import reports; the
+// count in failed covers the rest.
+const maxImportErrors = 100
+
+type importReport struct {
+	Created int           `json:"created"`
+	Failed  int           `json:"failed"`
+	Errors  []importError `json:"errors,omitempty"`
+	// Aborted says why reading stopped before the end of the body, e.g.
+	// it was over the size limit. The lines before it were imported.
+	Aborted string `json:"aborted,omitempty"`
+}
+
+type importError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importUsers registers one user per NDJSON line of the body, each
+// through RegisterUser as if POSTed alone, and reports the outcome of
+// all of them: a bad line fails that line, not the import. Bodies are
+// usually sent gzipped; the compression middleware has decoded them by
+// the time they get here.
+func (h *handlers) importUsers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.importFrom(r.Context(), r.Body))
+}
+
+// importFrom is the import itself, also run on a completed upload. Lines
+// are read a batch at a time and registered a few at once, so the users
+// of one batch may get their IDs out of line order; the report is still
+// by line.
+func (h *handlers) importFrom(ctx context.Context, body io.Reader) importReport {
+	var rep importReport
+	sc := bufio.NewScanner(body)
+	sc.Buffer(nil, 1<<16) // the limit POST /users has for one user
+	var batch []importLine
+	for line := 1; ; line++ {
+		more := sc.Scan()
+		if more {
+			if raw := bytes.TrimSpace(sc.Bytes()); len(raw) > 0 {
+				batch = append(batch, importLine{line, parseImportLine(raw)})
+			}
+		}
+		if len(batch) == importBatch || !more && len(batch) > 0 {
+			h.importBatch(ctx, batch, &rep)
+			batch = batch[:0]
+		}
+		if err := ctx.Err(); err != nil {
+			rep.Aborted = err.Error()
+			return rep
+		}
+		if !more {
+			break
+		}
+	}
+	if err := sc.Err(); err != nil {
+		rep.Aborted = err.Error()
+	}
+	return rep
+}
+
+const (
+	importBatch   = 64 // lines read before registering them
+	importWorkers = 4  // registrations at once
+)
+
+// importLine is one line of an import, parsed or failed.
+type importLine struct {
+	line int
+	req  result.Result[createRequest]
+}
+
+func parseImportLine(raw []byte) result.Result[createRequest] {
+	var req createRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return result.Fail[createRequest](errors.New("invalid JSON: " + err.Error()))
+	}
+	return result.Ok(req)
+}
+
+// importBatch registers the batch's parsed lines and adds every line's
+// outcome to rep, in line order.
+func (h *handlers) importBatch(ctx context.Context, batch []importLine, rep *importReport) {
+	created := result.ForEachPar(ctx, batch, importWorkers, func(ctx context.Context, l importLine) (users.User, error) {
+		req, err := l.req.Get()
+		if err != nil {
+			return users.User{}, err
+		}
+		return h.svc.RegisterUser(ctx, req.Name, req.Email)
+	})
+	for i, r := range created {
+		if err := r.Err(); err != nil {
+			rep.Failed++
+			if len(rep.Errors) < maxImportErrors {
+				rep.Errors = append(rep.Errors, importError{Line: batch[i].line, Error: i18n.Message(ctx, err)})
+			}
+			continue
+		}
+		rep.Created++
+	}
+}
@@ -0,0 +1,92 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+
+	"Go-Internals/keyset"
+)
+
+/*
+-----------------------------------
+KEY ROTATION
+-----------------------------------
+*/
+
+type keyHandlers struct {
+	sets      []*keyset.Set
+	reencrypt map[string]func(context.Context) (int, error)
+}
+
+// keySetResponse is a key set without its secrets.
+type keySetResponse struct {
+	Name     string           `json:"name"`
+	Versions []keyset.Version `json:"versions"`
+}
+
+type reencryptResponse struct {
+	// Reencrypted is how many records were sealed again under the
+	// current version.
+	Reencrypted int `json:"reencrypted"`
+}
+
+func (h *keyHandlers) set(w http.ResponseWriter, r *http.Request) (*keyset.Set, bool) {
+	for _, s := range h.sets {
+		if s.Name() == r.PathValue("name") {
+			return s, true
+		}
+	}
+	http.Error(w, "no such key set", http.StatusNotFound)
+	return nil, false
+}
+
+func (h *keyHandlers) list(w http.ResponseWriter, r *http.Request) {
+	out := make([]keySetResponse, len(h.sets))
+	for i, s := range h.sets {
+		out[i] = keySetResponse{Name: s.Name(), Versions: s.Versions()}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *keyHandlers) rotate(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.set(w, r)
+	if !ok {
+		return
+	}
+	v, err := s.Rotate(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func (h *keyHandlers) reencryptAll(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.set(w, r)
+	if !ok {
+		return
+	}
+	fn, ok := h.reencrypt[s.Name()]
+	if !ok {
+		http.Error(w, "what this key makes is not re-encrypted: it expires instead", http.StatusConflict)
+		return
+	}
+	n, err := fn(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reencryptResponse{Reencrypted: n})
+}
+
+func (h *keyHandlers) drop(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.set(w, r)
+	if !ok {
+		return
+	}
+	if err := s.Drop(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
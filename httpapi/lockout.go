@@ -0,0 +1,52 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"Go-Internals/lockout"
+)
+
+/*
+-----------------------------------
+LOCKOUTS
+-----------------------------------
+*/
+
+type lockoutHandlers struct {
+	g *lockout.Guard
+}
+
+// kindOf is the {kind} of r's path, false (and answered 400) if it is
+// neither account nor ip.
+func kindOf(w http.ResponseWriter, r *http.Request) (lockout.Kind, bool) {
+	switch k := lockout.Kind(r.PathValue("kind")); k {
+	case lockout.Account, lockout.IP:
+		return k, true
+	}
+	http.Error(w, "kind must be account or ip", http.StatusBadRequest)
+	return "", false
+}
+
+func (h *lockoutHandlers) list(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.g.List())
+}
+
+func (h *lockoutHandlers) get(w http.ResponseWriter, r *http.Request) {
+	kind, ok := kindOf(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.g.State(kind, r.PathValue("key")))
+}
+
+func (h *lockoutHandlers) unlock(w http.ResponseWriter, r *http.Request) {
+	kind, ok := kindOf(w, r)
+	if !ok {
+		return
+	}
+	if !h.g.Unlock(r.Context(), kind, r.PathValue("key")) {
+		http.Error(w, "not locked", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
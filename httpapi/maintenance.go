@@ -0,0 +1,58 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"Go-Internals/auth"
+	"Go-Internals/maintenance"
+)
+
+/*
+-----------------------------------
+MAINTENANCE
+-----------------------------------
+*/
+
+type maintenanceHandlers struct {
+	s *maintenance.Switch
+}
+
+type maintenanceRequest struct {
+	On     bool   `json:"on"`
+	Reason string `json:"reason,omitempty" schema:"maxLength=500"`
+	// RetryAfter is in seconds.
+	RetryAfter int `json:"retry_after,omitempty" schema:"minimum=1" doc:"What refused writes are told to wait, in seconds; default a minute."`
+}
+
+func (h *maintenanceHandlers) get(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.s.State())
+}
+
+// put answers straight away: a caller waiting for the drain polls GET
+// until in_flight is 0, rather than holding a request open that the
+// shedder would read as latency.
+func (h *maintenanceHandlers) put(w http.ResponseWriter, r *http.Request) {
+	var req maintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badJSON(w, r, err)
+		return
+	}
+	if !req.On {
+		writeJSON(w, http.StatusOK, h.s.Disable(r.Context()))
+		return
+	}
+	c, _ := auth.PrincipalFrom(r.Context())
+	writeJSON(w, http.StatusOK, h.s.Enable(r.Context(), c.Subject, req.Reason, time.Duration(req.RetryAfter)*time.Second))
+}
+
+// maintenanceExempt are the writes let through during maintenance: the
+// switch itself, and signing in and out, which change no data.
+func maintenanceExempt(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/maintenance", "/auth/refresh", "/auth/logout", "/auth/token", "/auth/2fa/verify":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"Go-Internals/activity"
+	"Go-Internals/auth"
+	"Go-Internals/oauth"
+	"Go-Internals/session"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+EXTERNAL SIGN-IN
+-----------------------------------
+*/
+
+type oauthHandlers struct {
+	m      *oauth.Manager
+	signer auth.Signer
+	// sessions, if set, opens a session for each sign-in, whose tokens
+	// it issues instead of signer.
+	sessions *session.Manager
+	history  *activity.History // may be nil
+}
+
+// signInResponse is a completed sign-in. A link started from
+// POST /users/{id}/identities/{provider} has no token: the caller has
+// one already.
+type signInResponse struct {
+	Token     string    `json:"token,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// RefreshToken and SessionID are set when sign-ins open sessions.
+	RefreshToken string         `json:"refresh_token,omitempty"`
+	SessionID    string         `json:"session_id,omitempty"`
+	User         users.User     `json:"user"`
+	Identity     oauth.Identity `json:"identity"`
+	Created      bool           `json:"created"`
+	Linked       bool           `json:"linked"`
+}
+
+type linkResponse struct {
+	// AuthorizationURL is where to send the user agent to sign in to the
+	// provider; it comes back to the callback.
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+func (h *oauthHandlers) providers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.m.Providers())
+}
+
+func (h *oauthHandlers) login(w http.ResponseWriter, r *http.Request) {
+	u, err := h.m.Begin(r.Context(), r.PathValue("provider"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// callback is where the provider sends the user agent back. A refusal
+// there (the user said no, say) comes as ?error= and is passed on.
+func (h *oauthHandlers) callback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "provider refused the sign-in: "+e+" "+q.Get("error_description"), http.StatusBadRequest)
+		return
+	}
+	if q.Get("state") == "" || q.Get("code") == "" {
+		http.Error(w, "state and code are required", http.StatusBadRequest)
+		return
+	}
+	res, err := h.m.Complete(r.Context(), r.PathValue("provider"), q.Get("state"), q.Get("code"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if res.Created && h.history != nil {
+		h.history.Record(r.Context(), res.User.ID, activity.Registration)
+	}
+	resp := signInResponse{User: res.User, Identity: res.Identity, Created: res.Created, Linked: res.Linked}
+	switch {
+	case res.LinkOnly:
+	case h.sessions != nil:
+		p, err := h.sessions.Start(r.Context(), strconv.Itoa(res.User.ID), []string{auth.RoleUser}, nil)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		resp.Token, resp.ExpiresAt, resp.RefreshToken, resp.SessionID = p.AccessToken, p.ExpiresAt, p.RefreshToken, p.SessionID
+	default:
+		now := time.Now()
+		resp.ExpiresAt = now.Add(h.m.SessionTTL()).UTC().Truncate(time.Second)
+		resp.Token, err = h.signer.Sign(auth.Claims{
+			Subject:   strconv.Itoa(res.User.ID),
+			Roles:     []string{auth.RoleUser},
+			IssuedAt:  now.Unix(),
+			ExpiresAt: resp.ExpiresAt.Unix(),
+		})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// self is the {id} of r's path if it is the caller's: linking and the
+// provider's tokens are the user's own business, not an admin's.
+func self(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return 0, false
+	}
+	c, ok := principal(w, r)
+	if !ok {
+		return 0, false
+	}
+	if c.Subject != strconv.Itoa(id) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *oauthHandlers) identities(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if !mayEdit(w, r, id) {
+		return
+	}
+	list, err := h.m.Identities(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (h *oauthHandlers) link(w http.ResponseWriter, r *http.Request) {
+	id, ok := self(w, r)
+	if !ok {
+		return
+	}
+	u, err := h.m.BeginLink(r.Context(), r.PathValue("provider"), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, linkResponse{AuthorizationURL: u})
+}
+
+func (h *oauthHandlers) unlink(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if !mayEdit(w, r, id) {
+		return
+	}
+	if err := h.m.Unlink(r.Context(), id, r.PathValue("provider")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *oauthHandlers) token(w http.ResponseWriter, r *http.Request) {
+	id, ok := self(w, r)
+	if !ok {
+		return
+	}
+	t, err := h.m.AccessToken(r.Context(), id, r.PathValue("provider"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, t)
+}
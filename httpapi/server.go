@@ -0,0 +1,1353 @@
+// Package httpapi exposes the user service over HTTP.
+//
+// Routing uses the standard library mux's method and wildcard patterns;
+// cross-cutting concerns (authentication, locale) are plain
+// func(http.Handler) http.Handler middleware applied around the mux.
+// Every route is added through an openapi.API, which serves the
+// description of all of them at /openapi.json (Swagger UI at /docs) and
+// rejects requests that do not fit it before a handler runs.
+//
+// /graphql serves the same service to clients that want to pick their
+// fields: queries for one user or a filtered, paged list, and mutations
+// to create, update and delete, the last two allowed whom PATCH
+// /users/{id} allows. Its schema, in SDL, is at /graphql/schema.
+//
+// With Config.Compression, responses are gzip- or deflate-compressed for
+// clients that accept it, and request bodies may be sent compressed;
+// POST /users/import expects its NDJSON that way.
+//
+// With Config.Uploads, an import or avatar too large to send in one
+// request goes through /uploads instead: started with its size, sent in
+// chunks that each carry a SHA-256, resumable from the offset the server
+// reports, and completed into the same handling as the one-shot route.
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"Go-Internals/abuse"
+	"Go-Internals/accesslog"
+	"Go-Internals/activity"
+	"Go-Internals/adaptive"
+	"Go-Internals/approval"
+	"Go-Internals/assets"
+	"Go-Internals/auth"
+	"Go-Internals/avatar"
+	"Go-Internals/backup"
+	"Go-Internals/bandwidth"
+	"Go-Internals/breadcrumb"
+	"Go-Internals/buildinfo"
+	"Go-Internals/catalog"
+	"Go-Internals/compression"
+	"Go-Internals/cpuprof"
+	"Go-Internals/crashreport"
+	"Go-Internals/ctxutil"
+	"Go-Internals/dedupe"
+	"Go-Internals/dryrun"
+	"Go-Internals/errortrack"
+	"Go-Internals/experiment"
+	"Go-Internals/fieldmask"
+	"Go-Internals/flightrec"
+	"Go-Internals/goroutines"
+	"Go-Internals/graphql"
+	"Go-Internals/httpsec"
+	"Go-Internals/i18n"
+	"Go-Internals/impersonate"
+	"Go-Internals/keyset"
+	"Go-Internals/loadshed"
+	"Go-Internals/lockout"
+	"Go-Internals/maintenance"
+	"Go-Internals/oauth"
+	"Go-Internals/openapi"
+	"Go-Internals/privacy"
+	"Go-Internals/quota"
+	"Go-Internals/reqid"
+	"Go-Internals/reqsign"
+	"Go-Internals/serviceaccount"
+	"Go-Internals/session"
+	"Go-Internals/twofactor"
+	"Go-Internals/upload"
+	"Go-Internals/users"
+	"Go-Internals/window"
+)
+
+// Config wires the server's dependencies. Auth and Admin are optional:
+// without Auth every request is anonymous, without Admin /admin/ is 404.
+type Config struct {
+	Service *users.UserService
+	Auth    auth.Verifier
+	// Lockout, if set with Auth, counts tokens that fail to verify,
+	// answering 429 for a locked account or a throttled address, and
+	// serves the admin-only GET /lockouts, GET /lockouts/{kind}/{key}
+	// and DELETE /lockouts/{kind}/{key} to unlock.
+	Lockout *lockout.Guard
+	// TwoFactor and Signer, if both set with Auth, serve TOTP enrollment
+	// and verification under /auth/2fa and refuse tokens whose roles the
+	// Manager requires a second factor for until they have passed one.
+	// Signer issues the tokens that have.
+	TwoFactor *twofactor.Manager
+	Signer    auth.Signer
+	// Sessions, if set with Auth, refuses tokens it revoked and serves
+	// POST /auth/refresh, POST /auth/logout and a user's sessions under
+	// /users/{id}/sessions. Sign-ins then open a session, answering with
+	// a refresh token as well.
+	Sessions *session.Manager
+	// ServiceAccounts, if set with Auth, issues service accounts' tokens
+	// at POST /auth/token and serves their admin-only management under
+	// /service-accounts. Their tokens pass only the routes their scopes
+	// cover (see serviceScopes).
+	ServiceAccounts *serviceaccount.Manager
+	// Impersonation, if set with Auth, lets admins act as a user with
+	// POST /users/{id}/impersonate and serves the admin-only
+	// /impersonations; every request made so is recorded with it.
+	Impersonation *impersonate.Manager
+	// Signatures, if set, verifies requests other services signed (see
+	// package reqsign), before tokens are.
+	Signatures *reqsign.Verifier
+	// Keys, if set with Auth, serves the admin-only rotation of these
+	// key sets under /keys: listing their versions, rotating and
+	// dropping one. Reencrypt re-encrypts what a set, by name, encrypted
+	// under its current version, at POST /keys/{name}/reencrypt.
+	Keys      []*keyset.Set
+	Reencrypt map[string]func(context.Context) (int, error)
+	// OAuth, if set with Signer, signs users in with external OpenID
+	// Connect providers under /auth/oauth/{provider}, issuing tokens with
+	// Signer, and serves a user's linked identities under
+	// /users/{id}/identities.
+	OAuth *oauth.Manager
+	Admin http.Handler
+	// Requests, if set, counts every request (for rate reporting).
+	Requests *window.Counter
+	// Limiter, if set, caps concurrent /users requests adaptively.
+	Limiter *adaptive.Limiter
+	// Shedder, if set, rejects low-priority requests under overload.
+	// /users/export, /users/import, long polls and /uploads are Batch;
+	// other requests get their X-Priority.
+	Shedder *loadshed.Shedder
+	// Crash, if set, turns handler panics into crash files and 500s.
+	Crash *crashreport.Reporter
+	// Privacy, if set, serves the admin-only data-subject routes
+	// GET /users/{id}/archive and POST /users/{id}/erase?mode=....
+	Privacy *privacy.Manager
+	// Approvals, if set with Auth, puts the destructive operations
+	// (erasure, POST /users/bulk-delete and POST /backups/restore) under
+	// the two-person rule: each answers 202 with a pending approval that
+	// another admin decides under /approvals.
+	Approvals *approval.Manager
+	// DryRun runs every write that can run dry as if it asked to with
+	// Dry-Run: true, and refuses the others with 503; see withDryRun.
+	DryRun bool
+	// Maintenance, if set, refuses writes with 503 while it is on; with
+	// Auth, admins switch it at GET and PUT /maintenance.
+	Maintenance *maintenance.Switch
+	// Restore, if set, serves the admin-only POST /backups/restore,
+	// loading a backup into the (empty) repository.
+	Restore func(ctx context.Context, seq uint64) (int, error)
+	// Dedupe, if set, serves the admin-only GET /users/duplicates and
+	// POST /users/{id}/merge?from=ID[&dry_run=true].
+	Dedupe *dedupe.Manager
+	// Activity, if set, records registrations and sign-ins, with the
+	// client's address, user agent and country, and serves a user's
+	// latest at GET /users/{id}/activity.
+	Activity *activity.History
+	// Experiments, if set, serves a user's variant of an A/B experiment,
+	// counting an exposure, at GET /users/{id}/experiments/{name}, and
+	// the counts per variant to admins at GET /experiments/stats.
+	Experiments *experiment.Service
+	// Products, if set, serves the catalogue under /products with the
+	// handlers repogen generated for it.
+	Products catalog.ProductRepository
+	// ModTimes, if set, adds Last-Modified to user responses (ETags are
+	// always sent).
+	ModTimes users.ModTimes
+	// Changes, if set, is what a long-polling GET /users?wait= waits on;
+	// without it the list is rebuilt every second to look for a change.
+	Changes users.ChangeFeed
+	// Avatars, if set, serves user avatars at /users/{id}/avatar: PUT (a
+	// multipart upload, by the user or an admin), GET and DELETE.
+	Avatars *avatar.Avatars
+	// Uploads, if set, serves resumable uploads under /uploads: a large
+	// import or an avatar sent in checksummed chunks, then completed.
+	Uploads *upload.Manager
+	// Bandwidth and ConnBandwidth, if set, cap how fast downloads
+	// (/users/export, /users/{id}/archive) are sent: all of them
+	// together, and those on any one connection.
+	Bandwidth     *bandwidth.Limiter
+	ConnBandwidth *bandwidth.Pool
+	// AccessLog, if set, logs every request, with the token's subject
+	// and the tenant.
+	AccessLog *accesslog.Logger
+	// Compression, if set, compresses responses for clients that accept
+	// it and decodes compressed request bodies.
+	Compression *compression.Options
+	// Security is CORS, the security headers and the cap on request
+	// bodies; nil is httpsec's defaults. Without a Limit of its own,
+	// uploads, imports and avatars are left to bound their bodies
+	// themselves.
+	Security *httpsec.Options
+	// Errors, if set, is told of every error answered with a 5xx.
+	Errors *errortrack.Tracker
+	// Timeout, if set, is every request's budget, except the Batch ones
+	// (exports, imports, uploads, long polls). A request that runs out
+	// answers 504, and its error, logged and tracked, lists the stages
+	// it went through and how long each took.
+	Timeout time.Duration
+	// Debug serves /debug/goroutines, grouped goroutine stacks, to
+	// admins.
+	Debug bool
+	// Profiler, if set, is told every request's latency, Batch ones
+	// aside, for its p99 trigger.
+	Profiler *cpuprof.Profiler
+	// Traces, if set, keeps the stages of slow and failed requests.
+	Traces *flightrec.Recorder
+	// Assets, if set, serves GET /version/assets: every file built into
+	// the binary, or overriding one, with its checksum.
+	Assets *assets.Bundle
+}
+
+// New returns the root handler.
+func New(cfg Config) http.Handler {
+	mux := http.NewServeMux()
+	api := openapi.New(mux, openapi.Options{
+		Title:   "users",
+		Version: "1",
+		OnInvalid: func(w http.ResponseWriter, r *http.Request, err error) {
+			writeError(w, r, i18n.Wrap(err, "request.invalid", nil))
+		},
+	})
+
+	h := &handlers{svc: cfg.Service, modTimes: cfg.ModTimes, changes: cfg.Changes, history: cfg.Activity}
+	limit := func(f http.HandlerFunc) http.Handler { return f }
+	if cfg.Limiter != nil {
+		mw := adaptive.Middleware(cfg.Limiter, 1)
+		limit = func(f http.HandlerFunc) http.Handler {
+			// The wait for a slot is a stage of its own: under load it
+			// is where a request's budget goes.
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				admitted := breadcrumb.Stage(r.Context(), "limiter")
+				mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					admitted()
+					f(w, r)
+				})).ServeHTTP(w, r)
+			})
+		}
+	}
+	var errBody errorBody
+	id := []openapi.Param{openapi.PathInt("id")}
+	fields := openapi.Query("fields", "the fields to answer with, e.g. id,name; default all", &openapi.Schema{Type: "string"})
+	tags := []string{"users"}
+	api.Add(
+		openapi.Route{Operation: openapi.Operation{Pattern: "GET /version", Summary: "Running build",
+			Description: "The version, VCS revision and commit time of the running binary.",
+			Responses:   map[int]any{http.StatusOK: buildinfo.Info{}}},
+			Handler: buildinfo.Handler()},
+		openapi.Route{Operation: openapi.Operation{Pattern: "GET /healthz", Summary: "Liveness probe",
+			Responses: map[int]any{http.StatusNoContent: nil}},
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})},
+		openapi.Route{Operation: openapi.Operation{Pattern: "GET /users/export", Summary: "Stream every user as NDJSON", Tags: tags,
+			Responses: map[int]any{http.StatusOK: openapi.Content{Type: "application/x-ndjson", Body: users.Enriched{}}}},
+			Handler: limit(h.export)},
+		openapi.Route{Operation: openapi.Operation{Pattern: "GET /users", Summary: "List users", Tags: tags,
+			Description: "With wait and If-None-Match, a long poll: answers when the list no longer matches the ETag, or with 304 after wait.",
+			Params: []openapi.Param{
+				openapi.Query("wait", "how long to wait for a change, e.g. 30s; at most 60s", &openapi.Schema{Type: "string"}),
+				openapi.Query("sort", "fields to order by, each descending with a leading -: id, name, created_at; e.g. name,-created_at", &openapi.Schema{Type: "string"}),
+				fields,
+			},
+			Responses: map[int]any{http.StatusOK: []users.Enriched{}, http.StatusNotModified: nil, http.StatusBadRequest: errBody}},
+			Handler: h.pollList(limit(h.list))},
+		openapi.Route{Operation: openapi.Operation{Pattern: "POST /users", Summary: "Register a user", Tags: tags, Body: createRequest{},
+			Params:    []openapi.Param{dryRunParam},
+			Responses: map[int]any{http.StatusCreated: users.Enriched{}, http.StatusBadRequest: errBody, http.StatusForbidden: errBody, http.StatusConflict: errBody}},
+			Handler: limit(h.create)},
+		openapi.Route{Operation: openapi.Operation{Pattern: "POST /users/import", Summary: "Register users from NDJSON", Tags: tags,
+			Description: "Admin only. One createRequest per line, gzip or deflate Content-Encoding welcome; a bad line fails alone and is reported.",
+			Body:        openapi.Content{Type: "application/x-ndjson", Body: createRequest{}}, Auth: true,
+			Responses: map[int]any{http.StatusOK: importReport{}}},
+			Handler: auth.RequireRole(auth.RoleAdmin)(http.HandlerFunc(h.importUsers))},
+		openapi.Route{Operation: openapi.Operation{Pattern: "GET /users/{id}", Summary: "Get a user", Tags: tags, Params: append(id, fields),
+			Responses: map[int]any{http.StatusOK: users.Enriched{}, http.StatusNotModified: nil, http.StatusBadRequest: errBody, http.StatusNotFound: errBody}},
+			Handler: limit(h.get)},
+		openapi.Route{Operation: openapi.Operation{Pattern: "PATCH /users/{id}", Summary: "Change some of a user's fields", Tags: tags,
+			Description: "The user themselves or an admin. A JSON Merge Patch or a JSON Patch against the user's JSON; id, created_at and avatar_url cannot be patched. " +
+				"With If-Match set to the ETag from a GET of the whole user (no fields), answers 412 if the user has changed since.",
+			Params: append(id, dryRunParam), Auth: true,
+			Body: []openapi.Content{
+				{Type: users.MergePatchType, Body: map[string]any{}},
+				{Type: users.JSONPatchType, Body: []users.PatchOp{}},
+			},
+			Responses: map[int]any{http.StatusOK: users.Enriched{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody,
+				http.StatusConflict: errBody, http.StatusPreconditionFailed: errBody}},
+			Handler: limit(h.patch)},
+	)
+	gql := newGraphQL(cfg.Service, cfg.Activity, cfg.Approvals)
+	gqlTags := []string{"graphql"}
+	gqlResponses := map[int]any{http.StatusOK: graphql.Response{}, http.StatusBadRequest: graphql.Response{}}
+	str := &openapi.Schema{Type: "string"}
+	api.Add(
+		openapi.Route{Operation: openapi.Operation{Pattern: "POST /graphql", Summary: "Run a GraphQL query or mutation", Tags: gqlTags,
+			Description: "Field errors still answer 200; 400 means the request never ran. updateUser and deleteUser are for the user themselves or an admin, and with approvals deleteUser waits for another admin.", Body: graphql.Request{},
+			Params: []openapi.Param{dryRunParam}, Responses: gqlResponses},
+			Handler: limit(scoreRegistrations(gql.ServeHTTP))},
+		openapi.Route{Operation: openapi.Operation{Pattern: "GET /graphql", Summary: "Run a GraphQL query", Tags: gqlTags,
+			Description: "Queries only; mutations must be POSTed.",
+			Params: []openapi.Param{
+				openapi.Query("query", "the GraphQL document", str),
+				openapi.Query("operationName", "which operation of the document to run", str),
+				openapi.Query("variables", "a JSON object of variable values", str),
+			},
+			Responses: gqlResponses},
+			Handler: limit(gql.ServeHTTP)},
+		openapi.Route{Operation: openapi.Operation{Pattern: "GET /graphql/schema", Summary: "The GraphQL schema in SDL", Tags: gqlTags,
+			Responses: map[int]any{http.StatusOK: openapi.Content{Type: "text/plain", Body: ""}}},
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				_, _ = io.WriteString(w, gql.SDL())
+			})},
+	)
+	if cfg.Privacy != nil {
+		p := &privacyHandlers{gated: gated{cfg.Approvals}, m: cfg.Privacy}
+		if cfg.Approvals != nil {
+			cfg.Approvals.Handle(KindErase, eraseFunc(cfg.Privacy))
+		}
+		admin := auth.RequireRole(auth.RoleAdmin)
+		mode := openapi.Query("mode", "what erasure does; default delete",
+			&openapi.Schema{Type: "string", Enum: []any{string(privacy.Delete), string(privacy.Anonymize)}})
+		reason := openapi.Query("reason", "why; required when erasures need approval", &openapi.Schema{Type: "string"})
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /users/{id}/archive", Summary: "Export everything stored about a user", Tags: []string{"privacy"},
+				Description: "Admin only.", Params: id, Auth: true,
+				Responses: map[int]any{http.StatusOK: privacy.Archive{}, http.StatusNotFound: errBody}},
+				Handler: admin(http.HandlerFunc(p.archive))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /users/{id}/erase", Summary: "Erase or anonymize a user", Tags: []string{"privacy"},
+				Description: "Admin only. Answers 200 with complete=false if a store failed; retry. With approvals, answers 202 with the approval instead, and erases once another admin approves it.",
+				Params:      append(id, mode, reason), Auth: true,
+				Responses: map[int]any{http.StatusOK: eraseResponse{}, http.StatusAccepted: approval.Approval{}, http.StatusNotFound: errBody}},
+				Handler: admin(http.HandlerFunc(p.erase))},
+		)
+	}
+
+	if cfg.Dedupe != nil {
+		d := &dedupeHandlers{m: cfg.Dedupe}
+		admin := auth.RequireRole(auth.RoleAdmin)
+		from := openapi.Query("from", "the duplicate to merge into the user", &openapi.Schema{Type: "integer"})
+		dryRun := openapi.Query("dry_run", "report what the merge would do without doing it", &openapi.Schema{Type: "boolean"})
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /users/duplicates", Summary: "List likely duplicate users", Tags: []string{"dedupe"},
+				Description: "Admin only. Pairs with the same normalized email or similar names, best first.", Auth: true,
+				Responses: map[int]any{http.StatusOK: []dedupe.Candidate{}}},
+				Handler: admin(http.HandlerFunc(d.find))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /users/{id}/merge", Summary: "Merge a duplicate into a user", Tags: []string{"dedupe"},
+				Description: "Admin only. Answers 200 with complete=false if a store failed; retry.", Params: append(id, from, dryRun), Auth: true,
+				Responses: map[int]any{http.StatusOK: mergeResponse{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody}},
+				Handler: admin(http.HandlerFunc(d.merge))},
+		)
+	}
+
+	if cfg.Activity != nil {
+		ah := &activityHandlers{svc: cfg.Service, history: cfg.Activity}
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /users/{id}/activity", Summary: "A user's recent registrations and sign-ins", Tags: tags,
+				Description: "The user themselves or an admin. Newest first, with the address, user agent and country of each; a sign-in is the first request with a new token.",
+				Params:      append(id, openapi.Query("limit", "how many events; default 20, at most 100", &openapi.Schema{Type: "integer"})), Auth: true,
+				Responses: map[int]any{http.StatusOK: []activity.Event{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody}},
+				Handler: http.HandlerFunc(ah.recent)},
+		)
+	}
+
+	if cfg.Experiments != nil {
+		eh := &experimentHandlers{svc: cfg.Service, experiments: cfg.Experiments}
+		name := openapi.Param{Name: "name", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}
+		eTags := []string{"experiments"}
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /users/{id}/experiments/{name}", Summary: "A user's variant of an experiment", Tags: eTags,
+				Description: "The user themselves or an admin. The same variant every time; each answer counts as an exposure, so ask as the variant is shown. enrolled=false if the experiment's flag leaves the user out.",
+				Params:      append(id, name), Auth: true,
+				Responses: map[int]any{http.StatusOK: assignment{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody}},
+				Handler: http.HandlerFunc(eh.assign)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /experiments/stats", Summary: "Exposures per variant of every experiment", Tags: eTags,
+				Description: "Admin only. Counted since the experiment was defined, on this instance.", Auth: true,
+				Responses: map[int]any{http.StatusOK: []experiment.Stats{}}},
+				Handler: auth.RequireRole(auth.RoleAdmin)(cfg.Experiments.StatsHandler())},
+		)
+	}
+
+	if cfg.Lockout != nil {
+		l := &lockoutHandlers{g: cfg.Lockout}
+		admin := auth.RequireRole(auth.RoleAdmin)
+		key := []openapi.Param{
+			{Name: "kind", In: "path", Required: true, Schema: &openapi.Schema{Type: "string", Enum: []any{string(lockout.Account), string(lockout.IP)}}},
+			{Name: "key", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}},
+		}
+		secTags := []string{"security"}
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /lockouts", Summary: "Accounts and addresses with failed sign-ins", Tags: secTags,
+				Description: "Admin only. The locked ones first, by when they unlock; failures decay, so a count is as of now.", Auth: true,
+				Responses: map[int]any{http.StatusOK: []lockout.State{}}},
+				Handler: admin(http.HandlerFunc(l.list))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /lockouts/{kind}/{key}", Summary: "The lockout state of an account or address", Tags: secTags,
+				Description: "Admin only. An account is a token subject, a user's ID for their tokens.", Params: key, Auth: true,
+				Responses: map[int]any{http.StatusOK: lockout.State{}, http.StatusBadRequest: errBody}},
+				Handler: admin(http.HandlerFunc(l.get))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "DELETE /lockouts/{kind}/{key}", Summary: "Unlock an account or address", Tags: secTags,
+				Description: "Admin only. Clears its failures too.", Params: key, Auth: true,
+				Responses: map[int]any{http.StatusNoContent: nil, http.StatusBadRequest: errBody, http.StatusNotFound: errBody}},
+				Handler: admin(http.HandlerFunc(l.unlock))},
+		)
+	}
+
+	if cfg.TwoFactor != nil && cfg.Signer != nil {
+		t := &twoFactorHandlers{svc: cfg.Service, m: cfg.TwoFactor, signer: cfg.Signer, guard: cfg.Lockout, sessions: cfg.Sessions}
+		tfTags := []string{"two-factor"}
+		tfErrors := map[int]any{http.StatusBadRequest: errBody, http.StatusNotFound: errBody, http.StatusConflict: errBody}
+		with := func(status int, body any, errs map[int]any) map[int]any {
+			out := map[int]any{status: body}
+			for k, v := range errs {
+				out[k] = v
+			}
+			return out
+		}
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /auth/2fa", Summary: "The caller's second factor", Tags: tfTags, Auth: true,
+				Responses: map[int]any{http.StatusOK: twofactor.Status{}}},
+				Handler: http.HandlerFunc(t.status)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /auth/2fa/enroll", Summary: "Start setting up an authenticator app", Tags: tfTags,
+				Description: "Answers with the secret and the otpauth:// URI to show as a QR code; the factor is on once confirmed. Starts over if an enrollment is pending; 409 if a factor is on.",
+				Auth:        true, Responses: with(http.StatusOK, twofactor.Enrollment{}, tfErrors)},
+				Handler: http.HandlerFunc(t.enroll)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /auth/2fa/confirm", Summary: "Switch the second factor on", Tags: tfTags,
+				Description: "With a code the app shows. Answers with the recovery codes, shown this once, and a token that has passed the second factor.",
+				Body:        codeRequest{}, Auth: true, Responses: with(http.StatusOK, recoveryResponse{}, tfErrors)},
+				Handler: http.HandlerFunc(t.confirm)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /auth/2fa/verify", Summary: "Pass the second factor", Tags: tfTags,
+				Description: "With a current code, each accepted once, or a recovery code, which is used up. Answers with the caller's token marked as having passed it; wrong codes count towards a lockout.",
+				Body:        codeRequest{}, Auth: true, Responses: with(http.StatusOK, tokenResponse{}, tfErrors)},
+				Handler: http.HandlerFunc(t.verify)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /auth/2fa/recovery-codes", Summary: "Replace the recovery codes", Tags: tfTags,
+				Description: "With a token that has passed the second factor. The old codes stop working.",
+				Auth:        true, Responses: with(http.StatusOK, recoveryResponse{}, tfErrors)},
+				Handler: http.HandlerFunc(t.regenerate)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "DELETE /auth/2fa", Summary: "Switch the second factor off", Tags: tfTags,
+				Description: "With a token that has passed it.", Auth: true,
+				Responses: map[int]any{http.StatusNoContent: nil, http.StatusForbidden: errBody, http.StatusNotFound: errBody}},
+				Handler: http.HandlerFunc(t.disable)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "DELETE /auth/2fa/{account}", Summary: "Reset an account's second factor", Tags: tfTags,
+				Description: "Admin only, for a lost device and lost recovery codes. An account is a token subject, a user's ID for their tokens.",
+				Params:      []openapi.Param{{Name: "account", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}}, Auth: true,
+				Responses: map[int]any{http.StatusNoContent: nil, http.StatusNotFound: errBody}},
+				Handler: auth.RequireRole(auth.RoleAdmin)(http.HandlerFunc(t.reset))},
+		)
+	}
+
+	if cfg.Sessions != nil && cfg.Auth != nil {
+		sh := &sessionHandlers{m: cfg.Sessions}
+		sTags := []string{"sessions"}
+		sid := []openapi.Param{openapi.PathInt("id"), {Name: "sid", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}}
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /auth/refresh", Summary: "Trade a refresh token for new tokens", Tags: sTags,
+				Description: "Without an access token. The refresh token is spent: the answer has the next one. Presenting a spent one again ends the session, since only a stolen copy would be.",
+				Body:        refreshRequest{}, Responses: map[int]any{http.StatusOK: session.Pair{}, http.StatusBadRequest: errBody, http.StatusUnauthorized: errBody}},
+				Handler: http.HandlerFunc(sh.refresh)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /auth/logout", Summary: "End the caller's session", Tags: sTags,
+				Description: "Its access tokens stop working at once and its refresh token is spent. A token from outside a session is revoked alone.",
+				Auth:        true, Responses: map[int]any{http.StatusNoContent: nil, http.StatusNotFound: errBody, http.StatusConflict: errBody}},
+				Handler: http.HandlerFunc(sh.logout)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /users/{id}/sessions", Summary: "A user's open sessions", Tags: sTags,
+				Description: "The user themselves or an admin. Newest first.", Params: id, Auth: true,
+				Responses: map[int]any{http.StatusOK: []session.Info{}, http.StatusBadRequest: errBody}},
+				Handler: http.HandlerFunc(sh.list)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "DELETE /users/{id}/sessions/{sid}", Summary: "End one of a user's sessions", Tags: sTags,
+				Description: "The user themselves or an admin.", Params: sid, Auth: true,
+				Responses: map[int]any{http.StatusNoContent: nil, http.StatusNotFound: errBody}},
+				Handler: http.HandlerFunc(sh.end)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "DELETE /users/{id}/sessions", Summary: "Sign a user out everywhere", Tags: sTags,
+				Description: "The user themselves or an admin. Ends every session and revokes every token issued to the user until now, tokens from outside sessions included.",
+				Params:      id, Auth: true, Responses: map[int]any{http.StatusNoContent: nil, http.StatusBadRequest: errBody}},
+				Handler: http.HandlerFunc(sh.endAll)},
+		)
+	}
+
+	if cfg.ServiceAccounts != nil && cfg.Auth != nil {
+		sa := &serviceAccountHandlers{m: cfg.ServiceAccounts, guard: cfg.Lockout}
+		admin := auth.RequireRole(auth.RoleAdmin)
+		saTags := []string{"service-accounts"}
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /auth/token", Summary: "Issue a service account a token", Tags: saTags,
+				Description: "The OAuth 2.0 client credentials grant, with the client ID and secret as Basic authentication or form fields. Answers with a bearer token limited to the scopes asked for, or to all the account's; failures count towards the address's lockout.",
+				Body:        openapi.Content{Type: "application/x-www-form-urlencoded", Body: tokenRequest{}},
+				Responses:   map[int]any{http.StatusOK: serviceaccount.Token{}, http.StatusBadRequest: oauthError{}, http.StatusUnauthorized: oauthError{}, http.StatusTooManyRequests: errBody}},
+				Handler: http.HandlerFunc(sa.token)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /service-accounts", Summary: "List service accounts", Tags: saTags,
+				Description: "Admin only.", Auth: true, Responses: map[int]any{http.StatusOK: []serviceaccount.ServiceAccount{}}},
+				Handler: admin(http.HandlerFunc(sa.list))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /service-accounts", Summary: "Create a service account", Tags: saTags,
+				Description: "Admin only. Answers with its client ID and secret; the secret is not kept, and is shown this once.",
+				Body:        createServiceAccountRequest{}, Auth: true,
+				Responses: map[int]any{http.StatusCreated: secretResponse{}, http.StatusBadRequest: errBody, http.StatusConflict: errBody}},
+				Handler: admin(http.HandlerFunc(sa.create))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /service-accounts/{id}", Summary: "Get a service account", Tags: saTags,
+				Description: "Admin only.", Params: id, Auth: true, Responses: map[int]any{http.StatusOK: serviceaccount.ServiceAccount{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody}},
+				Handler: admin(http.HandlerFunc(sa.get))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "PATCH /service-accounts/{id}", Summary: "Change a service account's scopes, description or state", Tags: saTags,
+				Description: "Admin only. Narrowing its scopes or disabling it revokes the tokens it holds.",
+				Params:      id, Body: serviceaccount.Patch{}, Auth: true, Responses: map[int]any{http.StatusOK: serviceaccount.ServiceAccount{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody}},
+				Handler: admin(http.HandlerFunc(sa.update))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /service-accounts/{id}/rotate", Summary: "Issue a service account a new secret", Tags: saTags,
+				Description: "Admin only. The new secret is shown this once. The old one keeps working for a while, so the client can be moved over, unless immediate=true, which also revokes the tokens it holds.",
+				Params:      append(id, openapi.Query("immediate", "end the old secret now, for one that leaked", &openapi.Schema{Type: "boolean"})),
+				Auth:        true, Responses: map[int]any{http.StatusOK: secretResponse{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody}},
+				Handler: admin(http.HandlerFunc(sa.rotate))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "DELETE /service-accounts/{id}", Summary: "Delete a service account", Tags: saTags,
+				Description: "Admin only. Revokes the tokens it holds.", Params: id, Auth: true,
+				Responses: map[int]any{http.StatusNoContent: nil, http.StatusNotFound: errBody}},
+				Handler: admin(http.HandlerFunc(sa.delete))},
+		)
+	}
+
+	if cfg.Auth != nil {
+		b := &bulkDeleteHandlers{gated: gated{cfg.Approvals}, svc: cfg.Service}
+		admin := auth.RequireRole(auth.RoleAdmin)
+		api.Add(openapi.Route{Operation: openapi.Operation{Pattern: "POST /users/bulk-delete", Summary: "Delete many users", Tags: []string{"users"},
+			Description: "Admin only. Answers which were deleted, already gone or failed. With approvals, answers 202 with the approval instead, and deletes once another admin approves it.",
+			Body:        bulkDeleteRequest{}, Auth: true,
+			Responses: map[int]any{http.StatusOK: bulkDeleteResponse{}, http.StatusAccepted: approval.Approval{}, http.StatusBadRequest: errBody}},
+			Handler: admin(http.HandlerFunc(b.delete))})
+		if cfg.Approvals != nil {
+			cfg.Approvals.Handle(KindBulkDelete, bulkDeleteFunc(cfg.Service))
+		}
+		bu := &bulkUpdateHandlers{svc: cfg.Service}
+		api.Add(openapi.Route{Operation: openapi.Operation{Pattern: "POST /users/bulk-update", Summary: "Change every user matching a filter", Tags: []string{"users"},
+			Description: "Admin only. Each patched user is checked as PATCH /users/{id} checks one; if any fails, none is changed. Answers how many matched and were changed.",
+			Body:        bulkUpdateRequest{}, Auth: true, Params: []openapi.Param{dryRunParam},
+			Responses: map[int]any{http.StatusOK: users.BulkUpdate{}, http.StatusBadRequest: errBody, http.StatusConflict: errBody}},
+			Handler: admin(http.HandlerFunc(bu.update))})
+		st := &userStatsHandlers{svc: cfg.Service}
+		api.Add(openapi.Route{Operation: openapi.Operation{Pattern: "GET /users/stats", Summary: "Count users and their registrations per day", Tags: []string{"users"},
+			Description: "Admin only. Days are UTC.", Auth: true,
+			Params: []openapi.Param{
+				openapi.Query("created_after", "count only users created after this time", &openapi.Schema{Type: "string", Format: "date-time"}),
+				openapi.Query("created_before", "count only users created before this time", &openapi.Schema{Type: "string", Format: "date-time"}),
+			},
+			Responses: map[int]any{http.StatusOK: users.Aggregates{}, http.StatusBadRequest: errBody}},
+			Handler: admin(http.HandlerFunc(st.stats))})
+	}
+
+	if cfg.Assets != nil {
+		api.Add(openapi.Route{Operation: openapi.Operation{Pattern: "GET /version/assets", Summary: "Built-in files",
+			Description: "The templates, catalogs, dashboard files and data served, each with its SHA-256, and a digest of them all to compare deploys by.",
+			Responses:   map[int]any{http.StatusOK: assets.Manifest{}}},
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				m, err := cfg.Assets.Manifest()
+				if err != nil {
+					writeError(w, r, err)
+					return
+				}
+				writeJSON(w, http.StatusOK, m)
+			})})
+	}
+
+	if cfg.Restore != nil && cfg.Auth != nil {
+		rs := &restoreHandlers{gated: gated{cfg.Approvals}, restore: cfg.Restore}
+		admin := auth.RequireRole(auth.RoleAdmin)
+		api.Add(openapi.Route{Operation: openapi.Operation{Pattern: "POST /backups/restore", Summary: "Restore from a backup", Tags: []string{"backup"},
+			Description: "Admin only. Loads a backup into the repository, which must be empty: 409 otherwise. With approvals, answers 202 with the approval instead, and restores once another admin approves it.",
+			Body:        restoreRequest{}, Auth: true,
+			Responses: map[int]any{http.StatusOK: restoreResponse{}, http.StatusAccepted: approval.Approval{}, http.StatusNotFound: errBody, http.StatusConflict: errBody}},
+			Handler: admin(http.HandlerFunc(rs.run))})
+		if cfg.Approvals != nil {
+			cfg.Approvals.Handle(KindRestore, restoreFunc(cfg.Restore))
+		}
+	}
+
+	if cfg.Approvals != nil && cfg.Auth != nil {
+		ap := &approvalHandlers{m: cfg.Approvals}
+		admin := auth.RequireRole(auth.RoleAdmin)
+		aTags := []string{"approvals"}
+		aid := []openapi.Param{{Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}}
+		status := openapi.Query("status", "only those with this status", &openapi.Schema{Type: "string",
+			Enum: []any{string(approval.Pending), string(approval.Running), string(approval.Executed), string(approval.Failed), string(approval.Rejected), string(approval.Expired)}})
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /approvals", Summary: "List approvals", Tags: aTags,
+				Description: "Admin only. Newest first; decided ones are kept for a while.", Params: []openapi.Param{status}, Auth: true,
+				Responses: map[int]any{http.StatusOK: []approval.Approval{}}},
+				Handler: admin(http.HandlerFunc(ap.list))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /approvals/{id}", Summary: "Get an approval", Tags: aTags,
+				Description: "Admin only.", Params: aid, Auth: true,
+				Responses: map[int]any{http.StatusOK: approval.Approval{}, http.StatusNotFound: errBody}},
+				Handler: admin(http.HandlerFunc(ap.get))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /approvals/{id}/approve", Summary: "Approve, and run, an operation", Tags: aTags,
+				Description: "Admin only, and not whoever asked for it: 403. Answers 200 once it has run, with status failed if it did not succeed; 410 once expired.",
+				Params:      aid, Auth: true,
+				Responses: map[int]any{http.StatusOK: approval.Approval{}, http.StatusForbidden: errBody, http.StatusNotFound: errBody, http.StatusConflict: errBody, http.StatusGone: errBody}},
+				Handler: admin(http.HandlerFunc(ap.approve))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /approvals/{id}/reject", Summary: "Reject an operation", Tags: aTags,
+				Description: "Admin only. Whoever asked for it may, to withdraw it.", Params: aid, Auth: true,
+				Responses: map[int]any{http.StatusOK: approval.Approval{}, http.StatusNotFound: errBody, http.StatusConflict: errBody, http.StatusGone: errBody}},
+				Handler: admin(http.HandlerFunc(ap.reject))},
+		)
+	}
+
+	if cfg.Maintenance != nil && cfg.Auth != nil {
+		mh := &maintenanceHandlers{s: cfg.Maintenance}
+		admin := auth.RequireRole(auth.RoleAdmin)
+		mTags := []string{"maintenance"}
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /maintenance", Summary: "Whether the service is in maintenance", Tags: mTags,
+				Description: "Admin only.", Auth: true,
+				Responses: map[int]any{http.StatusOK: maintenance.State{}}},
+				Handler: admin(http.HandlerFunc(mh.get))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "PUT /maintenance", Summary: "Switch maintenance on or off", Tags: mTags,
+				Description: "Admin only. While on, writes are answered 503 with Retry-After, reads as usual, and background jobs are paused. in_flight counts the writes and jobs still under way: poll GET until it is 0 to know nothing is writing.",
+				Body:        maintenanceRequest{}, Auth: true,
+				Responses: map[int]any{http.StatusOK: maintenance.State{}, http.StatusBadRequest: errBody}},
+				Handler: admin(http.HandlerFunc(mh.put))},
+		)
+	}
+
+	if cfg.Impersonation != nil && cfg.Auth != nil {
+		im := &impersonationHandlers{m: cfg.Impersonation, svc: cfg.Service}
+		admin := auth.RequireRole(auth.RoleAdmin)
+		iTags := []string{"impersonation"}
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /users/{id}/impersonate", Summary: "Act as a user", Tags: iTags,
+				Description: "Admin only. Answers with a token for the user, limited to the scopes asked for and lasting at most an hour. Requests made with it are the user's, but answered with X-Impersonated-By, refused on admin routes and audited one by one.",
+				Params:      []openapi.Param{openapi.PathInt("id")}, Body: impersonateRequest{}, Auth: true,
+				Responses: map[int]any{http.StatusCreated: impersonate.Grant{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody}},
+				Handler: admin(http.HandlerFunc(im.start))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /impersonations", Summary: "The impersonations under way", Tags: iTags,
+				Description: "Admin only.", Auth: true,
+				Responses: map[int]any{http.StatusOK: []impersonate.Impersonation{}}},
+				Handler: admin(http.HandlerFunc(im.list))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "DELETE /impersonations/{id}", Summary: "End an impersonation", Tags: iTags,
+				Description: "Admin only. Revokes its token.",
+				Params:      []openapi.Param{{Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}}, Auth: true,
+				Responses: map[int]any{http.StatusNoContent: nil, http.StatusNotFound: errBody, http.StatusConflict: errBody}},
+				Handler: admin(http.HandlerFunc(im.end))},
+		)
+	}
+
+	if len(cfg.Keys) > 0 && cfg.Auth != nil {
+		k := &keyHandlers{sets: cfg.Keys, reencrypt: cfg.Reencrypt}
+		admin := auth.RequireRole(auth.RoleAdmin)
+		kTags := []string{"keys"}
+		name := openapi.Param{Name: "name", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /keys", Summary: "The signing and encryption keys' versions", Tags: kTags,
+				Description: "Admin only. Secrets are never shown.", Auth: true,
+				Responses: map[int]any{http.StatusOK: []keySetResponse{}}},
+				Handler: admin(http.HandlerFunc(k.list))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /keys/{name}/rotate", Summary: "Rotate a key", Tags: kTags,
+				Description: "Admin only. A new random version signs or encrypts from now on; the ones before still verify or decrypt until dropped.",
+				Params:      []openapi.Param{name}, Auth: true,
+				Responses: map[int]any{http.StatusOK: keyset.Version{}, http.StatusNotFound: errBody, http.StatusConflict: errBody}},
+				Handler: admin(http.HandlerFunc(k.rotate))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /keys/{name}/reencrypt", Summary: "Re-encrypt under the current version", Tags: kTags,
+				Description: "Admin only. Seals again what older versions of the key encrypted, so they can be dropped. Tokens are not re-signed: they expire, and sessions refresh onto the current version.",
+				Params:      []openapi.Param{name}, Auth: true,
+				Responses: map[int]any{http.StatusOK: reencryptResponse{}, http.StatusNotFound: errBody, http.StatusConflict: errBody}},
+				Handler: admin(http.HandlerFunc(k.reencryptAll))},
+			openapi.Route{Operation: openapi.Operation{Pattern: "DELETE /keys/{name}/versions/{id}", Summary: "Drop a retired key version", Tags: kTags,
+				Description: "Admin only. What it signed stops verifying at once, and what it encrypted and was not re-encrypted can no longer be read.",
+				Params:      []openapi.Param{name, {Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}}, Auth: true,
+				Responses: map[int]any{http.StatusNoContent: nil, http.StatusNotFound: errBody, http.StatusConflict: errBody}},
+				Handler: admin(http.HandlerFunc(k.drop))},
+		)
+	}
+
+	if cfg.OAuth != nil && cfg.Signer != nil {
+		o := &oauthHandlers{m: cfg.OAuth, signer: cfg.Signer, sessions: cfg.Sessions, history: cfg.Activity}
+		oTags := []string{"sign-in"}
+		provider := openapi.Param{Name: "provider", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}
+		prov := []openapi.Param{provider}
+		idProv := []openapi.Param{openapi.PathInt("id"), provider}
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /auth/oauth", Summary: "The providers users may sign in with", Tags: oTags,
+				Responses: map[int]any{http.StatusOK: []string{}}},
+				Handler: http.HandlerFunc(o.providers)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /auth/oauth/{provider}/login", Summary: "Sign in with a provider", Tags: oTags,
+				Description: "Redirects to the provider, which sends the user agent back to the callback within ten minutes.",
+				Params:      prov, Responses: map[int]any{http.StatusFound: nil, http.StatusNotFound: errBody, http.StatusBadGateway: errBody}},
+				Handler: http.HandlerFunc(o.login)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /auth/oauth/{provider}/callback", Summary: "Finish signing in with a provider", Tags: oTags,
+				Description: "Where the provider sends the user agent back. Answers with a token for the user the provider's identity is linked to, registering one for an identity not linked yet; 409 if a user has its email and has not linked it.",
+				Params: append([]openapi.Param{provider},
+					openapi.Query("code", "the provider's authorization code", &openapi.Schema{Type: "string"}),
+					openapi.Query("state", "the sign-in's state", &openapi.Schema{Type: "string"})),
+				Responses: map[int]any{http.StatusOK: signInResponse{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody,
+					http.StatusConflict: errBody, http.StatusBadGateway: errBody}},
+				Handler: http.HandlerFunc(o.callback)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /users/{id}/identities", Summary: "A user's linked providers", Tags: tags,
+				Description: "The user themselves or an admin.", Params: id, Auth: true,
+				Responses: map[int]any{http.StatusOK: []oauth.Identity{}, http.StatusBadRequest: errBody}},
+				Handler: http.HandlerFunc(o.identities)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /users/{id}/identities/{provider}", Summary: "Link a provider", Tags: tags,
+				Description: "The user themselves. Answers with the provider URL to send the user agent to; the link is made when it comes back to the callback.",
+				Params:      idProv, Auth: true,
+				Responses: map[int]any{http.StatusOK: linkResponse{}, http.StatusNotFound: errBody, http.StatusConflict: errBody, http.StatusBadGateway: errBody}},
+				Handler: http.HandlerFunc(o.link)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "DELETE /users/{id}/identities/{provider}", Summary: "Unlink a provider", Tags: tags,
+				Description: "The user themselves or an admin. The provider's tokens are forgotten, not revoked.", Params: idProv, Auth: true,
+				Responses: map[int]any{http.StatusNoContent: nil, http.StatusNotFound: errBody}},
+				Handler: http.HandlerFunc(o.unlink)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /users/{id}/identities/{provider}/token", Summary: "A current provider access token", Tags: tags,
+				Description: "The user themselves, for calling the provider's APIs. Refreshed first if it has expired; 409 if it has and there is no refresh token.",
+				Params:      idProv, Auth: true,
+				Responses: map[int]any{http.StatusOK: oauth.Token{}, http.StatusNotFound: errBody, http.StatusConflict: errBody, http.StatusBadGateway: errBody}},
+				Handler: http.HandlerFunc(o.token)},
+		)
+	}
+
+	var a *avatarHandlers
+	if cfg.Avatars != nil {
+		a = &avatarHandlers{svc: cfg.Service, avatars: cfg.Avatars}
+		image := openapi.Content{Type: "image/*", Body: []byte(nil)}
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "PUT /users/{id}/avatar", Summary: "Upload a user's avatar", Tags: tags,
+				Description: "The user themselves or an admin. A multipart/form-data body whose avatar field is a JPEG, PNG or GIF; it is cropped to a square and scaled down.",
+				Params:      id, Auth: true,
+				Responses: map[int]any{http.StatusOK: users.Enriched{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody,
+					http.StatusRequestEntityTooLarge: errBody, http.StatusUnsupportedMediaType: errBody}},
+				Handler: limit(a.put)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /users/{id}/avatar", Summary: "Download a user's avatar", Tags: tags,
+				Description: "Cacheable for a year through the user's avatar_url, whose v names the image; revalidate otherwise.",
+				Params:      append(id, openapi.Query("v", "the image version from avatar_url", &openapi.Schema{Type: "string"})),
+				Responses:   map[int]any{http.StatusOK: image, http.StatusNotModified: nil, http.StatusNotFound: errBody}},
+				Handler: http.HandlerFunc(a.get)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "DELETE /users/{id}/avatar", Summary: "Remove a user's avatar", Tags: tags,
+				Description: "The user themselves or an admin.", Params: id, Auth: true,
+				Responses: map[int]any{http.StatusNoContent: nil, http.StatusNotFound: errBody}},
+				Handler: limit(a.delete)},
+		)
+	}
+	if cfg.Uploads != nil {
+		u := &uploadHandlers{h: h, avatars: a, m: cfg.Uploads}
+		upTags := []string{"uploads"}
+		upID := []openapi.Param{{Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}}
+		upStatus := map[int]any{http.StatusOK: upload.Status{}, http.StatusNotFound: errBody}
+		api.Add(
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /uploads", Summary: "Start a resumable upload", Tags: upTags,
+				Description: "An import (admin only) or a user's avatar (the user or an admin), sent in chunks and then completed. Abandoned uploads expire.",
+				Body:        initUploadRequest{}, Auth: true,
+				Responses: map[int]any{http.StatusCreated: upload.Status{}, http.StatusBadRequest: errBody, http.StatusRequestEntityTooLarge: errBody}},
+				Handler: http.HandlerFunc(u.init)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "GET /uploads/{id}", Summary: "How much of an upload has arrived", Tags: upTags,
+				Description: "Resume by sending the next chunk at offset (also in Upload-Offset).", Params: upID, Auth: true,
+				Responses: upStatus},
+				Handler: http.HandlerFunc(u.status)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "PUT /uploads/{id}/chunks/{offset}", Summary: "Send a chunk of an upload", Tags: upTags,
+				Description: "At the upload's offset, with Content-Digest: sha-256=:<base64>:. Resending a received chunk is harmless; a wrong offset answers 409 with the right one in Upload-Offset.",
+				Params:      append(upID, openapi.PathInt("offset")), Auth: true,
+				Body: openapi.Content{Type: "application/octet-stream", Body: []byte(nil)},
+				Responses: map[int]any{http.StatusOK: upload.Status{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody,
+					http.StatusConflict: errBody, http.StatusRequestEntityTooLarge: errBody}},
+				Handler: limit(u.chunk)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "POST /uploads/{id}/complete", Summary: "Finish an upload", Tags: upTags,
+				Description: "Answers as POST /users/import or PUT /users/{id}/avatar would, then removes the upload; 409 while bytes are missing.",
+				Params:      upID, Auth: true,
+				Responses: map[int]any{http.StatusOK: importReport{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody, http.StatusConflict: errBody}},
+				Handler: limit(u.complete)},
+			openapi.Route{Operation: openapi.Operation{Pattern: "DELETE /uploads/{id}", Summary: "Abandon an upload", Tags: upTags,
+				Params: upID, Auth: true,
+				Responses: map[int]any{http.StatusNoContent: nil, http.StatusNotFound: errBody}},
+				Handler: http.HandlerFunc(u.abort)},
+		)
+	}
+	if cfg.Products != nil {
+		api.Add((&catalog.ProductHandlers{Repo: cfg.Products}).Routes("/products")...)
+	}
+	api.ServeDocs("/openapi.json", "/docs")
+
+	if cfg.Admin != nil {
+		mux.Handle("/admin/", http.StripPrefix("/admin", cfg.Admin))
+	}
+	if cfg.Debug {
+		mux.Handle("GET /debug/goroutines", auth.RequireRole(auth.RoleAdmin)(goroutines.Handler()))
+	}
+
+	var root http.Handler = mux
+	root = withDryRun(mux, cfg.DryRun, root)
+	root = withLocale(root)
+	if cfg.Errors != nil {
+		root = withTracker(cfg.Errors, root)
+	}
+	if cfg.AccessLog != nil {
+		root = logIdentity(root)
+	}
+	if cfg.TwoFactor != nil && cfg.Signer != nil {
+		root = requireTwoFactor(cfg.TwoFactor, root)
+	}
+	if cfg.Activity != nil {
+		root = trackActivity(cfg.Activity, root)
+	}
+	if cfg.ServiceAccounts != nil && cfg.Auth != nil {
+		root = enforceScopes(mux, root)
+	}
+	if cfg.Auth != nil {
+		// Whether or not impersonations can be started: a token that is
+		// one is held to its limits either way.
+		root = guardImpersonation(mux, cfg.Impersonation, root)
+	}
+	if cfg.Auth != nil {
+		var guard auth.Guard
+		if cfg.Lockout != nil {
+			guard = cfg.Lockout
+		}
+		verifier := cfg.Auth
+		if cfg.Sessions != nil {
+			verifier = cfg.Sessions.Verifier(verifier)
+		}
+		root = auth.AuthenticateGuarded(verifier, guard)(root)
+	}
+	if cfg.Signatures != nil {
+		root = cfg.Signatures.Middleware(root)
+	}
+	if cfg.Maintenance != nil {
+		// Outside authentication: a write is refused before its token is
+		// looked at.
+		root = cfg.Maintenance.Middleware(maintenanceExempt)(root)
+	}
+	if cfg.Shedder != nil {
+		root = loadshed.Middleware(cfg.Shedder, classify)(root)
+	}
+	if cfg.Requests != nil {
+		root = countRequests(cfg.Requests, root)
+	}
+	if cfg.Profiler != nil {
+		root = observeLatency(cfg.Profiler, root)
+	}
+	if cfg.Compression != nil {
+		root = compression.Middleware(*cfg.Compression)(root)
+		root = compression.Decompress(*cfg.Compression)(root)
+	}
+	if cfg.Bandwidth != nil || cfg.ConnBandwidth != nil {
+		// Outside compression, so the budget is spent on the bytes that
+		// go on the wire.
+		root = throttleDownloads(cfg.Bandwidth, cfg.ConnBandwidth, root)
+	}
+	if cfg.Traces != nil {
+		// Inside withBudget, to read the trail it starts.
+		root = cfg.Traces.Middleware(root)
+	}
+	root = withBudget(cfg.Timeout, root)
+	var sec httpsec.Options
+	if cfg.Security != nil {
+		sec = *cfg.Security
+	}
+	if sec.Limit == nil {
+		sec.Limit = bodyLimit
+	}
+	// Outside authentication, so preflights, which carry no token, are
+	// answered; inside the crash handler and the access log, so its 413s
+	// are logged.
+	root = httpsec.Middleware(sec)(root)
+	if cfg.Crash != nil {
+		root = cfg.Crash.Middleware(root)
+	}
+	if cfg.AccessLog != nil {
+		// Outermost, so crashes' 500s and shed requests' 503s are logged
+		// with the bytes really sent.
+		root = cfg.AccessLog.Middleware(root)
+	}
+	// Outside everything, so every line and report about a request has
+	// its ID.
+	return reqid.Middleware(root)
+}
+
+type handlers struct {
+	svc      *users.UserService
+	modTimes users.ModTimes
+	changes  users.ChangeFeed
+	history  *activity.History
+}
+
+func (h *handlers) list(w http.ResponseWriter, r *http.Request) {
+	list, err := h.listFor(r.Context(), r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var modified time.Time
+	if h.modTimes != nil {
+		modified = h.modTimes.LastModified()
+	}
+	writeCached(w, r, list, modified)
+}
+
+// listFor lists the users as r asks for them, ready to encode: in the
+// ?sort= order, enriched, and with only the ?fields= fields when that is
+// set. The mask is applied here rather than by ListOptions.Fields: the
+// derived fields are computed from the whole record.
+func (h *handlers) listFor(ctx context.Context, r *http.Request) (any, error) {
+	q := r.URL.Query()
+	keys, err := users.ParseSort(q.Get("sort"))
+	if err != nil {
+		return nil, err
+	}
+	mask, err := users.ParseFields(q.Get("fields"))
+	if err != nil {
+		return nil, err
+	}
+	list, err := h.svc.ListUsersBy(ctx, users.ListOptions{SortBy: keys})
+	if err != nil {
+		return nil, err
+	}
+	enriched := h.svc.EnrichAll(ctx, list)
+	if mask != nil {
+		return fieldmask.SelectAll(mask, enriched), nil
+	}
+	return enriched, nil
+}
+
+func (h *handlers) export(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	// Errors after the first byte can't change the status; the client
+	// sees a truncated stream.
+	if _, err := h.svc.ExportUsers(r.Context(), w); err != nil {
+		writeError(w, r, err)
+	}
+}
+
+func (h *handlers) get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	mask, err := users.ParseFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	u, err := h.svc.GetUser(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var modified time.Time
+	if h.modTimes != nil {
+		modified, _ = h.modTimes.ModifiedAt(id)
+	}
+	var body any = h.svc.Enrich(r.Context(), u)
+	if mask != nil {
+		body = fieldmask.Select(mask, body.(users.Enriched))
+	}
+	writeCached(w, r, body, modified)
+}
+
+// patch answers with the patched user and its ETag, for the next
+// If-Match. Only a strong tag can match: If-Match compares strongly.
+func (h *handlers) patch(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if !mayEdit(w, r, id) {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<16))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	p, err := users.ParsePatch(mt, body)
+	if err != nil {
+		writeError(w, r, i18n.Wrap(err, "user.patch_invalid", i18n.Params{"reason": err.Error()}))
+		return
+	}
+	version := r.Header.Get("If-Match")
+	if version == "*" {
+		version = "" // any version: the user only has to exist
+	}
+	u, err := h.svc.PatchUser(r.Context(), id, p, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	e := h.svc.Enrich(r.Context(), u)
+	if body, err := encode(e); err == nil {
+		w.Header().Set("ETag", etagOf(body))
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+type createRequest struct {
+	Name  string `json:"name" schema:"required,maxLength=100"`
+	Email string `json:"email" schema:"required,format=email,maxLength=254"`
+	// Website is a honeypot: forms hide it, so only bots fill it in.
+	Website string `json:"website,omitempty" doc:"Leave out. Registrations that fill it in are treated as automated."`
+}
+
+func (h *handlers) create(w http.ResponseWriter, r *http.Request) {
+	var req createRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		badJSON(w, r, err)
+		return
+	}
+	ctx := abuse.WithClient(r.Context(), clientOf(r, map[string]string{"website": req.Website}))
+	u, err := h.svc.RegisterUser(ctx, req.Name, req.Email)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if h.history != nil && !dryrun.Enabled(ctx) {
+		h.history.Record(r.Context(), u.ID, activity.Registration)
+	}
+	w.Header().Set("Location", "/users/"+strconv.Itoa(u.ID))
+	writeJSON(w, http.StatusCreated, h.svc.Enrich(r.Context(), u))
+}
+
+// clientOf is the abuse.Client behind r: its peer address (there is no
+// trusted proxy to take X-Forwarded-For from) and, signed in, verified.
+func clientOf(r *http.Request, honeypot map[string]string) abuse.Client {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return abuse.Client{IP: ip, Honeypot: honeypot, Verified: ctxutil.UserID(r.Context()) != ""}
+}
+
+// scoreRegistrations marks the requests next serves as coming from a
+// client, so any registration they make is scored.
+func scoreRegistrations(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(abuse.WithClient(r.Context(), clientOf(r, nil))))
+	}
+}
+
+type privacyHandlers struct {
+	gated
+	m *privacy.Manager
+}
+
+func (h *privacyHandlers) archive(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	a, err := h.m.Export(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="user-`+strconv.Itoa(id)+`.json"`)
+	writeJSON(w, http.StatusOK, a)
+}
+
+type eraseResponse struct {
+	Complete bool `json:"complete"`
+	privacy.Report
+}
+
+// erase answers 200 with the report even if a store failed: the erasure
+// did happen elsewhere, and complete=false tells the caller to retry.
+func (h *privacyHandlers) erase(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	mode := privacy.Mode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = privacy.Delete
+	}
+	if mode != privacy.Delete && mode != privacy.Anonymize {
+		writeError(w, r, privacy.ErrBadMode)
+		return
+	}
+	if h.submit(w, r, approval.Action{
+		Kind:    KindErase,
+		Summary: string(mode) + " user " + strconv.Itoa(id),
+		Params:  map[string]string{"id": strconv.Itoa(id), "mode": string(mode)},
+	}, r.URL.Query().Get("reason")) {
+		return
+	}
+	rep, err := h.m.Erase(r.Context(), id, mode)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, eraseResponse{Complete: rep.Complete(), Report: rep})
+}
+
+type dedupeHandlers struct {
+	m *dedupe.Manager
+}
+
+func (h *dedupeHandlers) find(w http.ResponseWriter, r *http.Request) {
+	found, err := h.m.Find(r.Context(), dedupe.FindOptions{})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, found)
+}
+
+type mergeResponse struct {
+	Complete bool `json:"complete"`
+	dedupe.Report
+}
+
+// merge answers 200 with the report even if a store failed, as erase
+// does: nothing was deleted, and complete=false tells the caller to retry.
+func (h *dedupeHandlers) merge(w http.ResponseWriter, r *http.Request) {
+	keepID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	mergeID, err := strconv.Atoi(q.Get("from"))
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	merge := h.m.Merge
+	if dry, _ := strconv.ParseBool(q.Get("dry_run")); dry {
+		merge = h.m.DryRun
+	}
+	rep, err := merge(r.Context(), keepID, mergeID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, mergeResponse{Complete: rep.Complete(), Report: rep})
+}
+
+func countRequests(c *window.Counter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Inc()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// observeLatency feeds p the latency of every request but the Batch ones,
+// whose minutes would set the p99 off for nothing.
+func observeLatency(p *cpuprof.Profiler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if classify(r) == loadshed.Batch {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		p.Observe(time.Since(start))
+	})
+}
+
+func isDownload(r *http.Request) bool {
+	return r.URL.Path == "/users/export" ||
+		strings.HasPrefix(r.URL.Path, "/users/") && strings.HasSuffix(r.URL.Path, "/archive")
+}
+
+// throttleDownloads limits download responses by the global budget and
+// their connection's, keyed by remote address.
+func throttleDownloads(global *bandwidth.Limiter, conns *bandwidth.Pool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isDownload(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		conn, done := conns.Get(r.RemoteAddr)
+		defer done()
+		next.ServeHTTP(&throttledWriter{ResponseWriter: w, w: bandwidth.Writer(r.Context(), w, global, conn)}, r)
+	})
+}
+
+type throttledWriter struct {
+	http.ResponseWriter
+	w io.Writer
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) { return t.w.Write(p) }
+
+func (t *throttledWriter) Unwrap() http.ResponseWriter { return t.ResponseWriter }
+
+func classify(r *http.Request) loadshed.Priority {
+	switch {
+	case r.URL.Path == "/users/export", r.URL.Path == "/users/import",
+		r.URL.Path == "/users" && r.URL.Query().Has("wait"),
+		strings.HasPrefix(r.URL.Path, "/uploads/"):
+		return loadshed.Batch
+	case r.URL.Path == "/maintenance":
+		// Overload is when it is wanted.
+		return loadshed.Critical
+	}
+	return loadshed.HeaderClassifier(r)
+}
+
+// logIdentity runs inside authentication and tells the access log who
+// the caller is.
+func logIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := ctxutil.UserID(r.Context()); u != "" {
+			accesslog.SetUser(r.Context(), u)
+		}
+		accesslog.SetTenant(r.Context(), ctxutil.TenantID(r.Context()))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withBudget starts the request's breadcrumb trail, under a deadline of
+// timeout unless that is 0 or the request is a Batch one.
+func withBudget(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if timeout > 0 && classify(r) != loadshed.Batch {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		ctx, _ = breadcrumb.New(ctx)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func withTracker(t *errortrack.Tracker, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(errortrack.WithTracker(r.Context(), t)))
+	})
+}
+
+func withLocale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := i18n.Default.Negotiate(r.Header.Get("Accept-Language"))
+		next.ServeHTTP(w, r.WithContext(i18n.WithLocale(r.Context(), locale)))
+	})
+}
+
+// statusOf maps domain errors to HTTP status codes.
+func statusOf(err error) int {
+	switch {
+	case errors.As(err, new(*http.MaxBytesError)):
+		// Ahead of ErrInvalidRequest: a body too large to validate is
+		// refused for its size.
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, users.ErrUserNotFound), errors.Is(err, avatar.ErrNotFound), errors.Is(err, upload.ErrNotFound), errors.Is(err, twofactor.ErrNotEnrolled),
+		errors.Is(err, oauth.ErrUnknownProvider), errors.Is(err, oauth.ErrNotLinked), errors.Is(err, experiment.ErrUnknownExperiment):
+		return http.StatusNotFound
+	case errors.Is(err, users.ErrEmailTaken), errors.Is(err, twofactor.ErrEnabled):
+		return http.StatusConflict
+	case errors.Is(err, serviceaccount.ErrServiceAccountNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, serviceaccount.ErrServiceAccountNameTaken):
+		return http.StatusConflict
+	case errors.Is(err, serviceaccount.ErrInvalid), errors.Is(err, serviceaccount.ErrUnknownScope):
+		return http.StatusBadRequest
+	case errors.Is(err, approval.ErrSelf), errors.Is(err, approval.ErrService):
+		return http.StatusForbidden
+	case errors.Is(err, approval.ErrExpired):
+		return http.StatusGone
+	case errors.Is(err, approval.ErrNotFound), errors.Is(err, backup.ErrNoChain):
+		return http.StatusNotFound
+	case errors.Is(err, approval.ErrDecided), errors.Is(err, backup.ErrNotEmpty):
+		return http.StatusConflict
+	case errors.Is(err, approval.ErrReason), errors.Is(err, approval.ErrUnknownKind):
+		return http.StatusBadRequest
+	case errors.Is(err, impersonate.ErrReason), errors.Is(err, impersonate.ErrScope), errors.Is(err, impersonate.ErrTTL):
+		return http.StatusBadRequest
+	case errors.Is(err, impersonate.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, impersonate.ErrNoRevoker):
+		return http.StatusConflict
+	case errors.Is(err, keyset.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, keyset.ErrCurrent), errors.Is(err, keyset.ErrNoStore):
+		return http.StatusConflict
+	case errors.Is(err, oauth.ErrLinkedElsewhere), errors.Is(err, oauth.ErrAlreadyLinked), errors.Is(err, oauth.ErrNoRefresh):
+		return http.StatusConflict
+	case errors.Is(err, oauth.ErrBadState), errors.Is(err, oauth.ErrInvalidIDToken), errors.Is(err, oauth.ErrNoEmail):
+		return http.StatusBadRequest
+	case errors.Is(err, session.ErrInvalid), errors.Is(err, session.ErrExpired), errors.Is(err, session.ErrReused):
+		return http.StatusUnauthorized
+	case errors.Is(err, session.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, oauth.ErrProvider):
+		return http.StatusBadGateway
+	case errors.Is(err, users.ErrVersionConflict):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, abuse.ErrVerificationRequired):
+		return http.StatusForbidden
+	case errors.Is(err, users.ErrInvalidInput), errors.Is(err, privacy.ErrBadMode), errors.Is(err, dedupe.ErrSameUser), errors.Is(err, openapi.ErrInvalidRequest),
+		errors.Is(err, twofactor.ErrBadCode):
+		return http.StatusBadRequest
+	case errors.Is(err, avatar.ErrInvalid), errors.Is(err, upload.ErrChecksum), errors.Is(err, upload.ErrEmpty):
+		return http.StatusBadRequest
+	case errors.Is(err, upload.ErrOffset), errors.Is(err, upload.ErrIncomplete), errors.Is(err, upload.ErrBusy):
+		return http.StatusConflict
+	case errors.Is(err, avatar.ErrTooLarge), errors.Is(err, upload.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, avatar.ErrUnsupported):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, quota.ErrQuotaExceeded), errors.Is(err, lockout.ErrThrottled), errors.Is(err, lockout.ErrLocked):
+		return http.StatusTooManyRequests
+	case errors.Is(err, loadshed.ErrOverloaded):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+	// Problems lists what failed validation against the API description.
+	Problems []openapi.Problem `json:"problems,omitempty"`
+}
+
+// bodyLimit lifts httpsec's cap for the routes that take large bodies
+// and bound them themselves.
+func bodyLimit(r *http.Request) int64 {
+	if strings.HasPrefix(r.URL.Path, "/uploads/") || r.URL.Path == "/users/import" || strings.HasSuffix(r.URL.Path, "/avatar") {
+		return -1
+	}
+	return 0
+}
+
+// badJSON answers a body that did not decode: 413 if it was too large,
+// else 400.
+func badJSON(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.As(err, new(*http.MaxBytesError)) {
+		writeError(w, r, err)
+		return
+	}
+	http.Error(w, "invalid JSON body", http.StatusBadRequest)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	body := errorBody{Error: i18n.Message(r.Context(), err)}
+	var invalid *openapi.RequestError
+	if errors.As(err, &invalid) {
+		body.Problems = invalid.Problems
+	}
+	// Out of time: where it went is for the logs, not the client.
+	if err = breadcrumb.Annotate(r.Context(), err); errors.As(err, new(*breadcrumb.DeadlineError)) {
+		ctxutil.Logger(r.Context()).Warn("httpapi: request ran out of time", "method", r.Method, "path", r.URL.Path, "err", err)
+	}
+	status := statusOf(err)
+	if status >= 500 {
+		// The client's error is the server's to fix.
+		errortrack.From(r.Context()).Report(err)
+	}
+	writeJSON(w, status, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
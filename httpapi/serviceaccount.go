@@ -0,0 +1,272 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Go-Internals/auth"
+	"Go-Internals/lockout"
+	"Go-Internals/serviceaccount"
+)
+
+/*
+-----------------------------------
+SERVICE ACCOUNTS
+-----------------------------------
+*/
+
+type serviceAccountHandlers struct {
+	m     *serviceaccount.Manager
+	guard *lockout.Guard // may be nil
+}
+
+// tokenRequest documents the form POST /auth/token takes.
+type tokenRequest struct {
+	GrantType string `json:"grant_type" schema:"required,enum=client_credentials"`
+	// Scope narrows the token to some of the account's scopes.
+	Scope string `json:"scope,omitempty" doc:"Space-separated; default every scope the account has."`
+	// ClientID and ClientSecret are for clients that cannot send Basic
+	// authentication, which is preferred.
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// oauthError is an error answer of the token endpoint (RFC 6749 §5.2).
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+type createServiceAccountRequest struct {
+	Name        string   `json:"name" schema:"required,minLength=1,maxLength=64"`
+	Description string   `json:"description,omitempty" schema:"maxLength=200"`
+	Scopes      []string `json:"scopes" schema:"required,minItems=1"`
+}
+
+type secretResponse struct {
+	Account serviceaccount.ServiceAccount `json:"account"`
+	// ClientSecret is shown this once.
+	ClientSecret string `json:"client_secret"`
+}
+
+// token is the client credentials grant. Failed authentications count
+// towards the address's lockout, not the account's: its secret is not
+// guessable, and locking it would only let anyone stop the client.
+func (h *serviceAccountHandlers) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, oauthError{Error: "invalid_request", Description: err.Error()})
+		return
+	}
+	if gt := r.PostForm.Get("grant_type"); gt != "client_credentials" {
+		writeJSON(w, http.StatusBadRequest, oauthError{Error: "unsupported_grant_type", Description: "only client_credentials is supported"})
+		return
+	}
+	id, secret, basic := r.BasicAuth()
+	if !basic {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	ip := clientOf(r, nil).IP
+	if h.guard != nil {
+		if err := h.guard.Admit(r.Context(), "", ip); err != nil {
+			var locked *lockout.Error
+			if errors.As(err, &locked) {
+				w.Header().Set("Retry-After", strconv.Itoa(int((locked.Retry+time.Second-1)/time.Second)))
+			}
+			writeError(w, r, err)
+			return
+		}
+	}
+	t, err := h.m.Token(id, secret, r.PostForm.Get("scope"))
+	switch {
+	case errors.Is(err, serviceaccount.ErrInvalidClient):
+		if h.guard != nil {
+			h.guard.Failed(r.Context(), "", ip)
+		}
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="service accounts"`)
+		}
+		writeJSON(w, http.StatusUnauthorized, oauthError{Error: "invalid_client"})
+	case errors.Is(err, serviceaccount.ErrInvalidScope):
+		writeJSON(w, http.StatusBadRequest, oauthError{Error: "invalid_scope", Description: err.Error()})
+	case err != nil:
+		writeError(w, r, err)
+	default:
+		writeJSON(w, http.StatusOK, t)
+	}
+}
+
+func (h *serviceAccountHandlers) list(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.m.List())
+}
+
+func (h *serviceAccountHandlers) create(w http.ResponseWriter, r *http.Request) {
+	var req createServiceAccountRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<14)).Decode(&req); err != nil {
+		badJSON(w, r, err)
+		return
+	}
+	a, secret, err := h.m.Create(r.Context(), req.Name, req.Description, req.Scopes)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Location", "/service-accounts/"+strconv.Itoa(a.ID))
+	writeJSON(w, http.StatusCreated, secretResponse{Account: a, ClientSecret: secret})
+}
+
+func (h *serviceAccountHandlers) get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	a, err := h.m.Get(id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+func (h *serviceAccountHandlers) update(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var p serviceaccount.Patch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<14)).Decode(&p); err != nil {
+		badJSON(w, r, err)
+		return
+	}
+	a, err := h.m.Update(r.Context(), id, p)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+func (h *serviceAccountHandlers) rotate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	a, secret, err := h.m.Rotate(r.Context(), id, r.URL.Query().Get("immediate") == "true")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, secretResponse{Account: a, ClientSecret: secret})
+}
+
+func (h *serviceAccountHandlers) delete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := h.m.Delete(r.Context(), id); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+-----------------------------------
+SCOPES
+-----------------------------------
+*/
+
+// serviceScopes are the routes service accounts and impersonation
+// tokens may use, each with the scope the token needs ("" for none).
+// Any other route is closed to them, so one added later stays closed
+// until it is listed here. A listed route grants no role: its handler
+// still decides, and where it lets users act only on themselves, lets
+// a service account with the route's scope act on anyone (see mayEdit).
+var serviceScopes = map[string]string{
+	"GET /version":      "",
+	"GET /healthz":      "",
+	"GET /openapi.json": "",
+	"GET /docs":         "",
+	"POST /auth/logout": "",
+
+	"GET /users":                auth.ScopeUsersRead,
+	"GET /users/export":         auth.ScopeUsersRead,
+	"GET /users/{id}":           auth.ScopeUsersRead,
+	"GET /users/{id}/avatar":    auth.ScopeUsersRead,
+	"GET /users/{id}/activity":  auth.ScopeUsersRead,
+	"POST /users":               auth.ScopeUsersWrite,
+	"PATCH /users/{id}":         auth.ScopeUsersWrite,
+	"PUT /users/{id}/avatar":    auth.ScopeUsersWrite,
+	"DELETE /users/{id}/avatar": auth.ScopeUsersWrite,
+	// Imports, bulk deletes and updates, merges, archives, erasure,
+	// sessions and identities are admin's or the user's own.
+
+	// A POST to /graphql may be a query, but may as well be a
+	// mutation: read-only clients GET it.
+	"GET /graphql":        auth.ScopeUsersRead,
+	"GET /graphql/schema": auth.ScopeUsersRead,
+	"POST /graphql":       auth.ScopeUsersWrite,
+
+	"GET /uploads/{id}":                 auth.ScopeUsersRead,
+	"POST /uploads":                     auth.ScopeUsersWrite,
+	"PUT /uploads/{id}/chunks/{offset}": auth.ScopeUsersWrite,
+	"POST /uploads/{id}/complete":       auth.ScopeUsersWrite,
+	"DELETE /uploads/{id}":              auth.ScopeUsersWrite,
+
+	"GET /products":         auth.ScopeProductsRead,
+	"GET /products/{id}":    auth.ScopeProductsRead,
+	"POST /products":        auth.ScopeProductsWrite,
+	"PUT /products/{id}":    auth.ScopeProductsWrite,
+	"DELETE /products/{id}": auth.ScopeProductsWrite,
+}
+
+// routeScope is the scope a service account's or impersonation's token
+// needs for r, which mux routes; false if the route is not in
+// serviceScopes.
+func routeScope(mux *http.ServeMux, r *http.Request) (string, bool) {
+	_, pattern := mux.Handler(r)
+	scope, ok := serviceScopes[pattern]
+	return scope, ok
+}
+
+// serviceMay reports whether c is a service account whose scopes cover
+// the route r was routed by.
+func serviceMay(c auth.Claims, r *http.Request) bool {
+	scope, ok := serviceScopes[r.Pattern]
+	return ok && c.HasRole(auth.RoleService) && c.Allows(scope)
+}
+
+// enforceScopes is the permissions layer for service accounts: their
+// tokens pass only the routes in serviceScopes that their scopes cover,
+// mux telling which route a request is for. Users' tokens go by their
+// roles alone. It runs inside auth.Authenticate.
+func enforceScopes(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := auth.PrincipalFrom(r.Context())
+		if !ok || !c.HasRole(auth.RoleService) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		scope, ok := routeScope(mux, r)
+		switch {
+		case !ok:
+			http.Error(w, "service accounts cannot use this route", http.StatusForbidden)
+			return
+		case scope != "" && !c.Allows(scope):
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			http.Error(w, "the token's scopes do not include "+scope, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"Go-Internals/auth"
+	"Go-Internals/serviceaccount"
+	"Go-Internals/users"
+)
+
+// scopeServer is a server with service accounts and one user, ID 1.
+func scopeServer(t *testing.T) (http.Handler, *auth.HS256) {
+	t.Helper()
+	signer := &auth.HS256{Key: []byte("test key")}
+	svc := users.NewUserService(users.NewInMemoryUserRepo())
+	if _, err := svc.RegisterUser(context.Background(), "Ada", "ada@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	return New(Config{
+		Service:         svc,
+		Auth:            signer,
+		Signer:          signer,
+		ServiceAccounts: serviceaccount.New(serviceaccount.Options{Signer: signer}),
+	}), signer
+}
+
+// serviceToken is a service account's token with scope.
+func serviceToken(t *testing.T, signer *auth.HS256, scope string) string {
+	t.Helper()
+	now := time.Now()
+	tok, err := signer.Sign(auth.Claims{Subject: "sa:1", Roles: []string{auth.RoleService}, Scope: scope,
+		IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func serve(h http.Handler, token, method, path, contentType, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestServiceAccountScopes(t *testing.T) {
+	h, signer := scopeServer(t)
+	tests := []struct {
+		name, scope, method, path, contentType, body string
+		want                                         int
+	}{
+		{"read lists users", "users:read", "GET", "/users", "", "", http.StatusOK},
+		{"read cannot register", "users:read", "POST", "/users", "application/json", `{"name":"Bo","email":"bo@example.com"}`, http.StatusForbidden},
+		{"write registers", "users:write", "POST", "/users", "application/json", `{"name":"Bo","email":"bo@example.com"}`, http.StatusCreated},
+		{"write patches anyone", "users:write", "PATCH", "/users/1", users.MergePatchType, `{"name":"Ada L"}`, http.StatusOK},
+		{"products scope is not users", "products:write", "GET", "/users/1", "", "", http.StatusForbidden},
+		{"write cannot import", "users:write", "POST", "/users/import", "application/x-ndjson", `{"name":"Cy","email":"cy@example.com"}`, http.StatusForbidden},
+		{"write cannot bulk delete", "users:write", "POST", "/users/bulk-delete", "application/json", `{"ids":[1]}`, http.StatusForbidden},
+		{"write cannot bulk update", "users:write", "POST", "/users/bulk-update", "application/json", `{"filter":{"ids":[1]},"patch":{"name":"X"}}`, http.StatusForbidden},
+		{"read cannot see stats", "users:read", "GET", "/users/stats", "", "", http.StatusForbidden},
+		{"admin scope is not the admin role", "admin", "POST", "/users/bulk-delete", "application/json", `{"ids":[1]}`, http.StatusForbidden},
+		{"admin scope cannot list accounts", "admin", "GET", "/service-accounts", "", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, serviceToken(t, signer, tt.scope), tt.method, tt.path, tt.contentType, tt.body)
+			if w.Code != tt.want {
+				t.Errorf("%s %s with %q = %d, want %d: %s", tt.method, tt.path, tt.scope, w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
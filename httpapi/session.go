@@ -0,0 +1,108 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"Go-Internals/session"
+)
+
+/*
+-----------------------------------
+SESSIONS
+-----------------------------------
+*/
+
+type sessionHandlers struct {
+	m *session.Manager
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" schema:"required,maxLength=256"`
+}
+
+// refresh is anonymous: the access token it replaces has likely expired,
+// and the refresh token is credential enough.
+func (h *sessionHandlers) refresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "invalid JSON body: refresh_token is required", http.StatusBadRequest)
+		return
+	}
+	p, err := h.m.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, p)
+}
+
+// logout ends the caller's session or, for a token from outside one,
+// revokes the token.
+func (h *sessionHandlers) logout(w http.ResponseWriter, r *http.Request) {
+	c, ok := principal(w, r)
+	if !ok {
+		return
+	}
+	if c.Session != "" {
+		if err := h.m.End(r.Context(), c.Session); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	} else if !h.m.RevokeToken(c) {
+		http.Error(w, "token has no ID to revoke; it lasts until it expires", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *sessionHandlers) list(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if !mayEdit(w, r, id) {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.m.List(strconv.Itoa(id)))
+}
+
+// end ends one of the user's sessions; another user's is not found.
+func (h *sessionHandlers) end(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if !mayEdit(w, r, id) {
+		return
+	}
+	sid := r.PathValue("sid")
+	if s, err := h.m.Get(sid); err != nil || s.Subject != strconv.Itoa(id) {
+		writeError(w, r, session.ErrNotFound)
+		return
+	}
+	if err := h.m.End(r.Context(), sid); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// endAll signs the user out everywhere: every session, and every token
+// issued to them until now.
+func (h *sessionHandlers) endAll(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if !mayEdit(w, r, id) {
+		return
+	}
+	h.m.EndAll(r.Context(), strconv.Itoa(id))
+	w.WriteHeader(http.StatusNoContent)
+}
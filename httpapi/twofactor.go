@@ -0,0 +1,255 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"Go-Internals/auth"
+	"Go-Internals/lockout"
+	"Go-Internals/session"
+	"Go-Internals/twofactor"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+TWO-FACTOR AUTHENTICATION
+-----------------------------------
+*/
+
+type twoFactorHandlers struct {
+	svc    *users.UserService
+	m      *twofactor.Manager
+	signer auth.Signer
+	guard  *lockout.Guard // may be nil
+	// sessions, if set, is told of tokens that passed, so their
+	// session's refreshes keep the factor.
+	sessions *session.Manager
+}
+
+type codeRequest struct {
+	Code string `json:"code" schema:"required,maxLength=32" doc:"A code from the authenticator app or, for verify, a recovery code."`
+}
+
+type recoveryResponse struct {
+	// RecoveryCodes are shown this once; each signs in once without the
+	// app.
+	RecoveryCodes []string `json:"recovery_codes"`
+	// Token is set when the codes come with a signed-in session.
+	Token *tokenResponse `json:"token,omitempty"`
+}
+
+type tokenResponse struct {
+	Token     string           `json:"token"`
+	ExpiresAt time.Time        `json:"expires_at,omitzero"`
+	Method    twofactor.Method `json:"method"`
+	// Left is set when a recovery code was used up.
+	Left *int `json:"recovery_codes_left,omitempty"`
+}
+
+// principal is the caller, false (and answered 401) if anonymous.
+func principal(w http.ResponseWriter, r *http.Request) (auth.Claims, bool) {
+	c, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+	}
+	return c, ok
+}
+
+// requireFactor answers 403 unless c passed the second factor.
+func requireFactor(w http.ResponseWriter, c auth.Claims) bool {
+	if !c.TwoFactor() {
+		stepUp(w)
+		return false
+	}
+	return true
+}
+
+// stepUp asks for a token that passed a second factor, as RFC 9470 does.
+func stepUp(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_user_authentication", error_description="two-factor authentication required"`)
+	http.Error(w, "two-factor authentication required: POST /auth/2fa/verify", http.StatusForbidden)
+}
+
+func readCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req codeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&req); err != nil {
+		badJSON(w, r, err)
+		return "", false
+	}
+	return req.Code, true
+}
+
+func (h *twoFactorHandlers) status(w http.ResponseWriter, r *http.Request) {
+	c, ok := principal(w, r)
+	if !ok {
+		return
+	}
+	st, err := h.m.Status(r.Context(), c.Subject)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// enroll labels the account with the user's email where there is one.
+func (h *twoFactorHandlers) enroll(w http.ResponseWriter, r *http.Request) {
+	c, ok := principal(w, r)
+	if !ok {
+		return
+	}
+	label := c.Subject
+	if id, err := strconv.Atoi(c.Subject); err == nil {
+		if u, err := h.svc.GetUser(r.Context(), id); err == nil {
+			label = u.Email
+		}
+	}
+	e, err := h.m.Enroll(r.Context(), c.Subject, label)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// confirm answers with the recovery codes and, the code having just
+// proved the second factor, a token that says so.
+func (h *twoFactorHandlers) confirm(w http.ResponseWriter, r *http.Request) {
+	c, ok := principal(w, r)
+	if !ok {
+		return
+	}
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+	codes, err := h.m.Confirm(r.Context(), c.Subject, code)
+	if err != nil {
+		h.failed(r, c, err)
+		writeError(w, r, err)
+		return
+	}
+	resp := recoveryResponse{RecoveryCodes: codes}
+	if t, err := h.upgrade(c, twofactor.TOTP); err == nil {
+		resp.Token = &t
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// verify is the sign-in's second step: the token it answers with is the
+// caller's, marked as having passed the second factor, and expires when
+// the caller's does.
+func (h *twoFactorHandlers) verify(w http.ResponseWriter, r *http.Request) {
+	c, ok := principal(w, r)
+	if !ok {
+		return
+	}
+	code, ok := readCode(w, r)
+	if !ok {
+		return
+	}
+	method, err := h.m.Verify(r.Context(), c.Subject, code)
+	if err != nil {
+		h.failed(r, c, err)
+		writeError(w, r, err)
+		return
+	}
+	t, err := h.upgrade(c, method)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if method == twofactor.Recovery {
+		if st, err := h.m.Status(r.Context(), c.Subject); err == nil {
+			t.Left = &st.RecoveryLeft
+		}
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// failed counts a wrong code as a failed authentication: six digits are
+// few enough to guess at, so the lockout that stops forged tokens stops
+// that too.
+func (h *twoFactorHandlers) failed(r *http.Request, c auth.Claims, err error) {
+	if h.guard != nil && errors.Is(err, twofactor.ErrBadCode) {
+		h.guard.Failed(r.Context(), c.Subject, clientOf(r, nil).IP)
+	}
+}
+
+func (h *twoFactorHandlers) upgrade(c auth.Claims, method twofactor.Method) (tokenResponse, error) {
+	for _, m := range []string{auth.AMROTP, auth.AMRMFA} {
+		if !slices.Contains(c.AMR, m) {
+			c.AMR = append(slices.Clone(c.AMR), m)
+		}
+	}
+	token, err := h.signer.Sign(c)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	if h.sessions != nil && c.Session != "" {
+		h.sessions.Elevate(c.Session, c.AMR)
+	}
+	t := tokenResponse{Token: token, Method: method}
+	if c.ExpiresAt != 0 {
+		t.ExpiresAt = time.Unix(c.ExpiresAt, 0).UTC()
+	}
+	return t, nil
+}
+
+func (h *twoFactorHandlers) regenerate(w http.ResponseWriter, r *http.Request) {
+	c, ok := principal(w, r)
+	if !ok || !requireFactor(w, c) {
+		return
+	}
+	codes, err := h.m.RegenerateRecovery(r.Context(), c.Subject)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, recoveryResponse{RecoveryCodes: codes})
+}
+
+func (h *twoFactorHandlers) disable(w http.ResponseWriter, r *http.Request) {
+	c, ok := principal(w, r)
+	if !ok || !requireFactor(w, c) {
+		return
+	}
+	h.disableFor(w, r, c.Subject)
+}
+
+// reset is an admin's way out for a user who lost their device and
+// their recovery codes.
+func (h *twoFactorHandlers) reset(w http.ResponseWriter, r *http.Request) {
+	h.disableFor(w, r, r.PathValue("account"))
+}
+
+func (h *twoFactorHandlers) disableFor(w http.ResponseWriter, r *http.Request, account string) {
+	if err := h.m.Disable(r.Context(), account); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// selfServe are the routes a token that still needs its second factor
+// may use: to see where it stands, set the factor up, and pass it.
+var selfServe = []string{"/auth/2fa", "/auth/2fa/enroll", "/auth/2fa/confirm", "/auth/2fa/verify"}
+
+// requireTwoFactor refuses tokens whose roles m requires a second factor
+// for and which have not passed one, but on the selfServe routes. It
+// runs inside auth.Authenticate.
+func requireTwoFactor(m *twofactor.Manager, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := auth.PrincipalFrom(r.Context()); ok && m.Required(c) && !c.TwoFactor() && !slices.Contains(selfServe, r.URL.Path) {
+			stepUp(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
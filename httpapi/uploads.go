@@ -0,0 +1,222 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"Go-Internals/auth"
+	"Go-Internals/ctxutil"
+	"Go-Internals/i18n"
+	"Go-Internals/upload"
+)
+
+/*
+-----------------------------------
+RESUMABLE UPLOADS
+-----------------------------------
+*/
+
+// What a finished upload is for.
+const (
+	purposeImport = "import"
+	purposeAvatar = "avatar"
+)
+
+type uploadHandlers struct {
+	h       *handlers
+	avatars *avatarHandlers // nil without Config.Avatars
+	m       *upload.Manager
+}
+
+type initUploadRequest struct {
+	Size int64 `json:"size" schema:"required,minimum=1"`
+	// SHA256 is the hex digest of the whole body, checked on completion.
+	SHA256  string `json:"sha256,omitempty" schema:"pattern=^[0-9a-f]{64}$"`
+	Purpose string `json:"purpose" schema:"required,enum=import|avatar"`
+	// UserID is whose avatar an avatar upload is.
+	UserID int `json:"user_id,omitempty"`
+}
+
+// init starts an upload. Whoever may do what the upload is for may start
+// it: an admin an import, a user (or an admin) their own avatar.
+func (u *uploadHandlers) init(w http.ResponseWriter, r *http.Request) {
+	var req initUploadRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		badJSON(w, r, err)
+		return
+	}
+	c, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	spec := upload.Spec{Size: req.Size, SHA256: req.SHA256, Owner: c.Subject, Purpose: req.Purpose}
+	switch req.Purpose {
+	case purposeImport:
+		if !c.HasRole(auth.RoleAdmin) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	case purposeAvatar:
+		if u.avatars == nil {
+			http.Error(w, "avatars are not enabled", http.StatusBadRequest)
+			return
+		}
+		if !mayEdit(w, r, req.UserID) {
+			return
+		}
+		if _, err := u.h.svc.GetUser(r.Context(), req.UserID); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if err := u.avatars.avatars.CheckSize(req.Size); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		spec.Target = strconv.Itoa(req.UserID)
+	}
+	st, err := u.m.Init(spec)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/uploads/"+st.ID)
+	writeUploadStatus(w, http.StatusCreated, st)
+}
+
+// writeUploadStatus also puts the offset in Upload-Offset, where a
+// resuming client looks for it.
+func writeUploadStatus(w http.ResponseWriter, code int, st upload.Status) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(st.Offset, 10))
+	writeJSON(w, code, st)
+}
+
+// owned returns the upload if the caller started it (or is an admin).
+func (u *uploadHandlers) owned(w http.ResponseWriter, r *http.Request) (upload.Status, bool) {
+	st, err := u.m.Status(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, err)
+		return st, false
+	}
+	c, ok := auth.PrincipalFrom(r.Context())
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return st, false
+	}
+	if c.Subject != st.Owner && !c.HasRole(auth.RoleAdmin) {
+		// As if it did not exist: upload IDs are not to be probed.
+		writeError(w, r, i18n.Wrap(upload.ErrNotFound, "upload.not_found", nil))
+		return st, false
+	}
+	return st, true
+}
+
+func (u *uploadHandlers) status(w http.ResponseWriter, r *http.Request) {
+	st, ok := u.owned(w, r)
+	if !ok {
+		return
+	}
+	writeUploadStatus(w, http.StatusOK, st)
+}
+
+// chunk writes the body at the offset in the path. Its SHA-256 comes in
+// Content-Digest (RFC 9530): "sha-256=:<base64>:". A chunk at the wrong
+// offset answers 409 with the right one in Upload-Offset.
+func (u *uploadHandlers) chunk(w http.ResponseWriter, r *http.Request) {
+	st, ok := u.owned(w, r)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(r.PathValue("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid offset", http.StatusBadRequest)
+		return
+	}
+	sum, ok := chunkDigest(r.Header.Get("Content-Digest"))
+	if !ok {
+		http.Error(w, `Content-Digest must give the chunk's sha-256, as sha-256=:<base64>:`, http.StatusBadRequest)
+		return
+	}
+	st, err = u.m.Write(r.Context(), st.ID, offset, r.Body, sum)
+	if errors.Is(err, upload.ErrOffset) {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(st.Offset, 10))
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeUploadStatus(w, http.StatusOK, st)
+}
+
+// chunkDigest returns the hex SHA-256 from a Content-Digest header,
+// which may list other algorithms too.
+func chunkDigest(header string) (string, bool) {
+	for _, item := range strings.Split(header, ",") {
+		alg, val, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || !strings.EqualFold(alg, "sha-256") {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(strings.Trim(val, ":"))
+		if err != nil || len(b) != 32 {
+			return "", false
+		}
+		return hex.EncodeToString(b), true
+	}
+	return "", false
+}
+
+// complete assembles the upload and does what it was for, answering as
+// POST /users/import or PUT /users/{id}/avatar would. The upload is
+// removed afterwards, whatever the outcome: the body was whole and
+// checked, so sending it again would fail the same way. A failure to
+// delete its chunks is the manager's to log, not the client's problem.
+func (u *uploadHandlers) complete(w http.ResponseWriter, r *http.Request) {
+	st, ok := u.owned(w, r)
+	if !ok {
+		return
+	}
+	body, st, err := u.m.Assemble(r.Context(), st.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var result any
+	switch st.Purpose {
+	case purposeImport:
+		result = u.h.importFrom(r.Context(), body)
+	case purposeAvatar:
+		id, _ := strconv.Atoi(st.Target)
+		result, err = u.avatars.set(r.Context(), id, body)
+	}
+	body.Close()
+	// The chunks go even if the client hung up on the result.
+	ctx, cancel := context.WithTimeout(ctxutil.Detach(r.Context()), 30*time.Second)
+	_ = u.m.Remove(ctx, st.ID)
+	cancel()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (u *uploadHandlers) abort(w http.ResponseWriter, r *http.Request) {
+	st, ok := u.owned(w, r)
+	if !ok {
+		return
+	}
+	if err := u.m.Remove(r.Context(), st.ID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
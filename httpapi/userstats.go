@@ -0,0 +1,52 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"Go-Internals/query"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+USER STATS
+-----------------------------------
+*/
+
+type userStatsHandlers struct {
+	svc *users.UserService
+}
+
+// stats answers with the count, first and last registration and
+// registrations per day of the users created in the range asked for,
+// all of them by default. The backend aggregates them if it can; see
+// users.Aggregator.
+func (h *userStatsHandlers) stats(w http.ResponseWriter, r *http.Request) {
+	var f userFilter
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"created_after", &f.CreatedAfter}, {"created_before", &f.CreatedBefore}} {
+		s := r.URL.Query().Get(p.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorBody{Error: p.name + " must be an RFC 3339 time"})
+			return
+		}
+		*p.t = t
+	}
+	spec, ok := f.spec()
+	if !ok {
+		spec = query.All()
+	}
+	agg, err := h.svc.AggregateUsers(r.Context(), spec)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, agg)
+}
@@ -0,0 +1,111 @@
+package httpsec
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+-----------------------------------
+CORS
+-----------------------------------
+*/
+
+// CORS says which browser origins may call the server and how.
+type CORS struct {
+	// Origins allowed: exact (https://app.example.com), a subdomain
+	// wildcard (https://*.example.com) or "*" for any. None by default,
+	// which leaves cross-origin requests to the browser to refuse.
+	Origins []string
+	// Credentials lets allowed origins send cookies. A "*" origin never
+	// does: it is answered as "*", which browsers refuse credentials for.
+	Credentials bool
+	// Methods and Headers are what preflights allow; Expose, the
+	// response headers scripts may read. Each has a default matching
+	// the API.
+	Methods []string
+	Headers []string
+	Expose  []string
+	// MaxAge is how long browsers may cache a preflight; default 10
+	// minutes.
+	MaxAge time.Duration
+}
+
+func (c CORS) withDefaults() CORS {
+	if c.Methods == nil {
+		c.Methods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	if c.Headers == nil {
+		c.Headers = []string{"Authorization", "Content-Type", "Content-Digest", "Content-Encoding", "If-Match", "If-None-Match", "Accept-Language", "X-Request-ID"}
+	}
+	if c.Expose == nil {
+		c.Expose = []string{"ETag", "Location", "Retry-After", "Upload-Offset", "WWW-Authenticate", "X-Request-ID"}
+	}
+	if c.MaxAge <= 0 {
+		c.MaxAge = 10 * time.Minute
+	}
+	return c
+}
+
+// allowed is what Access-Control-Allow-Origin answers origin with, ""
+// if it is not allowed.
+func (c CORS) allowed(origin string) string {
+	for _, o := range c.Origins {
+		switch {
+		case o == "*":
+			return "*"
+		case strings.EqualFold(o, origin):
+			return origin
+		case strings.Contains(o, "://*."):
+			scheme, domain, _ := strings.Cut(o, "://*")
+			rest, ok := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+			if ok && strings.HasSuffix(rest, strings.ToLower(domain)) && len(rest) > len(domain) {
+				return origin
+			}
+		}
+	}
+	return ""
+}
+
+// handle adds the CORS headers for r and answers it if it is a
+// preflight, reporting whether it did.
+func (c CORS) handle(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(c.Origins) == 0 {
+		return false
+	}
+	h := w.Header()
+	// Answers differ by origin, so caches must keep them apart.
+	h.Add("Vary", "Origin")
+	allow := c.allowed(origin)
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if allow == "" {
+		if preflight {
+			// Without the headers the browser refuses the real request.
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return preflight
+	}
+	h.Set("Access-Control-Allow-Origin", allow)
+	if c.Credentials && allow != "*" {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", strings.Join(c.Expose, ", "))
+		return false
+	}
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	if !slices.Contains(c.Methods, r.Header.Get("Access-Control-Request-Method")) {
+		w.WriteHeader(http.StatusNoContent)
+		return true
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(c.Methods, ", "))
+	h.Set("Access-Control-Allow-Headers", strings.Join(c.Headers, ", "))
+	h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
@@ -0,0 +1,104 @@
+// Package httpsec is the HTTP server's baseline defences, on by default:
+// CORS for the browser origins allowed to call it, security headers on
+// every response, a cap on request bodies answered 413, and server
+// timeouts that stop slow clients (slowloris) holding connections open.
+//
+// Middleware applies the first three; Timeouts.Apply the last, to the
+// http.Server, since a handler runs only once the headers are in.
+package httpsec
+
+import (
+	"net/http"
+	"time"
+)
+
+// Options configures Middleware. The zero value is the defaults: no
+// cross-origin callers, every security header, 1 MiB bodies.
+type Options struct {
+	CORS    CORS
+	Headers Headers
+	// MaxBody caps a request body, in bytes; default 1 MiB, -1 for no
+	// cap.
+	MaxBody int64
+	// Limit, if set, is the cap for r instead, for routes that take more
+	// (uploads) or bound their own: 0 falls back to MaxBody, -1 is no
+	// cap.
+	Limit func(r *http.Request) int64
+}
+
+// Middleware applies CORS, the security headers and the body cap.
+func Middleware(opts Options) func(http.Handler) http.Handler {
+	if opts.MaxBody == 0 {
+		opts.MaxBody = 1 << 20
+	}
+	cors := opts.CORS.withDefaults()
+	headers := opts.Headers.withDefaults()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers.set(w.Header())
+			if cors.handle(w, r) {
+				return
+			}
+			limit := opts.MaxBody
+			if opts.Limit != nil {
+				if l := opts.Limit(r); l != 0 {
+					limit = l
+				}
+			}
+			if limit > 0 && r.Body != nil {
+				if r.ContentLength > limit {
+					// Said up front: refuse before reading any of it, and
+					// close, as the body is not going to be read.
+					w.Header().Set("Connection", "close")
+					http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				// Bodies of no declared length are cut off at the cap;
+				// handlers see an *http.MaxBytesError.
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+/*
+-----------------------------------
+SERVER TIMEOUTS
+-----------------------------------
+*/
+
+// Timeouts bound how long a client may take over a request, so a slow
+// one cannot hold a connection, and its goroutine, indefinitely.
+type Timeouts struct {
+	// ReadHeader is how long the request line and headers may take.
+	ReadHeader time.Duration
+	// Read is how long the whole request, body included, may take; 0
+	// leaves bodies to the handlers' own budgets.
+	Read time.Duration
+	// Write is how long writing the response may take; 0 for none, as
+	// exports and change streams run long.
+	Write time.Duration
+	// Idle is how long a kept-alive connection waits for its next
+	// request.
+	Idle time.Duration
+	// MaxHeaderBytes caps the request headers.
+	MaxHeaderBytes int
+}
+
+// DefaultTimeouts are what the server runs with unless told otherwise.
+var DefaultTimeouts = Timeouts{
+	ReadHeader:     5 * time.Second,
+	Read:           time.Minute,
+	Idle:           2 * time.Minute,
+	MaxHeaderBytes: 64 << 10,
+}
+
+// Apply sets t on srv.
+func (t Timeouts) Apply(srv *http.Server) {
+	srv.ReadHeaderTimeout = t.ReadHeader
+	srv.ReadTimeout = t.Read
+	srv.WriteTimeout = t.Write
+	srv.IdleTimeout = t.Idle
+	srv.MaxHeaderBytes = t.MaxHeaderBytes
+}
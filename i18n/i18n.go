@@ -0,0 +1,235 @@
+// Package i18n renders user-facing messages in the caller's language.
+//
+// Messages live in per-locale JSON catalogs embedded in the binary
+// (locales/*.json). A message is identified by a key and may take named
+// parameters written as {name} in the catalog text.
+//
+// Errors are localized without losing their identity: Wrap attaches a
+// message key to an error, errors.Is still sees the original sentinel, and
+// Message(ctx, err) renders it in the locale carried by ctx.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is used when nothing better matches.
+const DefaultLocale = "en"
+
+// Params are named message parameters.
+type Params map[string]any
+
+//go:embed locales/*.json
+var embedded embed.FS
+
+// Default is the bundle built from the embedded catalogs. A process
+// that loads its own replaces it at startup, before anything renders.
+var Default = mustLoad(embedded, "locales", DefaultLocale)
+
+// Locales is the embedded catalogs, <locale>.json each.
+func Locales() fs.FS {
+	sub, _ := fs.Sub(embedded, "locales")
+	return sub
+}
+
+// Bundle is a set of catalogs, one per locale.
+type Bundle struct {
+	catalogs map[string]map[string]string
+	fallback string
+}
+
+// LoadFS reads every <locale>.json in dir.
+func LoadFS(fsys fs.FS, dir, fallback string) (*Bundle, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{catalogs: make(map[string]map[string]string), fallback: fallback}
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".json" {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var cat map[string]string
+		if err := json.Unmarshal(data, &cat); err != nil {
+			return nil, fmt.Errorf("i18n: %s: %w", e.Name(), err)
+		}
+		b.catalogs[normalize(strings.TrimSuffix(e.Name(), ".json"))] = cat
+	}
+	if _, ok := b.catalogs[fallback]; !ok {
+		return nil, fmt.Errorf("i18n: fallback locale %q has no catalog", fallback)
+	}
+	return b, nil
+}
+
+func mustLoad(fsys fs.FS, dir, fallback string) *Bundle {
+	b, err := LoadFS(fsys, dir, fallback)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Locales lists available locales.
+func (b *Bundle) Locales() []string {
+	out := make([]string, 0, len(b.catalogs))
+	for l := range b.catalogs {
+		out = append(out, l)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Translate renders key in locale, falling back to the base language
+// ("pt-br" → "pt"), then the fallback locale, then the key itself.
+func (b *Bundle) Translate(locale, key string, params Params) string {
+	text, ok := b.lookup(normalize(locale), key)
+	if !ok {
+		return key
+	}
+	return interpolate(text, params)
+}
+
+func (b *Bundle) lookup(locale, key string) (string, bool) {
+	for _, l := range []string{locale, base(locale), b.fallback} {
+		if text, ok := b.catalogs[l][key]; ok {
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func interpolate(text string, params Params) string {
+	if len(params) == 0 || !strings.Contains(text, "{") {
+		return text
+	}
+	pairs := make([]string, 0, len(params)*2)
+	for k, v := range params {
+		pairs = append(pairs, "{"+k+"}", fmt.Sprint(v))
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+/*
+-----------------------------------
+LOCALE NEGOTIATION
+-----------------------------------
+*/
+
+// Negotiate picks the best available locale for an Accept-Language header
+// ("hi-IN,hi;q=0.9,en;q=0.8"). Exact matches win over base-language
+// matches at the same quality.
+func (b *Bundle) Negotiate(acceptLanguage string) string {
+	type pref struct {
+		tag string
+		q   float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			prefs = append(prefs, pref{normalize(tag), q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	for _, p := range prefs {
+		if _, ok := b.catalogs[p.tag]; ok {
+			return p.tag
+		}
+		if _, ok := b.catalogs[base(p.tag)]; ok {
+			return base(p.tag)
+		}
+	}
+	return b.fallback
+}
+
+func normalize(tag string) string { return strings.ToLower(strings.ReplaceAll(tag, "_", "-")) }
+
+func base(tag string) string {
+	b, _, _ := strings.Cut(tag, "-")
+	return b
+}
+
+/*
+-----------------------------------
+CONTEXT
+-----------------------------------
+*/
+
+type localeKey struct{}
+
+// WithLocale returns a context carrying the request locale.
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, normalize(locale))
+}
+
+// LocaleFrom returns the locale in ctx, or DefaultLocale.
+func LocaleFrom(ctx context.Context) string {
+	if l, ok := ctx.Value(localeKey{}).(string); ok && l != "" {
+		return l
+	}
+	return DefaultLocale
+}
+
+// T translates key in ctx's locale using the Default bundle.
+func T(ctx context.Context, key string, params Params) string {
+	return Default.Translate(LocaleFrom(ctx), key, params)
+}
+
+/*
+-----------------------------------
+LOCALIZED ERRORS
+-----------------------------------
+*/
+
+// Error attaches a message key to an underlying error.
+type Error struct {
+	Key    string
+	Params Params
+	Err    error
+}
+
+// Error renders in the default locale so logs stay readable.
+func (e *Error) Error() string { return Default.Translate(DefaultLocale, e.Key, e.Params) }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Wrap localizes err under key. A nil err stays nil.
+func Wrap(err error, key string, params Params) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Key: key, Params: params, Err: err}
+}
+
+// Message renders err for the user in ctx's locale. Errors without a
+// message key fall back to err.Error().
+func Message(ctx context.Context, err error) string {
+	var le *Error
+	if errors.As(err, &le) {
+		return T(ctx, le.Key, le.Params)
+	}
+	return err.Error()
+}
@@ -0,0 +1,252 @@
+// Package impersonate lets an admin act as a user, to see what they see
+// or fix what they cannot: Start issues a short-lived token with the
+// user as its subject, a user's role, the scopes asked for and the admin
+// in its act claim (RFC 8693 §4.1). Requests made with it are the
+// user's to the handlers, but marked: ctxutil.Impersonator names the
+// admin, audit entries carry them, and httpapi records each request.
+//
+// The token is never an admin's: routes that need admin refuse it
+// whatever its scopes, so an impersonation cannot start another or
+// change anything an admin's token would be needed for. Each is given a
+// reason, which the audit trail keeps, and ends when its token expires
+// or is ended early with End.
+package impersonate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/auth"
+	"Go-Internals/clock"
+	"Go-Internals/ctxutil"
+)
+
+var (
+	ErrReason   = errors.New("impersonate: a reason is required")
+	ErrScope    = errors.New("impersonate: scope must be some of users:read, users:write, products:read and products:write")
+	ErrTTL      = errors.New("impersonate: longer than impersonations may last")
+	ErrNotFound = errors.New("impersonate: no such impersonation")
+	// ErrNoRevoker is End without Options.Revoker: the token stands
+	// until it expires.
+	ErrNoRevoker = errors.New("impersonate: tokens cannot be revoked here; the impersonation lasts until it expires")
+)
+
+// Scopes an impersonation token may have; admin is not one.
+var allowed = []string{auth.ScopeUsersRead, auth.ScopeUsersWrite, auth.ScopeProductsRead, auth.ScopeProductsWrite}
+
+// Options configures New.
+type Options struct {
+	// Signer signs the tokens; required.
+	Signer auth.Signer
+	// TTL is how long an impersonation lasts unless asked for less;
+	// default 15 minutes. MaxTTL is the most one may be asked for;
+	// default 1 hour.
+	TTL    time.Duration
+	MaxTTL time.Duration
+	// Revoker, if set, lets End revoke a token before it expires
+	// (session.Manager is one).
+	Revoker Revoker
+	// Audit records impersonation.started, .ended and, through Request,
+	// .request. Default audit.Discard.
+	Audit audit.Sink
+	// Clock defaults to clock.Real().
+	Clock clock.Clock
+}
+
+// Revoker revokes one token.
+type Revoker interface {
+	RevokeToken(c auth.Claims) bool
+}
+
+// Impersonation is one admin acting as one user.
+type Impersonation struct {
+	// ID is the token's.
+	ID        string    `json:"id"`
+	Admin     string    `json:"admin"`
+	User      string    `json:"user"`
+	Scope     string    `json:"scope"`
+	Reason    string    `json:"reason"`
+	StartedAt time.Time `json:"started_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Grant is a started impersonation with its token, shown once.
+type Grant struct {
+	Impersonation
+	Token string `json:"token"`
+}
+
+type Manager struct {
+	opts Options
+	clk  clock.Clock
+
+	mu sync.Mutex
+	// active is by ID, with the claims End revokes.
+	active map[string]active
+}
+
+type active struct {
+	Impersonation
+	claims auth.Claims
+}
+
+func New(opts Options) *Manager {
+	if opts.TTL <= 0 {
+		opts.TTL = 15 * time.Minute
+	}
+	if opts.MaxTTL <= 0 {
+		opts.MaxTTL = time.Hour
+	}
+	if opts.Audit == nil {
+		opts.Audit = audit.Discard
+	}
+	return &Manager{opts: opts, clk: clock.OrReal(opts.Clock), active: map[string]active{}}
+}
+
+// Start issues admin a token acting as user, limited to scope
+// (space-separated; default users:read) and lasting ttl (default
+// Options.TTL).
+func (m *Manager) Start(ctx context.Context, admin, user, reason, scope string, ttl time.Duration) (Grant, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return Grant{}, ErrReason
+	}
+	scopes := slices.Compact(slices.Sorted(slices.Values(strings.Fields(scope))))
+	if len(scopes) == 0 {
+		scopes = []string{auth.ScopeUsersRead}
+	}
+	for _, s := range scopes {
+		if !slices.Contains(allowed, s) {
+			return Grant{}, ErrScope
+		}
+	}
+	switch {
+	case ttl <= 0:
+		ttl = m.opts.TTL
+	case ttl > m.opts.MaxTTL:
+		return Grant{}, ErrTTL
+	}
+
+	now := m.clk.Now()
+	c := auth.Claims{
+		Subject:   user,
+		Roles:     []string{auth.RoleUser},
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		ID:        randomID(),
+		Scope:     strings.Join(scopes, " "),
+		Act:       &auth.Actor{Subject: admin},
+	}
+	token, err := m.opts.Signer.Sign(c)
+	if err != nil {
+		return Grant{}, err
+	}
+	imp := Impersonation{
+		ID:        c.ID,
+		Admin:     admin,
+		User:      user,
+		Scope:     c.Scope,
+		Reason:    reason,
+		StartedAt: now.UTC(),
+		ExpiresAt: time.Unix(c.ExpiresAt, 0).UTC(),
+	}
+	m.mu.Lock()
+	m.sweepLocked(now)
+	m.active[imp.ID] = active{Impersonation: imp, claims: c}
+	m.mu.Unlock()
+	m.record(ctx, "impersonation.started", imp, map[string]string{
+		"reason": reason,
+		"scope":  imp.Scope,
+		"until":  imp.ExpiresAt.Format(time.RFC3339),
+	})
+	return Grant{Impersonation: imp, Token: token}, nil
+}
+
+// List is the impersonations not yet over, oldest first.
+func (m *Manager) List() []Impersonation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweepLocked(m.clk.Now())
+	out := make([]Impersonation, 0, len(m.active))
+	for _, a := range m.active {
+		out = append(out, a.Impersonation)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+// End revokes impersonation id's token before it expires.
+func (m *Manager) End(ctx context.Context, id string) error {
+	if m.opts.Revoker == nil {
+		return ErrNoRevoker
+	}
+	m.mu.Lock()
+	m.sweepLocked(m.clk.Now())
+	a, ok := m.active[id]
+	delete(m.active, id)
+	m.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	m.opts.Revoker.RevokeToken(a.claims)
+	m.record(ctx, "impersonation.ended", a.Impersonation, nil)
+	return nil
+}
+
+// Request records a request made with impersonation token c, and what
+// it was answered.
+func (m *Manager) Request(ctx context.Context, c auth.Claims, method, path string, status int) {
+	_ = m.opts.Audit.Record(ctx, audit.Entry{
+		Actor:        c.Subject,
+		Impersonator: c.Impersonator(),
+		Action:       "impersonation.request",
+		Resource:     "user",
+		ResourceID:   c.Subject,
+		Meta: map[string]string{
+			"impersonation": c.ID,
+			"method":        method,
+			"path":          path,
+			"status":        strconv.Itoa(status),
+		},
+	})
+}
+
+func (m *Manager) sweepLocked(now time.Time) {
+	for id, a := range m.active {
+		if !now.Before(a.ExpiresAt) {
+			delete(m.active, id)
+		}
+	}
+}
+
+// record is best effort: what it records has happened either way. The
+// actor is whoever started or ended it, the resource the user.
+func (m *Manager) record(ctx context.Context, action string, imp Impersonation, meta map[string]string) {
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	meta["impersonation"] = imp.ID
+	meta["admin"] = imp.Admin
+	_ = m.opts.Audit.Record(ctx, audit.Entry{
+		Actor:      ctxutil.UserID(ctx),
+		Action:     action,
+		Resource:   "user",
+		ResourceID: imp.User,
+		Meta:       meta,
+	})
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,220 @@
+// Package index provides secondary indexes for in-memory stores.
+//
+// An index is defined by a key extractor (entity → key) and a comparator,
+// and is either unique or non-unique. A Set groups the indexes of one store
+// and maintains them together on insert/update/remove, checking every
+// unique constraint before touching any index so a rejected write leaves
+// all of them unchanged.
+package index
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"strings"
+
+	"Go-Internals/btree"
+)
+
+// ErrUniqueViolation is the default error for a duplicate unique key.
+var ErrUniqueViolation = errors.New("index: unique constraint violated")
+
+// Index is maintained by a Set. ids are the store's primary keys.
+type Index[T any] interface {
+	Name() string
+	Unique() bool
+
+	// Check reports whether v (stored under id) would violate the index.
+	Check(id int, v T) error
+	Insert(id int, v T)
+	Remove(id int, v T)
+}
+
+/*
+-----------------------------------
+ORDERED INDEX
+-----------------------------------
+*/
+
+// Options configures an Ordered index.
+type Options struct {
+	Unique bool
+	// ErrDuplicate is returned by Check on a unique violation, so stores can
+	// surface a domain error (e.g. "email already registered") directly.
+	ErrDuplicate error
+}
+
+// entry pairs the key with the primary ID, which makes entries unique even
+// when keys are not and orders equal keys by ID.
+type entry[K any] struct {
+	key K
+	id  int
+}
+
+// Ordered is a B-tree index supporting exact, range and (for string keys)
+// prefix lookups.
+type Ordered[T any, K any] struct {
+	name string
+	opts Options
+	key  func(T) K
+	cmp  func(a, b K) int
+	tree *btree.BTree[entry[K], struct{}]
+}
+
+// NewOrdered builds an index over key(v) ordered by compare.
+func NewOrdered[T any, K any](name string, key func(T) K, compare func(a, b K) int, opts Options) *Ordered[T, K] {
+	if opts.ErrDuplicate == nil {
+		opts.ErrDuplicate = ErrUniqueViolation
+	}
+	idx := &Ordered[T, K]{name: name, opts: opts, key: key, cmp: compare}
+	idx.tree = btree.New[entry[K], struct{}](32, func(a, b entry[K]) bool {
+		if c := compare(a.key, b.key); c != 0 {
+			return c < 0
+		}
+		return a.id < b.id
+	})
+	return idx
+}
+
+// NewOrderedCmp is NewOrdered for naturally ordered keys.
+func NewOrderedCmp[T any, K cmp.Ordered](name string, key func(T) K, opts Options) *Ordered[T, K] {
+	return NewOrdered(name, key, cmp.Compare[K], opts)
+}
+
+func (x *Ordered[T, K]) Name() string { return x.name }
+func (x *Ordered[T, K]) Unique() bool { return x.opts.Unique }
+func (x *Ordered[T, K]) Len() int     { return x.tree.Len() }
+
+func (x *Ordered[T, K]) Check(id int, v T) error {
+	if !x.opts.Unique {
+		return nil
+	}
+	k := x.key(v)
+	var err error
+	x.tree.AscendGreaterOrEqual(entry[K]{k, math.MinInt}, func(e entry[K], _ struct{}) bool {
+		if x.cmp(e.key, k) != 0 {
+			return false
+		}
+		if e.id != id {
+			err = x.opts.ErrDuplicate
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+func (x *Ordered[T, K]) Insert(id int, v T) { x.tree.Set(entry[K]{x.key(v), id}, struct{}{}) }
+func (x *Ordered[T, K]) Remove(id int, v T) { x.tree.Delete(entry[K]{x.key(v), id}) }
+
+// Lookup returns the IDs stored under exactly k, in ID order.
+func (x *Ordered[T, K]) Lookup(k K) []int {
+	var ids []int
+	x.tree.AscendGreaterOrEqual(entry[K]{k, math.MinInt}, func(e entry[K], _ struct{}) bool {
+		if x.cmp(e.key, k) != 0 {
+			return false
+		}
+		ids = append(ids, e.id)
+		return true
+	})
+	return ids
+}
+
+// Range returns IDs with from <= key < to, in key order.
+func (x *Ordered[T, K]) Range(from, to K) []int {
+	var ids []int
+	x.tree.AscendRange(entry[K]{from, math.MinInt}, entry[K]{to, math.MinInt}, func(e entry[K], _ struct{}) bool {
+		ids = append(ids, e.id)
+		return true
+	})
+	return ids
+}
+
+// Ascend visits IDs in key order until fn returns false.
+func (x *Ordered[T, K]) Ascend(fn func(key K, id int) bool) {
+	x.tree.Ascend(func(e entry[K], _ struct{}) bool { return fn(e.key, e.id) })
+}
+
+// AscendAfter visits entries strictly after (k, id) in key order. It is the
+// resume point for cursor-style iteration.
+func (x *Ordered[T, K]) AscendAfter(k K, id int, fn func(key K, id int) bool) {
+	x.tree.AscendGreaterOrEqual(entry[K]{k, id}, func(e entry[K], _ struct{}) bool {
+		if e.id == id && x.cmp(e.key, k) == 0 {
+			return true
+		}
+		return fn(e.key, e.id)
+	})
+}
+
+// Descend visits IDs in reverse key order until fn returns false.
+func (x *Ordered[T, K]) Descend(fn func(key K, id int) bool) {
+	x.tree.Descend(func(e entry[K], _ struct{}) bool { return fn(e.key, e.id) })
+}
+
+// Prefix returns IDs whose string key starts with prefix, in key order.
+func Prefix[T any](x *Ordered[T, string], prefix string) []int {
+	var ids []int
+	x.tree.AscendGreaterOrEqual(entry[string]{prefix, math.MinInt}, func(e entry[string], _ struct{}) bool {
+		if !strings.HasPrefix(e.key, prefix) {
+			return false
+		}
+		ids = append(ids, e.id)
+		return true
+	})
+	return ids
+}
+
+/*
+-----------------------------------
+INDEX SET
+-----------------------------------
+*/
+
+// Set maintains a group of indexes over the same entity type.
+// Like the indexes themselves it is not locked; the owning store's lock
+// covers it.
+type Set[T any] struct {
+	indexes []Index[T]
+}
+
+func NewSet[T any](indexes ...Index[T]) *Set[T] {
+	return &Set[T]{indexes: indexes}
+}
+
+// Add registers another index. Existing entities are not back-filled.
+func (s *Set[T]) Add(idx Index[T]) { s.indexes = append(s.indexes, idx) }
+
+// Insert checks every constraint, then indexes v under id.
+func (s *Set[T]) Insert(id int, v T) error {
+	for _, idx := range s.indexes {
+		if err := idx.Check(id, v); err != nil {
+			return err
+		}
+	}
+	for _, idx := range s.indexes {
+		idx.Insert(id, v)
+	}
+	return nil
+}
+
+// Update re-indexes id from old to updated. On a constraint violation
+// nothing changes.
+func (s *Set[T]) Update(id int, old, updated T) error {
+	for _, idx := range s.indexes {
+		if err := idx.Check(id, updated); err != nil {
+			return err
+		}
+	}
+	for _, idx := range s.indexes {
+		idx.Remove(id, old)
+		idx.Insert(id, updated)
+	}
+	return nil
+}
+
+// Remove drops id from every index.
+func (s *Set[T]) Remove(id int, v T) {
+	for _, idx := range s.indexes {
+		idx.Remove(id, v)
+	}
+}
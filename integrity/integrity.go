@@ -0,0 +1,94 @@
+// Package integrity is the shared vocabulary for corruption handling in
+// the file-based stores.
+//
+// Every store checksums its records (and, where the format has one, its
+// file-level metadata). On startup a store verifies what it loads; a
+// record that fails its checksum is not fatal. It is copied into a
+// quarantine directory next to the data, dropped from the live set, and
+// listed as a Problem, so the rest of the data loads and an operator can
+// inspect or hand-repair what was lost. Verify-only passes (usersctl
+// fsck) produce the same Report without changing anything.
+package integrity
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// QuarantineDir is created next to the data it protects.
+const QuarantineDir = "quarantine"
+
+// Problem is one corrupt record or file.
+type Problem struct {
+	File        string
+	Offset      int64  // byte offset in File, or -1 for the whole file
+	Detail      string // what failed
+	Quarantined string // where the bytes were moved, if they were
+}
+
+func (p Problem) String() string {
+	var b strings.Builder
+	b.WriteString(p.File)
+	if p.Offset >= 0 {
+		fmt.Fprintf(&b, "@%d", p.Offset)
+	}
+	b.WriteString(": ")
+	b.WriteString(p.Detail)
+	if p.Quarantined != "" {
+		b.WriteString(" (saved to ")
+		b.WriteString(p.Quarantined)
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+// Report is the outcome of a verification pass.
+type Report struct {
+	Files    int
+	Records  int
+	Problems []Problem
+}
+
+// OK reports whether nothing was wrong.
+func (r Report) OK() bool { return len(r.Problems) == 0 }
+
+// Add records a problem.
+func (r *Report) Add(p Problem) { r.Problems = append(r.Problems, p) }
+
+// Merge folds another report into r.
+func (r *Report) Merge(o Report) {
+	r.Files += o.Files
+	r.Records += o.Records
+	r.Problems = append(r.Problems, o.Problems...)
+}
+
+// Save copies data into dir/quarantine under a name derived from file and
+// offset and returns the path written.
+func Save(dir, file string, offset int64, data []byte) (string, error) {
+	qdir := filepath.Join(dir, QuarantineDir)
+	if err := os.MkdirAll(qdir, 0o755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s.%d.%s", filepath.Base(file), offset, time.Now().UTC().Format("20060102T150405"))
+	path := filepath.Join(qdir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Move moves a whole file into dir/quarantine and returns its new path.
+func Move(dir, file string) (string, error) {
+	qdir := filepath.Join(dir, QuarantineDir)
+	if err := os.MkdirAll(qdir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(qdir, filepath.Base(file)+"."+time.Now().UTC().Format("20060102T150405"))
+	if err := os.Rename(file, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
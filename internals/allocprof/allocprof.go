@@ -0,0 +1,206 @@
+package allocprof
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"Go-Internals/users"
+)
+
+// Config is one way of running a workload.
+type Config struct {
+	Name string
+	// Pool takes encode buffers from a sync.Pool.
+	Pool bool
+	// Ballast is the size in bytes of the ballast kept alive; 0 for none.
+	Ballast int
+	// GOGC is the GC percent for the run; 0 leaves it as it is.
+	GOGC int
+}
+
+// DefaultConfigs are the baseline and one change of each kind.
+func DefaultConfigs() []Config {
+	return []Config{
+		{Name: "baseline"},
+		{Name: "sync.Pool", Pool: true},
+		{Name: "ballast 256MiB", Ballast: 256 << 20},
+		{Name: "GOGC=50", GOGC: 50},
+		{Name: "GOGC=400", GOGC: 400},
+		{Name: "pool+GOGC=400", Pool: true, GOGC: 400},
+	}
+}
+
+// Options configures Run.
+type Options struct {
+	// Workloads default to DefaultWorkloads.
+	Workloads []Workload
+	// Configs default to DefaultConfigs; the first is what the others
+	// are compared with.
+	Configs []Config
+	// Ops is how many operations each run does; default 100 000.
+	Ops int
+	// Seed is how many users the repository holds before a run; default
+	// 10 000.
+	Seed int
+	// NewRepo returns an empty repository; default the in-memory one.
+	NewRepo func() (users.UserRepository, error)
+}
+
+// Result is one workload under one configuration.
+type Result struct {
+	Workload string
+	Config   string
+	Ops      int
+
+	AllocsPerOp float64
+	BytesPerOp  float64
+	NsPerOp     float64
+	GCs         uint32
+	Pause       time.Duration // stop-the-world total
+	// HeapGoal is the heap size the GC aimed for at the end of the run.
+	HeapGoal uint64
+}
+
+// Run runs every workload under every configuration.
+func Run(opts Options) ([]Result, error) {
+	if len(opts.Workloads) == 0 {
+		opts.Workloads = DefaultWorkloads()
+	}
+	if len(opts.Configs) == 0 {
+		opts.Configs = DefaultConfigs()
+	}
+	if opts.Ops <= 0 {
+		opts.Ops = 100_000
+	}
+	if opts.Seed <= 0 {
+		opts.Seed = 10_000
+	}
+	if opts.NewRepo == nil {
+		opts.NewRepo = func() (users.UserRepository, error) { return users.NewInMemoryUserRepo(), nil }
+	}
+
+	var out []Result
+	for _, w := range opts.Workloads {
+		for _, c := range opts.Configs {
+			r, err := runOne(opts, w, c)
+			if err != nil {
+				return out, fmt.Errorf("allocprof: %s under %s: %w", w.Name, c.Name, err)
+			}
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func runOne(opts Options, w Workload, c Config) (Result, error) {
+	repo, err := opts.NewRepo()
+	if err != nil {
+		return Result{}, err
+	}
+	env, err := seed(repo, opts.Seed, c.Pool)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var ballast []byte
+	if c.Ballast > 0 {
+		ballast = make([]byte, c.Ballast)
+	}
+	if c.GOGC != 0 {
+		defer debug.SetGCPercent(debug.SetGCPercent(c.GOGC))
+	}
+	runtime.GC()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := range opts.Ops {
+		if err := w.Op(env, i); err != nil {
+			return Result{}, err
+		}
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(ballast)
+
+	ops := float64(opts.Ops)
+	return Result{
+		Workload:    w.Name,
+		Config:      c.Name,
+		Ops:         opts.Ops,
+		AllocsPerOp: float64(after.Mallocs-before.Mallocs) / ops,
+		BytesPerOp:  float64(after.TotalAlloc-before.TotalAlloc) / ops,
+		NsPerOp:     float64(elapsed.Nanoseconds()) / ops,
+		GCs:         after.NumGC - before.NumGC,
+		Pause:       time.Duration(after.PauseTotalNs - before.PauseTotalNs),
+		HeapGoal:    after.NextGC,
+	}, nil
+}
+
+// Report writes one table per workload, each configuration's change from
+// the first given in percent.
+func Report(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	var base Result
+	for i, r := range results {
+		if i == 0 || r.Workload != results[i-1].Workload {
+			if i > 0 {
+				fmt.Fprintln(tw)
+			}
+			base = r
+			fmt.Fprintf(tw, "%s, %d ops\n", r.Workload, r.Ops)
+			fmt.Fprintln(tw, "config\tallocs/op\tB/op\tns/op\tGCs\tpause\theap goal\t")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%dMiB\t\n", r.Config,
+			vs(r.AllocsPerOp, base.AllocsPerOp, "%.1f"), vs(r.BytesPerOp, base.BytesPerOp, "%.0f"),
+			vs(r.NsPerOp, base.NsPerOp, "%.0f"), vs(float64(r.GCs), float64(base.GCs), "%.0f"),
+			r.Pause.Round(time.Microsecond), r.HeapGoal>>20)
+	}
+	return tw.Flush()
+}
+
+// vs renders v, and for any but the baseline its change from base:
+// "12.0 (-25%)".
+func vs(v, base float64, format string) string {
+	s := fmt.Sprintf(format, v)
+	change := fmt.Sprintf("%+.0f%%", (v-base)/base*100)
+	if base == 0 || change == "+0%" || change == "-0%" {
+		return s
+	}
+	return s + " (" + change + ")"
+}
+
+// buffers hands out encode buffers, pooled or not.
+type buffers struct {
+	pool *sync.Pool
+}
+
+func newBuffers(pooled bool) buffers {
+	if !pooled {
+		return buffers{}
+	}
+	return buffers{pool: &sync.Pool{New: func() any { return new(bytes.Buffer) }}}
+}
+
+func (b buffers) get() *bytes.Buffer {
+	if b.pool == nil {
+		return new(bytes.Buffer)
+	}
+	buf := b.pool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func (b buffers) put(buf *bytes.Buffer) {
+	// A buffer grown by one huge list would pin its memory in the pool
+	// for good; let those go.
+	if b.pool != nil && buf.Cap() <= 4<<20 {
+		b.pool.Put(buf)
+	}
+}
@@ -0,0 +1,30 @@
+// demo: repository workloads with and without sync.Pool, a ballast and
+// other GOGC settings, side by side.
+//
+//	go run ./internals/allocprof/demo
+//	go run ./internals/allocprof/demo -ops 500000 -seed 100000
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"Go-Internals/internals/allocprof"
+)
+
+func main() {
+	ops := flag.Int("ops", 100_000, "operations per workload and configuration")
+	seed := flag.Int("seed", 10_000, "users in the repository before each run")
+	flag.Parse()
+
+	results, err := allocprof.Run(allocprof.Options{Ops: *ops, Seed: *seed})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := allocprof.Report(os.Stdout, results); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
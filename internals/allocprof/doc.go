@@ -0,0 +1,30 @@
+// Package allocprof runs repository workloads under different memory
+// configurations and reports what each cost, so a change made for
+// performance comes with numbers instead of a hunch.
+//
+// A workload is what a request does to the store and around it: register
+// a user, fetch one and encode it, list and encode everyone, rename one.
+// Each runs against a freshly seeded repository under each Config:
+//
+//   - Pool: the encode buffers come from a sync.Pool instead of being
+//     allocated per operation. It saves the buffer and its growth, not the
+//     User copies the repository hands out, so allocs/op drops by a little
+//     and bytes/op by more on the workloads that encode a lot.
+//   - Ballast: a large pointer-free allocation kept alive for the run. The
+//     GC sizes the next heap goal from the live heap, so a ballast of B
+//     bytes makes collections rarer. It costs address space, not memory
+//     (the pages are never touched), and since Go 1.19 GOMEMLIMIT with a
+//     high GOGC gets the same effect without the trick; the ballast is
+//     here to show the two side by side.
+//   - GOGC: the heap growth allowed between collections, set with
+//     debug.SetGCPercent for the run. Higher means fewer collections and a
+//     bigger heap.
+//
+// allocs/op and bytes/op come from runtime.MemStats and do not depend on
+// the configuration except through Pool; GCs, pause and ns/op do. Numbers
+// from one run on a laptop are noisy; compare configurations within a run,
+// not runs with each other.
+//
+//	go run ./internals/allocprof/demo
+//	go run ./internals/allocprof/demo -ops 500000 -seed 100000
+package allocprof
@@ -0,0 +1,99 @@
+package allocprof
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"Go-Internals/users"
+)
+
+// Env is what a workload's operations work on: a seeded repository and
+// the configuration's buffers.
+type Env struct {
+	Repo users.UserRepository
+	// IDs are the seeded users'.
+	IDs []int
+
+	bufs buffers
+	sink int // keeps encoded lengths alive, so encoding is not skipped
+}
+
+// Workload is an operation run Ops times, i counting up from 0.
+type Workload struct {
+	Name string
+	Op   func(env *Env, i int) error
+}
+
+func seed(repo users.UserRepository, n int, pooled bool) (*Env, error) {
+	env := &Env{Repo: repo, IDs: make([]int, 0, n), bufs: newBuffers(pooled)}
+	for i := range n {
+		u, err := repo.Create(users.User{Name: "user-" + strconv.Itoa(i), Email: "user-" + strconv.Itoa(i) + "@example.com"})
+		if err != nil {
+			return nil, err
+		}
+		env.IDs = append(env.IDs, u.ID)
+	}
+	return env, nil
+}
+
+// encode encodes v as a response body would be.
+func (env *Env) encode(v any) error {
+	buf := env.bufs.get()
+	defer env.bufs.put(buf)
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	env.sink += buf.Len()
+	return nil
+}
+
+// DefaultWorkloads are the API's main paths.
+func DefaultWorkloads() []Workload {
+	return []Workload{
+		{Name: "get+encode", Op: func(env *Env, i int) error {
+			u, err := env.Repo.GetByID(env.IDs[i%len(env.IDs)])
+			if err != nil {
+				return err
+			}
+			return env.encode(u)
+		}},
+		{Name: "create+delete", Op: func(env *Env, i int) error {
+			u, err := env.Repo.Create(users.User{Name: "new-" + strconv.Itoa(i), Email: "new-" + strconv.Itoa(i) + "@example.com"})
+			if err != nil {
+				return err
+			}
+			if err := env.encode(u); err != nil {
+				return err
+			}
+			return env.Repo.Delete(u.ID)
+		}},
+		{Name: "update", Op: func(env *Env, i int) error {
+			u, err := env.Repo.GetByID(env.IDs[i%len(env.IDs)])
+			if err != nil {
+				return err
+			}
+			u.Name = "renamed-" + strconv.Itoa(i)
+			u, err = env.Repo.Update(u)
+			if err != nil {
+				return err
+			}
+			return env.encode(u)
+		}},
+		// A page of 100, streamed as the export streams everyone: the
+		// copies and the encoding of a list response without the full
+		// List of every user.
+		{Name: "page+encode", Op: func(env *Env, i int) error {
+			page := make([]users.User, 0, 100)
+			for u, err := range env.Repo.Iterate(context.Background(), users.IterateOptions{BatchSize: 100}) {
+				if err != nil {
+					return err
+				}
+				if page = append(page, u); len(page) == cap(page) {
+					break
+				}
+			}
+			return env.encode(page)
+		}},
+	}
+}
@@ -0,0 +1,214 @@
+package arena
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	NameSize  = 48
+	EmailSize = 96
+)
+
+var (
+	ErrStale      = errors.New("arena: handle refers to a freed or reused slot")
+	ErrTooLong    = errors.New("arena: value exceeds fixed field size")
+	ErrBadSlab    = errors.New("arena: handle refers to an unknown slab")
+	ErrDoubleFree = errors.New("arena: slot already free")
+)
+
+// Record is a fixed-size, pointer-free user record. Because it contains no
+// pointers, a []Record is allocated in a noscan span.
+type Record struct {
+	ID        int64
+	CreatedAt int64 // unix nanoseconds
+
+	nameLen  uint8
+	emailLen uint8
+	name     [NameSize]byte
+	email    [EmailSize]byte
+
+	gen  uint32 // bumped on every Free; detects stale handles
+	next int32  // free list link (slot index within the arena), -1 = end
+	live bool
+}
+
+func (r *Record) SetName(s string) error {
+	if len(s) > NameSize {
+		return ErrTooLong
+	}
+	r.nameLen = uint8(copy(r.name[:], s))
+	return nil
+}
+
+func (r *Record) SetEmail(s string) error {
+	if len(s) > EmailSize {
+		return ErrTooLong
+	}
+	r.emailLen = uint8(copy(r.email[:], s))
+	return nil
+}
+
+// NameBytes aliases the slot's storage: no allocation, but only valid until
+// the slot is freed.
+func (r *Record) NameBytes() []byte  { return r.name[:r.nameLen] }
+func (r *Record) EmailBytes() []byte { return r.email[:r.emailLen] }
+
+// Name and Email copy out of the slot (one allocation each).
+func (r *Record) Name() string  { return string(r.name[:r.nameLen]) }
+func (r *Record) Email() string { return string(r.email[:r.emailLen]) }
+
+func (r *Record) Created() time.Time { return time.Unix(0, r.CreatedAt) }
+
+// Handle identifies a slot. It is a value type with no pointers, so storing
+// handles (e.g. in an index map) adds nothing for the GC to trace.
+type Handle struct {
+	slot int32
+	gen  uint32
+}
+
+// Stats describes arena occupancy.
+type Stats struct {
+	Slabs    int
+	SlabSize int
+	Capacity int
+	Live     int
+	Free     int
+	Allocs   int64
+	Frees    int64
+}
+
+// Arena hands out Records from slabs of SlabSize records each.
+type Arena struct {
+	mu       sync.Mutex
+	slabSize int
+	slabs    [][]Record
+	freeHead int32
+	live     int
+	allocs   int64
+	frees    int64
+}
+
+// New creates an arena whose slabs hold slabSize records each.
+func New(slabSize int) *Arena {
+	if slabSize <= 0 {
+		slabSize = 1024
+	}
+	return &Arena{slabSize: slabSize, freeHead: -1}
+}
+
+// Alloc returns a zeroed record and its handle, growing by one slab when the
+// free list is empty.
+func (a *Arena) Alloc() (Handle, *Record) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.freeHead < 0 {
+		a.growLocked()
+	}
+
+	slot := a.freeHead
+	r := a.recordLocked(slot)
+	a.freeHead = r.next
+
+	gen := r.gen
+	*r = Record{gen: gen, next: -1, live: true}
+
+	a.live++
+	a.allocs++
+	return Handle{slot: slot, gen: gen}, r
+}
+
+// Get resolves a handle. The pointer is valid until the handle is freed.
+func (a *Arena) Get(h Handle) (*Record, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if int(h.slot) >= len(a.slabs)*a.slabSize || h.slot < 0 {
+		return nil, ErrBadSlab
+	}
+	r := a.recordLocked(h.slot)
+	if !r.live || r.gen != h.gen {
+		return nil, ErrStale
+	}
+	return r, nil
+}
+
+// Free returns the slot to the free list. Any other copy of h becomes stale.
+func (a *Arena) Free(h Handle) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if int(h.slot) >= len(a.slabs)*a.slabSize || h.slot < 0 {
+		return ErrBadSlab
+	}
+	r := a.recordLocked(h.slot)
+	if !r.live {
+		return ErrDoubleFree
+	}
+	if r.gen != h.gen {
+		return ErrStale
+	}
+
+	r.live = false
+	r.gen++
+	r.next = a.freeHead
+	a.freeHead = h.slot
+
+	a.live--
+	a.frees++
+	return nil
+}
+
+// Each calls fn for every live record in slot order.
+func (a *Arena) Each(fn func(Handle, *Record) bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for si, slab := range a.slabs {
+		for i := range slab {
+			r := &slab[i]
+			if !r.live {
+				continue
+			}
+			h := Handle{slot: int32(si*a.slabSize + i), gen: r.gen}
+			if !fn(h, r) {
+				return
+			}
+		}
+	}
+}
+
+func (a *Arena) Stats() Stats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	capacity := len(a.slabs) * a.slabSize
+	return Stats{
+		Slabs:    len(a.slabs),
+		SlabSize: a.slabSize,
+		Capacity: capacity,
+		Live:     a.live,
+		Free:     capacity - a.live,
+		Allocs:   a.allocs,
+		Frees:    a.frees,
+	}
+}
+
+func (a *Arena) recordLocked(slot int32) *Record {
+	s := int(slot)
+	return &a.slabs[s/a.slabSize][s%a.slabSize]
+}
+
+// growLocked adds a slab and threads all of its slots onto the free list.
+func (a *Arena) growLocked() {
+	base := int32(len(a.slabs) * a.slabSize)
+	slab := make([]Record, a.slabSize)
+
+	for i := len(slab) - 1; i >= 0; i-- {
+		slab[i].next = a.freeHead
+		a.freeHead = base + int32(i)
+	}
+	a.slabs = append(a.slabs, slab)
+}
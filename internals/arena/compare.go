@@ -0,0 +1,98 @@
+package arena
+
+import (
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// HeapUser is the "normal" representation used as the comparison baseline:
+// one heap object per user plus one per string field.
+type HeapUser struct {
+	ID        int
+	Name      string
+	Email     string
+	CreatedAt time.Time
+}
+
+func NewHeapUser(id int, name, email string) *HeapUser {
+	return &HeapUser{ID: id, Name: name, Email: email, CreatedAt: time.Now()}
+}
+
+// GCReport summarizes what a workload cost the garbage collector.
+type GCReport struct {
+	Workload   string
+	Users      int
+	Mallocs    uint64        // heap objects allocated while building
+	HeapInUse  uint64        // bytes live after building
+	NumGC      uint32        // GC cycles triggered while building
+	PauseTotal time.Duration // stop-the-world pauses while building
+	ForcedGC   time.Duration // wall time of one full runtime.GC() with the data live
+	BuildTime  time.Duration
+}
+
+// CompareGC builds n users on the heap and n users in an arena and reports
+// the GC cost of each while the data is still live.
+func CompareGC(n int) []GCReport {
+	return []GCReport{
+		measure("heap []*HeapUser", n, func() any {
+			users := make([]*HeapUser, 0, n)
+			for i := 0; i < n; i++ {
+				id := strconv.Itoa(i)
+				users = append(users, NewHeapUser(i, "user-"+id, "user-"+id+"@example.com"))
+			}
+			return users
+		}),
+		measure("arena []Record + []Handle", n, func() any {
+			a := New(4096)
+			handles := make([]Handle, 0, n)
+			var buf []byte
+			for i := 0; i < n; i++ {
+				h, r := a.Alloc()
+				r.ID = int64(i)
+				r.CreatedAt = time.Now().UnixNano()
+
+				buf = strconv.AppendInt(append(buf[:0], "user-"...), int64(i), 10)
+				r.nameLen = uint8(copy(r.name[:], buf))
+				buf = append(buf, "@example.com"...)
+				r.emailLen = uint8(copy(r.email[:], buf))
+
+				handles = append(handles, h)
+			}
+			return struct {
+				a *Arena
+				h []Handle
+			}{a, handles}
+		}),
+	}
+}
+
+func measure(name string, n int, build func() any) GCReport {
+	runtime.GC()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+	data := build()
+	buildTime := time.Since(start)
+
+	runtime.ReadMemStats(&after)
+
+	gcStart := time.Now()
+	runtime.GC()
+	forced := time.Since(gcStart)
+
+	runtime.KeepAlive(data)
+
+	return GCReport{
+		Workload:   name,
+		Users:      n,
+		Mallocs:    after.Mallocs - before.Mallocs,
+		HeapInUse:  after.HeapInuse,
+		NumGC:      after.NumGC - before.NumGC,
+		PauseTotal: time.Duration(after.PauseTotalNs - before.PauseTotalNs),
+		ForcedGC:   forced,
+		BuildTime:  buildTime,
+	}
+}
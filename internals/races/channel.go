@@ -0,0 +1,73 @@
+package races
+
+import (
+	"fmt"
+	"sync"
+)
+
+/*
+-----------------------------------
+LOG CHANNEL SHUTDOWN
+-----------------------------------
+*/
+
+// RacyClose has each sender close the log channel when it is done, as if
+// each were the last one. The first close is fine; the next sender's send
+// or close panics, and a panic in a goroutine ends the process.
+func RacyClose() error {
+	ch := make(chan string)
+	received := make(chan int)
+	go countLines(ch, received)
+
+	var wg sync.WaitGroup
+	for w := range Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range PerWorker {
+				ch <- fmt.Sprintf("worker %d line %d", w, i)
+			}
+			close(ch)
+		}()
+	}
+	wg.Wait()
+	return checkLines(<-received)
+}
+
+// FixedClose makes closing the channel the owner's job: it waits for
+// every sender, then closes, and the logger drains what is left.
+func FixedClose() error {
+	ch := make(chan string)
+	received := make(chan int)
+	go countLines(ch, received)
+
+	var wg sync.WaitGroup
+	for w := range Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range PerWorker {
+				ch <- fmt.Sprintf("worker %d line %d", w, i)
+			}
+		}()
+	}
+	wg.Wait()
+	close(ch)
+	return checkLines(<-received)
+}
+
+// countLines is the async logger: it reads until the channel is closed.
+func countLines(ch <-chan string, received chan<- int) {
+	n := 0
+	for range ch {
+		n++
+	}
+	received <- n
+}
+
+func checkLines(n int) error {
+	if n != Workers*PerWorker {
+		return fmt.Errorf("logger got %d of %d lines", n, Workers*PerWorker)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package races
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+-----------------------------------
+ID COUNTER
+-----------------------------------
+*/
+
+// unsafeCounter is InMemoryUserRepo.nextID with the mutex deleted.
+type unsafeCounter struct{ next int }
+
+func (c *unsafeCounter) Next() int {
+	id := c.next
+	runtime.Gosched()
+	c.next = id + 1
+	return id
+}
+
+type lockedCounter struct {
+	mu   sync.Mutex
+	next int
+}
+
+func (c *lockedCounter) Next() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.next
+	c.next++
+	return id
+}
+
+type atomicCounter struct{ next atomic.Int64 }
+
+func (c *atomicCounter) Next() int { return int(c.next.Add(1) - 1) }
+
+func RacyCounter() error { return assignIDs((&unsafeCounter{}).Next) }
+
+// FixedCounter checks both fixes: the mutex the repository uses and the
+// atomic that is enough when the counter is all the lock protects.
+func FixedCounter() error {
+	if err := assignIDs((&lockedCounter{}).Next); err != nil {
+		return fmt.Errorf("mutex: %w", err)
+	}
+	if err := assignIDs((&atomicCounter{}).Next); err != nil {
+		return fmt.Errorf("atomic: %w", err)
+	}
+	return nil
+}
+
+// assignIDs draws IDs concurrently; every one must be unique.
+func assignIDs(next func() int) error {
+	ids := make([][]int, Workers)
+	var wg sync.WaitGroup
+	for w := range Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range PerWorker {
+				ids[w] = append(ids[w], next())
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[int]bool, Workers*PerWorker)
+	dups := 0
+	for _, list := range ids {
+		for _, id := range list {
+			if seen[id] {
+				dups++
+			}
+			seen[id] = true
+		}
+	}
+	if dups > 0 {
+		return fmt.Errorf("%d of %d IDs handed out twice", dups, Workers*PerWorker)
+	}
+	return nil
+}
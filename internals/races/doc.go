@@ -0,0 +1,63 @@
+// Package races collects the concurrency bugs the early Basic2.go was one
+// missing line away from, each in a racy and a fixed version:
+//
+//   - counter: the repository's nextID++ without its mutex. Two creates
+//     read the same value and hand out the same ID. Fixed with the mutex
+//     (what InMemoryUserRepo does) or an atomic.
+//   - email index: check-then-insert on a shared map. Unsynchronized, the
+//     runtime may abort with "concurrent map writes"; even without that,
+//     two registrations both see the email as free. A sync.Map does not
+//     fix the second problem, because the check and the insert are still
+//     two steps; LoadOrStore or one lock around both does.
+//   - log channel: the async logger's channel closed by whoever finishes
+//     first. A second close, or a send after the close, panics. Fixed by
+//     making closing the owner's job, after a WaitGroup of the senders,
+//     which is how Basic2 shuts its logger down.
+//
+// The racy versions call runtime.Gosched between the read and the write.
+// That does not create the race, it widens a window that is already
+// there, so the failure shows on a single-CPU machine too. The race
+// detector does not need the help: it reports the access pattern, not
+// its outcome.
+//
+// The racy map and channel versions can kill the process (a fatal
+// runtime error and an unrecovered panic in a goroutine), so the tests
+// run each racy version in a child process and check that it failed, and
+// under -race that the detector reported it; every fixed version must
+// pass, and under -race pass clean:
+//
+//	go test ./internals/races
+//	go test -race ./internals/races
+package races
+
+// Workers and PerWorker size every case: Workers goroutines doing
+// PerWorker operations each.
+const (
+	Workers   = 8
+	PerWorker = 2000
+)
+
+// Case is one pattern. Both versions return an error describing a
+// violated invariant; Racy may instead crash the process.
+type Case struct {
+	Name  string
+	About string
+	Racy  func() error
+	Fixed func() error
+}
+
+var Cases = []Case{
+	{"counter", "nextID++ from concurrent creates", RacyCounter, FixedCounter},
+	{"email-index", "check-then-insert on a shared map", RacyIndex, FixedIndex},
+	{"log-channel", "closing the log channel from the senders", RacyClose, FixedClose},
+}
+
+// Find returns the case named name.
+func Find(name string) (Case, bool) {
+	for _, c := range Cases {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Case{}, false
+}
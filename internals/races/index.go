@@ -0,0 +1,116 @@
+package races
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+/*
+-----------------------------------
+EMAIL INDEX
+-----------------------------------
+*/
+
+// emailIndex maps emails to user IDs; Claim registers email for id
+// unless another ID holds it.
+type emailIndex interface {
+	Claim(email string, id int) bool
+}
+
+type unsafeIndex struct{ m map[string]int }
+
+func (x *unsafeIndex) Claim(email string, id int) bool {
+	if _, taken := x.m[email]; taken {
+		return false
+	}
+	runtime.Gosched()
+	x.m[email] = id
+	return true
+}
+
+// syncMapIndex is the tempting half-fix: no more map corruption, but the
+// check and the store are still separate steps.
+type syncMapIndex struct{ m sync.Map }
+
+func (x *syncMapIndex) Claim(email string, id int) bool {
+	if _, taken := x.m.Load(email); taken {
+		return false
+	}
+	runtime.Gosched()
+	x.m.Store(email, id)
+	return true
+}
+
+type lockedIndex struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+func (x *lockedIndex) Claim(email string, id int) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, taken := x.m[email]; taken {
+		return false
+	}
+	x.m[email] = id
+	return true
+}
+
+type loadOrStoreIndex struct{ m sync.Map }
+
+func (x *loadOrStoreIndex) Claim(email string, id int) bool {
+	_, taken := x.m.LoadOrStore(email, id)
+	return !taken
+}
+
+// RacyIndex runs the unlocked map, then the sync.Map half-fix. Either
+// failing is the point; the first usually fails, the second always can.
+func RacyIndex() error {
+	if err := claimEmails(&unsafeIndex{m: map[string]int{}}); err != nil {
+		return fmt.Errorf("map: %w", err)
+	}
+	if err := claimEmails(&syncMapIndex{}); err != nil {
+		return fmt.Errorf("sync.Map Load+Store: %w", err)
+	}
+	return nil
+}
+
+func FixedIndex() error {
+	if err := claimEmails(&lockedIndex{m: map[string]int{}}); err != nil {
+		return fmt.Errorf("mutex: %w", err)
+	}
+	if err := claimEmails(&loadOrStoreIndex{}); err != nil {
+		return fmt.Errorf("sync.Map LoadOrStore: %w", err)
+	}
+	return nil
+}
+
+// claimEmails has every worker try to claim the same emails; each email
+// must be granted exactly once.
+func claimEmails(x emailIndex) error {
+	const emails = 100
+	granted := make([]int, Workers)
+	var wg sync.WaitGroup
+	for w := range Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range PerWorker {
+				if x.Claim("user"+strconv.Itoa(i%emails)+"@example.com", w*PerWorker+i) {
+					granted[w]++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	total := 0
+	for _, n := range granted {
+		total += n
+	}
+	if total != emails {
+		return fmt.Errorf("%d registrations accepted for %d emails", total, emails)
+	}
+	return nil
+}
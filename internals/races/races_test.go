@@ -0,0 +1,63 @@
+package races
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestFixed(t *testing.T) {
+	for _, c := range Cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if err := c.Fixed(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// racyRuns is how many times a child runs a racy version: one run now
+// and then gets lucky, twenty in a row do not.
+const racyRuns = 20
+
+// childEnv names the case TestRacyChild runs, in the child TestRacy
+// starts.
+const childEnv = "RACES_CHILD_CASE"
+
+func TestRacy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a child process per case")
+	}
+	for _, c := range Cases {
+		t.Run(c.Name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestRacyChild$", "-test.count=1")
+			cmd.Env = append(os.Environ(), childEnv+"="+c.Name)
+			out, err := cmd.CombinedOutput()
+			if err == nil {
+				t.Fatalf("racy version passed %d runs:\n%s", racyRuns, out)
+			}
+			if raceEnabled && !bytes.Contains(out, []byte("WARNING: DATA RACE")) {
+				t.Errorf("the race detector did not report the racy version:\n%s", out)
+			}
+		})
+	}
+}
+
+// TestRacyChild runs a racy version for TestRacy, in a process of its
+// own: it may take that process down.
+func TestRacyChild(t *testing.T) {
+	name := os.Getenv(childEnv)
+	if name == "" {
+		t.Skip("run by TestRacy")
+	}
+	c, ok := Find(name)
+	if !ok {
+		t.Fatalf("no case %q", name)
+	}
+	for range racyRuns {
+		if err := c.Racy(); err != nil {
+			t.Fatalf("invariant broken: %v", err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package reflectutil
+
+import "unsafe"
+
+/*
+-----------------------------------
+ZERO-COPY CONVERSIONS
+-----------------------------------
+*/
+
+// StringToBytes returns s's bytes without copying. The result must not be
+// modified.
+func StringToBytes(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+// BytesToString returns b as a string without copying. b must not be
+// modified while the string is in use.
+func BytesToString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
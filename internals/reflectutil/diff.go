@@ -0,0 +1,70 @@
+package reflectutil
+
+import (
+	"fmt"
+	"reflect"
+)
+
+/*
+-----------------------------------
+STRUCT DIFF
+-----------------------------------
+*/
+
+// DiffTag is the tag key Diff honours: `diff:"-"` leaves a field out.
+const DiffTag = "diff"
+
+// Change is one field that differs.
+type Change struct {
+	Field  Field
+	Before any
+	After  any
+}
+
+// Diff compares before and after, two values (or pointers) of the same
+// struct type, and returns the fields that differ in declaration order.
+// A field type with an Equal method of its own type (time.Time) is
+// compared with it, so equal instants in different locations are equal;
+// everything else is compared with reflect.DeepEqual.
+func Diff(before, after any) ([]Change, error) {
+	b, a := deref(reflect.ValueOf(before)), deref(reflect.ValueOf(after))
+	if b.Kind() != reflect.Struct || a.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+	if b.Type() != a.Type() {
+		return nil, fmt.Errorf("reflectutil: diff of %s and %s", b.Type(), a.Type())
+	}
+	fields, err := Fields(b.Type(), DiffTag)
+	if err != nil {
+		return nil, err
+	}
+	var out []Change
+	for _, f := range fields {
+		if f.Tag == "-" {
+			continue
+		}
+		x, y := b.FieldByIndex(f.Index), a.FieldByIndex(f.Index)
+		if !equal(x, y) {
+			out = append(out, Change{Field: f, Before: x.Interface(), After: y.Interface()})
+		}
+	}
+	return out, nil
+}
+
+// deref follows a pointer; a nil one yields the invalid Value.
+func deref(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Pointer {
+		return v.Elem()
+	}
+	return v
+}
+
+func equal(x, y reflect.Value) bool {
+	if m := x.MethodByName("Equal"); m.IsValid() {
+		mt := m.Type()
+		if mt.NumIn() == 1 && mt.In(0) == x.Type() && mt.NumOut() == 1 && mt.Out(0).Kind() == reflect.Bool {
+			return m.Call([]reflect.Value{y})[0].Bool()
+		}
+	}
+	return reflect.DeepEqual(x.Interface(), y.Interface())
+}
@@ -0,0 +1,34 @@
+// Package reflectutil holds the reflect and unsafe code the rest of the
+// tree shares, so each trick lives, and is explained, in one place.
+//
+//   - Fields and Walk enumerate a struct's exported fields, nested structs
+//     included, together with one struct tag. The tag systems are built on
+//     them: fieldcrypt's `encrypt`, and `redact` (Redact) for values that
+//     must not reach logs or audit trails.
+//   - Diff compares two values of one struct type field by field; audit
+//     turns its result into an entry's before/after metadata.
+//   - StringToBytes and BytesToString convert without copying.
+//
+// Reflection costs: reflect.Type.Field builds a StructField (tag string
+// included) on every call, so Fields parses a type once and caches the
+// result; after that a walk is an index lookup per field. Reading a field
+// through reflect.Value is still slower than a field access and can
+// allocate when the value is boxed into an interface (Value.Interface),
+// which is why Walk hands out reflect.Values rather than `any`.
+//
+// The unsafe conversions rely on the layout Go guarantees since 1.20:
+// unsafe.StringData and unsafe.SliceData give the backing array, and
+// unsafe.String / unsafe.Slice build a header around it. The result
+// aliases the input, so the usual rules are suspended: a string from
+// BytesToString changes if the bytes do, and the bytes from
+// StringToBytes must never be written (string data may live in
+// read-only memory; writing it faults). Use them where the input is
+// known not to be touched for the result's lifetime, e.g. hashing a
+// string or looking up a map with a []byte key.
+//
+// The tests check that the conversions and a cached Fields do not
+// allocate; the benchmarks compare them with the copying conversions and
+// with reflect.Type.Field:
+//
+//	go test -bench . -benchmem ./internals/reflectutil
+package reflectutil
@@ -0,0 +1,41 @@
+package reflectutil
+
+import "reflect"
+
+/*
+-----------------------------------
+REDACTION
+-----------------------------------
+*/
+
+// RedactTag marks fields whose values must not be logged or audited:
+//
+//	Email string `redact:"true"`
+const RedactTag = "redact"
+
+// Redacted replaces the value of a redacted string field.
+const Redacted = "[redacted]"
+
+// Redacts reports whether f carries `redact:"true"`, whichever tag key f
+// was listed with.
+func Redacts(f Field) bool { return f.StructField.Tag.Get(RedactTag) == "true" }
+
+// Redact overwrites every redacted field of *ptr in place: strings become
+// Redacted, other types their zero value. ptr must be a pointer to a
+// struct; redact a copy to keep the original.
+func Redact(ptr any) error {
+	if v := reflect.ValueOf(ptr); v.Kind() != reflect.Pointer {
+		return ErrNotStruct
+	}
+	return Walk(ptr, RedactTag, func(f Field, v reflect.Value) error {
+		if !Redacts(f) {
+			return nil
+		}
+		if v.Kind() == reflect.String {
+			v.SetString(Redacted)
+		} else {
+			v.SetZero()
+		}
+		return nil
+	})
+}
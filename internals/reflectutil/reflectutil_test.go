@@ -0,0 +1,245 @@
+package reflectutil
+
+import (
+	"errors"
+	"hash/fnv"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Account is exported: Fields skips unexported fields, embedded ones
+// included.
+type Account struct {
+	ID        int
+	Email     string `redact:"true" encrypt:"email"`
+	CreatedAt time.Time
+}
+
+// profile shows nesting: Address fields get dotted paths, the embedded
+// Account's fields are promoted.
+type profile struct {
+	Account
+	Name    string
+	Address struct {
+		City    string `redact:"true"`
+		Country string
+	}
+	Notes *string
+	Score int `diff:"-"`
+	hash  int // unexported: not walked
+}
+
+func TestFields(t *testing.T) {
+	fields, err := Fields(reflect.TypeFor[*profile](), "encrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, f := range fields {
+		paths = append(paths, f.Path)
+	}
+	want := []string{"ID", "Email", "CreatedAt", "Name", "Address.City", "Address.Country", "Notes", "Score"}
+	if !slices.Equal(paths, want) {
+		t.Fatalf("Fields paths = %v, want %v", paths, want)
+	}
+	if f := fields[1]; !slices.Equal(f.Index, []int{0, 1}) || f.Tag != "email" || !f.HasTag || !Redacts(f) {
+		t.Fatalf("Email = %+v, want index [0 1], tag email, redacted", f)
+	}
+	// time.Time has no exported fields: a leaf.
+	if f := fields[2]; f.HasTag || f.Type != reflect.TypeFor[time.Time]() {
+		t.Fatalf("CreatedAt = %+v", f)
+	}
+	if f := fields[4]; !slices.Equal(f.Index, []int{2, 0}) || !Redacts(f) {
+		t.Fatalf("Address.City = %+v, want index [2 0], redacted", f)
+	}
+
+	if _, err := Fields(reflect.TypeFor[int](), ""); !errors.Is(err, ErrNotStruct) {
+		t.Fatalf("Fields(int) = %v, want ErrNotStruct", err)
+	}
+}
+
+func TestWalkAndRedact(t *testing.T) {
+	var p profile
+	p.Name, p.Address.City, p.Address.Country = "Ada", "London", "UK"
+	if err := Redact(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Address.City != Redacted || p.Name != "Ada" || p.Address.Country != "UK" {
+		t.Fatalf("Redact = %+v", p)
+	}
+	if err := Redact(p); !errors.Is(err, ErrNotStruct) {
+		t.Fatalf("Redact of a value = %v, want ErrNotStruct", err)
+	}
+
+	errStop := errors.New("stop")
+	var seen []string
+	err := Walk(&p, "", func(f Field, v reflect.Value) error {
+		seen = append(seen, f.Path)
+		if f.Path == "Address.City" {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) || !slices.Equal(seen, []string{"ID", "Email", "CreatedAt", "Name", "Address.City"}) {
+		t.Fatalf("Walk = %v after %v", err, seen)
+	}
+	if err := Walk((*profile)(nil), "", nil); err != nil {
+		t.Fatalf("Walk of a nil pointer = %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	created := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	before := Account{ID: 7, Email: "ada@example.com", CreatedAt: created}
+	after := before
+	after.Email = "ada@analytical.example"
+	after.CreatedAt = created.In(time.FixedZone("CET", 3600)) // same instant: no change
+
+	changes, err := Diff(before, &after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Field.Path != "Email" ||
+		changes[0].Before != "ada@example.com" || changes[0].After != "ada@analytical.example" {
+		t.Fatalf("Diff = %+v", changes)
+	}
+
+	// diff:"-" fields are left out.
+	var p, q profile
+	q.Score = 3
+	if changes, _ := Diff(p, q); len(changes) != 0 {
+		t.Fatalf("Diff of an ignored field = %+v", changes)
+	}
+
+	if _, err := Diff(before, p); err == nil {
+		t.Fatal("Diff of two types succeeded")
+	}
+	if _, err := Diff(1, 2); !errors.Is(err, ErrNotStruct) {
+		t.Fatalf("Diff(1, 2) = %v, want ErrNotStruct", err)
+	}
+}
+
+func TestConversions(t *testing.T) {
+	if got := StringToBytes("hello"); string(got) != "hello" {
+		t.Fatalf("StringToBytes = %q", got)
+	}
+	if got := BytesToString([]byte("world")); got != "world" {
+		t.Fatalf("BytesToString = %q", got)
+	}
+	if StringToBytes("") != nil || BytesToString(nil) != "" {
+		t.Fatal("empty inputs do not give empty results")
+	}
+	// The string aliases the bytes.
+	buf := []byte("abc")
+	alias := BytesToString(buf)
+	buf[0] = 'x'
+	if alias != "xbc" {
+		t.Fatalf("BytesToString copied: %q", alias)
+	}
+}
+
+var (
+	byteSink []byte
+	strSink  string
+	intSink  int
+)
+
+// long is longer than the compiler's 32-byte stack buffer, so the
+// copying conversions allocate.
+var long = strings.Repeat("x", 64)
+
+func TestAllocations(t *testing.T) {
+	buf := []byte(long)
+	checks := []struct {
+		name string
+		want float64
+		fn   func()
+	}{
+		{"StringToBytes", 0, func() { byteSink = StringToBytes(long) }},
+		{"[]byte(s)", 1, func() { byteSink = []byte(long) }},
+		{"BytesToString", 0, func() { strSink = BytesToString(buf) }},
+		{"string(b)", 1, func() { strSink = string(buf) }},
+		{"Fields, cached", 0, func() { Fields(reflect.TypeFor[Account](), RedactTag) }},
+	}
+	Fields(reflect.TypeFor[Account](), RedactTag)
+	for _, c := range checks {
+		if got := testing.AllocsPerRun(100, c.fn); got != c.want {
+			t.Errorf("%s: %v allocations, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// The compiler already avoids the copy where it can prove the bytes do
+// not escape (h.Write([]byte(long)) below); the conversions pay off where
+// it cannot.
+func BenchmarkHashConversion(b *testing.B) {
+	b.ReportAllocs()
+	h := fnv.New64a()
+	for b.Loop() {
+		h.Write([]byte(long))
+	}
+}
+
+func BenchmarkStringToBytes(b *testing.B) {
+	b.Run("copy", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			byteSink = []byte(long)
+		}
+	})
+	b.Run("unsafe", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			byteSink = StringToBytes(long)
+		}
+	})
+}
+
+func BenchmarkBytesToString(b *testing.B) {
+	buf := []byte(long)
+	b.Run("copy", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			strSink = string(buf)
+		}
+	})
+	b.Run("unsafe", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			strSink = BytesToString(buf)
+		}
+	})
+}
+
+func BenchmarkFields(b *testing.B) {
+	u := Account{ID: 7, Email: "ada@example.com"}
+	b.Run("direct", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			intSink += len(u.Email)
+		}
+	})
+	b.Run("Walk", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			Walk(&u, RedactTag, func(f Field, v reflect.Value) error {
+				if v.Kind() == reflect.String {
+					intSink += v.Len()
+				}
+				return nil
+			})
+		}
+	})
+	b.Run("Type.Field", func(b *testing.B) {
+		b.ReportAllocs()
+		t := reflect.TypeFor[Account]()
+		for b.Loop() {
+			for j := range t.NumField() {
+				intSink += len(t.Field(j).Tag.Get(RedactTag))
+			}
+		}
+	})
+}
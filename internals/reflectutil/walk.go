@@ -0,0 +1,117 @@
+package reflectutil
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
+/*
+-----------------------------------
+FIELD WALKER
+-----------------------------------
+*/
+
+var ErrNotStruct = errors.New("reflectutil: want a struct or a pointer to one")
+
+// Field is one exported leaf field of a struct type.
+type Field struct {
+	// Path names the field from the outer struct: "Name", "Address.City".
+	// Fields of embedded structs are promoted, so their path is their own
+	// name, as in Go source.
+	Path string
+	// Index is the reflect.Value.FieldByIndex path.
+	Index []int
+	reflect.StructField
+	// Tag is the value of the tag key Fields was asked for; HasTag tells
+	// an empty value from a missing tag.
+	Tag    string
+	HasTag bool
+}
+
+// Fields lists t's exported fields with their value for the tag key tag
+// (which may be ""). Struct-typed fields are descended into, except those
+// with no exported fields of their own, such as time.Time, which are
+// leaves; pointers are always leaves. t may be a pointer to a struct.
+func Fields(t reflect.Type, tag string) ([]Field, error) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+	key := fieldsKey{t, tag}
+	if v, ok := fieldCache.Load(key); ok {
+		return v.([]Field), nil
+	}
+	fields := collect(t, tag, nil, "", nil)
+	fieldCache.Store(key, fields)
+	return fields, nil
+}
+
+type fieldsKey struct {
+	t   reflect.Type
+	tag string
+}
+
+// fieldCache holds one []Field per type and tag key.
+var fieldCache sync.Map
+
+func collect(t reflect.Type, tag string, index []int, prefix string, out []Field) []Field {
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		idx := append(append([]int(nil), index...), i)
+		if sf.Type.Kind() == reflect.Struct && hasExported(sf.Type) {
+			next := prefix
+			if !sf.Anonymous {
+				next += sf.Name + "."
+			}
+			out = collect(sf.Type, tag, idx, next, out)
+			continue
+		}
+		f := Field{Path: prefix + sf.Name, Index: idx, StructField: sf}
+		if tag != "" {
+			f.Tag, f.HasTag = sf.Tag.Lookup(tag)
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func hasExported(t reflect.Type) bool {
+	for i := range t.NumField() {
+		if t.Field(i).IsExported() {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk calls fn for every field of v that Fields lists, in declaration
+// order, and stops at the first error. If v is a pointer the values are
+// settable. A nil pointer walks nothing.
+func Walk(v any, tag string, fn func(f Field, value reflect.Value) error) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return ErrNotStruct
+	}
+	fields, err := Fields(rv.Type(), tag)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if err := fn(f, rv.FieldByIndex(f.Index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
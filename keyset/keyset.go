@@ -0,0 +1,369 @@
+// Package keyset keeps the versions of a secret key: the current one,
+// which signs or encrypts, and the retired ones, which still verify or
+// decrypt what was made with them until they are dropped. Each version
+// has an ID, written next to what it made (a JWT's kid header, a sealed
+// field's prefix), so the version that opens it is found directly.
+//
+// Rotate makes a new random version current, and Run does it on a
+// schedule. A retired version is dropped Keep after it was retired, by
+// when what it made should have expired or been re-encrypted
+// (users.EncryptedRepo.Reencrypt); Drop drops a compromised one at once.
+// Rotating a key is then an operation: rotate, re-encrypt, drop.
+//
+// Versions persist in a blobstore.Store, one blob per set, sealed with an
+// atrest.Keyring when one is given, so the keys from configuration are
+// the root every rotated key hangs off. Without a store a set holds the
+// one version it was opened with and cannot rotate: a version lost at a
+// restart would take what it encrypted with it. Processes sharing a
+// store pick up each other's rotations when Run reloads it.
+package keyset
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/atrest"
+	"Go-Internals/audit"
+	"Go-Internals/blobstore"
+	"Go-Internals/clock"
+	"Go-Internals/ctxutil"
+)
+
+var (
+	ErrNotFound = errors.New("keyset: no such key version")
+	ErrCurrent  = errors.New("keyset: the current version cannot be dropped; rotate first")
+	ErrNoStore  = errors.New("keyset: rotation needs a store to keep the versions in")
+)
+
+// InitialID is the ID of the version a set starts with.
+const InitialID = "k0"
+
+// checkEvery is how often Run reloads the set and looks for work.
+const checkEvery = time.Minute
+
+// Options configures Open.
+type Options struct {
+	// Name names the set and its blob, such as "jwt"; required.
+	Name string
+	// Store keeps the versions; without one the set cannot rotate.
+	Store blobstore.Store
+	// Seal, if set, encrypts the stored versions.
+	Seal *atrest.Keyring
+	// Initial is the key the set starts with, InitialID, if the store
+	// holds none yet: the one configured before rotation. Default a
+	// random one.
+	Initial []byte
+	// Size is how many bytes a new version has; default 32.
+	Size int
+	// Every is how old the current version gets before Run rotates it;
+	// 0 rotates only on demand.
+	Every time.Duration
+	// Keep is how long Run keeps a retired version; 0 keeps it until
+	// dropped.
+	Keep time.Duration
+	// Audit records key.rotated and key.dropped. Default audit.Discard.
+	Audit audit.Sink
+	// Clock defaults to clock.Real().
+	Clock clock.Clock
+}
+
+// Version is a key version, without its secret.
+type Version struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// RetiredAt is when a newer version replaced it.
+	RetiredAt time.Time `json:"retired_at,omitzero"`
+	Current   bool      `json:"current"`
+}
+
+// stored is a version as the blob holds it.
+type stored struct {
+	ID        string    `json:"id"`
+	Secret    []byte    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+	RetiredAt time.Time `json:"retired_at,omitzero"`
+}
+
+type Set struct {
+	opts Options
+	clk  clock.Clock
+
+	mu sync.RWMutex
+	// versions is newest, the current one, first.
+	versions []stored
+}
+
+// Open loads the set from the store, starting it with Options.Initial
+// if the store has none.
+func Open(ctx context.Context, opts Options) (*Set, error) {
+	if opts.Name == "" {
+		return nil, errors.New("keyset: Name is required")
+	}
+	if opts.Size <= 0 {
+		opts.Size = 32
+	}
+	if opts.Audit == nil {
+		opts.Audit = audit.Discard
+	}
+	s := &Set{opts: opts, clk: clock.OrReal(opts.Clock)}
+	versions, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		secret := opts.Initial
+		if len(secret) == 0 {
+			secret = s.random()
+		}
+		versions = []stored{{ID: InitialID, Secret: slices.Clone(secret), CreatedAt: s.clk.Now().UTC()}}
+		if opts.Store != nil {
+			if err := s.save(ctx, versions); err != nil {
+				return nil, err
+			}
+		}
+	}
+	s.versions = versions
+	return s, nil
+}
+
+// Name is the set's name.
+func (s *Set) Name() string { return s.opts.Name }
+
+// Current is the version new tokens and values are made with.
+func (s *Set) Current() (id string, key []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.versions[0].ID, s.versions[0].Secret
+}
+
+// Lookup is version id's key, false if the set does not hold it.
+func (s *Set) Lookup(id string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, v := range s.versions {
+		if v.ID == id {
+			return v.Secret, true
+		}
+	}
+	return nil, false
+}
+
+// IDs are the versions held, current first.
+func (s *Set) IDs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]string, len(s.versions))
+	for i, v := range s.versions {
+		ids[i] = v.ID
+	}
+	return ids
+}
+
+// Versions are the versions held, current first.
+func (s *Set) Versions() []Version {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Version, len(s.versions))
+	for i, v := range s.versions {
+		out[i] = Version{ID: v.ID, CreatedAt: v.CreatedAt, RetiredAt: v.RetiredAt, Current: i == 0}
+	}
+	return out
+}
+
+/*
+-----------------------------------
+ROTATION
+-----------------------------------
+*/
+
+// Rotate makes a new random version current, retiring the one before.
+func (s *Set) Rotate(ctx context.Context) (Version, error) {
+	if s.opts.Store == nil {
+		return Version{}, ErrNoStore
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refreshLocked(ctx); err != nil {
+		return Version{}, err
+	}
+	now := s.clk.Now().UTC()
+	next := 0
+	for _, v := range s.versions {
+		if n, err := strconv.Atoi(strings.TrimPrefix(v.ID, "k")); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	versions := slices.Clone(s.versions)
+	versions[0].RetiredAt = now
+	versions = slices.Insert(versions, 0, stored{ID: "k" + strconv.Itoa(next), Secret: s.random(), CreatedAt: now})
+	if err := s.save(ctx, versions); err != nil {
+		return Version{}, err
+	}
+	s.versions = versions
+	s.record(ctx, "key.rotated", versions[0].ID)
+	return Version{ID: versions[0].ID, CreatedAt: now, Current: true}, nil
+}
+
+// Drop forgets retired version id: what it signed stops verifying, and
+// what it encrypted and was not re-encrypted cannot be read.
+func (s *Set) Drop(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refreshLocked(ctx); err != nil {
+		return err
+	}
+	i := slices.IndexFunc(s.versions, func(v stored) bool { return v.ID == id })
+	switch {
+	case i < 0:
+		return ErrNotFound
+	case i == 0:
+		return ErrCurrent
+	}
+	versions := slices.Delete(slices.Clone(s.versions), i, i+1)
+	if s.opts.Store != nil {
+		if err := s.save(ctx, versions); err != nil {
+			return err
+		}
+	}
+	s.versions = versions
+	s.record(ctx, "key.dropped", id)
+	return nil
+}
+
+// Run reloads the set, rotates it once the current version is Every old
+// and drops retired versions Keep after they were retired, until ctx is
+// done.
+func (s *Set) Run(ctx context.Context) error {
+	ticker := s.clk.NewTicker(checkEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			if err := s.tick(ctx); err != nil {
+				log.Printf("keyset %s: %v", s.opts.Name, err)
+			}
+		}
+	}
+}
+
+func (s *Set) tick(ctx context.Context) error {
+	if s.opts.Store == nil {
+		return nil
+	}
+	s.mu.Lock()
+	err := s.refreshLocked(ctx)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	now := s.clk.Now()
+	versions := s.Versions()
+	if s.opts.Every > 0 && now.Sub(versions[0].CreatedAt) >= s.opts.Every {
+		if _, err := s.Rotate(ctx); err != nil {
+			return err
+		}
+	}
+	if s.opts.Keep > 0 {
+		for _, v := range versions[1:] {
+			if now.Sub(v.RetiredAt) >= s.opts.Keep {
+				if err := s.Drop(ctx, v.ID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// refreshLocked takes up what other processes sharing the store saved.
+// s.mu must be held.
+func (s *Set) refreshLocked(ctx context.Context) error {
+	if s.opts.Store == nil {
+		return nil
+	}
+	versions, err := s.load(ctx)
+	if err != nil {
+		return err
+	}
+	if len(versions) > 0 {
+		s.versions = versions
+	}
+	return nil
+}
+
+func (s *Set) blob() string { return "keys/" + s.opts.Name }
+
+func (s *Set) load(ctx context.Context) ([]stored, error) {
+	if s.opts.Store == nil {
+		return nil, nil
+	}
+	rc, _, err := s.opts.Store.Get(ctx, s.blob())
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(io.LimitReader(rc, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if atrest.IsSealed(b) {
+		if s.opts.Seal == nil {
+			return nil, fmt.Errorf("keyset %s: the stored keys are sealed and no keyring is configured", s.opts.Name)
+		}
+		if b, err = s.opts.Seal.Open(b, []byte(s.blob())); err != nil {
+			return nil, fmt.Errorf("keyset %s: %w", s.opts.Name, err)
+		}
+	}
+	var versions []stored
+	if err := json.Unmarshal(b, &versions); err != nil {
+		return nil, fmt.Errorf("keyset %s: %w", s.opts.Name, err)
+	}
+	return versions, nil
+}
+
+func (s *Set) save(ctx context.Context, versions []stored) error {
+	b, err := json.Marshal(versions)
+	if err != nil {
+		return err
+	}
+	if s.opts.Seal != nil {
+		if b, err = s.opts.Seal.Seal(b, []byte(s.blob())); err != nil {
+			return err
+		}
+	}
+	_, err = s.opts.Store.Put(ctx, s.blob(), bytes.NewReader(b), "application/octet-stream")
+	return err
+}
+
+func (s *Set) random() []byte {
+	b := make([]byte, s.opts.Size)
+	rand.Read(b)
+	return b
+}
+
+// record is best effort: what it records has happened either way.
+func (s *Set) record(ctx context.Context, action, id string) {
+	_ = s.opts.Audit.Record(ctx, audit.Entry{
+		Actor:      ctxutil.UserID(ctx),
+		Action:     action,
+		Resource:   "keyset",
+		ResourceID: s.opts.Name,
+		Meta:       map[string]string{"version": id},
+	})
+}
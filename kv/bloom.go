@@ -0,0 +1,59 @@
+package kv
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+// bloom is a standard bloom filter with k probes derived from one 64-bit
+// FNV hash by double hashing (Kirsch–Mitzenmacher).
+type bloom struct {
+	k    uint8
+	bits []byte
+}
+
+// newBloom sizes a filter for n keys at false-positive rate p.
+func newBloom(n int, p float64) *bloom {
+	n = max(n, 1)
+	m := int(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	return &bloom{k: uint8(min(max(k, 1), 30)), bits: make([]byte, (m+7)/8)}
+}
+
+func bloomHash(key []byte) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum >> 32)
+}
+
+func (b *bloom) add(key []byte) {
+	h1, h2 := bloomHash(key)
+	m := uint32(len(b.bits) * 8)
+	for i := range uint32(b.k) {
+		bit := (h1 + i*h2) % m
+		b.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func (b *bloom) mayContain(key []byte) bool {
+	h1, h2 := bloomHash(key)
+	m := uint32(len(b.bits) * 8)
+	for i := range uint32(b.k) {
+		bit := (h1 + i*h2) % m
+		if b.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloom) marshal() []byte { return append([]byte{b.k}, b.bits...) }
+
+func unmarshalBloom(data []byte) (*bloom, error) {
+	if len(data) < 2 {
+		return nil, errors.New("kv: short bloom filter")
+	}
+	return &bloom{k: data[0], bits: data[1:]}, nil
+}
@@ -0,0 +1,624 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"Go-Internals/atrest"
+	"Go-Internals/integrity"
+)
+
+var (
+	ErrNotFound = errors.New("kv: key not found")
+	ErrClosed   = errors.New("kv: database is closed")
+	ErrNoKeys   = errors.New("kv: data is encrypted and no keyring is configured")
+)
+
+// Options tunes a DB.
+type Options struct {
+	MemtableSize int  // flush threshold in bytes; default 4 MiB
+	CompactAt    int  // compact when this many tables exist; default 4
+	SyncWrites   bool // fsync the WAL on every write
+	// Verify checks every record of every table (and every WAL segment)
+	// on Open and salvages damaged ones; see Problems. Without it only
+	// table metadata is checked up front and record checksums are
+	// checked as records are read.
+	Verify bool
+	// Keys, if set, encrypts values at rest: each table and each WAL
+	// generation gets its own data key wrapped by the keyring's current
+	// key. Tables written without keys stay readable and are encrypted
+	// when compaction rewrites them, as are tables under a retired key.
+	Keys *atrest.Keyring
+}
+
+// DB is safe for concurrent use.
+type DB struct {
+	dir  string
+	opts Options
+
+	// compactMu is held for a whole compaction, and always taken before
+	// mu. Vacuum merges with only compactMu held; the flush path, which
+	// already holds mu, merely tries it and skips compaction if busy.
+	compactMu sync.Mutex
+
+	mu      sync.RWMutex
+	mem     *memtable
+	wal     *wal
+	tables  []*table // newest first
+	nextSeq int
+	closed  bool
+	stats   Stats
+
+	problems []integrity.Problem // found by Open
+
+	bloomSkips atomic.Int64 // counted under the read lock
+}
+
+// Stats reports engine counters.
+type Stats struct {
+	Tables        int
+	TableBytes    int64
+	MemtableBytes int
+	Flushes       int
+	Compactions   int
+	BloomSkips    int64 // table lookups avoided by a bloom filter
+}
+
+// Open opens (or creates) the database in dir, replaying the WAL.
+func Open(dir string, opts Options) (*DB, error) {
+	if opts.MemtableSize <= 0 {
+		opts.MemtableSize = 4 << 20
+	}
+	if opts.CompactAt <= 1 {
+		opts.CompactAt = 4
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	db := &DB{dir: dir, opts: opts, mem: newMemtable(), nextSeq: 1}
+	if err := db.loadTables(); err != nil {
+		db.closeTables()
+		return nil, err
+	}
+
+	if opts.Verify {
+		if err := db.verifyTables(); err != nil {
+			db.closeTables()
+			return nil, err
+		}
+	}
+
+	w, err := openWAL(filepath.Join(dir, "wal"), opts.SyncWrites, opts.Verify, opts.Keys)
+	if err != nil {
+		db.closeTables()
+		return nil, err
+	}
+	db.wal = w
+	db.problems = append(db.problems, w.log.Problems()...)
+	problems, err := w.replay(db.mem.put)
+	db.problems = append(db.problems, problems...)
+	if err != nil {
+		db.closeTables()
+		w.close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Problems lists the damage Open found and quarantined instead of failing.
+func (db *DB) Problems() []integrity.Problem {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return slices.Clone(db.problems)
+}
+
+func (db *DB) loadTables() error {
+	entries, err := os.ReadDir(db.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(db.dir, name)) // unfinished flush/compaction
+			continue
+		}
+		base, ok := strings.CutSuffix(name, ".sst")
+		if !ok {
+			continue
+		}
+		seq, err := strconv.Atoi(base)
+		if err != nil {
+			continue
+		}
+		db.nextSeq = max(db.nextSeq, seq+1)
+		path := filepath.Join(db.dir, name)
+		t, err := openTable(path, seq, db.opts.Keys)
+		if err == nil && t.encrypted && t.dk == nil {
+			t.close()
+			return fmt.Errorf("%w: %s", ErrNoKeys, path)
+		}
+		if errors.Is(err, ErrCorrupt) {
+			// Unreadable metadata: nothing in the table can be trusted.
+			p := integrity.Problem{File: path, Offset: -1, Detail: "table metadata fails its checksum"}
+			if p.Quarantined, err = integrity.Move(db.dir, path); err != nil {
+				return err
+			}
+			db.problems = append(db.problems, p)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		db.tables = append(db.tables, t)
+	}
+	slices.SortFunc(db.tables, func(a, b *table) int { return b.seq - a.seq })
+	return nil
+}
+
+// Get returns the value for key, or ErrNotFound.
+func (db *DB) Get(key string) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	if e, ok := db.mem.get(key); ok {
+		return found(e)
+	}
+	bkey := []byte(key)
+	for _, t := range db.tables {
+		if !t.filter.mayContain(bkey) {
+			db.bloomSkips.Add(1)
+			continue
+		}
+		e, ok, err := t.get(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return found(e)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func found(e entry) ([]byte, error) {
+	if e.deleted {
+		return nil, ErrNotFound
+	}
+	return slices.Clone(e.value), nil
+}
+
+// Put sets key to value.
+func (db *DB) Put(key string, value []byte) error {
+	return db.write(key, entry{value: slices.Clone(value)})
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (db *DB) Delete(key string) error {
+	return db.write(key, entry{deleted: true})
+}
+
+// Batch applies several writes under one lock acquisition, so readers
+// never see half of them. The batch is a single WAL record, so a crash
+// keeps all of it or none.
+func (db *DB) Batch(fn func(b *Batch)) error {
+	var b Batch
+	fn(&b)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	if len(b.records) == 0 {
+		return nil
+	}
+	if err := db.wal.append(b.records...); err != nil {
+		return err
+	}
+	for _, r := range b.records {
+		db.mem.put(r.key, r.entry)
+	}
+	return db.maybeFlushLocked()
+}
+
+// Batch collects writes for DB.Batch.
+type Batch struct{ records []record }
+
+func (b *Batch) Put(key string, value []byte) {
+	b.records = append(b.records, record{key, entry{value: slices.Clone(value)}})
+}
+
+func (b *Batch) Delete(key string) {
+	b.records = append(b.records, record{key, entry{deleted: true}})
+}
+
+func (db *DB) write(key string, e entry) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.wal.append(record{key, e}); err != nil {
+		return err
+	}
+	db.mem.put(key, e)
+	return db.maybeFlushLocked()
+}
+
+func (db *DB) maybeFlushLocked() error {
+	if db.mem.size < db.opts.MemtableSize {
+		return nil
+	}
+	return db.flushLocked()
+}
+
+// Flush writes the memtable out as a table now.
+func (db *DB) Flush() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	return db.flushLocked()
+}
+
+func (db *DB) flushLocked() error {
+	if db.mem.tree.Len() == 0 {
+		return nil
+	}
+	seq := db.nextSeq
+	path := filepath.Join(db.dir, tableName(seq))
+	dk, err := db.dataKey()
+	if err != nil {
+		return err
+	}
+	err = writeTable(path, db.mem.tree.Len(), dk, func(yield func(record) bool) {
+		db.mem.rangeFrom("", "", func(k string, e entry) bool { return yield(record{k, e}) })
+	})
+	if err != nil {
+		return err
+	}
+	t, err := openTable(path, seq, db.opts.Keys)
+	if err != nil {
+		return err
+	}
+	db.nextSeq++
+	db.tables = slices.Insert(db.tables, 0, t)
+	db.mem = newMemtable()
+	db.stats.Flushes++
+	// The table is durable, so the WAL can go. A crash before this reset
+	// just replays records the table already has.
+	if err := db.wal.reset(); err != nil {
+		return err
+	}
+
+	if len(db.tables) >= db.opts.CompactAt && db.compactMu.TryLock() {
+		defer db.compactMu.Unlock()
+		return db.compactLocked()
+	}
+	return nil
+}
+
+// Compact merges every table into one, dropping superseded versions and
+// tombstones.
+func (db *DB) Compact() error {
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	return db.compactLocked()
+}
+
+func (db *DB) compactLocked() error {
+	if !db.worthMerging(db.tables) {
+		return nil
+	}
+	seq := db.nextSeq
+	t, err := db.mergeTables(context.Background(), db.tables, seq, nil)
+	if err != nil {
+		return err
+	}
+	db.nextSeq++
+	return db.replaceLocked(db.tables, t)
+}
+
+// Vacuum is Compact for a background job: the merge runs without the
+// write lock, so reads and writes carry on (new flushes simply stack on
+// top of the merged table), and pace, if set, is called before every
+// 64 KiB written so the merge can be throttled. It reports table bytes
+// before and after. Close waits for a running Vacuum; cancel ctx first to
+// make that quick.
+func (db *DB) Vacuum(ctx context.Context, pace func(ctx context.Context, n int) error) (before, after int64, err error) {
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return 0, 0, ErrClosed
+	}
+	inputs := slices.Clone(db.tables)
+	seq := db.nextSeq
+	db.nextSeq++
+	db.mu.Unlock()
+
+	for _, t := range inputs {
+		before += t.size
+	}
+	if !db.worthMerging(inputs) {
+		return before, before, nil
+	}
+	t, err := db.mergeTables(ctx, inputs, seq, pace)
+	if err != nil {
+		return before, before, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.replaceLocked(inputs, t); err != nil {
+		return before, before, err
+	}
+	return before, t.size, nil
+}
+
+// mergeTables writes the merge of inputs (newest first) as table seq.
+// Inputs must be every table older than any table not among them, or
+// dropping tombstones would resurrect what they deleted.
+func (db *DB) mergeTables(ctx context.Context, inputs []*table, seq int, pace func(context.Context, int) error) (*table, error) {
+	var readErr, paceErr error
+	seqs := make([]iter.Seq[record], len(inputs))
+	var n uint64
+	for i, t := range inputs {
+		seqs[i] = t.scan("", "", &readErr)
+		n += t.count
+	}
+
+	dk, err := db.dataKey()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(db.dir, tableName(seq))
+	err = writeTable(path, int(n), dk, func(yield func(record) bool) {
+		pending := 0
+		for r := range mergeNewest(seqs) {
+			// Nothing older than the inputs can be hiding under a
+			// tombstone, so it is safe to drop.
+			if r.deleted {
+				continue
+			}
+			if pending += len(r.key) + len(r.value); pace != nil && pending >= 64<<10 {
+				if paceErr = pace(ctx, pending); paceErr != nil {
+					return
+				}
+				pending = 0
+			}
+			if !yield(r) {
+				return
+			}
+		}
+	})
+	if err := errors.Join(err, readErr, paceErr); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return openTable(path, seq, db.opts.Keys)
+}
+
+// worthMerging: several tables, or one that is not encrypted under the
+// keyring's current key (so a rewrite rotates it).
+func (db *DB) worthMerging(tables []*table) bool {
+	if len(tables) != 1 {
+		return len(tables) > 1
+	}
+	if k := db.opts.Keys; k != nil {
+		t := tables[0]
+		return t.dk == nil || t.dk.KeyID() != k.Current()
+	}
+	return false
+}
+
+// dataKey returns a fresh key for a new table, or nil without a keyring.
+func (db *DB) dataKey() (*atrest.DataKey, error) {
+	if db.opts.Keys == nil {
+		return nil, nil
+	}
+	return db.opts.Keys.NewDataKey()
+}
+
+// replaceLocked swaps inputs for merged, keeping any tables flushed since
+// the merge started (they are newer, so they stay in front).
+func (db *DB) replaceLocked(inputs []*table, merged *table) error {
+	if db.closed {
+		merged.close()
+		os.Remove(merged.path)
+		return ErrClosed
+	}
+	var tables []*table
+	for _, t := range db.tables {
+		if !slices.Contains(inputs, t) {
+			tables = append(tables, t)
+		}
+	}
+	db.tables = append(tables, merged)
+	db.stats.Compactions++
+	for _, o := range inputs {
+		o.close()
+		if err := os.Remove(o.path); err != nil {
+			return err
+		}
+	}
+	return syncDir(db.dir)
+}
+
+// mergeNewest merges sorted sequences (newest first) into one sorted
+// sequence with one record per key, taken from the newest sequence that
+// has it.
+func mergeNewest(seqs []iter.Seq[record]) iter.Seq[record] {
+	return func(yield func(record) bool) {
+		type head struct {
+			next func() (record, bool)
+			stop func()
+			cur  record
+			ok   bool
+		}
+		heads := make([]*head, len(seqs))
+		for i, s := range seqs {
+			next, stop := iter.Pull(s)
+			h := &head{next: next, stop: stop}
+			h.cur, h.ok = next()
+			heads[i] = h
+		}
+		defer func() {
+			for _, h := range heads {
+				h.stop()
+			}
+		}()
+
+		for {
+			best := -1
+			for i, h := range heads {
+				if h.ok && (best < 0 || h.cur.key < heads[best].cur.key) {
+					best = i // strict <: ties keep the newer (lower) index
+				}
+			}
+			if best < 0 {
+				return
+			}
+			r := heads[best].cur
+			for _, h := range heads {
+				if h.ok && h.cur.key == r.key {
+					h.cur, h.ok = h.next()
+				}
+			}
+			if !yield(r) {
+				return
+			}
+		}
+	}
+}
+
+// KV is one key/value pair from Scan.
+type KV struct {
+	Key   string
+	Value []byte
+}
+
+// Scan streams live keys with prefix in ascending order, starting after
+// the key `after` ("" for the beginning). It reads batchSize keys per lock
+// acquisition and never holds the lock while the caller's loop body runs,
+// so the caller may write to the DB mid-scan; such writes may or may not
+// be seen.
+func (db *DB) Scan(prefix, after string, batchSize int) iter.Seq2[KV, error] {
+	if batchSize <= 0 {
+		batchSize = 256
+	}
+	return func(yield func(KV, error) bool) {
+		start := max(prefix, after+"\x00")
+		if after == "" {
+			start = prefix
+		}
+		for {
+			batch, last, more, err := db.scanBatch(start, prefix, batchSize)
+			if err != nil {
+				yield(KV{}, err)
+				return
+			}
+			for _, kv := range batch {
+				if !yield(kv, nil) {
+					return
+				}
+			}
+			if !more {
+				return
+			}
+			start = last + "\x00"
+		}
+	}
+}
+
+// scanBatch returns up to n live records from start, the last key examined
+// (live or not) and whether more may follow.
+func (db *DB) scanBatch(start, prefix string, n int) ([]KV, string, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, "", false, ErrClosed
+	}
+
+	var readErr error
+	seqs := make([]iter.Seq[record], 0, 1+len(db.tables))
+	seqs = append(seqs, func(yield func(record) bool) {
+		db.mem.rangeFrom(start, prefix, func(k string, e entry) bool { return yield(record{k, e}) })
+	})
+	for _, t := range db.tables {
+		seqs = append(seqs, t.scan(start, prefix, &readErr))
+	}
+
+	var out []KV
+	var last string
+	examined := 0
+	for r := range mergeNewest(seqs) {
+		last = r.key
+		examined++
+		if !r.deleted {
+			out = append(out, KV{Key: r.key, Value: slices.Clone(r.value)})
+		}
+		if examined == n {
+			return out, last, true, readErr
+		}
+	}
+	return out, last, false, readErr
+}
+
+// Stats returns current counters.
+func (db *DB) Stats() Stats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	st := db.stats
+	st.Tables = len(db.tables)
+	st.MemtableBytes = db.mem.size
+	st.BloomSkips = db.bloomSkips.Load()
+	for _, t := range db.tables {
+		st.TableBytes += t.size
+	}
+	return st
+}
+
+// Close flushes the memtable and closes all files.
+func (db *DB) Close() error {
+	db.compactMu.Lock()
+	defer db.compactMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	err := db.flushLocked()
+	db.closed = true
+	return errors.Join(err, db.wal.close(), db.closeTables())
+}
+
+func (db *DB) closeTables() error {
+	var errs []error
+	for _, t := range db.tables {
+		errs = append(errs, t.close())
+	}
+	return errors.Join(errs...)
+}
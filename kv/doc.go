@@ -0,0 +1,55 @@
+// Package kv is a small log-structured key-value engine (an "LSM-lite").
+//
+// Writes go to a write-ahead log and an in-memory sorted memtable. When the
+// memtable passes Options.MemtableSize it is written out as an immutable,
+// sorted SSTable file and the WAL is reset. Reads check the memtable
+// first, then the tables from newest to oldest; the first hit wins, and a
+// tombstone hit means "deleted". Each table carries a bloom filter, so a
+// Get for a missing key usually touches no table data at all.
+//
+// Tables pile up with every flush, and each one makes misses and scans a
+// bit slower, so once there are Options.CompactAt of them they are merged
+// into one. The merge keeps the newest version of each key and, because it
+// always merges every table, can drop tombstones too.
+//
+// On-disk layout in the directory:
+//
+//	wal/           commitlog of writes since the last flush, replayed on Open
+//	NNNNNN.sst     tables; a higher number is newer
+//	quarantine/    damaged data set aside by Open (package integrity)
+//
+// Table format:
+//
+//	records   [flags u8][keyLen uvarint][valLen uvarint][key][value][crc32c u32]...  (sorted by key)
+//	index     [count uvarint] then per entry [keyLen uvarint][key][offset uvarint]
+//	          one entry every indexEvery records: a sparse index
+//	bloom     [k u8][bits...]
+//	key       wrapped data key, if encrypted (package atrest)
+//	footer    [indexOff u64][bloomOff u64][records u64][metaCRC u32][keyLen u32][magic u64]   (little endian)
+//
+// With Options.Keys, values (never keys: they must stay sortable) are
+// AES-GCM sealed with the table's data key and bound to their key, so a
+// value cannot be moved to another key on disk. The WAL seals whole
+// batches with a per-generation data key. Compaction writes under the
+// current key, which is how key rotation reaches old data.
+//
+// Each record's CRC covers its encoded bytes; metaCRC covers the index,
+// the bloom filter and the first three footer fields. A table whose
+// metadata fails is quarantined whole on Open, so the rest of the
+// database still loads. Record CRCs are checked as records are read
+// (ErrCorrupt), or all up front with Options.Verify, which rewrites a
+// damaged table without its bad blocks. Verify checks a directory
+// offline.
+//
+// New tables are written to a temporary name, fsynced and renamed, so a
+// crash leaves either the old set of tables or the new one. Compaction
+// writes the merged table before deleting its inputs; if it crashes in
+// between, the inputs are still there and still correct, since the merged
+// table holds the same latest values.
+//
+// Simplifications versus a real LSM: flush and automatic compaction run
+// synchronously on the writer that triggers them (Vacuum is the
+// background, throttled alternative), there are no levels
+// (compaction is all-or-nothing), and values are read with one ReadAt per
+// record rather than through a block cache.
+package kv
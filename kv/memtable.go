@@ -0,0 +1,42 @@
+package kv
+
+import (
+	"strings"
+
+	"Go-Internals/btree"
+)
+
+// entry is a value or a tombstone.
+type entry struct {
+	value   []byte
+	deleted bool
+}
+
+// memtable is the sorted in-memory write buffer.
+type memtable struct {
+	tree *btree.BTree[string, entry]
+	size int // approximate bytes
+}
+
+func newMemtable() *memtable {
+	return &memtable{tree: btree.New[string, entry](32, func(a, b string) bool { return a < b })}
+}
+
+func (m *memtable) put(key string, e entry) {
+	if old, ok := m.tree.Set(key, e); ok {
+		m.size -= len(key) + len(old.value)
+	}
+	m.size += len(key) + len(e.value)
+}
+
+func (m *memtable) get(key string) (entry, bool) { return m.tree.Get(key) }
+
+// rangeFrom calls fn for keys >= start with the given prefix, in order.
+func (m *memtable) rangeFrom(start, prefix string, fn func(string, entry) bool) {
+	m.tree.AscendGreaterOrEqual(start, func(k string, e entry) bool {
+		if !strings.HasPrefix(k, prefix) {
+			return false
+		}
+		return fn(k, e)
+	})
+}
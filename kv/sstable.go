@@ -0,0 +1,326 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"Go-Internals/atrest"
+)
+
+const (
+	tableMagic  = 0x4b564c534d4c5432 // "KVLSMLT2": v2 added checksums
+	footerSize  = 40
+	indexEvery  = 16
+	bloomFPRate = 0.01
+)
+
+var ErrCorrupt = errors.New("kv: corrupt data")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// record is a key with its entry, as produced by merges and flushes.
+type record struct {
+	key string
+	entry
+}
+
+// writeTable writes sorted records to path via a temp file, fsync and
+// rename, then fsyncs the directory so the rename itself is durable. With
+// a data key, values are sealed (bound to their key) and the wrapped key
+// is stored in the table.
+func writeTable(path string, n int, dk *atrest.DataKey, records func(yield func(record) bool)) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // no-op after a successful rename
+
+	w := bufio.NewWriterSize(f, 64<<10)
+	bf := newBloom(n, bloomFPRate)
+	type idx struct {
+		key string
+		off uint64
+	}
+	var index []idx
+	var off uint64
+	var count uint64
+	var buf, sealed []byte
+
+	for r := range records {
+		if count%indexEvery == 0 {
+			index = append(index, idx{r.key, off})
+		}
+		bf.add([]byte(r.key))
+		if dk != nil && !r.deleted {
+			sealed = dk.Seal(sealed[:0], r.value, []byte(r.key))
+			r.value = sealed
+		}
+		buf = appendRecord(buf[:0], r.key, r.entry)
+		buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(buf, crcTable))
+		if _, err := w.Write(buf); err != nil {
+			f.Close()
+			return err
+		}
+		off += uint64(len(buf))
+		count++
+	}
+
+	indexOff := off
+	buf = binary.AppendUvarint(buf[:0], uint64(len(index)))
+	for _, e := range index {
+		buf = binary.AppendUvarint(buf, uint64(len(e.key)))
+		buf = append(buf, e.key...)
+		buf = binary.AppendUvarint(buf, e.off)
+	}
+	bloomOff := indexOff + uint64(len(buf))
+	buf = append(buf, bf.marshal()...)
+	var keyBlock []byte
+	if dk != nil {
+		keyBlock = dk.Wrapped()
+	}
+	buf = append(buf, keyBlock...)
+	buf = binary.LittleEndian.AppendUint64(buf, indexOff)
+	buf = binary.LittleEndian.AppendUint64(buf, bloomOff)
+	buf = binary.LittleEndian.AppendUint64(buf, count)
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(buf, crcTable))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(keyBlock)))
+	buf = binary.LittleEndian.AppendUint64(buf, tableMagic)
+	if _, err := w.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// table is an open, immutable SSTable. The sparse index and bloom filter
+// live in memory; records are read from the file on demand.
+type table struct {
+	seq      int
+	path     string
+	f        *os.File
+	size     int64
+	dataEnd  int64
+	count    uint64
+	indexKey []string
+	indexOff []int64
+	filter   *bloom
+
+	encrypted bool
+	dk        *atrest.DataKey // nil if not encrypted, or opened without keys
+}
+
+// openTable opens a table. An encrypted table opened with a nil keyring
+// can only be checked (CRCs), not read: its values stay sealed.
+func openTable(path string, seq int, keys *atrest.Keyring) (*table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := loadTable(f, path, seq, keys)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+func loadTable(f *os.File, path string, seq int, keys *atrest.Keyring) (*table, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := st.Size()
+	if size < footerSize {
+		return nil, ErrCorrupt
+	}
+	var foot [footerSize]byte
+	if _, err := f.ReadAt(foot[:], size-footerSize); err != nil {
+		return nil, err
+	}
+	indexOff := int64(binary.LittleEndian.Uint64(foot[0:]))
+	bloomOff := int64(binary.LittleEndian.Uint64(foot[8:]))
+	count := binary.LittleEndian.Uint64(foot[16:])
+	if binary.LittleEndian.Uint64(foot[32:]) != tableMagic ||
+		indexOff < 0 || indexOff > bloomOff || bloomOff > size-footerSize {
+		return nil, ErrCorrupt
+	}
+
+	// The meta checksum covers the index, the bloom filter and the first
+	// three footer fields.
+	meta := make([]byte, size-indexOff-footerSize+24)
+	if _, err := f.ReadAt(meta, indexOff); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(meta, crcTable) != binary.LittleEndian.Uint32(foot[24:]) {
+		return nil, ErrCorrupt
+	}
+	meta = meta[:len(meta)-24]
+	keyLen := int64(binary.LittleEndian.Uint32(foot[28:]))
+	if keyLen > int64(len(meta))-(bloomOff-indexOff) {
+		return nil, ErrCorrupt
+	}
+	keyBlock := meta[int64(len(meta))-keyLen:]
+	meta = meta[:int64(len(meta))-keyLen]
+	t := &table{seq: seq, path: path, f: f, size: size, dataEnd: indexOff, count: count}
+
+	r := bufio.NewReader(strings.NewReader(string(meta[:bloomOff-indexOff])))
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	for range n {
+		klen, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrCorrupt
+		}
+		key := make([]byte, klen)
+		if _, err := io.ReadFull(r, key); err != nil {
+			return nil, ErrCorrupt
+		}
+		off, err := binary.ReadUvarint(r)
+		if err != nil || int64(off) >= indexOff {
+			return nil, ErrCorrupt
+		}
+		t.indexKey = append(t.indexKey, string(key))
+		t.indexOff = append(t.indexOff, int64(off))
+	}
+
+	if t.filter, err = unmarshalBloom(meta[bloomOff-indexOff:]); err != nil {
+		return nil, err
+	}
+	if t.encrypted = keyLen > 0; t.encrypted && keys != nil {
+		if t.dk, err = keys.OpenDataKey(keyBlock); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// reader positions a record reader at the last index entry <= key.
+func (t *table) reader(key string) *bufio.Reader {
+	i := sort.SearchStrings(t.indexKey, key)
+	if i == len(t.indexKey) || t.indexKey[i] != key {
+		i--
+	}
+	start := int64(0)
+	if i >= 0 {
+		start = t.indexOff[i]
+	}
+	return bufio.NewReaderSize(io.NewSectionReader(t.f, start, t.dataEnd-start), 4096)
+}
+
+// read decodes the next record and checks its CRC.
+func (t *table) read(r *bufio.Reader, scratch *[]byte) (string, entry, error) {
+	k, e, err := readRecord(r, uint64(t.dataEnd))
+	if err != nil {
+		return "", entry{}, err
+	}
+	var sum [4]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return "", entry{}, unexpected(err)
+	}
+	*scratch = appendRecord((*scratch)[:0], k, e)
+	if crc32.Checksum(*scratch, crcTable) != binary.LittleEndian.Uint32(sum[:]) {
+		return "", entry{}, ErrCorrupt
+	}
+	if t.dk != nil && !e.deleted {
+		if e.value, err = t.dk.Open(nil, e.value, []byte(k)); err != nil {
+			return "", entry{}, ErrCorrupt
+		}
+	}
+	return k, e, nil
+}
+
+// corrupt names the table in a read error.
+func (t *table) corrupt(err error) error {
+	if errors.Is(err, ErrCorrupt) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %s", ErrCorrupt, t.path)
+	}
+	return err
+}
+
+// get looks key up in the data section; callers check the bloom filter
+// first.
+func (t *table) get(key string) (entry, bool, error) {
+	r := t.reader(key)
+	var scratch []byte
+	for range indexEvery {
+		k, e, err := t.read(r, &scratch)
+		if errors.Is(err, io.EOF) {
+			return entry{}, false, nil
+		}
+		if err != nil {
+			return entry{}, false, t.corrupt(err)
+		}
+		if k == key {
+			return e, true, nil
+		}
+		if k > key {
+			break
+		}
+	}
+	return entry{}, false, nil
+}
+
+// scan yields every record with key >= start and the prefix. A read
+// error ends the sequence and is stored in *errp.
+func (t *table) scan(start, prefix string, errp *error) iter.Seq[record] {
+	return func(yield func(record) bool) {
+		r := t.reader(start)
+		var scratch []byte
+		for {
+			k, e, err := t.read(r, &scratch)
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				*errp = t.corrupt(err)
+				return
+			}
+			if k < start {
+				continue
+			}
+			if !strings.HasPrefix(k, prefix) || !yield(record{k, e}) {
+				return
+			}
+		}
+	}
+}
+
+func (t *table) close() error { return t.f.Close() }
+
+func tableName(seq int) string { return fmt.Sprintf("%06d.sst", seq) }
@@ -0,0 +1,165 @@
+package kv
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"Go-Internals/commitlog"
+	"Go-Internals/integrity"
+)
+
+/*
+-----------------------------------
+BLOCK-WISE READS
+-----------------------------------
+*/
+
+// Each sparse index entry starts a block of up to indexEvery records, and
+// is the only place a reader can resynchronize after a bad record, so
+// damage is handled a block at a time.
+
+// salvage yields every record from intact blocks and returns the start
+// offset of each block it had to drop. A block is dropped whole if any
+// record in it fails: records after a bad length cannot be located.
+func (t *table) salvage(yield func(record) bool) (bad []int64) {
+	var scratch, pending []record
+	var buf []byte
+	for i, start := range t.indexOff {
+		end := t.dataEnd
+		if i+1 < len(t.indexOff) {
+			end = t.indexOff[i+1]
+		}
+		r := bufio.NewReader(io.NewSectionReader(t.f, start, end-start))
+		pending = scratch[:0]
+		ok := true
+		for {
+			k, e, err := t.read(r, &buf)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				ok = false
+				break
+			}
+			pending = append(pending, record{k, e})
+		}
+		if !ok {
+			bad = append(bad, start)
+			continue
+		}
+		for _, rec := range pending {
+			if !yield(rec) {
+				return bad
+			}
+		}
+		scratch = pending
+	}
+	return bad
+}
+
+// verifyTables checks every record (Options.Verify). A damaged table is
+// moved to quarantine and replaced by a copy holding its intact blocks.
+// Dropped records may let older versions of their keys, from older
+// tables, show through again, which beats refusing to start.
+func (db *DB) verifyTables() error {
+	for i := 0; i < len(db.tables); i++ {
+		t := db.tables[i]
+		bad := t.salvage(func(record) bool { return true })
+		if len(bad) == 0 {
+			continue
+		}
+
+		qpath, err := integrity.Move(db.dir, t.path)
+		if err != nil {
+			return err
+		}
+		for _, off := range bad {
+			db.problems = append(db.problems, integrity.Problem{File: t.path, Offset: off,
+				Detail: fmt.Sprintf("block of up to %d records fails its checksum, dropped", indexEvery), Quarantined: qpath})
+		}
+		// t.f still reads the quarantined file.
+		dk, err := db.dataKey()
+		if err != nil {
+			return err
+		}
+		kept := 0
+		err = writeTable(t.path, int(t.count), dk, func(yield func(record) bool) {
+			t.salvage(func(r record) bool { kept++; return yield(r) })
+		})
+		t.close()
+		if err != nil {
+			return err
+		}
+		if kept == 0 {
+			os.Remove(t.path)
+			db.tables = append(db.tables[:i], db.tables[i+1:]...)
+			i--
+			continue
+		}
+		if db.tables[i], err = openTable(t.path, t.seq, db.opts.Keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+-----------------------------------
+OFFLINE VERIFICATION
+-----------------------------------
+*/
+
+// Verify checks a database directory without opening it for writing:
+// every table's metadata and record checksums, and the WAL segments. It
+// needs no keys: checksums cover the encrypted bytes. The database must
+// not be open in another process.
+func Verify(dir string) (integrity.Report, error) {
+	var rep integrity.Report
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return rep, err
+	}
+	for _, e := range entries {
+		base, ok := strings.CutSuffix(e.Name(), ".sst")
+		if !ok {
+			continue
+		}
+		seq, err := strconv.Atoi(base)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		rep.Files++
+		t, err := openTable(path, seq, nil)
+		if errors.Is(err, ErrCorrupt) {
+			rep.Add(integrity.Problem{File: path, Offset: -1, Detail: "table metadata fails its checksum"})
+			continue
+		}
+		if err != nil {
+			return rep, err
+		}
+		n := 0
+		bad := t.salvage(func(record) bool { n++; return true })
+		t.close()
+		rep.Records += n
+		for _, off := range bad {
+			rep.Add(integrity.Problem{File: path, Offset: off, Detail: "record block fails its checksum"})
+		}
+	}
+
+	walDir := filepath.Join(dir, "wal")
+	if _, err := os.Stat(walDir); err == nil {
+		wr, err := commitlog.Verify(walDir)
+		if err != nil {
+			return rep, err
+		}
+		rep.Merge(wr)
+	}
+	return rep, nil
+}
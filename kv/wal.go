@@ -0,0 +1,207 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"Go-Internals/atrest"
+	"Go-Internals/commitlog"
+	"Go-Internals/integrity"
+)
+
+const (
+	flagPut       = 0
+	flagTombstone = 1
+)
+
+// appendRecord encodes one record in the format shared by the WAL and
+// tables.
+func appendRecord(buf []byte, key string, e entry) []byte {
+	flags := byte(flagPut)
+	if e.deleted {
+		flags = flagTombstone
+	}
+	buf = append(buf, flags)
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = binary.AppendUvarint(buf, uint64(len(e.value)))
+	buf = append(buf, key...)
+	return append(buf, e.value...)
+}
+
+// readRecord decodes one record; io.EOF means a clean end of input.
+// limit bounds the lengths it will believe, so a corrupt length fails
+// with ErrCorrupt instead of a huge allocation.
+func readRecord(r *bufio.Reader, limit uint64) (string, entry, error) {
+	flags, err := r.ReadByte()
+	if err != nil {
+		return "", entry{}, err
+	}
+	if flags != flagPut && flags != flagTombstone {
+		return "", entry{}, ErrCorrupt
+	}
+	klen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", entry{}, unexpected(err)
+	}
+	vlen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", entry{}, unexpected(err)
+	}
+	if klen > limit || vlen > limit-klen {
+		return "", entry{}, ErrCorrupt
+	}
+	buf := make([]byte, klen+vlen)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", entry{}, unexpected(err)
+	}
+	e := entry{deleted: flags == flagTombstone}
+	if !e.deleted {
+		e.value = buf[klen:]
+	}
+	return string(buf[:klen]), e, nil
+}
+
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// wal is the write-ahead log for the current memtable. Each commitlog
+// record holds one write or one whole Batch, so the log's framing makes
+// batches atomic across a crash too.
+//
+// Every log record starts with a type byte. With a keyring, the first
+// record after each reset carries a fresh wrapped data key and the
+// batches after it are sealed with that key.
+type wal struct {
+	dir  string
+	log  *commitlog.Log
+	keys *atrest.Keyring
+	dk   *atrest.DataKey // key of the current generation, once written
+	buf  []byte
+}
+
+const (
+	walPlain  = 'P' // [type][records...]
+	walKey    = 'K' // [type][wrapped data key]
+	walSealed = 'S' // [type][sealed records...]
+)
+
+var walAAD = []byte("kv-wal")
+
+func openWAL(dir string, sync, verify bool, keys *atrest.Keyring) (*wal, error) {
+	l, err := commitlog.Open(dir, commitlog.Options{SyncWrites: sync, Verify: verify})
+	if err != nil {
+		return nil, err
+	}
+	return &wal{dir: dir, log: l, keys: keys}, nil
+}
+
+// replay feeds every logged record to fn. The commitlog has already cut
+// off a torn tail on open. A log record that passed its CRC but does not
+// decode (a bug rather than a crash) is quarantined whole, so none of the
+// batch it held is applied.
+func (w *wal) replay(fn func(string, entry)) ([]integrity.Problem, error) {
+	var problems []integrity.Problem
+	var batch []record
+	for rec, err := range w.log.Scan(w.log.OldestOffset()) {
+		if err != nil {
+			return problems, err
+		}
+		if len(rec.Data) > 0 && rec.Data[0] == walKey {
+			if w.keys == nil {
+				return problems, fmt.Errorf("%w: %s", ErrNoKeys, w.dir)
+			}
+			if w.dk, err = w.keys.OpenDataKey(rec.Data[1:]); err != nil {
+				return problems, err
+			}
+			continue
+		}
+		batch, err = w.decode(rec.Data, batch[:0])
+		if err != nil {
+			p := integrity.Problem{File: w.dir, Offset: -1, Detail: fmt.Sprintf("wal record %d does not decode: %v", rec.Offset, err)}
+			if p.Quarantined, err = integrity.Save(w.dir, "wal", int64(rec.Offset), rec.Data); err != nil {
+				return problems, err
+			}
+			problems = append(problems, p)
+			continue
+		}
+		for _, r := range batch {
+			fn(r.key, r.entry)
+		}
+	}
+	return problems, nil
+}
+
+func (w *wal) decode(data []byte, batch []record) ([]record, error) {
+	if len(data) == 0 {
+		return batch, ErrCorrupt
+	}
+	switch data[0] {
+	case walPlain:
+		data = data[1:]
+	case walSealed:
+		if w.dk == nil {
+			return batch, ErrCorrupt // no key record before it
+		}
+		var err error
+		if data, err = w.dk.Open(nil, data[1:], walAAD); err != nil {
+			return batch, err
+		}
+	default:
+		return batch, ErrCorrupt
+	}
+	r := bufio.NewReader(bytes.NewReader(data))
+	for {
+		k, e, err := readRecord(r, uint64(len(data)))
+		if errors.Is(err, io.EOF) {
+			return batch, nil
+		}
+		if err != nil {
+			return batch, err
+		}
+		batch = append(batch, record{k, e})
+	}
+}
+
+func (w *wal) append(records ...record) error {
+	if w.keys != nil && w.dk == nil {
+		dk, err := w.keys.NewDataKey()
+		if err != nil {
+			return err
+		}
+		if _, err := w.log.Append(append([]byte{walKey}, dk.Wrapped()...)); err != nil {
+			return err
+		}
+		w.dk = dk
+	}
+
+	w.buf = w.buf[:0]
+	for _, r := range records {
+		w.buf = appendRecord(w.buf, r.key, r.entry)
+	}
+	if w.dk != nil {
+		_, err := w.log.Append(w.dk.Seal([]byte{walSealed}, w.buf, walAAD))
+		return err
+	}
+	_, err := w.log.Append(append([]byte{walPlain}, w.buf...))
+	return err
+}
+
+// reset drops the log once its contents are safely in a table. The next
+// generation gets a new data key, under the keyring's current key.
+func (w *wal) reset() error {
+	if err := w.log.TruncateBefore(w.log.NextOffset()); err != nil {
+		return err
+	}
+	w.dk = nil
+	return w.log.Sync()
+}
+
+func (w *wal) close() error { return w.log.Close() }
@@ -0,0 +1,45 @@
+package loadshed
+
+import (
+	"net/http"
+	"time"
+)
+
+// Classifier assigns a priority to an incoming request.
+type Classifier func(*http.Request) Priority
+
+// HeaderClassifier reads the X-Priority header ("batch", "interactive",
+// "critical"), defaulting to Interactive. Callers can only lower their
+// priority this way: critical must come from a trusted classifier.
+func HeaderClassifier(r *http.Request) Priority {
+	switch r.Header.Get("X-Priority") {
+	case "batch":
+		return Batch
+	default:
+		return Interactive
+	}
+}
+
+// Middleware tags the request context with its priority, sheds it with
+// 503 + Retry-After when overloaded, and feeds latency to the shedder.
+// Batch requests are not observed: a long export would read as overload.
+func Middleware(s *Shedder, classify Classifier) func(http.Handler) http.Handler {
+	if classify == nil {
+		classify = HeaderClassifier
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := classify(r)
+			if err := s.Allow(p); err != nil {
+				w.Header().Set("Retry-After", "5")
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			start := time.Now()
+			next.ServeHTTP(w, r.WithContext(WithPriority(r.Context(), p)))
+			if p != Batch {
+				s.Observe(time.Since(start))
+			}
+		})
+	}
+}
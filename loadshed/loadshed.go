@@ -0,0 +1,166 @@
+// Package loadshed rejects low-priority work when the process is
+// overloaded so that interactive traffic keeps its latency.
+//
+// Overload is a single number in [0, ∞): the highest of the configured
+// signals, each normalized so 1.0 means "at capacity" (queue full, limiter
+// at its limit, latency at target). Each priority has a load level above
+// which it is shed; by default batch work (bulk exports, backfills) goes
+// first at 0.7, interactive requests at 1.0, and critical requests are
+// never shed. Shedding early and by priority beats letting every request
+// queue and time out together.
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOverloaded matches every *OverloadError via errors.Is.
+var ErrOverloaded = errors.New("loadshed: overloaded")
+
+// OverloadError says which request was shed and why.
+type OverloadError struct {
+	Priority Priority
+	Load     float64
+}
+
+func (e *OverloadError) Error() string {
+	return fmt.Sprintf("loadshed: shedding %s request at load %.2f", e.Priority, e.Load)
+}
+
+func (e *OverloadError) Is(target error) bool { return target == ErrOverloaded }
+
+// Priority orders requests; higher survives longer.
+type Priority int
+
+const (
+	Batch Priority = iota
+	Interactive
+	Critical
+)
+
+func (p Priority) String() string {
+	switch p {
+	case Batch:
+		return "batch"
+	case Interactive:
+		return "interactive"
+	case Critical:
+		return "critical"
+	default:
+		return fmt.Sprintf("priority(%d)", int(p))
+	}
+}
+
+type priorityKey struct{}
+
+// WithPriority tags ctx; untagged contexts are Interactive.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func PriorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return Interactive
+}
+
+// Signal reports a load ratio: 0 idle, 1 at capacity.
+type Signal func() float64
+
+// Options configures a Shedder.
+type Options struct {
+	Signals []Signal
+
+	// TargetLatency enables the built-in latency signal: an EWMA of the
+	// durations passed to Observe, divided by this target.
+	TargetLatency time.Duration
+
+	// Thresholds maps priority to the load at which it is shed. Missing
+	// priorities use the defaults (Batch 0.7, Interactive 1.0); Critical
+	// is never shed unless listed.
+	Thresholds map[Priority]float64
+}
+
+// Shedder is safe for concurrent use.
+type Shedder struct {
+	opts       Options
+	thresholds map[Priority]float64
+
+	mu   sync.Mutex
+	ewma float64 // nanoseconds
+
+	shed [Critical + 1]atomic.Int64
+}
+
+func New(opts Options) *Shedder {
+	t := map[Priority]float64{Batch: 0.7, Interactive: 1.0}
+	for p, v := range opts.Thresholds {
+		t[p] = v
+	}
+	return &Shedder{opts: opts, thresholds: t}
+}
+
+// Load is the current overload level.
+func (s *Shedder) Load() float64 {
+	load := 0.0
+	for _, sig := range s.opts.Signals {
+		load = math.Max(load, sig())
+	}
+	if s.opts.TargetLatency > 0 {
+		s.mu.Lock()
+		lat := s.ewma
+		s.mu.Unlock()
+		load = math.Max(load, lat/float64(s.opts.TargetLatency))
+	}
+	return load
+}
+
+// Allow returns an *OverloadError if a request of priority p should be
+// shed right now.
+func (s *Shedder) Allow(p Priority) error {
+	limit, ok := s.thresholds[p]
+	if !ok {
+		return nil
+	}
+	if load := s.Load(); load >= limit {
+		if p >= Batch && p <= Critical {
+			s.shed[p].Add(1)
+		}
+		return &OverloadError{Priority: p, Load: load}
+	}
+	return nil
+}
+
+// AllowCtx is Allow with the priority taken from ctx.
+func (s *Shedder) AllowCtx(ctx context.Context) error { return s.Allow(PriorityFrom(ctx)) }
+
+// Observe feeds a completed request's latency to the latency signal.
+func (s *Shedder) Observe(d time.Duration) {
+	if s.opts.TargetLatency <= 0 {
+		return
+	}
+	const alpha = 0.1
+	s.mu.Lock()
+	if s.ewma == 0 {
+		s.ewma = float64(d)
+	} else {
+		s.ewma += (float64(d) - s.ewma) * alpha
+	}
+	s.mu.Unlock()
+}
+
+// Shed reports how many requests of each priority were rejected.
+func (s *Shedder) Shed() map[Priority]int64 {
+	out := make(map[Priority]int64, len(s.shed))
+	for p := range s.shed {
+		out[Priority(p)] = s.shed[p].Load()
+	}
+	return out
+}
@@ -0,0 +1,29 @@
+package loadshed
+
+import (
+	"Go-Internals/adaptive"
+	"Go-Internals/boundedqueue"
+)
+
+// QueueDepth is depth/capacity of q.
+func QueueDepth[T any](q *boundedqueue.Queue[T]) Signal {
+	return func() float64 {
+		st := q.Stats()
+		if st.Capacity == 0 {
+			return 0
+		}
+		return float64(st.Depth) / float64(st.Capacity)
+	}
+}
+
+// LimiterUtilization is in-flight/limit of l: 1.0 means new requests are
+// about to be rejected by the limiter itself.
+func LimiterUtilization(l *adaptive.Limiter) Signal {
+	return func() float64 {
+		st := l.Stats()
+		if st.Limit == 0 {
+			return 0
+		}
+		return float64(st.InFlight) / float64(st.Limit)
+	}
+}
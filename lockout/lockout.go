@@ -0,0 +1,341 @@
+// Package lockout slows down credential guessing: it counts failed
+// authentications per account and per client address, locks an account
+// that fails too often and throttles an address that does, for a while.
+//
+// Failures decay rather than expire: each counts half after HalfLife, so
+// a user mistyping now and then never gets near the limit while a burst
+// reaches it fast, and an account unlocked by time alone is relocked by
+// fewer attempts than it took the first time. A success clears the
+// account's failures; an admin's Unlock clears a lock and them both.
+//
+// Accounts are named by what the attempt claimed, which for a bearer
+// token is its unverified subject: anyone can lock anyone out for
+// LockFor. The address throttle is what keeps that, and guessing across
+// many accounts, to one client's worth.
+//
+// A Guard is an auth.Guard. Locks, throttles and unlocks are recorded
+// in the audit trail and published, with every failure, as security
+// events.
+package lockout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/clock"
+	"Go-Internals/ctxutil"
+	"Go-Internals/eventbus"
+)
+
+var (
+	ErrLocked    = errors.New("lockout: account locked")
+	ErrThrottled = errors.New("lockout: too many failed attempts from this address")
+)
+
+// Error refuses an attempt against a locked account or from a throttled
+// address. It matches ErrLocked or ErrThrottled.
+type Error struct {
+	Kind Kind
+	Key  string
+	// Retry is how long until the lock lifts.
+	Retry time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v; retry in %s", e.sentinel(), e.Retry.Round(time.Second))
+}
+
+func (e *Error) Is(target error) bool { return target == e.sentinel() }
+
+// RetryAfter makes Error an auth.Guard rejection with a Retry-After.
+func (e *Error) RetryAfter() time.Duration { return e.Retry }
+
+func (e *Error) sentinel() error {
+	if e.Kind == Account {
+		return ErrLocked
+	}
+	return ErrThrottled
+}
+
+// Kind is what a State counts failures of.
+type Kind string
+
+const (
+	Account Kind = "account"
+	IP      Kind = "ip"
+)
+
+// Topics of the security events a Guard publishes, each with a State.
+const (
+	TopicFailed   = "security.auth_failed"
+	TopicLocked   = "security.locked"
+	TopicUnlocked = "security.unlocked"
+)
+
+// State is what a Guard knows of an account or address.
+type State struct {
+	Kind Kind   `json:"kind"`
+	Key  string `json:"key"`
+	// Failures is the decayed count of failed attempts.
+	Failures float64 `json:"failures"`
+	// LockedUntil is zero if it is not locked.
+	LockedUntil time.Time `json:"locked_until,omitzero"`
+	// Locks counts the times it has been locked, while it is tracked.
+	Locks int `json:"locks"`
+}
+
+// Options configures New.
+type Options struct {
+	// MaxFailures is the decayed count of failures that locks an
+	// account; default 5.
+	MaxFailures float64
+	// MaxIPFailures is the count that throttles an address, across all
+	// the accounts it tried; default 20.
+	MaxIPFailures float64
+	// HalfLife is how long a failure takes to count half; default 10m.
+	HalfLife time.Duration
+	// LockFor is how long a lock or a throttle lasts; default 15m.
+	LockFor time.Duration
+	// Audit records lockout.locked and lockout.unlocked, with the admin
+	// who unlocked; a lock lifting on its own is not recorded, its
+	// locked_until says when. Default audit.Discard.
+	Audit audit.Sink
+	// Events, if set, is where the security events are published.
+	Events *eventbus.Bus
+	// Clock defaults to clock.Real().
+	Clock clock.Clock
+}
+
+type key struct {
+	kind Kind
+	key  string
+}
+
+type entry struct {
+	failures    float64
+	at          time.Time // of failures
+	lockedUntil time.Time
+	locks       int
+}
+
+// Guard is safe for concurrent use.
+type Guard struct {
+	opts Options
+
+	mu      sync.Mutex
+	entries map[key]*entry
+	fails   int // since the last prune
+}
+
+func New(opts Options) *Guard {
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = 5
+	}
+	if opts.MaxIPFailures <= 0 {
+		opts.MaxIPFailures = 20
+	}
+	if opts.HalfLife <= 0 {
+		opts.HalfLife = 10 * time.Minute
+	}
+	if opts.LockFor <= 0 {
+		opts.LockFor = 15 * time.Minute
+	}
+	if opts.Audit == nil {
+		opts.Audit = audit.Discard
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	return &Guard{opts: opts, entries: make(map[key]*entry)}
+}
+
+// Admit refuses an attempt against a locked account or from a throttled
+// address with an *Error; "" for either is not checked.
+func (g *Guard) Admit(_ context.Context, account, ip string) error {
+	now := g.opts.Clock.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, k := range []key{{Account, account}, {IP, ip}} {
+		if e, ok := g.entries[k]; ok && k.key != "" && now.Before(e.lockedUntil) {
+			return &Error{Kind: k.kind, Key: k.key, Retry: e.lockedUntil.Sub(now)}
+		}
+	}
+	return nil
+}
+
+// Failed counts a failed attempt against account from ip, locking
+// either that reaches its limit.
+func (g *Guard) Failed(ctx context.Context, account, ip string) {
+	now := g.opts.Clock.Now()
+	var failed, locked []State
+	g.mu.Lock()
+	for _, k := range []key{{Account, account}, {IP, ip}} {
+		if k.key == "" {
+			continue
+		}
+		e := g.entry(k)
+		e.failures = g.decayed(e, now) + 1
+		e.at = now
+		limit := g.opts.MaxFailures
+		if k.kind == IP {
+			limit = g.opts.MaxIPFailures
+		}
+		// To the hundredth, as State shows it: a burst's few
+		// milliseconds of decay should not save it an attempt.
+		if math.Round(e.failures*100)/100 >= limit && !now.Before(e.lockedUntil) {
+			e.lockedUntil = now.Add(g.opts.LockFor)
+			e.locks++
+			locked = append(locked, g.state(k, e, now))
+		}
+		failed = append(failed, g.state(k, e, now))
+	}
+	if g.fails++; g.fails >= 1024 {
+		g.prune(now)
+	}
+	g.mu.Unlock()
+
+	for _, s := range failed {
+		g.publish(ctx, TopicFailed, s)
+	}
+	for _, s := range locked {
+		g.record(ctx, "lockout.locked", s)
+		g.publish(ctx, TopicLocked, s)
+	}
+}
+
+// Succeeded clears account's failures. The address keeps its own: one
+// good token does not vouch for the others it tried.
+func (g *Guard) Succeeded(_ context.Context, account, _ string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if e, ok := g.entries[key{Account, account}]; ok {
+		e.failures = 0
+	}
+}
+
+// Unlock lifts the lock on an account or address and clears its
+// failures. It reports false if there was none to lift.
+func (g *Guard) Unlock(ctx context.Context, kind Kind, k string) bool {
+	now := g.opts.Clock.Now()
+	g.mu.Lock()
+	e, ok := g.entries[key{kind, k}]
+	if !ok || !now.Before(e.lockedUntil) {
+		g.mu.Unlock()
+		return false
+	}
+	e.lockedUntil, e.failures = time.Time{}, 0
+	s := g.state(key{kind, k}, e, now)
+	g.mu.Unlock()
+
+	g.record(ctx, "lockout.unlocked", s)
+	g.publish(ctx, TopicUnlocked, s)
+	return true
+}
+
+// State is what the Guard knows of an account or address; a zero State
+// but for its Kind and Key if nothing.
+func (g *Guard) State(kind Kind, k string) State {
+	now := g.opts.Clock.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if e, ok := g.entries[key{kind, k}]; ok {
+		return g.state(key{kind, k}, e, now)
+	}
+	return State{Kind: kind, Key: k}
+}
+
+// List is every tracked account and address: the locked ones first, by
+// when they unlock, then the rest by failures, most first.
+func (g *Guard) List() []State {
+	now := g.opts.Clock.Now()
+	g.mu.Lock()
+	out := make([]State, 0, len(g.entries))
+	for k, e := range g.entries {
+		if s := g.state(k, e, now); s.Failures >= 0.01 || !s.LockedUntil.IsZero() {
+			out = append(out, s)
+		}
+	}
+	g.mu.Unlock()
+	slices.SortFunc(out, func(a, b State) int {
+		switch {
+		case a.LockedUntil.IsZero() != b.LockedUntil.IsZero():
+			if a.LockedUntil.IsZero() {
+				return 1
+			}
+			return -1
+		case !a.LockedUntil.Equal(b.LockedUntil):
+			return a.LockedUntil.Compare(b.LockedUntil)
+		case a.Failures != b.Failures:
+			if a.Failures > b.Failures {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(string(a.Kind)+a.Key, string(b.Kind)+b.Key)
+	})
+	return out
+}
+
+// entry must be called with g.mu held.
+func (g *Guard) entry(k key) *entry {
+	e, ok := g.entries[k]
+	if !ok {
+		e = &entry{}
+		g.entries[k] = e
+	}
+	return e
+}
+
+func (g *Guard) decayed(e *entry, now time.Time) float64 {
+	if e.failures == 0 {
+		return 0
+	}
+	return e.failures * math.Exp2(-float64(now.Sub(e.at))/float64(g.opts.HalfLife))
+}
+
+// state must be called with g.mu held.
+func (g *Guard) state(k key, e *entry, now time.Time) State {
+	s := State{Kind: k.kind, Key: k.key, Failures: math.Round(g.decayed(e, now)*100) / 100, Locks: e.locks}
+	if now.Before(e.lockedUntil) {
+		s.LockedUntil = e.lockedUntil
+	}
+	return s
+}
+
+// prune forgets the entries that are unlocked and down to almost no
+// failures. It must be called with g.mu held.
+func (g *Guard) prune(now time.Time) {
+	g.fails = 0
+	for k, e := range g.entries {
+		if !now.Before(e.lockedUntil) && g.decayed(e, now) < 0.01 {
+			delete(g.entries, k)
+		}
+	}
+}
+
+// record is best effort: the lock stands either way.
+func (g *Guard) record(ctx context.Context, action string, s State) {
+	meta := map[string]string{"failures": fmt.Sprintf("%.2f", s.Failures)}
+	if !s.LockedUntil.IsZero() {
+		meta["locked_until"] = s.LockedUntil.Format(time.RFC3339)
+	}
+	_ = g.opts.Audit.Record(ctx, audit.Entry{
+		Actor:    ctxutil.UserID(ctx),
+		Action:   action,
+		Resource: string(s.Kind) + ":" + s.Key,
+		Meta:     meta,
+	})
+}
+
+func (g *Guard) publish(ctx context.Context, topic string, s State) {
+	if g.opts.Events != nil {
+		_, _ = g.opts.Events.Publish(ctx, topic, s)
+	}
+}
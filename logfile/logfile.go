@@ -0,0 +1,343 @@
+// Package logfile is an io.Writer to a log file that rotates itself, so a
+// process that runs for months does not fill the disk.
+//
+// The file is rotated when a write would take it past MaxSize or when it
+// has been open longer than MaxAge: it is renamed to path.<timestamp>
+// and a new one is started. Rotated files are gzipped in the background
+// if Compress is set, and only the newest MaxFiles are kept.
+//
+// Reopen is for rotation done by someone else: after logrotate (or an
+// operator) has moved the file away, a SIGHUP handler calls Reopen and
+// writing continues in a fresh file at path instead of the moved one.
+package logfile
+
+import (
+	"cmp"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+var ErrClosed = errors.New("logfile: closed")
+
+// stamp names rotated files; it sorts in time order.
+const stamp = "20060102T150405Z"
+
+// Options configures Open.
+type Options struct {
+	Path string
+	// MaxSize is the size a file is rotated at; default 100 MiB.
+	MaxSize int64
+	// MaxAge rotates a file once it has been written for this long, even
+	// if small; 0 rotates on size only.
+	MaxAge time.Duration
+	// MaxFiles is how many rotated files are kept; default 7, -1 keeps
+	// them all.
+	MaxFiles int
+	// Compress gzips rotated files.
+	Compress bool
+	Clock    clock.Clock
+	// Logger reports failures of the background work (compression,
+	// deletion), which no Write can return; default slog.Default(). Do
+	// not point it at this file.
+	Logger *slog.Logger
+}
+
+// File is safe for concurrent use.
+type File struct {
+	opts Options
+	clk  clock.Clock
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	opened  time.Time
+	closed  bool
+	pending sync.WaitGroup // background compression and clean-up
+	bg      sync.Mutex     // one clean-up at a time
+}
+
+// Open opens (or creates) the file at opts.Path for appending.
+func Open(opts Options) (*File, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 100 << 20
+	}
+	if opts.MaxFiles == 0 {
+		opts.MaxFiles = 7
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	lf := &File{opts: opts, clk: clock.OrReal(opts.Clock)}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+func (lf *File) open() error {
+	if err := os.MkdirAll(filepath.Dir(lf.opts.Path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(lf.opts.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	lf.f, lf.size = f, st.Size()
+	lf.opened = lf.clk.Now()
+	if st.Size() > 0 {
+		lf.opened = lf.started()
+	}
+	return nil
+}
+
+// started guesses when a file carried over from a previous run was
+// begun: at the newest rotation, which is when it took over the path,
+// or else now. Without it a process restarted daily would never reach
+// a MaxAge of a week.
+func (lf *File) started() time.Time {
+	now := lf.clk.Now()
+	old, err := lf.Rotated()
+	if err != nil || len(old) == 0 {
+		return now
+	}
+	ts, _, _ := stampOf(filepath.Base(old[len(old)-1]), filepath.Base(lf.opts.Path))
+	if ts.After(now) {
+		return now
+	}
+	return ts
+}
+
+// Write appends p, rotating first if p would not fit. A single write
+// larger than MaxSize goes into a file of its own rather than being
+// split, so log lines stay whole.
+func (lf *File) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.closed {
+		return 0, ErrClosed
+	}
+	if lf.size > 0 && (lf.size+int64(len(p)) > lf.opts.MaxSize ||
+		lf.opts.MaxAge > 0 && lf.clk.Now().Sub(lf.opened) >= lf.opts.MaxAge) {
+		if err := lf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+// Rotate starts a new file now.
+func (lf *File) Rotate() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.closed {
+		return ErrClosed
+	}
+	return lf.rotate()
+}
+
+func (lf *File) rotate() error {
+	if err := lf.f.Close(); err != nil {
+		return err
+	}
+	name := lf.rotatedName()
+	if err := os.Rename(lf.opts.Path, name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		// Keep writing where we were rather than lose lines.
+		if oerr := lf.open(); oerr != nil {
+			return errors.Join(err, oerr)
+		}
+		return err
+	}
+	if err := lf.open(); err != nil {
+		return err
+	}
+	lf.pending.Add(1)
+	go func() {
+		defer lf.pending.Done()
+		lf.cleanUp()
+	}()
+	return nil
+}
+
+// rotatedName is path.<stamp>, with -1, -2... if that is taken (two
+// rotations within a second).
+func (lf *File) rotatedName() string {
+	base := lf.opts.Path + "." + lf.clk.Now().UTC().Format(stamp)
+	name := base
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+	return name
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// compress gzips path to path.gz and removes path. The .gz appears
+// whole or not at all; a crash midway leaves the uncompressed file.
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// cleanUp deletes the oldest rotated files beyond MaxFiles and
+// compresses the rest. It works from the directory rather than from the
+// rotation that started it, so rotations in quick succession cannot trip
+// over each other, and a file left uncompressed by a crash is picked up
+// next time.
+func (lf *File) cleanUp() {
+	lf.bg.Lock()
+	defer lf.bg.Unlock()
+	old, err := lf.Rotated()
+	if err != nil {
+		lf.opts.Logger.Warn("logfile: listing rotated logs failed", "err", err)
+		return
+	}
+	for lf.opts.MaxFiles >= 0 && len(old) > lf.opts.MaxFiles {
+		if err := os.Remove(old[0]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			lf.opts.Logger.Warn("logfile: removing old log failed", "file", old[0], "err", err)
+		}
+		old = old[1:]
+	}
+	if !lf.opts.Compress {
+		return
+	}
+	for _, name := range old {
+		if strings.HasSuffix(name, ".gz") {
+			continue
+		}
+		if err := compress(name); err != nil {
+			lf.opts.Logger.Warn("logfile: compressing rotated log failed", "file", name, "err", err)
+		}
+	}
+}
+
+// Rotated lists the rotated files, oldest first.
+func (lf *File) Rotated() ([]string, error) {
+	dir, base := filepath.Split(lf.opts.Path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type rotated struct {
+		name string
+		at   time.Time
+		seq  int
+	}
+	var found []rotated
+	for _, e := range entries {
+		if at, seq, ok := stampOf(e.Name(), base); ok {
+			found = append(found, rotated{filepath.Join(dir, e.Name()), at, seq})
+		}
+	}
+	slices.SortFunc(found, func(a, b rotated) int {
+		if c := a.at.Compare(b.at); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.seq, b.seq)
+	})
+	out := make([]string, len(found))
+	for i, r := range found {
+		out[i] = r.name
+	}
+	return out, nil
+}
+
+// stampOf parses the rotation time and collision suffix out of a
+// rotated file's name.
+func stampOf(name, base string) (time.Time, int, bool) {
+	rest, ok := strings.CutPrefix(name, base+".")
+	if !ok || strings.HasSuffix(rest, ".tmp") {
+		return time.Time{}, 0, false
+	}
+	ts, suffix, hasSeq := strings.Cut(strings.TrimSuffix(rest, ".gz"), "-")
+	t, err := time.Parse(stamp, ts)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	seq := 0
+	if hasSeq {
+		if seq, err = strconv.Atoi(suffix); err != nil || seq < 1 {
+			return time.Time{}, 0, false
+		}
+	}
+	return t, seq, true
+}
+
+// Reopen closes the file and opens path again: after an outside tool
+// has moved it, this starts a new file there.
+func (lf *File) Reopen() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.closed {
+		return ErrClosed
+	}
+	if err := lf.f.Close(); err != nil {
+		return err
+	}
+	return lf.open()
+}
+
+// Close closes the file and waits for background compression.
+func (lf *File) Close() error {
+	lf.mu.Lock()
+	if lf.closed {
+		lf.mu.Unlock()
+		return nil
+	}
+	lf.closed = true
+	err := lf.f.Close()
+	lf.mu.Unlock()
+	lf.pending.Wait()
+	return err
+}
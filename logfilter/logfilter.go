@@ -0,0 +1,398 @@
+// Package logfilter is a slog.Handler in front of the real one that
+// decides what is worth writing: a level per module, changeable while the
+// process runs, and sampling of messages that repeat.
+//
+// A record's module is its "module" attribute, or else the prefix of its
+// message: the packages here log as "vacuum: compacted", so "vacuum" is
+// the module without anyone having to tag it. Modules without a level of
+// their own use the default.
+//
+// Sampling keys on module, level and message. In each period the first
+// few records of a key are written and the rest only by chance; when the
+// period is over, one record says how many were left out. A failing
+// dependency that logs the same error a thousand times a second costs a
+// line or two per second instead of the disk.
+package logfilter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+/*
+-----------------------------------
+LEVELS
+-----------------------------------
+*/
+
+// Levels is the default level and the per-module ones. It is safe for
+// concurrent use.
+type Levels struct {
+	base *slog.LevelVar
+
+	mu      sync.RWMutex
+	modules map[string]slog.Level
+	modMin  atomic.Int64 // lowest module level, to answer Enabled cheaply
+}
+
+// NewLevels uses base as the default level, so whatever already flips it
+// (SIGUSR2) keeps working; nil starts a new one at Info.
+func NewLevels(base *slog.LevelVar) *Levels {
+	if base == nil {
+		base = new(slog.LevelVar)
+	}
+	l := &Levels{base: base, modules: make(map[string]slog.Level)}
+	l.modMin.Store(int64(slog.LevelError + 1))
+	return l
+}
+
+// Default is the level of modules without their own.
+func (l *Levels) Default() *slog.LevelVar { return l.base }
+
+// Set gives module its own level; "" sets the default.
+func (l *Levels) Set(module string, level slog.Level) {
+	if module == "" {
+		l.base.Set(level)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.modules[module] = level
+	l.recompute()
+}
+
+// Unset returns module to the default level.
+func (l *Levels) Unset(module string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.modules, module)
+	l.recompute()
+}
+
+// Replace sets the default and drops every module level in favour of
+// modules.
+func (l *Levels) Replace(def slog.Level, modules map[string]slog.Level) {
+	l.base.Set(def)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.modules = maps.Clone(modules)
+	if l.modules == nil {
+		l.modules = make(map[string]slog.Level)
+	}
+	l.recompute()
+}
+
+func (l *Levels) recompute() {
+	lowest := slog.LevelError + 1
+	for _, lv := range l.modules {
+		lowest = min(lowest, lv)
+	}
+	l.modMin.Store(int64(lowest))
+}
+
+// Level is module's level.
+func (l *Levels) Level(module string) slog.Level {
+	l.mu.RLock()
+	lv, ok := l.modules[module]
+	l.mu.RUnlock()
+	if ok {
+		return lv
+	}
+	return l.base.Level()
+}
+
+// Modules returns the modules that have their own level.
+func (l *Levels) Modules() map[string]slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return maps.Clone(l.modules)
+}
+
+func (l *Levels) lowest() slog.Level {
+	return min(l.base.Level(), slog.Level(l.modMin.Load()))
+}
+
+// String is the levels as ParseLevels reads them.
+func (l *Levels) String() string {
+	parts := []string{l.base.Level().String()}
+	for _, m := range sortedKeys(l.Modules()) {
+		parts = append(parts, m+"="+l.Level(m).String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func sortedKeys(m map[string]slog.Level) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// ParseLevels reads "info,vacuum=debug,httpapi=warn": a default level,
+// which may be left out (keeping def), and module=level pairs. Levels
+// are slog's names, in any case, with offsets such as "info+2".
+func ParseLevels(spec string, def slog.Level) (slog.Level, map[string]slog.Level, error) {
+	modules := make(map[string]slog.Level)
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		module, name, ok := strings.Cut(item, "=")
+		if !ok {
+			module, name = "", item
+		}
+		var lv slog.Level
+		if err := lv.UnmarshalText([]byte(name)); err != nil {
+			return 0, nil, fmt.Errorf("logfilter: level %q: %w", item, err)
+		}
+		if module == "" {
+			def = lv
+		} else {
+			modules[module] = lv
+		}
+	}
+	return def, modules, nil
+}
+
+/*
+-----------------------------------
+HANDLER
+-----------------------------------
+*/
+
+// Sampling thins out repeated messages.
+type Sampling struct {
+	// First is how many records of a key are written per period; 0 turns
+	// sampling off.
+	First int
+	// Rate is the chance that a record past First is written anyway, so
+	// a flood still shows some of its attributes.
+	Rate float64
+	// Period defaults to one second.
+	Period time.Duration
+}
+
+// Options configures New. Levels is required.
+type Options struct {
+	Levels   *Levels
+	Sampling Sampling
+	Clock    clock.Clock
+}
+
+// maxKeys bounds the messages sampled at once. Past it new messages are
+// written unsampled until Flush drops the keys whose period is over.
+const maxKeys = 4096
+
+// Handler filters records on their way to the handler it wraps, which
+// should let every level through: deciding is this one's job.
+type Handler struct {
+	next   slog.Handler
+	module string // from WithAttrs
+	s      *state
+}
+
+// state is shared by a Handler and its WithAttrs and WithGroup children.
+type state struct {
+	opts Options
+	clk  clock.Clock
+
+	mu   sync.Mutex
+	keys map[sampleKey]*sampled
+
+	suppressed atomic.Int64
+}
+
+type sampleKey struct {
+	module string
+	level  slog.Level
+	msg    string
+}
+
+type sampled struct {
+	start      time.Time
+	seen       int
+	suppressed int
+	next       slog.Handler // where the summary goes
+}
+
+func New(next slog.Handler, opts Options) *Handler {
+	if opts.Sampling.Period <= 0 {
+		opts.Sampling.Period = time.Second
+	}
+	return &Handler{next: next, s: &state{opts: opts, clk: clock.OrReal(opts.Clock), keys: make(map[sampleKey]*sampled)}}
+}
+
+// Enabled is true if any module logs at level: the module is not known
+// until Handle sees the record.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.s.opts.Levels.lowest() && h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	module := h.module
+	if module == "" {
+		module = moduleOf(r)
+	}
+	if r.Level < h.s.opts.Levels.Level(module) {
+		return nil
+	}
+	if h.s.opts.Sampling.First > 0 {
+		write, summary := h.s.sample(sampleKey{module, r.Level, r.Message}, h.next)
+		if summary != nil {
+			summary()
+		}
+		if !write {
+			return nil
+		}
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.next = h.next.WithAttrs(attrs)
+	for _, a := range attrs {
+		if a.Key == "module" {
+			c.module = a.Value.String()
+		}
+	}
+	return &c
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.next = h.next.WithGroup(name)
+	return &c
+}
+
+// moduleOf is the record's "module" attribute or its message's
+// "module: " prefix.
+func moduleOf(r slog.Record) string {
+	var module string
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "module" {
+			module = a.Value.String()
+			return false
+		}
+		return true
+	})
+	if module != "" {
+		return module
+	}
+	prefix, _, ok := strings.Cut(r.Message, ": ")
+	if !ok || len(prefix) > 32 {
+		return ""
+	}
+	for _, c := range prefix {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.ContainsRune("._-/", c)) {
+			return ""
+		}
+	}
+	return prefix
+}
+
+// sample says whether a record of k is written, and returns the summary
+// of k's previous period if that has just ended.
+func (s *state) sample(k sampleKey, next slog.Handler) (bool, func()) {
+	now := s.clk.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var summary func()
+	e := s.keys[k]
+	if e != nil && now.Sub(e.start) >= s.opts.Sampling.Period {
+		summary = s.summary(k, e, now)
+		e = nil
+	}
+	if e == nil {
+		if len(s.keys) >= maxKeys {
+			delete(s.keys, k)
+			return true, summary
+		}
+		e = &sampled{start: now}
+		s.keys[k] = e
+	}
+	e.seen++
+	e.next = next
+	if e.seen <= s.opts.Sampling.First || rand.Float64() < s.opts.Sampling.Rate {
+		return true, summary
+	}
+	e.suppressed++
+	s.suppressed.Add(1)
+	return false, summary
+}
+
+// summary builds the record reporting e's suppressed count, to be
+// handled outside the lock; nil if nothing was suppressed.
+func (s *state) summary(k sampleKey, e *sampled, now time.Time) func() {
+	if e.suppressed == 0 {
+		return nil
+	}
+	r := slog.NewRecord(now, k.level, fmt.Sprintf("logfilter: %d similar messages suppressed", e.suppressed), 0)
+	r.AddAttrs(slog.String("message", k.msg), slog.Duration("period", now.Sub(e.start).Round(time.Millisecond)))
+	if k.module != "" {
+		r.AddAttrs(slog.String("module", k.module))
+	}
+	next := e.next
+	return func() { _ = next.Handle(context.Background(), r) }
+}
+
+// Flush writes the summaries of every period that has ended and forgets
+// those keys. Run calls it once a period; a message that stops repeating
+// would otherwise never report what was left out.
+func (h *Handler) Flush() {
+	now := h.s.clk.Now()
+	var summaries []func()
+	h.s.mu.Lock()
+	for k, e := range h.s.keys {
+		if now.Sub(e.start) < h.s.opts.Sampling.Period {
+			continue
+		}
+		if f := h.s.summary(k, e, now); f != nil {
+			summaries = append(summaries, f)
+		}
+		delete(h.s.keys, k)
+	}
+	h.s.mu.Unlock()
+	for _, f := range summaries {
+		f()
+	}
+}
+
+// Run flushes once a period until ctx is done, then a last time.
+func (h *Handler) Run(ctx context.Context) {
+	t := h.s.clk.NewTicker(h.s.opts.Sampling.Period)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			h.Flush()
+		case <-ctx.Done():
+			h.Flush()
+			return
+		}
+	}
+}
+
+// Stats counts what sampling left out.
+type Stats struct {
+	Suppressed int64 `json:"suppressed"`
+	Sampling   int   `json:"sampling"` // messages currently being counted
+}
+
+func (h *Handler) Stats() Stats {
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	return Stats{Suppressed: h.s.suppressed.Load(), Sampling: len(h.s.keys)}
+}
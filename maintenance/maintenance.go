@@ -0,0 +1,284 @@
+// Package maintenance is the server's maintenance switch. While it is
+// on, writes are answered 503 with Retry-After and reads go on as
+// usual, so a migration or a restore can run against data that is not
+// changing under it, without taking the service down.
+//
+// Switching it on does not cut off what is already running: Drain waits
+// for the writes admitted before it, and for the background jobs made
+// Pausable, which are cancelled and then started again once it is off.
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/clock"
+	"Go-Internals/ctxutil"
+)
+
+// ErrOn is what writes are refused with.
+var ErrOn = errors.New("maintenance: the service is in maintenance; writes are paused")
+
+// Options configures New.
+type Options struct {
+	// RetryAfter is what refused writes are told to wait, unless Enable
+	// says otherwise; default 1 minute.
+	RetryAfter time.Duration
+	// Audit records each switch. Default audit.Discard.
+	Audit audit.Sink
+	// Clock defaults to clock.Real().
+	Clock clock.Clock
+}
+
+// State is the switch's.
+type State struct {
+	On     bool      `json:"on"`
+	Reason string    `json:"reason,omitempty"`
+	By     string    `json:"by,omitempty"`
+	Since  time.Time `json:"since,omitzero"`
+	// RetryAfter is in seconds.
+	RetryAfter int `json:"retry_after,omitempty"`
+	// InFlight counts the writes and jobs still running; once it is 0
+	// with On, nothing is writing.
+	InFlight int `json:"in_flight"`
+	// Paused are the jobs waiting for the switch to go off.
+	Paused []string `json:"paused,omitempty"`
+}
+
+type Switch struct {
+	opts Options
+	clk  clock.Clock
+
+	mu    sync.Mutex
+	state State
+	// active counts InFlight; idle is closed while it is 0.
+	active int
+	idle   chan struct{}
+	// changed is closed, and replaced, on every switch.
+	changed chan struct{}
+	paused  map[string]bool
+}
+
+func New(opts Options) *Switch {
+	if opts.RetryAfter <= 0 {
+		opts.RetryAfter = time.Minute
+	}
+	if opts.Audit == nil {
+		opts.Audit = audit.Discard
+	}
+	idle := make(chan struct{})
+	close(idle)
+	return &Switch{opts: opts, clk: clock.OrReal(opts.Clock), idle: idle, changed: make(chan struct{}), paused: map[string]bool{}}
+}
+
+// Enable switches maintenance on, by whom and why, telling refused
+// writes to come back after retryAfter (default Options.RetryAfter).
+// Switching it on again updates the reason.
+func (s *Switch) Enable(ctx context.Context, by, reason string, retryAfter time.Duration) State {
+	if retryAfter <= 0 {
+		retryAfter = s.opts.RetryAfter
+	}
+	s.mu.Lock()
+	was := s.state.On
+	s.state = State{On: true, Reason: reason, By: by, Since: s.clk.Now().UTC(), RetryAfter: int(retryAfter / time.Second)}
+	if !was {
+		s.broadcastLocked()
+	}
+	st := s.stateLocked()
+	s.mu.Unlock()
+	_ = s.opts.Audit.Record(ctx, audit.Entry{
+		Actor:    ctxutil.UserID(ctx),
+		Action:   "maintenance.enabled",
+		Resource: "maintenance",
+		Meta:     map[string]string{"reason": reason, "retry_after": strconv.Itoa(st.RetryAfter)},
+	})
+	return st
+}
+
+// Disable switches maintenance off: writes are let through again and
+// the paused jobs start.
+func (s *Switch) Disable(ctx context.Context) State {
+	s.mu.Lock()
+	was := s.state.On
+	s.state = State{}
+	if was {
+		s.broadcastLocked()
+	}
+	st := s.stateLocked()
+	s.mu.Unlock()
+	if was {
+		_ = s.opts.Audit.Record(ctx, audit.Entry{
+			Actor:    ctxutil.UserID(ctx),
+			Action:   "maintenance.disabled",
+			Resource: "maintenance",
+		})
+	}
+	return st
+}
+
+func (s *Switch) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stateLocked()
+}
+
+// Drain waits until nothing admitted is still writing, or for ctx. Call
+// it after Enable.
+func (s *Switch) Drain(ctx context.Context) error {
+	s.mu.Lock()
+	idle := s.idle
+	s.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Switch) stateLocked() State {
+	st := s.state
+	st.InFlight = s.active
+	for name, p := range s.paused {
+		if p {
+			st.Paused = append(st.Paused, name)
+		}
+	}
+	slices.Sort(st.Paused)
+	return st
+}
+
+func (s *Switch) broadcastLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// admit counts a write in unless maintenance is on.
+func (s *Switch) admit() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state.On {
+		return false
+	}
+	s.beginLocked()
+	return true
+}
+
+func (s *Switch) beginLocked() {
+	if s.active == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.active++
+}
+
+func (s *Switch) done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active--; s.active == 0 {
+		close(s.idle)
+	}
+}
+
+/*
+-----------------------------------
+HTTP
+-----------------------------------
+*/
+
+// Middleware refuses writes with 503 while maintenance is on, and
+// counts those it lets through for Drain. GET, HEAD and OPTIONS are
+// reads. exempt, if not nil, lets writes through that it reports true
+// for: the switch's own endpoint, say, or signing in.
+func (s *Switch) Middleware(exempt func(r *http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions:
+				next.ServeHTTP(w, r)
+				return
+			case exempt != nil && exempt(r):
+				// Not counted, either: the switch's own endpoint drains.
+				next.ServeHTTP(w, r)
+				return
+			case !s.admit():
+				st := s.State()
+				w.Header().Set("Retry-After", strconv.Itoa(st.RetryAfter))
+				msg := ErrOn.Error()
+				if st.Reason != "" {
+					msg += ": " + st.Reason
+				}
+				http.Error(w, msg, http.StatusServiceUnavailable)
+				return
+			}
+			defer s.done()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+/*
+-----------------------------------
+BACKGROUND JOBS
+-----------------------------------
+*/
+
+// Pausable wraps a job that runs until ctx is done (a runmode.Subsystem)
+// so it is paused while maintenance is on: its ctx is cancelled, Drain
+// waits for it to return, and it is run again once maintenance is off.
+// A job that returns for any other reason returns its error, for the
+// supervisor to restart it.
+func (s *Switch) Pausable(name string, run func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		for {
+			s.mu.Lock()
+			on, changed := s.state.On, s.changed
+			s.paused[name] = on
+			if !on {
+				s.beginLocked()
+			}
+			s.mu.Unlock()
+			if on {
+				select {
+				case <-ctx.Done():
+					s.setPaused(name, false)
+					return nil
+				case <-changed:
+					continue
+				}
+			}
+
+			jobCtx, cancel := context.WithCancel(ctx)
+			go func() {
+				select {
+				case <-changed:
+					cancel()
+				case <-jobCtx.Done():
+				}
+			}()
+			err := run(jobCtx)
+			cancel()
+			s.done()
+			if ctx.Err() != nil {
+				return err
+			}
+			select {
+			case <-changed:
+				ctxutil.Logger(ctx).Info("maintenance: job paused", "job", name)
+			default:
+				return err
+			}
+		}
+	}
+}
+
+func (s *Switch) setPaused(name string, p bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused[name] = p
+}
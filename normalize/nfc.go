@@ -0,0 +1,163 @@
+package normalize
+
+import (
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+-----------------------------------
+NFC
+-----------------------------------
+*/
+
+// NFC returns s in Unicode Normalization Form C, so that "é" typed as e
+// and a combining acute is the same string as "é" typed as one letter.
+// The composition tables cover the Latin and Cyrillic letters with the
+// combining marks U+0300 to U+036F (Latin-1, Latin Extended-A and B, the
+// Vietnamese letters of Latin Extended Additional, Cyrillic); outside
+// them text passes through unchanged. A string without a combining mark
+// is returned as is, without allocating.
+func NFC(s string) string {
+	if strings.IndexFunc(s, isMark) < 0 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	var marks []rune
+	starter, started := rune(0), false
+	for _, r := range s {
+		if isMark(r) && started {
+			marks = append(marks, r)
+			continue
+		}
+		if started {
+			writeComposed(&b, starter, marks)
+		}
+		if isMark(r) {
+			b.WriteRune(r) // a mark with nothing before it to go on
+			started = false
+			continue
+		}
+		starter, started, marks = r, true, marks[:0]
+	}
+	if started {
+		writeComposed(&b, starter, marks)
+	}
+	return b.String()
+}
+
+// writeComposed writes starter and the marks after it composed: the
+// starter decomposed, all the marks put in canonical order, then each
+// composed into the starter unless an earlier mark of the same or a
+// higher class, left over, blocks it.
+func writeComposed(b *strings.Builder, starter rune, marks []rune) {
+	if len(marks) == 0 {
+		b.WriteRune(starter)
+		return
+	}
+	var all []rune
+	for {
+		d, ok := decompositions[starter]
+		if !ok {
+			break
+		}
+		starter, all = d[0], append(all, d[1])
+	}
+	slices.Reverse(all)
+	all = append(all, marks...)
+	slices.SortStableFunc(all, func(a, b rune) int { return int(combiningClass(a)) - int(combiningClass(b)) })
+
+	var left []rune
+	blocked := uint8(0)
+	for _, m := range all {
+		c := combiningClass(m)
+		if composed, ok := compositions[[2]rune{starter, m}]; ok && (len(left) == 0 || blocked < c) {
+			starter = composed
+			continue
+		}
+		left, blocked = append(left, m), c
+	}
+	b.WriteRune(starter)
+	for _, m := range left {
+		b.WriteRune(m)
+	}
+}
+
+// isMark reports whether r is a combining mark of U+0300 to U+036F.
+// U+034F, the grapheme joiner, is of class 0, a starter.
+func isMark(r rune) bool { return r >= 0x0300 && r <= 0x036F && r != 0x034F }
+
+// combiningClass is the canonical combining class of a mark in U+0300 to
+// U+036F, from UnicodeData.txt.
+func combiningClass(r rune) uint8 {
+	switch {
+	case r <= 0x0314, r >= 0x033D && r <= 0x0344, r == 0x0346, r >= 0x034A && r <= 0x034C,
+		r >= 0x0350 && r <= 0x0352, r == 0x0357, r == 0x035B, r >= 0x0363:
+		return 230
+	case r == 0x0315, r == 0x031A, r == 0x0358:
+		return 232
+	case r == 0x031B:
+		return 216
+	case r == 0x0321, r == 0x0322, r == 0x0327, r == 0x0328:
+		return 202
+	case r >= 0x0334 && r <= 0x0338:
+		return 1
+	case r == 0x0345:
+		return 240
+	case r == 0x035C, r == 0x035F, r == 0x0362:
+		return 233
+	case r == 0x035D, r == 0x035E, r == 0x0360, r == 0x0361:
+		return 234
+	default:
+		return 220
+	}
+}
+
+// composable lists, for each combining mark, the letters it composes with:
+// pairs of the letter and what it becomes.
+var composable = map[rune]string{
+	0x0300: "AÀEÈIÌOÒUÙaàeèiìoòuùÜǛüǜNǸnǹЕЀИЍеѐиѝĒḔēḕŌṐōṑWẀwẁÂẦâầĂẰăằÊỀêềÔỒôồƠỜơờƯỪưừYỲyỳ",                                                                 // grave accent
+	0x0301: "AÁEÉIÍOÓUÚYÝaáeéiíoóuúyýCĆcćLĹlĺNŃnńRŔrŕSŚsśZŹzźÜǗüǘGǴgǵÅǺåǻÆǼæǽØǾøǿГЃКЌгѓкќÇḈçḉĒḖēḗÏḮïḯKḰkḱMḾmḿÕṌõṍŌṒōṓPṔpṕŨṸũṹWẂwẃÂẤâấĂẮăắÊẾêếÔỐôốƠỚơớƯỨưứ", // acute accent
+	0x0302: "AÂEÊIÎOÔUÛaâeêiîoôuûCĈcĉGĜgĝHĤhĥJĴjĵSŜsŝWŴwŵYŶyŷZẐzẑẠẬạậẸỆẹệỌỘọộ",                                                                             // circumflex accent
+	0x0303: "AÃNÑOÕaãnñoõIĨiĩUŨuũVṼvṽÂẪâẫĂẴăẵEẼeẽÊỄêễÔỖôỗƠỠơỡƯỮưữYỸyỹ",                                                                                     // tilde
+	0x0304: "AĀaāEĒeēIĪiīOŌoōUŪuūÜǕüǖÄǞäǟȦǠȧǡÆǢæǣǪǬǫǭÖȪöȫÕȬõȭȮȰȯȱYȲyȳИӢиӣУӮуӯGḠgḡḶḸḷḹṚṜṛṝ",                                                                 // macron
+	0x0306: "AĂaăEĔeĕGĞgğIĬiĭOŎoŏUŬuŭУЎИЙийуўЖӁжӂАӐаӑЕӖеӗȨḜȩḝẠẶạặ",                                                                                         // breve
+	0x0307: "CĊcċEĖeėGĠgġIİZŻzżAȦaȧOȮoȯBḂbḃDḊdḋFḞfḟHḢhḣMṀmṁNṄnṅPṖpṗRṘrṙSṠsṡŚṤśṥŠṦšṧṢṨṣṩTṪtṫWẆwẇXẊxẋYẎyẏſẛ",                                                 // dot above
+	0x0308: "AÄEËIÏOÖUÜaäeëiïoöuüyÿYŸЕЁІЇеёіїАӒаӓӘӚәӛЖӜжӝЗӞзӟИӤиӥОӦоӧӨӪөӫЭӬэӭУӰуӱЧӴчӵЫӸыӹHḦhḧÕṎõṏŪṺūṻWẄwẅXẌxẍtẗ",                                           // diaeresis
+	0x0309: "AẢaảÂẨâẩĂẲăẳEẺeẻÊỂêểIỈiỉOỎoỏÔỔôổƠỞơởUỦuủƯỬưửYỶyỷ",                                                                                             // hook above
+	0x030A: "AÅaåUŮuůwẘyẙ",                                                                                                                                 // ring above
+	0x030B: "OŐoőUŰuűУӲуӳ",                                                                                                                                 // double acute accent
+	0x030C: "CČcčDĎdďEĚeěLĽlľNŇnňRŘrřSŠsšTŤtťZŽzžAǍaǎIǏiǐOǑoǒUǓuǔÜǙüǚGǦgǧKǨkǩƷǮʒǯjǰHȞhȟ",                                                                   // caron
+	0x030F: "AȀaȁEȄeȅIȈiȉOȌoȍRȐrȑUȔuȕѴѶѵѷ",                                                                                                                 // double grave accent
+	0x0311: "AȂaȃEȆeȇIȊiȋOȎoȏRȒrȓUȖuȗ",                                                                                                                     // inverted breve
+	0x031B: "OƠoơUƯuư",                                                                                                                                     // horn
+	0x0323: "BḄbḅDḌdḍHḤhḥKḲkḳLḶlḷMṂmṃNṆnṇRṚrṛSṢsṣTṬtṭVṾvṿWẈwẉZẒzẓAẠaạEẸeẹIỊiịOỌoọƠỢơợUỤuụƯỰưựYỴyỵ",                                                         // dot below
+	0x0324: "UṲuṳ",                                                                                                                                         // diaeresis below
+	0x0325: "AḀaḁ",                                                                                                                                         // ring below
+	0x0326: "SȘsșTȚtț",                                                                                                                                     // comma below
+	0x0327: "CÇcçGĢgģKĶkķLĻlļNŅnņRŖrŗSŞsşTŢtţEȨeȩDḐdḑHḨhḩ",                                                                                                 // cedilla
+	0x0328: "AĄaąEĘeęIĮiįUŲuųOǪoǫ",                                                                                                                         // ogonek
+	0x032D: "DḒdḓEḘeḙLḼlḽNṊnṋTṰtṱUṶuṷ",                                                                                                                     // circumflex accent below
+	0x032E: "HḪhḫ",                                                                                                                                         // breve below
+	0x0330: "EḚeḛIḬiḭUṴuṵ",                                                                                                                                 // tilde below
+	0x0331: "BḆbḇDḎdḏKḴkḵLḺlḻNṈnṉRṞrṟTṮtṯZẔzẕhẖ",                                                                                                           // macron below
+}
+
+var (
+	compositions   = make(map[[2]rune]rune)
+	decompositions = make(map[rune][2]rune)
+)
+
+func init() {
+	for m, pairs := range composable {
+		for pairs != "" {
+			base, n := utf8.DecodeRuneInString(pairs)
+			composed, k := utf8.DecodeRuneInString(pairs[n:])
+			pairs = pairs[n+k:]
+			compositions[[2]rune{base, m}] = composed
+			decompositions[composed] = [2]rune{base, m}
+		}
+	}
+}
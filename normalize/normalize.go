@@ -0,0 +1,100 @@
+// Package normalize puts emails and names in one canonical form, so that
+// two ways of typing the same thing are stored, indexed and compared as
+// the same string.
+//
+// Email and Name are the forms the service stores: trimmed, in NFC and,
+// for an email, lowercased. Options adds the folds a deployment may want
+// and another may not: one mailbox's addresses folded into one, names
+// typed all in one case capitalized. EmailKey is what a unique email
+// index compares; it does not depend on the options, so an index built
+// with one set keeps working under another.
+//
+// NFC here is not the whole of Unicode's: it composes Latin and Cyrillic
+// letters only (see NFC). Text in other scripts is kept as typed, so a
+// Greek, Hangul or Devanagari name typed with combining marks and the
+// same name typed precomposed are two strings, and two emails.
+package normalize
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Options are the optional folds. The zero Options only trims, composes
+// and lowercases.
+type Options struct {
+	// FoldMailbox stores an email as its mailbox (see Mailbox), so
+	// "Ada.L+news@gmail.com" and "adal@gmail.com" are one address and the
+	// second registration is refused as taken.
+	FoldMailbox bool
+	// CaseNames capitalizes the words of a name typed all in one case
+	// (see Title); a name with mixed case is taken to be meant.
+	CaseNames bool
+}
+
+// Email is email as o stores it.
+func (o Options) Email(email string) string {
+	if o.FoldMailbox {
+		return Mailbox(email)
+	}
+	return EmailKey(email)
+}
+
+// Name is name as o stores it.
+func (o Options) Name(name string) string {
+	if o.CaseNames {
+		return Title(name)
+	}
+	return Name(name)
+}
+
+// EmailKey is the form two spellings of one address share: trimmed of
+// spaces, in NFC and lowercased. Lowercasing the local part is not what
+// RFC 5321 allows, but no mail provider users meet tells cases apart.
+func EmailKey(email string) string {
+	return strings.ToLower(NFC(strings.TrimSpace(email)))
+}
+
+// Mailbox is the form the addresses of one mailbox share: EmailKey
+// without a +tag and, for Gmail, without the dots it ignores in the
+// local part.
+func Mailbox(email string) string {
+	email = EmailKey(email)
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return email
+	}
+	local, _, _ = strings.Cut(local, "+")
+	if domain == "gmail.com" || domain == "googlemail.com" {
+		local, domain = strings.ReplaceAll(local, ".", ""), "gmail.com"
+	}
+	return local + "@" + domain
+}
+
+// Name is name trimmed, with each run of whitespace inside it one space,
+// in NFC.
+func Name(name string) string {
+	return strings.Join(strings.Fields(NFC(name)), " ")
+}
+
+// Title is Name with, if the name was typed all in one case, each word
+// and each part of a hyphenated word capitalized: "ada  lovelace" becomes
+// "Ada Lovelace" and "JEAN-LUC" "Jean-Luc", but "ada LOVELACE" and
+// "Ludwig van Beethoven" only lose extra spaces.
+func Title(name string) string {
+	name = Name(name)
+	if name != strings.ToLower(name) && name != strings.ToUpper(name) {
+		return name
+	}
+	var b strings.Builder
+	b.Grow(len(name))
+	start := true
+	for _, r := range strings.ToLower(name) {
+		if start && unicode.IsLetter(r) {
+			r = unicode.ToTitle(r)
+		}
+		start = r == ' ' || r == '-'
+		b.WriteRune(r)
+	}
+	return b.String()
+}
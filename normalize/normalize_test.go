@@ -0,0 +1,92 @@
+package normalize
+
+import "testing"
+
+func TestNFC(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"ascii", "Ada Lovelace", "Ada Lovelace"},
+		{"precomposed", "José", "José"},
+		{"e and acute", "José", "José"},
+		{"cyrillic", "й", "й"},
+		{"two marks, canonical order", "ậ", "ậ"},
+		{"two marks, reordered", "ậ", "ậ"},
+		{"decomposed then composed", "ậ", "ậ"},
+		{"mark without a composition", "q́", "q́"},
+		{"blocked by a mark of the same class", "á́", "á́"},
+		{"leading mark", "́a", "́a"},
+		// Greek is not in the tables: it stays decomposed.
+		{"greek", "ά", "ά"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NFC(tt.in); got != tt.want {
+				t.Errorf("NFC(%+q) = %+q, want %+q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmail(t *testing.T) {
+	tests := []struct {
+		in, key, mailbox string
+	}{
+		{in: " Ada@Example.COM ", key: "ada@example.com", mailbox: "ada@example.com"},
+		{in: "ada+news@example.com", key: "ada+news@example.com", mailbox: "ada@example.com"},
+		{in: "Ada.L+news@gmail.com", key: "ada.l+news@gmail.com", mailbox: "adal@gmail.com"},
+		{in: "a.d.a@googlemail.com", key: "a.d.a@googlemail.com", mailbox: "ada@gmail.com"},
+		// Only Gmail ignores dots.
+		{in: "a.d.a@example.com", key: "a.d.a@example.com", mailbox: "a.d.a@example.com"},
+		{in: "José@example.com", key: "josé@example.com", mailbox: "josé@example.com"},
+		{in: "not an email", key: "not an email", mailbox: "not an email"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := EmailKey(tt.in); got != tt.key {
+				t.Errorf("EmailKey = %q, want %q", got, tt.key)
+			}
+			if got := Mailbox(tt.in); got != tt.mailbox {
+				t.Errorf("Mailbox = %q, want %q", got, tt.mailbox)
+			}
+			if got := (Options{}).Email(tt.in); got != tt.key {
+				t.Errorf("Options{}.Email = %q, want %q", got, tt.key)
+			}
+			if got := (Options{FoldMailbox: true}).Email(tt.in); got != tt.mailbox {
+				t.Errorf("FoldMailbox Email = %q, want %q", got, tt.mailbox)
+			}
+		})
+	}
+}
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		in, name, title string
+	}{
+		{"  Ada   Lovelace ", "Ada Lovelace", "Ada Lovelace"},
+		{"ada  lovelace", "ada lovelace", "Ada Lovelace"},
+		{"JEAN-LUC PICARD", "JEAN-LUC PICARD", "Jean-Luc Picard"},
+		// Mixed case is taken to be meant.
+		{"ada LOVELACE", "ada LOVELACE", "ada LOVELACE"},
+		{"Ludwig van Beethoven", "Ludwig van Beethoven", "Ludwig van Beethoven"},
+		{"josé\tgarcía", "josé garcía", "José García"},
+		{"ивáн", "ивáн", "Ивáн"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := Name(tt.in); got != tt.name {
+				t.Errorf("Name = %q, want %q", got, tt.name)
+			}
+			if got := Title(tt.in); got != tt.title {
+				t.Errorf("Title = %q, want %q", got, tt.title)
+			}
+			if got := (Options{}).Name(tt.in); got != tt.name {
+				t.Errorf("Options{}.Name = %q, want %q", got, tt.name)
+			}
+			if got := (Options{CaseNames: true}).Name(tt.in); got != tt.title {
+				t.Errorf("CaseNames Name = %q, want %q", got, tt.title)
+			}
+		})
+	}
+}
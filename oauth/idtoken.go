@@ -0,0 +1,248 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+-----------------------------------
+ID TOKENS
+-----------------------------------
+*/
+
+// leeway is how far apart this service's and the provider's clocks may
+// be.
+const leeway = time.Minute
+
+// jwksRefetch is how soon after fetching the provider's keys a token
+// with a key ID not among them may fetch them again, so forged tokens
+// cannot make every request a fetch.
+const jwksRefetch = time.Minute
+
+var errNoKey = errors.New("oauth: no such signing key")
+
+// idClaims is what the flow reads from an ID token.
+type idClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     flexBool `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// audience is aud, which is a string or an array of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if json.Unmarshal(b, &one) == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// flexBool is a bool some providers send as "true".
+type flexBool bool
+
+func (f *flexBool) UnmarshalJSON(b []byte) error {
+	switch strings.Trim(string(b), `"`) {
+	case "true":
+		*f = true
+	case "false", "null", "":
+		*f = false
+	default:
+		return fmt.Errorf("oauth: %s is not a bool", b)
+	}
+	return nil
+}
+
+// verifyIDToken checks raw's signature with the provider's published
+// keys, and that it is the provider's, for this client, current, and
+// answers the sign-in nonce was made for (OpenID Connect Core §3.1.3.7).
+func (p *provider) verifyIDToken(ctx context.Context, m *metadata, raw, nonce string, now time.Time) (idClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return idClaims{}, fmt.Errorf("%w: malformed", ErrInvalidIDToken)
+	}
+	var hdr struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(b, &hdr) != nil {
+		return idClaims{}, fmt.Errorf("%w: malformed header", ErrInvalidIDToken)
+	}
+	// Only asymmetric algorithms: "none", and HS256 keyed with a public
+	// key, are the classic forgeries.
+	if hdr.Alg != "RS256" && hdr.Alg != "ES256" {
+		return idClaims{}, fmt.Errorf("%w: algorithm %q not accepted", ErrInvalidIDToken, hdr.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return idClaims{}, fmt.Errorf("%w: malformed signature", ErrInvalidIDToken)
+	}
+	key, err := p.key(ctx, m, hdr.Kid, hdr.Alg, now)
+	if err != nil {
+		return idClaims{}, err
+	}
+	if !verifySignature(hdr.Alg, key, parts[0]+"."+parts[1], sig) {
+		return idClaims{}, fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+	}
+
+	var c idClaims
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(b, &c) != nil {
+		return idClaims{}, fmt.Errorf("%w: malformed claims", ErrInvalidIDToken)
+	}
+	switch {
+	case c.Issuer != p.cfg.Issuer:
+		return idClaims{}, fmt.Errorf("%w: issuer %q", ErrInvalidIDToken, c.Issuer)
+	case !slices.Contains(c.Audience, p.cfg.ClientID):
+		return idClaims{}, fmt.Errorf("%w: not issued to this client", ErrInvalidIDToken)
+	case len(c.Audience) > 1 && c.AuthorizedParty != p.cfg.ClientID:
+		return idClaims{}, fmt.Errorf("%w: issued for another party", ErrInvalidIDToken)
+	case c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(leeway)):
+		return idClaims{}, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case time.Unix(c.IssuedAt, 0).After(now.Add(leeway)):
+		return idClaims{}, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	case c.Nonce != nonce:
+		return idClaims{}, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	case c.Subject == "":
+		return idClaims{}, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	return c, nil
+}
+
+func verifySignature(alg string, key any, signing string, sig []byte) bool {
+	sum := sha256.Sum256([]byte(signing))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig) == nil
+	case *ecdsa.PublicKey:
+		// JWS §3.4: R and S, each 32 bytes, not ASN.1.
+		if alg != "ES256" || len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, sum[:], r, s)
+	}
+	return false
+}
+
+// key is the provider's key kid names, suited to alg. Keys rotate, so
+// one not seen sends for the set again, at most every jwksRefetch.
+func (p *provider) key(ctx context.Context, m *metadata, kid, alg string, now time.Time) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k := pick(p.keys, kid, alg); k != nil {
+		return k, nil
+	}
+	if !p.keysFetch.IsZero() && now.Sub(p.keysFetch) < jwksRefetch {
+		return nil, fmt.Errorf("%w: %w %q", ErrInvalidIDToken, errNoKey, kid)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, m.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		// Keys of kinds not accepted, or malformed, are skipped: the
+		// set may hold others for other clients.
+		if k, err := j.public(); err == nil {
+			keys[j.Kid] = k
+		}
+	}
+	p.keys, p.keysFetch = keys, now
+	if k := pick(keys, kid, alg); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: %w %q", ErrInvalidIDToken, errNoKey, kid)
+}
+
+// pick is keys' kid, or, for a token naming none, the one key of alg's
+// kind if there is only one.
+func pick(keys map[string]any, kid, alg string) any {
+	if kid != "" {
+		return keys[kid]
+	}
+	var found any
+	for _, k := range keys {
+		_, rsaKey := k.(*rsa.PublicKey)
+		if rsaKey != (alg == "RS256") {
+			continue
+		}
+		if found != nil {
+			return nil
+		}
+		found = k
+	}
+	return found
+}
+
+// jwk is a JSON Web Key (RFC 7517), RSA or P-256 public.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j jwk) public() (any, error) {
+	dec := base64.RawURLEncoding.DecodeString
+	switch j.Kty {
+	case "RSA":
+		n, err := dec(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := dec(j.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("oauth: bad RSA exponent")
+		}
+		k := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("oauth: RSA key too short")
+		}
+		return k, nil
+	case "EC":
+		if j.Crv != "P-256" {
+			return nil, errors.New("oauth: curve not supported")
+		}
+		x, err1 := dec(j.X)
+		y, err2 := dec(j.Y)
+		if err1 != nil || err2 != nil || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("oauth: bad EC point")
+		}
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+	}
+	return nil, errors.New("oauth: key type not supported")
+}
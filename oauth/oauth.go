@@ -0,0 +1,730 @@
+// Package oauth signs users in with external OpenID Connect providers:
+// the authorization-code flow with PKCE, the provider's identity mapped
+// to a local user, and the provider's tokens kept and refreshed.
+//
+// A sign-in is two requests. Begin makes the state, nonce and PKCE
+// verifier and returns the provider URL to send the user agent to; the
+// provider sends it back to the redirect URL with a code, and Complete
+// trades the code for tokens, verifies the ID token and finds the user
+// the identity is linked to, linking it to a new user when it is not.
+// A user already signed in links another provider the same way, from
+// BeginLink; Unlink undoes it.
+//
+// Links are JSON blobs in a blobstore.Store, with the provider's access
+// and refresh tokens sealed when the Manager has a fieldcrypt.Codec.
+// AccessToken hands out a current access token for calling the
+// provider's APIs, refreshing it first when it has expired.
+//
+// The Manager is a privacy.Holder and a dedupe.Reassigner.
+package oauth
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/blobstore"
+	"Go-Internals/clock"
+	"Go-Internals/ctxutil"
+	"Go-Internals/eventbus"
+	"Go-Internals/fieldcrypt"
+	"Go-Internals/query"
+	"Go-Internals/users"
+)
+
+var (
+	ErrUnknownProvider = errors.New("oauth: unknown provider")
+	ErrBadState        = errors.New("oauth: unknown or expired sign-in state")
+	ErrProvider        = errors.New("oauth: provider error")
+	ErrInvalidIDToken  = errors.New("oauth: invalid ID token")
+	ErrNotLinked       = errors.New("oauth: provider not linked")
+	// ErrLinkedElsewhere is linking an identity that is another user's.
+	ErrLinkedElsewhere = errors.New("oauth: identity is linked to another user")
+	// ErrAlreadyLinked is linking a second identity of one provider.
+	ErrAlreadyLinked = errors.New("oauth: user already has this provider linked")
+	// ErrNoEmail is a new identity whose provider shares no email, which
+	// a local user needs.
+	ErrNoEmail = errors.New("oauth: provider shared no email address")
+	// ErrNoRefresh is an expired access token with no refresh token.
+	ErrNoRefresh = errors.New("oauth: access token expired and cannot be refreshed")
+)
+
+// Options configures New.
+type Options struct {
+	Providers []ProviderConfig
+	// Service finds and registers the users identities map to; required.
+	Service *users.UserService
+	// Store keeps the links; required.
+	Store blobstore.Store
+	// LinkByEmail links a new identity to the user with its email, if
+	// the provider says it verified it. Off, such a sign-in is refused
+	// with users.ErrEmailTaken and the user links the provider
+	// themselves: with a provider that verifies carelessly, linking by
+	// email hands the account to whoever registered the address there.
+	LinkByEmail bool
+	// StateTTL is how long a sign-in may take at the provider; default
+	// 10 minutes.
+	StateTTL time.Duration
+	// MaxPending bounds sign-ins in progress, which anyone may start;
+	// beyond it the oldest are dropped. Default 10000.
+	MaxPending int
+	// SessionTTL is how long the tokens a sign-in issues last; default
+	// 12 hours. The Manager does not issue them, its caller does.
+	SessionTTL time.Duration
+	// HTTPClient talks to the providers; default one with a 10-second
+	// timeout.
+	HTTPClient *http.Client
+	// Codec, if set, seals the provider's tokens at rest.
+	Codec *fieldcrypt.Codec
+	// Audit records oauth.login, oauth.linked and oauth.unlinked. Default
+	// audit.Discard.
+	Audit audit.Sink
+	// Clock defaults to clock.Real().
+	Clock clock.Clock
+}
+
+// Identity is a provider's account linked to a local user.
+type Identity struct {
+	Provider string `json:"provider"`
+	// Subject is the provider's ID for the account, its ID tokens' sub.
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email,omitempty"`
+	LinkedAt  time.Time `json:"linked_at"`
+	LastLogin time.Time `json:"last_login,omitzero"`
+}
+
+// Result is a completed sign-in.
+type Result struct {
+	User     users.User `json:"user"`
+	Identity Identity   `json:"identity"`
+	// Created is a user registered for the identity; Linked, an identity
+	// linked by this sign-in.
+	Created bool `json:"created"`
+	Linked  bool `json:"linked"`
+	// LinkOnly is a sign-in BeginLink started: the caller was signed in
+	// already and needs no new session.
+	LinkOnly bool `json:"link_only"`
+}
+
+// Token is a provider access token.
+type Token struct {
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`
+}
+
+// pending is a sign-in sent to the provider and not back yet.
+type pending struct {
+	provider string
+	verifier string
+	nonce    string
+	linkTo   int // 0 for a sign-in
+	expires  time.Time
+}
+
+type Manager struct {
+	opts      Options
+	providers map[string]*provider
+
+	pmu     sync.Mutex
+	pending map[string]pending // by state
+
+	// mu serializes changes to links, refreshes included: a provider
+	// that rotates refresh tokens revokes the one two refreshes would
+	// both use.
+	mu sync.Mutex
+}
+
+// New checks the providers' configuration; it does not contact them
+// until a sign-in needs to.
+func New(opts Options) (*Manager, error) {
+	if opts.StateTTL <= 0 {
+		opts.StateTTL = 10 * time.Minute
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = 10000
+	}
+	if opts.SessionTTL <= 0 {
+		opts.SessionTTL = 12 * time.Hour
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.Audit == nil {
+		opts.Audit = audit.Discard
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	m := &Manager{opts: opts, providers: make(map[string]*provider), pending: make(map[string]pending)}
+	for _, cfg := range opts.Providers {
+		if err := cfg.check(); err != nil {
+			return nil, err
+		}
+		if _, dup := m.providers[cfg.Name]; dup {
+			return nil, fmt.Errorf("oauth: provider %s configured twice", cfg.Name)
+		}
+		m.providers[cfg.Name] = &provider{cfg: cfg, client: opts.HTTPClient}
+	}
+	return m, nil
+}
+
+// Providers lists the configured providers' names, sorted.
+func (m *Manager) Providers() []string {
+	names := make([]string, 0, len(m.providers))
+	for name := range m.providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// SessionTTL is how long the tokens a sign-in issues should last.
+func (m *Manager) SessionTTL() time.Duration { return m.opts.SessionTTL }
+
+/*
+-----------------------------------
+THE FLOW
+-----------------------------------
+*/
+
+// Begin starts a sign-in with provider and returns the URL to redirect
+// the user agent to.
+func (m *Manager) Begin(ctx context.Context, provider string) (string, error) {
+	return m.begin(ctx, provider, 0)
+}
+
+// BeginLink starts linking provider to user id, who is signed in; the
+// sign-in completes as Begin's does.
+func (m *Manager) BeginLink(ctx context.Context, provider string, id int) (string, error) {
+	if _, err := m.opts.Service.GetUser(ctx, id); err != nil {
+		return "", err
+	}
+	links, err := m.load(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if slices.ContainsFunc(links, func(l link) bool { return l.Provider == provider }) {
+		return "", ErrAlreadyLinked
+	}
+	return m.begin(ctx, provider, id)
+}
+
+func (m *Manager) begin(ctx context.Context, name string, linkTo int) (string, error) {
+	p, ok := m.providers[name]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+	now := m.opts.Clock.Now()
+	meta, err := p.discover(ctx, now)
+	if err != nil {
+		return "", err
+	}
+	state := randomString(32)
+	// 32 bytes make a 43-character verifier, RFC 7636's shortest.
+	pd := pending{provider: name, verifier: randomString(32), nonce: randomString(16), linkTo: linkTo, expires: now.Add(m.opts.StateTTL)}
+	m.pmu.Lock()
+	m.prune(now)
+	m.pending[state] = pd
+	m.pmu.Unlock()
+	return p.authURL(meta, state, pd.nonce, pd.verifier), nil
+}
+
+// prune drops expired sign-ins and, while there are too many, the
+// oldest. m.pmu must be held.
+func (m *Manager) prune(now time.Time) {
+	for state, pd := range m.pending {
+		if now.After(pd.expires) {
+			delete(m.pending, state)
+		}
+	}
+	for len(m.pending) >= m.opts.MaxPending {
+		oldest := ""
+		for state, pd := range m.pending {
+			if oldest == "" || pd.expires.Before(m.pending[oldest].expires) {
+				oldest = state
+			}
+		}
+		delete(m.pending, oldest)
+	}
+}
+
+// take removes and returns state's sign-in: a state is good once.
+func (m *Manager) take(state, provider string) (pending, error) {
+	m.pmu.Lock()
+	defer m.pmu.Unlock()
+	pd, ok := m.pending[state]
+	if !ok {
+		return pending{}, ErrBadState
+	}
+	delete(m.pending, state)
+	if pd.provider != provider || m.opts.Clock.Now().After(pd.expires) {
+		return pending{}, ErrBadState
+	}
+	return pd, nil
+}
+
+// Complete finishes the sign-in state names with the code provider sent
+// back, and returns the user it is for.
+func (m *Manager) Complete(ctx context.Context, provider, state, code string) (Result, error) {
+	pd, err := m.take(state, provider)
+	if err != nil {
+		return Result{}, err
+	}
+	p := m.providers[provider]
+	now := m.opts.Clock.Now()
+	meta, err := p.discover(ctx, now)
+	if err != nil {
+		return Result{}, err
+	}
+	t, err := p.exchange(ctx, meta, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {pd.verifier},
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	if t.IDToken == "" {
+		return Result{}, fmt.Errorf("%w: none in the token response", ErrInvalidIDToken)
+	}
+	c, err := p.verifyIDToken(ctx, meta, t.IDToken, pd.nonce, now)
+	if err != nil {
+		return Result{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res, err := m.resolve(ctx, provider, c, pd.linkTo)
+	if err != nil {
+		return Result{}, err
+	}
+	links, err := m.load(ctx, res.User.ID)
+	if err != nil {
+		return Result{}, err
+	}
+	i := slices.IndexFunc(links, func(l link) bool { return l.Provider == provider })
+	if i < 0 {
+		links = append(links, link{Identity: Identity{Provider: provider, Subject: c.Subject, LinkedAt: now}})
+		i = len(links) - 1
+	}
+	l := &links[i]
+	l.Email, l.LastLogin = c.Email, now
+	l.setTokens(t, now)
+	if err := m.save(ctx, res.User.ID, links); err != nil {
+		return Result{}, err
+	}
+	if res.Linked {
+		if err := m.index(ctx, provider, c.Subject, res.User.ID); err != nil {
+			return Result{}, err
+		}
+		m.record(ctx, "oauth.linked", res.User.ID, map[string]string{"provider": provider, "created": strconv.FormatBool(res.Created)})
+	}
+	if !res.LinkOnly {
+		m.record(ctx, "oauth.login", res.User.ID, map[string]string{"provider": provider})
+	}
+	res.Identity = l.Identity
+	return res, nil
+}
+
+// resolve finds the user c's identity is for, registering one if need
+// be. m.mu must be held.
+func (m *Manager) resolve(ctx context.Context, provider string, c idClaims, linkTo int) (Result, error) {
+	id, err := m.lookup(ctx, provider, c.Subject)
+	if err != nil {
+		return Result{}, err
+	}
+	if id != 0 {
+		u, err := m.opts.Service.GetUser(ctx, id)
+		switch {
+		case err == nil && linkTo != 0 && id != linkTo:
+			return Result{}, ErrLinkedElsewhere
+		case err == nil:
+			return Result{User: u, LinkOnly: linkTo != 0}, nil
+		case !errors.Is(err, users.ErrUserNotFound):
+			return Result{}, err
+		}
+		// A deletion the Manager did not hear of: the link is stale.
+	}
+
+	if linkTo != 0 {
+		u, err := m.opts.Service.GetUser(ctx, linkTo)
+		if err != nil {
+			return Result{}, err
+		}
+		links, err := m.load(ctx, linkTo)
+		if err != nil {
+			return Result{}, err
+		}
+		if slices.ContainsFunc(links, func(l link) bool { return l.Provider == provider }) {
+			return Result{}, ErrAlreadyLinked
+		}
+		return Result{User: u, Linked: true, LinkOnly: true}, nil
+	}
+
+	email := strings.ToLower(strings.TrimSpace(c.Email))
+	if email == "" {
+		return Result{}, ErrNoEmail
+	}
+	if m.opts.LinkByEmail && bool(c.EmailVerified) {
+		found, err := m.opts.Service.SearchUsers(ctx, query.Eq(query.FieldEmail, email))
+		if err != nil {
+			return Result{}, err
+		}
+		if len(found) == 1 {
+			return Result{User: found[0], Linked: true}, nil
+		}
+	}
+	name := cmp.Or(c.Name, c.PreferredUsername, email[:max(strings.IndexByte(email, '@'), 0)], email)
+	u, err := m.opts.Service.RegisterUser(ctx, name, email)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{User: u, Created: true, Linked: true}, nil
+}
+
+/*
+-----------------------------------
+LINKS
+-----------------------------------
+*/
+
+// Identities lists the identities linked to user id.
+func (m *Manager) Identities(ctx context.Context, id int) ([]Identity, error) {
+	links, err := m.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Identity, len(links))
+	for i, l := range links {
+		out[i] = l.Identity
+	}
+	return out, nil
+}
+
+// Unlink removes provider's identity from user id. The provider's
+// tokens are forgotten, not revoked.
+func (m *Manager) Unlink(ctx context.Context, id int, provider string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	links, err := m.load(ctx, id)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(links, func(l link) bool { return l.Provider == provider })
+	if i < 0 {
+		return ErrNotLinked
+	}
+	if err := m.unindex(ctx, links[i].Identity); err != nil {
+		return err
+	}
+	if err := m.save(ctx, id, slices.Delete(links, i, i+1)); err != nil {
+		return err
+	}
+	m.record(ctx, "oauth.unlinked", id, map[string]string{"provider": provider})
+	return nil
+}
+
+// refreshMargin is how long before it expires an access token is
+// refreshed, so that what AccessToken hands out lasts a call or two.
+const refreshMargin = time.Minute
+
+// AccessToken is a current access token of provider's for user id,
+// refreshed with the refresh token if the one kept has expired.
+func (m *Manager) AccessToken(ctx context.Context, id int, provider string) (Token, error) {
+	p, ok := m.providers[provider]
+	if !ok {
+		return Token{}, ErrUnknownProvider
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	links, err := m.load(ctx, id)
+	if err != nil {
+		return Token{}, err
+	}
+	i := slices.IndexFunc(links, func(l link) bool { return l.Provider == provider })
+	if i < 0 {
+		return Token{}, ErrNotLinked
+	}
+	l := &links[i]
+	now := m.opts.Clock.Now()
+	if l.AccessToken != "" && (l.Expiry.IsZero() || now.Add(refreshMargin).Before(l.Expiry)) {
+		return l.token(), nil
+	}
+	if l.RefreshToken == "" {
+		return Token{}, ErrNoRefresh
+	}
+	meta, err := p.discover(ctx, now)
+	if err != nil {
+		return Token{}, err
+	}
+	t, err := p.exchange(ctx, meta, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {l.RefreshToken}})
+	if err != nil {
+		return Token{}, err
+	}
+	l.setTokens(t, now)
+	if err := m.save(ctx, id, links); err != nil {
+		return Token{}, err
+	}
+	return l.token(), nil
+}
+
+// Subscribe removes a user's links when the service publishes their
+// deletion.
+func (m *Manager) Subscribe(bus *eventbus.Bus) error {
+	_, err := bus.Subscribe(users.TopicUserDeleted, func(ev eventbus.Event) {
+		if u, ok := ev.Payload.(users.User); ok {
+			_, _ = m.Erase(context.Background(), u.ID)
+		}
+	}, eventbus.SubscribeOptions{})
+	return err
+}
+
+/*
+-----------------------------------
+STORAGE
+-----------------------------------
+*/
+
+// link is an identity as stored, with the provider's tokens.
+type link struct {
+	Identity
+	AccessToken  string    `json:"access_token,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitzero"`
+}
+
+// setTokens keeps t's tokens; a refresh that sends no new refresh token
+// leaves the old one good.
+func (l *link) setTokens(t tokens, now time.Time) {
+	l.AccessToken, l.Expiry = t.AccessToken, time.Time{}
+	if t.ExpiresIn > 0 {
+		l.Expiry = now.Add(time.Duration(t.ExpiresIn) * time.Second)
+	}
+	if t.RefreshToken != "" {
+		l.RefreshToken = t.RefreshToken
+	}
+}
+
+func (l *link) token() Token { return Token{AccessToken: l.AccessToken, ExpiresAt: l.Expiry} }
+
+// userKey is where user id's links are stored.
+func userKey(id int) string { return "oauth/users/" + strconv.Itoa(id) }
+
+// subjectKey is where the user an identity is linked to is stored.
+// Subjects may be long and have any character, so the key has their
+// hash.
+func subjectKey(provider, subject string) string {
+	sum := sha256.Sum256([]byte(subject))
+	return "oauth/subjects/" + provider + "/" + hex.EncodeToString(sum[:])
+}
+
+// Names tokens are sealed under.
+const (
+	accessField  = "oauth.access_token"
+	refreshField = "oauth.refresh_token"
+)
+
+// load is user id's links, none if there are none.
+func (m *Manager) load(ctx context.Context, id int) ([]link, error) {
+	rc, _, err := m.opts.Store.Get(ctx, userKey(id))
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var links []link
+	if err := json.NewDecoder(io.LimitReader(rc, 1<<20)).Decode(&links); err != nil {
+		return nil, err
+	}
+	if m.opts.Codec != nil {
+		for i := range links {
+			l := &links[i]
+			if l.AccessToken, err = m.open(accessField, l.AccessToken); err != nil {
+				return nil, err
+			}
+			if l.RefreshToken, err = m.open(refreshField, l.RefreshToken); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return links, nil
+}
+
+func (m *Manager) open(field, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	return m.opts.Codec.Open(field, value)
+}
+
+// save stores user id's links, deleting the blob for none.
+func (m *Manager) save(ctx context.Context, id int, links []link) error {
+	if len(links) == 0 {
+		err := m.opts.Store.Delete(ctx, userKey(id))
+		if errors.Is(err, blobstore.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	stored := slices.Clone(links)
+	if m.opts.Codec != nil {
+		for i := range stored {
+			l := &stored[i]
+			if l.AccessToken != "" {
+				l.AccessToken = m.opts.Codec.Seal(accessField, l.AccessToken, fieldcrypt.Random)
+			}
+			if l.RefreshToken != "" {
+				l.RefreshToken = m.opts.Codec.Seal(refreshField, l.RefreshToken, fieldcrypt.Random)
+			}
+		}
+	}
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	_, err = m.opts.Store.Put(ctx, userKey(id), bytes.NewReader(b), "application/json")
+	return err
+}
+
+// subjectRecord is what subjectKey holds.
+type subjectRecord struct {
+	UserID int `json:"user_id"`
+}
+
+// lookup is the user provider's subject is linked to, 0 for none.
+func (m *Manager) lookup(ctx context.Context, provider, subject string) (int, error) {
+	rc, _, err := m.opts.Store.Get(ctx, subjectKey(provider, subject))
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	var r subjectRecord
+	if err := json.NewDecoder(io.LimitReader(rc, 1<<10)).Decode(&r); err != nil {
+		return 0, err
+	}
+	return r.UserID, nil
+}
+
+func (m *Manager) index(ctx context.Context, provider, subject string, id int) error {
+	b, err := json.Marshal(subjectRecord{UserID: id})
+	if err != nil {
+		return err
+	}
+	_, err = m.opts.Store.Put(ctx, subjectKey(provider, subject), bytes.NewReader(b), "application/json")
+	return err
+}
+
+func (m *Manager) unindex(ctx context.Context, i Identity) error {
+	err := m.opts.Store.Delete(ctx, subjectKey(i.Provider, i.Subject))
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// record is best effort: what it records has happened either way.
+func (m *Manager) record(ctx context.Context, action string, id int, meta map[string]string) {
+	_ = m.opts.Audit.Record(ctx, audit.Entry{
+		Actor:      ctxutil.UserID(ctx),
+		Action:     action,
+		Resource:   "user",
+		ResourceID: strconv.Itoa(id),
+		Meta:       meta,
+	})
+}
+
+/*
+-----------------------------------
+PRIVACY AND MERGES
+-----------------------------------
+*/
+
+func (m *Manager) Name() string { return "oauth_identities" }
+
+// Export is the user's identities; the provider's tokens stay out of
+// archives, which travel.
+func (m *Manager) Export(ctx context.Context, id int) (any, error) {
+	ids, err := m.Identities(ctx, id)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (m *Manager) Erase(ctx context.Context, id int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	links, err := m.load(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	for _, l := range links {
+		if err := m.unindex(ctx, l.Identity); err != nil {
+			return 0, err
+		}
+	}
+	return len(links), m.save(ctx, id, nil)
+}
+
+// Reassign moves the merged user's identities to keep, so either's
+// provider signs in to keep. A provider keep has linked already keeps
+// keep's identity; the merged user's is unlinked, free to be linked
+// again.
+func (m *Manager) Reassign(ctx context.Context, keep *users.User, merged users.User, dryRun bool) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	from, err := m.load(ctx, merged.ID)
+	if err != nil || len(from) == 0 {
+		return 0, err
+	}
+	to, err := m.load(ctx, keep.ID)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for _, l := range from {
+		if slices.ContainsFunc(to, func(k link) bool { return k.Provider == l.Provider }) {
+			if !dryRun {
+				if err := m.unindex(ctx, l.Identity); err != nil {
+					return moved, err
+				}
+			}
+			continue
+		}
+		moved++
+		if dryRun {
+			continue
+		}
+		to = append(to, l)
+		if err := m.index(ctx, l.Provider, l.Subject, keep.ID); err != nil {
+			return moved, err
+		}
+	}
+	if dryRun {
+		return moved, nil
+	}
+	if err := m.save(ctx, keep.ID, to); err != nil {
+		return moved, err
+	}
+	return moved, m.save(ctx, merged.ID, nil)
+}
@@ -0,0 +1,243 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+-----------------------------------
+PROVIDERS
+-----------------------------------
+*/
+
+// ProviderConfig is an OpenID Connect provider the service is registered
+// with as a client.
+type ProviderConfig struct {
+	// Name is the provider in routes and links, "google" say: lower-case
+	// letters, digits, '-' and '_'.
+	Name string `json:"name"`
+	// Issuer is the provider's issuer URL; its endpoints are discovered
+	// from Issuer/.well-known/openid-configuration.
+	Issuer   string `json:"issuer"`
+	ClientID string `json:"client_id"`
+	// ClientSecret is sent with client_secret_basic; a public client,
+	// which PKCE alone protects, has none.
+	ClientSecret string `json:"client_secret,omitempty"`
+	// RedirectURL is the callback registered with the provider: this
+	// service's /auth/oauth/{name}/callback.
+	RedirectURL string `json:"redirect_url"`
+	// Scopes default to openid, email and profile; openid is always
+	// asked for.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// LoadProviders reads a JSON array of ProviderConfig.
+func LoadProviders(r io.Reader) ([]ProviderConfig, error) {
+	var list []ProviderConfig
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&list); err != nil {
+		return nil, fmt.Errorf("oauth: providers: %w", err)
+	}
+	return list, nil
+}
+
+func (c ProviderConfig) check() error {
+	if c.Name == "" || strings.Trim(c.Name, "abcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+		return fmt.Errorf("oauth: provider name %q: want lower-case letters, digits, '-' and '_'", c.Name)
+	}
+	if u, err := url.Parse(c.Issuer); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("oauth: provider %s: issuer %q is not a URL", c.Name, c.Issuer)
+	}
+	if c.ClientID == "" || c.RedirectURL == "" {
+		return fmt.Errorf("oauth: provider %s: client_id and redirect_url are required", c.Name)
+	}
+	return nil
+}
+
+// metadata is the part of a provider's discovery document the flow uses.
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// discoveryTTL is how long a discovery document is trusted; the keys it
+// points at are refetched sooner if a token names one not seen.
+const discoveryTTL = time.Hour
+
+// provider is a ProviderConfig with what was fetched from it.
+type provider struct {
+	cfg    ProviderConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	meta      *metadata
+	fetched   time.Time
+	keys      map[string]any // kid → *rsa.PublicKey or *ecdsa.PublicKey
+	keysFetch time.Time
+}
+
+// discover returns the provider's metadata, fetching it if it is stale.
+func (p *provider) discover(ctx context.Context, now time.Time) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil && now.Sub(p.fetched) < discoveryTTL {
+		return p.meta, nil
+	}
+	var m metadata
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, err
+	}
+	// OpenID Connect Discovery §4.3: a document for another issuer is an
+	// impostor's.
+	if m.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: discovery names issuer %q, want %q", ErrProvider, m.Issuer, p.cfg.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document lacks an endpoint", ErrProvider)
+	}
+	// PKCE is not optional here; a provider that lists its methods must
+	// list S256. One that lists none may still support it, as most do.
+	if len(m.CodeChallengeMethods) > 0 && !slices.Contains(m.CodeChallengeMethods, "S256") {
+		return nil, fmt.Errorf("%w: provider does not support PKCE with S256", ErrProvider)
+	}
+	p.meta, p.fetched = &m, now
+	return p.meta, nil
+}
+
+func (p *provider) getJSON(ctx context.Context, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: GET %s: %s", ErrProvider, u, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return fmt.Errorf("%w: GET %s: %v", ErrProvider, u, err)
+	}
+	return nil
+}
+
+// authURL is where to send the user agent to sign in.
+func (p *provider) authURL(m *metadata, state, nonce, verifier string) string {
+	scopes := []string{"openid"}
+	for _, s := range p.cfg.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(p.cfg.Scopes) == 0 {
+		scopes = append(scopes, "email", "profile")
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// tokens is a token endpoint's answer (RFC 6749 §5.1).
+type tokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	IDToken      string `json:"id_token"`
+}
+
+// tokenError is a token endpoint's refusal (RFC 6749 §5.2).
+type tokenError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// exchange posts form to the token endpoint, authenticating as the
+// client.
+func (p *provider) exchange(ctx context.Context, m *metadata, form url.Values) (tokens, error) {
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokens{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		// RFC 6749 §2.3.1: both are form-encoded before going into Basic.
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return tokens{}, fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	defer resp.Body.Close()
+	body := io.LimitReader(resp.Body, 1<<20)
+	if resp.StatusCode != http.StatusOK {
+		var e tokenError
+		if json.NewDecoder(body).Decode(&e) == nil && e.Error != "" {
+			return tokens{}, fmt.Errorf("%w: token endpoint: %s: %s", ErrProvider, e.Error, e.Description)
+		}
+		return tokens{}, fmt.Errorf("%w: token endpoint: %s", ErrProvider, resp.Status)
+	}
+	var t tokens
+	if err := json.NewDecoder(body).Decode(&t); err != nil {
+		return tokens{}, fmt.Errorf("%w: token endpoint: %v", ErrProvider, err)
+	}
+	if t.AccessToken == "" {
+		return tokens{}, fmt.Errorf("%w: token endpoint: no access_token", ErrProvider)
+	}
+	return t, nil
+}
+
+/*
+-----------------------------------
+PKCE
+-----------------------------------
+*/
+
+// randomString is n random bytes, base64url: unguessable and safe in a
+// URL.
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// challenge is verifier's S256 code challenge (RFC 7636 §4.2).
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
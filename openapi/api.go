@@ -0,0 +1,457 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+-----------------------------------
+OPERATIONS
+-----------------------------------
+*/
+
+// Operation describes one route. Types are given by example values
+// (createRequest{}, []users.User(nil)), the way the handler would have
+// them.
+type Operation struct {
+	// Pattern is the route as http.ServeMux takes it: "POST /users",
+	// "GET /users/{id}".
+	Pattern     string
+	Summary     string
+	Description string
+	Tags        []string
+	// Params describes the path and query parameters. A path parameter
+	// not listed is a string.
+	Params []Param
+	// Body is a value of the request body's type, nil for no body. A
+	// Content body is documented with its type but not read: only its
+	// Content-Type is checked, and the handler streams it. A []Content
+	// is a choice of such bodies, told apart by their Content-Type.
+	Body any
+	// Responses maps a status to a value of its body type; nil is a
+	// response without a body, Content one that is not JSON.
+	Responses map[int]any
+	// Auth marks the operation as taking a bearer token.
+	Auth bool
+}
+
+// Param is a path, query or header parameter.
+type Param struct {
+	Name        string
+	In          string // "path", "query" or "header"
+	Description string
+	Required    bool // path parameters always are
+	Schema      *Schema
+}
+
+// PathInt is an integer path parameter, such as an ID.
+func PathInt(name string) Param {
+	return Param{Name: name, In: "path", Required: true, Schema: &Schema{Type: "integer", Format: "int64"}}
+}
+
+// Query is an optional query parameter.
+func Query(name, description string, s *Schema) Param {
+	return Param{Name: name, In: "query", Description: description, Schema: s}
+}
+
+// Header is an optional request header.
+func Header(name, description string, s *Schema) Param {
+	return Param{Name: name, In: "header", Description: description, Schema: s}
+}
+
+// Content is a body that is not JSON, e.g. a stream of NDJSON records of
+// Body's type.
+type Content struct {
+	Type string
+	Body any
+}
+
+// Route is an operation and its handler.
+type Route struct {
+	Operation
+	Handler http.Handler
+}
+
+/*
+-----------------------------------
+API
+-----------------------------------
+*/
+
+// Options configures New.
+type Options struct {
+	Title       string
+	Version     string
+	Description string
+	// MaxBody bounds the request bodies read for validation; default
+	// 1 MiB. Handlers may apply a smaller limit of their own.
+	MaxBody int64
+	// OnInvalid answers a request that failed validation; err is a
+	// *RequestError. Default: 400, or 413 for a body over MaxBody, with
+	// {"error": ..., "problems": [...]}.
+	OnInvalid func(w http.ResponseWriter, r *http.Request, err error)
+	// SwaggerUI is where the docs page loads Swagger UI from; default the
+	// swagger-ui-dist package on unpkg.
+	SwaggerUI string
+}
+
+// API registers routes on a mux and keeps the OpenAPI description of
+// every route it registered, so the document cannot drift from the
+// routes it describes: a route is in the mux if and only if it is in
+// the document.
+type API struct {
+	mux  *http.ServeMux
+	opts Options
+
+	mu         sync.RWMutex
+	components *components
+	paths      map[string]map[string]*operation // path, then method
+	bearer     bool
+}
+
+func New(mux *http.ServeMux, opts Options) *API {
+	if opts.Title == "" {
+		opts.Title = "API"
+	}
+	if opts.Version == "" {
+		opts.Version = "0"
+	}
+	if opts.MaxBody <= 0 {
+		opts.MaxBody = 1 << 20
+	}
+	if opts.OnInvalid == nil {
+		opts.OnInvalid = writeInvalid
+	}
+	if opts.SwaggerUI == "" {
+		opts.SwaggerUI = "https://unpkg.com/swagger-ui-dist@5"
+	}
+	return &API{mux: mux, opts: opts, components: newComponents(), paths: make(map[string]map[string]*operation)}
+}
+
+// operation is an Operation as the document shows it, plus what the
+// validator needs.
+type operation struct {
+	Summary     string                    `json:"summary,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Tags        []string                  `json:"tags,omitempty"`
+	OperationID string                    `json:"operationId"`
+	Parameters  []parameter               `json:"parameters,omitempty"`
+	RequestBody *requestBody              `json:"requestBody,omitempty"`
+	Responses   map[string]responseObject `json:"responses"`
+	Security    []map[string][]string     `json:"security,omitempty"`
+	body        *Schema                   // nil: no JSON body
+	bodyTypes   []string                  // the media types of Content bodies
+	params      []parameter
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type responseObject struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+var (
+	patternRE = regexp.MustCompile(`^([A-Z]+) (/[^ ]*)$`)
+	wildRE    = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(\.\.\.)?\}`)
+)
+
+// Add registers routes on the mux, each behind request validation. Like
+// ServeMux.Handle, it panics on a pattern it cannot take (here: one
+// without a method) and on a type it cannot describe; both are mistakes
+// in the code, found at start-up.
+func (a *API) Add(routes ...Route) {
+	for _, rt := range routes {
+		op, path, method, err := a.describe(rt.Operation)
+		if err != nil {
+			panic(fmt.Sprintf("openapi: %s: %v", rt.Pattern, err))
+		}
+		a.mu.Lock()
+		if a.paths[path] == nil {
+			a.paths[path] = make(map[string]*operation)
+		}
+		a.paths[path][method] = op
+		a.bearer = a.bearer || rt.Auth
+		a.mu.Unlock()
+		a.mux.Handle(rt.Pattern, a.validate(op, rt.Handler))
+	}
+}
+
+func (a *API) describe(o Operation) (op *operation, path, method string, err error) {
+	m := patternRE.FindStringSubmatch(o.Pattern)
+	if m == nil {
+		return nil, "", "", errors.New(`want "METHOD /path"`)
+	}
+	method = strings.ToLower(m[1])
+	path = wildRE.ReplaceAllString(m[2], "{$1}")
+	op = &operation{
+		Summary: o.Summary, Description: o.Description, Tags: o.Tags,
+		OperationID: operationID(method, path),
+		Responses:   make(map[string]responseObject),
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	declared := make(map[string]Param)
+	for _, p := range o.Params {
+		declared[p.In+"."+p.Name] = p
+	}
+	for _, w := range wildRE.FindAllStringSubmatch(m[2], -1) {
+		p, ok := declared["path."+w[1]]
+		if !ok {
+			p = Param{Name: w[1], In: "path", Schema: &Schema{Type: "string"}}
+		}
+		delete(declared, "path."+w[1])
+		op.params = append(op.params, parameter{Name: p.Name, In: "path", Description: p.Description, Required: true, Schema: p.Schema})
+	}
+	for _, p := range o.Params {
+		if p.In == "query" || p.In == "header" {
+			op.params = append(op.params, parameter{Name: p.Name, In: p.In, Description: p.Description, Required: p.Required, Schema: p.Schema})
+			delete(declared, p.In+"."+p.Name)
+		}
+	}
+	for k := range declared {
+		return nil, "", "", fmt.Errorf("parameter %s is not in the pattern", k)
+	}
+	op.Parameters = op.params
+
+	if c, ok := o.Body.(Content); ok {
+		o.Body = []Content{c}
+	}
+	if cs, ok := o.Body.([]Content); ok {
+		op.RequestBody = &requestBody{Required: true, Content: map[string]mediaType{}}
+		for _, c := range cs {
+			s, err := a.components.schemaOf(reflect.TypeOf(c.Body))
+			if err != nil {
+				return nil, "", "", err
+			}
+			op.bodyTypes = append(op.bodyTypes, c.Type)
+			op.RequestBody.Content[c.Type] = mediaType{Schema: s}
+		}
+	} else if o.Body != nil {
+		if op.body, err = a.components.schemaOf(reflect.TypeOf(o.Body)); err != nil {
+			return nil, "", "", err
+		}
+		op.RequestBody = &requestBody{Required: true, Content: map[string]mediaType{"application/json": {Schema: op.body}}}
+	}
+	for status, body := range o.Responses {
+		resp := responseObject{Description: http.StatusText(status)}
+		ctype := "application/json"
+		if c, ok := body.(Content); ok {
+			ctype, body = c.Type, c.Body
+		}
+		if body != nil {
+			s, err := a.components.schemaOf(reflect.TypeOf(body))
+			if err != nil {
+				return nil, "", "", err
+			}
+			resp.Content = map[string]mediaType{ctype: {Schema: s}}
+		}
+		op.Responses[strconv.Itoa(status)] = resp
+	}
+	if len(op.Responses) == 0 {
+		op.Responses["default"] = responseObject{Description: "any response"}
+	}
+	if o.Auth {
+		op.Security = []map[string][]string{{"bearer": {}}}
+	}
+	return op, path, method, nil
+}
+
+// operationID is "get_users_id" for GET /users/{id}: unique per route,
+// stable, and a valid identifier for generated clients.
+func operationID(method, path string) string {
+	id := method
+	for _, part := range strings.Split(path, "/") {
+		part = strings.Trim(part, "{}")
+		if part != "" {
+			id += "_" + strings.NewReplacer("-", "_", ".", "_").Replace(part)
+		}
+	}
+	return id
+}
+
+// validate checks parameters and body before calling next. A JSON body
+// is read in full (up to MaxBody) and handed to next unchanged.
+func (a *API) validate(op *operation, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.mu.RLock()
+		c := &checker{schemas: a.components.schemas}
+		a.mu.RUnlock()
+		for _, p := range op.params {
+			var v string
+			var present bool
+			switch p.In {
+			case "path":
+				v, present = r.PathValue(p.Name), true
+			case "header":
+				v = r.Header.Get(p.Name)
+				present = v != ""
+			default:
+				present = r.URL.Query().Has(p.Name)
+				v = r.URL.Query().Get(p.Name)
+			}
+			switch {
+			case !present && p.Required:
+				c.add(p.In+"."+p.Name, "is required")
+			case present:
+				c.param(p.Schema, v, p.In+"."+p.Name)
+			}
+		}
+		switch {
+		case op.body != nil:
+			a.checkBody(c, op.body, w, r)
+		case len(op.bodyTypes) > 0:
+			if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || !slices.Contains(op.bodyTypes, mt) {
+				c.add("body", "must be %s, not %q", strings.Join(op.bodyTypes, " or "), r.Header.Get("Content-Type"))
+			}
+		}
+		if len(c.problems) > 0 {
+			a.opts.OnInvalid(w, r, &RequestError{Problems: c.problems, Body: c.body})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *API) checkBody(c *checker, s *Schema, w http.ResponseWriter, r *http.Request) {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mt, _, err := mime.ParseMediaType(ct); err != nil || mt != "application/json" {
+			c.add("body", "must be application/json, not %s", ct)
+			return
+		}
+	}
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, a.opts.MaxBody))
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			c.add("body", "is larger than %d bytes", tooBig.Limit)
+			c.body = tooBig
+		} else {
+			c.add("body", "could not be read: %v", err)
+		}
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(raw))
+	if len(bytes.TrimSpace(raw)) == 0 {
+		c.add("body", "is required")
+		return
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		c.add("body", "is not valid JSON: %v", err)
+		return
+	}
+	if dec.More() {
+		c.add("body", "has data after the JSON value")
+		return
+	}
+	c.value(s, v, "body")
+}
+
+func writeInvalid(w http.ResponseWriter, _ *http.Request, err error) {
+	var re *RequestError
+	errors.As(err, &re)
+	status := http.StatusBadRequest
+	if errors.As(err, new(*http.MaxBytesError)) {
+		status = http.StatusRequestEntityTooLarge
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"error": err.Error(), "problems": re.Problems})
+}
+
+/*
+-----------------------------------
+DOCUMENT
+-----------------------------------
+*/
+
+// Document returns the OpenAPI 3.0 document of every route added so far,
+// as indented JSON.
+func (a *API) Document() ([]byte, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	info := map[string]any{"title": a.opts.Title, "version": a.opts.Version}
+	if a.opts.Description != "" {
+		info["description"] = a.opts.Description
+	}
+	comps := map[string]any{"schemas": a.components.schemas}
+	if a.bearer {
+		comps["securitySchemes"] = map[string]any{"bearer": map[string]string{"type": "http", "scheme": "bearer"}}
+	}
+	return json.MarshalIndent(map[string]any{
+		"openapi":    "3.0.3",
+		"info":       info,
+		"paths":      a.paths,
+		"components": comps,
+	}, "", "  ")
+}
+
+// ServeDocs adds GET specPath (the document as JSON) and GET uiPath
+// (Swagger UI showing it) to the mux. Neither is in the document.
+func (a *API) ServeDocs(specPath, uiPath string) {
+	a.mux.HandleFunc("GET "+specPath, func(w http.ResponseWriter, r *http.Request) {
+		doc, err := a.Document()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(doc)
+	})
+	a.mux.HandleFunc("GET "+uiPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = uiPage.Execute(w, map[string]string{"Title": a.opts.Title, "Spec": specPath, "Assets": a.opts.SwaggerUI})
+	})
+}
+
+// The page is Swagger UI's stock bundle pointed at the document; the
+// assets come from a CDN (Options.SwaggerUI) rather than from this
+// binary.
+var uiPage = template.Must(template.New("ui").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="{{.Assets}}/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="{{.Assets}}/swagger-ui-bundle.js"></script>
+<script>
+SwaggerUIBundle({url: {{.Spec}}, dom_id: "#swagger-ui"});
+</script>
+</body>
+</html>
+`))
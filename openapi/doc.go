@@ -0,0 +1,24 @@
+// Package openapi describes HTTP routes as an OpenAPI 3.0 document and
+// checks requests against that description before they reach a handler.
+//
+// The description is not written by hand. A route is registered together
+// with its Operation (pattern, parameters, and the Go types of its body
+// and responses), and schemas are derived from those types the way
+// encoding/json sees them, json tags included. Constraints JSON cannot
+// express go in a schema tag:
+//
+//	type createRequest struct {
+//		Name  string `json:"name" schema:"required,minLength=1,maxLength=100"`
+//		Email string `json:"email" schema:"required,format=email"`
+//	}
+//
+// Since the mux only learns a route through API.Add, the document lists
+// exactly the routes that are served; adding a field to a DTO changes
+// both the schema and what validation accepts.
+//
+// Validation is a gate, not the domain's rules: it rejects what cannot
+// be a valid request (a missing field, a string where a number goes, an
+// ID that is not an integer, a field nobody defined) with every problem
+// listed, so handlers decode input already known to fit. Whether an
+// email is taken or a name is allowed stays with the service.
+package openapi
@@ -0,0 +1,311 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+-----------------------------------
+SCHEMAS
+-----------------------------------
+*/
+
+// Schema is the subset of the OpenAPI 3.0 schema object that Go types
+// map to and Validate checks.
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Nullable    bool               `json:"nullable,omitempty"`
+	ReadOnly    bool               `json:"readOnly,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	// AdditionalProperties is false for structs (an unknown field is a
+	// mistake worth a 400, not something to drop silently) and the
+	// value schema for maps.
+	AdditionalProperties any       `json:"additionalProperties,omitempty"`
+	Items                *Schema   `json:"items,omitempty"`
+	Enum                 []any     `json:"enum,omitempty"`
+	MinLength            *int      `json:"minLength,omitempty"`
+	MaxLength            *int      `json:"maxLength,omitempty"`
+	Minimum              *float64  `json:"minimum,omitempty"`
+	Maximum              *float64  `json:"maximum,omitempty"`
+	Pattern              string    `json:"pattern,omitempty"`
+	MinItems             *int      `json:"minItems,omitempty"`
+	MaxItems             *int      `json:"maxItems,omitempty"`
+	AllOf                []*Schema `json:"allOf,omitempty"`
+
+	re *regexp.Regexp // Pattern, compiled
+}
+
+// Tag is the struct tag with a field's constraints, comma-separated and
+// named as in JSON Schema:
+//
+//	Email string `json:"email" schema:"required,format=email,maxLength=254"`
+//	Kind  string `json:"kind" schema:"enum=a|b|c"`
+//	ID    int    `json:"id" schema:"readOnly"`
+//
+// A pattern cannot contain a comma. DocTag holds the field's description.
+const (
+	Tag    = "schema"
+	DocTag = "doc"
+)
+
+const refPrefix = "#/components/schemas/"
+
+var (
+	timeType      = reflect.TypeFor[time.Time]()
+	durationType  = reflect.TypeFor[time.Duration]()
+	rawType       = reflect.TypeFor[json.RawMessage]()
+	marshalerType = reflect.TypeFor[json.Marshaler]()
+	textType      = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// components collects the schemas of exported named structs, which are
+// referenced by name instead of repeated inline. Two types with one name
+// (from different packages) are told apart by their package's name.
+type components struct {
+	schemas map[string]*Schema
+	types   map[reflect.Type]string
+}
+
+func newComponents() *components {
+	return &components{schemas: make(map[string]*Schema), types: make(map[reflect.Type]string)}
+}
+
+func (c *components) name(t reflect.Type) string {
+	if n, ok := c.types[t]; ok {
+		return n
+	}
+	n := t.Name()
+	if _, taken := c.schemas[n]; taken {
+		pkg := t.PkgPath()
+		n = pkg[strings.LastIndex(pkg, "/")+1:] + "." + n
+	}
+	c.types[t] = n
+	return n
+}
+
+// schemaOf maps t to a schema, registering named structs in c. Types
+// with their own JSON encoding (other than time.Time) are described as
+// strings if they marshal to text, or left open otherwise.
+func (c *components) schemaOf(t reflect.Type) (*Schema, error) {
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}, nil
+	case t == durationType:
+		return &Schema{Type: "integer", Format: "int64", Description: "nanoseconds"}, nil
+	case t == rawType:
+		return &Schema{}, nil
+	case t.Kind() != reflect.Pointer && (t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType)):
+		return &Schema{}, nil
+	case t.Kind() != reflect.Pointer && (t.Implements(textType) || reflect.PointerTo(t).Implements(textType)):
+		return &Schema{Type: "string"}, nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}, nil
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer", Minimum: ptr(0.0)}, nil
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}, nil
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}, nil
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Interface:
+		return &Schema{}, nil
+	case reflect.Pointer:
+		s, err := c.schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		if s.Ref != "" {
+			// $ref siblings are ignored in 3.0; allOf carries nullable.
+			return &Schema{Nullable: true, AllOf: []*Schema{s}}, nil
+		}
+		s.Nullable = true
+		return s, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}, nil
+		}
+		items, err := c.schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("openapi: %s: map keys must be strings", t)
+		}
+		vals, err := c.schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "object", AdditionalProperties: vals}, nil
+	case reflect.Struct:
+		if !token.IsExported(t.Name()) {
+			return c.object(t)
+		}
+		_, seen := c.types[t]
+		name := c.name(t)
+		if !seen {
+			c.schemas[name] = nil // a placeholder, for types that refer to themselves
+			s, err := c.object(t)
+			if err != nil {
+				return nil, err
+			}
+			c.schemas[name] = s
+		}
+		return &Schema{Ref: refPrefix + name}, nil
+	}
+	return nil, fmt.Errorf("openapi: %s: cannot describe %s", t, t.Kind())
+}
+
+// object describes a struct as encoding/json sees it: exported fields
+// under their json names, "-" skipped, embedded structs promoted.
+func (c *components) object(t reflect.Type) (*Schema, error) {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+	if err := c.fields(t, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *components) fields(t reflect.Type, s *Schema) error {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := c.fields(ft, s); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fs, err := c.schemaOf(ft)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", t.Name(), f.Name, err)
+		}
+		if slices.Contains(strings.Split(opts, ","), "string") && (fs.Type == "integer" || fs.Type == "number" || fs.Type == "boolean") {
+			fs = &Schema{Type: "string", Description: "a " + fs.Type + " as a string"}
+		}
+		required, err := applyTag(fs, f.Tag.Get(Tag))
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", t.Name(), f.Name, err)
+		}
+		if d := f.Tag.Get(DocTag); d != "" {
+			fs.Description = d
+		}
+		if fs.Ref != "" && (fs.Description != "" || fs.ReadOnly) {
+			fs = &Schema{AllOf: []*Schema{{Ref: fs.Ref}}, Description: fs.Description, ReadOnly: fs.ReadOnly}
+		}
+		s.Properties[name] = fs
+		if required {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return nil
+}
+
+// applyTag adds a schema tag's constraints to s and reports whether the
+// field is required.
+func applyTag(s *Schema, tag string) (required bool, err error) {
+	if tag == "" {
+		return false, nil
+	}
+	for _, item := range strings.Split(tag, ",") {
+		key, val, hasVal := strings.Cut(strings.TrimSpace(item), "=")
+		switch key {
+		case "required":
+			required = true
+		case "readOnly":
+			s.ReadOnly = true
+		case "nullable":
+			s.Nullable = true
+		case "format":
+			s.Format = val
+		case "pattern":
+			re, err := regexp.Compile(val)
+			if err != nil {
+				return false, fmt.Errorf("pattern: %w", err)
+			}
+			s.Pattern, s.re = val, re
+		case "enum":
+			for _, v := range strings.Split(val, "|") {
+				if s.Type == "integer" {
+					n, err := strconv.ParseInt(v, 10, 64)
+					if err != nil {
+						return false, fmt.Errorf("enum %q: %w", v, err)
+					}
+					s.Enum = append(s.Enum, n)
+				} else {
+					s.Enum = append(s.Enum, v)
+				}
+			}
+		case "minimum", "maximum":
+			n, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return false, fmt.Errorf("%s: %w", key, err)
+			}
+			if key == "minimum" {
+				s.Minimum = &n
+			} else {
+				s.Maximum = &n
+			}
+		case "minLength", "maxLength", "minItems", "maxItems":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return false, fmt.Errorf("%s: %w", key, err)
+			}
+			switch key {
+			case "minLength":
+				s.MinLength = &n
+			case "maxLength":
+				s.MaxLength = &n
+			case "minItems":
+				s.MinItems = &n
+			default:
+				s.MaxItems = &n
+			}
+		default:
+			return false, fmt.Errorf("unknown schema tag %q", key)
+		}
+		if !hasVal && key != "required" && key != "readOnly" && key != "nullable" {
+			return false, fmt.Errorf("schema tag %q needs a value", key)
+		}
+	}
+	return required, nil
+}
+
+func ptr[T any](v T) *T { return &v }
@@ -0,0 +1,271 @@
+package openapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"Go-Internals/emailaddr"
+)
+
+/*
+-----------------------------------
+VALIDATION
+-----------------------------------
+*/
+
+var ErrInvalidRequest = errors.New("openapi: request does not match the API description")
+
+// Problem is one way a request differs from its operation's description.
+// At names the value: "path.id", "query.limit", "body", "body.tags[2]".
+type Problem struct {
+	At      string `json:"at"`
+	Message string `json:"message"`
+}
+
+// RequestError lists every problem found, not only the first: a client
+// fixing its request should not need one round trip per field.
+type RequestError struct {
+	Problems []Problem
+	// Body is why the body could not be read, if it could not: an
+	// *http.MaxBytesError for one too large.
+	Body error
+}
+
+func (e *RequestError) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid request: ")
+	for i, p := range e.Problems {
+		if i == 3 {
+			fmt.Fprintf(&b, "; and %d more", len(e.Problems)-i)
+			break
+		}
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(p.At + " " + p.Message)
+	}
+	return b.String()
+}
+
+func (e *RequestError) Is(target error) bool { return target == ErrInvalidRequest }
+
+func (e *RequestError) Unwrap() error { return e.Body }
+
+// maxProblems bounds a RequestError, so a large bad body costs a bounded
+// response.
+const maxProblems = 20
+
+type checker struct {
+	schemas  map[string]*Schema
+	problems []Problem
+	body     error
+}
+
+func (c *checker) add(at, format string, args ...any) {
+	if len(c.problems) < maxProblems {
+		c.problems = append(c.problems, Problem{At: at, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// value checks a decoded JSON value (numbers as json.Number) against s.
+func (c *checker) value(s *Schema, v any, at string) {
+	if s.Ref != "" {
+		s = c.schemas[strings.TrimPrefix(s.Ref, refPrefix)]
+	}
+	if v == nil {
+		if !s.Nullable && s.Type != "" && len(s.AllOf) == 0 {
+			c.add(at, "must not be null")
+		}
+		return
+	}
+	for _, sub := range s.AllOf {
+		c.value(sub, v, at)
+	}
+	switch s.Type {
+	case "object":
+		m, ok := v.(map[string]any)
+		if !ok {
+			c.add(at, "must be an object, not %s", jsonType(v))
+			return
+		}
+		c.object(s, m, at)
+	case "array":
+		l, ok := v.([]any)
+		if !ok {
+			c.add(at, "must be an array, not %s", jsonType(v))
+			return
+		}
+		if s.MinItems != nil && len(l) < *s.MinItems {
+			c.add(at, "must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(l) > *s.MaxItems {
+			c.add(at, "must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, e := range l {
+				c.value(s.Items, e, at+"["+strconv.Itoa(i)+"]")
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			c.add(at, "must be a string, not %s", jsonType(v))
+			return
+		}
+		c.str(s, str, at)
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			c.add(at, "must be a number, not %s", jsonType(v))
+			return
+		}
+		c.number(s, string(n), at)
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			c.add(at, "must be a boolean, not %s", jsonType(v))
+		}
+	}
+}
+
+func (c *checker) object(s *Schema, m map[string]any, at string) {
+	for _, name := range s.Required {
+		if _, ok := m[name]; !ok {
+			c.add(join(at, name), "is required")
+		}
+	}
+	// Sorted, so the problems come in the same order every time.
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		// A readOnly field is never required, but a client echoing a
+		// record back still has to send it with the right type: the
+		// handler decodes it before ignoring it.
+		if ps, ok := s.Properties[k]; ok {
+			c.value(ps, m[k], join(at, k))
+			continue
+		}
+		switch extra := s.AdditionalProperties.(type) {
+		case bool:
+			if !extra {
+				c.add(join(at, k), "is not a known field")
+			}
+		case *Schema:
+			c.value(extra, m[k], join(at, k))
+		}
+	}
+}
+
+func (c *checker) str(s *Schema, v, at string) {
+	n := utf8.RuneCountInString(v)
+	if s.MinLength != nil && n < *s.MinLength {
+		c.add(at, "must be at least %d characters", *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		c.add(at, "must be at most %d characters", *s.MaxLength)
+	}
+	if s.re != nil && !s.re.MatchString(v) {
+		c.add(at, "must match %s", s.Pattern)
+	}
+	if len(s.Enum) > 0 && !slices.Contains(s.Enum, any(v)) {
+		c.add(at, "must be one of %v", s.Enum)
+	}
+	switch s.Format {
+	case "email":
+		var invalid *emailaddr.Error
+		if _, err := emailaddr.Parse(v); errors.As(err, &invalid) {
+			c.add(at, "must be an email address: %s", invalid.Reason)
+		}
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+			c.add(at, "must be an RFC 3339 date-time")
+		}
+	case "byte":
+		if _, err := base64.StdEncoding.DecodeString(v); err != nil {
+			c.add(at, "must be base64")
+		}
+	}
+}
+
+// number checks the text of a number, from a body or a parameter.
+func (c *checker) number(s *Schema, text, at string) {
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		if s.Type == "integer" {
+			c.add(at, "must be an integer")
+		} else {
+			c.add(at, "must be a number")
+		}
+		return
+	}
+	if s.Type == "integer" {
+		n, err := strconv.ParseInt(text, 10, 64)
+		switch {
+		case err != nil && f == math.Trunc(f):
+			c.add(at, "is out of range")
+			return
+		case err != nil:
+			c.add(at, "must be an integer")
+			return
+		case s.Format == "int32" && (n < math.MinInt32 || n > math.MaxInt32):
+			c.add(at, "is out of range")
+			return
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, any(n)) {
+			c.add(at, "must be one of %v", s.Enum)
+		}
+	}
+	if s.Minimum != nil && f < *s.Minimum {
+		c.add(at, "must be at least %v", *s.Minimum)
+	}
+	if s.Maximum != nil && f > *s.Maximum {
+		c.add(at, "must be at most %v", *s.Maximum)
+	}
+}
+
+// param checks a path or query parameter, which arrives as text.
+func (c *checker) param(s *Schema, v, at string) {
+	switch s.Type {
+	case "integer", "number":
+		c.number(s, v, at)
+	case "boolean":
+		if _, err := strconv.ParseBool(v); err != nil {
+			c.add(at, "must be true or false")
+		}
+	default:
+		c.str(s, v, at)
+	}
+}
+
+func join(at, field string) string {
+	if at == "" {
+		return field
+	}
+	return at + "." + field
+}
+
+func jsonType(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "an object"
+	case []any:
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	}
+	return "null"
+}
@@ -0,0 +1,57 @@
+// eventlog is an example subscriber plugin: it appends every event it
+// receives to $EVENTLOG_FILE (default stderr) as a JSON line.
+//
+//	go build -o bin/eventlog ./plugins/examples/eventlog                     # "rpc"
+//	go build -buildmode=plugin -o bin/eventlog.so ./plugins/examples/eventlog # "go"
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"Go-Internals/plugins"
+)
+
+type subscriber struct {
+	once sync.Once
+	mu   sync.Mutex
+	w    io.Writer
+	err  error
+}
+
+func (s *subscriber) Handle(topic string, payload json.RawMessage) error {
+	s.once.Do(func() {
+		s.w = os.Stderr
+		if path := os.Getenv("EVENTLOG_FILE"); path != "" {
+			s.w, s.err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		}
+	})
+	if s.err != nil {
+		return s.err
+	}
+	line, err := json.Marshal(struct {
+		At      time.Time       `json:"at"`
+		Topic   string          `json:"topic"`
+		Payload json.RawMessage `json:"payload"`
+	}{time.Now().UTC(), topic, payload})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(line, '\n'))
+	return err
+}
+
+// Plugin is the symbol the host looks up when loaded as a .so.
+var Plugin = &subscriber{}
+
+func main() {
+	if err := plugins.Serve(Plugin); err != nil {
+		log.Fatal(err)
+	}
+}
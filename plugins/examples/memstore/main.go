@@ -0,0 +1,21 @@
+// memstore is an example storage plugin: the in-memory repository, served
+// over RPC. It shows the shape of a backend kept out of the service's
+// binary (a SQL store with its driver, say); its data lives as long as
+// the plugin process.
+//
+//	go build -o bin/memstore ./plugins/examples/memstore
+//	go run ./Basic-Go/Basic2.go -plugins plugins/examples/plugins.json -store plugin
+package main
+
+import (
+	"log"
+
+	"Go-Internals/plugins"
+	"Go-Internals/users"
+)
+
+func main() {
+	if err := plugins.Serve(users.NewInMemoryUserRepo()); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,50 @@
+// nodisposable is an example validator plugin: it refuses registrations
+// from throwaway email domains. The same code builds either way:
+//
+//	go build -o bin/nodisposable ./plugins/examples/nodisposable                     # "rpc"
+//	go build -buildmode=plugin -o bin/nodisposable.so ./plugins/examples/nodisposable # "go"
+//
+// As a Go plugin main does not run, so -domains cannot be set; the
+// default list applies.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"Go-Internals/plugins"
+	"Go-Internals/users"
+)
+
+type validator struct{ domains map[string]bool }
+
+func (v *validator) Validate(u users.User) error {
+	_, domain, _ := strings.Cut(u.Email, "@")
+	if v.domains[strings.ToLower(domain)] {
+		return users.Reject(fmt.Sprintf("disposable email domain %s", domain))
+	}
+	return nil
+}
+
+func newValidator(list string) *validator {
+	v := &validator{domains: map[string]bool{}}
+	for _, d := range strings.Split(list, ",") {
+		v.domains[strings.ToLower(strings.TrimSpace(d))] = true
+	}
+	return v
+}
+
+const defaultDomains = "mailinator.com,10minutemail.com,guerrillamail.com"
+
+// Plugin is the symbol the host looks up when loaded as a .so.
+var Plugin = newValidator(defaultDomains)
+
+func main() {
+	domains := flag.String("domains", defaultDomains, "comma-separated domains to refuse")
+	flag.Parse()
+	if err := plugins.Serve(newValidator(*domains)); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,44 @@
+package plugins
+
+import (
+	"plugin"
+	"reflect"
+)
+
+/*
+-----------------------------------
+GO PLUGINS
+-----------------------------------
+*/
+
+// openGo opens a .so and returns the implementation its symbol names.
+// Lookup gives the address of an exported variable, which is used as is
+// (methods with either receiver are found) unless the variable is itself
+// a pointer or interface, whose value is used. A func() any or
+// func() (any, error) symbol is called as a constructor. Opening the
+// same path twice returns the already loaded plugin.
+func openGo(spec Spec) (any, error) {
+	p, err := plugin.Open(spec.Path)
+	if err != nil {
+		return nil, err
+	}
+	name := spec.Symbol
+	if name == "" {
+		name = "Plugin"
+	}
+	sym, err := p.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	switch v := sym.(type) {
+	case func() any:
+		return v(), nil
+	case func() (any, error):
+		return v()
+	}
+	// var Plugin = &impl{} looks up as **impl: use the pointer it holds.
+	if v := reflect.ValueOf(sym).Elem(); (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && !v.IsNil() {
+		return v.Interface(), nil
+	}
+	return sym, nil
+}
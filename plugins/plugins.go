@@ -0,0 +1,340 @@
+// Package plugins loads extensions named in a manifest: registration
+// validators, event subscribers and storage backends, written outside
+// this repository and picked at startup instead of compiled in.
+//
+// Two transports, chosen per plugin:
+//
+//   - "go": a Go plugin (.so, go build -buildmode=plugin) opened in
+//     process with the standard plugin package. Calls are plain method
+//     calls, but the plugin must be built by the same toolchain against
+//     the same versions of every shared package, it cannot be unloaded,
+//     and a panic in it is a panic in the service. Linux and macOS only.
+//   - "rpc": a subprocess speaking JSON-RPC (net/rpc/jsonrpc) over its
+//     stdin and stdout, the way hashicorp/go-plugin runs providers. It
+//     can be built by anything, crash on its own, and be killed after a
+//     timeout; a call costs a round trip through a pipe.
+//
+// A plugin has the same code either way. It implements Validator,
+// Subscriber or users.UserRepository and either exports it, as the
+// variable (or constructor function) named by the manifest, default
+// Plugin, or calls Serve from main. See plugins/examples.
+//
+// The manifest is JSON; relative paths are resolved against its
+// directory:
+//
+//	{"plugins": [
+//	  {"name": "no-disposable", "kind": "validator", "transport": "rpc",
+//	   "path": "bin/nodisposable", "args": ["-domains", "mailinator.com"]},
+//	  {"name": "event-log", "kind": "subscriber", "transport": "go",
+//	   "path": "bin/eventlog.so", "topics": ["user.created"]}
+//	]}
+//
+// Failures are loud at startup (a plugin that cannot be loaded stops
+// Load) and contained at run time: a validator that errors fails the
+// registration, a subscriber that errors is logged, and every call is
+// counted per plugin (Set.Stats).
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"Go-Internals/eventbus"
+	"Go-Internals/users"
+)
+
+// Kind is what a plugin extends.
+type Kind string
+
+const (
+	KindValidator  Kind = "validator"
+	KindSubscriber Kind = "subscriber"
+	KindStorage    Kind = "storage"
+)
+
+// Transport is how a plugin is loaded.
+type Transport string
+
+const (
+	TransportGo  Transport = "go"
+	TransportRPC Transport = "rpc"
+)
+
+var (
+	ErrManifest = errors.New("plugins: invalid manifest")
+	// ErrKind means a plugin does not implement the kind the manifest
+	// gives it.
+	ErrKind = errors.New("plugins: wrong kind")
+)
+
+// Validator vets a registration. Return users.Reject(reason) to refuse
+// it; other errors fail the request.
+type Validator interface {
+	Validate(u users.User) error
+}
+
+// Subscriber receives the events of its topics. The payload is JSON, the
+// same for both transports: a users.User for the user.* topics.
+type Subscriber interface {
+	Handle(topic string, payload json.RawMessage) error
+}
+
+/*
+-----------------------------------
+MANIFEST
+-----------------------------------
+*/
+
+// Manifest lists the plugins to load, in order: validators run in
+// manifest order.
+type Manifest struct {
+	Plugins []Spec `json:"plugins"`
+}
+
+// Spec is one plugin.
+type Spec struct {
+	Name      string    `json:"name"`
+	Kind      Kind      `json:"kind"`
+	Transport Transport `json:"transport"`
+	Path      string    `json:"path"`
+	// Symbol is the exported variable of a Go plugin; default "Plugin".
+	Symbol string `json:"symbol,omitempty"`
+	// Args and Env are passed to an RPC plugin's process.
+	Args []string          `json:"args,omitempty"`
+	Env  map[string]string `json:"env,omitempty"`
+	// Topics a subscriber receives; default every user.* topic.
+	Topics []string `json:"topics,omitempty"`
+	// Timeout bounds each call to an RPC plugin, e.g. "500ms"; default 2s.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+func (s Spec) timeout() time.Duration {
+	d, err := time.ParseDuration(s.Timeout)
+	if err != nil || d <= 0 {
+		return 2 * time.Second
+	}
+	return d
+}
+
+// LoadManifest reads and checks a manifest file.
+func LoadManifest(path string) (Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Manifest{}, fmt.Errorf("%w: %s: %v", ErrManifest, path, err)
+	}
+	seen := map[string]bool{}
+	storage := 0
+	for i := range m.Plugins {
+		s := &m.Plugins[i]
+		switch {
+		case s.Name == "":
+			return m, fmt.Errorf("%w: plugin %d has no name", ErrManifest, i)
+		case seen[s.Name]:
+			return m, fmt.Errorf("%w: plugin %q listed twice", ErrManifest, s.Name)
+		case !slices.Contains([]Kind{KindValidator, KindSubscriber, KindStorage}, s.Kind):
+			return m, fmt.Errorf("%w: plugin %q: unknown kind %q", ErrManifest, s.Name, s.Kind)
+		case s.Transport != TransportGo && s.Transport != TransportRPC:
+			return m, fmt.Errorf("%w: plugin %q: unknown transport %q", ErrManifest, s.Name, s.Transport)
+		case s.Path == "":
+			return m, fmt.Errorf("%w: plugin %q has no path", ErrManifest, s.Name)
+		}
+		if s.Kind == KindStorage {
+			if storage++; storage > 1 {
+				return m, fmt.Errorf("%w: more than one storage plugin", ErrManifest)
+			}
+		}
+		if s.Timeout != "" {
+			if _, err := time.ParseDuration(s.Timeout); err != nil {
+				return m, fmt.Errorf("%w: plugin %q: timeout: %v", ErrManifest, s.Name, err)
+			}
+		}
+		if !filepath.IsAbs(s.Path) {
+			s.Path = filepath.Join(filepath.Dir(path), s.Path)
+		}
+		seen[s.Name] = true
+	}
+	return m, nil
+}
+
+/*
+-----------------------------------
+LOADED SET
+-----------------------------------
+*/
+
+// Set is the loaded plugins of a manifest.
+type Set struct {
+	plugins     []*loaded
+	validators  []*loaded
+	subscribers []*loaded
+	storage     users.UserRepository
+}
+
+type loaded struct {
+	spec  Spec
+	impl  any      // Validator, Subscriber or users.UserRepository
+	proc  *process // RPC plugins only
+	calls atomic.Int64
+	fails atomic.Int64
+}
+
+// Stat is one plugin's call counters.
+type Stat struct {
+	Kind      Kind      `json:"kind"`
+	Transport Transport `json:"transport"`
+	Calls     int64     `json:"calls"`
+	Failures  int64     `json:"failures"`
+}
+
+// Load reads the manifest at path and loads every plugin in it.
+func Load(path string) (*Set, error) {
+	m, err := LoadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	return Open(m)
+}
+
+// Open loads every plugin of m. If one fails, those already started are
+// stopped again.
+func Open(m Manifest) (*Set, error) {
+	s := &Set{}
+	for _, spec := range m.Plugins {
+		l, err := open(spec)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("plugin %s: %w", spec.Name, err)
+		}
+		s.plugins = append(s.plugins, l)
+		switch spec.Kind {
+		case KindValidator:
+			s.validators = append(s.validators, l)
+		case KindSubscriber:
+			s.subscribers = append(s.subscribers, l)
+		case KindStorage:
+			s.storage = l.impl.(users.UserRepository)
+		}
+	}
+	return s, nil
+}
+
+func open(spec Spec) (*loaded, error) {
+	l := &loaded{spec: spec}
+	var err error
+	if spec.Transport == TransportGo {
+		l.impl, err = openGo(spec)
+	} else {
+		l.proc, err = start(spec)
+		if err == nil {
+			l.impl = l.proc.remote(spec.Kind)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ok bool
+	switch spec.Kind {
+	case KindValidator:
+		_, ok = l.impl.(Validator)
+	case KindSubscriber:
+		_, ok = l.impl.(Subscriber)
+	case KindStorage:
+		_, ok = l.impl.(users.UserRepository)
+	}
+	if !ok {
+		if l.proc != nil {
+			l.proc.Close()
+		}
+		return nil, fmt.Errorf("%w: %T is not a %s", ErrKind, l.impl, spec.Kind)
+	}
+	return l, nil
+}
+
+// count records the outcome of one call.
+func (l *loaded) count(err error) error {
+	l.calls.Add(1)
+	if err != nil && !errors.Is(err, users.ErrInvalidInput) {
+		l.fails.Add(1)
+	}
+	return err
+}
+
+// Validate runs every validator plugin on u, for users.WithValidator.
+func (s *Set) Validate(_ context.Context, u users.User) error {
+	for _, l := range s.validators {
+		if err := l.count(l.impl.(Validator).Validate(u)); err != nil {
+			if errors.Is(err, users.ErrInvalidInput) {
+				return err
+			}
+			return fmt.Errorf("plugins: validator %s: %w", l.spec.Name, err)
+		}
+	}
+	return nil
+}
+
+// HasValidators reports whether the manifest had any validator.
+func (s *Set) HasValidators() bool { return len(s.validators) > 0 }
+
+// Subscribe attaches every subscriber plugin to bus. Delivery is
+// asynchronous (eventbus queues per subscriber) and a failing handler is
+// logged, not retried.
+func (s *Set) Subscribe(bus *eventbus.Bus) error {
+	for _, l := range s.subscribers {
+		topics := l.spec.Topics
+		if len(topics) == 0 {
+			topics = []string{users.TopicUserCreated, users.TopicUserDeleted}
+		}
+		h := func(ev eventbus.Event) {
+			payload, err := json.Marshal(ev.Payload)
+			if err == nil {
+				err = l.count(l.impl.(Subscriber).Handle(ev.Topic, payload))
+			}
+			if err != nil {
+				slog.Warn("plugin subscriber failed", "plugin", l.spec.Name, "topic", ev.Topic, "err", err)
+			}
+		}
+		for _, t := range topics {
+			if _, err := bus.Subscribe(t, h, eventbus.SubscribeOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Storage returns the storage plugin, if the manifest has one.
+func (s *Set) Storage() (users.UserRepository, bool) { return s.storage, s.storage != nil }
+
+// Stats returns every plugin's counters by name. Storage calls are not
+// counted here; wrap the repository in users.Instrument for those.
+func (s *Set) Stats() map[string]Stat {
+	out := make(map[string]Stat, len(s.plugins))
+	for _, l := range s.plugins {
+		out[l.spec.Name] = Stat{Kind: l.spec.Kind, Transport: l.spec.Transport, Calls: l.calls.Load(), Failures: l.fails.Load()}
+	}
+	return out
+}
+
+// Close stops every RPC plugin. Go plugins stay loaded: the runtime
+// cannot unload them.
+func (s *Set) Close() error {
+	var errs []error
+	for _, l := range s.plugins {
+		if l.proc != nil {
+			errs = append(errs, l.proc.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,317 @@
+package plugins
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"Go-Internals/query"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+RPC PROTOCOL
+-----------------------------------
+*/
+
+// The host starts a plugin with CookieEnv set to Cookie, so a plugin run
+// by hand can say what it is instead of waiting on its stdin. The plugin
+// answers with one handshake line on stdout,
+//
+//	gointernals-plugin|1|validator,subscriber
+//
+// (protocol version, then the kinds it serves), and from then on stdin
+// and stdout carry JSON-RPC; stderr is the plugin's log, forwarded to the
+// host's with the plugin's name.
+const (
+	CookieEnv       = "GOINTERNALS_PLUGIN"
+	Cookie          = "d6f1c1e8-users-plugin"
+	ProtocolVersion = 1
+	handshakeMagic  = "gointernals-plugin"
+)
+
+// handshakeTimeout bounds how long a plugin may take to start.
+const handshakeTimeout = 10 * time.Second
+
+// Wire types. Storage results carry a code for the repository's sentinel
+// errors, so they survive the trip as errors.Is-able values.
+type (
+	ValidateReply struct {
+		Rejected string `json:"rejected,omitempty"`
+	}
+	EventArgs struct {
+		Topic   string          `json:"topic"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	Empty     struct{}
+	UserReply struct {
+		User users.User `json:"user"`
+		Code string     `json:"code,omitempty"`
+	}
+	UsersReply struct {
+		Users []users.User `json:"users"`
+	}
+)
+
+const (
+	codeNotFound    = "not_found"
+	codeEmailTaken  = "email_taken"
+	codeExists      = "exists"
+	codeUnsupported = "unsupported"
+)
+
+var codes = []struct {
+	code string
+	err  error
+}{
+	{codeNotFound, users.ErrUserNotFound},
+	{codeEmailTaken, users.ErrEmailTaken},
+	{codeExists, users.ErrUserExists},
+	{codeUnsupported, errors.ErrUnsupported},
+}
+
+func codeOf(err error) (string, error) {
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code, nil
+		}
+	}
+	return "", err
+}
+
+func errOf(code string) error {
+	for _, c := range codes {
+		if c.code == code {
+			return c.err
+		}
+	}
+	if code != "" {
+		return fmt.Errorf("plugins: unknown error code %q", code)
+	}
+	return nil
+}
+
+/*
+-----------------------------------
+HOST SIDE
+-----------------------------------
+*/
+
+// process is a running RPC plugin.
+type process struct {
+	name    string
+	cmd     *exec.Cmd
+	client  *rpc.Client
+	kinds   []Kind
+	timeout time.Duration
+	exited  chan struct{}
+}
+
+func start(spec Spec) (*process, error) {
+	cmd := exec.Command(spec.Path, spec.Args...)
+	cmd.Env = append(os.Environ(), CookieEnv+"="+Cookie)
+	for k, v := range spec.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &process{name: spec.Name, cmd: cmd, timeout: spec.timeout(), exited: make(chan struct{})}
+	go func() {
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			slog.Info("plugin: "+sc.Text(), "plugin", spec.Name)
+		}
+	}()
+	go func() {
+		err := cmd.Wait()
+		slog.Debug("plugin exited", "plugin", spec.Name, "err", err)
+		close(p.exited)
+	}()
+
+	out := bufio.NewReader(stdout)
+	line := make(chan string, 1)
+	go func() {
+		l, _ := out.ReadString('\n')
+		line <- l
+	}()
+	var hs string
+	select {
+	case hs = <-line:
+	case <-p.exited:
+		return nil, errors.New("exited before the handshake")
+	case <-time.After(handshakeTimeout):
+		cmd.Process.Kill()
+		return nil, errors.New("no handshake")
+	}
+	if p.kinds, err = parseHandshake(hs); err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+	if !slices.Contains(p.kinds, spec.Kind) {
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("%w: it serves %v, not %s", ErrKind, p.kinds, spec.Kind)
+	}
+	p.client = rpc.NewClientWithCodec(jsonrpc.NewClientCodec(pipe{out, stdin}))
+	return p, nil
+}
+
+func parseHandshake(line string) ([]Kind, error) {
+	parts := strings.Split(strings.TrimSpace(line), "|")
+	if len(parts) != 3 || parts[0] != handshakeMagic {
+		return nil, fmt.Errorf("bad handshake %q (is it a plugin?)", strings.TrimSpace(line))
+	}
+	if v, err := strconv.Atoi(parts[1]); err != nil || v != ProtocolVersion {
+		return nil, fmt.Errorf("plugin speaks protocol %s, want %d", parts[1], ProtocolVersion)
+	}
+	var kinds []Kind
+	for _, k := range strings.Split(parts[2], ",") {
+		kinds = append(kinds, Kind(k))
+	}
+	return kinds, nil
+}
+
+// pipe joins the plugin's stdout and stdin into the codec's connection.
+type pipe struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// call is one RPC, bounded by the plugin's timeout. A call that times out
+// is abandoned, not cancelled: the plugin may still finish it.
+func (p *process) call(method string, args, reply any) error {
+	c := p.client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-c.Done:
+		if c.Error != nil {
+			return fmt.Errorf("%s: %w", method, c.Error)
+		}
+		return nil
+	case <-time.After(p.timeout):
+		return fmt.Errorf("%s: %w after %v", method, context.DeadlineExceeded, p.timeout)
+	}
+}
+
+// Close closes the plugin's stdin, which ends Serve, and kills the
+// process if it has not exited within a second.
+func (p *process) Close() error {
+	err := p.client.Close()
+	select {
+	case <-p.exited:
+	case <-time.After(time.Second):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+	if errors.Is(err, rpc.ErrShutdown) {
+		err = nil // it had already gone
+	}
+	return err
+}
+
+func (p *process) remote(kind Kind) any {
+	switch kind {
+	case KindValidator:
+		return remoteValidator{p}
+	case KindSubscriber:
+		return remoteSubscriber{p}
+	default:
+		return &remoteRepo{p}
+	}
+}
+
+type remoteValidator struct{ p *process }
+
+func (v remoteValidator) Validate(u users.User) error {
+	var r ValidateReply
+	if err := v.p.call("Validator.Validate", u, &r); err != nil {
+		return err
+	}
+	if r.Rejected != "" {
+		return users.Reject(r.Rejected)
+	}
+	return nil
+}
+
+type remoteSubscriber struct{ p *process }
+
+func (s remoteSubscriber) Handle(topic string, payload json.RawMessage) error {
+	return s.p.call("Subscriber.Handle", EventArgs{Topic: topic, Payload: payload}, &Empty{})
+}
+
+// remoteRepo is a users.UserRepository (and Restorer) served by a plugin.
+// The plugin implements the five basic methods; Search and Iterate are
+// answered here on top of List, as the mmap store does. List cannot
+// return an error, so a failing List is logged and returns nothing.
+type remoteRepo struct{ p *process }
+
+func (r *remoteRepo) one(method string, args any) (users.User, error) {
+	var reply UserReply
+	if err := r.p.call(method, args, &reply); err != nil {
+		return users.User{}, err
+	}
+	return reply.User, errOf(reply.Code)
+}
+
+func (r *remoteRepo) Create(u users.User) (users.User, error) { return r.one("Storage.Create", u) }
+func (r *remoteRepo) GetByID(id int) (users.User, error)      { return r.one("Storage.GetByID", id) }
+func (r *remoteRepo) Update(u users.User) (users.User, error) { return r.one("Storage.Update", u) }
+
+func (r *remoteRepo) Delete(id int) error {
+	_, err := r.one("Storage.Delete", id)
+	return err
+}
+
+func (r *remoteRepo) Restore(u users.User) error {
+	_, err := r.one("Storage.Restore", u)
+	return err
+}
+
+func (r *remoteRepo) List() []users.User {
+	var reply UsersReply
+	if err := r.p.call("Storage.List", Empty{}, &reply); err != nil {
+		slog.Error("plugin storage list failed", "plugin", r.p.name, "err", err)
+		return nil
+	}
+	return reply.Users
+}
+
+func (r *remoteRepo) Search(spec query.Spec) ([]users.User, error) {
+	result, err := users.Filter(r.List(), spec)
+	if err != nil {
+		return nil, err
+	}
+	users.SortByCreated(result)
+	return result, nil
+}
+
+func (r *remoteRepo) Iterate(ctx context.Context, opts users.IterateOptions) iter.Seq2[users.User, error] {
+	return func(yield func(users.User, error) bool) {
+		users.YieldBatch(ctx, r.List(), opts.Filter, yield)
+	}
+}
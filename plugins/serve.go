@@ -0,0 +1,121 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"strings"
+
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+PLUGIN SIDE
+-----------------------------------
+*/
+
+// ErrNotLaunched is returned by Serve when the process was not started
+// by the host.
+var ErrNotLaunched = errors.New("plugins: this is a plugin; list it in the service's plugin manifest instead of running it")
+
+// Serve runs impl as an RPC plugin until the host closes the connection.
+// Call it from the plugin's main. impl may implement any of Validator,
+// Subscriber and users.UserRepository; it serves each kind it implements.
+//
+// The protocol owns stdout, so Serve points os.Stdout at stderr first:
+// anything the plugin prints ends up in the host's log rather than
+// corrupting the stream.
+func Serve(impl any) error {
+	if os.Getenv(CookieEnv) != Cookie {
+		return ErrNotLaunched
+	}
+	srv := rpc.NewServer()
+	var kinds []string
+	if v, ok := impl.(Validator); ok {
+		srv.RegisterName("Validator", &validatorServer{v})
+		kinds = append(kinds, string(KindValidator))
+	}
+	if s, ok := impl.(Subscriber); ok {
+		srv.RegisterName("Subscriber", &subscriberServer{s})
+		kinds = append(kinds, string(KindSubscriber))
+	}
+	if r, ok := impl.(users.UserRepository); ok {
+		srv.RegisterName("Storage", &storageServer{r})
+		kinds = append(kinds, string(KindStorage))
+	}
+	if len(kinds) == 0 {
+		return fmt.Errorf("%w: %T implements no plugin kind", ErrKind, impl)
+	}
+
+	proto := os.Stdout
+	os.Stdout = os.Stderr
+	fmt.Fprintf(proto, "%s|%d|%s\n", handshakeMagic, ProtocolVersion, strings.Join(kinds, ","))
+	srv.ServeCodec(jsonrpc.NewServerCodec(stdio{os.Stdin, proto}))
+	return nil
+}
+
+type stdio struct {
+	io.Reader
+	io.WriteCloser
+}
+
+type validatorServer struct{ v Validator }
+
+func (s *validatorServer) Validate(u users.User, reply *ValidateReply) error {
+	err := s.v.Validate(u)
+	if errors.Is(err, users.ErrInvalidInput) {
+		reply.Rejected = err.Error()
+		return nil
+	}
+	return err
+}
+
+type subscriberServer struct{ s Subscriber }
+
+func (s *subscriberServer) Handle(ev EventArgs, _ *Empty) error {
+	return s.s.Handle(ev.Topic, ev.Payload)
+}
+
+type storageServer struct{ r users.UserRepository }
+
+func (s *storageServer) result(u users.User, err error, reply *UserReply) error {
+	reply.User = u
+	reply.Code, err = codeOf(err)
+	return err
+}
+
+func (s *storageServer) Create(u users.User, reply *UserReply) error {
+	u, err := s.r.Create(u)
+	return s.result(u, err, reply)
+}
+
+func (s *storageServer) GetByID(id int, reply *UserReply) error {
+	u, err := s.r.GetByID(id)
+	return s.result(u, err, reply)
+}
+
+func (s *storageServer) Update(u users.User, reply *UserReply) error {
+	u, err := s.r.Update(u)
+	return s.result(u, err, reply)
+}
+
+func (s *storageServer) Delete(id int, reply *UserReply) error {
+	return s.result(users.User{}, s.r.Delete(id), reply)
+}
+
+func (s *storageServer) Restore(u users.User, reply *UserReply) error {
+	r, ok := s.r.(users.Restorer)
+	if !ok {
+		return s.result(users.User{}, errors.ErrUnsupported, reply)
+	}
+	return s.result(users.User{}, r.Restore(u), reply)
+}
+
+func (s *storageServer) List(_ Empty, reply *UsersReply) error {
+	reply.Users = s.r.List()
+	return nil
+}
@@ -0,0 +1,54 @@
+package privacy
+
+import (
+	"context"
+	"strconv"
+
+	"Go-Internals/audit"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+HOLDERS
+-----------------------------------
+*/
+
+// AuditTrail exposes the entries an audit ring keeps about a user
+// (Resource "user", the user's ID). Erasure redacts their metadata rather
+// than dropping them: who did what and when stays auditable, but not to
+// whom beyond a number that no longer resolves to a person.
+func AuditTrail(r *audit.Ring) Holder { return auditHolder{r} }
+
+type auditHolder struct{ r *audit.Ring }
+
+func (auditHolder) Name() string { return "audit" }
+
+func (h auditHolder) Export(_ context.Context, id int) (any, error) {
+	return h.r.Matching(aboutUser(id)), nil
+}
+
+func (h auditHolder) Erase(_ context.Context, id int) (int, error) {
+	return h.r.Redact(aboutUser(id), func(e *audit.Entry) { e.Meta = nil }), nil
+}
+
+func aboutUser(id int) func(audit.Entry) bool {
+	s := strconv.Itoa(id)
+	return func(e audit.Entry) bool { return e.Resource == "user" && e.ResourceID == s }
+}
+
+// ChangeLog exposes the in-memory repository's change feed, whose entries
+// carry full copies of the record before and after every mutation.
+func ChangeLog(r *users.InMemoryUserRepo) Holder { return changeHolder{r} }
+
+type changeHolder struct{ r *users.InMemoryUserRepo }
+
+func (changeHolder) Name() string { return "events" }
+
+func (h changeHolder) Export(_ context.Context, id int) (any, error) {
+	return h.r.ChangesFor(id), nil
+}
+
+func (h changeHolder) Erase(_ context.Context, id int) (int, error) {
+	return h.r.RedactChanges(id), nil
+}
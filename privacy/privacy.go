@@ -0,0 +1,237 @@
+// Package privacy implements data-subject requests: export everything
+// stored about a user, and erase it.
+//
+// A user's data is not only their record in the repository. Audit trails
+// name them, change logs carry copies of the record, and file-backed
+// stores keep old versions on disk until compaction. Each of those is a
+// Holder; a Manager runs a request against the repository and every
+// holder it was given, and reports per store what it found or did, so an
+// erasure that could only partly complete says so instead of looking
+// done.
+//
+// Erasure comes in two modes. Delete removes the record. Anonymize keeps
+// it (so IDs referenced elsewhere still resolve) but overwrites every
+// personal field. Either way holders then redact what they keep, and a
+// Purger, if configured, rewrites the storage so the old bytes are gone:
+// without that step a kv or mmap store would still have them on disk.
+//
+// Sessions: the service has none to revoke. Tokens are stateless JWTs
+// that expire on their own.
+package privacy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/clock"
+	"Go-Internals/ctxutil"
+	"Go-Internals/users"
+)
+
+// Holder is a store, other than the repository, that keeps data about
+// users.
+type Holder interface {
+	// Name identifies the store in archives and reports.
+	Name() string
+	// Export returns what the store holds about the user, JSON-encodable.
+	Export(ctx context.Context, id int) (any, error)
+	// Erase removes or redacts it and returns how many records changed.
+	Erase(ctx context.Context, id int) (int, error)
+}
+
+// Purger physically removes deleted data from a backend (kvstore.Store
+// and mmapstore.Store implement it).
+type Purger interface {
+	Purge(ctx context.Context) error
+}
+
+// Mode is how Erase treats the user record.
+type Mode string
+
+const (
+	Delete    Mode = "delete"
+	Anonymize Mode = "anonymize"
+)
+
+// ErrBadMode is returned for a Mode other than Delete or Anonymize.
+var ErrBadMode = errors.New("privacy: erase mode must be delete or anonymize")
+
+// Options configures New.
+type Options struct {
+	Repo    users.UserRepository
+	Holders []Holder
+	// Purge, if set, runs after every erasure.
+	Purge Purger
+	// Audit records each export and erasure; the record carries the user
+	// ID only. Default audit.Discard.
+	Audit audit.Sink
+	Clock clock.Clock
+}
+
+type Manager struct {
+	opts Options
+	clk  clock.Clock
+}
+
+func New(opts Options) *Manager {
+	if opts.Audit == nil {
+		opts.Audit = audit.Discard
+	}
+	return &Manager{opts: opts, clk: clock.OrReal(opts.Clock)}
+}
+
+/*
+-----------------------------------
+EXPORT
+-----------------------------------
+*/
+
+// Archive is everything stored about one user.
+type Archive struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Profile     users.User     `json:"profile"`
+	Stores      map[string]any `json:"stores"`
+}
+
+// Export collects the user's record and every holder's data. A failing
+// holder fails the export: an archive must be complete.
+func (m *Manager) Export(ctx context.Context, id int) (Archive, error) {
+	u, err := m.opts.Repo.GetByID(id)
+	if err != nil {
+		return Archive{}, err
+	}
+	a := Archive{GeneratedAt: m.clk.Now(), Profile: u, Stores: make(map[string]any, len(m.opts.Holders))}
+	for _, h := range m.opts.Holders {
+		data, err := h.Export(ctx, id)
+		if err != nil {
+			return Archive{}, fmt.Errorf("privacy: export from %s: %w", h.Name(), err)
+		}
+		a.Stores[h.Name()] = data
+	}
+	m.record(ctx, "export", id, nil)
+	return a, nil
+}
+
+/*
+-----------------------------------
+ERASURE
+-----------------------------------
+*/
+
+// Step is what one store did during an erasure.
+type Step struct {
+	Store   string `json:"store"`
+	Records int    `json:"records"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Report is the completion report of an erasure.
+type Report struct {
+	UserID   int       `json:"user_id"`
+	Mode     Mode      `json:"mode"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Steps    []Step    `json:"steps"`
+}
+
+// Complete reports whether every step succeeded.
+func (r Report) Complete() bool {
+	for _, s := range r.Steps {
+		if s.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Erase removes the user from the repository and every holder, then
+// purges storage. Only a failure on the repository itself stops it
+// early; a failing holder is recorded in the report and the rest still
+// run, so one broken store does not leave the others untouched. Delete
+// is idempotent so that such an erasure can simply be retried: a record
+// that is already gone counts as 0 and the holders run again.
+func (m *Manager) Erase(ctx context.Context, id int, mode Mode) (Report, error) {
+	rep := Report{UserID: id, Mode: mode, Started: m.clk.Now()}
+	n := 1
+	var meta map[string]string
+	switch mode {
+	case Delete:
+		err := m.opts.Repo.Delete(id)
+		if errors.Is(err, users.ErrUserNotFound) {
+			n = 0
+		} else if err != nil {
+			return rep, err
+		}
+	case Anonymize:
+		u, err := m.opts.Repo.GetByID(id)
+		if err != nil {
+			return rep, err
+		}
+		after, err := m.opts.Repo.Update(anonymized(u))
+		if err != nil {
+			return rep, err
+		}
+		meta, _ = audit.Changes(u, after)
+	default:
+		return rep, ErrBadMode
+	}
+	rep.Steps = append(rep.Steps, Step{Store: "users", Records: n})
+
+	for _, h := range m.opts.Holders {
+		n, err := h.Erase(ctx, id)
+		rep.Steps = append(rep.Steps, step(h.Name(), n, err))
+	}
+	if m.opts.Purge != nil {
+		rep.Steps = append(rep.Steps, step("storage purge", 0, m.opts.Purge.Purge(ctx)))
+	}
+	rep.Finished = m.clk.Now()
+	m.record(ctx, string(mode), id, meta)
+	return rep, nil
+}
+
+func step(store string, n int, err error) Step {
+	s := Step{Store: store, Records: n}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	return s
+}
+
+const anonymizedName = "anonymized"
+
+// anonymized keeps what carries no personal data: the ID and timestamps.
+// The email stays unique per user, since backends index it. The avatar
+// URL goes; the image itself is the avatar holder's to erase.
+func anonymized(u users.User) users.User {
+	return users.User{
+		ID:        u.ID,
+		Name:      anonymizedName,
+		Email:     anonymizedEmail(u.ID),
+		CreatedAt: u.CreatedAt,
+		ExpiresAt: u.ExpiresAt,
+	}
+}
+
+func anonymizedEmail(id int) string { return "erased-" + strconv.Itoa(id) + "@invalid" }
+
+// IsAnonymized reports whether u is the result of an Anonymize erasure.
+func IsAnonymized(u users.User) bool {
+	return u.Name == anonymizedName && u.Email == anonymizedEmail(u.ID)
+}
+
+// record is best effort, like the service's: the request already happened.
+// meta carries an anonymization's before/after (audit.Changes), with the
+// personal fields already redacted.
+func (m *Manager) record(ctx context.Context, action string, id int, meta map[string]string) {
+	_ = m.opts.Audit.Record(ctx, audit.Entry{
+		Actor:      ctxutil.UserID(ctx),
+		Action:     "privacy." + action,
+		Resource:   "user",
+		ResourceID: strconv.Itoa(id),
+		Meta:       meta,
+	})
+}
@@ -0,0 +1,158 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Getter returns a record's value for a field, or false if the field does
+// not exist.
+type Getter func(field string) (any, bool)
+
+// Match evaluates spec against one record.
+func Match(spec Spec, get Getter) (bool, error) {
+	switch s := spec.(type) {
+	case nil:
+		return true, nil
+
+	case And:
+		for _, child := range s {
+			ok, err := Match(child, get)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+
+	case Or:
+		for _, child := range s {
+			ok, err := Match(child, get)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case Not:
+		ok, err := Match(s.Spec, get)
+		return !ok && err == nil, err
+
+	case Cmp:
+		v, ok := get(s.Field)
+		if !ok {
+			return false, fmt.Errorf("%w: %q", ErrUnknownField, s.Field)
+		}
+		return compare(s, v)
+
+	default:
+		return false, fmt.Errorf("query: unsupported spec %T", spec)
+	}
+}
+
+func compare(c Cmp, have any) (bool, error) {
+	if c.Op == OpPrefix {
+		h, ok1 := have.(string)
+		p, ok2 := c.Value.(string)
+		if !ok1 || !ok2 {
+			return false, fmt.Errorf("%w: prefix on %q", ErrBadOperator, c.Field)
+		}
+		return strings.HasPrefix(h, p), nil
+	}
+
+	n, err := order(have, c.Value)
+	if err != nil {
+		return false, fmt.Errorf("%w: field %q", err, c.Field)
+	}
+
+	switch c.Op {
+	case OpEq:
+		return n == 0, nil
+	case OpNe:
+		return n != 0, nil
+	case OpLt:
+		return n < 0, nil
+	case OpLe:
+		return n <= 0, nil
+	case OpGt:
+		return n > 0, nil
+	case OpGe:
+		return n >= 0, nil
+	default:
+		return false, ErrBadOperator
+	}
+}
+
+// order compares a (record value) with b (spec value). Integers of any
+// width compare with each other; otherwise the types must match.
+func order(a, b any) (int, error) {
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, ErrTypeMismatch
+		}
+		return strings.Compare(x, y), nil
+
+	case time.Time:
+		y, ok := b.(time.Time)
+		if !ok {
+			return 0, ErrTypeMismatch
+		}
+		return x.Compare(y), nil
+
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, ErrTypeMismatch
+		}
+		switch {
+		case x == y:
+			return 0, nil
+		case !x:
+			return -1, nil
+		default:
+			return 1, nil
+		}
+	}
+
+	x, ok1 := toInt64(a)
+	y, ok2 := toInt64(b)
+	if !ok1 || !ok2 {
+		return 0, ErrTypeMismatch
+	}
+	switch {
+	case x < y:
+		return -1, nil
+	case x > y:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func toInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,120 @@
+// Package query lets callers describe which records they want without
+// knowing how a backend finds them.
+//
+// A Spec is a small expression tree (comparisons combined with And/Or/Not).
+// Backends translate it: the in-memory repository evaluates it with Match
+// (using an index when the shape allows), a SQL backend renders it with
+// ToSQL.
+package query
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrUnknownField = errors.New("query: unknown field")
+	ErrTypeMismatch = errors.New("query: value type does not match field")
+	ErrBadOperator  = errors.New("query: operator not supported for this value")
+)
+
+// Well-known field names shared by backends.
+const (
+	FieldID        = "id"
+	FieldName      = "name"
+	FieldEmail     = "email"
+	FieldCreatedAt = "created_at"
+)
+
+// Spec is a predicate over a record. The concrete types below are the only
+// implementations.
+type Spec interface {
+	isSpec()
+}
+
+// Op is a comparison operator.
+type Op int
+
+const (
+	OpEq Op = iota
+	OpNe
+	OpLt
+	OpLe
+	OpGt
+	OpGe
+	OpPrefix // strings only
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpEq:
+		return "="
+	case OpNe:
+		return "!="
+	case OpLt:
+		return "<"
+	case OpLe:
+		return "<="
+	case OpGt:
+		return ">"
+	case OpGe:
+		return ">="
+	case OpPrefix:
+		return "prefix"
+	default:
+		return "?"
+	}
+}
+
+// Cmp compares one field to a value.
+type Cmp struct {
+	Field string
+	Op    Op
+	Value any
+}
+
+// And matches when every child matches. An empty And matches everything.
+type And []Spec
+
+// Or matches when any child matches. An empty Or matches nothing.
+type Or []Spec
+
+// Not inverts its child.
+type Not struct{ Spec Spec }
+
+func (Cmp) isSpec() {}
+func (And) isSpec() {}
+func (Or) isSpec()  {}
+func (Not) isSpec() {}
+
+/*
+-----------------------------------
+CONSTRUCTORS
+-----------------------------------
+*/
+
+func Eq(field string, v any) Spec { return Cmp{field, OpEq, v} }
+func Ne(field string, v any) Spec { return Cmp{field, OpNe, v} }
+func Lt(field string, v any) Spec { return Cmp{field, OpLt, v} }
+func Le(field string, v any) Spec { return Cmp{field, OpLe, v} }
+func Gt(field string, v any) Spec { return Cmp{field, OpGt, v} }
+func Ge(field string, v any) Spec { return Cmp{field, OpGe, v} }
+
+// HasPrefix matches string fields starting with prefix.
+func HasPrefix(field, prefix string) Spec { return Cmp{field, OpPrefix, prefix} }
+
+func CreatedAfter(t time.Time) Spec  { return Gt(FieldCreatedAt, t) }
+func CreatedBefore(t time.Time) Spec { return Lt(FieldCreatedAt, t) }
+
+// CreatedBetween matches from <= created_at < to.
+func CreatedBetween(from, to time.Time) Spec {
+	return And{Ge(FieldCreatedAt, from), Lt(FieldCreatedAt, to)}
+}
+
+// All matches every record.
+func All() Spec { return And{} }
+
+// AllOf and AnyOf read better than the literal types at call sites.
+func AllOf(specs ...Spec) Spec { return And(specs) }
+func AnyOf(specs ...Spec) Spec { return Or(specs) }
+func Negate(s Spec) Spec       { return Not{s} }
@@ -0,0 +1,114 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Placeholder renders the n-th (1-based) bind parameter.
+type Placeholder func(n int) string
+
+// Question is the MySQL/SQLite style ("?").
+func Question(int) string { return "?" }
+
+// Dollar is the Postgres style ("$1", "$2", ...).
+func Dollar(n int) string { return "$" + strconv.Itoa(n) }
+
+// ToSQL renders spec as a WHERE clause body plus bind arguments.
+//
+// columns maps spec field names to SQL column names and doubles as a
+// whitelist: field names never reach the SQL text unless they are listed,
+// and values are always bound, never interpolated.
+func ToSQL(spec Spec, columns map[string]string, ph Placeholder) (string, []any, error) {
+	if ph == nil {
+		ph = Question
+	}
+	b := &sqlBuilder{columns: columns, ph: ph}
+	if err := b.write(spec); err != nil {
+		return "", nil, err
+	}
+	return b.sb.String(), b.args, nil
+}
+
+type sqlBuilder struct {
+	sb      strings.Builder
+	args    []any
+	columns map[string]string
+	ph      Placeholder
+}
+
+func (b *sqlBuilder) write(spec Spec) error {
+	switch s := spec.(type) {
+	case nil:
+		b.sb.WriteString("1=1")
+		return nil
+
+	case And:
+		return b.group(s, " AND ", "1=1")
+
+	case Or:
+		return b.group(s, " OR ", "1=0")
+
+	case Not:
+		b.sb.WriteString("NOT (")
+		if err := b.write(s.Spec); err != nil {
+			return err
+		}
+		b.sb.WriteString(")")
+		return nil
+
+	case Cmp:
+		col, ok := b.columns[s.Field]
+		if !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownField, s.Field)
+		}
+
+		if s.Op == OpPrefix {
+			p, ok := s.Value.(string)
+			if !ok {
+				return fmt.Errorf("%w: prefix on %q", ErrBadOperator, s.Field)
+			}
+			b.args = append(b.args, escapeLike(p)+"%")
+			fmt.Fprintf(&b.sb, "%s LIKE %s ESCAPE '\\'", col, b.ph(len(b.args)))
+			return nil
+		}
+
+		b.args = append(b.args, s.Value)
+		fmt.Fprintf(&b.sb, "%s %s %s", col, sqlOp(s.Op), b.ph(len(b.args)))
+		return nil
+
+	default:
+		return fmt.Errorf("query: unsupported spec %T", spec)
+	}
+}
+
+func (b *sqlBuilder) group(children []Spec, sep, empty string) error {
+	if len(children) == 0 {
+		b.sb.WriteString(empty)
+		return nil
+	}
+	b.sb.WriteString("(")
+	for i, child := range children {
+		if i > 0 {
+			b.sb.WriteString(sep)
+		}
+		if err := b.write(child); err != nil {
+			return err
+		}
+	}
+	b.sb.WriteString(")")
+	return nil
+}
+
+func sqlOp(o Op) string {
+	if o == OpNe {
+		return "<>"
+	}
+	return o.String()
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
@@ -0,0 +1,230 @@
+// Package quota tracks per-tenant usage counters and enforces limits.
+//
+// Counters are atomics, so the hot path (Consume) never takes a write lock
+// once a tenant's counter exists. Limits are checked with a CAS loop, so two
+// concurrent requests can never both squeeze under the last unit of quota.
+// Usage is persisted periodically (not on every increment) through a Store.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Resource names a counted thing.
+type Resource string
+
+const (
+	APICalls     Resource = "api_calls"
+	StorageItems Resource = "storage_items"
+)
+
+// Limits caps resources. A missing or zero entry means unlimited.
+type Limits map[Resource]int64
+
+// Usage is a snapshot of tenant → resource → count.
+type Usage map[string]map[Resource]int64
+
+// Config configures a Tracker.
+type Config struct {
+	Default   Limits            // applied to tenants without an override
+	PerTenant map[string]Limits // overrides, merged over Default
+
+	Store        Store         // nil disables persistence
+	PersistEvery time.Duration // default 30s
+	Clock        clock.Clock
+}
+
+// Tracker is safe for concurrent use.
+type Tracker struct {
+	mu       sync.RWMutex
+	counters map[string]map[Resource]*atomic.Int64
+	limits   map[string]Limits
+	defaults Limits
+
+	store        Store
+	persistEvery time.Duration
+	clk          clock.Clock
+	dirty        atomic.Bool
+}
+
+// New creates a tracker and loads persisted usage, if any.
+func New(cfg Config) (*Tracker, error) {
+	t := &Tracker{
+		counters:     make(map[string]map[Resource]*atomic.Int64),
+		limits:       make(map[string]Limits),
+		defaults:     maps.Clone(cfg.Default),
+		store:        cfg.Store,
+		persistEvery: cfg.PersistEvery,
+		clk:          clock.OrReal(cfg.Clock),
+	}
+	if t.persistEvery <= 0 {
+		t.persistEvery = 30 * time.Second
+	}
+	for tenant, l := range cfg.PerTenant {
+		t.limits[tenant] = maps.Clone(l)
+	}
+
+	if t.store != nil {
+		usage, err := t.store.Load()
+		if err != nil {
+			return nil, fmt.Errorf("quota: load usage: %w", err)
+		}
+		for tenant, res := range usage {
+			for r, n := range res {
+				t.counter(tenant, r).Store(n)
+			}
+		}
+	}
+	return t, nil
+}
+
+// Consume adds n to the tenant's counter, or returns ErrQuotaExceeded
+// (leaving the counter unchanged) if that would cross the limit.
+func (t *Tracker) Consume(tenant string, r Resource, n int64) error {
+	c := t.counter(tenant, r)
+	limit := t.Limit(tenant, r)
+
+	for {
+		cur := c.Load()
+		if limit > 0 && cur+n > limit {
+			return fmt.Errorf("%w: tenant %q %s %d/%d", ErrQuotaExceeded, tenant, r, cur, limit)
+		}
+		if c.CompareAndSwap(cur, cur+n) {
+			t.dirty.Store(true)
+			return nil
+		}
+	}
+}
+
+// Release gives n units back (e.g. a stored item was deleted or a write
+// failed after Consume). Counters never go below zero.
+func (t *Tracker) Release(tenant string, r Resource, n int64) {
+	c := t.counter(tenant, r)
+	for {
+		cur := c.Load()
+		next := max(cur-n, 0)
+		if c.CompareAndSwap(cur, next) {
+			t.dirty.Store(true)
+			return
+		}
+	}
+}
+
+// Limit returns the effective limit for tenant (0 = unlimited).
+func (t *Tracker) Limit(tenant string, r Resource) int64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if l, ok := t.limits[tenant]; ok {
+		if n, ok := l[r]; ok {
+			return n
+		}
+	}
+	return t.defaults[r]
+}
+
+// SetLimits replaces a tenant's overrides at runtime.
+func (t *Tracker) SetLimits(tenant string, l Limits) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limits[tenant] = maps.Clone(l)
+}
+
+// Usage returns a snapshot of every counter.
+func (t *Tracker) Usage() Usage {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	out := make(Usage, len(t.counters))
+	for tenant, res := range t.counters {
+		m := make(map[Resource]int64, len(res))
+		for r, c := range res {
+			m[r] = c.Load()
+		}
+		out[tenant] = m
+	}
+	return out
+}
+
+// Reset zeroes resource r for every tenant, e.g. at the start of a billing
+// period for API-call quotas.
+func (t *Tracker) Reset(r Resource) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, res := range t.counters {
+		if c, ok := res[r]; ok {
+			c.Store(0)
+		}
+	}
+	t.dirty.Store(true)
+}
+
+// Flush persists usage now if anything changed since the last save.
+func (t *Tracker) Flush() error {
+	if t.store == nil || !t.dirty.Swap(false) {
+		return nil
+	}
+	if err := t.store.Save(t.Usage()); err != nil {
+		t.dirty.Store(true) // retry next time
+		return err
+	}
+	return nil
+}
+
+// Run persists usage every PersistEvery until ctx is done, then flushes
+// one last time.
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := t.clk.NewTicker(t.persistEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := t.Flush(); err != nil {
+				log.Println("quota: final flush:", err)
+			}
+			return
+		case <-ticker.C():
+			if err := t.Flush(); err != nil {
+				log.Println("quota: persist:", err)
+			}
+		}
+	}
+}
+
+// counter returns the tenant's counter, creating it on first use. The
+// common case only takes the read lock.
+func (t *Tracker) counter(tenant string, r Resource) *atomic.Int64 {
+	t.mu.RLock()
+	c := t.counters[tenant][r]
+	t.mu.RUnlock()
+	if c != nil {
+		return c
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := t.counters[tenant]
+	if res == nil {
+		res = make(map[Resource]*atomic.Int64)
+		t.counters[tenant] = res
+	}
+	if c = res[r]; c == nil {
+		c = new(atomic.Int64)
+		res[r] = c
+	}
+	return c
+}
@@ -0,0 +1,166 @@
+package quota
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"Go-Internals/atrest"
+	"Go-Internals/integrity"
+)
+
+// Store persists usage snapshots.
+type Store interface {
+	Load() (Usage, error)
+	Save(Usage) error
+}
+
+// FileStore keeps usage in a JSON file, replaced atomically on each save
+// (write temp file, fsync, rename) so a crash never leaves half a file.
+//
+// Each tenant's usage is stored with a CRC32 so damage is contained: a
+// tenant whose record fails its checksum is quarantined (see package
+// integrity) and starts from zero, and a file that no longer parses is
+// quarantined whole, instead of Load failing and the tracker refusing to
+// start. Files written before checksums existed (a bare usage map) still
+// load.
+//
+// With Keys the whole file is sealed (atrest.Keyring.Seal) on save, since
+// tenant names are as sensitive as the records. Plaintext files still
+// load, so turning encryption on needs no migration step.
+type FileStore struct {
+	Path string
+	// OnProblem hears about quarantined data; default logs a warning.
+	OnProblem func(integrity.Problem)
+	Keys      *atrest.Keyring
+}
+
+// ErrNoKeys is returned by Load for a sealed file when Keys is nil.
+var ErrNoKeys = errors.New("quota: usage file is encrypted and no keyring is configured")
+
+var sealAAD = []byte("quota")
+
+const fileVersion = 2
+
+type fileFormat struct {
+	Version int                     `json:"version"`
+	Tenants map[string]tenantRecord `json:"tenants"`
+}
+
+type tenantRecord struct {
+	CRC32 uint32          `json:"crc32"`
+	Usage json.RawMessage `json:"usage"`
+}
+
+func (f FileStore) Load() (Usage, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Usage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if atrest.IsSealed(data) {
+		if f.Keys == nil {
+			return nil, ErrNoKeys
+		}
+		data, err = f.Keys.Open(data, sealAAD)
+		if errors.Is(err, atrest.ErrDecrypt) {
+			return f.quarantine("does not decrypt; usage starts from zero")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var ff fileFormat
+	if err := json.Unmarshal(data, &ff); err != nil || ff.Version == 0 {
+		var u Usage
+		if lerr := json.Unmarshal(data, &u); lerr == nil {
+			return u, nil // pre-checksum file
+		}
+		return f.quarantine("does not parse; usage starts from zero")
+	}
+
+	u := make(Usage, len(ff.Tenants))
+	var buf bytes.Buffer
+	for tenant, rec := range ff.Tenants {
+		buf.Reset()
+		var counts map[Resource]int64
+		if json.Compact(&buf, rec.Usage) == nil && crc32.ChecksumIEEE(buf.Bytes()) == rec.CRC32 &&
+			json.Unmarshal(buf.Bytes(), &counts) == nil {
+			u[tenant] = counts
+			continue
+		}
+		p := integrity.Problem{File: f.Path, Offset: -1, Detail: fmt.Sprintf("tenant %q fails its checksum; usage starts from zero", tenant)}
+		raw, _ := json.Marshal(map[string]tenantRecord{tenant: rec})
+		if p.Quarantined, err = integrity.Save(filepath.Dir(f.Path), f.Path, -1, raw); err != nil {
+			return nil, err
+		}
+		f.report(p)
+	}
+	return u, nil
+}
+
+// quarantine moves the whole file aside.
+func (f FileStore) quarantine(detail string) (Usage, error) {
+	p := integrity.Problem{File: f.Path, Offset: -1, Detail: detail}
+	var err error
+	if p.Quarantined, err = integrity.Move(filepath.Dir(f.Path), f.Path); err != nil {
+		return nil, err
+	}
+	f.report(p)
+	return Usage{}, nil
+}
+
+func (f FileStore) report(p integrity.Problem) {
+	if f.OnProblem != nil {
+		f.OnProblem(p)
+		return
+	}
+	slog.Warn("quota: quarantined usage data", "problem", p.String())
+}
+
+func (f FileStore) Save(u Usage) error {
+	ff := fileFormat{Version: fileVersion, Tenants: make(map[string]tenantRecord, len(u))}
+	for tenant, counts := range u {
+		raw, err := json.Marshal(counts)
+		if err != nil {
+			return err
+		}
+		ff.Tenants[tenant] = tenantRecord{CRC32: crc32.ChecksumIEEE(raw), Usage: raw}
+	}
+	data, err := json.MarshalIndent(ff, "", "  ")
+	if err != nil {
+		return err
+	}
+	if f.Keys != nil {
+		if data, err = f.Keys.Seal(data, sealAAD); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
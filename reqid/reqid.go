@@ -0,0 +1,62 @@
+// Package reqid gives every request an ID, carried in its context and in
+// the X-Request-ID response header, so an access log line, a slow
+// operation report and a client's bug report can be matched up.
+package reqid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// Header is where the ID is read from a request and written to the
+// response.
+const Header = "X-Request-ID"
+
+type ctxKey struct{}
+
+// With returns a context carrying request id.
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// From returns the request ID in ctx, or "".
+func From(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// New returns a random 16-hex-digit ID.
+func New() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Middleware keeps the caller's X-Request-ID if it looks like one (a
+// proxy in front may have set it) and makes one up otherwise.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !valid(id) {
+			id = New()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(With(r.Context(), id)))
+	})
+}
+
+// valid accepts 1 to 64 letters, digits, '-', '_' and '.': enough for
+// UUIDs and trace IDs, nothing that could break a log line.
+func valid(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
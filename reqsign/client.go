@@ -0,0 +1,104 @@
+package reqsign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"Go-Internals/clock"
+)
+
+/*
+-----------------------------------
+SIGNING
+-----------------------------------
+*/
+
+// Signer signs requests with one key.
+type Signer struct {
+	key  Key
+	priv ed25519.PrivateKey
+	// Clock defaults to clock.Real().
+	Clock clock.Clock
+}
+
+// NewSigner signs with k, which needs its secret: an Ed25519 public key
+// alone only verifies.
+func NewSigner(k Key) (*Signer, error) {
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Signer{key: k}
+	if k.Alg == Ed25519 {
+		if len(k.Secret) != ed25519.SeedSize {
+			return nil, errors.New("reqsign: key " + k.ID + ": signing with Ed25519 needs the private seed")
+		}
+		s.priv = ed25519.NewKeyFromSeed(k.Secret)
+	}
+	return s, nil
+}
+
+// Sign adds the signature headers to r, reading its body to hash it and
+// putting it back.
+func (s *Signer) Sign(r *http.Request) error {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	}
+	ts := strconv.FormatInt(clock.OrReal(s.Clock).Now().Unix(), 10)
+	b := make([]byte, 16)
+	rand.Read(b)
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	d := digest(body)
+	msg := canonical(r, s.key.ID, ts, nonce, d)
+
+	r.Header.Set(HeaderKey, s.key.ID)
+	r.Header.Set(HeaderTimestamp, ts)
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set(HeaderDigest, d)
+	r.Header.Set(HeaderSignature, base64.StdEncoding.EncodeToString(s.sign(msg)))
+	return nil
+}
+
+func (s *Signer) sign(msg []byte) []byte {
+	if s.priv != nil {
+		return ed25519.Sign(s.priv, msg)
+	}
+	mac := hmac.New(sha256.New, s.key.Secret)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// Transport signs every request it sends with Signer, through Base
+// (default http.DefaultTransport). Give it to an http.Client for the
+// calls that must be signed.
+type Transport struct {
+	Signer *Signer
+	Base   http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// A RoundTripper must not change the request it is given.
+	r = r.Clone(r.Context())
+	if err := t.Signer.Sign(r); err != nil {
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r)
+}
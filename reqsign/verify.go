@@ -0,0 +1,189 @@
+package reqsign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/auth"
+	"Go-Internals/clock"
+)
+
+/*
+-----------------------------------
+VERIFICATION
+-----------------------------------
+*/
+
+// Options configures NewVerifier.
+type Options struct {
+	// Keys are the callers' keys; required.
+	Keys []Key
+	// Window is how far a signature's timestamp may be from now, either
+	// way; default 5 minutes. Nonces are remembered for twice as long.
+	Window time.Duration
+	// MaxBody is the largest body hashed to verify; default 32 MiB.
+	MaxBody int64
+	// Require lists path prefixes, such as "/debug/", whose requests
+	// must be signed; others may be, and are verified if they are.
+	Require []string
+	// Clock defaults to clock.Real().
+	Clock clock.Clock
+}
+
+type Verifier struct {
+	opts Options
+	clk  clock.Clock
+	keys map[string]Key
+
+	mu sync.Mutex
+	// seen is when each nonce used in the window may be forgotten.
+	seen  map[string]time.Time
+	sweep time.Time
+}
+
+func NewVerifier(opts Options) (*Verifier, error) {
+	if len(opts.Keys) == 0 {
+		return nil, errors.New("reqsign: no keys to verify with")
+	}
+	if opts.Window <= 0 {
+		opts.Window = 5 * time.Minute
+	}
+	if opts.MaxBody <= 0 {
+		opts.MaxBody = 32 << 20
+	}
+	v := &Verifier{opts: opts, clk: clock.OrReal(opts.Clock), keys: map[string]Key{}, seen: map[string]time.Time{}}
+	for _, k := range opts.Keys {
+		if err := k.Validate(); err != nil {
+			return nil, err
+		}
+		v.keys[k.ID] = k
+	}
+	return v, nil
+}
+
+// Verify checks r's signature and returns the key that made it. It
+// reads the body and puts it back.
+func (v *Verifier) Verify(r *http.Request) (Key, error) {
+	id := r.Header.Get(HeaderKey)
+	sig, err := base64.StdEncoding.DecodeString(r.Header.Get(HeaderSignature))
+	if id == "" || err != nil || len(sig) == 0 {
+		return Key{}, ErrUnsigned
+	}
+	k, ok := v.keys[id]
+	if !ok {
+		return Key{}, ErrUnknownKey
+	}
+	ts, nonce, d := r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce), r.Header.Get(HeaderDigest)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || nonce == "" {
+		return Key{}, ErrUnsigned
+	}
+	now := v.clk.Now()
+	if skew := now.Sub(time.Unix(sec, 0)); skew > v.opts.Window || skew < -v.opts.Window {
+		return Key{}, ErrStale
+	}
+	if !v.check(k, canonical(r, id, ts, nonce, d), sig) {
+		return Key{}, ErrBadSignature
+	}
+
+	// The signature covers the digest; the body must match it.
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(r.Body, v.opts.MaxBody+1))
+		if err != nil {
+			return Key{}, err
+		}
+		r.Body.Close()
+		if int64(len(body)) > v.opts.MaxBody {
+			return Key{}, ErrTooLarge
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if subtle.ConstantTimeCompare([]byte(digest(body)), []byte(d)) != 1 {
+		return Key{}, ErrDigest
+	}
+	// Only a genuine signature spends its nonce, so forged ones cannot
+	// use up another caller's.
+	if !v.remember(id+" "+nonce, now) {
+		return Key{}, ErrReplayed
+	}
+	return k, nil
+}
+
+func (v *Verifier) check(k Key, msg, sig []byte) bool {
+	if k.Alg == Ed25519 {
+		return ed25519.Verify(k.public(), msg, sig)
+	}
+	mac := hmac.New(sha256.New, k.Secret)
+	mac.Write(msg)
+	return hmac.Equal(mac.Sum(nil), sig)
+}
+
+// remember records nonce, false if it was already used within the
+// window. A timestamp is good for Window either side of now, so a nonce
+// is kept for twice that.
+func (v *Verifier) remember(nonce string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if until, ok := v.seen[nonce]; ok && now.Before(until) {
+		return false
+	}
+	v.seen[nonce] = now.Add(2 * v.opts.Window)
+	if now.After(v.sweep) {
+		for n, until := range v.seen {
+			if !now.Before(until) {
+				delete(v.seen, n)
+			}
+		}
+		v.sweep = now.Add(v.opts.Window)
+	}
+	return true
+}
+
+func (v *Verifier) required(r *http.Request) bool {
+	for _, p := range v.opts.Require {
+		if strings.HasPrefix(r.URL.Path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware refuses, with 401, signed requests that do not verify and
+// unsigned ones to the Require paths. A verified request without a
+// token is its key's principal, subject "peer:<id>" with the key's
+// roles; one with a token is the token's, once auth.Authenticate (which
+// runs inside) has verified it.
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HeaderSignature) == "" && !v.required(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		k, err := v.Verify(r)
+		if err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, ErrTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			w.Header().Set("WWW-Authenticate", `Signature realm="internal"`)
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if _, ok := auth.PrincipalFrom(r.Context()); !ok {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Claims{Subject: "peer:" + k.ID, Roles: k.Roles}))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
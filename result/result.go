@@ -0,0 +1,113 @@
+// Package result carries a value or the error that took its place through
+// a pipeline of steps, for batch work where one item failing must not
+// stop the others: an import of ten thousand lines, each parsed,
+// validated and stored, reports which ones failed and why, and stores
+// the rest.
+//
+// A Result is its value and an error side by side, with no allocation,
+// and the steps are plain function calls the compiler inlines. What they
+// buy over `if err != nil` is that a step is written once, for a value,
+// and skipped for an error, so the error reaches the end with the item it
+// belongs to. The benchmarks measure what they cost:
+//
+//	go test -bench . -benchmem ./result
+package result
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Result is a T or an error.
+type Result[T any] struct {
+	val T
+	err error
+}
+
+// Ok is a successful Result.
+func Ok[T any](v T) Result[T] { return Result[T]{val: v} }
+
+// Fail is a failed Result. err must not be nil.
+func Fail[T any](err error) Result[T] { return Result[T]{err: err} }
+
+// Of makes a Result of a function's two return values.
+func Of[T any](v T, err error) Result[T] {
+	if err != nil {
+		return Result[T]{err: err}
+	}
+	return Result[T]{val: v}
+}
+
+// Get returns the value and error, the way the function that made it
+// would have.
+func (r Result[T]) Get() (T, error) { return r.val, r.err }
+
+// Err is the error, nil for a success.
+func (r Result[T]) Err() error { return r.err }
+
+// OK reports whether r holds a value.
+func (r Result[T]) OK() bool { return r.err == nil }
+
+// Map applies f to r's value; a failure passes through.
+func Map[T, U any](r Result[T], f func(T) U) Result[U] {
+	if r.err != nil {
+		return Result[U]{err: r.err}
+	}
+	return Result[U]{val: f(r.val)}
+}
+
+// AndThen applies a step that can fail to r's value; a failure passes
+// through without running it.
+func AndThen[T, U any](r Result[T], f func(T) (U, error)) Result[U] {
+	if r.err != nil {
+		return Result[U]{err: r.err}
+	}
+	return Of(f(r.val))
+}
+
+// Collect returns the values of the successes, in order, and the errors
+// of the failures joined; nil if there were none.
+func Collect[T any](rs []Result[T]) ([]T, error) {
+	vals := make([]T, 0, len(rs))
+	var errs []error
+	for _, r := range rs {
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		vals = append(vals, r.val)
+	}
+	return vals, errors.Join(errs...)
+}
+
+// ForEachPar runs f on every item, at most n at a time (at least one),
+// and returns the outcomes in the items' order. Items not started when
+// ctx is done fail with its error rather than run; f gets ctx too, to
+// give up on the ones already running.
+func ForEachPar[T, U any](ctx context.Context, items []T, n int, f func(ctx context.Context, item T) (U, error)) []Result[U] {
+	out := make([]Result[U], len(items))
+	if n < 1 {
+		n = 1
+	}
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			for j := i; j < len(items); j++ {
+				out[j] = Fail[U](ctx.Err())
+			}
+			wg.Wait()
+			return out
+		}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			out[i] = Of(f(ctx, item))
+		}()
+	}
+	wg.Wait()
+	return out
+}
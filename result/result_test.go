@@ -0,0 +1,270 @@
+package result
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errNegative = errors.New("negative")
+
+func parse(s string) (int, error) { return strconv.Atoi(s) }
+
+func validate(n int) (int, error) {
+	if n < 0 {
+		return 0, errNegative
+	}
+	return n, nil
+}
+
+func normalize(n int) int { return n % 1000 }
+
+func TestOf(t *testing.T) {
+	if v, err := Of(parse("42")).Get(); v != 42 || err != nil {
+		t.Fatalf("Of(42) = %v, %v", v, err)
+	}
+	r := Of(parse("x"))
+	if r.OK() || r.Err() == nil {
+		t.Fatalf("Of(x) = %+v, want a failure", r)
+	}
+	// A failure keeps no value, whatever the function returned with it.
+	if v, _ := Of(7, errNegative).Get(); v != 0 {
+		t.Fatalf("Of(7, err) kept %v", v)
+	}
+}
+
+func TestMap(t *testing.T) {
+	if v, err := Map(Ok(1234), normalize).Get(); v != 234 || err != nil {
+		t.Fatalf("Map(Ok) = %v, %v", v, err)
+	}
+	called := false
+	r := Map(Fail[int](errNegative), func(n int) string { called = true; return "" })
+	if !errors.Is(r.Err(), errNegative) || called {
+		t.Fatalf("Map(Fail) = %v, called %v", r.Err(), called)
+	}
+}
+
+func TestAndThen(t *testing.T) {
+	if v, err := AndThen(Ok(5), validate).Get(); v != 5 || err != nil {
+		t.Fatalf("AndThen(Ok(5)) = %v, %v", v, err)
+	}
+	if err := AndThen(Ok(-5), validate).Err(); !errors.Is(err, errNegative) {
+		t.Fatalf("AndThen(Ok(-5)) = %v, want errNegative", err)
+	}
+	errFirst := errors.New("first")
+	called := false
+	r := AndThen(Fail[int](errFirst), func(n int) (int, error) { called = true; return n, nil })
+	if !errors.Is(r.Err(), errFirst) || called {
+		t.Fatalf("AndThen(Fail) = %v, called %v", r.Err(), called)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	errA, errB := errors.New("a"), errors.New("b")
+	vals, err := Collect([]Result[int]{Ok(1), Fail[int](errA), Ok(2), Fail[int](errB), Ok(3)})
+	if !slices.Equal(vals, []int{1, 2, 3}) {
+		t.Fatalf("Collect values = %v", vals)
+	}
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Fatalf("Collect error = %v, want a and b", err)
+	}
+	if vals, err := Collect([]Result[int]{Ok(1)}); err != nil || !slices.Equal(vals, []int{1}) {
+		t.Fatalf("Collect of successes = %v, %v", vals, err)
+	}
+	if vals, err := Collect[int](nil); err != nil || len(vals) != 0 {
+		t.Fatalf("Collect(nil) = %v, %v", vals, err)
+	}
+}
+
+func TestForEachPar(t *testing.T) {
+	items := []string{"1", "x", "3", "-4", "5"}
+	rs := ForEachPar(context.Background(), items, 2, func(_ context.Context, s string) (int, error) {
+		return AndThen(Of(parse(s)), validate).Get()
+	})
+	if len(rs) != len(items) {
+		t.Fatalf("%d results for %d items", len(rs), len(items))
+	}
+	// In the items' order, whatever order they finished in.
+	for i, want := range []int{1, 0, 3, 0, 5} {
+		if v, err := rs[i].Get(); v != want || (err != nil) != (want == 0) {
+			t.Errorf("item %d (%q) = %v, %v", i, items[i], v, err)
+		}
+	}
+	if !errors.Is(rs[3].Err(), errNegative) {
+		t.Errorf("item 3 = %v, want errNegative", rs[3].Err())
+	}
+}
+
+func TestForEachParLimit(t *testing.T) {
+	for _, n := range []int{-1, 0, 1, 3} {
+		var running, most atomic.Int32
+		ForEachPar(context.Background(), make([]int, 20), n, func(context.Context, int) (int, error) {
+			now := running.Add(1)
+			for {
+				m := most.Load()
+				if now <= m || most.CompareAndSwap(m, now) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+			return 0, nil
+		})
+		if limit := max(n, 1); most.Load() > int32(limit) {
+			t.Errorf("n = %d: %d ran at once", n, most.Load())
+		}
+	}
+}
+
+func TestForEachParCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var started atomic.Int32
+	rs := ForEachPar(ctx, make([]int, 10), 2, func(ctx context.Context, _ int) (int, error) {
+		if started.Add(1) == 2 {
+			cancel()
+		}
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	// Every item fails, the ones running included, as they give up.
+	for i, r := range rs {
+		if !errors.Is(r.Err(), context.Canceled) {
+			t.Fatalf("item %d = %v, want context.Canceled", i, r.Err())
+		}
+	}
+}
+
+/*
+-----------------------------------
+BENCHMARKS
+-----------------------------------
+*/
+
+// What Result costs over plain (value, error) returns, on a three-step
+// pipeline (parse, validate, normalize) over 1000 lines, a tenth of
+// them bad, and on an import-shaped fan-out with ForEachPar.
+//
+//	go test -bench . -benchmem ./result
+
+func lines() []string {
+	out := make([]string, 1000)
+	for i := range out {
+		switch {
+		case i%20 == 0:
+			out[i] = "x" + strconv.Itoa(i)
+		case i%20 == 10:
+			out[i] = "-" + strconv.Itoa(i)
+		default:
+			out[i] = strconv.Itoa(i * 7)
+		}
+	}
+	return out
+}
+
+func plain(in []string) (sum, failed int) {
+	for _, s := range in {
+		n, err := parse(s)
+		if err == nil {
+			n, err = validate(n)
+		}
+		if err != nil {
+			failed++
+			continue
+		}
+		sum += normalize(n)
+	}
+	return sum, failed
+}
+
+func steps(in []string) (sum, failed int) {
+	for _, s := range in {
+		r := Map(AndThen(Of(parse(s)), validate), normalize)
+		if n, err := r.Get(); err != nil {
+			failed++
+		} else {
+			sum += n
+		}
+	}
+	return sum, failed
+}
+
+func collected(in []string) (sum, failed int) {
+	rs := make([]Result[int], len(in))
+	for i, s := range in {
+		rs[i] = Map(AndThen(Of(parse(s)), validate), normalize)
+	}
+	vals, err := Collect(rs)
+	for _, v := range vals {
+		sum += v
+	}
+	if err != nil {
+		failed = len(err.(interface{ Unwrap() []error }).Unwrap())
+	}
+	return sum, failed
+}
+
+func fanOut(n int) func([]string) (int, int) {
+	return func(in []string) (sum, failed int) {
+		for _, r := range ForEachPar(context.Background(), in, n, func(_ context.Context, s string) (int, error) {
+			return AndThen(Of(parse(s)), validate).Get()
+		}) {
+			if v, err := r.Get(); err != nil {
+				failed++
+			} else {
+				sum += normalize(v)
+			}
+		}
+		return sum, failed
+	}
+}
+
+var pipelines = []struct {
+	name string
+	f    func([]string) (int, int)
+}{
+	{"plain", plain},
+	{"steps", steps},
+	{"collect", collected},
+	{"par1", fanOut(1)},
+	{"par4", fanOut(4)},
+}
+
+func TestPipelinesAgree(t *testing.T) {
+	in := lines()
+	wantSum, wantFailed := plain(in)
+	if wantFailed != 100 {
+		t.Fatalf("%d of the lines failed, want 100", wantFailed)
+	}
+	for _, p := range pipelines {
+		if sum, failed := p.f(in); sum != wantSum || failed != wantFailed {
+			t.Errorf("%s = %d, %d failed; plain = %d, %d", p.name, sum, failed, wantSum, wantFailed)
+		}
+	}
+}
+
+// The steps allocate nothing of their own: only what parse's errors do,
+// as in the plain pipeline.
+func TestStepsDoNotAllocate(t *testing.T) {
+	in := lines()
+	want := testing.AllocsPerRun(20, func() { plain(in) })
+	if got := testing.AllocsPerRun(20, func() { steps(in) }); got != want {
+		t.Fatalf("steps make %v allocations a run, plain %v", got, want)
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	in := lines()
+	for _, p := range pipelines {
+		b.Run(p.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				p.f(in)
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(in)), "ns/line")
+		})
+	}
+}
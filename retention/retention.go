@@ -0,0 +1,276 @@
+// Package retention enforces how long data is kept.
+//
+// A Rule finds what has outlived its retention period and deletes or
+// anonymizes it. A Job evaluates its rules on a schedule; it is a
+// runmode.Subsystem like vacuum.Job, so it runs under the process
+// supervisor. With DryRun set, rules only report what they would touch,
+// which is how a new policy should be rolled out: run it dry, read the
+// report, then switch it on.
+//
+// Rules take "now" from the job's clock rather than calling time.Now, so
+// a dry run and the real run that follows it agree on what is old.
+package retention
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/clock"
+	"Go-Internals/ctxutil"
+	"Go-Internals/privacy"
+	"Go-Internals/users"
+)
+
+// Rule is one retention policy.
+type Rule interface {
+	// Name describes the rule in reports ("audit entries older than 90 days").
+	Name() string
+	// Apply acts on everything the rule matches as of now, or with dryRun
+	// only collects it.
+	Apply(ctx context.Context, now time.Time, dryRun bool) (Outcome, error)
+}
+
+// maxSamples bounds Outcome.Samples, so a report stays readable when a
+// rule matches millions of records.
+const maxSamples = 20
+
+// Outcome is what one rule did in one run.
+type Outcome struct {
+	Rule    string   `json:"rule"`
+	DryRun  bool     `json:"dry_run"`
+	Matched int      `json:"matched"`
+	Applied int      `json:"applied"`           // 0 in a dry run
+	Samples []string `json:"samples,omitempty"` // up to 20 matched records
+	Error   string   `json:"error,omitempty"`
+}
+
+func (o *Outcome) sample(s string) {
+	if len(o.Samples) < maxSamples {
+		o.Samples = append(o.Samples, s)
+	}
+}
+
+/*
+-----------------------------------
+RULES
+-----------------------------------
+*/
+
+// AuditOlderThan deletes audit entries older than age from ring. Only the
+// ring is covered: a sink behind it (a log file) has its own rotation.
+func AuditOlderThan(ring *audit.Ring, age time.Duration) Rule {
+	return auditRule{ring, age}
+}
+
+type auditRule struct {
+	ring *audit.Ring
+	age  time.Duration
+}
+
+func (r auditRule) Name() string { return "audit entries older than " + days(r.age) }
+
+func (r auditRule) Apply(_ context.Context, now time.Time, dryRun bool) (Outcome, error) {
+	cutoff := now.Add(-r.age)
+	old := func(e audit.Entry) bool { return e.At.Before(cutoff) }
+	out := Outcome{Rule: r.Name(), DryRun: dryRun}
+	for _, e := range r.ring.Matching(old) {
+		out.Matched++
+		out.sample(e.At.Format(time.RFC3339) + " " + e.Action + " " + e.Resource + "/" + e.ResourceID)
+	}
+	if !dryRun && out.Matched > 0 {
+		out.Applied = r.ring.Remove(old)
+	}
+	return out, nil
+}
+
+// LastActiveFunc reports when a user was last active.
+type LastActiveFunc func(users.User) time.Time
+
+// CreatedAt is the default LastActiveFunc: "inactive for N" means
+// "registered more than N ago". activity.History's LastActive counts
+// sign-ins too.
+func CreatedAt(u users.User) time.Time { return u.CreatedAt }
+
+// AnonymizeInactive anonymizes, through m (so every store a
+// data-subject erasure covers is covered here too), users inactive for
+// longer than after. Users already anonymized are skipped.
+func AnonymizeInactive(repo users.UserRepository, m *privacy.Manager, after time.Duration, lastActive LastActiveFunc) Rule {
+	if lastActive == nil {
+		lastActive = CreatedAt
+	}
+	return inactiveRule{repo, m, after, lastActive}
+}
+
+type inactiveRule struct {
+	repo       users.UserRepository
+	m          *privacy.Manager
+	after      time.Duration
+	lastActive LastActiveFunc
+}
+
+func (r inactiveRule) Name() string { return "anonymize users inactive for " + days(r.after) }
+
+// Apply collects the matches first and erases afterwards, so the
+// iteration never races its own updates.
+func (r inactiveRule) Apply(ctx context.Context, now time.Time, dryRun bool) (Outcome, error) {
+	cutoff := now.Add(-r.after)
+	out := Outcome{Rule: r.Name(), DryRun: dryRun}
+	var ids []int
+	for u, err := range r.repo.Iterate(ctx, users.IterateOptions{}) {
+		if err != nil {
+			return out, err
+		}
+		if privacy.IsAnonymized(u) || !r.lastActive(u).Before(cutoff) {
+			continue
+		}
+		out.Matched++
+		out.sample("user " + strconv.Itoa(u.ID))
+		ids = append(ids, u.ID)
+	}
+	if dryRun {
+		return out, nil
+	}
+
+	var errs []error
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return out, err
+		}
+		rep, err := r.m.Erase(ctx, id, privacy.Anonymize)
+		if errors.Is(err, users.ErrUserNotFound) {
+			continue // deleted since the scan
+		}
+		if err == nil && !rep.Complete() {
+			err = errors.New("privacy: erasure of user " + strconv.Itoa(id) + " incomplete")
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		out.Applied++
+	}
+	return out, errors.Join(errs...)
+}
+
+func days(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return strconv.Itoa(int(d/(24*time.Hour))) + " days"
+	}
+	return d.String()
+}
+
+/*
+-----------------------------------
+JOB
+-----------------------------------
+*/
+
+// JobOptions configures NewJob.
+type JobOptions struct {
+	Rules    []Rule
+	Interval time.Duration // between runs; default 24h
+	DryRun   bool
+	Clock    clock.Clock
+	Logger   *slog.Logger        // default ctxutil.Logger(ctx), a run at a time
+	OnReport func(report Report) // called after every run
+}
+
+// Report is one run of every rule.
+type Report struct {
+	At       time.Time `json:"at"`
+	DryRun   bool      `json:"dry_run"`
+	Outcomes []Outcome `json:"outcomes"`
+}
+
+// Job evaluates a fixed set of rules, one at a time.
+type Job struct {
+	opts JobOptions
+	clk  clock.Clock
+	log  *slog.Logger
+
+	mu   sync.Mutex
+	runs int
+	last Report
+}
+
+func NewJob(opts JobOptions) *Job {
+	if opts.Interval <= 0 {
+		opts.Interval = 24 * time.Hour
+	}
+	return &Job{opts: opts, clk: clock.OrReal(opts.Clock), log: opts.Logger}
+}
+
+// logger is the Logger option or, without one, the logger of the run's
+// context: under the supervisor, tagged with the subsystem.
+func (j *Job) logger(ctx context.Context) *slog.Logger {
+	if j.log != nil {
+		return j.log
+	}
+	return ctxutil.Logger(ctx)
+}
+
+// RunOnce applies every rule now. A failing rule does not stop the
+// others; the joined errors are returned along with the report.
+func (j *Job) RunOnce(ctx context.Context) (Report, error) {
+	log := j.logger(ctx)
+	rep := Report{At: j.clk.Now(), DryRun: j.opts.DryRun}
+	var errs []error
+	for _, rule := range j.opts.Rules {
+		if ctx.Err() != nil {
+			break
+		}
+		out, err := rule.Apply(ctx, rep.At, j.opts.DryRun)
+		out.Rule, out.DryRun = rule.Name(), j.opts.DryRun
+		if err != nil {
+			out.Error = err.Error()
+			errs = append(errs, err)
+			log.Warn("retention rule failed", "rule", out.Rule, "err", err)
+		} else if j.opts.DryRun {
+			log.Info("retention dry run", "rule", out.Rule, "would_apply", out.Matched)
+		} else {
+			log.Info("retention applied", "rule", out.Rule, "matched", out.Matched, "applied", out.Applied)
+		}
+		rep.Outcomes = append(rep.Outcomes, out)
+	}
+	if j.opts.OnReport != nil {
+		j.opts.OnReport(rep)
+	}
+
+	j.mu.Lock()
+	j.runs++
+	j.last = rep
+	j.mu.Unlock()
+	return rep, errors.Join(errs...)
+}
+
+// Run applies the rules every Interval until ctx is done. Rule errors are
+// logged, not returned, and retried next interval.
+func (j *Job) Run(ctx context.Context) error {
+	t := j.clk.NewTicker(j.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+			j.RunOnce(ctx)
+		}
+	}
+}
+
+// Stats summarizes the job so far.
+type Stats struct {
+	Runs int
+	Last Report
+}
+
+func (j *Job) Stats() Stats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return Stats{Runs: j.runs, Last: j.last}
+}
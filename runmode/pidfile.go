@@ -0,0 +1,83 @@
+// Package runmode covers how the binary runs as a long-lived service:
+// foreground or detached (daemon), guarded by a PID file, with internal
+// subsystems supervised and restarted when they fail.
+package runmode
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrAlreadyRunning = errors.New("runmode: another instance is running")
+	ErrUnsupported    = errors.New("runmode: not supported on this platform")
+)
+
+// daemonEnv marks the re-executed child (see Daemonize).
+const daemonEnv = "RUNMODE_DAEMON"
+
+// PIDFile is an acquired PID file.
+type PIDFile struct {
+	path string
+	pid  int
+}
+
+// AcquirePIDFile creates path containing our PID. If the file exists and
+// names a live process it fails with ErrAlreadyRunning; if the process is
+// gone (crash, kill -9, reboot) the stale file is replaced.
+func AcquirePIDFile(path string) (*PIDFile, error) {
+	pid := os.Getpid()
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, werr := fmt.Fprintf(f, "%d\n", pid)
+			cerr := f.Close()
+			if err := errors.Join(werr, cerr); err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return &PIDFile{path: path, pid: pid}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		other, rerr := ReadPIDFile(path)
+		if rerr == nil && other != pid && processAlive(other) {
+			return nil, fmt.Errorf("%w (pid %d, %s)", ErrAlreadyRunning, other, path)
+		}
+		// Stale or unreadable: remove and retry once. O_EXCL keeps two
+		// instances racing on the same stale file from both winning.
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("runmode: could not acquire %s", path)
+}
+
+// ReadPIDFile returns the PID recorded in path.
+func ReadPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("runmode: %s: bad pid %q", path, strings.TrimSpace(string(data)))
+	}
+	return pid, nil
+}
+
+// Release removes the file if it still names this process.
+func (p *PIDFile) Release() error {
+	if pid, err := ReadPIDFile(p.path); err != nil || pid != p.pid {
+		return err
+	}
+	return os.Remove(p.path)
+}
+
+// Path is where the PID file lives.
+func (p *PIDFile) Path() string { return p.path }
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package runmode
+
+import "os"
+
+// processAlive: on Windows FindProcess opens a handle and fails for
+// processes that don't exist.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// Daemonize is unix-only; run under a service manager instead.
+func Daemonize(logPath string) (child bool, err error) {
+	if os.Getenv(daemonEnv) == "1" {
+		return true, nil
+	}
+	return false, ErrUnsupported
+}
@@ -0,0 +1,56 @@
+//go:build unix
+
+package runmode
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// processAlive uses signal 0: no signal is sent, but the kernel still
+// checks that the process exists. EPERM means it exists and belongs to
+// someone else.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// Daemonize detaches the process. Go can't fork safely once the runtime
+// has started threads, so instead the binary re-executes itself in a new
+// session with stdin at /dev/null and stdout/stderr appended to logPath,
+// and the parent returns.
+//
+// Call it first thing in main:
+//
+//	if child, err := runmode.Daemonize("app.log"); err != nil { ... } else if !child { return }
+//
+// child is false in the original process (which should exit) and true in
+// the detached one.
+func Daemonize(logPath string) (child bool, err error) {
+	if os.Getenv(daemonEnv) == "1" {
+		return true, nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return false, err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return false, err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+	fmt.Fprintf(os.Stderr, "started in background, pid %d, logging to %s\n", cmd.Process.Pid, logPath)
+	return false, cmd.Process.Release()
+}
@@ -0,0 +1,264 @@
+package runmode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+-----------------------------------
+SERVICES
+-----------------------------------
+*/
+
+// Service is a part of the process with a lifetime: a store to open and
+// close, a server to listen and shut down, a bus to drain.
+//
+// Start must not block past getting the service going: anything that
+// runs until shutdown runs in its own goroutine, which Stop ends. ctx
+// bounds Start itself (a store that takes too long to open), not the
+// service's lifetime, so nothing that outlives Start may stop with it.
+// Stop should return by ctx's deadline, abandoning what is left.
+type Service interface {
+	Name() string
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// Func makes a Service of two funcs; either may be nil.
+func Func(name string, start, stop func(ctx context.Context) error) Service {
+	return funcService{name, start, stop}
+}
+
+type funcService struct {
+	name        string
+	start, stop func(ctx context.Context) error
+}
+
+func (f funcService) Name() string { return f.name }
+
+func (f funcService) Start(ctx context.Context) error {
+	if f.start == nil {
+		return nil
+	}
+	return f.start(ctx)
+}
+
+func (f funcService) Stop(ctx context.Context) error {
+	if f.stop == nil {
+		return nil
+	}
+	return f.stop(ctx)
+}
+
+// Closer makes a Service that does nothing to start and calls close to
+// stop, for what is ready once built: an opened store, an event bus.
+func Closer(name string, close func() error) Service {
+	return Func(name, nil, func(context.Context) error { return close() })
+}
+
+// Registry starts services in dependency order and stops them in the
+// reverse order, so a service never runs without what it depends on:
+// the HTTP server stops taking requests before the repository closes,
+// and the event bus drains before the plugins subscribed to it exit.
+type Registry struct {
+	log *slog.Logger
+
+	mu      sync.Mutex
+	entries []*entry
+	started []*entry // in start order
+}
+
+type entry struct {
+	svc  Service
+	deps []string
+
+	// guarded by Registry.mu
+	state string // "registered", "running", "stopped", "failed"
+	took  time.Duration
+	err   error
+}
+
+// RegistryOptions configures NewRegistry.
+type RegistryOptions struct {
+	Logger *slog.Logger // default slog.Default()
+}
+
+func NewRegistry(opts RegistryOptions) *Registry {
+	log := opts.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	return &Registry{log: log}
+}
+
+// Register adds s, to start after the services named in dependsOn. Those
+// may be registered later, but before Start. Two services with one name
+// is a programming error and panics.
+func (r *Registry) Register(s Service, dependsOn ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if slices.ContainsFunc(r.entries, func(e *entry) bool { return e.svc.Name() == s.Name() }) {
+		panic("runmode: service " + s.Name() + " registered twice")
+	}
+	r.entries = append(r.entries, &entry{svc: s, deps: dependsOn, state: "registered"})
+}
+
+// Order returns the start order: dependencies first, otherwise in the
+// order of registration. It fails on a dependency that is not registered
+// or a cycle.
+func (r *Registry) Order() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order, err := r.order()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(order))
+	for i, e := range order {
+		names[i] = e.svc.Name()
+	}
+	return names, nil
+}
+
+func (r *Registry) order() ([]*entry, error) {
+	byName := make(map[string]*entry, len(r.entries))
+	for _, e := range r.entries {
+		byName[e.svc.Name()] = e
+	}
+	for _, e := range r.entries {
+		for _, d := range e.deps {
+			if byName[d] == nil {
+				return nil, fmt.Errorf("runmode: service %s depends on %s, which is not registered", e.svc.Name(), d)
+			}
+		}
+	}
+
+	// Depth-first, visiting in registration order, so unrelated services
+	// keep the order they were registered in.
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	mark := make(map[*entry]int, len(r.entries))
+	var out []*entry
+	var path []string
+	var visit func(e *entry) error
+	visit = func(e *entry) error {
+		switch mark[e] {
+		case done:
+			return nil
+		case visiting:
+			i := slices.Index(path, e.svc.Name())
+			return fmt.Errorf("runmode: services depend on each other: %s", strings.Join(append(path[i:], e.svc.Name()), " -> "))
+		}
+		mark[e] = visiting
+		path = append(path, e.svc.Name())
+		for _, d := range e.deps {
+			if err := visit(byName[d]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		mark[e] = done
+		out = append(out, e)
+		return nil
+	}
+	for _, e := range r.entries {
+		if err := visit(e); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Start starts every service in order. If one fails, those already
+// started are stopped, in reverse, and Start returns its error.
+func (r *Registry) Start(ctx context.Context) error {
+	r.mu.Lock()
+	order, err := r.order()
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range order {
+		start := time.Now()
+		err := e.svc.Start(ctx)
+		took := time.Since(start)
+		r.mu.Lock()
+		e.took, e.err = took, err
+		if err == nil {
+			e.state = "running"
+			r.started = append(r.started, e)
+		} else {
+			e.state = "failed"
+		}
+		r.mu.Unlock()
+		if err != nil {
+			r.log.Error("runmode: service failed to start, stopping the others", "service", e.svc.Name(), "err", err)
+			return errors.Join(fmt.Errorf("runmode: starting %s: %w", e.svc.Name(), err), r.Stop(ctx))
+		}
+		r.log.Debug("runmode: service started", "service", e.svc.Name(), "took", took)
+	}
+	return nil
+}
+
+// Stop stops the started services in reverse order. A service that fails
+// to stop is logged and the others still stop; the errors are joined.
+func (r *Registry) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	started := r.started
+	r.started = nil
+	r.mu.Unlock()
+
+	var errs []error
+	for _, e := range slices.Backward(started) {
+		err := e.svc.Stop(ctx)
+		r.mu.Lock()
+		e.state = "stopped"
+		if err != nil {
+			e.state, e.err = "failed", err
+		}
+		r.mu.Unlock()
+		if err != nil {
+			r.log.Error("runmode: service failed to stop", "service", e.svc.Name(), "err", err)
+			errs = append(errs, fmt.Errorf("runmode: stopping %s: %w", e.svc.Name(), err))
+			continue
+		}
+		r.log.Debug("runmode: service stopped", "service", e.svc.Name())
+	}
+	return errors.Join(errs...)
+}
+
+// ServiceStats is one service's state.
+type ServiceStats struct {
+	Name      string
+	State     string
+	DependsOn []string
+	// StartTook is how long Start took.
+	StartTook time.Duration
+	LastError string
+}
+
+// Stats lists the services in registration order.
+func (r *Registry) Stats() []ServiceStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]ServiceStats, 0, len(r.entries))
+	for _, e := range r.entries {
+		st := ServiceStats{Name: e.svc.Name(), State: e.state, DependsOn: e.deps, StartTook: e.took}
+		if e.err != nil {
+			st.LastError = e.err.Error()
+		}
+		out = append(out, st)
+	}
+	return out
+}
@@ -0,0 +1,239 @@
+package runmode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"Go-Internals/clock"
+	"Go-Internals/crashreport"
+	"Go-Internals/ctxutil"
+)
+
+// Backoff is the restart delay policy: Initial, multiplied by Factor after
+// each consecutive failure, capped at Max. A run that lasted ResetAfter
+// counts as healthy and resets the delay.
+type Backoff struct {
+	Initial    time.Duration // default 100ms
+	Max        time.Duration // default 30s
+	Factor     float64       // default 2
+	ResetAfter time.Duration // default 1m
+}
+
+func (b Backoff) withDefaults() Backoff {
+	if b.Initial <= 0 {
+		b.Initial = 100 * time.Millisecond
+	}
+	if b.Max <= 0 {
+		b.Max = 30 * time.Second
+	}
+	if b.Factor < 1 {
+		b.Factor = 2
+	}
+	if b.ResetAfter <= 0 {
+		b.ResetAfter = time.Minute
+	}
+	return b
+}
+
+// Subsystem is a long-running internal component. It should run until ctx
+// is done and return nil then; returning earlier (or panicking) counts as
+// a crash and triggers a restart.
+type Subsystem func(ctx context.Context) error
+
+// Supervisor restarts subsystems that fail.
+type Supervisor struct {
+	backoff Backoff
+	clk     clock.Clock
+	log     *slog.Logger
+	crash   *crashreport.Reporter
+
+	mu   sync.Mutex
+	subs []*supervised
+	// Set by Start.
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type supervised struct {
+	name string
+	run  Subsystem
+
+	// guarded by Supervisor.mu
+	running  bool
+	restarts int
+	lastErr  error
+	lastAt   time.Time
+}
+
+// SupervisorOptions configures NewSupervisor.
+type SupervisorOptions struct {
+	Backoff Backoff
+	Clock   clock.Clock
+	Logger  *slog.Logger // default slog.Default()
+	// Crash, if set, gets a crash file for every panic; otherwise the
+	// stack is kept in the subsystem's LastError.
+	Crash *crashreport.Reporter
+}
+
+func NewSupervisor(opts SupervisorOptions) *Supervisor {
+	log := opts.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	return &Supervisor{backoff: opts.Backoff.withDefaults(), clk: clock.OrReal(opts.Clock), log: log, crash: opts.Crash}
+}
+
+// Add registers a subsystem. Call before Run.
+func (s *Supervisor) Add(name string, run Subsystem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subs = append(s.subs, &supervised{name: name, run: run})
+}
+
+// Run starts every subsystem and blocks until ctx is done and all of them
+// have returned.
+func (s *Supervisor) Run(ctx context.Context) {
+	s.mu.Lock()
+	subs := append([]*supervised(nil), s.subs...)
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, sub := range subs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.supervise(ctx, sub)
+		}()
+	}
+	wg.Wait()
+}
+
+// Name, Start and Stop make the Supervisor a Service, running its
+// subsystems from Start until Stop.
+func (s *Supervisor) Name() string { return "supervisor" }
+
+// Start runs the subsystems in the background.
+func (s *Supervisor) Start(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.mu.Unlock()
+		cancel()
+		return errors.New("runmode: supervisor already started")
+	}
+	s.cancel, s.done = cancel, done
+	s.mu.Unlock()
+	go func() {
+		defer close(done)
+		s.Run(ctx)
+	}()
+	return nil
+}
+
+// Stop cancels the subsystems and waits for them to return, or for ctx.
+func (s *Supervisor) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("runmode: subsystems still running: %w", ctx.Err())
+	}
+}
+
+func (s *Supervisor) supervise(ctx context.Context, sub *supervised) {
+	delay := s.backoff.Initial
+	for {
+		start := s.clk.Now()
+		s.setState(sub, true, nil)
+		err := s.runOnce(ctx, sub)
+		s.setState(sub, false, err)
+
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("returned before shutdown")
+		}
+
+		if s.clk.Since(start) >= s.backoff.ResetAfter {
+			delay = s.backoff.Initial
+		}
+		s.log.Error("runmode: subsystem crashed, restarting", "subsystem", sub.name, "err", err, "in", delay)
+
+		t := s.clk.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C():
+		}
+		s.mu.Lock()
+		sub.restarts++
+		s.mu.Unlock()
+		delay = min(time.Duration(float64(delay)*s.backoff.Factor), s.backoff.Max)
+	}
+}
+
+// runOnce turns a panic into an error so the supervisor can restart.
+func (s *Supervisor) runOnce(ctx context.Context, sub *supervised) (err error) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if s.crash != nil {
+			s.crash.Capture(sub.name, v, debug.Stack())
+			err = fmt.Errorf("panic: %v", v)
+			return
+		}
+		err = fmt.Errorf("panic: %v\n%s", v, debug.Stack())
+	}()
+	// The subsystem logs, through ctxutil.Logger, under its name.
+	return sub.run(ctxutil.WithLogger(ctx, s.log.With("subsystem", sub.name)))
+}
+
+func (s *Supervisor) setState(sub *supervised, running bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub.running = running
+	if err != nil {
+		sub.lastErr = err
+		sub.lastAt = s.clk.Now()
+	}
+}
+
+// SubsystemStats is one subsystem's restart history.
+type SubsystemStats struct {
+	Name      string
+	Running   bool
+	Restarts  int
+	LastError string
+	LastAt    time.Time
+}
+
+func (s *Supervisor) Stats() []SubsystemStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]SubsystemStats, 0, len(s.subs))
+	for _, sub := range s.subs {
+		st := SubsystemStats{Name: sub.name, Running: sub.running, Restarts: sub.restarts, LastAt: sub.lastAt}
+		if sub.lastErr != nil {
+			st.LastError = sub.lastErr.Error()
+		}
+		out = append(out, st)
+	}
+	return out
+}
@@ -0,0 +1,228 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+-----------------------------------
+BUILTINS
+-----------------------------------
+*/
+
+// builtins are available to every script. None of them reaches outside
+// the run: no files, no network, no clock. Whatever else a script may
+// touch, the host passes to Run as a global.
+var builtins = map[string]Value{}
+
+func init() {
+	for _, b := range []*Builtin{
+		{"len", biLen},
+		{"str", func(t *Thread, args []Value) (Value, error) {
+			if err := arity(args, 1); err != nil {
+				return nil, err
+			}
+			s := Format(args[0])
+			t.Alloc(len(s))
+			return s, nil
+		}},
+		{"int", biInt},
+		{"type", func(_ *Thread, args []Value) (Value, error) {
+			if err := arity(args, 1); err != nil {
+				return nil, err
+			}
+			return typeName(args[0]), nil
+		}},
+		{"lower", stringFn(strings.ToLower)},
+		{"upper", stringFn(strings.ToUpper)},
+		{"trim", stringFn(strings.TrimSpace)},
+		{"starts_with", predicate(strings.HasPrefix)},
+		{"ends_with", predicate(strings.HasSuffix)},
+		{"contains", func(t *Thread, args []Value) (Value, error) {
+			if err := arity(args, 2); err != nil {
+				return nil, err
+			}
+			return t.contains(args[0], args[1], t.at), nil
+		}},
+		{"split", biSplit},
+		{"join", biJoin},
+		{"replace", biReplace},
+		{"keys", func(t *Thread, args []Value) (Value, error) {
+			if err := arity(args, 1); err != nil {
+				return nil, err
+			}
+			m, ok := args[0].(Map)
+			if !ok {
+				return nil, fmt.Errorf("want map, got %s", typeName(args[0]))
+			}
+			t.Alloc(16 * len(m))
+			keys := make(List, 0, len(m))
+			for _, k := range sortedKeys(m) {
+				keys = append(keys, k)
+			}
+			return keys, nil
+		}},
+		{"append", func(t *Thread, args []Value) (Value, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("takes a list and values to add")
+			}
+			l, ok := args[0].(List)
+			if !ok {
+				return nil, fmt.Errorf("want list, got %s", typeName(args[0]))
+			}
+			// A new list: appending never changes one another variable
+			// holds.
+			t.Alloc(16 * (len(l) + len(args) - 1))
+			return append(append(make(List, 0, len(l)+len(args)-1), l...), args[1:]...), nil
+		}},
+		{"range", func(t *Thread, args []Value) (Value, error) {
+			if err := arity(args, 1); err != nil {
+				return nil, err
+			}
+			n, ok := args[0].(int64)
+			if !ok || n < 0 {
+				return nil, fmt.Errorf("want a non-negative int")
+			}
+			t.Alloc(16 * int(min(n, 1<<30)))
+			l := make(List, n)
+			for i := range l {
+				l[i] = int64(i)
+			}
+			return l, nil
+		}},
+	} {
+		builtins[b.Name] = b
+	}
+}
+
+func arity(args []Value, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("takes %d arguments, got %d", n, len(args))
+	}
+	return nil
+}
+
+func stringArgs(args []Value, n int) ([]string, error) {
+	if err := arity(args, n); err != nil {
+		return nil, err
+	}
+	out := make([]string, n)
+	for i, a := range args {
+		s, ok := a.(string)
+		if !ok {
+			return nil, fmt.Errorf("argument %d: want string, got %s", i+1, typeName(a))
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+func stringFn(f func(string) string) func(*Thread, []Value) (Value, error) {
+	return func(t *Thread, args []Value) (Value, error) {
+		s, err := stringArgs(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		t.Alloc(len(s[0]))
+		return f(s[0]), nil
+	}
+}
+
+func predicate(f func(string, string) bool) func(*Thread, []Value) (Value, error) {
+	return func(_ *Thread, args []Value) (Value, error) {
+		s, err := stringArgs(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		return f(s[0], s[1]), nil
+	}
+}
+
+func biLen(_ *Thread, args []Value) (Value, error) {
+	if err := arity(args, 1); err != nil {
+		return nil, err
+	}
+	switch v := args[0].(type) {
+	case string:
+		return int64(len(v)), nil
+	case List:
+		return int64(len(v)), nil
+	case Map:
+		return int64(len(v)), nil
+	}
+	return nil, fmt.Errorf("%s has no length", typeName(args[0]))
+}
+
+func biInt(_ *Thread, args []Value) (Value, error) {
+	if err := arity(args, 1); err != nil {
+		return nil, err
+	}
+	switch v := args[0].(type) {
+	case int64:
+		return v, nil
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", v)
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to int", typeName(args[0]))
+}
+
+func biSplit(t *Thread, args []Value) (Value, error) {
+	s, err := stringArgs(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(s[0], s[1])
+	t.Alloc(len(s[0]) + 16*len(parts))
+	out := make(List, len(parts))
+	for i, p := range parts {
+		out[i] = p
+	}
+	return out, nil
+}
+
+func biJoin(t *Thread, args []Value) (Value, error) {
+	if err := arity(args, 2); err != nil {
+		return nil, err
+	}
+	l, ok := args[0].(List)
+	sep, ok2 := args[1].(string)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("want a list and a string")
+	}
+	parts := make([]string, len(l))
+	n := len(sep) * len(l)
+	for i, e := range l {
+		parts[i] = Format(e)
+		n += len(parts[i])
+	}
+	t.Alloc(n)
+	return strings.Join(parts, sep), nil
+}
+
+func biReplace(t *Thread, args []Value) (Value, error) {
+	s, err := stringArgs(args, 3)
+	if err != nil {
+		return nil, err
+	}
+	// The result's size is known before it is built, so a replacement
+	// that would blow the limit fails without allocating.
+	n := len(s[0])
+	if s[1] != "" {
+		n += strings.Count(s[0], s[1]) * (len(s[2]) - len(s[1]))
+	} else {
+		n += (len(s[0]) + 1) * len(s[2])
+	}
+	t.Alloc(n)
+	return strings.ReplaceAll(s[0], s[1], s[2]), nil
+}
@@ -0,0 +1,142 @@
+// demo: registration and event hooks written as scripts, and what the
+// limits do to scripts that misbehave.
+//
+//	go run ./script/demo                      # the hooks in script/examples
+//	go run ./script/demo -dir my/hooks
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"Go-Internals/eventbus"
+	"Go-Internals/script"
+	"Go-Internals/users"
+)
+
+// runaway scripts, each stopped by a different limit.
+var runaway = []struct{ name, src string }{
+	{"loop", "for {}"},
+	{"recursion", "fn f(n) { return f(n + 1) }\nf(0)"},
+	{"memory", `s := "x"` + "\nfor { s = s + s }"},
+	{"slow", "for i in range(100) { for j in range(1000) { x := i * j } }"},
+}
+
+func main() {
+	dir := flag.String("dir", "script/examples", "directory of *.script hooks")
+	flag.Parse()
+
+	// Script log lines go to stdout, without timestamps, to read inline.
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	hooks, err := script.LoadDir(*dir, script.Options{Logger: logger})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, h := range hooks.Hooks() {
+		at := "validate"
+		if !h.Validates() {
+			at = "on " + h.Topic
+		}
+		fmt.Printf("loaded %-20s %s\n", h.Name, at)
+	}
+
+	bus := eventbus.New()
+	if err := hooks.Subscribe(bus); err != nil {
+		log.Fatal(err)
+	}
+	svc := users.NewUserService(users.NewInMemoryUserRepo(), users.WithValidator(hooks.Validate), users.WithEvents(bus))
+
+	fmt.Println("\n== registrations")
+	for _, r := range []struct{ name, email string }{
+		{"Asha", "asha@example.com"},
+		{"Temp", "temp@mailinator.com"},
+		{"Sub", "x@mx.yopmail.com"},
+		{"bob@example.com", "bob@example.com"},
+		{"zzzz", "z@example.com"},
+		{"Ravi", "ravi@example.in"},
+	} {
+		u, err := svc.RegisterUser(context.Background(), r.name, r.email)
+		if err != nil {
+			fmt.Printf("  %-22s refused: %v\n", r.email, err)
+			continue
+		}
+		fmt.Printf("  %-22s registered as %d\n", r.email, u.ID)
+	}
+	bus.Close() // waits for the event hooks to finish
+
+	fmt.Println("\n== runaway scripts (default limits)")
+	for _, r := range runaway {
+		prog, err := script.Compile(r.name, r.src)
+		if err != nil {
+			log.Fatal(err)
+		}
+		start := time.Now()
+		res, err := prog.Run(context.Background(), nil, script.Limits{})
+		fmt.Printf("  %-10s stopped after %6d steps, %8v: %v (%s)\n", r.name, res.Steps, time.Since(start).Round(time.Microsecond), err, which(err))
+	}
+	prog, _ := script.Compile("slow", runaway[3].src)
+	_, err = prog.Run(context.Background(), nil, script.Limits{Steps: 1 << 30, Timeout: 5 * time.Millisecond})
+	fmt.Printf("  %-10s with no step limit and a 5ms timeout: %v (%s)\n", "slow", err, which(err))
+
+	fmt.Println("\n== cost of the validate hooks")
+	u := users.User{ID: 1, Name: "Asha", Email: "asha@example.com", CreatedAt: time.Now()}
+	quiet := script.New(script.Options{Logger: slog.New(slog.DiscardHandler)}, validators(hooks)...)
+	b := testing.Benchmark(func(b *testing.B) {
+		for b.Loop() {
+			if err := quiet.Validate(context.Background(), u); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	var steps, runs int64
+	for _, s := range quiet.Stats() {
+		steps, runs = steps+s.Steps, runs+s.Runs
+	}
+	fmt.Printf("  %v per registration, %d allocs, %d steps per hook run\n", time.Duration(b.NsPerOp()), b.AllocsPerOp(), steps/max(runs, 1))
+
+	fmt.Println("\n== stats")
+	stats := hooks.Stats()
+	for _, name := range slices.Sorted(maps.Keys(stats)) {
+		fmt.Printf("  %-20s %+v\n", name, stats[name])
+	}
+}
+
+func validators(h *script.Hooks) []*script.Hook {
+	var out []*script.Hook
+	for _, hk := range h.Hooks() {
+		if hk.Validates() {
+			out = append(out, hk)
+		}
+	}
+	return out
+}
+
+func which(err error) string {
+	switch {
+	case errors.Is(err, script.ErrSteps):
+		return "ErrSteps"
+	case errors.Is(err, script.ErrDepth):
+		return "ErrDepth"
+	case errors.Is(err, script.ErrMemory):
+		return "ErrMemory"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	return "?"
+}
@@ -0,0 +1,43 @@
+// Package script is a small embedded language for operator-written
+// hooks: a check on every registration, a reaction to an event. Plugins
+// (package plugins) cover the same extension points, but a plugin is a
+// program to build and deploy; a hook is a few lines in a directory that
+// the service reads at start.
+//
+// The language is expression-oriented and dynamically typed, with Go's
+// punctuation and no semicolons:
+//
+//	# hook: validate
+//	blocked := ["mailinator.com", "yopmail.com"]
+//	domain := lower(split(user.email, "@")[-1])
+//	if domain in blocked {
+//		reject("disposable email domain " + domain)
+//	}
+//
+// Values are nil, bool, int, string, list and map (string keys; m.k is
+// m["k"], and a missing key is nil). Statements are x := v, x = v,
+// if / else if / else, for v in coll (for i, v and for k, v give the
+// index or key too), for cond, for {}, break, continue, and
+// fn name(a, b) { return ... }, with closures. There are no floats, no
+// imports and no way to name anything the host did not provide.
+//
+// A script is untrusted in the sense that it may be wrong: a loop that
+// never ends, recursion without a base case, a string doubled until it
+// fills memory. Limits turns each into an error at a bounded cost, by
+// counting evaluation steps, call depth and bytes allocated, and by a
+// wall-clock timeout. What a script can reach is exactly the builtins
+// (pure functions over values: len, str, lower, split, keys, ...) and
+// the globals its host passes to Run. For hooks that is the Host API:
+//
+//	user           the user, as its JSON fields (validate hooks; event
+//	               hooks when the payload is a user)
+//	event          {topic, at, payload} (event hooks)
+//	reject(why)    refuse the registration (validate hooks)
+//	log(args...)   one info line in the service log
+//	now()          the time, in Unix seconds
+//
+// The interpreter walks the syntax tree. That is slow next to compiled
+// code, some hundred nanoseconds per step, and beside the point: a hook
+// runs once per registration or event, and a few thousand steps is a
+// long script.
+package script
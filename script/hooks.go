@@ -0,0 +1,313 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"Go-Internals/clock"
+	"Go-Internals/eventbus"
+	"Go-Internals/users"
+)
+
+/*
+-----------------------------------
+HOOKS
+-----------------------------------
+*/
+
+// Ext is the extension LoadDir looks for.
+const Ext = ".script"
+
+var ErrDirective = errors.New(`script: want "# hook: validate" or "# hook: on <topic>" before the code`)
+
+// Hook is a script and the point it is attached to, named by a comment
+// line before its code:
+//
+//	# hook: validate            every registration, before it is stored
+//	# hook: on user.created     every event on the topic ("*" for all)
+type Hook struct {
+	Name  string
+	Topic string // empty for a validate hook
+	prog  *Program
+}
+
+func (h *Hook) Validates() bool { return h.Topic == "" }
+
+// ParseHook compiles src and reads its hook line.
+func ParseHook(name, src string) (*Hook, error) {
+	h := &Hook{Name: name}
+	found := false
+	for line := range strings.Lines(src) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		text, ok := strings.CutPrefix(line, "#")
+		if !ok {
+			if text, ok = strings.CutPrefix(line, "//"); !ok {
+				break // code starts
+			}
+		}
+		spec, ok := strings.CutPrefix(strings.TrimSpace(text), "hook:")
+		if !ok {
+			continue
+		}
+		switch f := strings.Fields(spec); {
+		case len(f) == 1 && f[0] == "validate":
+		case len(f) == 2 && f[0] == "on":
+			h.Topic = f[1]
+		default:
+			return nil, fmt.Errorf("%s: %w", name, ErrDirective)
+		}
+		found = true
+		break
+	}
+	if !found {
+		return nil, fmt.Errorf("%s: %w", name, ErrDirective)
+	}
+	prog, err := Compile(name, src)
+	if err != nil {
+		return nil, err
+	}
+	h.prog = prog
+	return h, nil
+}
+
+// Options configures a set of hooks.
+type Options struct {
+	Limits Limits
+	// Logger receives what scripts pass to log(); default slog.Default().
+	Logger *slog.Logger
+	Clock  clock.Clock // what now() reads
+}
+
+// Hooks runs scripts at the service's extension points: Validate is a
+// users.Validator, Subscribe attaches the event hooks to a bus.
+type Hooks struct {
+	hooks []*Hook
+	opts  Options
+	clk   clock.Clock
+
+	mu    sync.Mutex
+	stats map[string]*HookStat
+}
+
+// HookStat counts one hook's runs. Steps is the total over all of them,
+// so Steps/Runs is what a run costs.
+type HookStat struct {
+	Topic    string `json:"topic,omitempty"`
+	Runs     int64  `json:"runs"`
+	Rejected int64  `json:"rejected,omitempty"`
+	Failures int64  `json:"failures"`
+	Steps    int64  `json:"steps"`
+}
+
+func New(opts Options, hooks ...*Hook) *Hooks {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	h := &Hooks{hooks: hooks, opts: opts, clk: clock.OrReal(opts.Clock), stats: make(map[string]*HookStat, len(hooks))}
+	for _, hk := range hooks {
+		h.stats[hk.Name] = &HookStat{Topic: hk.Topic}
+	}
+	return h
+}
+
+// LoadDir compiles every *.script file in dir, in name order, which is
+// the order validate hooks run in. One bad script fails the whole load:
+// a hook silently missing is worse than a service that does not start.
+func LoadDir(dir string, opts Options) (*Hooks, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+Ext))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(paths)
+	var hooks []*Hook
+	for _, p := range paths {
+		src, err := os.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		h, err := ParseHook(filepath.Base(p), string(src))
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return New(opts, hooks...), nil
+}
+
+func (h *Hooks) Hooks() []*Hook { return h.hooks }
+
+// rejected is how reject() stops a validate hook.
+type rejected struct{ reason string }
+
+func (r *rejected) Error() string { return "rejected: " + r.reason }
+
+// host is the API a hook sees besides the builtins.
+func (h *Hooks) host(hk *Hook) map[string]Value {
+	g := map[string]Value{
+		"log": &Builtin{"log", func(_ *Thread, args []Value) (Value, error) {
+			parts := make([]string, len(args))
+			for i, a := range args {
+				parts[i] = Format(a)
+			}
+			h.opts.Logger.Info("script log", "hook", hk.Name, "msg", strings.Join(parts, " "))
+			return nil, nil
+		}},
+		"now": &Builtin{"now", func(_ *Thread, args []Value) (Value, error) {
+			if err := arity(args, 0); err != nil {
+				return nil, err
+			}
+			return h.clk.Now().Unix(), nil
+		}},
+	}
+	if hk.Validates() {
+		g["reject"] = &Builtin{"reject", func(_ *Thread, args []Value) (Value, error) {
+			if err := arity(args, 1); err != nil {
+				return nil, err
+			}
+			return nil, &rejected{Format(args[0])}
+		}}
+	}
+	return g
+}
+
+func (h *Hooks) run(ctx context.Context, hk *Hook, globals map[string]Value) error {
+	g := h.host(hk)
+	for k, v := range globals {
+		g[k] = v
+	}
+	res, err := hk.prog.Run(ctx, g, h.opts.Limits)
+	var rej *rejected
+	isRej := errors.As(err, &rej)
+	h.mu.Lock()
+	s := h.stats[hk.Name]
+	s.Runs++
+	s.Steps += int64(res.Steps)
+	switch {
+	case isRej:
+		s.Rejected++
+	case err != nil:
+		s.Failures++
+	}
+	h.mu.Unlock()
+	if isRej {
+		return users.Reject(rej.reason)
+	}
+	if err != nil {
+		return fmt.Errorf("script hook %w", err)
+	}
+	return nil
+}
+
+// Validate runs the validate hooks on u, for users.WithValidator. The
+// first reject() turns the registration down with its reason. A hook
+// that fails (a runtime error, a limit) fails the registration too, as a
+// validator plugin's error does: passing a check that did not run would
+// make every broken script an open door.
+func (h *Hooks) Validate(ctx context.Context, u users.User) error {
+	var user Value
+	for _, hk := range h.hooks {
+		if !hk.Validates() {
+			continue
+		}
+		if user == nil {
+			var err error
+			if user, err = ToValue(u); err != nil {
+				return err
+			}
+		}
+		// Each hook gets its own copy: one cannot edit what the next sees.
+		if err := h.run(ctx, hk, map[string]Value{"user": copyValue(user)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handle runs the hooks of ev's topic, "*" hooks included, in order, and
+// returns their errors joined. It is how a host without a bus (or a dry
+// run) delivers an event.
+func (h *Hooks) Handle(ctx context.Context, ev eventbus.Event) error {
+	return h.handle(ctx, ev, func(topic string) bool { return topic == ev.Topic || topic == eventbus.AllTopics })
+}
+
+func (h *Hooks) handle(ctx context.Context, ev eventbus.Event, match func(topic string) bool) error {
+	var errs []error
+	for _, hk := range h.hooks {
+		if hk.Validates() || !match(hk.Topic) {
+			continue
+		}
+		payload, err := ToValue(ev.Payload)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		g := map[string]Value{"event": Map{"topic": ev.Topic, "at": ev.At.Unix(), "payload": payload}}
+		if _, ok := ev.Payload.(users.User); ok {
+			g["user"] = payload
+		}
+		errs = append(errs, h.run(ctx, hk, g))
+	}
+	return errors.Join(errs...)
+}
+
+// Subscribe attaches the event hooks to bus, one subscription per topic
+// (the bus itself delivers every topic to "*"). Delivery is asynchronous;
+// a failing hook is logged and counted, not retried.
+func (h *Hooks) Subscribe(bus *eventbus.Bus) error {
+	var topics []string
+	for _, hk := range h.hooks {
+		if !hk.Validates() && !slices.Contains(topics, hk.Topic) {
+			topics = append(topics, hk.Topic)
+		}
+	}
+	for _, t := range topics {
+		_, err := bus.Subscribe(t, func(ev eventbus.Event) {
+			if err := h.handle(context.Background(), ev, func(topic string) bool { return topic == t }); err != nil {
+				h.opts.Logger.Warn("script hook failed", "topic", ev.Topic, "err", err)
+			}
+		}, eventbus.SubscribeOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns a copy of every hook's counters by name.
+func (h *Hooks) Stats() map[string]HookStat {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make(map[string]HookStat, len(h.stats))
+	for name, s := range h.stats {
+		out[name] = *s
+	}
+	return out
+}
+
+func copyValue(v Value) Value {
+	switch v := v.(type) {
+	case List:
+		out := make(List, len(v))
+		for i, e := range v {
+			out[i] = copyValue(e)
+		}
+		return out
+	case Map:
+		out := make(Map, len(v))
+		for k, e := range v {
+			out[k] = copyValue(e)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,583 @@
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSteps  = errors.New("script: step limit exceeded")
+	ErrDepth  = errors.New("script: call depth exceeded")
+	ErrMemory = errors.New("script: memory limit exceeded")
+)
+
+// Error is a syntax or runtime error, with where it happened. Err is the
+// cause when there is one: a limit (ErrSteps, context.DeadlineExceeded,
+// ...) or an error returned by a Builtin.
+type Error struct {
+	Script string
+	Pos    string // line:col
+	Msg    string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	where := e.Pos
+	if e.Script != "" {
+		where = e.Script + ":" + where
+	}
+	return where + ": " + e.Msg
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Program is a parsed script. It holds no state between runs and may be
+// run from several goroutines at once.
+type Program struct {
+	name string
+	body []stmt
+}
+
+// Compile parses src; name is only used in error messages.
+func Compile(name, src string) (*Program, error) {
+	body, err := parse(src)
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) {
+			e.Script = name
+		}
+		return nil, err
+	}
+	return &Program{name: name, body: body}, nil
+}
+
+func (p *Program) Name() string { return p.name }
+
+// Limits bound one run. A script can loop forever or build a value as
+// large as it likes; these turn either into an error instead.
+type Limits struct {
+	// Steps bounds the statements and expressions evaluated; default
+	// 100000.
+	Steps int
+	// Depth bounds nested calls of script functions; default 32.
+	Depth int
+	// Memory bounds, roughly, the bytes of the strings, lists and maps
+	// the run creates, freed or not; default 1 MiB.
+	Memory int
+	// Timeout bounds the run's wall-clock time, on top of the context's
+	// deadline; default 100ms.
+	Timeout time.Duration
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.Steps <= 0 {
+		l.Steps = 100000
+	}
+	if l.Depth <= 0 {
+		l.Depth = 32
+	}
+	if l.Memory <= 0 {
+		l.Memory = 1 << 20
+	}
+	if l.Timeout <= 0 {
+		l.Timeout = 100 * time.Millisecond
+	}
+	return l
+}
+
+// Result is a completed run: what a top-level return returned (nil if
+// none did) and the steps it took.
+type Result struct {
+	Value Value
+	Steps int
+}
+
+// Run executes the program. globals are the names the host provides on
+// top of the builtins; a script sees nothing else.
+func (p *Program) Run(ctx context.Context, globals map[string]Value, lim Limits) (res Result, err error) {
+	lim = lim.withDefaults()
+	ctx, cancel := context.WithTimeout(ctx, lim.Timeout)
+	defer cancel()
+	t := &Thread{ctx: ctx, lim: lim, script: p.name}
+	defer func() {
+		res.Steps = t.steps
+		if r := recover(); r != nil {
+			err = t.recovered(r)
+		}
+	}()
+	top := &scope{vars: make(map[string]Value, len(globals)), parent: &scope{vars: builtins, frozen: true}}
+	for k, v := range globals {
+		top.vars[k] = v
+	}
+	ctl, v := t.exec(p.body, &scope{vars: map[string]Value{}, parent: top})
+	if ctl == ctlReturn {
+		res.Value = v
+	}
+	return res, nil
+}
+
+// Thread is the state of one run, handed to builtins.
+type Thread struct {
+	ctx    context.Context
+	lim    Limits
+	script string
+	steps  int
+	depth  int
+	memory int
+	at     pos // the node being evaluated, for builtin errors
+}
+
+func (t *Thread) Context() context.Context { return t.ctx }
+
+// Alloc charges n bytes to the run's memory limit. Builtins that build
+// values call it before doing so.
+func (t *Thread) Alloc(n int) {
+	if t.memory += n; t.memory > t.lim.Memory {
+		t.fail(t.at, ErrMemory, "memory limit exceeded")
+	}
+}
+
+func (t *Thread) step(at pos) {
+	t.at = at
+	if t.steps++; t.steps > t.lim.Steps {
+		t.fail(at, ErrSteps, "step limit exceeded")
+	}
+	if t.steps%256 == 0 {
+		if err := t.ctx.Err(); err != nil {
+			t.fail(at, err, err.Error())
+		}
+	}
+}
+
+// runtimeError unwinds the evaluator; Run recovers it.
+type runtimeError struct{ err *Error }
+
+func (t *Thread) fail(at pos, cause error, msg string) {
+	panic(runtimeError{&Error{Script: t.script, Pos: at.String(), Msg: msg, Err: cause}})
+}
+
+func (t *Thread) failf(at pos, format string, args ...any) {
+	t.fail(at, nil, fmt.Sprintf(format, args...))
+}
+
+// recovered turns a panic into Run's error. Anything but a runtimeError
+// is a bug in a builtin, reported rather than allowed to take the host
+// down.
+func (t *Thread) recovered(r any) error {
+	if re, ok := r.(runtimeError); ok {
+		return re.err
+	}
+	return &Error{Script: t.script, Pos: t.at.String(), Msg: fmt.Sprintf("internal error: %v", r)}
+}
+
+/*
+-----------------------------------
+SCOPES
+-----------------------------------
+*/
+
+type scope struct {
+	vars   map[string]Value
+	parent *scope
+	frozen bool // the builtins, shared by every run
+}
+
+func (s *scope) lookup(name string) (*scope, bool) {
+	for ; s != nil; s = s.parent {
+		if _, ok := s.vars[name]; ok {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+/*
+-----------------------------------
+STATEMENTS
+-----------------------------------
+*/
+
+type control int
+
+const (
+	ctlNone control = iota
+	ctlBreak
+	ctlContinue
+	ctlReturn
+)
+
+func (t *Thread) exec(body []stmt, sc *scope) (control, Value) {
+	for _, s := range body {
+		if ctl, v := t.stmt(s, sc); ctl != ctlNone {
+			return ctl, v
+		}
+	}
+	return ctlNone, nil
+}
+
+func (t *Thread) stmt(s stmt, sc *scope) (control, Value) {
+	t.step(s.at())
+	switch s := s.(type) {
+	case *exprStmt:
+		t.eval(s.x, sc)
+	case *defineStmt:
+		// A function sees the scope it is defined in, itself included, so
+		// it can recurse.
+		sc.vars[s.name] = t.eval(s.x, sc)
+	case *assignStmt:
+		t.assign(s, sc)
+	case *ifStmt:
+		if truthy(t.eval(s.cond, sc)) {
+			return t.exec(s.then, t.child(sc))
+		}
+		if s.els != nil {
+			return t.exec(s.els, t.child(sc))
+		}
+	case *whileStmt:
+		for s.cond == nil || truthy(t.eval(s.cond, sc)) {
+			t.step(s.p)
+			ctl, v := t.exec(s.body, t.child(sc))
+			if ctl == ctlBreak {
+				break
+			}
+			if ctl == ctlReturn {
+				return ctl, v
+			}
+		}
+	case *forInStmt:
+		return t.forIn(s, sc)
+	case *returnStmt:
+		var v Value
+		if s.x != nil {
+			v = t.eval(s.x, sc)
+		}
+		return ctlReturn, v
+	case *branchStmt:
+		if s.brk {
+			return ctlBreak, nil
+		}
+		return ctlContinue, nil
+	}
+	return ctlNone, nil
+}
+
+func (t *Thread) child(sc *scope) *scope {
+	return &scope{vars: make(map[string]Value, 2), parent: sc}
+}
+
+// forIn iterates a list (index, element), a map (key, value, keys in
+// order) or a string (index, one-character string). The loop variables
+// are fresh on every iteration.
+func (t *Thread) forIn(s *forInStmt, sc *scope) (control, Value) {
+	x := t.eval(s.x, sc)
+	body := func(k, v Value) (bool, control, Value) {
+		t.step(s.p)
+		inner := t.child(sc)
+		if s.key != "" {
+			inner.vars[s.key] = k
+		}
+		inner.vars[s.val] = v
+		ctl, rv := t.exec(s.body, inner)
+		switch ctl {
+		case ctlBreak:
+			return false, ctlNone, nil
+		case ctlReturn:
+			return false, ctl, rv
+		}
+		return true, ctlNone, nil
+	}
+	switch x := x.(type) {
+	case List:
+		for i, e := range x {
+			if more, ctl, v := body(int64(i), e); !more {
+				return ctl, v
+			}
+		}
+	case Map:
+		for _, k := range sortedKeys(x) {
+			if more, ctl, v := body(k, x[k]); !more {
+				return ctl, v
+			}
+		}
+	case string:
+		for i, r := range x {
+			if more, ctl, v := body(int64(i), string(r)); !more {
+				return ctl, v
+			}
+		}
+	default:
+		t.failf(s.x.at(), "cannot iterate over %s", typeName(x))
+	}
+	return ctlNone, nil
+}
+
+func (t *Thread) assign(s *assignStmt, sc *scope) {
+	v := t.eval(s.x, sc)
+	switch target := s.target.(type) {
+	case *identExpr:
+		owner, ok := sc.lookup(target.name)
+		switch {
+		case !ok:
+			t.failf(target.p, "undefined: %s (declare it with :=)", target.name)
+		case owner.frozen:
+			t.failf(target.p, "cannot assign to builtin %s", target.name)
+		}
+		owner.vars[target.name] = v
+	case *fieldExpr:
+		m, ok := t.eval(target.x, sc).(Map)
+		if !ok {
+			t.failf(target.p, "cannot set field of a non-map")
+		}
+		t.setKey(m, target.name, v)
+	case *indexExpr:
+		switch x := t.eval(target.x, sc).(type) {
+		case List:
+			x[t.listIndex(x, t.eval(target.index, sc), target.p)] = v
+		case Map:
+			k, ok := t.eval(target.index, sc).(string)
+			if !ok {
+				t.failf(target.p, "map keys are strings")
+			}
+			t.setKey(x, k, v)
+		default:
+			t.failf(target.p, "cannot index %s", typeName(x))
+		}
+	}
+}
+
+func (t *Thread) setKey(m Map, k string, v Value) {
+	if _, ok := m[k]; !ok {
+		t.Alloc(16 + len(k))
+	}
+	m[k] = v
+}
+
+func (t *Thread) listIndex(l List, i Value, at pos) int {
+	n, ok := i.(int64)
+	if !ok {
+		t.failf(at, "list index must be int, not %s", typeName(i))
+	}
+	if n < 0 {
+		n += int64(len(l))
+	}
+	if n < 0 || n >= int64(len(l)) {
+		t.failf(at, "index %d out of range (len %d)", i, len(l))
+	}
+	return int(n)
+}
+
+/*
+-----------------------------------
+EXPRESSIONS
+-----------------------------------
+*/
+
+func (t *Thread) eval(e expr, sc *scope) Value {
+	t.step(e.at())
+	switch e := e.(type) {
+	case *litExpr:
+		return e.v
+	case *identExpr:
+		owner, ok := sc.lookup(e.name)
+		if !ok {
+			t.failf(e.p, "undefined: %s", e.name)
+		}
+		return owner.vars[e.name]
+	case *listExpr:
+		t.Alloc(16 * len(e.elems))
+		l := make(List, len(e.elems))
+		for i, x := range e.elems {
+			l[i] = t.eval(x, sc)
+		}
+		return l
+	case *mapExpr:
+		m := make(Map, len(e.keys))
+		for i, kx := range e.keys {
+			k, ok := t.eval(kx, sc).(string)
+			if !ok {
+				t.failf(kx.at(), "map keys are strings")
+			}
+			t.setKey(m, k, t.eval(e.vals[i], sc))
+		}
+		return m
+	case *fnExpr:
+		return &closure{fn: e, env: sc}
+	case *unaryExpr:
+		x := t.eval(e.x, sc)
+		if e.op == "!" {
+			return !truthy(x)
+		}
+		n, ok := x.(int64)
+		if !ok {
+			t.failf(e.p, "cannot negate %s", typeName(x))
+		}
+		return -n
+	case *binExpr:
+		return t.binary(e, sc)
+	case *fieldExpr:
+		x := t.eval(e.x, sc)
+		m, ok := x.(Map)
+		if !ok {
+			t.failf(e.p, "%s has no field %s", typeName(x), e.name)
+		}
+		return m[e.name] // a missing field is nil
+	case *indexExpr:
+		switch x := t.eval(e.x, sc).(type) {
+		case List:
+			return x[t.listIndex(x, t.eval(e.index, sc), e.p)]
+		case Map:
+			k, ok := t.eval(e.index, sc).(string)
+			if !ok {
+				t.failf(e.p, "map keys are strings")
+			}
+			return x[k]
+		default:
+			t.failf(e.p, "cannot index %s", typeName(x))
+		}
+	case *callExpr:
+		return t.call(e, sc)
+	}
+	panic(fmt.Sprintf("script: unknown node %T", e))
+}
+
+func (t *Thread) binary(e *binExpr, sc *scope) Value {
+	switch e.op {
+	case "&&":
+		return truthy(t.eval(e.x, sc)) && truthy(t.eval(e.y, sc))
+	case "||":
+		return truthy(t.eval(e.x, sc)) || truthy(t.eval(e.y, sc))
+	}
+	x, y := t.eval(e.x, sc), t.eval(e.y, sc)
+	switch e.op {
+	case "==":
+		return equal(x, y)
+	case "!=":
+		return !equal(x, y)
+	case "in":
+		return t.contains(y, x, e.p)
+	}
+	switch x := x.(type) {
+	case int64:
+		if y, ok := y.(int64); ok {
+			return t.arith(e, x, y)
+		}
+	case string:
+		y, ok := y.(string)
+		if !ok {
+			break
+		}
+		switch e.op {
+		case "+":
+			t.Alloc(len(x) + len(y))
+			return x + y
+		case "<":
+			return x < y
+		case "<=":
+			return x <= y
+		case ">":
+			return x > y
+		case ">=":
+			return x >= y
+		}
+	case List:
+		if y, ok := y.(List); ok && e.op == "+" {
+			t.Alloc(16 * (len(x) + len(y)))
+			return append(append(make(List, 0, len(x)+len(y)), x...), y...)
+		}
+	}
+	t.failf(e.p, "invalid operation: %s %s %s", typeName(x), e.op, typeName(y))
+	return nil
+}
+
+func (t *Thread) arith(e *binExpr, x, y int64) Value {
+	switch e.op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/", "%":
+		if y == 0 {
+			t.failf(e.p, "division by zero")
+		}
+		if e.op == "/" {
+			return x / y
+		}
+		return x % y
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	case ">=":
+		return x >= y
+	}
+	t.failf(e.p, "invalid operation: int %s int", e.op)
+	return nil
+}
+
+// contains is "x in coll".
+func (t *Thread) contains(coll, x Value, at pos) bool {
+	switch c := coll.(type) {
+	case List:
+		for _, e := range c {
+			t.step(at)
+			if equal(e, x) {
+				return true
+			}
+		}
+		return false
+	case Map:
+		k, ok := x.(string)
+		_, found := c[k]
+		return ok && found
+	case string:
+		s, ok := x.(string)
+		if !ok {
+			t.failf(at, "invalid operation: %s in string", typeName(x))
+		}
+		return strings.Contains(c, s)
+	}
+	t.failf(at, "cannot use in on %s", typeName(coll))
+	return false
+}
+
+func (t *Thread) call(e *callExpr, sc *scope) Value {
+	fn := t.eval(e.fn, sc)
+	args := make([]Value, len(e.args))
+	for i, a := range e.args {
+		args[i] = t.eval(a, sc)
+	}
+	switch fn := fn.(type) {
+	case *Builtin:
+		t.at = e.p
+		v, err := fn.Fn(t, args)
+		if err != nil {
+			t.fail(e.p, err, fn.Name+": "+err.Error())
+		}
+		return v
+	case *closure:
+		if len(args) != len(fn.fn.params) {
+			t.failf(e.p, "%s takes %d arguments, got %d", Format(fn), len(fn.fn.params), len(args))
+		}
+		if t.depth++; t.depth > t.lim.Depth {
+			t.fail(e.p, ErrDepth, "call depth exceeded")
+		}
+		defer func() { t.depth-- }()
+		inner := &scope{vars: make(map[string]Value, len(args)), parent: fn.env}
+		for i, name := range fn.fn.params {
+			inner.vars[name] = args[i]
+		}
+		if ctl, v := t.exec(fn.fn.body, inner); ctl == ctlReturn {
+			return v
+		}
+		return nil
+	}
+	t.failf(e.p, "cannot call %s", typeName(fn))
+	return nil
+}
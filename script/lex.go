@@ -0,0 +1,181 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+-----------------------------------
+LEXER
+-----------------------------------
+*/
+
+type tokenKind int
+
+const (
+	tEOF tokenKind = iota
+	tIdent
+	tInt
+	tString
+	tSemi // ";" or an inserted end of line
+	tPunct
+	tKeyword
+)
+
+type pos struct{ line, col int }
+
+func (p pos) String() string { return fmt.Sprintf("%d:%d", p.line, p.col) }
+
+type token struct {
+	kind tokenKind
+	text string // identifier, keyword, punctuation; the decoded string literal
+	num  int64
+	pos  pos
+}
+
+var keywords = map[string]bool{
+	"if": true, "else": true, "for": true, "in": true, "fn": true,
+	"return": true, "break": true, "continue": true,
+	"true": true, "false": true, "nil": true,
+}
+
+// Longest first, so ":=" wins over ":".
+var puncts = []string{
+	":=", "==", "!=", "<=", ">=", "&&", "||",
+	"(", ")", "[", "]", "{", "}", ",", ":", ".", ";", "=", "<", ">", "+", "-", "*", "/", "%", "!",
+}
+
+// lex splits src into tokens. As in Go, a newline ends a statement when
+// the line's last token could end one, so scripts need no semicolons.
+func lex(src string) ([]token, error) {
+	var toks []token
+	line, col := 1, 1
+	i := 0
+	endsStmt := func() bool {
+		if len(toks) == 0 {
+			return false
+		}
+		t := toks[len(toks)-1]
+		switch t.kind {
+		case tIdent, tInt, tString:
+			return true
+		case tKeyword:
+			return t.text == "true" || t.text == "false" || t.text == "nil" ||
+				t.text == "return" || t.text == "break" || t.text == "continue"
+		case tPunct:
+			return t.text == ")" || t.text == "]" || t.text == "}"
+		}
+		return false
+	}
+	advance := func(n int) {
+		for _, r := range src[i : i+n] {
+			if r == '\n' {
+				line, col = line+1, 1
+			} else {
+				col++
+			}
+		}
+		i += n
+	}
+	for i < len(src) {
+		c := src[i]
+		at := pos{line, col}
+		switch {
+		case c == '\n':
+			if endsStmt() {
+				toks = append(toks, token{kind: tSemi, text: "\n", pos: at})
+			}
+			advance(1)
+		case c == ' ' || c == '\t' || c == '\r':
+			advance(1)
+		case c == '#' || strings.HasPrefix(src[i:], "//"):
+			n := strings.IndexByte(src[i:], '\n')
+			if n < 0 {
+				n = len(src) - i
+			}
+			advance(n)
+		case c == '"':
+			n, s, err := quoted(src[i:])
+			if err != nil {
+				return nil, &Error{Pos: at.String(), Msg: err.Error()}
+			}
+			toks = append(toks, token{kind: tString, text: s, pos: at})
+			advance(n)
+		case c >= '0' && c <= '9':
+			n := 0
+			for i+n < len(src) && (src[i+n] >= '0' && src[i+n] <= '9' || src[i+n] == '_') {
+				n++
+			}
+			v, err := strconv.ParseInt(strings.ReplaceAll(src[i:i+n], "_", ""), 10, 64)
+			if err != nil {
+				return nil, &Error{Pos: at.String(), Msg: "bad number " + src[i:i+n]}
+			}
+			toks = append(toks, token{kind: tInt, num: v, text: src[i : i+n], pos: at})
+			advance(n)
+		default:
+			r, size := utf8.DecodeRuneInString(src[i:])
+			if r == '_' || unicode.IsLetter(r) {
+				n := size
+				for i+n < len(src) {
+					r, size := utf8.DecodeRuneInString(src[i+n:])
+					if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+						break
+					}
+					n += size
+				}
+				word := src[i : i+n]
+				kind := tIdent
+				if keywords[word] {
+					kind = tKeyword
+				}
+				toks = append(toks, token{kind: kind, text: word, pos: at})
+				advance(n)
+				continue
+			}
+			var p string
+			for _, cand := range puncts {
+				if strings.HasPrefix(src[i:], cand) {
+					p = cand
+					break
+				}
+			}
+			if p == "" {
+				return nil, &Error{Pos: at.String(), Msg: fmt.Sprintf("unexpected character %q", r)}
+			}
+			kind := tPunct
+			if p == ";" {
+				kind = tSemi
+			}
+			toks = append(toks, token{kind: kind, text: p, pos: at})
+			advance(len(p))
+		}
+	}
+	if endsStmt() {
+		toks = append(toks, token{kind: tSemi, text: "\n", pos: pos{line, col}})
+	}
+	return append(toks, token{kind: tEOF, pos: pos{line, col}}), nil
+}
+
+// quoted decodes the string literal at the start of s, Go escapes
+// included, and returns its length in s.
+func quoted(s string) (int, string, error) {
+	for n := 1; n < len(s); n++ {
+		switch s[n] {
+		case '\\':
+			n++
+		case '\n':
+			return 0, "", fmt.Errorf("newline in string")
+		case '"':
+			v, err := strconv.Unquote(s[:n+1])
+			if err != nil {
+				return 0, "", fmt.Errorf("bad string %s", s[:n+1])
+			}
+			return n + 1, v, nil
+		}
+	}
+	return 0, "", fmt.Errorf("unterminated string")
+}
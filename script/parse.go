@@ -0,0 +1,527 @@
+package script
+
+import "fmt"
+
+/*
+-----------------------------------
+SYNTAX TREE
+-----------------------------------
+*/
+
+type (
+	expr interface{ at() pos }
+	stmt interface{ at() pos }
+)
+
+type (
+	litExpr struct {
+		p pos
+		v Value
+	}
+	identExpr struct {
+		p    pos
+		name string
+	}
+	listExpr struct {
+		p     pos
+		elems []expr
+	}
+	mapExpr struct {
+		p          pos
+		keys, vals []expr
+	}
+	unaryExpr struct {
+		p  pos
+		op string
+		x  expr
+	}
+	binExpr struct {
+		p    pos
+		op   string
+		x, y expr
+	}
+	fieldExpr struct {
+		p    pos
+		x    expr
+		name string
+	}
+	indexExpr struct {
+		p        pos
+		x, index expr
+	}
+	callExpr struct {
+		p    pos
+		fn   expr
+		args []expr
+	}
+	fnExpr struct {
+		p      pos
+		name   string
+		params []string
+		body   []stmt
+	}
+)
+
+type (
+	exprStmt struct {
+		p pos
+		x expr
+	}
+	// name := x
+	defineStmt struct {
+		p    pos
+		name string
+		x    expr
+	}
+	// target = x
+	assignStmt struct {
+		p         pos
+		target, x expr
+	}
+	ifStmt struct {
+		p         pos
+		cond      expr
+		then, els []stmt
+	}
+	forInStmt struct {
+		p        pos
+		key, val string
+		x        expr
+		body     []stmt
+	}
+	// cond nil: forever
+	whileStmt struct {
+		p    pos
+		cond expr
+		body []stmt
+	}
+	returnStmt struct {
+		p pos
+		x expr
+	}
+	branchStmt struct {
+		p   pos
+		brk bool
+	}
+)
+
+func (e *litExpr) at() pos    { return e.p }
+func (e *identExpr) at() pos  { return e.p }
+func (e *listExpr) at() pos   { return e.p }
+func (e *mapExpr) at() pos    { return e.p }
+func (e *unaryExpr) at() pos  { return e.p }
+func (e *binExpr) at() pos    { return e.p }
+func (e *fieldExpr) at() pos  { return e.p }
+func (e *indexExpr) at() pos  { return e.p }
+func (e *callExpr) at() pos   { return e.p }
+func (e *fnExpr) at() pos     { return e.p }
+func (s *exprStmt) at() pos   { return s.p }
+func (s *defineStmt) at() pos { return s.p }
+func (s *assignStmt) at() pos { return s.p }
+func (s *ifStmt) at() pos     { return s.p }
+func (s *forInStmt) at() pos  { return s.p }
+func (s *whileStmt) at() pos  { return s.p }
+func (s *returnStmt) at() pos { return s.p }
+func (s *branchStmt) at() pos { return s.p }
+
+/*
+-----------------------------------
+PARSER
+-----------------------------------
+*/
+
+// parser is recursive descent over the token list. noBrace is set while
+// parsing an if or for header, where "{" opens the body rather than a
+// map literal (the same rule Go has for composite literals).
+type parser struct {
+	toks    []token
+	i       int
+	noBrace bool
+	loops   int // enclosing loops in the current function
+}
+
+// bail unwinds the parser; parse turns it back into an error.
+type bail struct{ err *Error }
+
+func parse(src string) ([]stmt, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	return p.program()
+}
+
+func (p *parser) program() (body []stmt, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b, ok := r.(bail)
+			if !ok {
+				panic(r)
+			}
+			err = b.err
+		}
+	}()
+	for p.peek().kind != tEOF {
+		body = append(body, p.stmt())
+		p.endStmt()
+	}
+	return body, nil
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tPunct || t.kind == tKeyword) && t.text == text
+}
+
+func (p *parser) failf(at pos, format string, args ...any) {
+	panic(bail{&Error{Pos: at.String(), Msg: fmt.Sprintf(format, args...)}})
+}
+
+func (p *parser) expect(text string) token {
+	t := p.peek()
+	if !p.is(text) {
+		p.failf(t.pos, "expected %q, found %s", text, describe(t))
+	}
+	return p.next()
+}
+
+func (p *parser) ident() token {
+	t := p.peek()
+	if t.kind != tIdent {
+		p.failf(t.pos, "expected name, found %s", describe(t))
+	}
+	return p.next()
+}
+
+// endStmt accepts the end of a statement: a semicolon, or nothing before
+// a closing brace or the end of input.
+func (p *parser) endStmt() {
+	switch t := p.peek(); {
+	case t.kind == tSemi:
+		p.next()
+	case t.kind == tEOF || p.is("}"):
+	default:
+		p.failf(t.pos, "unexpected %s at end of statement", describe(t))
+	}
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tEOF:
+		return "end of script"
+	case tSemi:
+		if t.text == "\n" {
+			return "end of line"
+		}
+		return `";"`
+	case tString:
+		return fmt.Sprintf("string %q", t.text)
+	case tInt:
+		return "number " + t.text
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func (p *parser) block() []stmt {
+	p.expect("{")
+	saved := p.noBrace
+	p.noBrace = false
+	var body []stmt
+	for !p.is("}") {
+		if p.peek().kind == tEOF {
+			p.failf(p.peek().pos, "missing }")
+		}
+		if p.peek().kind == tSemi {
+			p.next()
+			continue
+		}
+		body = append(body, p.stmt())
+		p.endStmt()
+	}
+	p.next()
+	p.noBrace = saved
+	return body
+}
+
+// header parses an if or for condition.
+func (p *parser) header() expr {
+	saved := p.noBrace
+	p.noBrace = true
+	x := p.expr()
+	p.noBrace = saved
+	return x
+}
+
+func (p *parser) stmt() stmt {
+	t := p.peek()
+	switch {
+	case p.is("fn") && p.toks[p.i+1].kind == tIdent:
+		f := p.fn()
+		return &defineStmt{p: t.pos, name: f.name, x: f}
+	case p.is("if"):
+		return p.ifStmt()
+	case p.is("for"):
+		return p.forStmt()
+	case p.is("return"):
+		p.next()
+		s := &returnStmt{p: t.pos}
+		if n := p.peek(); n.kind != tSemi && n.kind != tEOF && !p.is("}") {
+			s.x = p.expr()
+		}
+		return s
+	case p.is("break"), p.is("continue"):
+		if p.loops == 0 {
+			p.failf(t.pos, "%s outside a loop", t.text)
+		}
+		p.next()
+		return &branchStmt{p: t.pos, brk: t.text == "break"}
+	case t.kind == tIdent && p.toks[p.i+1].text == ":=":
+		p.next()
+		p.next()
+		return &defineStmt{p: t.pos, name: t.text, x: p.expr()}
+	}
+	x := p.expr()
+	if p.is("=") {
+		switch x.(type) {
+		case *identExpr, *indexExpr, *fieldExpr:
+		default:
+			p.failf(p.peek().pos, "cannot assign to this expression")
+		}
+		p.next()
+		return &assignStmt{p: t.pos, target: x, x: p.expr()}
+	}
+	return &exprStmt{p: t.pos, x: x}
+}
+
+func (p *parser) ifStmt() stmt {
+	t := p.expect("if")
+	s := &ifStmt{p: t.pos, cond: p.header()}
+	s.then = p.block()
+	if p.is("else") {
+		p.next()
+		if p.is("if") {
+			s.els = []stmt{p.ifStmt()}
+		} else {
+			s.els = p.block()
+		}
+	}
+	return s
+}
+
+// forStmt parses the three loops: "for k, v in x {}", "for cond {}" and
+// "for {}".
+func (p *parser) forStmt() stmt {
+	t := p.expect("for")
+	if p.peek().kind == tIdent {
+		if n := p.toks[p.i+1]; n.text == "in" || n.text == "," {
+			s := &forInStmt{p: t.pos, val: p.next().text}
+			if p.is(",") {
+				p.next()
+				s.key, s.val = s.val, p.ident().text
+			}
+			p.expect("in")
+			s.x = p.header()
+			s.body = p.loopBody()
+			return s
+		}
+	}
+	s := &whileStmt{p: t.pos}
+	if !p.is("{") {
+		s.cond = p.header()
+	}
+	s.body = p.loopBody()
+	return s
+}
+
+func (p *parser) loopBody() []stmt {
+	p.loops++
+	defer func() { p.loops-- }()
+	return p.block()
+}
+
+func (p *parser) fn() *fnExpr {
+	t := p.expect("fn")
+	f := &fnExpr{p: t.pos}
+	if p.peek().kind == tIdent {
+		f.name = p.next().text
+	}
+	p.expect("(")
+	for !p.is(")") {
+		f.params = append(f.params, p.ident().text)
+		if !p.is(")") {
+			p.expect(",")
+		}
+	}
+	p.next()
+	saved := p.loops
+	p.loops = 0
+	f.body = p.block()
+	p.loops = saved
+	return f
+}
+
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3, "in": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+}
+
+func (p *parser) expr() expr { return p.binary(1) }
+
+func (p *parser) binary(min int) expr {
+	x := p.unary()
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.text]
+		if !ok || (t.kind != tPunct && t.kind != tKeyword) || prec < min {
+			return x
+		}
+		p.next()
+		x = &binExpr{p: t.pos, op: t.text, x: x, y: p.binary(prec + 1)}
+	}
+}
+
+func (p *parser) unary() expr {
+	if t := p.peek(); p.is("!") || p.is("-") {
+		p.next()
+		return &unaryExpr{p: t.pos, op: t.text, x: p.unary()}
+	}
+	return p.postfix(p.primary())
+}
+
+func (p *parser) postfix(x expr) expr {
+	for {
+		t := p.peek()
+		switch {
+		case p.is("."):
+			p.next()
+			x = &fieldExpr{p: t.pos, x: x, name: p.ident().text}
+		case p.is("["):
+			p.next()
+			saved := p.noBrace
+			p.noBrace = false
+			x = &indexExpr{p: t.pos, x: x, index: p.expr()}
+			p.noBrace = saved
+			p.expect("]")
+		case p.is("("):
+			p.next()
+			c := &callExpr{p: t.pos, fn: x}
+			c.args = p.list(")")
+			x = c
+		default:
+			return x
+		}
+	}
+}
+
+// list parses comma-separated expressions up to close, allowing a
+// trailing comma and line breaks after one.
+func (p *parser) list(close string) []expr {
+	saved := p.noBrace
+	p.noBrace = false
+	var out []expr
+	for {
+		p.skipLines()
+		if p.is(close) {
+			break
+		}
+		out = append(out, p.expr())
+		p.skipLines()
+		if !p.is(close) {
+			p.expect(",")
+		}
+	}
+	p.next()
+	p.noBrace = saved
+	return out
+}
+
+// skipLines drops inserted statement ends inside brackets, where a line
+// break cannot end a statement.
+func (p *parser) skipLines() {
+	for t := p.peek(); t.kind == tSemi && t.text == "\n"; t = p.peek() {
+		p.next()
+	}
+}
+
+func (p *parser) primary() expr {
+	t := p.peek()
+	switch {
+	case t.kind == tInt:
+		p.next()
+		return &litExpr{p: t.pos, v: t.num}
+	case t.kind == tString:
+		p.next()
+		return &litExpr{p: t.pos, v: t.text}
+	case t.kind == tIdent:
+		p.next()
+		return &identExpr{p: t.pos, name: t.text}
+	case p.is("true"), p.is("false"):
+		p.next()
+		return &litExpr{p: t.pos, v: t.text == "true"}
+	case p.is("nil"):
+		p.next()
+		return &litExpr{p: t.pos, v: nil}
+	case p.is("fn"):
+		return p.fn()
+	case p.is("("):
+		p.next()
+		saved := p.noBrace
+		p.noBrace = false
+		x := p.expr()
+		p.noBrace = saved
+		p.expect(")")
+		return x
+	case p.is("["):
+		p.next()
+		return &listExpr{p: t.pos, elems: p.list("]")}
+	case p.is("{") && !p.noBrace:
+		p.next()
+		return p.mapLit(t.pos)
+	}
+	p.failf(t.pos, "unexpected %s", describe(t))
+	return nil
+}
+
+func (p *parser) mapLit(at pos) expr {
+	saved := p.noBrace
+	p.noBrace = false
+	m := &mapExpr{p: at}
+	for {
+		p.skipLines()
+		if p.is("}") {
+			break
+		}
+		var key expr
+		if t := p.peek(); t.kind == tIdent && p.toks[p.i+1].text == ":" {
+			// {name: v} is {"name": v}, as in JavaScript.
+			p.next()
+			key = &litExpr{p: t.pos, v: t.text}
+		} else {
+			key = p.expr()
+		}
+		p.expect(":")
+		m.keys = append(m.keys, key)
+		m.vals = append(m.vals, p.expr())
+		p.skipLines()
+		if !p.is("}") {
+			p.expect(",")
+		}
+	}
+	p.next()
+	p.noBrace = saved
+	return m
+}
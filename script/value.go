@@ -0,0 +1,227 @@
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+-----------------------------------
+VALUES
+-----------------------------------
+*/
+
+// Value is what scripts compute with: nil, bool, int64, string, List,
+// Map, or a function (*Builtin, or one the script defined).
+type Value = any
+
+type (
+	List []Value
+	// Map keys are strings: m.name and m["name"] are the same lookup.
+	Map map[string]Value
+)
+
+// Builtin is a function of the host API. It returns an error to stop the
+// script; the error reaches the caller of Run wrapped in *Error.
+type Builtin struct {
+	Name string
+	Fn   func(t *Thread, args []Value) (Value, error)
+}
+
+type closure struct {
+	fn  *fnExpr
+	env *scope
+}
+
+func typeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case string:
+		return "string"
+	case List:
+		return "list"
+	case Map:
+		return "map"
+	case *Builtin, *closure:
+		return "function"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func truthy(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case string:
+		return v != ""
+	case List:
+		return len(v) > 0
+	case Map:
+		return len(v) > 0
+	}
+	return true
+}
+
+func equal(a, b Value) bool {
+	switch a := a.(type) {
+	case List:
+		b, ok := b.(List)
+		return ok && slices.EqualFunc(a, b, equal)
+	case Map:
+		b, ok := b.(Map)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := b[k]; !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	case *Builtin, *closure:
+		return a == b
+	}
+	switch b.(type) {
+	case List, Map, *Builtin, *closure:
+		return false
+	}
+	return a == b
+}
+
+// Format renders v the way str() does: strings bare at the top level,
+// quoted inside lists and maps, map keys sorted.
+func Format(v Value) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	var b strings.Builder
+	format(&b, v)
+	return b.String()
+}
+
+func format(b *strings.Builder, v Value) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("nil")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case string:
+		b.WriteString(strconv.Quote(v))
+	case List:
+		b.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			format(b, e)
+		}
+		b.WriteByte(']')
+	case Map:
+		b.WriteByte('{')
+		for i, k := range sortedKeys(v) {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(strconv.Quote(k))
+			b.WriteString(": ")
+			format(b, v[k])
+		}
+		b.WriteByte('}')
+	case *Builtin:
+		b.WriteString("<builtin " + v.Name + ">")
+	case *closure:
+		name := v.fn.name
+		if name == "" {
+			name = "anonymous"
+		}
+		b.WriteString("<fn " + name + ">")
+	default:
+		fmt.Fprint(b, v)
+	}
+}
+
+func sortedKeys(m Map) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+/*
+-----------------------------------
+FROM GO
+-----------------------------------
+*/
+
+// ToValue converts a Go value for a script. Basic types map directly,
+// times become RFC 3339 strings, and anything else goes through its JSON
+// form, so a struct arrives as a Map keyed by its JSON field names. JSON
+// numbers that are not integers arrive as strings: scripts have no
+// floats.
+func ToValue(v any) (Value, error) {
+	switch v := v.(type) {
+	case nil, bool, int64, string, List, Map, *Builtin:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case []string:
+		out := make(List, len(v))
+		for i, s := range v {
+			out[i] = s
+		}
+		return out, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("script: convert %T: %w", v, err)
+	}
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("script: convert %T: %w", v, err)
+	}
+	return fromJSON(generic), nil
+}
+
+func fromJSON(v any) Value {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		return v.String()
+	case []any:
+		out := make(List, len(v))
+		for i, e := range v {
+			out[i] = fromJSON(e)
+		}
+		return out
+	case map[string]any:
+		out := make(Map, len(v))
+		for k, e := range v {
+			out[k] = fromJSON(e)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,68 @@
+// Package serviceaccount is the service's machine clients: accounts apart
+// from users, each with a client ID and secret that it trades for a
+// short-lived access token (the client credentials grant of RFC 6749
+// §4.4), limited to the scopes the account was granted.
+//
+// Accounts are stored with the repository stack cmd/repogen generates,
+// as the catalogue's products are; only the secret's hash is kept, so a
+// secret is shown once, when it is issued. Rotating it issues another,
+// with the old one kept working for a while so clients can move over.
+package serviceaccount
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"Go-Internals/auth"
+)
+
+var (
+	ErrInvalid      = errors.New("serviceaccount: a service account needs a name of at most 64 characters and at least one scope")
+	ErrUnknownScope = errors.New("serviceaccount: unknown scope")
+)
+
+//go:generate go run Go-Internals/cmd/repogen -type ServiceAccount
+
+// ServiceAccount is one machine client.
+type ServiceAccount struct {
+	ID          int    `json:"id" repo:"id" schema:"readOnly"`
+	Name        string `json:"name" repo:"unique,fold" schema:"required,minLength=1,maxLength=64"`
+	Description string `json:"description,omitempty" schema:"maxLength=200"`
+	// ClientID is what the account authenticates as, with its secret.
+	ClientID string   `json:"client_id" repo:"unique" schema:"readOnly"`
+	Scopes   []string `json:"scopes"`
+	Disabled bool     `json:"disabled"`
+	// SecretHash is the SHA-256 of the secret; PreviousHash, of the one
+	// it replaced, good until PreviousExpiresAt.
+	SecretHash        []byte    `json:"-"`
+	PreviousHash      []byte    `json:"-"`
+	PreviousExpiresAt time.Time `json:"previous_secret_expires_at,omitzero" schema:"readOnly"`
+	SecretRotatedAt   time.Time `json:"secret_rotated_at" schema:"readOnly"`
+	CreatedAt         time.Time `json:"created_at" repo:"created" schema:"readOnly"`
+}
+
+// Validate checks the name and the scopes.
+func (a *ServiceAccount) Validate() error {
+	if a.Name == "" || len(a.Name) > 64 || len(a.Scopes) == 0 {
+		return ErrInvalid
+	}
+	for _, s := range a.Scopes {
+		if !auth.KnownScope(s) {
+			return fmt.Errorf("%w %q", ErrUnknownScope, s)
+		}
+	}
+	return nil
+}
+
+// Subject is the token subject of account id, which no user's ID can be.
+func Subject(id int) string { return "service:" + strconv.Itoa(id) }
+
+// clone keeps a caller's changes to the scopes out of the repository's
+// copy.
+func (a ServiceAccount) clone() ServiceAccount {
+	a.Scopes = slices.Clone(a.Scopes)
+	return a
+}
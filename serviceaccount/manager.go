@@ -0,0 +1,298 @@
+package serviceaccount
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/auth"
+	"Go-Internals/clock"
+	"Go-Internals/ctxutil"
+)
+
+var (
+	// ErrInvalidClient is a client ID and secret that do not match an
+	// enabled account; which of the three failed is not said.
+	ErrInvalidClient = errors.New("serviceaccount: invalid client credentials")
+	// ErrInvalidScope is a token asked for with scopes the account was
+	// not granted.
+	ErrInvalidScope = errors.New("serviceaccount: scope not granted to the account")
+)
+
+// Options configures New.
+type Options struct {
+	// Repo stores the accounts; default an in-memory one.
+	Repo ServiceAccountRepository
+	// Signer signs the access tokens; required.
+	Signer auth.Signer
+	// TokenTTL is how long an access token lasts; default 1 hour.
+	TokenTTL time.Duration
+	// Overlap is how long a rotated secret keeps working beside its
+	// replacement; default 24 hours.
+	Overlap time.Duration
+	// Revoker, if set, revokes an account's tokens when it is disabled,
+	// deleted, has its scopes changed or its secret rotated at once
+	// (session.Manager is one).
+	Revoker Revoker
+	// Audit records service_account.created, .updated, .rotated and
+	// .deleted. Default audit.Discard.
+	Audit audit.Sink
+	// Clock defaults to clock.Real().
+	Clock clock.Clock
+}
+
+// Revoker revokes every token issued to subject until now.
+type Revoker interface {
+	EndAll(ctx context.Context, subject string) int
+}
+
+// Token is the answer to a client credentials grant (RFC 6749 §5.1).
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// Patch is the fields Update changes; nil ones are left alone.
+type Patch struct {
+	Description *string   `json:"description,omitempty" schema:"maxLength=200"`
+	Scopes      *[]string `json:"scopes,omitempty"`
+	Disabled    *bool     `json:"disabled,omitempty"`
+}
+
+type Manager struct {
+	opts Options
+	clk  clock.Clock
+	// mu serializes read-modify-writes of an account: the repository
+	// only makes each call atomic.
+	mu sync.Mutex
+}
+
+func New(opts Options) *Manager {
+	if opts.Repo == nil {
+		opts.Repo = NewInMemoryServiceAccountRepo()
+	}
+	if opts.TokenTTL <= 0 {
+		opts.TokenTTL = time.Hour
+	}
+	if opts.Overlap <= 0 {
+		opts.Overlap = 24 * time.Hour
+	}
+	if opts.Audit == nil {
+		opts.Audit = audit.Discard
+	}
+	return &Manager{opts: opts, clk: clock.OrReal(opts.Clock)}
+}
+
+// Create adds an account and returns it with its client secret, which
+// is not kept and cannot be shown again.
+func (m *Manager) Create(ctx context.Context, name, description string, scopes []string) (ServiceAccount, string, error) {
+	secret := randomString(32)
+	sum := sha256.Sum256([]byte(secret))
+	a := ServiceAccount{
+		Name:            name,
+		Description:     description,
+		ClientID:        randomString(12),
+		Scopes:          slices.Compact(slices.Sorted(slices.Values(scopes))),
+		SecretHash:      sum[:],
+		SecretRotatedAt: m.clk.Now().UTC(),
+	}
+	if err := a.Validate(); err != nil {
+		return ServiceAccount{}, "", err
+	}
+	a, err := m.opts.Repo.Create(a)
+	if err != nil {
+		return ServiceAccount{}, "", err
+	}
+	m.record(ctx, "service_account.created", a.ID, map[string]string{"scopes": strings.Join(a.Scopes, " ")})
+	return a.clone(), secret, nil
+}
+
+func (m *Manager) Get(id int) (ServiceAccount, error) {
+	a, err := m.opts.Repo.GetByID(id)
+	return a.clone(), err
+}
+
+// List is every account, in ID order.
+func (m *Manager) List() []ServiceAccount {
+	list := m.opts.Repo.List()
+	for i, a := range list {
+		list[i] = a.clone()
+	}
+	return list
+}
+
+// Update applies p to account id. Narrowing its scopes or disabling it
+// revokes the tokens it holds, which would outlast the change.
+func (m *Manager) Update(ctx context.Context, id int, p Patch) (ServiceAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, err := m.opts.Repo.GetByID(id)
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+	a = a.clone()
+	old := a.clone()
+	if p.Description != nil {
+		a.Description = *p.Description
+	}
+	if p.Scopes != nil {
+		a.Scopes = slices.Compact(slices.Sorted(slices.Values(*p.Scopes)))
+	}
+	if p.Disabled != nil {
+		a.Disabled = *p.Disabled
+	}
+	if err := a.Validate(); err != nil {
+		return ServiceAccount{}, err
+	}
+	if a, err = m.opts.Repo.Update(a); err != nil {
+		return ServiceAccount{}, err
+	}
+	narrowed := slices.ContainsFunc(old.Scopes, func(s string) bool {
+		return !(auth.Claims{Scope: strings.Join(a.Scopes, " ")}).Allows(s)
+	})
+	if (a.Disabled && !old.Disabled) || narrowed {
+		m.revoke(ctx, a.ID)
+	}
+	m.record(ctx, "service_account.updated", a.ID, map[string]string{
+		"scopes":   strings.Join(a.Scopes, " "),
+		"disabled": strconv.FormatBool(a.Disabled),
+	})
+	return a.clone(), nil
+}
+
+// Rotate issues account id a new secret and returns it, to be shown
+// once. The old secret keeps working for Options.Overlap unless now is
+// set, which ends it at once and revokes the tokens issued with it.
+func (m *Manager) Rotate(ctx context.Context, id int, now bool) (ServiceAccount, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, err := m.opts.Repo.GetByID(id)
+	if err != nil {
+		return ServiceAccount{}, "", err
+	}
+	a = a.clone()
+	secret := randomString(32)
+	sum := sha256.Sum256([]byte(secret))
+	at := m.clk.Now().UTC()
+	a.PreviousHash, a.PreviousExpiresAt = a.SecretHash, at.Add(m.opts.Overlap)
+	if now {
+		a.PreviousHash, a.PreviousExpiresAt = nil, time.Time{}
+	}
+	a.SecretHash, a.SecretRotatedAt = sum[:], at
+	if a, err = m.opts.Repo.Update(a); err != nil {
+		return ServiceAccount{}, "", err
+	}
+	if now {
+		m.revoke(ctx, a.ID)
+	}
+	m.record(ctx, "service_account.rotated", a.ID, map[string]string{"immediate": strconv.FormatBool(now)})
+	return a.clone(), secret, nil
+}
+
+// Delete removes account id and revokes its tokens.
+func (m *Manager) Delete(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.opts.Repo.Delete(id); err != nil {
+		return err
+	}
+	m.revoke(ctx, id)
+	m.record(ctx, "service_account.deleted", id, nil)
+	return nil
+}
+
+/*
+-----------------------------------
+CLIENT CREDENTIALS
+-----------------------------------
+*/
+
+// Authenticate is the enabled account clientID names, if secret is its
+// current secret or a rotated one still overlapping it.
+func (m *Manager) Authenticate(clientID, secret string) (ServiceAccount, error) {
+	a, err := m.opts.Repo.GetByClientID(clientID)
+	if err != nil || a.Disabled {
+		return ServiceAccount{}, ErrInvalidClient
+	}
+	sum := sha256.Sum256([]byte(secret))
+	ok := subtle.ConstantTimeCompare(sum[:], a.SecretHash) == 1
+	if !ok && len(a.PreviousHash) > 0 && m.clk.Now().Before(a.PreviousExpiresAt) {
+		ok = subtle.ConstantTimeCompare(sum[:], a.PreviousHash) == 1
+	}
+	if !ok {
+		return ServiceAccount{}, ErrInvalidClient
+	}
+	return a.clone(), nil
+}
+
+// Token authenticates clientID with secret and issues it an access
+// token limited to scope, space-separated, or to every scope the
+// account was granted if scope is empty.
+func (m *Manager) Token(clientID, secret, scope string) (Token, error) {
+	a, err := m.Authenticate(clientID, secret)
+	if err != nil {
+		return Token{}, err
+	}
+	granted := auth.Claims{Scope: strings.Join(a.Scopes, " ")}
+	scopes := a.Scopes
+	if scope != "" {
+		scopes = slices.Compact(slices.Sorted(slices.Values(strings.Fields(scope))))
+		for _, s := range scopes {
+			if !auth.KnownScope(s) || !granted.Allows(s) {
+				return Token{}, ErrInvalidScope
+			}
+		}
+	}
+	now := m.clk.Now()
+	access, err := m.opts.Signer.Sign(auth.Claims{
+		Subject:   Subject(a.ID),
+		Roles:     []string{auth.RoleService},
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(m.opts.TokenTTL).Unix(),
+		ID:        randomString(12),
+		Scope:     strings.Join(scopes, " "),
+	})
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{
+		AccessToken: access,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(m.opts.TokenTTL / time.Second),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+func (m *Manager) revoke(ctx context.Context, id int) {
+	if m.opts.Revoker != nil {
+		m.opts.Revoker.EndAll(ctx, Subject(id))
+	}
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// record is best effort: what it records has happened either way.
+func (m *Manager) record(ctx context.Context, action string, id int, meta map[string]string) {
+	_ = m.opts.Audit.Record(ctx, audit.Entry{
+		Actor:      ctxutil.UserID(ctx),
+		Action:     action,
+		Resource:   "service_account",
+		ResourceID: strconv.Itoa(id),
+		Meta:       meta,
+	})
+}
@@ -0,0 +1,450 @@
+// Code generated by repogen -type ServiceAccount; DO NOT EDIT.
+
+package serviceaccount
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/index"
+	"Go-Internals/openapi"
+)
+
+var (
+	ErrServiceAccountNotFound      = errors.New("serviceaccount: serviceaccount not found")
+	ErrServiceAccountNameTaken     = errors.New("serviceaccount: serviceaccount Name already taken")
+	ErrServiceAccountClientIDTaken = errors.New("serviceaccount: serviceaccount ClientID already taken")
+)
+
+/*
+-----------------------------------
+INTERFACE
+-----------------------------------
+*/
+
+// ServiceAccountRepository is the storage contract for ServiceAccount.
+type ServiceAccountRepository interface {
+	// Create assigns ID and CreatedAt.
+	Create(v ServiceAccount) (ServiceAccount, error)
+	GetByID(id int) (ServiceAccount, error)
+	// List returns every serviceaccount in ID order.
+	List() []ServiceAccount
+	// Update replaces a stored serviceaccount, keeping its CreatedAt.
+	Update(v ServiceAccount) (ServiceAccount, error)
+	Delete(id int) error
+	Len() int
+
+	GetByName(name string) (ServiceAccount, error)
+	// RangeByName returns from <= Name < to, in Name order.
+	RangeByName(from, to string) []ServiceAccount
+	GetByClientID(clientID string) (ServiceAccount, error)
+	// RangeByClientID returns from <= ClientID < to, in ClientID order.
+	RangeByClientID(from, to string) []ServiceAccount
+}
+
+/*
+-----------------------------------
+IN-MEMORY REPOSITORY
+-----------------------------------
+*/
+
+// InMemoryServiceAccountRepo keeps serviceaccounts in a map with one index per indexed
+// field, maintained on every write.
+type InMemoryServiceAccountRepo struct {
+	mu     sync.Mutex
+	items  map[int]ServiceAccount
+	nextID int
+
+	indexes    *index.Set[ServiceAccount]
+	byName     *index.Ordered[ServiceAccount, string]
+	byClientID *index.Ordered[ServiceAccount, string]
+}
+
+func NewInMemoryServiceAccountRepo() *InMemoryServiceAccountRepo {
+	r := &InMemoryServiceAccountRepo{items: make(map[int]ServiceAccount), nextID: 1}
+	r.byName = index.NewOrderedCmp("Name", func(v ServiceAccount) string { return strings.ToLower(v.Name) },
+		index.Options{Unique: true, ErrDuplicate: ErrServiceAccountNameTaken})
+	r.byClientID = index.NewOrderedCmp("ClientID", func(v ServiceAccount) string { return v.ClientID },
+		index.Options{Unique: true, ErrDuplicate: ErrServiceAccountClientIDTaken})
+	r.indexes = index.NewSet[ServiceAccount](r.byName, r.byClientID)
+	return r
+}
+
+func (r *InMemoryServiceAccountRepo) Create(v ServiceAccount) (ServiceAccount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v.ID = r.nextID
+	v.CreatedAt = time.Now()
+	if err := r.indexes.Insert(v.ID, v); err != nil {
+		return ServiceAccount{}, err
+	}
+	r.items[v.ID] = v
+	r.nextID++
+	return v, nil
+}
+
+func (r *InMemoryServiceAccountRepo) GetByID(id int) (ServiceAccount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v, ok := r.items[id]
+	if !ok {
+		return ServiceAccount{}, ErrServiceAccountNotFound
+	}
+	return v, nil
+}
+
+func (r *InMemoryServiceAccountRepo) List() []ServiceAccount {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(slices.Sorted(maps.Keys(r.items)))
+}
+
+func (r *InMemoryServiceAccountRepo) Update(v ServiceAccount) (ServiceAccount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.items[v.ID]
+	if !ok {
+		return ServiceAccount{}, ErrServiceAccountNotFound
+	}
+	v.CreatedAt = old.CreatedAt
+	if err := r.indexes.Update(v.ID, old, v); err != nil {
+		return ServiceAccount{}, err
+	}
+	r.items[v.ID] = v
+	return v, nil
+}
+
+func (r *InMemoryServiceAccountRepo) Delete(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.items[id]
+	if !ok {
+		return ErrServiceAccountNotFound
+	}
+	r.indexes.Remove(id, old)
+	delete(r.items, id)
+	return nil
+}
+
+func (r *InMemoryServiceAccountRepo) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.items)
+}
+
+func (r *InMemoryServiceAccountRepo) GetByName(name string) (ServiceAccount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := r.byName.Lookup(strings.ToLower(name))
+	if len(ids) == 0 {
+		return ServiceAccount{}, ErrServiceAccountNotFound
+	}
+	return r.items[ids[0]], nil
+}
+
+func (r *InMemoryServiceAccountRepo) RangeByName(from, to string) []ServiceAccount {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(r.byName.Range(strings.ToLower(from), strings.ToLower(to)))
+}
+
+func (r *InMemoryServiceAccountRepo) GetByClientID(clientID string) (ServiceAccount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := r.byClientID.Lookup(clientID)
+	if len(ids) == 0 {
+		return ServiceAccount{}, ErrServiceAccountNotFound
+	}
+	return r.items[ids[0]], nil
+}
+
+func (r *InMemoryServiceAccountRepo) RangeByClientID(from, to string) []ServiceAccount {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(r.byClientID.Range(from, to))
+}
+
+func (r *InMemoryServiceAccountRepo) resolveLocked(ids []int) []ServiceAccount {
+	out := make([]ServiceAccount, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, r.items[id])
+	}
+	return out
+}
+
+/*
+-----------------------------------
+FAKE
+-----------------------------------
+*/
+
+// FakeServiceAccountRepo is an in-memory repository for tests of code that uses
+// one: it records every call by method name and fails the methods it is
+// told to.
+type FakeServiceAccountRepo struct {
+	repo *InMemoryServiceAccountRepo
+
+	mu    sync.Mutex
+	calls []string
+	fail  map[string]error
+}
+
+func NewFakeServiceAccountRepo() *FakeServiceAccountRepo {
+	return &FakeServiceAccountRepo{repo: NewInMemoryServiceAccountRepo(), fail: make(map[string]error)}
+}
+
+// FailOn makes every call to method ("Create", "GetByID", ...) return err
+// without touching the data; a nil err clears it. Methods without an
+// error result ignore it.
+func (f *FakeServiceAccountRepo) FailOn(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.fail, method)
+	} else {
+		f.fail[method] = err
+	}
+}
+
+// Calls returns the methods called so far, in order.
+func (f *FakeServiceAccountRepo) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+func (f *FakeServiceAccountRepo) call(method string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, method)
+	return f.fail[method]
+}
+
+func (f *FakeServiceAccountRepo) Create(v ServiceAccount) (ServiceAccount, error) {
+	if err := f.call("Create"); err != nil {
+		return ServiceAccount{}, err
+	}
+	return f.repo.Create(v)
+}
+
+func (f *FakeServiceAccountRepo) GetByID(id int) (ServiceAccount, error) {
+	if err := f.call("GetByID"); err != nil {
+		return ServiceAccount{}, err
+	}
+	return f.repo.GetByID(id)
+}
+
+func (f *FakeServiceAccountRepo) List() []ServiceAccount {
+	f.call("List")
+	return f.repo.List()
+}
+
+func (f *FakeServiceAccountRepo) Update(v ServiceAccount) (ServiceAccount, error) {
+	if err := f.call("Update"); err != nil {
+		return ServiceAccount{}, err
+	}
+	return f.repo.Update(v)
+}
+
+func (f *FakeServiceAccountRepo) Delete(id int) error {
+	if err := f.call("Delete"); err != nil {
+		return err
+	}
+	return f.repo.Delete(id)
+}
+
+func (f *FakeServiceAccountRepo) Len() int {
+	f.call("Len")
+	return f.repo.Len()
+}
+
+func (f *FakeServiceAccountRepo) GetByName(name string) (ServiceAccount, error) {
+	if err := f.call("GetByName"); err != nil {
+		return ServiceAccount{}, err
+	}
+	return f.repo.GetByName(name)
+}
+
+func (f *FakeServiceAccountRepo) RangeByName(from, to string) []ServiceAccount {
+	f.call("RangeByName")
+	return f.repo.RangeByName(from, to)
+}
+
+func (f *FakeServiceAccountRepo) GetByClientID(clientID string) (ServiceAccount, error) {
+	if err := f.call("GetByClientID"); err != nil {
+		return ServiceAccount{}, err
+	}
+	return f.repo.GetByClientID(clientID)
+}
+
+func (f *FakeServiceAccountRepo) RangeByClientID(from, to string) []ServiceAccount {
+	f.call("RangeByClientID")
+	return f.repo.RangeByClientID(from, to)
+}
+
+/*
+-----------------------------------
+HTTP HANDLERS
+-----------------------------------
+*/
+
+// ServiceAccountHandlers serves a ServiceAccountRepository as JSON:
+//
+//	GET    {prefix}        list
+//	POST   {prefix}        create (201, Location header)
+//	GET    {prefix}/{id}   get
+//	PUT    {prefix}/{id}   update (the path's ID wins over the body's)
+//	DELETE {prefix}/{id}   delete (204)
+//
+// A ServiceAccount with a Validate() error method is validated before create and
+// update; a failure answers 400.
+type ServiceAccountHandlers struct {
+	Repo ServiceAccountRepository
+}
+
+// Routes describes the routes under prefix, e.g. "/products", for an
+// openapi.API, which documents and validates them.
+func (h *ServiceAccountHandlers) Routes(prefix string) []openapi.Route {
+	var errBody map[string]string
+	id := []openapi.Param{openapi.PathInt("id")}
+	tags := []string{"serviceaccounts"}
+	return []openapi.Route{
+		{Operation: openapi.Operation{Pattern: "GET " + prefix, Summary: "List serviceaccounts", Tags: tags,
+			Responses: map[int]any{http.StatusOK: []ServiceAccount{}}}, Handler: http.HandlerFunc(h.list)},
+		{Operation: openapi.Operation{Pattern: "POST " + prefix, Summary: "Create a serviceaccount", Tags: tags, Body: ServiceAccount{},
+			Responses: map[int]any{http.StatusCreated: ServiceAccount{}, http.StatusBadRequest: errBody, http.StatusConflict: errBody}}, Handler: http.HandlerFunc(h.create)},
+		{Operation: openapi.Operation{Pattern: "GET " + prefix + "/{id}", Summary: "Get a serviceaccount", Tags: tags, Params: id,
+			Responses: map[int]any{http.StatusOK: ServiceAccount{}, http.StatusNotFound: errBody}}, Handler: http.HandlerFunc(h.get)},
+		{Operation: openapi.Operation{Pattern: "PUT " + prefix + "/{id}", Summary: "Replace a serviceaccount", Tags: tags, Params: id, Body: ServiceAccount{},
+			Responses: map[int]any{http.StatusOK: ServiceAccount{}, http.StatusBadRequest: errBody, http.StatusNotFound: errBody, http.StatusConflict: errBody}}, Handler: http.HandlerFunc(h.update)},
+		{Operation: openapi.Operation{Pattern: "DELETE " + prefix + "/{id}", Summary: "Delete a serviceaccount", Tags: tags, Params: id,
+			Responses: map[int]any{http.StatusNoContent: nil, http.StatusNotFound: errBody}}, Handler: http.HandlerFunc(h.delete)},
+	}
+}
+
+// Register adds the routes under prefix to mux, undocumented.
+func (h *ServiceAccountHandlers) Register(mux *http.ServeMux, prefix string) {
+	for _, rt := range h.Routes(prefix) {
+		mux.Handle(rt.Pattern, rt.Handler)
+	}
+}
+
+func (h *ServiceAccountHandlers) list(w http.ResponseWriter, r *http.Request) {
+	writeServiceAccountJSON(w, http.StatusOK, h.Repo.List())
+}
+
+func (h *ServiceAccountHandlers) get(w http.ResponseWriter, r *http.Request) {
+	id, ok := serviceaccountPathID(w, r)
+	if !ok {
+		return
+	}
+	v, err := h.Repo.GetByID(id)
+	if err != nil {
+		writeServiceAccountError(w, err)
+		return
+	}
+	writeServiceAccountJSON(w, http.StatusOK, v)
+}
+
+func (h *ServiceAccountHandlers) create(w http.ResponseWriter, r *http.Request) {
+	v, ok := decodeServiceAccount(w, r)
+	if !ok {
+		return
+	}
+	v, err := h.Repo.Create(v)
+	if err != nil {
+		writeServiceAccountError(w, err)
+		return
+	}
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.Itoa(v.ID))
+	writeServiceAccountJSON(w, http.StatusCreated, v)
+}
+
+func (h *ServiceAccountHandlers) update(w http.ResponseWriter, r *http.Request) {
+	id, ok := serviceaccountPathID(w, r)
+	if !ok {
+		return
+	}
+	v, ok := decodeServiceAccount(w, r)
+	if !ok {
+		return
+	}
+	v.ID = id
+	v, err := h.Repo.Update(v)
+	if err != nil {
+		writeServiceAccountError(w, err)
+		return
+	}
+	writeServiceAccountJSON(w, http.StatusOK, v)
+}
+
+func (h *ServiceAccountHandlers) delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := serviceaccountPathID(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Repo.Delete(id); err != nil {
+		writeServiceAccountError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func serviceaccountPathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func decodeServiceAccount(w http.ResponseWriter, r *http.Request) (ServiceAccount, bool) {
+	var v ServiceAccount
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&v); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return v, false
+	}
+	if val, ok := any(&v).(interface{ Validate() error }); ok {
+		if err := val.Validate(); err != nil {
+			writeServiceAccountJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return v, false
+		}
+	}
+	return v, true
+}
+
+func writeServiceAccountError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrServiceAccountNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrServiceAccountNameTaken):
+		status = http.StatusConflict
+	case errors.Is(err, ErrServiceAccountClientIDTaken):
+		status = http.StatusConflict
+	}
+	writeServiceAccountJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeServiceAccountJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
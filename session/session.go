@@ -0,0 +1,456 @@
+// Package session keeps sign-ins server-side, so that they can be ended
+// before their tokens expire.
+//
+// Start opens a session and returns a pair of tokens: a short-lived
+// access token, a JWT naming the session in its sid claim, and a
+// long-lived opaque refresh token. Refresh trades the refresh token for a
+// new pair; the old refresh token is spent, and presenting it again is
+// taken for what it most likely is, a stolen copy, and ends the session
+// (the reuse detection of OAuth 2.0 Security BCP §4.14.2).
+//
+// Ending a session revokes its access tokens too: Verifier wraps the
+// service's auth.Verifier and refuses, with auth.ErrRevokedToken, tokens
+// whose session, ID or subject is on the revocation list. Entries stay
+// only as long as the tokens they revoke could still verify, and a
+// ttl.Sweeper clears them, and idle sessions, as they expire.
+//
+// Sessions and revocations are held in memory: a restart ends every
+// session, and revocations last while access tokens do, which is not
+// long.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/auth"
+	"Go-Internals/clock"
+	"Go-Internals/ctxutil"
+	"Go-Internals/eventbus"
+	"Go-Internals/ttl"
+	"Go-Internals/users"
+)
+
+var (
+	ErrInvalid  = errors.New("session: invalid refresh token")
+	ErrExpired  = errors.New("session: refresh token expired")
+	ErrReused   = errors.New("session: refresh token already used; the session has been ended")
+	ErrNotFound = errors.New("session: no such session")
+)
+
+// Options configures New.
+type Options struct {
+	// Signer signs the access tokens; required.
+	Signer auth.Signer
+	// AccessTTL is how long an access token lasts; default 15 minutes.
+	AccessTTL time.Duration
+	// RefreshTTL is how long a session may go without a refresh before
+	// it expires; each refresh starts it over. Default 30 days.
+	RefreshTTL time.Duration
+	// MaxTokenTTL is the longest any token the Verifier passes may last,
+	// those not issued here included; revoking a subject's tokens lasts
+	// that long. Default 24 hours.
+	MaxTokenTTL time.Duration
+	// Audit records session.started, session.reused and session.revoked.
+	// Default audit.Discard.
+	Audit audit.Sink
+	// Clock defaults to clock.Real().
+	Clock clock.Clock
+}
+
+// Pair is what Start and Refresh return.
+type Pair struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// RefreshToken is good for one Refresh.
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	SessionID        string    `json:"session_id"`
+}
+
+// Info is a session, without its secrets.
+type Info struct {
+	ID          string    `json:"id"`
+	Subject     string    `json:"subject"`
+	CreatedAt   time.Time `json:"created_at"`
+	RefreshedAt time.Time `json:"refreshed_at,omitzero"`
+	Refreshes   int       `json:"refreshes"`
+	// ExpiresAt is when the session ends unless refreshed.
+	ExpiresAt time.Time `json:"expires_at"`
+	AMR       []string  `json:"amr,omitempty"`
+}
+
+// maxSpent bounds the spent refresh tokens remembered per session; a
+// replay of one older than that reads as a forgery, refused without
+// ending the session.
+const maxSpent = 64
+
+type session struct {
+	info  Info
+	roles []string
+	// current is the SHA-256 of the refresh token's secret; spent, those
+	// of the secrets it replaced.
+	current [32]byte
+	spent   [][32]byte
+	// lastExp is the expiry of the latest access token, which revoking
+	// the session must outlast.
+	lastExp time.Time
+}
+
+// revocation is an entry of the revocation list.
+type revocation struct {
+	until time.Time
+	// before, for a subject, is the latest issue time revoked.
+	before int64
+}
+
+// Sweeper keys are a session's or a revocation's, told apart by prefix.
+const (
+	sessionKey = "session:"
+	sidKey     = "sid:"
+	jtiKey     = "jti:"
+	subKey     = "sub:"
+)
+
+type Manager struct {
+	opts    Options
+	clk     clock.Clock
+	sweeper *ttl.Sweeper[string]
+
+	mu        sync.Mutex
+	sessions  map[string]*session
+	bySubject map[string][]string
+	revoked   map[string]revocation
+}
+
+func New(opts Options) *Manager {
+	if opts.AccessTTL <= 0 {
+		opts.AccessTTL = 15 * time.Minute
+	}
+	if opts.RefreshTTL <= 0 {
+		opts.RefreshTTL = 30 * 24 * time.Hour
+	}
+	if opts.MaxTokenTTL <= 0 {
+		opts.MaxTokenTTL = 24 * time.Hour
+	}
+	if opts.Audit == nil {
+		opts.Audit = audit.Discard
+	}
+	m := &Manager{
+		opts:      opts,
+		clk:       clock.OrReal(opts.Clock),
+		sessions:  make(map[string]*session),
+		bySubject: make(map[string][]string),
+		revoked:   make(map[string]revocation),
+	}
+	m.sweeper = ttl.New(m.clk, m.expire)
+	return m
+}
+
+// Run expires idle sessions and stale revocations until ctx is done.
+func (m *Manager) Run(ctx context.Context) { m.sweeper.Run(ctx) }
+
+// Start opens a session for subject, whose tokens carry roles and amr.
+func (m *Manager) Start(ctx context.Context, subject string, roles, amr []string) (Pair, error) {
+	now := m.clk.Now()
+	s := &session{
+		info:  Info{ID: randomString(16), Subject: subject, CreatedAt: now, AMR: slices.Clone(amr)},
+		roles: slices.Clone(roles),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, err := m.issue(s, now)
+	if err != nil {
+		return Pair{}, err
+	}
+	m.sessions[s.info.ID] = s
+	m.bySubject[subject] = append(m.bySubject[subject], s.info.ID)
+	m.record(ctx, "session.started", s)
+	return p, nil
+}
+
+// Refresh spends refresh and returns a new pair for its session.
+func (m *Manager) Refresh(ctx context.Context, refresh string) (Pair, error) {
+	sid, secret, ok := strings.Cut(refresh, ".")
+	if !ok {
+		return Pair{}, ErrInvalid
+	}
+	sum := sha256.Sum256([]byte(secret))
+	now := m.clk.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[sid]
+	if !ok {
+		return Pair{}, ErrInvalid
+	}
+	if subtle.ConstantTimeCompare(sum[:], s.current[:]) != 1 {
+		if slices.Contains(s.spent, sum) {
+			m.end(s, now)
+			m.record(ctx, "session.reused", s)
+			return Pair{}, ErrReused
+		}
+		return Pair{}, ErrInvalid
+	}
+	if !now.Before(s.info.ExpiresAt) {
+		return Pair{}, ErrExpired
+	}
+	spent := s.current
+	p, err := m.issue(s, now)
+	if err != nil {
+		return Pair{}, err
+	}
+	s.spent = append(s.spent, spent)
+	if len(s.spent) > maxSpent {
+		s.spent = slices.Delete(s.spent, 0, len(s.spent)-maxSpent)
+	}
+	s.info.Refreshes++
+	s.info.RefreshedAt = now
+	return p, nil
+}
+
+// issue gives s a new refresh token and access token, and a new expiry.
+// m.mu must be held.
+func (m *Manager) issue(s *session, now time.Time) (Pair, error) {
+	secret := randomString(32)
+	exp := now.Add(m.opts.AccessTTL)
+	access, err := m.opts.Signer.Sign(auth.Claims{
+		Subject:   s.info.Subject,
+		Roles:     s.roles,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: exp.Unix(),
+		AMR:       s.info.AMR,
+		ID:        randomString(12),
+		Session:   s.info.ID,
+	})
+	if err != nil {
+		return Pair{}, err
+	}
+	s.current = sha256.Sum256([]byte(secret))
+	s.lastExp = time.Unix(exp.Unix(), 0)
+	s.info.ExpiresAt = now.Add(m.opts.RefreshTTL)
+	m.sweeper.Schedule(sessionKey+s.info.ID, s.info.ExpiresAt)
+	return Pair{
+		AccessToken:      access,
+		TokenType:        "Bearer",
+		ExpiresAt:        s.lastExp.UTC(),
+		RefreshToken:     s.info.ID + "." + secret,
+		RefreshExpiresAt: s.info.ExpiresAt,
+		SessionID:        s.info.ID,
+	}, nil
+}
+
+// Elevate records that session sid passed more factors, amr: the tokens
+// Refresh issues carry them from then on.
+func (m *Manager) Elevate(sid string, amr []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[sid]; ok {
+		s.info.AMR = slices.Clone(amr)
+	}
+}
+
+// Get is session sid.
+func (m *Manager) Get(sid string) (Info, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[sid]
+	if !ok {
+		return Info{}, ErrNotFound
+	}
+	return s.info, nil
+}
+
+// List is subject's sessions, newest first.
+func (m *Manager) List(subject string) []Info {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Info, 0, len(m.bySubject[subject]))
+	for _, sid := range m.bySubject[subject] {
+		out = append(out, m.sessions[sid].info)
+	}
+	slices.Reverse(out)
+	return out
+}
+
+/*
+-----------------------------------
+REVOCATION
+-----------------------------------
+*/
+
+// End ends session sid, revoking its tokens.
+func (m *Manager) End(ctx context.Context, sid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[sid]
+	if !ok {
+		return ErrNotFound
+	}
+	m.end(s, m.clk.Now())
+	m.record(ctx, "session.revoked", s)
+	return nil
+}
+
+// EndAll ends subject's sessions and revokes every token issued to it
+// until now, those issued elsewhere included, returning how many
+// sessions it ended. Tokens issued in the same second are revoked too:
+// a JWT's iat has no finer grain.
+func (m *Manager) EndAll(ctx context.Context, subject string) int {
+	now := m.clk.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sids := slices.Clone(m.bySubject[subject])
+	for _, sid := range sids {
+		m.end(m.sessions[sid], now)
+	}
+	m.revoke(subKey+subject, revocation{until: now.Add(m.opts.MaxTokenTTL), before: now.Unix()})
+	_ = m.opts.Audit.Record(ctx, audit.Entry{
+		Actor:      ctxutil.UserID(ctx),
+		Action:     "session.revoked",
+		Resource:   "account",
+		ResourceID: subject,
+		Meta:       map[string]string{"sessions": strconv.Itoa(len(sids)), "all": "true"},
+	})
+	return len(sids)
+}
+
+// RevokeToken revokes the access token c is the claims of, alone: one
+// from outside a session, say. Tokens without an ID cannot be, and
+// report false.
+func (m *Manager) RevokeToken(c auth.Claims) bool {
+	if c.ID == "" {
+		return false
+	}
+	until := m.clk.Now().Add(m.opts.MaxTokenTTL)
+	if c.ExpiresAt != 0 {
+		until = time.Unix(c.ExpiresAt, 0)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revoke(jtiKey+c.ID, revocation{until: until})
+	return true
+}
+
+// Revoked reports whether c was revoked.
+func (m *Manager) Revoked(c auth.Claims) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c.ID != "" {
+		if _, ok := m.revoked[jtiKey+c.ID]; ok {
+			return true
+		}
+	}
+	if c.Session != "" {
+		if _, ok := m.revoked[sidKey+c.Session]; ok {
+			return true
+		}
+		// EndAll ended every session it revoked the subject's tokens of,
+		// so a session still open is newer, whatever its tokens' iat.
+		if _, ok := m.sessions[c.Session]; ok {
+			return false
+		}
+	}
+	r, ok := m.revoked[subKey+c.Subject]
+	return ok && c.IssuedAt <= r.before
+}
+
+// Verifier is v refusing the tokens m revoked.
+func (m *Manager) Verifier(v auth.Verifier) auth.Verifier { return verifier{m, v} }
+
+type verifier struct {
+	m *Manager
+	v auth.Verifier
+}
+
+func (v verifier) Verify(token string) (auth.Claims, error) {
+	c, err := v.v.Verify(token)
+	if err == nil && v.m.Revoked(c) {
+		return auth.Claims{}, auth.ErrRevokedToken
+	}
+	return c, err
+}
+
+// end removes s and revokes its access tokens until the last expires.
+// m.mu must be held.
+func (m *Manager) end(s *session, now time.Time) {
+	delete(m.sessions, s.info.ID)
+	m.sweeper.Cancel(sessionKey + s.info.ID)
+	sub := m.bySubject[s.info.Subject]
+	if i := slices.Index(sub, s.info.ID); i >= 0 {
+		sub = slices.Delete(sub, i, i+1)
+	}
+	if len(sub) == 0 {
+		delete(m.bySubject, s.info.Subject)
+	} else {
+		m.bySubject[s.info.Subject] = sub
+	}
+	if s.lastExp.After(now) {
+		m.revoke(sidKey+s.info.ID, revocation{until: s.lastExp})
+	}
+}
+
+// revoke lists key until r.until, or later if it is listed longer.
+// m.mu must be held.
+func (m *Manager) revoke(key string, r revocation) {
+	if old, ok := m.revoked[key]; ok && old.until.After(r.until) {
+		r.until = old.until
+	}
+	m.revoked[key] = r
+	m.sweeper.Schedule(key, r.until)
+}
+
+// expire is the sweeper's: an idle session ends, a revocation outlived
+// what it revoked. Either may have been renewed since it was due.
+func (m *Manager) expire(key string, _ time.Time) {
+	now := m.clk.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if sid, ok := strings.CutPrefix(key, sessionKey); ok {
+		if s, ok := m.sessions[sid]; ok && !now.Before(s.info.ExpiresAt) {
+			m.end(s, now)
+		}
+		return
+	}
+	if r, ok := m.revoked[key]; ok && !now.Before(r.until) {
+		delete(m.revoked, key)
+	}
+}
+
+// Subscribe ends a user's sessions when the service publishes their
+// deletion.
+func (m *Manager) Subscribe(bus *eventbus.Bus) error {
+	_, err := bus.Subscribe(users.TopicUserDeleted, func(ev eventbus.Event) {
+		if u, ok := ev.Payload.(users.User); ok {
+			m.EndAll(context.Background(), strconv.Itoa(u.ID))
+		}
+	}, eventbus.SubscribeOptions{})
+	return err
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// record is best effort: what it records has happened either way.
+func (m *Manager) record(ctx context.Context, action string, s *session) {
+	_ = m.opts.Audit.Record(ctx, audit.Entry{
+		Actor:      ctxutil.UserID(ctx),
+		Action:     action,
+		Resource:   "account",
+		ResourceID: s.info.Subject,
+		Meta:       map[string]string{"session": s.info.ID},
+	})
+}
@@ -0,0 +1,138 @@
+// Package sigctl gives a running process the classic Unix operator
+// controls:
+//
+//	kill -USR1 <pid>   dump every goroutine stack plus process stats to the log
+//	kill -USR2 <pid>   toggle debug logging on and off, or reload log levels
+//	kill -HUP  <pid>   reopen log files and reload configuration
+//
+// These work without an HTTP endpoint, which matters exactly when the
+// process is wedged or its listener is what broke. On platforms without
+// these signals Run returns immediately.
+package sigctl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// Options says what each signal does. Nil fields disable that signal's
+// action (the signal is still caught, so it doesn't kill the process).
+type Options struct {
+	// Stats adds process-specific numbers (user counts, queue depths, ...)
+	// to the SIGUSR1 dump.
+	Stats func() map[string]any
+
+	// Level is flipped between Info and Debug by SIGUSR2.
+	Level *slog.LevelVar
+	// ReloadLevels, if set, runs on SIGUSR2 instead of the flip: it
+	// reloads per-module log levels (from a file, say) and returns them
+	// for the log.
+	ReloadLevels func() (string, error)
+
+	// Reload runs on SIGHUP.
+	Reload func() error
+	// Reopen also runs on SIGHUP, before Reload: it reopens log files
+	// that logrotate has just moved away.
+	Reopen func() error
+
+	// Logger receives the output; default slog.Default().
+	Logger *slog.Logger
+	// DumpTo receives the raw goroutine dump, which is too large and
+	// multi-line for a log record; default os.Stderr.
+	DumpTo io.Writer
+}
+
+func (o Options) logger() *slog.Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return slog.Default()
+}
+
+// Dump logs stats and writes all goroutine stacks to DumpTo. It is what
+// SIGUSR1 does, exported so an admin endpoint can do the same.
+func Dump(o Options) {
+	var buf bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&buf, 2)
+	out := o.DumpTo
+	if out == nil {
+		out = os.Stderr
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	attrs := []any{
+		"goroutines", runtime.NumGoroutine(),
+		"heap_alloc", ms.HeapAlloc,
+		"heap_objects", ms.HeapObjects,
+		"num_gc", ms.NumGC,
+	}
+	if o.Stats != nil {
+		for k, v := range o.Stats() {
+			attrs = append(attrs, k, v)
+		}
+	}
+
+	o.logger().Info("sigctl: stats", attrs...)
+	fmt.Fprintf(out, "---- goroutine dump (%d goroutines) ----\n%s---- end goroutine dump ----\n", runtime.NumGoroutine(), buf.Bytes())
+}
+
+// ToggleDebug flips Level between Info and Debug and returns the new level.
+func ToggleDebug(o Options) slog.Level {
+	if o.Level == nil {
+		return slog.LevelInfo
+	}
+	next := slog.LevelDebug
+	if o.Level.Level() == slog.LevelDebug {
+		next = slog.LevelInfo
+	}
+	o.Level.Set(next)
+	o.logger().Info("sigctl: log level changed", "level", next)
+	return next
+}
+
+func reloadLevels(o Options) {
+	levels, err := o.ReloadLevels()
+	if err != nil {
+		o.logger().Error("sigctl: reloading log levels failed, keeping previous levels", "err", err)
+		return
+	}
+	o.logger().Info("sigctl: log levels reloaded", "levels", levels)
+}
+
+func reload(o Options) {
+	if o.Reopen != nil {
+		if err := o.Reopen(); err != nil {
+			o.logger().Error("sigctl: reopening log files failed", "err", err)
+		} else {
+			o.logger().Info("sigctl: log files reopened")
+		}
+	}
+	if o.Reload == nil {
+		if o.Reopen != nil {
+			return
+		}
+		o.logger().Info("sigctl: SIGHUP ignored, nothing to reload")
+		return
+	}
+	if err := o.Reload(); err != nil {
+		o.logger().Error("sigctl: reload failed, keeping previous configuration", "err", err)
+		return
+	}
+	o.logger().Info("sigctl: configuration reloaded")
+}
+
+// Start runs Run in a goroutine.
+func Start(ctx context.Context, o Options) {
+	go func() {
+		if err := Run(ctx, o); err != nil {
+			o.logger().Error(fmt.Sprint("sigctl: ", err))
+		}
+	}()
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package sigctl
+
+import "context"
+
+// Run returns immediately: SIGUSR1/SIGUSR2/SIGHUP don't exist here. Dump
+// and ToggleDebug can still be called directly.
+func Run(ctx context.Context, o Options) error { return nil }
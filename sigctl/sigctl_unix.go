@@ -0,0 +1,39 @@
+//go:build unix
+
+package sigctl
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Run handles SIGUSR1, SIGUSR2 and SIGHUP until ctx is done. Actions run
+// one at a time on Run's goroutine, so a slow reload delays the next dump
+// rather than overlapping it.
+func Run(ctx context.Context, o Options) error {
+	ch := make(chan os.Signal, 4)
+	signal.Notify(ch, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
+	defer signal.Stop(ch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case sig := <-ch:
+			switch sig {
+			case syscall.SIGUSR1:
+				Dump(o)
+			case syscall.SIGUSR2:
+				if o.ReloadLevels != nil {
+					reloadLevels(o)
+				} else {
+					ToggleDebug(o)
+				}
+			case syscall.SIGHUP:
+				reload(o)
+			}
+		}
+	}
+}
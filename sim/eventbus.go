@@ -0,0 +1,51 @@
+package sim
+
+import (
+	"context"
+
+	"Go-Internals/eventbus"
+)
+
+/*
+-----------------------------------
+EVENT BUS ADAPTER
+-----------------------------------
+*/
+
+// Handler returns an eventbus.Handler that hands each event to a daemon
+// actor named name, which calls h on it, in delivery order. The bus's own
+// subscriber goroutine only queues the event, so when h runs relative to
+// other actors is the scheduler's choice.
+//
+// Every subscription on a simulated bus must use a Handler, and every
+// publish must go through Actor.Publish: the scheduler waits for exactly
+// the deliveries Publish counted before each decision.
+func (s *Sim) Handler(name string, h func(a *Actor, ev eventbus.Event)) eventbus.Handler {
+	var queue []eventbus.Event // guarded by s.mu
+	queued := func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(queue) > 0
+	}
+	s.Daemon(name, func(a *Actor) {
+		for {
+			a.WaitFor(queued)
+			s.mu.Lock()
+			ev := queue[0]
+			queue = queue[1:]
+			s.mu.Unlock()
+			h(a, ev)
+		}
+	})
+	return func(ev eventbus.Event) {
+		s.delivered(func() { queue = append(queue, ev) })
+	}
+}
+
+// Publish publishes on bus and tells the scheduler how many deliveries to
+// wait for.
+func (a *Actor) Publish(ctx context.Context, bus *eventbus.Bus, topic string, payload any) (int, error) {
+	n, err := bus.Publish(ctx, topic, payload)
+	a.sim.expect(n)
+	return n, err
+}
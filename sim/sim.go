@@ -0,0 +1,392 @@
+// Package sim runs concurrent scenarios deterministically, so a race
+// found once can be replayed from its seed.
+//
+// A scenario is a set of actors, each a goroutine, but only one runs at
+// a time: an actor runs until it yields (Yield, Sleep, WaitFor) and the
+// scheduler then picks the next one with an RNG seeded from the
+// scenario's seed. Time is a clock.Fake that moves only when no actor can
+// run, straight to the earliest sleeper's deadline, so timeouts are
+// covered without waiting for them. Same seed, same interleaving, same
+// trace: the seed printed with a failure is a reproduction.
+//
+// Interleavings are chosen at yield points, i.e. between the operations
+// an actor performs, not inside them: the repository's own locking is
+// trusted, and what the scheduler explores is how operations from
+// different actors order (a read-modify-write losing an update, an event
+// observed before the write it announces).
+//
+// Code with goroutines of its own is brought under the scheduler through
+// an adapter. The eventbus one (Handler and Actor.Publish) turns each
+// subscription into an actor, so deliveries are scheduled like
+// everything else.
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+var (
+	ErrDeadlock = errors.New("sim: deadlock")
+	ErrMaxSteps = errors.New("sim: step limit reached")
+	// ErrLostDelivery means an external delivery counted by Publish did
+	// not arrive within Options.SettleTimeout.
+	ErrLostDelivery = errors.New("sim: delivery never arrived")
+)
+
+// Epoch is where every simulation's clock starts.
+var Epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Options configures New.
+type Options struct {
+	// MaxSteps bounds the scheduling decisions of one run, which turns a
+	// livelock into a failure; default 100000.
+	MaxSteps int
+	// SettleTimeout is how long, in real time, the scheduler waits for
+	// deliveries from goroutines it does not control; default 1s.
+	SettleTimeout time.Duration
+}
+
+// Sim is one run of a scenario.
+type Sim struct {
+	seed uint64
+	opts Options
+	rng  *rand.Rand
+	clk  *clock.Fake
+
+	actors []*Actor
+	parked chan struct{} // an actor gave control back
+	abort  chan struct{} // closed when the run ends
+	steps  int
+	trace  []string
+
+	mu      sync.Mutex // guards what external goroutines touch
+	pending int
+	kick    chan struct{}
+	failed  error
+}
+
+// New returns a simulation for seed. Add actors with Go, then Run.
+func New(seed uint64, opts Options) *Sim {
+	if opts.MaxSteps <= 0 {
+		opts.MaxSteps = 100000
+	}
+	if opts.SettleTimeout <= 0 {
+		opts.SettleTimeout = time.Second
+	}
+	return &Sim{
+		seed:   seed,
+		opts:   opts,
+		rng:    rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15)),
+		clk:    clock.NewFake(Epoch),
+		parked: make(chan struct{}),
+		abort:  make(chan struct{}),
+		kick:   make(chan struct{}, 1),
+	}
+}
+
+func (s *Sim) Seed() uint64 { return s.seed }
+
+// Clock is the simulated clock; pass it to the components under test.
+func (s *Sim) Clock() *clock.Fake { return s.clk }
+
+// Rand is the simulation's RNG, for decisions an actor makes (which user
+// to touch, what to write). Only one actor runs at a time, so using it is
+// as deterministic as the schedule.
+func (s *Sim) Rand() *rand.Rand { return s.rng }
+
+// Trace is every scheduling decision and Logf line of the run, in order.
+func (s *Sim) Trace() []string { return s.trace }
+
+// TraceHash summarizes Trace: two runs with the same hash interleaved
+// identically.
+func (s *Sim) TraceHash() uint64 {
+	h := fnv.New64a()
+	for _, l := range s.trace {
+		h.Write([]byte(l))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+/*
+-----------------------------------
+ACTORS
+-----------------------------------
+*/
+
+type state uint8
+
+const (
+	runnable state = iota
+	sleeping
+	waiting
+	done
+)
+
+// Actor is one simulated goroutine. Its methods may only be called from
+// the actor itself.
+type Actor struct {
+	sim    *Sim
+	name   string
+	daemon bool
+	resume chan struct{}
+
+	state state
+	until time.Time   // sleeping
+	cond  func() bool // waiting
+}
+
+func (a *Actor) Name() string { return a.name }
+
+// Sim returns the simulation the actor runs in.
+func (a *Actor) Sim() *Sim { return a.sim }
+
+// Go adds an actor. Adding actors from inside a running actor is allowed;
+// the new one becomes runnable at the next scheduling decision.
+func (s *Sim) Go(name string, fn func(a *Actor)) { s.spawn(name, false, fn) }
+
+// Daemon adds an actor that the run does not wait for: Run returns once
+// every other actor is done and no daemon can run.
+func (s *Sim) Daemon(name string, fn func(a *Actor)) { s.spawn(name, true, fn) }
+
+func (s *Sim) spawn(name string, daemon bool, fn func(a *Actor)) {
+	a := &Actor{sim: s, name: name, daemon: daemon, resume: make(chan struct{})}
+	s.actors = append(s.actors, a)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil && r != errAborted {
+				s.fail(fmt.Errorf("sim: actor %s panicked: %v\n%s", a.name, r, debug.Stack()))
+			}
+			select {
+			case <-s.abort: // the scheduler is gone; nobody to tell
+			default:
+				a.state = done
+				s.parked <- struct{}{}
+			}
+		}()
+		a.wait()
+		fn(a)
+	}()
+}
+
+// wait blocks until the scheduler picks a, or ends the goroutine if the
+// run is over.
+func (a *Actor) wait() {
+	select {
+	case <-a.resume:
+	case <-a.sim.abort:
+		panic(errAborted)
+	}
+}
+
+// errAborted unwinds an actor that failed or outlived its run.
+var errAborted = errors.New("sim: actor stopped")
+
+func (a *Actor) park(st state) {
+	a.state = st
+	a.sim.parked <- struct{}{}
+	a.wait()
+}
+
+// Yield lets the scheduler run any actor, this one included.
+func (a *Actor) Yield() { a.park(runnable) }
+
+// Sleep blocks for d of simulated time.
+func (a *Actor) Sleep(d time.Duration) {
+	a.until = a.sim.clk.Now().Add(d)
+	a.park(sleeping)
+}
+
+// WaitFor blocks until cond is true. cond is evaluated by the scheduler
+// while no actor runs, so it may read shared state without locks.
+func (a *Actor) WaitFor(cond func() bool) {
+	a.cond = cond
+	a.park(waiting)
+}
+
+// Logf adds a line to the trace.
+func (a *Actor) Logf(format string, args ...any) {
+	a.sim.trace = append(a.sim.trace, a.name+": "+fmt.Sprintf(format, args...))
+}
+
+// Fatalf fails the run and stops the actor.
+func (a *Actor) Fatalf(format string, args ...any) {
+	a.sim.fail(fmt.Errorf("%s: %s", a.name, fmt.Sprintf(format, args...)))
+	panic(errAborted)
+}
+
+func (s *Sim) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed == nil {
+		s.failed = err
+	}
+}
+
+/*
+-----------------------------------
+SCHEDULER
+-----------------------------------
+*/
+
+// Run schedules actors until all non-daemon actors are done, or one
+// fails, or the run deadlocks. The returned error names the seed.
+func (s *Sim) Run() error {
+	defer close(s.abort)
+	for {
+		if err := s.settle(); err != nil {
+			s.fail(err)
+		}
+		if err := s.failure(); err != nil {
+			return s.wrap(err)
+		}
+		ready := s.ready()
+		if len(ready) == 0 {
+			// Checked first: a daemon on a timer would otherwise keep
+			// the clock, and the run, going forever.
+			if s.finished() {
+				return nil
+			}
+			if s.wakeSleepers() {
+				continue
+			}
+			return s.wrap(fmt.Errorf("%w: blocked: %s", ErrDeadlock, strings.Join(s.blocked(), ", ")))
+		}
+		if s.steps++; s.steps > s.opts.MaxSteps {
+			return s.wrap(ErrMaxSteps)
+		}
+		a := ready[s.rng.IntN(len(ready))]
+		s.trace = append(s.trace, fmt.Sprintf("step %d: %s", s.steps, a.name))
+		a.state, a.cond = runnable, nil
+		a.resume <- struct{}{}
+		<-s.parked
+	}
+}
+
+func (s *Sim) wrap(err error) error {
+	return fmt.Errorf("seed %d, step %d: %w", s.seed, s.steps, err)
+}
+
+func (s *Sim) failure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failed
+}
+
+// ready lists the actors that can run, in creation order so the RNG's
+// choice depends only on the seed.
+func (s *Sim) ready() []*Actor {
+	var out []*Actor
+	for _, a := range s.actors {
+		if a.state == runnable || (a.state == waiting && a.cond()) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// wakeSleepers moves the clock to the earliest sleeper's deadline and
+// makes every actor due by then runnable.
+func (s *Sim) wakeSleepers() bool {
+	var next time.Time
+	for _, a := range s.actors {
+		if a.state == sleeping && (next.IsZero() || a.until.Before(next)) {
+			next = a.until
+		}
+	}
+	if next.IsZero() {
+		return false
+	}
+	s.clk.Set(next)
+	for _, a := range s.actors {
+		if a.state == sleeping && !a.until.After(next) {
+			a.state = runnable
+		}
+	}
+	return true
+}
+
+func (s *Sim) finished() bool {
+	for _, a := range s.actors {
+		if !a.daemon && a.state != done {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Sim) blocked() []string {
+	var out []string
+	for _, a := range s.actors {
+		if a.state != done && !a.daemon {
+			out = append(out, a.name)
+		}
+	}
+	return out
+}
+
+// settle waits for deliveries announced with expect to arrive.
+func (s *Sim) settle() error {
+	deadline := time.After(s.opts.SettleTimeout)
+	for {
+		s.mu.Lock()
+		n := s.pending
+		s.mu.Unlock()
+		if n <= 0 {
+			return nil
+		}
+		select {
+		case <-s.kick:
+		case <-deadline:
+			return fmt.Errorf("%w (%d outstanding)", ErrLostDelivery, n)
+		}
+	}
+}
+
+// expect announces n deliveries from outside the scheduler.
+func (s *Sim) expect(n int) {
+	s.mu.Lock()
+	s.pending += n
+	s.mu.Unlock()
+}
+
+// delivered runs f, from an external goroutine, as one announced delivery.
+func (s *Sim) delivered(f func()) {
+	s.mu.Lock()
+	f()
+	s.pending--
+	s.mu.Unlock()
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+/*
+-----------------------------------
+EXPLORATION
+-----------------------------------
+*/
+
+// Explore runs scenario with seeds first, first+1, ... up to n runs and
+// returns the first failure. Replay a failing seed with New(seed) and the
+// same scenario; Trace shows how it got there.
+func Explore(first uint64, n int, opts Options, scenario func(s *Sim)) (*Sim, error) {
+	for seed := first; seed < first+uint64(n); seed++ {
+		s := New(seed, opts)
+		scenario(s)
+		if err := s.Run(); err != nil {
+			return s, err
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,199 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"Go-Internals/boundedqueue"
+	"Go-Internals/eventbus"
+	"Go-Internals/users"
+)
+
+// lostUpdate has three actors increment a counter, kept in a user's
+// name, with GetByID then Update. With no compare-and-swap in between,
+// some interleavings lose an increment.
+func lostUpdate(s *Sim) {
+	repo := users.NewInMemoryUserRepo()
+	u, _ := repo.Create(users.User{Name: "0", Email: "counter@example.com"})
+	const actors, increments = 3, 4
+	finished := 0
+	for i := range actors {
+		s.Go("incr-"+strconv.Itoa(i), func(a *Actor) {
+			for range increments {
+				cur, err := repo.GetByID(u.ID)
+				if err != nil {
+					a.Fatalf("get: %v", err)
+				}
+				a.Yield() // the window between read and write
+				n, _ := strconv.Atoi(cur.Name)
+				cur.Name = strconv.Itoa(n + 1)
+				if _, err := repo.Update(cur); err != nil {
+					a.Fatalf("update: %v", err)
+				}
+				a.Logf("wrote %s", cur.Name)
+				a.Sleep(time.Duration(s.Rand().IntN(5)) * time.Millisecond)
+			}
+			if finished++; finished == actors {
+				got, _ := repo.GetByID(u.ID)
+				if got.Name != strconv.Itoa(actors*increments) {
+					a.Fatalf("counter is %s after %d increments", got.Name, actors*increments)
+				}
+			}
+		})
+	}
+}
+
+// feedOrder has two writers publish user.created through a bus whose
+// subscriber runs as an actor, so deliveries are scheduled too.
+func feedOrder(s *Sim) {
+	repo := users.NewInMemoryUserRepo()
+	bus := eventbus.New()
+	const writers, each = 2, 5
+	got := 0
+	handler := s.Handler("subscriber", func(a *Actor, ev eventbus.Event) {
+		a.Logf("saw %d", ev.Payload.(users.User).ID)
+		got++
+		a.Yield()
+	})
+	if _, err := bus.Subscribe("user.created", handler, eventbus.SubscribeOptions{Buffer: 8, Policy: boundedqueue.Reject}); err != nil {
+		panic(err)
+	}
+	finished := 0
+	for w := range writers {
+		name := "writer-" + strconv.Itoa(w)
+		s.Go(name, func(a *Actor) {
+			for i := range each {
+				u, err := repo.Create(users.User{Name: name, Email: fmt.Sprintf("%s-%d@example.com", name, i)})
+				if err != nil {
+					a.Fatalf("create: %v", err)
+				}
+				a.Yield()
+				if _, err := a.Publish(context.Background(), bus, "user.created", u); err != nil {
+					a.Fatalf("publish: %v", err)
+				}
+			}
+			if finished++; finished == writers {
+				a.WaitFor(func() bool { return got == writers*each })
+				bus.Close()
+			}
+		})
+	}
+}
+
+func run(seed uint64, scenario func(*Sim)) (*Sim, error) {
+	s := New(seed, Options{})
+	scenario(s)
+	return s, s.Run()
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
+
+func TestSameSeedSameTrace(t *testing.T) {
+	for _, sc := range []struct {
+		name     string
+		scenario func(*Sim)
+	}{
+		{"lost-update", lostUpdate},
+		{"feed-order", feedOrder},
+	} {
+		t.Run(sc.name, func(t *testing.T) {
+			hashes := map[uint64]bool{}
+			for seed := uint64(1); seed <= 20; seed++ {
+				first, err1 := run(seed, sc.scenario)
+				again, err2 := run(seed, sc.scenario)
+				if !slices.Equal(first.Trace(), again.Trace()) {
+					t.Fatalf("seed %d: traces differ:\n%s\n---\n%s", seed,
+						strings.Join(first.Trace(), "\n"), strings.Join(again.Trace(), "\n"))
+				}
+				if errString(err1) != errString(err2) {
+					t.Fatalf("seed %d: %v, then %v", seed, err1, err2)
+				}
+				if first.TraceHash() != again.TraceHash() {
+					t.Fatalf("seed %d: equal traces, hashes %x and %x", seed, first.TraceHash(), again.TraceHash())
+				}
+				hashes[first.TraceHash()] = true
+			}
+			// The seed has to matter, or replaying it proves nothing.
+			if len(hashes) < 2 {
+				t.Fatalf("20 seeds gave %d interleavings", len(hashes))
+			}
+		})
+	}
+}
+
+// The lost update is found, and its seed replays it: the same failure at
+// the same step, by the same path, on every run.
+func TestLostUpdateReplays(t *testing.T) {
+	s, err := Explore(1, 500, Options{}, lostUpdate)
+	if err == nil {
+		t.Fatal("500 seeds and no lost update")
+	}
+	if !strings.Contains(err.Error(), "counter is") || !strings.Contains(err.Error(), fmt.Sprintf("seed %d,", s.Seed())) {
+		t.Fatalf("Explore = %v, want the counter check to fail and name the seed", err)
+	}
+	for i := range 5 {
+		again, err2 := run(s.Seed(), lostUpdate)
+		if errString(err2) != err.Error() || again.TraceHash() != s.TraceHash() {
+			t.Fatalf("replay %d of seed %d = %v (trace %x), first %v (trace %x)",
+				i, s.Seed(), err2, again.TraceHash(), err, s.TraceHash())
+		}
+	}
+}
+
+func TestSleepMovesTheClock(t *testing.T) {
+	s := New(1, Options{})
+	var woke []string
+	for _, d := range []time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour} {
+		s.Go(d.String(), func(a *Actor) {
+			a.Sleep(d)
+			woke = append(woke, a.Name())
+			if got := s.Clock().Now().Sub(Epoch); got != d {
+				a.Fatalf("woke at %v", got)
+			}
+		})
+	}
+	start := time.Now()
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(woke, []string{"1h0m0s", "2h0m0s", "3h0m0s"}) {
+		t.Fatalf("woke in the order %v", woke)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("six hours of sleep took %v", elapsed)
+	}
+}
+
+func TestDeadlock(t *testing.T) {
+	s := New(7, Options{})
+	s.Go("stuck", func(a *Actor) { a.WaitFor(func() bool { return false }) })
+	err := s.Run()
+	if !errors.Is(err, ErrDeadlock) || !strings.Contains(err.Error(), "seed 7,") || !strings.Contains(err.Error(), "stuck") {
+		t.Fatalf("Run = %v, want a deadlock naming the seed and the actor", err)
+	}
+}
+
+// A daemon on a timer neither keeps the run going nor counts as stuck.
+func TestDaemonDoesNotHoldTheRun(t *testing.T) {
+	s := New(1, Options{MaxSteps: 50})
+	s.Daemon("ticker", func(a *Actor) {
+		for {
+			a.Sleep(time.Second)
+		}
+	})
+	s.Go("work", func(a *Actor) { a.Sleep(3 * time.Second) })
+	if err := s.Run(); err != nil {
+		t.Fatalf("Run = %v", err)
+	}
+}
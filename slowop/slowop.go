@@ -0,0 +1,315 @@
+// Package slowop reports operations that take longer than they should,
+// with what is needed to find out why.
+//
+// Code brackets an operation with Start and End. When one runs past its
+// threshold the Detector takes two pictures: at the moment the threshold
+// passes, every goroutine that is running or waiting on a lock or a
+// channel (the operation itself among them, and usually whoever holds
+// what it waits for); and when it ends, its own goroutine's stack, which
+// shows who called it. Both go into a Report with the request ID, tenant
+// and caller from the context and a short summary of the arguments.
+//
+// Fast operations cost a timer and nothing else. Goroutine dumps stop the
+// world briefly, so at most MaxDumps are taken a minute; past that a
+// report has only the final stack.
+package slowop
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"Go-Internals/ctxutil"
+	"Go-Internals/tenant"
+	"Go-Internals/window"
+)
+
+// Options configures New.
+type Options struct {
+	// Threshold is what counts as slow; default 100ms.
+	Threshold time.Duration
+	// Thresholds overrides Threshold per operation name.
+	Thresholds map[string]time.Duration
+	// MaxDumps caps the goroutine dumps taken per minute; default 6.
+	// Negative never dumps.
+	MaxDumps int
+	// Keep is how many recent reports Recent returns; default 50.
+	Keep int
+	// Sink receives every report; default Log(nil, os.Stderr).
+	Sink func(Report)
+}
+
+// Report is one slow operation.
+type Report struct {
+	Op        string        `json:"op"`
+	Args      string        `json:"args,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+	Tenant    string        `json:"tenant,omitempty"`
+	User      string        `json:"user,omitempty"`
+	Start     time.Time     `json:"start"`
+	Duration  time.Duration `json:"duration"`
+	Threshold time.Duration `json:"threshold"`
+	// Stack is the operation's goroutine as it ended.
+	Stack string `json:"stack"`
+	// Waiting is every goroutine running or blocked on a lock or a
+	// channel when the threshold passed; empty if the dump was skipped.
+	Waiting string `json:"waiting,omitempty"`
+}
+
+// Detector is safe for concurrent use. A nil *Detector detects nothing.
+type Detector struct {
+	opts  Options
+	dumps *window.Counter
+
+	slow   atomic.Int64
+	dumped atomic.Int64
+
+	mu     sync.Mutex
+	recent []Report // ring, next at len % Keep
+	next   int
+}
+
+func New(opts Options) *Detector {
+	if opts.Threshold <= 0 {
+		opts.Threshold = 100 * time.Millisecond
+	}
+	if opts.MaxDumps == 0 {
+		opts.MaxDumps = 6
+	}
+	if opts.Keep <= 0 {
+		opts.Keep = 50
+	}
+	if opts.Sink == nil {
+		opts.Sink = Log(nil, os.Stderr)
+	}
+	return &Detector{opts: opts, dumps: window.New(time.Minute, 6, nil)}
+}
+
+// Op is one operation in progress.
+type Op struct {
+	d         *Detector
+	ctx       context.Context
+	name      string
+	args      []any
+	start     time.Time
+	threshold time.Duration
+	timer     *time.Timer
+
+	mu      sync.Mutex
+	waiting string
+}
+
+// Start begins timing op. args are key/value pairs summarised into the
+// report, such as "id", 42; keep personal data out of them, since
+// reports are logged.
+func (d *Detector) Start(ctx context.Context, op string, args ...any) *Op {
+	if d == nil {
+		return nil
+	}
+	threshold := d.opts.Threshold
+	if t, ok := d.opts.Thresholds[op]; ok {
+		threshold = t
+	}
+	o := &Op{d: d, ctx: ctx, name: op, args: args, start: time.Now(), threshold: threshold}
+	if d.opts.MaxDumps > 0 {
+		o.timer = time.AfterFunc(threshold, o.snapshot)
+	}
+	return o
+}
+
+// snapshot runs once the threshold has passed with the operation still
+// going.
+func (o *Op) snapshot() {
+	if !o.d.mayDump() {
+		return
+	}
+	waiting := waitingGoroutines()
+	o.mu.Lock()
+	o.waiting = waiting
+	o.mu.Unlock()
+}
+
+func (d *Detector) mayDump() bool {
+	// Not exact under a burst of slow operations, which may take a dump
+	// or two over; it only has to stop a storm of them.
+	if d.dumps.Sum() >= uint64(d.opts.MaxDumps) {
+		return false
+	}
+	d.dumps.Inc()
+	d.dumped.Add(1)
+	return true
+}
+
+// End stops timing and reports the operation if it was slow.
+func (o *Op) End() {
+	if o == nil {
+		return
+	}
+	elapsed := time.Since(o.start)
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	if elapsed < o.threshold {
+		return
+	}
+	buf := make([]byte, 16<<10)
+	buf = buf[:runtime.Stack(buf, false)]
+	o.mu.Lock()
+	waiting := o.waiting
+	o.mu.Unlock()
+
+	r := Report{
+		Op: o.name, Args: summarize(o.args), RequestID: ctxutil.RequestID(o.ctx),
+		Start: o.start, Duration: elapsed, Threshold: o.threshold,
+		Stack: string(buf), Waiting: waiting,
+	}
+	if t := ctxutil.TenantID(o.ctx); t != tenant.Default {
+		r.Tenant = t
+	}
+	r.User = ctxutil.UserID(o.ctx)
+	o.d.slow.Add(1)
+	o.d.keep(r)
+	o.d.opts.Sink(r)
+}
+
+// summarize renders key/value pairs as "k=v k=v", each value cut to 64
+// bytes.
+func summarize(args []any) string {
+	var b strings.Builder
+	for i := 0; i < len(args); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		var v any = "?"
+		if i+1 < len(args) {
+			v = args[i+1]
+		}
+		s := fmt.Sprint(v)
+		if len(s) > 64 {
+			s = s[:61] + "..."
+		}
+		fmt.Fprintf(&b, "%v=%s", args[i], s)
+	}
+	return b.String()
+}
+
+// waitingGoroutines dumps every goroutine and keeps those running or
+// blocked on a lock, a condition or a channel: the ones that say who is
+// stuck behind whom. Sleeping, idle and I/O-waiting goroutines, and the
+// dumping one itself, are left out.
+func waitingGoroutines() string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		if len(buf) >= 16<<20 {
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	var out bytes.Buffer
+	for i, g := range bytes.Split(buf, []byte("\n\n")) {
+		if i == 0 {
+			continue // this goroutine, taking the dump
+		}
+		header, _, _ := bytes.Cut(g, []byte("\n"))
+		if interesting(string(header)) {
+			out.Write(g)
+			out.WriteString("\n\n")
+		}
+	}
+	return out.String()
+}
+
+// interesting reads a "goroutine 7 [sync.Mutex.Lock, 2 minutes]:"
+// header's state.
+func interesting(header string) bool {
+	_, state, ok := strings.Cut(header, "[")
+	if !ok {
+		return false
+	}
+	state, _, _ = strings.Cut(state, "]")
+	state, _, _ = strings.Cut(state, ",")
+	switch {
+	case state == "running", state == "runnable", state == "syscall":
+		return true
+	case strings.Contains(state, "Mutex"), strings.Contains(state, "semacquire"), strings.Contains(state, "Cond"),
+		strings.HasPrefix(state, "chan"), state == "select":
+		return true
+	}
+	return false
+}
+
+func (d *Detector) keep(r Report) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.recent) < d.opts.Keep {
+		d.recent = append(d.recent, r)
+		return
+	}
+	d.recent[d.next] = r
+	d.next = (d.next + 1) % d.opts.Keep
+}
+
+// Recent returns the latest reports, newest first.
+func (d *Detector) Recent() []Report {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]Report, 0, len(d.recent))
+	for i := range d.recent {
+		out = append(out, d.recent[(d.next+len(d.recent)-1-i)%len(d.recent)])
+	}
+	return out
+}
+
+// Stats counts slow operations and the dumps taken for them.
+type Stats struct {
+	Slow  int64 `json:"slow"`
+	Dumps int64 `json:"dumps"`
+}
+
+func (d *Detector) Stats() Stats {
+	if d == nil {
+		return Stats{}
+	}
+	return Stats{Slow: d.slow.Load(), Dumps: d.dumped.Load()}
+}
+
+// Log returns the default sink: one log record per report on l
+// (slog.Default() if nil), and the stacks, too long and multi-line for a
+// record, to stacks (skipped if nil).
+func Log(l *slog.Logger, stacks io.Writer) func(Report) {
+	var mu sync.Mutex // keeps concurrent reports' stacks apart
+	return func(r Report) {
+		logger := l
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.Warn("slowop: slow operation", "op", r.Op, "duration", r.Duration, "threshold", r.Threshold,
+			"args", r.Args, "request_id", r.RequestID, "tenant", r.Tenant, "user", r.User)
+		if stacks == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(stacks, "---- slow %s (%s, request %s) ----\n%s\n", r.Op, r.Duration, r.RequestID, r.Stack)
+		if r.Waiting != "" {
+			fmt.Fprintf(stacks, "---- goroutines running or waiting at %s ----\n%s", r.Threshold, r.Waiting)
+		}
+		fmt.Fprintf(stacks, "---- end slow %s ----\n", r.Op)
+	}
+}
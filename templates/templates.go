@@ -0,0 +1,259 @@
+// Package templates renders notification content (emails, webhook bodies)
+// from embedded template files.
+//
+// Layout:
+//
+//	files/layouts/base.html.tmpl   shared layouts; pages fill {{block "content"}}
+//	files/layouts/base.txt.tmpl
+//	files/<name>.subject.tmpl      one file per part of a notification
+//	files/<name>.txt.tmpl
+//	files/<name>.html.tmpl
+//	files/<locale>/<name>.*.tmpl   per-locale overrides of any of the above
+//
+// .html.tmpl files go through html/template (contextual escaping), all
+// others through text/template. Inside templates, {{t "key" (params "k" v)}}
+// pulls a string from the i18n catalog in the render locale, so most
+// strings never need a per-locale file at all.
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	"Go-Internals/i18n"
+)
+
+//go:embed files
+var embedded embed.FS
+
+var ErrNotFound = errors.New("templates: template not found")
+
+// Part is one rendered part of a notification.
+type Part string
+
+const (
+	Subject Part = "subject"
+	Text    Part = "txt"
+	HTML    Part = "html"
+	Webhook Part = "webhook" // request body for webhook deliveries
+)
+
+// Rendered is a complete notification.
+type Rendered struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Engine holds parsed templates, keyed by "<locale>/<name>.<part>" with an
+// empty locale for the defaults.
+type Engine struct {
+	bundle *i18n.Bundle
+	html   map[string]*htmltemplate.Template
+	text   map[string]*texttemplate.Template
+}
+
+// Default is built from the embedded files and i18n.Default.
+var Default = mustNew(Files(), i18n.Default)
+
+// Files is the embedded templates, laid out as above.
+func Files() fs.FS { return mustSub(embedded, "files") }
+
+// New parses every template under fsys. Parse errors surface here, at
+// startup, rather than on the first notification.
+func New(fsys fs.FS, bundle *i18n.Bundle) (*Engine, error) {
+	e := &Engine{
+		bundle: bundle,
+		html:   make(map[string]*htmltemplate.Template),
+		text:   make(map[string]*texttemplate.Template),
+	}
+
+	htmlLayouts, _ := fs.Glob(fsys, "layouts/*.html.tmpl")
+	textLayouts, _ := fs.Glob(fsys, "layouts/*.txt.tmpl")
+
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".tmpl") || strings.HasPrefix(p, "layouts/") {
+			return err
+		}
+		key := strings.TrimSuffix(p, ".tmpl")
+
+		if strings.HasSuffix(key, "."+string(HTML)) {
+			t := htmltemplate.New(path.Base(p)).Funcs(htmltemplate.FuncMap(e.funcs(i18n.DefaultLocale)))
+			files := append([]string{p}, htmlLayouts...)
+			if _, err := t.ParseFS(fsys, files...); err != nil {
+				return err
+			}
+			e.html[key] = t
+			return nil
+		}
+
+		t := texttemplate.New(path.Base(p)).Funcs(e.funcs(i18n.DefaultLocale))
+		files := []string{p}
+		if strings.HasSuffix(key, "."+string(Text)) {
+			files = append(files, textLayouts...)
+		}
+		if _, err := t.ParseFS(fsys, files...); err != nil {
+			return err
+		}
+		e.text[key] = t
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("templates: %w", err)
+	}
+	return e, nil
+}
+
+func mustNew(fsys fs.FS, bundle *i18n.Bundle) *Engine {
+	e, err := New(fsys, bundle)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// Names lists the available notification names (without parts or locales).
+func (e *Engine) Names() []string {
+	seen := map[string]bool{}
+	for _, m := range []map[string]bool{keys(e.html), keys(e.text)} {
+		for k := range m {
+			if strings.Contains(k, "/") {
+				continue
+			}
+			name := k[:strings.LastIndex(k, ".")]
+			seen[name] = true
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for n := range seen {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// RenderPart writes one part of a notification in locale.
+func (e *Engine) RenderPart(w io.Writer, locale, name string, part Part, data any) error {
+	key, ok := e.resolve(locale, name, part)
+	if !ok {
+		return fmt.Errorf("%w: %s.%s", ErrNotFound, name, part)
+	}
+	funcs := e.funcs(locale)
+
+	if part == HTML {
+		t, err := e.html[key].Clone()
+		if err != nil {
+			return err
+		}
+		return t.Funcs(htmltemplate.FuncMap(funcs)).Execute(w, data)
+	}
+
+	t, err := e.text[key].Clone()
+	if err != nil {
+		return err
+	}
+	return t.Funcs(funcs).Execute(w, data)
+}
+
+// Render produces every part that exists for name. Missing parts are left
+// empty; a notification with no parts at all is ErrNotFound.
+func (e *Engine) Render(locale, name string, data any) (Rendered, error) {
+	var r Rendered
+	found := false
+	for _, p := range []struct {
+		part Part
+		dst  *string
+	}{{Subject, &r.Subject}, {Text, &r.Text}, {HTML, &r.HTML}} {
+		var buf bytes.Buffer
+		err := e.RenderPart(&buf, locale, name, p.part, data)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return Rendered{}, err
+		}
+		found = true
+		*p.dst = strings.TrimSpace(buf.String())
+	}
+	if !found {
+		return Rendered{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return r, nil
+}
+
+// resolve finds the most specific template: exact locale, base language,
+// then the default.
+func (e *Engine) resolve(locale, name string, part Part) (string, bool) {
+	locale = strings.ToLower(locale)
+	base, _, _ := strings.Cut(locale, "-")
+	file := name + "." + string(part)
+
+	for _, prefix := range []string{locale + "/", base + "/", ""} {
+		key := prefix + file
+		if part == HTML {
+			if _, ok := e.html[key]; ok {
+				return key, true
+			}
+		} else if _, ok := e.text[key]; ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func (e *Engine) funcs(locale string) texttemplate.FuncMap {
+	return texttemplate.FuncMap{
+		"t": func(key string, params ...i18n.Params) string {
+			var p i18n.Params
+			if len(params) > 0 {
+				p = params[0]
+			}
+			return e.bundle.Translate(locale, key, p)
+		},
+		"params": func(kv ...any) (i18n.Params, error) {
+			if len(kv)%2 != 0 {
+				return nil, errors.New("params: odd number of arguments")
+			}
+			p := make(i18n.Params, len(kv)/2)
+			for i := 0; i < len(kv); i += 2 {
+				k, ok := kv[i].(string)
+				if !ok {
+					return nil, fmt.Errorf("params: key %v is not a string", kv[i])
+				}
+				p[k] = kv[i+1]
+			}
+			return p, nil
+		},
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"locale": func() string { return locale },
+	}
+}
+
+func keys[V any](m map[string]V) map[string]bool {
+	out := make(map[string]bool, len(m))
+	for k := range m {
+		out[k] = true
+	}
+	return out
+}
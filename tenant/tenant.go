@@ -0,0 +1,22 @@
+// Package tenant carries the tenant ID through a request context.
+package tenant
+
+import "context"
+
+// Default is used when a request carries no tenant.
+const Default = "default"
+
+type ctxKey struct{}
+
+// With returns a context carrying tenant id.
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// From returns the tenant in ctx, or Default.
+func From(ctx context.Context) string {
+	if id, ok := ctx.Value(ctxKey{}).(string); ok && id != "" {
+		return id
+	}
+	return Default
+}
@@ -0,0 +1,118 @@
+// Package totp implements time-based one-time passwords (RFC 6238), the
+// six-digit codes of authenticator apps, and the otpauth:// URIs their
+// QR codes provision them with.
+//
+// Only the parameters every app supports are offered: HMAC-SHA1, six
+// digits, a 30-second period. Verify accepts codes a few periods either
+// side of now, for clocks that drift and people who type slowly, and
+// returns the period a code was for, so a caller can refuse it twice.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	// SecretSize is the bytes of a secret NewSecret makes: 160 bits, as
+	// RFC 4226 recommends for SHA-1.
+	SecretSize = 20
+)
+
+var ErrBadSecret = errors.New("totp: secret is not base32")
+
+// encoding is base32 as authenticator apps take it: RFC 4648's alphabet,
+// unpadded.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random secret in base32.
+func NewSecret() string {
+	b := make([]byte, SecretSize)
+	rand.Read(b)
+	return encoding.EncodeToString(b)
+}
+
+// decodeSecret takes a secret as people copy them: in either case, with
+// spaces, padded or not.
+func decodeSecret(secret string) ([]byte, error) {
+	s := strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(secret))
+	key, err := encoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrBadSecret
+	}
+	return key, nil
+}
+
+// Step is the period t is in: the count of periods since the epoch.
+func Step(t time.Time) int64 { return t.Unix() / int64(Period/time.Second) }
+
+// Code is secret's code for the period t is in.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, Step(t)), nil
+}
+
+// hotp is RFC 4226's HOTP of counter, with dynamic truncation.
+func hotp(key []byte, counter int64) string {
+	mac := hmac.New(sha1.New, key)
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[off:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, n%1_000_000)
+}
+
+// Verify reports whether code is secret's for a period within skew of
+// t's, and which period it is. The periods are all tried, in constant
+// time, so how long an answer takes says nothing of how close a guess
+// came.
+func Verify(secret, code string, t time.Time, skew int) (int64, bool) {
+	key, err := decodeSecret(secret)
+	code = strings.ReplaceAll(code, " ", "")
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+	now := Step(t)
+	var step int64
+	ok := 0
+	for d := -int64(skew); d <= int64(skew); d++ {
+		match := subtle.ConstantTimeCompare([]byte(hotp(key, now+d)), []byte(code))
+		// The first match wins; a code cannot be two periods' at once
+		// but for a collision, and then the earlier is the one to burn.
+		step = int64(subtle.ConstantTimeSelect(match&^ok, int(now+d), int(step)))
+		ok |= match
+	}
+	return step, ok == 1
+}
+
+// URI is the otpauth:// URI that provisions secret in an authenticator
+// app, usually shown as a QR code: the app lists it as issuer and
+// account.
+func URI(issuer, account, secret string) string {
+	q := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(Digits)},
+		"period":    {fmt.Sprint(int(Period / time.Second))},
+	}
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	// Spaces as %20: apps read the query as a URI's, not a form's.
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(q.Encode(), "+", "%20")
+}
@@ -0,0 +1,174 @@
+// Package ttl expires keys at their deadline.
+//
+// Deadlines live in a min-heap, so the sweeper always sleeps exactly until
+// the next expiry instead of scanning everything on a fixed tick. Schedule,
+// Reschedule and Cancel are O(log n).
+package ttl
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+// Sweeper calls OnExpire for each key whose deadline has passed.
+type Sweeper[K comparable] struct {
+	mu       sync.Mutex
+	h        expiryHeap[K]
+	pos      map[K]*entry[K]
+	clk      clock.Clock
+	wake     chan struct{} // poked when the earliest deadline may have changed
+	onExpire func(key K, at time.Time)
+}
+
+type entry[K comparable] struct {
+	key   K
+	at    time.Time
+	index int
+}
+
+// New creates a sweeper. onExpire runs on the Run goroutine, one key at a
+// time, without the sweeper lock held.
+func New[K comparable](clk clock.Clock, onExpire func(key K, at time.Time)) *Sweeper[K] {
+	return &Sweeper[K]{
+		pos:      make(map[K]*entry[K]),
+		clk:      clock.OrReal(clk),
+		wake:     make(chan struct{}, 1),
+		onExpire: onExpire,
+	}
+}
+
+// Schedule sets (or moves) key's deadline.
+func (s *Sweeper[K]) Schedule(key K, at time.Time) {
+	s.mu.Lock()
+	if e, ok := s.pos[key]; ok {
+		e.at = at
+		heap.Fix(&s.h, e.index)
+	} else {
+		e := &entry[K]{key: key, at: at}
+		heap.Push(&s.h, e)
+		s.pos[key] = e
+	}
+	s.mu.Unlock()
+	s.poke()
+}
+
+// Cancel removes key. It reports whether the key was scheduled.
+func (s *Sweeper[K]) Cancel(key K) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.pos[key]
+	if !ok {
+		return false
+	}
+	heap.Remove(&s.h, e.index)
+	delete(s.pos, key)
+	return true
+}
+
+// Len reports how many keys are scheduled.
+func (s *Sweeper[K]) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.h)
+}
+
+// Next returns the earliest deadline.
+func (s *Sweeper[K]) Next() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.h) == 0 {
+		return time.Time{}, false
+	}
+	return s.h[0].at, true
+}
+
+// Run expires keys until ctx is done.
+func (s *Sweeper[K]) Run(ctx context.Context) {
+	for {
+		s.expireDue()
+
+		var timeC <-chan time.Time
+		var timer clock.Timer
+		if next, ok := s.Next(); ok {
+			timer = s.clk.NewTimer(next.Sub(s.clk.Now()))
+			timeC = timer.C()
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-s.wake:
+		case <-timeC:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// expireDue pops and reports everything whose deadline is <= now.
+func (s *Sweeper[K]) expireDue() {
+	for {
+		now := s.clk.Now()
+
+		s.mu.Lock()
+		if len(s.h) == 0 || s.h[0].at.After(now) {
+			s.mu.Unlock()
+			return
+		}
+		e := heap.Pop(&s.h).(*entry[K])
+		delete(s.pos, e.key)
+		s.mu.Unlock()
+
+		if s.onExpire != nil {
+			s.onExpire(e.key, e.at)
+		}
+	}
+}
+
+func (s *Sweeper[K]) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+/*
+-----------------------------------
+MIN-HEAP (container/heap)
+-----------------------------------
+*/
+
+type expiryHeap[K comparable] []*entry[K]
+
+func (h expiryHeap[K]) Len() int           { return len(h) }
+func (h expiryHeap[K]) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h expiryHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap[K]) Push(x any) {
+	e := x.(*entry[K])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap[K]) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*h = old[:n-1]
+	return e
+}
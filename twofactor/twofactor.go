@@ -0,0 +1,431 @@
+// Package twofactor adds a second factor to sign-ins: TOTP codes from an
+// authenticator app, with one-time recovery codes for a lost device.
+//
+// Enrolling is two steps. Enroll makes a secret and the otpauth:// URI
+// an app's QR scanner takes; nothing is enforced yet. Confirm, with a
+// code the app then shows, switches the factor on and returns the
+// recovery codes, the only time they are seen: only their hashes are
+// kept. From then on Verify takes a current code, each one once, or a
+// recovery code, which it uses up.
+//
+// Accounts are token subjects, a user's ID for their tokens, so service
+// accounts can enroll too. Enrollments are JSON blobs in a
+// blobstore.Store, their secrets sealed with a fieldcrypt.Codec when the
+// Manager has one. Which roles must pass a second factor is the
+// Manager's policy; see Required.
+package twofactor
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/auth"
+	"Go-Internals/blobstore"
+	"Go-Internals/clock"
+	"Go-Internals/ctxutil"
+	"Go-Internals/eventbus"
+	"Go-Internals/fieldcrypt"
+	"Go-Internals/totp"
+	"Go-Internals/users"
+)
+
+var (
+	ErrNotEnrolled = errors.New("twofactor: not enrolled")
+	ErrEnabled     = errors.New("twofactor: already enabled")
+	ErrBadCode     = errors.New("twofactor: wrong or already used code")
+)
+
+// Method is how Verify was satisfied.
+type Method string
+
+const (
+	TOTP     Method = "totp"
+	Recovery Method = "recovery_code"
+)
+
+// Options configures New.
+type Options struct {
+	// Store keeps the enrollments; required.
+	Store blobstore.Store
+	// Issuer names the service in authenticator apps; default "users".
+	Issuer string
+	// Skew is how many periods either side of now a code is accepted
+	// for; default 1, so a code is good for about 90 seconds.
+	Skew int
+	// RecoveryCodes is how many Confirm hands out; default 10.
+	RecoveryCodes int
+	// Require lists the roles whose tokens must have passed a second
+	// factor.
+	Require []string
+	// Codec, if set, seals secrets at rest.
+	Codec *fieldcrypt.Codec
+	// Audit records two_factor.enrolled, .enabled, .verified, .failed,
+	// .recovery_codes and .disabled. Default audit.Discard.
+	Audit audit.Sink
+	// Clock defaults to clock.Real().
+	Clock clock.Clock
+}
+
+// Enrollment is what an authenticator app is set up with.
+type Enrollment struct {
+	Secret string `json:"secret"`
+	// URI is the otpauth:// URI to show as a QR code.
+	URI string `json:"uri"`
+}
+
+// Status is an account's second factor, without its secrets.
+type Status struct {
+	Enabled bool `json:"enabled"`
+	// Pending is an enrollment not confirmed yet.
+	Pending      bool      `json:"pending"`
+	EnabledAt    time.Time `json:"enabled_at,omitzero"`
+	RecoveryLeft int       `json:"recovery_codes_left"`
+}
+
+// record is an enrollment as stored.
+type record struct {
+	Secret    string    `json:"secret"`
+	EnabledAt time.Time `json:"enabled_at,omitzero"`
+	// LastStep is the period of the last code accepted; earlier ones are
+	// refused, so a code seen over a shoulder is already spent.
+	LastStep int64  `json:"last_step"`
+	Salt     string `json:"salt,omitempty"`
+	// Recovery are the SHA-256s, salted, of the unused recovery codes.
+	Recovery []string `json:"recovery,omitempty"`
+}
+
+func (r *record) status() Status {
+	return Status{Enabled: !r.EnabledAt.IsZero(), Pending: r.EnabledAt.IsZero(), EnabledAt: r.EnabledAt, RecoveryLeft: len(r.Recovery)}
+}
+
+type Manager struct {
+	opts Options
+	// mu serializes read-modify-writes of enrollments, so two requests
+	// cannot both spend one code.
+	mu sync.Mutex
+}
+
+func New(opts Options) *Manager {
+	if opts.Issuer == "" {
+		opts.Issuer = "users"
+	}
+	if opts.Skew <= 0 {
+		opts.Skew = 1
+	}
+	if opts.RecoveryCodes <= 0 {
+		opts.RecoveryCodes = 10
+	}
+	if opts.Audit == nil {
+		opts.Audit = audit.Discard
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	return &Manager{opts: opts}
+}
+
+// Required reports whether c's roles call for a second factor.
+func (m *Manager) Required(c auth.Claims) bool {
+	for _, role := range m.opts.Require {
+		if c.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Enroll starts, or starts over, account's enrollment; label is how
+// apps list the account, its email say. ErrEnabled if a factor is on:
+// Disable it first.
+func (m *Manager) Enroll(ctx context.Context, account, label string) (Enrollment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.load(ctx, account)
+	switch {
+	case err == nil && !r.EnabledAt.IsZero():
+		return Enrollment{}, ErrEnabled
+	case err != nil && !errors.Is(err, ErrNotEnrolled):
+		return Enrollment{}, err
+	}
+	r = &record{Secret: totp.NewSecret()}
+	if err := m.save(ctx, account, r); err != nil {
+		return Enrollment{}, err
+	}
+	m.record(ctx, "two_factor.enrolled", account, nil)
+	return Enrollment{Secret: r.Secret, URI: totp.URI(m.opts.Issuer, label, r.Secret)}, nil
+}
+
+// Confirm switches account's factor on with a code from the app it was
+// enrolled in, and returns the recovery codes.
+func (m *Manager) Confirm(ctx context.Context, account, code string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.load(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+	if !r.EnabledAt.IsZero() {
+		return nil, ErrEnabled
+	}
+	step, ok := totp.Verify(r.Secret, code, m.opts.Clock.Now(), m.opts.Skew)
+	if !ok {
+		m.record(ctx, "two_factor.failed", account, map[string]string{"during": "confirm"})
+		return nil, ErrBadCode
+	}
+	r.EnabledAt, r.LastStep = m.opts.Clock.Now(), step
+	codes := m.newRecovery(r)
+	if err := m.save(ctx, account, r); err != nil {
+		return nil, err
+	}
+	m.record(ctx, "two_factor.enabled", account, nil)
+	return codes, nil
+}
+
+// Verify checks code, a current TOTP code or an unused recovery code,
+// against account's factor. ErrNotEnrolled if it has none on.
+func (m *Manager) Verify(ctx context.Context, account, code string) (Method, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.load(ctx, account)
+	if err != nil {
+		return "", err
+	}
+	if r.EnabledAt.IsZero() {
+		return "", ErrNotEnrolled
+	}
+	method := TOTP
+	if c := strings.ReplaceAll(code, " ", ""); len(c) == totp.Digits && strings.Trim(c, "0123456789") == "" {
+		step, ok := totp.Verify(r.Secret, c, m.opts.Clock.Now(), m.opts.Skew)
+		if !ok || step <= r.LastStep {
+			m.record(ctx, "two_factor.failed", account, nil)
+			return "", ErrBadCode
+		}
+		r.LastStep = step
+	} else {
+		method = Recovery
+		if !r.useRecovery(code) {
+			m.record(ctx, "two_factor.failed", account, nil)
+			return "", ErrBadCode
+		}
+	}
+	if err := m.save(ctx, account, r); err != nil {
+		return "", err
+	}
+	m.record(ctx, "two_factor.verified", account, map[string]string{
+		"method": string(method), "recovery_codes_left": strconv.Itoa(len(r.Recovery)),
+	})
+	return method, nil
+}
+
+// RegenerateRecovery replaces account's recovery codes with new ones.
+func (m *Manager) RegenerateRecovery(ctx context.Context, account string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, err := m.load(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+	if r.EnabledAt.IsZero() {
+		return nil, ErrNotEnrolled
+	}
+	codes := m.newRecovery(r)
+	if err := m.save(ctx, account, r); err != nil {
+		return nil, err
+	}
+	m.record(ctx, "two_factor.recovery_codes", account, nil)
+	return codes, nil
+}
+
+// Disable removes account's factor, on or pending. ErrNotEnrolled if it
+// has none.
+func (m *Manager) Disable(ctx context.Context, account string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.load(ctx, account); err != nil {
+		return err
+	}
+	if err := m.opts.Store.Delete(ctx, key(account)); err != nil {
+		return err
+	}
+	m.record(ctx, "two_factor.disabled", account, nil)
+	return nil
+}
+
+// Status is account's second factor; a zero Status if it has none.
+func (m *Manager) Status(ctx context.Context, account string) (Status, error) {
+	r, err := m.load(ctx, account)
+	if errors.Is(err, ErrNotEnrolled) {
+		return Status{}, nil
+	}
+	if err != nil {
+		return Status{}, err
+	}
+	return r.status(), nil
+}
+
+// Subscribe removes a user's factor when the service publishes their
+// deletion.
+func (m *Manager) Subscribe(bus *eventbus.Bus) error {
+	_, err := bus.Subscribe(users.TopicUserDeleted, func(ev eventbus.Event) {
+		if u, ok := ev.Payload.(users.User); ok {
+			_ = m.opts.Store.Delete(context.Background(), key(strconv.Itoa(u.ID)))
+		}
+	}, eventbus.SubscribeOptions{})
+	return err
+}
+
+/*
+-----------------------------------
+RECOVERY CODES
+-----------------------------------
+*/
+
+// recoveryAlphabet leaves out 0, 1, 8 and 9, which read as letters.
+const recoveryAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+// newRecovery gives r a fresh salt and set of codes, returning them in
+// the clear: "xxxxx-xxxxx", 50 random bits each.
+func (m *Manager) newRecovery(r *record) []string {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	r.Salt = hex.EncodeToString(salt)
+	r.Recovery = r.Recovery[:0]
+	codes := make([]string, m.opts.RecoveryCodes)
+	for i := range codes {
+		b := make([]byte, 10)
+		rand.Read(b)
+		for j := range b {
+			b[j] = recoveryAlphabet[b[j]%32]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+		r.Recovery = append(r.Recovery, r.hash(codes[i]))
+	}
+	return codes
+}
+
+// hash is the stored form of a recovery code. Codes have the entropy a
+// password lacks, so a salted SHA-256 is enough.
+func (r *record) hash(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(r.Salt + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// useRecovery removes code from r's codes, reporting whether it was one.
+// Every code is compared, in constant time.
+func (r *record) useRecovery(code string) bool {
+	h := []byte(r.hash(code))
+	found := -1
+	for i, c := range r.Recovery {
+		if subtle.ConstantTimeCompare([]byte(c), h) == 1 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return false
+	}
+	r.Recovery = append(r.Recovery[:found], r.Recovery[found+1:]...)
+	return true
+}
+
+/*
+-----------------------------------
+STORAGE
+-----------------------------------
+*/
+
+// key is where account's enrollment is stored. Subjects may have any
+// character, so they are encoded into what blob keys allow.
+func key(account string) string {
+	return "two-factor/" + base64.RawURLEncoding.EncodeToString([]byte(account))
+}
+
+// secretField is the name secrets are sealed under.
+const secretField = "two_factor.secret"
+
+func (m *Manager) load(ctx context.Context, account string) (*record, error) {
+	rc, _, err := m.opts.Store.Get(ctx, key(account))
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, ErrNotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var r record
+	if err := json.NewDecoder(io.LimitReader(rc, 1<<16)).Decode(&r); err != nil {
+		return nil, err
+	}
+	if m.opts.Codec != nil {
+		if r.Secret, err = m.opts.Codec.Open(secretField, r.Secret); err != nil {
+			return nil, err
+		}
+	}
+	return &r, nil
+}
+
+func (m *Manager) save(ctx context.Context, account string, r *record) error {
+	stored := *r
+	if m.opts.Codec != nil {
+		stored.Secret = m.opts.Codec.Seal(secretField, r.Secret, fieldcrypt.Random)
+	}
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	_, err = m.opts.Store.Put(ctx, key(account), bytes.NewReader(b), "application/json")
+	return err
+}
+
+// record is best effort: what it records has happened either way.
+func (m *Manager) record(ctx context.Context, action, account string, meta map[string]string) {
+	_ = m.opts.Audit.Record(ctx, audit.Entry{
+		Actor:      ctxutil.UserID(ctx),
+		Action:     action,
+		Resource:   "account",
+		ResourceID: account,
+		Meta:       meta,
+	})
+}
+
+/*
+-----------------------------------
+PRIVACY
+-----------------------------------
+*/
+
+func (m *Manager) Name() string { return "two_factor" }
+
+// Export is the user's Status; secrets and recovery hashes stay out of
+// archives, which travel.
+func (m *Manager) Export(ctx context.Context, id int) (any, error) {
+	st, err := m.Status(ctx, strconv.Itoa(id))
+	if err != nil || (!st.Enabled && !st.Pending) {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (m *Manager) Erase(ctx context.Context, id int) (int, error) {
+	err := m.Disable(ctx, strconv.Itoa(id))
+	if errors.Is(err, ErrNotEnrolled) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
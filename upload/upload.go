@@ -0,0 +1,431 @@
+// Package upload takes large bodies in chunks, so a dropped connection
+// costs the chunk in flight rather than the whole transfer.
+//
+// A client declares the size up front (Init), sends the bytes as chunks
+// at increasing offsets (Write), and can ask at any time how far it got
+// (Status) to resume from there. Every chunk carries its SHA-256 and is
+// rejected if the bytes do not match; the whole body may carry one too,
+// checked before Assemble hands it over. Chunks are kept in a blob store
+// and read back in order by Assemble, so neither a chunk nor the body is
+// ever held in memory.
+//
+// An upload nobody touches for TTL is abandoned: the ttl.Sweeper started
+// by Run deletes its chunks. Upload state lives in memory, so uploads do
+// not survive a restart; clients start over.
+package upload
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"Go-Internals/blobstore"
+	"Go-Internals/clock"
+	"Go-Internals/i18n"
+	"Go-Internals/ttl"
+)
+
+var (
+	ErrNotFound   = errors.New("upload: no such upload")
+	ErrOffset     = errors.New("upload: chunk is not at the upload's offset")
+	ErrChecksum   = errors.New("upload: checksum mismatch")
+	ErrTooLarge   = errors.New("upload: too large")
+	ErrIncomplete = errors.New("upload: not every byte has been received")
+	ErrBusy       = errors.New("upload: another request is writing this upload")
+	ErrEmpty      = errors.New("upload: empty chunk")
+)
+
+// maxChunks bounds the chunks of one upload, as S3 bounds parts.
+const maxChunks = 10000
+
+// Options configures New.
+type Options struct {
+	Store blobstore.Store
+	// TTL is how long an upload may go without a chunk before it is
+	// abandoned; default 24h.
+	TTL time.Duration
+	// MaxSize bounds the declared size of an upload; default 1 GiB.
+	MaxSize int64
+	// MaxChunk bounds one chunk; default 16 MiB.
+	MaxChunk int64
+	Clock    clock.Clock
+	// Logger reports failed clean-ups; default slog.Default().
+	Logger *slog.Logger
+}
+
+// Spec is what Init is told about an upload.
+type Spec struct {
+	Size int64
+	// SHA256 is the hex digest of the whole body; optional.
+	SHA256 string
+	// Owner is whoever may write the upload, such as a token subject.
+	Owner string
+	// Purpose and Target say what the finished body is for, such as
+	// "avatar" and a user ID. The package does not interpret them.
+	Purpose string
+	Target  string
+}
+
+// Status is an upload's progress: the next chunk goes at Offset.
+type Status struct {
+	ID      string    `json:"id"`
+	Size    int64     `json:"size"`
+	Offset  int64     `json:"offset"`
+	Purpose string    `json:"purpose"`
+	Target  string    `json:"target,omitempty"`
+	Owner   string    `json:"-"`
+	Chunks  []Chunk   `json:"chunks"`
+	Expires time.Time `json:"expires"`
+}
+
+// Chunk is one received piece.
+type Chunk struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manager is safe for concurrent use.
+type Manager struct {
+	opts    Options
+	clk     clock.Clock
+	sweeper *ttl.Sweeper[string]
+
+	mu      sync.Mutex
+	uploads map[string]*state
+}
+
+// state is changed only by the request holding it busy, under mu so
+// Status can read it meanwhile.
+type state struct {
+	spec    Spec
+	id      string
+	chunks  []Chunk
+	offset  int64
+	expires time.Time
+	// whole is the running SHA-256 of every byte so far, marshalled, so
+	// a chunk that fails its checksum can be taken back out of it.
+	whole []byte
+	busy  bool
+}
+
+func New(opts Options) *Manager {
+	if opts.TTL <= 0 {
+		opts.TTL = 24 * time.Hour
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 1 << 30
+	}
+	if opts.MaxChunk <= 0 {
+		opts.MaxChunk = 16 << 20
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	m := &Manager{opts: opts, clk: clock.OrReal(opts.Clock), uploads: make(map[string]*state)}
+	m.sweeper = ttl.New(m.clk, m.expire)
+	return m
+}
+
+// Run expires abandoned uploads until ctx is done.
+func (m *Manager) Run(ctx context.Context) { m.sweeper.Run(ctx) }
+
+// MaxChunk is the largest chunk Write accepts.
+func (m *Manager) MaxChunk() int64 { return m.opts.MaxChunk }
+
+// Init starts an upload.
+func (m *Manager) Init(spec Spec) (Status, error) {
+	if spec.Size <= 0 || spec.Size > m.opts.MaxSize {
+		return Status{}, i18n.Wrap(ErrTooLarge, "upload.too_large", i18n.Params{"max": strconv.FormatInt(m.opts.MaxSize>>20, 10) + " MiB"})
+	}
+	if spec.SHA256 != "" {
+		if b, err := hex.DecodeString(spec.SHA256); err != nil || len(b) != sha256.Size {
+			return Status{}, fmt.Errorf("upload: sha256 %q is not a hex SHA-256 digest", spec.SHA256)
+		}
+	}
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	whole, _ := sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	st := &state{spec: spec, id: hex.EncodeToString(b[:]), whole: whole, expires: m.clk.Now().Add(m.opts.TTL)}
+
+	m.mu.Lock()
+	m.uploads[st.id] = st
+	s := st.status()
+	m.mu.Unlock()
+	m.sweeper.Schedule(st.id, st.expires)
+	return s, nil
+}
+
+// Status returns the upload's progress.
+func (m *Manager) Status(id string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.uploads[id]
+	if !ok {
+		return Status{}, notFound()
+	}
+	return st.status(), nil
+}
+
+func (st *state) status() Status {
+	return Status{
+		ID: st.id, Size: st.spec.Size, Offset: st.offset,
+		Purpose: st.spec.Purpose, Target: st.spec.Target, Owner: st.spec.Owner,
+		Chunks: append([]Chunk{}, st.chunks...), Expires: st.expires,
+	}
+}
+
+func notFound() error { return i18n.Wrap(ErrNotFound, "upload.not_found", nil) }
+
+func chunkKey(id string, offset int64) string { return fmt.Sprintf("uploads/%s/%016d", id, offset) }
+
+// acquire marks the upload busy, so no two requests write it at once.
+func (m *Manager) acquire(id string) (*state, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.uploads[id]
+	if !ok {
+		return nil, notFound()
+	}
+	if st.busy {
+		return nil, ErrBusy
+	}
+	st.busy = true
+	return st, nil
+}
+
+func (m *Manager) release(st *state) {
+	m.mu.Lock()
+	st.busy = false
+	m.mu.Unlock()
+}
+
+// Write stores the chunk r at offset, whose hex SHA-256 is sum, and
+// returns the new status. offset must be where the last chunk ended; a
+// chunk resent at an offset already received, with the same sum, is
+// accepted again without being rewritten, so a client unsure whether a
+// chunk arrived can simply retry it. Receiving a chunk also pushes the
+// upload's expiry back by TTL.
+func (m *Manager) Write(ctx context.Context, id string, offset int64, r io.Reader, sum string) (Status, error) {
+	st, err := m.acquire(id)
+	if err != nil {
+		return Status{}, err
+	}
+	defer m.release(st)
+
+	for _, c := range st.chunks {
+		if c.Offset == offset && c.SHA256 == sum {
+			return m.touch(st), nil
+		}
+	}
+	if offset != st.offset {
+		return m.touch(st), i18n.Wrap(ErrOffset, "upload.offset", i18n.Params{"offset": st.offset})
+	}
+	if len(st.chunks) >= maxChunks {
+		return Status{}, fmt.Errorf("%w: more than %d chunks", ErrTooLarge, maxChunks)
+	}
+
+	whole := sha256.New()
+	if err := whole.(encoding.BinaryUnmarshaler).UnmarshalBinary(st.whole); err != nil {
+		return Status{}, err
+	}
+	chunk := sha256.New()
+	limit := min(m.opts.MaxChunk, st.spec.Size-offset)
+	counted := &countingReader{r: io.LimitReader(r, limit+1)}
+	key := chunkKey(id, offset)
+	if _, err := m.opts.Store.Put(ctx, key, io.TeeReader(counted, io.MultiWriter(chunk, whole)), "application/octet-stream"); err != nil {
+		return Status{}, err
+	}
+	if err := checkChunk(counted.n, limit, chunk, sum); err != nil {
+		m.deleteChunk(key)
+		return Status{}, err
+	}
+	m.mu.Lock()
+	st.whole, _ = whole.(encoding.BinaryMarshaler).MarshalBinary()
+	st.chunks = append(st.chunks, Chunk{Offset: offset, Size: counted.n, SHA256: sum})
+	st.offset += counted.n
+	m.mu.Unlock()
+	return m.touch(st), nil
+}
+
+func checkChunk(n, limit int64, h hash.Hash, sum string) error {
+	switch {
+	case n > limit:
+		return i18n.Wrap(ErrTooLarge, "upload.chunk_too_large", i18n.Params{"max": limit})
+	case n == 0:
+		return ErrEmpty
+	case hex.EncodeToString(h.Sum(nil)) != sum:
+		return i18n.Wrap(ErrChecksum, "upload.checksum", nil)
+	}
+	return nil
+}
+
+// touch pushes the expiry back and returns the status.
+func (m *Manager) touch(st *state) Status {
+	m.mu.Lock()
+	st.expires = m.clk.Now().Add(m.opts.TTL)
+	s := st.status()
+	m.mu.Unlock()
+	m.sweeper.Schedule(st.id, st.expires)
+	return s
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Assemble returns the whole body, read chunk by chunk from the store,
+// once every byte is in and the body's SHA-256, if declared, matches.
+// The upload stays busy until the reader is closed; Remove it then.
+func (m *Manager) Assemble(ctx context.Context, id string) (io.ReadCloser, Status, error) {
+	st, err := m.acquire(id)
+	if err != nil {
+		return nil, Status{}, err
+	}
+	m.mu.Lock()
+	s := st.status()
+	m.mu.Unlock()
+	if st.offset != st.spec.Size {
+		m.release(st)
+		return nil, s, i18n.Wrap(ErrIncomplete, "upload.incomplete", i18n.Params{"offset": st.offset, "size": st.spec.Size})
+	}
+	if st.spec.SHA256 != "" {
+		whole := sha256.New()
+		if err := whole.(encoding.BinaryUnmarshaler).UnmarshalBinary(st.whole); err != nil {
+			m.release(st)
+			return nil, s, err
+		}
+		if hex.EncodeToString(whole.Sum(nil)) != st.spec.SHA256 {
+			m.release(st)
+			return nil, s, i18n.Wrap(ErrChecksum, "upload.checksum", nil)
+		}
+	}
+	return &assembly{ctx: ctx, m: m, st: st, chunks: s.Chunks}, s, nil
+}
+
+// assembly reads the chunks in order, opening each only when the one
+// before it is used up.
+type assembly struct {
+	ctx    context.Context
+	m      *Manager
+	st     *state
+	chunks []Chunk
+	cur    io.ReadCloser
+	closed bool
+}
+
+func (a *assembly) Read(p []byte) (int, error) {
+	for {
+		if a.cur == nil {
+			if len(a.chunks) == 0 {
+				return 0, io.EOF
+			}
+			rc, _, err := a.m.opts.Store.Get(a.ctx, chunkKey(a.st.id, a.chunks[0].Offset))
+			if err != nil {
+				return 0, err
+			}
+			a.cur, a.chunks = rc, a.chunks[1:]
+		}
+		n, err := a.cur.Read(p)
+		if err == io.EOF {
+			a.cur.Close()
+			a.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (a *assembly) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	if a.cur != nil {
+		a.cur.Close()
+	}
+	a.m.release(a.st)
+	return nil
+}
+
+// Remove deletes the upload and its chunks: once it has been assembled
+// and used, or to abandon it. Chunks it fails to delete are logged as
+// well as returned; the upload is gone either way.
+func (m *Manager) Remove(ctx context.Context, id string) error {
+	st, err := m.acquire(id)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.uploads, id)
+	m.mu.Unlock()
+	m.sweeper.Cancel(id)
+	err = m.deleteChunks(ctx, st)
+	if err != nil {
+		m.opts.Logger.Warn("upload clean-up failed", "upload", id, "err", err)
+	}
+	return err
+}
+
+func (m *Manager) deleteChunks(ctx context.Context, st *state) error {
+	var errs []error
+	for _, c := range st.chunks {
+		if err := m.opts.Store.Delete(ctx, chunkKey(st.id, c.Offset)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) deleteChunk(key string) {
+	// A fresh context: the request's may be why the chunk failed.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := m.opts.Store.Delete(ctx, key); err != nil {
+		m.opts.Logger.Warn("upload chunk clean-up failed", "key", key, "err", err)
+	}
+}
+
+// expire is the sweeper's callback. An upload in use when its time
+// comes (a slow chunk, an assembly) gets another TTL.
+func (m *Manager) expire(id string, _ time.Time) {
+	m.mu.Lock()
+	st, ok := m.uploads[id]
+	if ok && st.busy {
+		m.mu.Unlock()
+		m.sweeper.Schedule(id, m.clk.Now().Add(m.opts.TTL))
+		return
+	}
+	delete(m.uploads, id)
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := m.deleteChunks(ctx, st); err != nil {
+		m.opts.Logger.Warn("abandoned upload clean-up failed", "upload", id, "err", err)
+		return
+	}
+	m.opts.Logger.Info("abandoned upload expired", "upload", id, "chunks", len(st.chunks), "bytes", st.offset)
+}
@@ -0,0 +1,241 @@
+package users
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"Go-Internals/query"
+)
+
+/*
+-----------------------------------
+COUNTS AND AGGREGATES
+-----------------------------------
+*/
+
+// Aggregates summarizes the users matching a spec.
+type Aggregates struct {
+	Count int `json:"count"`
+	// FirstCreated and LastCreated are the oldest and newest CreatedAt,
+	// zero if Count is.
+	FirstCreated time.Time `json:"first_created,omitzero"`
+	LastCreated  time.Time `json:"last_created,omitzero"`
+	// PerDay counts the users by the UTC day they were created, oldest
+	// first. Days without any are left out.
+	PerDay []DayCount `json:"per_day"`
+}
+
+// DayCount is how many users were created on a UTC day.
+type DayCount struct {
+	Day   string `json:"day"` // 2006-01-02
+	Count int    `json:"count"`
+}
+
+// dayLayout is DayCount.Day's.
+const dayLayout = time.DateOnly
+
+// add counts u, which must come no earlier than the users added before.
+func (a *Aggregates) add(u User) {
+	if a.Count == 0 {
+		a.FirstCreated = u.CreatedAt
+	}
+	a.Count++
+	a.LastCreated = u.CreatedAt
+	day := u.CreatedAt.UTC().Format(dayLayout)
+	if n := len(a.PerDay); n > 0 && a.PerDay[n-1].Day == day {
+		a.PerDay[n-1].Count++
+		return
+	}
+	a.PerDay = append(a.PerDay, DayCount{Day: day, Count: 1})
+}
+
+// Aggregator is implemented by backends that can count and summarize
+// users without handing each one over, as an SQL store would with COUNT
+// and GROUP BY, or from an index. Count and Aggregate iterate over the
+// others.
+type Aggregator interface {
+	Count(spec query.Spec) (int, error)
+	Aggregate(spec query.Spec) (Aggregates, error)
+}
+
+// Count is how many users of repo match spec.
+func Count(ctx context.Context, repo UserRepository, spec query.Spec) (int, error) {
+	if a, ok := repo.(Aggregator); ok {
+		return a.Count(spec)
+	}
+	n := 0
+	for _, err := range repo.Iterate(ctx, IterateOptions{Filter: spec}) {
+		if err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Aggregate summarizes the users of repo matching spec.
+func Aggregate(ctx context.Context, repo UserRepository, spec query.Spec) (Aggregates, error) {
+	if a, ok := repo.(Aggregator); ok {
+		return a.Aggregate(spec)
+	}
+	agg := Aggregates{PerDay: []DayCount{}}
+	for u, err := range repo.Iterate(ctx, IterateOptions{Filter: spec}) {
+		if err != nil {
+			return Aggregates{}, err
+		}
+		// Not every backend iterates oldest first.
+		if agg.Count > 0 && u.CreatedAt.Before(agg.LastCreated) {
+			return aggregateSorted(ctx, repo, spec)
+		}
+		agg.add(u)
+	}
+	return agg, nil
+}
+
+// aggregateSorted is Aggregate for a backend iterating in another order:
+// it has to hold the matching users to sort them.
+func aggregateSorted(ctx context.Context, repo UserRepository, spec query.Spec) (Aggregates, error) {
+	var list []User
+	for u, err := range repo.Iterate(ctx, IterateOptions{Filter: spec}) {
+		if err != nil {
+			return Aggregates{}, err
+		}
+		list = append(list, u)
+	}
+	SortByCreated(list)
+	agg := Aggregates{PerDay: []DayCount{}}
+	for _, u := range list {
+		agg.add(u)
+	}
+	return agg, nil
+}
+
+/*
+-----------------------------------
+IN-MEMORY AGGREGATES
+-----------------------------------
+*/
+
+var _ Aggregator = (*InMemoryUserRepo)(nil)
+
+// Count counts the users Search would return without copying them out:
+// every user for an empty And, otherwise those in the created_at range
+// spec bounds, or an index's candidates when one applies.
+func (r *InMemoryUserRepo) Count(spec query.Spec) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if and, ok := spec.(query.And); ok && len(and) == 0 {
+		return len(r.users), nil
+	}
+	n := 0
+	count := func(User) { n++ }
+	var err error
+	if lo, hi, ok := createdRange(spec); ok {
+		err = r.matchRangeLocked(spec, lo, hi, count)
+	} else {
+		err = r.matchLocked(spec, count)
+	}
+	return n, err
+}
+
+// Aggregate walks the created_at index, only over the range spec bounds
+// it to, so users come oldest first and each day's are together.
+func (r *InMemoryUserRepo) Aggregate(spec query.Spec) (Aggregates, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	agg := Aggregates{PerDay: []DayCount{}}
+	lo, hi, _ := createdRange(spec)
+	if err := r.matchRangeLocked(spec, lo, hi, agg.add); err != nil {
+		return Aggregates{}, err
+	}
+	return agg, nil
+}
+
+// createdRange is the [lo, hi) of created_at every user matching spec
+// lies in, from the created_at comparisons spec requires; false if there
+// are none, when it is every time.
+func createdRange(spec query.Spec) (lo, hi time.Time, ok bool) {
+	lo, hi = time.Time{}, time.Unix(math.MaxInt64/2, 0)
+	var narrow func(query.Spec)
+	narrow = func(spec query.Spec) {
+		switch s := spec.(type) {
+		case query.And:
+			for _, child := range s {
+				narrow(child)
+			}
+		case query.Cmp:
+			t, isTime := s.Value.(time.Time)
+			if s.Field != query.FieldCreatedAt || !isTime {
+				return
+			}
+			// Match trims the ends Gt and Lt leave out.
+			from, to := lo, hi
+			switch s.Op {
+			case query.OpGt, query.OpGe:
+				from = t
+			case query.OpLt, query.OpLe:
+				to = t.Add(1)
+			case query.OpEq:
+				from, to = t, t.Add(1)
+			default:
+				return
+			}
+			if from.After(lo) {
+				lo = from
+			}
+			if to.Before(hi) {
+				hi = to
+			}
+			ok = true
+		}
+	}
+	narrow(spec)
+	return lo, hi, ok
+}
+
+// matchRangeLocked calls f with each user created in [lo, hi) matching
+// spec, oldest first.
+func (r *InMemoryUserRepo) matchRangeLocked(spec query.Spec, lo, hi time.Time, f func(User)) error {
+	if !lo.Before(hi) {
+		return nil
+	}
+	for _, id := range r.byCreated.Range(lo, hi) {
+		u := r.users[id]
+		ok, err := query.Match(spec, Getter(u))
+		if err != nil {
+			return err
+		}
+		if ok {
+			f(u)
+		}
+	}
+	return nil
+}
+
+// matchLocked calls f with each user matching spec, in no set order.
+func (r *InMemoryUserRepo) matchLocked(spec query.Spec, f func(User)) error {
+	match := func(u User) error {
+		ok, err := query.Match(spec, Getter(u))
+		if ok {
+			f(u)
+		}
+		return err
+	}
+	if ids, ok := r.planLocked(spec); ok {
+		for _, id := range ids {
+			if err := match(r.users[id]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, u := range r.users {
+		if err := match(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"Go-Internals/dryrun"
+	"Go-Internals/kv"
+	"Go-Internals/users"
+	"Go-Internals/users/kvstore"
+	"Go-Internals/users/mmapstore"
+)
+
+// backends opens each store on disk or in memory, skipping those this
+// platform lacks.
+func backends(t *testing.T) map[string]users.UserRepository {
+	t.Helper()
+	out := map[string]users.UserRepository{"memory": users.NewInMemoryUserRepo()}
+	kvs, err := kvstore.Open(t.TempDir(), kv.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { kvs.Close() })
+	out["kv"] = kvs
+	switch mm, err := mmapstore.Open(filepath.Join(t.TempDir(), "users.db"), 16); {
+	case errors.Is(err, mmapstore.ErrUnsupported):
+	case err != nil:
+		t.Fatal(err)
+	default:
+		t.Cleanup(func() { mm.Close() })
+		out["mmap"] = mm
+	}
+	return out
+}
+
+// A dry run answers as the write would, on every backend: a taken email
+// is refused by both, and a free one accepted by both.
+func TestEmailsUniqueOnEveryBackend(t *testing.T) {
+	for name, repo := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			svc := users.NewUserService(repo)
+			ctx := context.Background()
+			dry, _ := dryrun.With(ctx)
+			if _, err := svc.RegisterUser(ctx, "Ada", "ada@example.com"); err != nil {
+				t.Fatal(err)
+			}
+			bo, err := svc.RegisterUser(ctx, "Bo", "bo@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, c := range []struct {
+				what string
+				run  func(context.Context) error
+				want error
+			}{
+				{"register a taken email", func(ctx context.Context) error {
+					_, err := svc.RegisterUser(ctx, "Ada again", "ada@example.com")
+					return err
+				}, users.ErrEmailTaken},
+				{"update to a taken email", func(ctx context.Context) error {
+					_, err := svc.UpdateUser(ctx, bo.ID, "", "ada@example.com")
+					return err
+				}, users.ErrEmailTaken},
+				{"update to a free email", func(ctx context.Context) error {
+					_, err := svc.UpdateUser(ctx, bo.ID, "", "bo2@example.com")
+					return err
+				}, nil},
+				// Bo's old email is free once Bo has moved off it.
+				{"register the freed email", func(ctx context.Context) error {
+					_, err := svc.RegisterUser(ctx, "Cy", "bo@example.com")
+					return err
+				}, nil},
+			} {
+				if err := c.run(dry); !errors.Is(err, c.want) {
+					t.Fatalf("dry run: %s = %v, want %v", c.what, err, c.want)
+				}
+				if err := c.run(ctx); !errors.Is(err, c.want) {
+					t.Fatalf("%s = %v, want %v", c.what, err, c.want)
+				}
+			}
+
+			// Deleting a user frees the email too.
+			if err := svc.DeleteUser(ctx, bo.ID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := svc.RegisterUser(ctx, "Bo again", "bo2@example.com"); err != nil {
+				t.Fatalf("register a deleted user's email = %v", err)
+			}
+		})
+	}
+}
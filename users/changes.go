@@ -0,0 +1,237 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"sync"
+	"time"
+)
+
+/*
+-----------------------------------
+CHANGE DATA CAPTURE
+-----------------------------------
+*/
+
+// ErrChangesTruncated means the requested sequence has already been dropped
+// from the log; the consumer must resync from a full List/Iterate.
+var ErrChangesTruncated = errors.New("changes before the requested sequence were discarded")
+
+// ChangeOp is the kind of mutation.
+type ChangeOp string
+
+const (
+	OpCreate ChangeOp = "create"
+	OpUpdate ChangeOp = "update"
+	OpDelete ChangeOp = "delete"
+	OpExpire ChangeOp = "expire" // removed by the TTL sweeper, not a caller
+)
+
+// Change is one committed mutation. Before is nil for creates, After is nil
+// for deletes and expiries.
+type Change struct {
+	Seq    uint64    `json:"seq"`
+	Op     ChangeOp  `json:"op"`
+	At     time.Time `json:"at"`
+	Before *User     `json:"before,omitempty"`
+	After  *User     `json:"after,omitempty"`
+}
+
+// ChangeFeed is implemented by backends that can stream their mutations.
+type ChangeFeed interface {
+	// Changes yields every change with Seq >= fromSeq in order, then keeps
+	// waiting for new ones until ctx is done (it is a tail, not a snapshot).
+	Changes(ctx context.Context, fromSeq uint64) iter.Seq2[Change, error]
+	// LastSeq is the sequence of the newest change (0 if none yet).
+	LastSeq() uint64
+}
+
+var _ ChangeFeed = (*InMemoryUserRepo)(nil)
+
+// ModTimes is implemented by backends that know when records were last
+// written, which is what a Last-Modified header needs.
+type ModTimes interface {
+	// ModifiedAt is when user id was created or last updated; false if
+	// there is no such user.
+	ModifiedAt(id int) (time.Time, bool)
+	// LastModified is when any user was last created, updated, deleted
+	// or expired; zero before the first write.
+	LastModified() time.Time
+}
+
+var _ ModTimes = (*InMemoryUserRepo)(nil)
+
+// DefaultChangeRetention is how many changes the in-memory log keeps.
+const DefaultChangeRetention = 10_000
+
+// changeLog is an append-only, bounded sequence log. Appends happen under
+// the repository lock (so sequence order is commit order); readers only
+// take the log's own lock.
+type changeLog struct {
+	mu      sync.Mutex
+	entries []Change // entries[i].Seq == first+i
+	first   uint64
+	next    uint64
+	max     int
+	notify  chan struct{} // closed and replaced on every append
+
+	// Kept apart from entries, which are trimmed: a user written once
+	// long ago still has a modification time.
+	modified map[int]time.Time
+	lastAt   time.Time
+}
+
+func newChangeLog(max int) *changeLog {
+	return &changeLog{first: 1, next: 1, max: max, notify: make(chan struct{}), modified: make(map[int]time.Time)}
+}
+
+func (l *changeLog) append(op ChangeOp, before, after *User) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := Change{
+		Seq:    l.next,
+		Op:     op,
+		At:     time.Now(),
+		Before: before,
+		After:  after,
+	}
+	l.entries = append(l.entries, c)
+	l.next++
+	if after != nil {
+		l.modified[after.ID] = c.At
+	} else {
+		delete(l.modified, before.ID)
+	}
+	l.lastAt = c.At
+
+	// Trim in chunks so we don't copy on every append once full.
+	if over := len(l.entries) - l.max; over > l.max/4 {
+		l.entries = append([]Change(nil), l.entries[over:]...)
+		l.first += uint64(over)
+	}
+
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// read returns changes from seq (inclusive), plus a channel that is closed
+// when something newer is appended.
+func (l *changeLog) read(seq uint64, limit int) ([]Change, <-chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if seq < l.first {
+		if seq == 0 && l.first == 1 {
+			seq = 1
+		} else {
+			return nil, nil, ErrChangesTruncated
+		}
+	}
+	start := int(seq - l.first)
+	if start >= len(l.entries) {
+		return nil, l.notify, nil
+	}
+	end := min(start+limit, len(l.entries))
+	return append([]Change(nil), l.entries[start:end]...), l.notify, nil
+}
+
+func (l *changeLog) last() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.next - 1
+}
+
+func (r *InMemoryUserRepo) Changes(ctx context.Context, fromSeq uint64) iter.Seq2[Change, error] {
+	return func(yield func(Change, error) bool) {
+		seq := fromSeq
+		for {
+			batch, notify, err := r.changes.read(seq, 256)
+			if err != nil {
+				yield(Change{}, err)
+				return
+			}
+
+			for _, c := range batch {
+				if !yield(c, nil) {
+					return
+				}
+				seq = c.Seq + 1
+			}
+			if len(batch) > 0 {
+				continue
+			}
+
+			select {
+			case <-notify:
+			case <-ctx.Done():
+				yield(Change{}, ctx.Err())
+				return
+			}
+		}
+	}
+}
+
+func (r *InMemoryUserRepo) LastSeq() uint64 { return r.changes.last() }
+
+func (r *InMemoryUserRepo) ModifiedAt(id int) (time.Time, bool) {
+	r.changes.mu.Lock()
+	defer r.changes.mu.Unlock()
+	t, ok := r.changes.modified[id]
+	return t, ok
+}
+
+func (r *InMemoryUserRepo) LastModified() time.Time {
+	r.changes.mu.Lock()
+	defer r.changes.mu.Unlock()
+	return r.changes.lastAt
+}
+
+// ChangesFor returns the retained changes to user id, oldest first.
+func (r *InMemoryUserRepo) ChangesFor(id int) []Change {
+	r.changes.mu.Lock()
+	defer r.changes.mu.Unlock()
+
+	var out []Change
+	for _, c := range r.changes.entries {
+		if c.userID() == id {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// RedactChanges strips user id's data from the retained changes, leaving
+// only the ID, and returns how many changes it touched. Sequence numbers
+// and ops stay, so consumers tailing the feed see no gap.
+func (r *InMemoryUserRepo) RedactChanges(id int) int {
+	r.changes.mu.Lock()
+	defer r.changes.mu.Unlock()
+
+	n := 0
+	for i := range r.changes.entries {
+		c := &r.changes.entries[i]
+		if c.userID() != id {
+			continue
+		}
+		if c.Before != nil {
+			c.Before = &User{ID: id}
+		}
+		if c.After != nil {
+			c.After = &User{ID: id}
+		}
+		n++
+	}
+	return n
+}
+
+func (c Change) userID() int {
+	if c.After != nil {
+		return c.After.ID
+	}
+	if c.Before != nil {
+		return c.Before.ID
+	}
+	return 0
+}
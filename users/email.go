@@ -0,0 +1,49 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"Go-Internals/emailaddr"
+	"Go-Internals/i18n"
+)
+
+/*
+-----------------------------------
+EMAIL ADDRESSES
+-----------------------------------
+*/
+
+// checkEmail parses a normalized email and returns it as the service
+// stores it: an internationalized domain in Unicode, so one given in
+// Punycode is the same address. A refusal is ErrInvalidInput with the
+// reason.
+func checkEmail(email string) (string, error) {
+	a, err := emailaddr.Parse(email)
+	var invalid *emailaddr.Error
+	if errors.As(err, &invalid) {
+		return "", i18n.Wrap(rejection(invalid.Reason), "user.email_invalid", i18n.Params{"email": email, "reason": invalid.Reason})
+	}
+	if err != nil {
+		return "", err
+	}
+	return a.String(), nil
+}
+
+// RequireMailServer is a Validator that refuses an email whose domain,
+// by c, takes no mail. A lookup that cannot tell, because DNS timed out
+// or failed, lets the address through: a slow resolver should not stop
+// registrations. It looks up on every update too, email changed or not.
+func RequireMailServer(c *emailaddr.Checker) Validator {
+	return func(ctx context.Context, u User) error {
+		a, err := emailaddr.Parse(u.Email)
+		if err == nil {
+			err = c.Check(ctx, a)
+		}
+		if errors.Is(err, emailaddr.ErrInvalid) || errors.Is(err, emailaddr.ErrNoMailServer) {
+			return Reject(strings.TrimPrefix(err.Error(), "emailaddr: "))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,271 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	"Go-Internals/fieldcrypt"
+	"Go-Internals/normalize"
+	"Go-Internals/query"
+)
+
+/*
+-----------------------------------
+FIELD-LEVEL ENCRYPTION
+-----------------------------------
+*/
+
+// EncryptedRepo seals the User fields tagged `encrypt:"..."` before they
+// reach the wrapped repository and opens them on the way back, so the
+// backend only ever holds ciphertext for them.
+//
+// Email is deterministic, which keeps the backends' uniqueness check
+// working on ciphertext. Backends compare emails as normalize.EmailKey
+// has them but ciphertexts can only be compared exactly, so emails are
+// stored in that form. Search pushes equality on email down to the backend (as
+// ciphertext) and otherwise filters decrypted records here, since the
+// backend cannot evaluate anything else on a sealed field.
+//
+// With a rotating codec an email is sealed differently under each key
+// version, so until Reencrypt has brought every record to the current
+// one, lookups look for each version's ciphertext, and writes check the
+// retired versions' for a taken email, which the backend cannot see.
+//
+// Sealed values are longer than their plaintext (fieldcrypt.SealedLen):
+// with the mmap backend's fixed slots a name is limited to 16 bytes. As
+// with InstrumentedRepo, optional backend interfaces are not forwarded.
+type EncryptedRepo struct {
+	UserRepository
+	codec *fieldcrypt.Codec
+}
+
+func EncryptFields(repo UserRepository, c *fieldcrypt.Codec) *EncryptedRepo {
+	return &EncryptedRepo{UserRepository: repo, codec: c}
+}
+
+// Seal returns u as the backend stores it, for writers that bypass the
+// repository (a Restorer on the backend, say).
+func (r *EncryptedRepo) Seal(u User) (User, error) { return r.seal(u) }
+
+func (r *EncryptedRepo) seal(u User) (User, error) {
+	u.Email = normalize.EmailKey(u.Email)
+	err := r.codec.Encrypt(&u)
+	return u, err
+}
+
+func (r *EncryptedRepo) open(u User, err error) (User, error) {
+	if err != nil {
+		return User{}, err
+	}
+	if err := r.codec.Decrypt(&u); err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+func (r *EncryptedRepo) Create(u User) (User, error) {
+	sealed, err := r.seal(u)
+	if err != nil {
+		return User{}, err
+	}
+	if err := r.checkRetired(u); err != nil {
+		return User{}, err
+	}
+	return r.open(r.UserRepository.Create(sealed))
+}
+
+func (r *EncryptedRepo) GetByID(id int) (User, error) {
+	return r.open(r.UserRepository.GetByID(id))
+}
+
+// List drops records that do not decrypt, as List cannot report errors;
+// Iterate reports them.
+func (r *EncryptedRepo) List() []User {
+	all := r.UserRepository.List()
+	out := all[:0]
+	for _, u := range all {
+		if u, err := r.open(u, nil); err == nil {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+func (r *EncryptedRepo) Update(u User) (User, error) {
+	sealed, err := r.seal(u)
+	if err != nil {
+		return User{}, err
+	}
+	if err := r.checkRetired(u); err != nil {
+		return User{}, err
+	}
+	return r.open(r.UserRepository.Update(sealed))
+}
+
+func (r *EncryptedRepo) Search(spec query.Spec) ([]User, error) {
+	if pushed, ok := r.rewrite(spec); ok {
+		found, err := r.UserRepository.Search(pushed)
+		if err != nil {
+			return nil, err
+		}
+		out := found[:0]
+		for _, u := range found {
+			u, err := r.open(u, nil)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, u)
+		}
+		return out, nil
+	}
+	result, err := Filter(r.List(), spec)
+	if err != nil {
+		return nil, err
+	}
+	SortByCreated(result)
+	return result, nil
+}
+
+// Iterate filters after decryption; the backend sees no filter.
+func (r *EncryptedRepo) Iterate(ctx context.Context, opts IterateOptions) iter.Seq2[User, error] {
+	filter := opts.Filter
+	opts.Filter = nil
+	return func(yield func(User, error) bool) {
+		for u, err := range r.UserRepository.Iterate(ctx, opts) {
+			if err == nil {
+				u, err = r.open(u, nil)
+			}
+			if err != nil {
+				yield(User{}, err)
+				return
+			}
+			if ok, err := query.Match(filter, Getter(u)); err != nil || !ok {
+				if err != nil {
+					yield(User{}, err)
+					return
+				}
+				continue
+			}
+			if !yield(u, nil) {
+				return
+			}
+		}
+	}
+}
+
+// sealedFields maps query fields to the User fields that back them.
+var sealedFields = map[string]string{query.FieldName: "Name", query.FieldEmail: "Email"}
+
+// rewrite translates spec into one the backend can evaluate on sealed
+// records: equality on deterministic fields becomes equality on the
+// ciphertext. It fails if spec touches a sealed field any other way.
+func (r *EncryptedRepo) rewrite(spec query.Spec) (query.Spec, bool) {
+	switch s := spec.(type) {
+	case nil:
+		return nil, true
+	case query.And:
+		return r.rewriteAll(s, func(c []query.Spec) query.Spec { return query.And(c) })
+	case query.Or:
+		return r.rewriteAll(s, func(c []query.Spec) query.Spec { return query.Or(c) })
+	case query.Not:
+		child, ok := r.rewrite(s.Spec)
+		return query.Not{Spec: child}, ok
+	case query.Cmp:
+		name, sealed := sealedFields[s.Field]
+		if !sealed {
+			return s, true
+		}
+		v, isStr := s.Value.(string)
+		if fieldcrypt.ModeOf[User](name) != fieldcrypt.Deterministic || !isStr || (s.Op != query.OpEq && s.Op != query.OpNe) {
+			return nil, false
+		}
+		if s.Field == query.FieldEmail {
+			v = normalize.EmailKey(v)
+		}
+		values := r.codec.SealEach(name, v, fieldcrypt.Deterministic)
+		if len(values) == 1 {
+			s.Value = values[0]
+			return s, true
+		}
+		// Equal to any version's ciphertext, or unequal to all of them.
+		each := make([]query.Spec, len(values))
+		for i, c := range values {
+			each[i] = query.Cmp{Field: s.Field, Op: s.Op, Value: c}
+		}
+		if s.Op == query.OpEq {
+			return query.Or(each), true
+		}
+		return query.And(each), true
+	default:
+		return nil, false
+	}
+}
+
+func (r *EncryptedRepo) rewriteAll(specs []query.Spec, build func([]query.Spec) query.Spec) (query.Spec, bool) {
+	out := make([]query.Spec, len(specs))
+	for i, child := range specs {
+		var ok bool
+		if out[i], ok = r.rewrite(child); !ok {
+			return nil, false
+		}
+	}
+	return build(out), true
+}
+
+// checkRetired is ErrEmailTaken if another user's email is u's sealed
+// with a retired key version.
+func (r *EncryptedRepo) checkRetired(u User) error {
+	if fieldcrypt.ModeOf[User]("Email") != fieldcrypt.Deterministic {
+		return nil
+	}
+	sealed := r.codec.SealEach("Email", normalize.EmailKey(u.Email), fieldcrypt.Deterministic)
+	for _, v := range sealed[1:] {
+		found, err := r.UserRepository.Search(query.Cmp{Field: query.FieldEmail, Op: query.OpEq, Value: v})
+		if err != nil {
+			return err
+		}
+		for _, f := range found {
+			if f.ID != u.ID {
+				return ErrEmailTaken
+			}
+		}
+	}
+	return nil
+}
+
+/*
+-----------------------------------
+RE-ENCRYPTION
+-----------------------------------
+*/
+
+// Reencrypt seals anew, with the codec's current key version, every
+// record with a field sealed with an older one, and returns how many it
+// rewrote. Once it has run, the older versions can be dropped. Writes
+// go straight to the backend: what the users' records say is unchanged,
+// so the service has nothing to be told.
+func (r *EncryptedRepo) Reencrypt(ctx context.Context) (int, error) {
+	n := 0
+	for u, err := range r.UserRepository.Iterate(ctx, IterateOptions{}) {
+		if err != nil {
+			return n, err
+		}
+		if !r.codec.Stale(u.Name) && !r.codec.Stale(u.Email) {
+			continue
+		}
+		plain, err := r.open(u, nil)
+		if err != nil {
+			return n, fmt.Errorf("users: re-encrypting user %d: %w", u.ID, err)
+		}
+		sealed, err := r.seal(plain)
+		if err != nil {
+			return n, err
+		}
+		if _, err := r.UserRepository.Update(sealed); err != nil {
+			return n, fmt.Errorf("users: re-encrypting user %d: %w", u.ID, err)
+		}
+		n++
+	}
+	return n, nil
+}
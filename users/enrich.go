@@ -0,0 +1,147 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"Go-Internals/breadcrumb"
+	"Go-Internals/clock"
+	"Go-Internals/normalize"
+)
+
+/*
+-----------------------------------
+DERIVED FIELDS
+-----------------------------------
+*/
+
+// Enricher computes a field derived from a user as it is read: nothing
+// of it is stored, so it is always in step with the record and the
+// clock. Compute runs on every read of every user and must be quick;
+// returning nil leaves the field out.
+type Enricher struct {
+	// Field is the name under "derived".
+	Field   string
+	Compute func(ctx context.Context, u User) any
+}
+
+// Enriched is a user as read: the stored record and, under "derived",
+// what the enrichers made of it. Without enrichers it encodes as the
+// User does.
+type Enriched struct {
+	User
+	Derived map[string]any `json:"derived,omitempty"`
+}
+
+// WithEnricher adds a derived field to every read the service answers:
+// Enrich, and the export. Enrichers run in the order they were added; a
+// later one for the same field replaces the earlier.
+func WithEnricher(e Enricher) ServiceOption {
+	return func(s *UserService) { s.enrichers = append(s.enrichers, e) }
+}
+
+// Enrich derives the enrichers' fields for u. Every transport goes
+// through it (REST, GraphQL and the export), so a field derived for one
+// is derived for all.
+func (s *UserService) Enrich(ctx context.Context, u User) Enriched {
+	e := Enriched{User: u}
+	if len(s.enrichers) == 0 {
+		return e
+	}
+	for _, en := range s.enrichers {
+		if v := en.Compute(ctx, u); v != nil {
+			if e.Derived == nil {
+				e.Derived = make(map[string]any, len(s.enrichers))
+			}
+			e.Derived[en.Field] = v
+		} else {
+			delete(e.Derived, en.Field)
+		}
+	}
+	return e
+}
+
+// EnrichAll is Enrich over a list.
+func (s *UserService) EnrichAll(ctx context.Context, list []User) []Enriched {
+	if len(s.enrichers) > 0 {
+		defer breadcrumb.Stage(ctx, "enrich")()
+	}
+	out := make([]Enriched, len(list))
+	for i, u := range list {
+		out[i] = s.Enrich(ctx, u)
+	}
+	return out
+}
+
+// Version names the state of u as read, derived fields included,
+// changing with any of them. It is the ETag GET /users/{id} answers
+// with (a hash of the same JSON), so a client sends back as If-Match
+// the tag it read.
+func (s *UserService) Version(ctx context.Context, u User) string {
+	return hashJSON(s.Enrich(ctx, u))
+}
+
+func hashJSON(v any) string {
+	var b bytes.Buffer
+	_ = json.NewEncoder(&b).Encode(v)
+	h := fnv.New64a()
+	h.Write(b.Bytes())
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+/*
+-----------------------------------
+ENRICHERS
+-----------------------------------
+*/
+
+// AccountAge derives account_age_days: whole days since the user was
+// created, by clk.
+func AccountAge(clk clock.Clock) Enricher {
+	return Enricher{Field: "account_age_days", Compute: func(_ context.Context, u User) any {
+		if u.CreatedAt.IsZero() {
+			return nil
+		}
+		return int(max(clk.Since(u.CreatedAt), 0).Hours() / 24)
+	}}
+}
+
+// Gravatar derives gravatar_url, the user's Gravatar at size pixels
+// square, an identicon for an email with none. The hash is of the
+// trimmed, lowercased address, as Gravatar asks.
+func Gravatar(size int) Enricher {
+	return Enricher{Field: "gravatar_url", Compute: func(_ context.Context, u User) any {
+		if u.Email == "" {
+			return nil
+		}
+		sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(u.Email))))
+		q := url.Values{"d": {"identicon"}, "s": {strconv.Itoa(size)}}
+		return "https://www.gravatar.com/avatar/" + hex.EncodeToString(sum[:]) + "?" + q.Encode()
+	}}
+}
+
+// DisplayName derives display_name: the name as normalize.Title has it,
+// whitespace collapsed and, if it was typed all in one case, each word
+// capitalized: "ada  lovelace" becomes "Ada Lovelace", but "ada  LOVELACE"
+// is taken to be meant and only loses the extra space. A user with no
+// name is shown by their email's local part.
+func DisplayName() Enricher {
+	return Enricher{Field: "display_name", Compute: func(_ context.Context, u User) any {
+		if name := normalize.Title(u.Name); name != "" {
+			return name
+		}
+		local, _, _ := strings.Cut(u.Email, "@")
+		if local == "" {
+			return nil
+		}
+		return local
+	}}
+}
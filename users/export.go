@@ -0,0 +1,33 @@
+package users
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// ExportJSONLines streams every user matching opts to w, one JSON object per
+// line, using Iterate so memory stays flat regardless of store size.
+// It returns how many users were written.
+func ExportJSONLines(ctx context.Context, repo UserRepository, w io.Writer, opts IterateOptions) (int, error) {
+	return exportJSONLines(ctx, repo, w, opts, func(u User) any { return u })
+}
+
+// exportJSONLines writes view(u) for each user u.
+func exportJSONLines(ctx context.Context, repo UserRepository, w io.Writer, opts IterateOptions, view func(User) any) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	n := 0
+	for u, err := range repo.Iterate(ctx, opts) {
+		if err != nil {
+			return n, err
+		}
+		if err := enc.Encode(view(u)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, bw.Flush()
+}
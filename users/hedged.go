@@ -0,0 +1,44 @@
+package users
+
+import (
+	"context"
+	"errors"
+
+	"Go-Internals/hedge"
+)
+
+/*
+-----------------------------------
+HEDGED READS
+-----------------------------------
+*/
+
+// HedgedRepo serves GetByID from the primary and, if it is slow, races a
+// replica against it (see package hedge). Writes and every other read go
+// to the primary only.
+//
+// UserRepository methods take no context, so a losing call can't be
+// interrupted: it runs to completion in its goroutine and its result is
+// discarded.
+type HedgedRepo struct {
+	UserRepository
+	replicas []UserRepository
+	opts     hedge.Options
+}
+
+// Hedge wraps primary; replicas are tried in order as backups. Not found is
+// treated as a final answer rather than a reason to ask the next replica.
+func Hedge(primary UserRepository, opts hedge.Options, replicas ...UserRepository) *HedgedRepo {
+	if opts.Retryable == nil {
+		opts.Retryable = func(err error) bool { return !errors.Is(err, ErrUserNotFound) }
+	}
+	return &HedgedRepo{UserRepository: primary, replicas: replicas, opts: opts}
+}
+
+func (r *HedgedRepo) GetByID(id int) (User, error) {
+	attempts := make([]func(context.Context) (User, error), 0, 1+len(r.replicas))
+	for _, repo := range append([]UserRepository{r.UserRepository}, r.replicas...) {
+		attempts = append(attempts, func(context.Context) (User, error) { return repo.GetByID(id) })
+	}
+	return hedge.Do(context.Background(), r.opts, attempts...)
+}
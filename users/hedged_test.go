@@ -0,0 +1,57 @@
+package users
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"Go-Internals/hedge"
+)
+
+// replicaRepo answers GetByID with err if set, and counts the calls.
+type replicaRepo struct {
+	UserRepository
+	err   error
+	calls atomic.Int32
+}
+
+func (r *replicaRepo) GetByID(id int) (User, error) {
+	r.calls.Add(1)
+	if r.err != nil {
+		return User{}, r.err
+	}
+	return r.UserRepository.GetByID(id)
+}
+
+func hedgedFixture(t *testing.T, primaryErr error) (*HedgedRepo, *replicaRepo) {
+	t.Helper()
+	base := NewInMemoryUserRepo()
+	if _, err := base.Create(User{Name: "Ada", Email: "ada@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	replica := &replicaRepo{UserRepository: base}
+	return Hedge(&replicaRepo{UserRepository: base, err: primaryErr}, hedge.Options{Delay: time.Hour}, replica), replica
+}
+
+func TestHedgedFailover(t *testing.T) {
+	repo, replica := hedgedFixture(t, errors.New("primary down"))
+	u, err := repo.GetByID(1)
+	if err != nil || u.Name != "Ada" {
+		t.Fatalf("GetByID = %+v, %v", u, err)
+	}
+	if replica.calls.Load() != 1 {
+		t.Fatalf("replica asked %d times, want 1", replica.calls.Load())
+	}
+}
+
+// Not found is an answer: asking a replica would only make it slower.
+func TestHedgedNotFoundIsFinal(t *testing.T) {
+	repo, replica := hedgedFixture(t, nil)
+	if _, err := repo.GetByID(2); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("GetByID(2) = %v, want ErrUserNotFound", err)
+	}
+	if replica.calls.Load() != 0 {
+		t.Fatalf("replica asked %d times, want 0", replica.calls.Load())
+	}
+}
@@ -0,0 +1,92 @@
+package users
+
+import (
+	"context"
+	"iter"
+	"time"
+
+	"Go-Internals/histogram"
+	"Go-Internals/query"
+)
+
+/*
+-----------------------------------
+INSTRUMENTED REPOSITORY
+-----------------------------------
+*/
+
+// InstrumentedRepo wraps a UserRepository and records the latency of every
+// call in a per-method histogram. Errors are timed too: a slow failure is
+// still slow. Optional backend interfaces (ChangeFeed, ...) are not
+// forwarded; use the wrapped repository for those.
+type InstrumentedRepo struct {
+	UserRepository
+	lat map[string]*histogram.Histogram
+}
+
+// Repository method names, as keys of Latencies.
+const (
+	MethodCreate  = "create"
+	MethodGetByID = "get_by_id"
+	MethodList    = "list"
+	MethodUpdate  = "update"
+	MethodDelete  = "delete"
+	MethodSearch  = "search"
+	MethodIterate = "iterate"
+)
+
+func Instrument(repo UserRepository) *InstrumentedRepo {
+	r := &InstrumentedRepo{UserRepository: repo, lat: make(map[string]*histogram.Histogram)}
+	for _, m := range []string{MethodCreate, MethodGetByID, MethodList, MethodUpdate, MethodDelete, MethodSearch, MethodIterate} {
+		r.lat[m] = histogram.New()
+	}
+	return r
+}
+
+// Latencies returns the live histograms (nanoseconds) keyed by method. The
+// map itself is never modified after Instrument.
+func (r *InstrumentedRepo) Latencies() map[string]*histogram.Histogram { return r.lat }
+
+func (r *InstrumentedRepo) since(method string, start time.Time) {
+	r.lat[method].RecordDuration(time.Since(start))
+}
+
+func (r *InstrumentedRepo) Create(u User) (User, error) {
+	defer r.since(MethodCreate, time.Now())
+	return r.UserRepository.Create(u)
+}
+
+func (r *InstrumentedRepo) GetByID(id int) (User, error) {
+	defer r.since(MethodGetByID, time.Now())
+	return r.UserRepository.GetByID(id)
+}
+
+func (r *InstrumentedRepo) List() []User {
+	defer r.since(MethodList, time.Now())
+	return r.UserRepository.List()
+}
+
+func (r *InstrumentedRepo) Update(u User) (User, error) {
+	defer r.since(MethodUpdate, time.Now())
+	return r.UserRepository.Update(u)
+}
+
+func (r *InstrumentedRepo) Delete(id int) error {
+	defer r.since(MethodDelete, time.Now())
+	return r.UserRepository.Delete(id)
+}
+
+func (r *InstrumentedRepo) Search(spec query.Spec) ([]User, error) {
+	defer r.since(MethodSearch, time.Now())
+	return r.UserRepository.Search(spec)
+}
+
+// Iterate times the whole iteration, from first pull to the consumer
+// stopping, so it includes time spent in the caller's loop body.
+func (r *InstrumentedRepo) Iterate(ctx context.Context, opts IterateOptions) iter.Seq2[User, error] {
+	seq := r.UserRepository.Iterate(ctx, opts)
+	return func(yield func(User, error) bool) {
+		defer r.since(MethodIterate, time.Now())
+		seq(yield)
+	}
+}
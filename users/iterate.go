@@ -0,0 +1,96 @@
+package users
+
+import (
+	"context"
+	"iter"
+	"math"
+	"time"
+
+	"Go-Internals/query"
+)
+
+// IterateOptions tunes Iterate.
+type IterateOptions struct {
+	// Filter limits the stream to matching users. nil means all.
+	Filter query.Spec
+	// BatchSize is how many users are copied out per lock acquisition.
+	// The lock is never held while the caller's loop body runs. Default 256.
+	BatchSize int
+}
+
+func (o IterateOptions) batchSize() int {
+	if o.BatchSize <= 0 {
+		return 256
+	}
+	return o.BatchSize
+}
+
+// Iterate streams users oldest first without materializing the whole set:
+//
+//	for u, err := range repo.Iterate(ctx, users.IterateOptions{}) {
+//		if err != nil { ... }
+//	}
+//
+// It walks the created_at index in batches, resuming each batch from a
+// (CreatedAt, ID) cursor. Users created or deleted during iteration may or
+// may not be seen, but no user is seen twice. A cancelled ctx ends the
+// stream with ctx.Err().
+func (r *InMemoryUserRepo) Iterate(ctx context.Context, opts IterateOptions) iter.Seq2[User, error] {
+	return func(yield func(User, error) bool) {
+		size := opts.batchSize()
+		cursorAt, cursorID := time.Time{}, math.MinInt
+		batch := make([]User, 0, size)
+
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(User{}, err)
+				return
+			}
+
+			batch = batch[:0]
+			r.mu.Lock()
+			r.byCreated.AscendAfter(cursorAt, cursorID, func(at time.Time, id int) bool {
+				batch = append(batch, r.users[id])
+				cursorAt, cursorID = at, id
+				return len(batch) < size
+			})
+			r.mu.Unlock()
+
+			if len(batch) == 0 {
+				return
+			}
+			if !YieldBatch(ctx, batch, opts.Filter, yield) {
+				return
+			}
+			if len(batch) < size {
+				return
+			}
+		}
+	}
+}
+
+// YieldBatch hands one batch to an Iterate consumer, applying the filter.
+// It returns false if the stream is over (consumer stopped, error or
+// cancellation). Backends share it so Iterate behaves the same everywhere.
+func YieldBatch(ctx context.Context, batch []User, filter query.Spec, yield func(User, error) bool) bool {
+	for _, u := range batch {
+		if err := ctx.Err(); err != nil {
+			yield(User{}, err)
+			return false
+		}
+		if filter != nil {
+			ok, err := query.Match(filter, Getter(u))
+			if err != nil {
+				yield(User{}, err)
+				return false
+			}
+			if !ok {
+				continue
+			}
+		}
+		if !yield(u, nil) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,446 @@
+// Package kvstore is a UserRepository on the kv LSM engine.
+//
+// Key layout:
+//
+//	u/<id as 16 hex digits>   JSON-encoded user (fixed width so key order is ID order)
+//	e/<normalized email>      user ID, the unique email index (normalize.EmailKey)
+//	m/next_id                 next ID to assign
+//	m/email_index             "plain" or "hmac": how e/ keys are formed
+//	m/index_key               HMAC key for e/ keys (hmac mode)
+//
+// Every mutation writes its keys in one kv.Batch, so readers never see a
+// user without its email entry or vice versa. Check-then-write sequences
+// (email uniqueness) are serialized by the store's own mutex.
+//
+// With an encrypting engine (kv.Options.Keys) values are sealed on disk
+// but keys are not, so the email index switches to hmac mode: e/ keys
+// become an HMAC-SHA256 of the normalized email, which still supports
+// exact lookups but no longer spells the address out. The HMAC key is
+// itself a value, so it is encrypted like the rest. Open rebuilds the
+// index when the mode changes.
+package kvstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"strconv"
+	"sync"
+	"time"
+
+	"Go-Internals/integrity"
+	"Go-Internals/kv"
+	"Go-Internals/normalize"
+	"Go-Internals/query"
+	"Go-Internals/users"
+)
+
+const (
+	userPrefix  = "u/"
+	emailPrefix = "e/"
+	nextIDKey   = "m/next_id"
+	modeKey     = "m/email_index"
+	indexKeyKey = "m/index_key"
+)
+
+// Store is safe for concurrent use.
+type Store struct {
+	db       *kv.DB
+	mu       sync.Mutex // serializes writers
+	indexKey []byte     // nil in plain mode
+}
+
+// Open opens (or creates) a store in dir.
+func Open(dir string, opts kv.Options) (*Store, error) {
+	db, err := kv.Open(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{db: db}
+	if err := s.setupIndex(opts.Keys != nil); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// setupIndex loads (or creates) the HMAC key and rebuilds the email index
+// if it was built in the other mode.
+func (s *Store) setupIndex(hashed bool) error {
+	want := "plain"
+	if hashed {
+		want = "hmac"
+		key, err := s.db.Get(indexKeyKey)
+		if errors.Is(err, kv.ErrNotFound) {
+			key = []byte(rand.Text())
+			err = s.db.Put(indexKeyKey, key)
+		}
+		if err != nil {
+			return err
+		}
+		s.indexKey = key
+	}
+
+	have, err := s.db.Get(modeKey)
+	if errors.Is(err, kv.ErrNotFound) {
+		have, err = []byte("plain"), nil // stores from before the mode key
+	}
+	if err != nil || string(have) == want {
+		return err
+	}
+	if err := s.reindex(); err != nil {
+		return err
+	}
+	return s.db.Put(modeKey, []byte(want))
+}
+
+// reindex drops every e/ key and recreates them from the users. It is
+// idempotent, so a crash part way through is fixed by the next Open.
+func (s *Store) reindex() error {
+	var stale []string
+	for rec, err := range s.db.Scan(emailPrefix, "", 0) {
+		if err != nil {
+			return err
+		}
+		stale = append(stale, rec.Key)
+	}
+	err := s.db.Batch(func(b *kv.Batch) {
+		for _, k := range stale {
+			b.Delete(k)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for u, err := range s.Iterate(context.Background(), users.IterateOptions{}) {
+		if err != nil {
+			return err
+		}
+		if err := s.db.Put(s.emailKey(u.Email), []byte(strconv.Itoa(u.ID))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func userKey(id int) string { return fmt.Sprintf("%s%016x", userPrefix, id) }
+
+func (s *Store) emailKey(email string) string {
+	email = normalize.EmailKey(email)
+	if s.indexKey == nil {
+		return emailPrefix + email
+	}
+	m := hmac.New(sha256.New, s.indexKey)
+	m.Write([]byte(email))
+	return emailPrefix + hex.EncodeToString(m.Sum(nil))
+}
+
+func (s *Store) Create(user users.User) (users.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.db.Get(s.emailKey(user.Email)); err == nil {
+		return users.User{}, users.ErrEmailTaken
+	} else if !errors.Is(err, kv.ErrNotFound) {
+		return users.User{}, err
+	}
+
+	id, err := s.nextID()
+	if err != nil {
+		return users.User{}, err
+	}
+	user.ID = id
+	user.CreatedAt = time.Now()
+	data, err := json.Marshal(user)
+	if err != nil {
+		return users.User{}, err
+	}
+
+	err = s.db.Batch(func(b *kv.Batch) {
+		b.Put(userKey(id), data)
+		b.Put(s.emailKey(user.Email), []byte(strconv.Itoa(id)))
+		b.Put(nextIDKey, []byte(strconv.Itoa(id+1)))
+	})
+	if err != nil {
+		return users.User{}, err
+	}
+	return user, nil
+}
+
+// Restore inserts user with its own ID and CreatedAt; see users.Restorer.
+func (s *Store) Restore(user users.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.get(user.ID); err == nil {
+		return users.ErrUserExists
+	} else if !errors.Is(err, users.ErrUserNotFound) {
+		return err
+	}
+	if _, err := s.db.Get(s.emailKey(user.Email)); err == nil {
+		return users.ErrEmailTaken
+	} else if !errors.Is(err, kv.ErrNotFound) {
+		return err
+	}
+	next, err := s.nextID()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Batch(func(b *kv.Batch) {
+		b.Put(userKey(user.ID), data)
+		b.Put(s.emailKey(user.Email), []byte(strconv.Itoa(user.ID)))
+		b.Put(nextIDKey, []byte(strconv.Itoa(max(next, user.ID+1))))
+	})
+}
+
+// PeekID is the ID Create assigns next; see users.IDPeeker.
+func (s *Store) PeekID() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nextID()
+}
+
+func (s *Store) nextID() (int, error) {
+	v, err := s.db.Get(nextIDKey)
+	if errors.Is(err, kv.ErrNotFound) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(v))
+}
+
+func (s *Store) get(id int) (users.User, error) {
+	data, err := s.db.Get(userKey(id))
+	if errors.Is(err, kv.ErrNotFound) {
+		return users.User{}, users.ErrUserNotFound
+	}
+	if err != nil {
+		return users.User{}, err
+	}
+	var u users.User
+	if err := json.Unmarshal(data, &u); err != nil {
+		return users.User{}, fmt.Errorf("kvstore: user %d: %w", id, err)
+	}
+	return u, nil
+}
+
+// GetByID hides expired users, like the other backends.
+func (s *Store) GetByID(id int) (users.User, error) {
+	u, err := s.get(id)
+	if err != nil {
+		return users.User{}, err
+	}
+	if u.Expired(time.Now()) {
+		return users.User{}, users.ErrUserNotFound
+	}
+	return u, nil
+}
+
+// List returns live users in ID order. Read errors truncate the result,
+// since the interface has no error return; use Iterate to see them.
+func (s *Store) List() []users.User {
+	var out []users.User
+	for u, err := range s.Iterate(context.Background(), users.IterateOptions{}) {
+		if err != nil {
+			break
+		}
+		out = append(out, u)
+	}
+	return out
+}
+
+func (s *Store) Update(user users.User) (users.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, err := s.get(user.ID)
+	if err != nil {
+		return users.User{}, err
+	}
+	newEmail := s.emailKey(user.Email)
+	oldEmail := s.emailKey(old.Email)
+	if newEmail != oldEmail {
+		if _, err := s.db.Get(newEmail); err == nil {
+			return users.User{}, users.ErrEmailTaken
+		} else if !errors.Is(err, kv.ErrNotFound) {
+			return users.User{}, err
+		}
+	}
+
+	user.CreatedAt = old.CreatedAt
+	data, err := json.Marshal(user)
+	if err != nil {
+		return users.User{}, err
+	}
+	err = s.db.Batch(func(b *kv.Batch) {
+		b.Put(userKey(user.ID), data)
+		if newEmail != oldEmail {
+			b.Delete(oldEmail)
+			b.Put(newEmail, []byte(strconv.Itoa(user.ID)))
+		}
+	})
+	if err != nil {
+		return users.User{}, err
+	}
+	return user, nil
+}
+
+// UpdateMany writes every user of list in one kv.Batch; see
+// users.BulkUpdater. Email checks see the earlier users' new emails.
+func (s *Store) UpdateMany(list []users.User) ([]users.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// owners overlays the e/ keys the batch moves: 0 is freed.
+	owners := map[string]int{}
+	taken := func(key string) (bool, error) {
+		if id, ok := owners[key]; ok {
+			return id != 0, nil
+		}
+		_, err := s.db.Get(key)
+		if errors.Is(err, kv.ErrNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	updated := make([]users.User, 0, len(list))
+	data := make([][]byte, 0, len(list))
+	for _, user := range list {
+		old, err := s.get(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		newEmail, oldEmail := s.emailKey(user.Email), s.emailKey(old.Email)
+		if newEmail != oldEmail {
+			if t, err := taken(newEmail); err != nil {
+				return nil, err
+			} else if t {
+				return nil, users.ErrEmailTaken
+			}
+			owners[oldEmail], owners[newEmail] = 0, user.ID
+		}
+		user.CreatedAt = old.CreatedAt
+		b, err := json.Marshal(user)
+		if err != nil {
+			return nil, err
+		}
+		updated = append(updated, user)
+		data = append(data, b)
+	}
+
+	err := s.db.Batch(func(b *kv.Batch) {
+		for k, id := range owners {
+			if id == 0 {
+				b.Delete(k)
+			} else {
+				b.Put(k, []byte(strconv.Itoa(id)))
+			}
+		}
+		for i, u := range updated {
+			b.Put(userKey(u.ID), data[i])
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (s *Store) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	return s.db.Batch(func(b *kv.Batch) {
+		b.Delete(userKey(id))
+		b.Delete(s.emailKey(u.Email))
+	})
+}
+
+// Search scans every user; the only index is email uniqueness.
+func (s *Store) Search(spec query.Spec) ([]users.User, error) {
+	result, err := users.Filter(s.List(), spec)
+	if err != nil {
+		return nil, err
+	}
+	users.SortByCreated(result)
+	return result, nil
+}
+
+// Iterate streams users in ID order straight off a kv scan.
+func (s *Store) Iterate(ctx context.Context, opts users.IterateOptions) iter.Seq2[users.User, error] {
+	return func(yield func(users.User, error) bool) {
+		size := opts.BatchSize
+		if size <= 0 {
+			size = 256
+		}
+		batch := make([]users.User, 0, size)
+		now := time.Now()
+
+		for rec, err := range s.db.Scan(userPrefix, "", size) {
+			if err != nil {
+				yield(users.User{}, err)
+				return
+			}
+			var u users.User
+			if err := json.Unmarshal(rec.Value, &u); err != nil {
+				yield(users.User{}, fmt.Errorf("kvstore: %s: %w", rec.Key, err))
+				return
+			}
+			if u.Expired(now) {
+				continue
+			}
+			batch = append(batch, u)
+			if len(batch) == size {
+				if !users.YieldBatch(ctx, batch, opts.Filter, yield) {
+					return
+				}
+				batch = batch[:0]
+			}
+		}
+		users.YieldBatch(ctx, batch, opts.Filter, yield)
+	}
+}
+
+// Compact merges the engine's tables now.
+func (s *Store) Compact() error { return s.db.Compact() }
+
+// Purge makes past deletes and updates physical: the memtable is flushed
+// (which resets the WAL) and every table merged, so superseded versions
+// are gone from disk. Erasure (package privacy) relies on it.
+func (s *Store) Purge(ctx context.Context) error {
+	if err := s.db.Flush(); err != nil {
+		return err
+	}
+	return s.db.Compact()
+}
+
+// Vacuum runs a paced background compaction; see kv.DB.Vacuum.
+func (s *Store) Vacuum(ctx context.Context, pace func(context.Context, int) error) (int64, int64, error) {
+	return s.db.Vacuum(ctx, pace)
+}
+
+// Problems lists the damage the engine quarantined on open.
+func (s *Store) Problems() []integrity.Problem { return s.db.Problems() }
+
+// Stats exposes the engine counters.
+func (s *Store) Stats() kv.Stats { return s.db.Stats() }
+
+func (s *Store) Close() error { return s.db.Close() }
@@ -0,0 +1,243 @@
+// Package mmapstore keeps user records off the Go heap in a memory-mapped
+// file of fixed-width slots.
+//
+// File layout (all integers in native byte order; the file is not portable
+// between architectures with different endianness):
+//
+//	[0, 4096)               header page
+//	[4096 + i*SlotSize, +SlotSize)  slot i
+//
+// Each slot is read and written by casting the mapped bytes to *slot with
+// unsafe.Pointer: no encoding step and no copy onto the heap until a User is
+// materialized.
+//
+// Every slot carries a CRC32 of its payload and the header carries one of
+// its own fields. Open quarantines committed slots that fail their CRC
+// (copying the raw bytes to a quarantine directory next to the file, see
+// package integrity) and rebuilds a damaged header from the slots, so one
+// bad record never stops the store from loading. Verify checks a file
+// without changing it.
+//
+// Encryption at rest (Options.Keys): emails are AES-GCM sealed with a
+// per-file data key, bound to the record ID, and the data key is stored
+// wrapped in the header page (package atrest). The seal costs 28 bytes of
+// the email width. A high bit in emailLen marks sealed emails, so a
+// plaintext file opened with keys keeps working, and Vacuum rewrites
+// every email under a new data key wrapped by the current key: that is
+// both the migration and the key rotation path.
+//
+// Crash consistency: a slot is written in two steps. The payload and its
+// CRC32 go in first, the state byte is flipped to committed last, then the
+// page is msync'ed. A crash between the two leaves a slot that is either
+// still empty, or committed with a CRC that does not match (torn write).
+// Open skips both, so a crash loses at most the record being written and
+// never exposes half of one. The in-memory id→slot index is rebuilt by
+// scanning slots on Open.
+//
+// Updates never overwrite a live slot: the new version is committed into a
+// free slot with rev+1, then the old slot is cleared. If a crash leaves both
+// committed, Open keeps the higher rev.
+//
+// Expired records are hidden on read (GetByID, List, Iterate) and their
+// slot is reclaimed when they are deleted; there is no background sweeper.
+// Freed slots are reused but the file never shrinks on its own; Vacuum
+// rewrites it with only the live records.
+package mmapstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"time"
+	"unsafe"
+
+	"Go-Internals/atrest"
+	"Go-Internals/users"
+)
+
+const (
+	headerSize = 4096
+	SlotSize   = 384
+	NameSize   = 64
+	EmailSize  = 152
+	AvatarSize = 126
+
+	magic   = "GIUSERS1"
+	version = 3 // v3: avatar URL (slot grew from 256 to 384); v2: expires field (email shrank from 160 to 152)
+
+	slotEmpty     = 0
+	slotCommitted = 1
+)
+
+var (
+	ErrTooLong     = errors.New("mmapstore: name, email or avatar URL exceeds slot width")
+	ErrBadFile     = errors.New("mmapstore: not a user store file")
+	ErrUnsupported = errors.New("mmapstore: memory-mapped store is only supported on linux")
+	ErrNoKeys      = errors.New("mmapstore: file is encrypted and no keyring is configured")
+)
+
+const (
+	// sealedFlag marks emailLen as the length of a sealed email.
+	sealedFlag = 0x8000
+
+	// The wrapped data key lives in the header page after the header:
+	// [len u16][wrapped key].
+	keyBlockOff = 64
+	keyBlockMax = headerSize - keyBlockOff - 2
+)
+
+// Options configures OpenWith.
+type Options struct {
+	InitialSlots int // slots in a new file; default 1024
+	// Keys, if set, encrypts emails at rest; see the package comment.
+	Keys *atrest.Keyring
+}
+
+// header is the first page of the file.
+type header struct {
+	magic    [8]byte
+	version  uint32
+	slotSize uint32
+	capacity uint32 // slots currently allocated in the file
+	crc      uint32 // of the header with crc = 0; 0 = not set (older files)
+	nextID   uint64
+}
+
+func (h *header) checksum() uint32 {
+	c := *h
+	c.crc = 0
+	return crc32.ChecksumIEEE((*[unsafe.Sizeof(header{})]byte)(unsafe.Pointer(&c))[:])
+}
+
+// seal stamps the checksum; call it before every header write.
+func (h *header) seal() { h.crc = h.checksum() }
+
+func (h *header) intact() bool { return h.crc == 0 || h.crc == h.checksum() }
+
+// slot is exactly SlotSize bytes; the blank fields pad it out.
+type slot struct {
+	state     uint8
+	_         [3]byte
+	crc       uint32
+	id        int64
+	created   int64 // unix nanoseconds
+	expires   int64 // unix nanoseconds, 0 = never
+	nameLen   uint16
+	emailLen  uint16
+	rev       uint32 // bumped on every update of the same ID
+	name      [NameSize]byte
+	email     [EmailSize]byte
+	avatarLen uint16
+	avatar    [AvatarSize]byte
+}
+
+// Compile-time layout checks: these fail to build if a field change breaks
+// the on-disk format.
+var (
+	_ [SlotSize - unsafe.Sizeof(slot{})]struct{}
+	_ [unsafe.Sizeof(slot{}) - SlotSize]struct{}
+	_ [headerSize - unsafe.Sizeof(header{})]struct{}
+)
+
+// keyBlock returns the wrapped data key stored in the header page of a
+// file image, or nil if the file is not encrypted.
+func keyBlock(data []byte) []byte {
+	n := int(binary.LittleEndian.Uint16(data[keyBlockOff:]))
+	if n == 0 || n > keyBlockMax {
+		return nil
+	}
+	return data[keyBlockOff+2 : keyBlockOff+2+n]
+}
+
+func putKeyBlock(data, wrapped []byte) error {
+	if len(wrapped) > keyBlockMax {
+		return errors.New("mmapstore: wrapped data key does not fit the header page")
+	}
+	binary.LittleEndian.PutUint16(data[keyBlockOff:], uint16(len(wrapped)))
+	copy(data[keyBlockOff+2:], wrapped)
+	return nil
+}
+
+// payload returns the bytes covered by the CRC (everything after crc).
+func (s *slot) payload() []byte {
+	const off = unsafe.Offsetof(slot{}.id)
+	p := (*[SlotSize]byte)(unsafe.Pointer(s))
+	return p[off:]
+}
+
+func (s *slot) checksum() uint32 { return crc32.ChecksumIEEE(s.payload()) }
+
+func (s *slot) valid() bool {
+	return s.state == slotCommitted && s.crc == s.checksum()
+}
+
+// damaged reports a slot that claims to hold a record but cannot be
+// trusted. Empty slots are not checked: deleted records leave their old
+// bytes behind.
+func (s *slot) damaged() bool {
+	return s.state > slotCommitted || (s.state == slotCommitted && s.crc != s.checksum())
+}
+
+// user materializes the record, opening a sealed email with dk.
+func (s *slot) user(dk *atrest.DataKey) (users.User, error) {
+	u := users.User{
+		ID:        int(s.id),
+		Name:      string(s.name[:s.nameLen]),
+		CreatedAt: time.Unix(0, s.created),
+		AvatarURL: string(s.avatar[:s.avatarLen]),
+	}
+	if s.expires != 0 {
+		u.ExpiresAt = time.Unix(0, s.expires)
+	}
+	if s.emailLen&sealedFlag == 0 {
+		u.Email = string(s.email[:s.emailLen])
+		return u, nil
+	}
+	if dk == nil {
+		return users.User{}, ErrNoKeys
+	}
+	email, err := dk.Open(nil, s.email[:s.emailLen&^sealedFlag], idAAD(s.id))
+	if err != nil {
+		return users.User{}, err
+	}
+	u.Email = string(email)
+	return u, nil
+}
+
+// idAAD binds a sealed email to its record ID, so it cannot be moved to
+// another record on disk.
+func idAAD(id int64) []byte { return binary.LittleEndian.AppendUint64(nil, uint64(id)) }
+
+// expired is checked without materializing a User.
+func (s *slot) expired(now int64) bool {
+	return s.expires != 0 && now >= s.expires
+}
+
+// fill writes the payload and CRC but leaves state alone; the caller
+// commits separately so the commit is the last byte to change. With dk
+// the email is sealed, which costs atrest.Overhead bytes of its width.
+func (s *slot) fill(u users.User, dk *atrest.DataKey) error {
+	email := []byte(u.Email)
+	flag := uint16(0)
+	if dk != nil {
+		email = dk.Seal(nil, email, idAAD(int64(u.ID)))
+		flag = sealedFlag
+	}
+	if len(u.Name) > NameSize || len(email) > EmailSize || len(u.AvatarURL) > AvatarSize {
+		return ErrTooLong
+	}
+	s.id = int64(u.ID)
+	s.created = u.CreatedAt.UnixNano()
+	s.expires = 0
+	if !u.ExpiresAt.IsZero() {
+		s.expires = u.ExpiresAt.UnixNano()
+	}
+	s.nameLen = uint16(copy(s.name[:], u.Name))
+	s.emailLen = uint16(copy(s.email[:], email)) | flag
+	s.avatarLen = uint16(copy(s.avatar[:], u.AvatarURL))
+	clear(s.name[s.nameLen:])
+	clear(s.email[s.emailLen&^sealedFlag:])
+	clear(s.avatar[s.avatarLen:])
+	s.crc = s.checksum()
+	return nil
+}
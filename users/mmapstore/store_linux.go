@@ -0,0 +1,785 @@
+//go:build linux
+
+package mmapstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"Go-Internals/atrest"
+	"Go-Internals/integrity"
+	"Go-Internals/normalize"
+	"Go-Internals/query"
+	"Go-Internals/users"
+)
+
+// Store is a users.UserRepository backed by a memory-mapped file.
+type Store struct {
+	mu    sync.Mutex
+	f     *os.File
+	data  []byte // the whole mapping
+	index map[int]int
+	// emails maps each live user's normalize.EmailKey to its ID, so
+	// emails stay unique as in the other stores. It lives in memory
+	// only, rebuilt by load: the file keeps emails sealed.
+	emails map[string]int
+	free   []int // empty (or torn) slots available for reuse
+	hdr    *header
+	gen    uint64 // bumped by every mutation; lets Vacuum detect races
+	keys   *atrest.Keyring
+	dk     *atrest.DataKey // seals new emails; nil without keys
+
+	problems []integrity.Problem // found by load
+}
+
+var _ users.UserRepository = (*Store)(nil)
+
+// Open maps path, creating it with initialSlots slots if it does not exist.
+func Open(path string, initialSlots int) (*Store, error) {
+	return OpenWith(path, Options{InitialSlots: initialSlots})
+}
+
+// OpenWith is Open with options.
+func OpenWith(path string, opts Options) (*Store, error) {
+	initialSlots := opts.InitialSlots
+	if initialSlots <= 0 {
+		initialSlots = 1024
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	s := &Store{f: f, index: make(map[int]int), emails: make(map[string]int), keys: opts.Keys}
+
+	fresh := st.Size() == 0
+	size := st.Size()
+	if fresh {
+		size = int64(headerSize + initialSlots*SlotSize)
+		if err := f.Truncate(size); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	if err := s.mapFile(int(size)); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if fresh {
+		copy(s.hdr.magic[:], magic)
+		s.hdr.version = version
+		s.hdr.slotSize = SlotSize
+		s.hdr.capacity = uint32(initialSlots)
+		s.hdr.nextID = 1
+		if err := s.syncHeader(); err != nil {
+			s.Close()
+			return nil, err
+		}
+	} else if string(s.hdr.magic[:]) != magic || s.hdr.version != version || s.hdr.slotSize != SlotSize {
+		s.Close()
+		return nil, ErrBadFile
+	}
+
+	if err := s.loadKey(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Problems lists the damage Open found and quarantined.
+func (s *Store) Problems() []integrity.Problem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.problems)
+}
+
+func (s *Store) Create(user users.User) (users.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, taken := s.emails[normalize.EmailKey(user.Email)]; taken {
+		return users.User{}, users.ErrEmailTaken
+	}
+	if len(s.free) == 0 {
+		if err := s.growLocked(); err != nil {
+			return users.User{}, err
+		}
+	}
+	i := s.free[len(s.free)-1]
+
+	user.ID = int(s.hdr.nextID)
+	user.CreatedAt = time.Now()
+
+	sl := s.slot(i)
+	sl.state = slotEmpty
+	if err := sl.fill(user, s.dk); err != nil {
+		return users.User{}, err
+	}
+	// Payload first, commit byte last.
+	sl.state = slotCommitted
+	s.hdr.nextID++
+
+	if err := s.syncSlot(i); err != nil {
+		return users.User{}, err
+	}
+	if err := s.syncHeader(); err != nil {
+		return users.User{}, err
+	}
+
+	s.free = s.free[:len(s.free)-1]
+	s.index[user.ID] = i
+	s.emails[normalize.EmailKey(user.Email)] = user.ID
+	s.gen++
+	return user, nil
+}
+
+// PeekID is the ID Create assigns next; see users.IDPeeker.
+func (s *Store) PeekID() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int(s.hdr.nextID), nil
+}
+
+// Restore inserts user with its own ID and CreatedAt; see users.Restorer.
+func (s *Store) Restore(user users.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.index[user.ID]; ok {
+		return users.ErrUserExists
+	}
+	if _, taken := s.emails[normalize.EmailKey(user.Email)]; taken {
+		return users.ErrEmailTaken
+	}
+	if len(s.free) == 0 {
+		if err := s.growLocked(); err != nil {
+			return err
+		}
+	}
+	i := s.free[len(s.free)-1]
+
+	sl := s.slot(i)
+	sl.state = slotEmpty
+	sl.rev = 0
+	if err := sl.fill(user, s.dk); err != nil {
+		return err
+	}
+	sl.state = slotCommitted
+	if err := s.syncSlot(i); err != nil {
+		return err
+	}
+	if uint64(user.ID) >= s.hdr.nextID {
+		s.hdr.nextID = uint64(user.ID) + 1
+		if err := s.syncHeader(); err != nil {
+			return err
+		}
+	}
+
+	s.free = s.free[:len(s.free)-1]
+	s.index[user.ID] = i
+	s.emails[normalize.EmailKey(user.Email)] = user.ID
+	s.gen++
+	return nil
+}
+
+func (s *Store) GetByID(id int) (users.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.index[id]
+	if !ok || s.slot(i).expired(time.Now().UnixNano()) {
+		return users.User{}, users.ErrUserNotFound
+	}
+	return s.slot(i).user(s.dk)
+}
+
+// List returns the users in creation order (by ID), like the other
+// stores, not in slot order.
+func (s *Store) List() []users.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	result := make([]users.User, 0, len(s.index))
+	for _, id := range s.idsLocked() {
+		if sl := s.slot(s.index[id]); !sl.expired(now) {
+			// Sealed emails were checked by load, so this cannot fail.
+			if u, err := sl.user(s.dk); err == nil {
+				result = append(result, u)
+			}
+		}
+	}
+	return result
+}
+
+// Search scans every live slot; the email index is for uniqueness only.
+func (s *Store) Search(spec query.Spec) ([]users.User, error) {
+	result, err := users.Filter(s.List(), spec)
+	if err != nil {
+		return nil, err
+	}
+	users.SortByCreated(result)
+	return result, nil
+}
+
+// Iterate streams users in slot order (not creation order), copying
+// opts.BatchSize records out of the mapping per lock acquisition.
+func (s *Store) Iterate(ctx context.Context, opts users.IterateOptions) iter.Seq2[users.User, error] {
+	return func(yield func(users.User, error) bool) {
+		size := opts.BatchSize
+		if size <= 0 {
+			size = 256
+		}
+		batch := make([]users.User, 0, size)
+
+		for next := 0; ; {
+			if err := ctx.Err(); err != nil {
+				yield(users.User{}, err)
+				return
+			}
+
+			batch = batch[:0]
+			s.mu.Lock()
+			if s.data == nil {
+				s.mu.Unlock()
+				return
+			}
+			capacity := int(s.hdr.capacity)
+			now := time.Now().UnixNano()
+			for ; next < capacity && len(batch) < size; next++ {
+				sl := s.slot(next)
+				if sl.state != slotCommitted || sl.expired(now) {
+					continue
+				}
+				if i, ok := s.index[int(sl.id)]; ok && i == next {
+					if u, err := sl.user(s.dk); err == nil {
+						batch = append(batch, u)
+					}
+				}
+			}
+			s.mu.Unlock()
+
+			if !users.YieldBatch(ctx, batch, opts.Filter, yield) || next >= capacity {
+				return
+			}
+		}
+	}
+}
+
+// Update writes the new version into a free slot and only then retires the
+// old one, so there is no moment at which the record exists in neither.
+func (s *Store) Update(user users.User) (users.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldIdx, ok := s.index[user.ID]
+	if !ok {
+		return users.User{}, users.ErrUserNotFound
+	}
+	prev, err := s.slot(oldIdx).user(s.dk)
+	if err != nil {
+		return users.User{}, err
+	}
+	key := normalize.EmailKey(user.Email)
+	if owner, taken := s.emails[key]; taken && owner != user.ID {
+		return users.User{}, users.ErrEmailTaken
+	}
+	if len(s.free) == 0 {
+		if err := s.growLocked(); err != nil {
+			return users.User{}, err
+		}
+	}
+	newIdx := s.free[len(s.free)-1]
+
+	old := s.slot(oldIdx)
+	user.CreatedAt = time.Unix(0, old.created)
+	rev := old.rev + 1
+
+	sl := s.slot(newIdx)
+	sl.state = slotEmpty
+	sl.rev = rev
+	if err := sl.fill(user, s.dk); err != nil {
+		return users.User{}, err
+	}
+	sl.state = slotCommitted
+	if err := s.syncSlot(newIdx); err != nil {
+		return users.User{}, err
+	}
+
+	old.state = slotEmpty
+	if err := s.syncSlot(oldIdx); err != nil {
+		return users.User{}, err
+	}
+
+	s.free[len(s.free)-1] = oldIdx
+	s.index[user.ID] = newIdx
+	s.dropEmailLocked(prev)
+	s.emails[key] = user.ID
+	s.gen++
+	return user, nil
+}
+
+func (s *Store) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.index[id]
+	if !ok {
+		return users.ErrUserNotFound
+	}
+	u, err := s.slot(i).user(s.dk)
+	if err != nil {
+		return err
+	}
+	s.slot(i).state = slotEmpty
+	if err := s.syncSlot(i); err != nil {
+		return err
+	}
+	delete(s.index, id)
+	s.dropEmailLocked(u)
+	s.free = append(s.free, i)
+	s.gen++
+	return nil
+}
+
+// dropEmailLocked frees u's email, if u is who holds it.
+func (s *Store) dropEmailLocked(u users.User) {
+	if key := normalize.EmailKey(u.Email); s.emails[key] == u.ID {
+		delete(s.emails, key)
+	}
+}
+
+// Close flushes and unmaps the file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data == nil {
+		return nil
+	}
+	return s.unmapLocked()
+}
+
+/*
+-----------------------------------
+VACUUM
+-----------------------------------
+*/
+
+// Vacuum shrinks the file to the live records plus some headroom. The file
+// only ever grows otherwise, so after a mass delete most of it is empty
+// slots.
+//
+// Live slots are copied out under the lock, then written (paced) to a
+// sibling file without it, so the store stays usable during the slow part.
+// If the store was written to meanwhile, the copy is redone under the lock
+// without pacing, which is quick since it is only memory and one file.
+// The new file is fsynced and renamed over the old one before it is
+// mapped; a crash leaves one complete file or the other.
+//
+// With keys, the image gets a fresh data key wrapped by the current key
+// and every email is sealed again under it, plaintext ones included.
+func (s *Store) Vacuum(ctx context.Context, pace func(ctx context.Context, n int) error) (before, after int64, err error) {
+	s.mu.Lock()
+	if s.data == nil {
+		s.mu.Unlock()
+		return 0, 0, os.ErrClosed
+	}
+	path := s.f.Name()
+	tmp := path + ".vacuum"
+	before = int64(len(s.data))
+	var dk *atrest.DataKey
+	if s.keys != nil {
+		if dk, err = s.keys.NewDataKey(); err != nil {
+			s.mu.Unlock()
+			return before, before, err
+		}
+	}
+	image, err := s.packLocked(dk)
+	gen := s.gen
+	s.mu.Unlock()
+	if err != nil {
+		return before, before, err
+	}
+
+	defer os.Remove(tmp) // no-op after the rename
+	if err := writeFileSync(ctx, tmp, image, pace); err != nil {
+		return before, before, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		return before, before, os.ErrClosed
+	}
+	if s.gen != gen {
+		if image, err = s.packLocked(dk); err != nil {
+			return before, before, err
+		}
+		if err := writeFileSync(ctx, tmp, image, nil); err != nil {
+			return before, before, err
+		}
+	}
+
+	if err := s.unmapLocked(); err != nil {
+		return before, before, err
+	}
+	renameErr := os.Rename(tmp, path)
+	if renameErr == nil {
+		renameErr = syncDir(filepath.Dir(path))
+	}
+	// Map whichever file is now at path: the new one, or the untouched
+	// old one if the rename failed.
+	if err := s.reopenLocked(path); err != nil {
+		return before, before, err
+	}
+	if renameErr != nil {
+		return before, before, renameErr
+	}
+	return before, int64(len(s.data)), nil
+}
+
+// Purge rewrites the file unpaced, so the bytes of deleted and superseded
+// records (which empty slots keep) are gone. Erasure (package privacy)
+// relies on it.
+func (s *Store) Purge(ctx context.Context) error {
+	_, _, err := s.Vacuum(ctx, nil)
+	return err
+}
+
+// packLocked builds the compacted file image: the header, then the live
+// slots in ID order, then empty slots up to the new capacity. A non-nil
+// dk replaces the file's data key and reseals every email.
+func (s *Store) packLocked(dk *atrest.DataKey) ([]byte, error) {
+	ids := s.idsLocked()
+	capacity := max(len(ids)+len(ids)/4, 64)
+	image := make([]byte, headerSize+capacity*SlotSize)
+	copy(image, s.data[:headerSize])
+	h := (*header)(unsafe.Pointer(&image[0]))
+	h.capacity = uint32(capacity)
+	h.seal()
+	if dk != nil {
+		if err := putKeyBlock(image, dk.Wrapped()); err != nil {
+			return nil, err
+		}
+	}
+	for n, id := range ids {
+		src := headerSize + s.index[id]*SlotSize
+		dst := image[headerSize+n*SlotSize:]
+		copy(dst, s.data[src:src+SlotSize])
+		if dk == nil {
+			continue
+		}
+		u, err := s.slot(s.index[id]).user(s.dk)
+		if err != nil {
+			return nil, err
+		}
+		// fill keeps state and rev, which the copy carried over.
+		if err := (*slot)(unsafe.Pointer(&dst[0])).fill(u, dk); err != nil {
+			return nil, err
+		}
+	}
+	return image, nil
+}
+
+// idsLocked returns the stored IDs in order, which is creation order:
+// IDs only grow, and a user keeps its ID when its slot moves.
+func (s *Store) idsLocked() []int {
+	ids := make([]int, 0, len(s.index))
+	for id := range s.index {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func writeFileSync(ctx context.Context, path string, data []byte, pace func(context.Context, int) error) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	const chunk = 64 << 10
+	for off := 0; off < len(data); off += chunk {
+		end := min(off+chunk, len(data))
+		if pace != nil {
+			if err := pace(ctx, end-off); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		if _, err := f.Write(data[off:end]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *Store) unmapLocked() error {
+	err := s.sync(0, len(s.data))
+	if uerr := syscall.Munmap(s.data); err == nil {
+		err = uerr
+	}
+	s.data, s.hdr = nil, nil
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *Store) reopenLocked(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	if err := s.mapFile(int(st.Size())); err != nil {
+		f.Close()
+		return err
+	}
+	s.index, s.emails, s.free = make(map[int]int), make(map[string]int), nil
+	if err := s.loadKey(); err != nil {
+		return err
+	}
+	return s.load()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+/*
+-----------------------------------
+MAPPING & SYSCALLS
+-----------------------------------
+*/
+
+func (s *Store) mapFile(size int) error {
+	data, err := syscall.Mmap(int(s.f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("mmapstore: mmap: %w", err)
+	}
+	s.data = data
+	s.hdr = (*header)(unsafe.Pointer(&data[0]))
+	return nil
+}
+
+// slot casts the mapped bytes of slot i to *slot. The pointer is only valid
+// until the next remap (growLocked), so it must not escape s.mu.
+func (s *Store) slot(i int) *slot {
+	off := headerSize + i*SlotSize
+	return (*slot)(unsafe.Pointer(&s.data[off]))
+}
+
+// growLocked doubles the file and remaps it. Every *slot obtained earlier
+// is invalid afterwards, which is why nothing holds one across calls.
+func (s *Store) growLocked() error {
+	oldCap := int(s.hdr.capacity)
+	newCap := oldCap * 2
+	if newCap == 0 {
+		newCap = 1024
+	}
+
+	if err := s.sync(0, len(s.data)); err != nil {
+		return err
+	}
+	if err := syscall.Munmap(s.data); err != nil {
+		return fmt.Errorf("mmapstore: munmap: %w", err)
+	}
+	s.data, s.hdr = nil, nil
+
+	size := headerSize + newCap*SlotSize
+	if err := s.f.Truncate(int64(size)); err != nil {
+		return err
+	}
+	if err := s.mapFile(size); err != nil {
+		return err
+	}
+
+	s.hdr.capacity = uint32(newCap)
+	for i := newCap - 1; i >= oldCap; i-- {
+		s.free = append(s.free, i)
+	}
+	return s.syncHeader()
+}
+
+// load rebuilds the index and free list. Slots that are committed but
+// fail their CRC (torn writes, or later damage) are quarantined and
+// cleared; a damaged header is rebuilt from the file size and the slots.
+func (s *Store) load() error {
+	fileSlots := (len(s.data) - headerSize) / SlotSize
+	headerOK := s.hdr.intact()
+	if !headerOK {
+		s.problems = append(s.problems, integrity.Problem{File: s.f.Name(), Offset: 0,
+			Detail: "header checksum mismatch; rebuilt from slots"})
+		s.hdr.capacity = uint32(fileSlots)
+	}
+	capacity := min(int(s.hdr.capacity), fileSlots)
+
+	maxID := 0
+	for i := capacity - 1; i >= 0; i-- {
+		sl := s.slot(i)
+		if sl.damaged() {
+			if err := s.quarantineLocked(i, fmt.Sprintf("slot %d fails its checksum", i)); err != nil {
+				return err
+			}
+		} else if sl.valid() && sl.emailLen&sealedFlag != 0 {
+			if _, err := sl.user(s.dk); errors.Is(err, ErrNoKeys) {
+				return err
+			} else if err != nil {
+				if err := s.quarantineLocked(i, fmt.Sprintf("slot %d: email does not decrypt", i)); err != nil {
+					return err
+				}
+			}
+		}
+		if !sl.valid() {
+			s.free = append(s.free, i)
+			continue
+		}
+		id := int(sl.id)
+		if prev, dup := s.index[id]; dup {
+			// Crash mid-update: keep the newer revision.
+			if s.slot(prev).rev > sl.rev {
+				sl.state = slotEmpty
+				s.free = append(s.free, i)
+				continue
+			}
+			s.slot(prev).state = slotEmpty
+			s.free = append(s.free, prev)
+		}
+		s.index[id] = i
+		if id > maxID {
+			maxID = id
+		}
+	}
+
+	// A file written before emails were kept unique may hold the same
+	// one twice: the older user keeps it.
+	for _, id := range s.idsLocked() {
+		if u, err := s.slot(s.index[id]).user(s.dk); err == nil {
+			if _, taken := s.emails[normalize.EmailKey(u.Email)]; !taken {
+				s.emails[normalize.EmailKey(u.Email)] = id
+			}
+		}
+	}
+
+	// The header may lag a crash that happened after a slot commit.
+	if uint64(maxID) >= s.hdr.nextID {
+		s.hdr.nextID = uint64(maxID) + 1
+	}
+	if !headerOK {
+		return s.syncHeader()
+	}
+	return nil
+}
+
+// quarantineLocked saves slot i's raw bytes next to the file and clears
+// it, so it is reported once rather than on every open.
+func (s *Store) quarantineLocked(i int, detail string) error {
+	off := headerSize + i*SlotSize
+	p := integrity.Problem{File: s.f.Name(), Offset: int64(off), Detail: detail}
+	var err error
+	if p.Quarantined, err = integrity.Save(filepath.Dir(s.f.Name()), s.f.Name(), int64(off), s.data[off:off+SlotSize]); err != nil {
+		return err
+	}
+	s.slot(i).state = slotEmpty
+	if err := s.syncSlot(i); err != nil {
+		return err
+	}
+	s.problems = append(s.problems, p)
+	return nil
+}
+
+// loadKey opens the file's data key. A file with sealed emails needs it
+// to be read at all; with keys and no data key yet (a new or plaintext
+// file), one is created so new emails are sealed from now on.
+func (s *Store) loadKey() error {
+	s.dk = nil
+	wrapped := keyBlock(s.data)
+	switch {
+	case wrapped == nil && s.keys == nil:
+		return nil
+	case wrapped == nil:
+		dk, err := s.keys.NewDataKey()
+		if err != nil {
+			return err
+		}
+		if err := putKeyBlock(s.data, dk.Wrapped()); err != nil {
+			return err
+		}
+		s.dk = dk
+		return s.sync(0, headerSize)
+	case s.keys == nil:
+		return ErrNoKeys
+	}
+	dk, err := s.keys.OpenDataKey(wrapped)
+	if err != nil {
+		return err
+	}
+	s.dk = dk
+	return nil
+}
+
+func (s *Store) syncHeader() error {
+	s.hdr.seal()
+	return s.sync(0, headerSize)
+}
+
+func (s *Store) syncSlot(i int) error {
+	return s.sync(headerSize+i*SlotSize, SlotSize)
+}
+
+// sync msyncs the pages covering [off, off+n). msync requires a
+// page-aligned start address, so the range is widened to page boundaries.
+func (s *Store) sync(off, n int) error {
+	page := os.Getpagesize()
+	start := off / page * page
+	end := off + n
+	if end > len(s.data) {
+		end = len(s.data)
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC,
+		uintptr(unsafe.Pointer(&s.data[start])), uintptr(end-start), syscall.MS_SYNC)
+	if errno != 0 {
+		return fmt.Errorf("mmapstore: msync: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+//go:build !linux
+
+package mmapstore
+
+import (
+	"context"
+	"iter"
+
+	"Go-Internals/integrity"
+	"Go-Internals/query"
+	"Go-Internals/users"
+)
+
+// Store is only implemented on linux; see store_linux.go.
+type Store struct{}
+
+func Open(path string, initialSlots int) (*Store, error) { return nil, ErrUnsupported }
+func OpenWith(path string, opts Options) (*Store, error) { return nil, ErrUnsupported }
+
+func (*Store) Create(users.User) (users.User, error) { return users.User{}, ErrUnsupported }
+func (*Store) GetByID(int) (users.User, error)       { return users.User{}, ErrUnsupported }
+func (*Store) List() []users.User                    { return nil }
+func (*Store) Update(users.User) (users.User, error) { return users.User{}, ErrUnsupported }
+func (*Store) Delete(int) error                      { return ErrUnsupported }
+func (*Store) Restore(users.User) error              { return ErrUnsupported }
+func (*Store) PeekID() (int, error)                  { return 0, ErrUnsupported }
+
+func (*Store) Search(query.Spec) ([]users.User, error) { return nil, ErrUnsupported }
+func (*Store) Close() error                            { return nil }
+
+func (*Store) Problems() []integrity.Problem { return nil }
+
+func (*Store) Vacuum(context.Context, func(context.Context, int) error) (int64, int64, error) {
+	return 0, 0, ErrUnsupported
+}
+
+func (*Store) Purge(context.Context) error { return ErrUnsupported }
+
+func (*Store) Iterate(context.Context, users.IterateOptions) iter.Seq2[users.User, error] {
+	return func(yield func(users.User, error) bool) { yield(users.User{}, ErrUnsupported) }
+}
@@ -0,0 +1,433 @@
+package users
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+-----------------------------------
+PATCHES
+-----------------------------------
+*/
+
+// The media types of the two patch formats.
+const (
+	MergePatchType = "application/merge-patch+json" // RFC 7396
+	JSONPatchType  = "application/json-patch+json"  // RFC 6902
+)
+
+// Patch is a change to some of a user's fields, written against the
+// user's JSON form: a JSON Merge Patch, an object whose members replace
+// the user's and whose nulls remove them, or a JSON Patch, a list of
+// operations on JSON pointers. id, created_at and avatar_url cannot be
+// patched. The zero Patch changes nothing.
+type Patch struct {
+	kind  string
+	merge any
+	ops   []PatchOp
+}
+
+// PatchOp is one JSON Patch operation: add, remove, replace, move, copy
+// or test.
+type PatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// readOnly are the fields a patch may not touch: the store assigns the
+// first two, and avatar_url follows the avatar upload.
+var readOnly = []string{"id", "created_at", "avatar_url"}
+
+// ParsePatch reads a patch in one of the two formats, named by its media
+// type. A JSON Patch's operations are checked here; whether they apply
+// is known only against a user.
+func ParsePatch(mediaType string, data []byte) (Patch, error) {
+	switch mediaType {
+	case MergePatchType:
+		var doc any
+		if err := decodeJSON(data, &doc); err != nil {
+			return Patch{}, invalidPatch("%v", err)
+		}
+		return Patch{kind: mediaType, merge: doc}, nil
+	case JSONPatchType:
+		var ops []PatchOp
+		if err := json.Unmarshal(data, &ops); err != nil {
+			return Patch{}, invalidPatch("%s", strings.TrimPrefix(err.Error(), "json: "))
+		}
+		for i, op := range ops {
+			if err := op.check(); err != nil {
+				return Patch{}, invalidPatch("operation %d: %v", i, err)
+			}
+		}
+		return Patch{kind: mediaType, ops: ops}, nil
+	}
+	return Patch{}, invalidPatch("a patch must be %s or %s, not %q", MergePatchType, JSONPatchType, mediaType)
+}
+
+// Apply returns u with p applied; u itself is not changed. A patch that
+// fails, whether a test operation or a read-only field, changes nothing.
+func (p Patch) Apply(u User) (User, error) {
+	if p.kind == "" {
+		return u, nil
+	}
+	before, err := toDoc(u)
+	if err != nil {
+		return User{}, err
+	}
+	doc := copyJSON(before)
+	if p.kind == MergePatchType {
+		doc = mergeDoc(doc, p.merge)
+	}
+	for _, op := range p.ops {
+		if doc, err = op.apply(doc); err != nil {
+			return User{}, invalidPatch("%s %s: %v", op.Op, op.Path, err)
+		}
+	}
+
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return User{}, invalidPatch("the patched user is not an object")
+	}
+	old := before.(map[string]any)
+	for _, f := range readOnly {
+		a, hadA := old[f]
+		b, hasB := obj[f]
+		if hadA != hasB || !equalJSON(a, b) {
+			return User{}, invalidPatch("%s cannot be changed", f)
+		}
+	}
+	var out User
+	b, err := json.Marshal(obj)
+	if err == nil {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&out)
+	}
+	if err != nil {
+		return User{}, invalidPatch("%s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return out, nil
+}
+
+func invalidPatch(format string, args ...any) error {
+	return rejection(fmt.Sprintf(format, args...))
+}
+
+// decodeJSON reads one JSON value, numbers kept as written so that an id
+// or a value compared by test survives the round trip exactly.
+func decodeJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("more than one JSON value")
+	}
+	return nil
+}
+
+func toDoc(u User) (any, error) {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	err = decodeJSON(b, &doc)
+	return doc, err
+}
+
+// mergeDoc is RFC 7396's MergePatch.
+func mergeDoc(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergeDoc(t[k], v)
+		}
+	}
+	return t
+}
+
+/*
+-----------------------------------
+JSON PATCH
+-----------------------------------
+*/
+
+var (
+	errNoValue      = errors.New("no value at the path")
+	errNotContainer = errors.New("the path goes through a value that is not an object or array")
+)
+
+func (op PatchOp) check() error {
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return fmt.Errorf("%s needs a value", op.Op)
+		}
+	case "move", "copy":
+		if _, err := pointer(op.From); err != nil {
+			return err
+		}
+	case "remove":
+	default:
+		return fmt.Errorf("unknown op %q", op.Op)
+	}
+	_, err := pointer(op.Path)
+	return err
+}
+
+func (op PatchOp) apply(doc any) (any, error) {
+	path, _ := pointer(op.Path)
+	switch op.Op {
+	case "add", "replace", "test":
+		var v any
+		if err := decodeJSON(op.Value, &v); err != nil {
+			return nil, err
+		}
+		if op.Op == "add" {
+			return addAt(doc, path, v)
+		}
+		cur, err := valueAt(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "test" {
+			if !equalJSON(cur, v) {
+				return nil, errors.New("test failed")
+			}
+			return doc, nil
+		}
+		if doc, err = removeAt(doc, path); err != nil {
+			return nil, err
+		}
+		return addAt(doc, path, v)
+	case "remove":
+		return removeAt(doc, path)
+	default: // move, copy
+		from, _ := pointer(op.From)
+		v, err := valueAt(doc, from)
+		if err != nil {
+			return nil, fmt.Errorf("from %s: %w", op.From, err)
+		}
+		if op.Op == "copy" {
+			return addAt(doc, path, copyJSON(v))
+		}
+		if len(path) > len(from) && slices.Equal(path[:len(from)], from) {
+			return nil, errors.New("cannot move a value into itself")
+		}
+		if doc, err = removeAt(doc, from); err != nil {
+			return nil, err
+		}
+		return addAt(doc, path, v)
+	}
+}
+
+// pointer splits an RFC 6901 JSON pointer into its reference tokens; ""
+// is the whole document.
+func pointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("path %q must start with /", p)
+	}
+	toks := strings.Split(p[1:], "/")
+	for i, t := range toks {
+		toks[i] = pointerEscapes.Replace(t)
+	}
+	return toks, nil
+}
+
+var pointerEscapes = strings.NewReplacer("~1", "/", "~0", "~")
+
+// arrayIndex reads an array index no greater than last.
+func arrayIndex(tok string, last int) (int, error) {
+	i, err := strconv.Atoi(tok)
+	if err != nil || i < 0 || tok != strconv.Itoa(i) {
+		return 0, fmt.Errorf("%q is not an array index", tok)
+	}
+	if i > last {
+		return 0, errNoValue
+	}
+	return i, nil
+}
+
+func valueAt(doc any, path []string) (any, error) {
+	for _, t := range path {
+		switch n := doc.(type) {
+		case map[string]any:
+			v, ok := n[t]
+			if !ok {
+				return nil, errNoValue
+			}
+			doc = v
+		case []any:
+			i, err := arrayIndex(t, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = n[i]
+		default:
+			return nil, errNoValue
+		}
+	}
+	return doc, nil
+}
+
+// within calls f on the object or array holding path's last token and puts
+// what it returns, the container changed, back into the document.
+func within(doc any, path []string, f func(parent any, tok string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return f(doc, path[0])
+	}
+	switch n := doc.(type) {
+	case map[string]any:
+		child, ok := n[path[0]]
+		if !ok {
+			return nil, errNoValue
+		}
+		c, err := within(child, path[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = c
+		return n, nil
+	case []any:
+		i, err := arrayIndex(path[0], len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		c, err := within(n[i], path[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = c
+		return n, nil
+	}
+	return nil, errNotContainer
+}
+
+func addAt(doc any, path []string, v any) (any, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	return within(doc, path, func(parent any, tok string) (any, error) {
+		switch n := parent.(type) {
+		case map[string]any:
+			n[tok] = v
+			return n, nil
+		case []any:
+			i := len(n)
+			if tok != "-" {
+				var err error
+				if i, err = arrayIndex(tok, len(n)); err != nil {
+					return nil, err
+				}
+			}
+			return slices.Insert(n, i, v), nil
+		}
+		return nil, errNotContainer
+	})
+}
+
+func removeAt(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	return within(doc, path, func(parent any, tok string) (any, error) {
+		switch n := parent.(type) {
+		case map[string]any:
+			if _, ok := n[tok]; !ok {
+				return nil, errNoValue
+			}
+			delete(n, tok)
+			return n, nil
+		case []any:
+			i, err := arrayIndex(tok, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			return slices.Delete(n, i, i+1), nil
+		}
+		return nil, errNotContainer
+	})
+}
+
+// equalJSON is test's comparison: numbers by value, objects regardless of
+// member order.
+func equalJSON(a, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if a == b {
+			return true
+		}
+		fa, errA := a.Float64()
+		fb, errB := b.Float64()
+		return errA == nil && errB == nil && fa == fb
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := b[k]; !ok || !equalJSON(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equalJSON(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	default: // string, bool, nil
+		return a == b
+	}
+}
+
+func copyJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, w := range v {
+			m[k] = copyJSON(w)
+		}
+		return m
+	case []any:
+		s := make([]any, len(v))
+		for i, w := range v {
+			s[i] = copyJSON(w)
+		}
+		return s
+	default:
+		return v
+	}
+}
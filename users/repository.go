@@ -0,0 +1,355 @@
+package users
+
+import (
+	"context"
+	"iter"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/index"
+	"Go-Internals/normalize"
+	"Go-Internals/query"
+	"Go-Internals/ttl"
+)
+
+/*
+-----------------------------------
+INTERFACE (VERY IMPORTANT)
+-----------------------------------
+*/
+
+// UserRepository is the storage contract. Every backend (in-memory, mmap,
+// ...) implements it, so the service layer never knows which one it has.
+type UserRepository interface {
+	Create(user User) (User, error)
+	GetByID(id int) (User, error)
+	// List returns every user, oldest first, in the same order every
+	// time.
+	List() []User
+	Update(user User) (User, error)
+	Delete(id int) error
+
+	// Search returns users matching spec, oldest first.
+	Search(spec query.Spec) ([]User, error)
+
+	// Iterate streams users without building the full slice.
+	Iterate(ctx context.Context, opts IterateOptions) iter.Seq2[User, error]
+}
+
+// Restorer is implemented by backends that can insert a record exactly as
+// given, ID and CreatedAt included, which Create cannot (it assigns both).
+// Restores and migrations need it so IDs held elsewhere stay valid. The
+// next assigned ID moves past the restored one.
+type Restorer interface {
+	// Restore fails with ErrUserExists if the ID is taken and
+	// ErrEmailTaken if the email is.
+	Restore(user User) error
+}
+
+// IDPeeker is implemented by backends that can tell which ID Create
+// will assign next, so a dry run can say what a registration would get.
+// Another writer may take it first.
+type IDPeeker interface {
+	PeekID() (int, error)
+}
+
+// BulkUpdater is implemented by backends that can store changes to many
+// users at once: all of them or, if one fails, none. UpdateWhere uses it;
+// on other backends it updates one user at a time.
+type BulkUpdater interface {
+	// UpdateMany stores each of list as Update would, in order, so a
+	// later one may take an email an earlier one gave up. It fails with
+	// ErrUserNotFound or ErrEmailTaken as Update does, having stored
+	// nothing.
+	UpdateMany(list []User) ([]User, error)
+}
+
+/*
+-----------------------------------
+IN-MEMORY REPOSITORY
+-----------------------------------
+*/
+
+// InMemoryUserRepo keeps users in a map (lookup by ID) plus a set of
+// secondary indexes that are maintained on every Create/Update/Delete:
+//
+//   - email:      unique by normalize.EmailKey (ErrEmailTaken on conflict)
+//   - created_at: ordered, for List order and time ranges
+//   - name:       ordered by lowercased name, for prefix search
+//
+// Users with an ExpiresAt are also scheduled on a TTL sweeper; see RunExpiry.
+type InMemoryUserRepo struct {
+	mu      sync.Mutex
+	users   map[int]User
+	nextID  int
+	changes *changeLog
+
+	expiry   *ttl.Sweeper[int]
+	onExpire func(User)
+
+	indexes   *index.Set[User]
+	byEmail   *index.Ordered[User, string]
+	byCreated *index.Ordered[User, time.Time]
+	byName    *index.Ordered[User, string]
+}
+
+func NewInMemoryUserRepo() *InMemoryUserRepo {
+	r := &InMemoryUserRepo{
+		users:   make(map[int]User),
+		nextID:  1,
+		changes: newChangeLog(DefaultChangeRetention),
+	}
+
+	r.byEmail = index.NewOrderedCmp("email", func(u User) string { return normalize.EmailKey(u.Email) },
+		index.Options{Unique: true, ErrDuplicate: ErrEmailTaken})
+	r.byCreated = index.NewOrdered("created_at", func(u User) time.Time { return u.CreatedAt },
+		time.Time.Compare, index.Options{})
+	r.byName = index.NewOrderedCmp("name", func(u User) string { return strings.ToLower(u.Name) },
+		index.Options{})
+	r.indexes = index.NewSet[User](r.byEmail, r.byCreated, r.byName)
+	r.expiry = ttl.New(nil, r.expire)
+
+	return r
+}
+
+func (r *InMemoryUserRepo) Create(user User) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user.ID = r.nextID
+	user.CreatedAt = time.Now()
+
+	if err := r.indexes.Insert(user.ID, user); err != nil {
+		return User{}, err
+	}
+	r.users[user.ID] = user
+	r.nextID++
+	r.scheduleLocked(user)
+	r.changes.append(OpCreate, nil, &user)
+
+	return user, nil
+}
+
+// PeekID is the ID Create assigns next; see IDPeeker.
+func (r *InMemoryUserRepo) PeekID() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.nextID, nil
+}
+
+func (r *InMemoryUserRepo) Restore(user User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[user.ID]; ok {
+		return ErrUserExists
+	}
+	if err := r.indexes.Insert(user.ID, user); err != nil {
+		return err
+	}
+	r.users[user.ID] = user
+	r.nextID = max(r.nextID, user.ID+1)
+	r.scheduleLocked(user)
+	r.changes.append(OpCreate, nil, &user)
+	return nil
+}
+
+func (r *InMemoryUserRepo) GetByID(id int) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	// Expired but not swept yet: already gone as far as callers are concerned.
+	if !ok || user.Expired(time.Now()) {
+		return User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// List returns all users ordered by CreatedAt (oldest first).
+func (r *InMemoryUserRepo) List() []User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]User, 0, len(r.users))
+	r.byCreated.Ascend(func(_ time.Time, id int) bool {
+		result = append(result, r.users[id])
+		return true
+	})
+	return result
+}
+
+// Update replaces name and email of an existing user. ID and CreatedAt are
+// owned by the repository and are kept from the stored record.
+func (r *InMemoryUserRepo) Update(user User) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.users[user.ID]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	user.CreatedAt = old.CreatedAt
+
+	if err := r.indexes.Update(user.ID, old, user); err != nil {
+		return User{}, err
+	}
+	r.users[user.ID] = user
+	r.scheduleLocked(user)
+	r.changes.append(OpUpdate, &old, &user)
+	return user, nil
+}
+
+// UpdateMany is Update for each of list, all or nothing; see BulkUpdater.
+func (r *InMemoryUserRepo) UpdateMany(list []User) ([]User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	olds := make([]User, 0, len(list))
+	updated := make([]User, 0, len(list))
+	for _, user := range list {
+		old, ok := r.users[user.ID]
+		if !ok {
+			r.undoLocked(olds, updated)
+			return nil, ErrUserNotFound
+		}
+		user.CreatedAt = old.CreatedAt
+		if err := r.indexes.Update(user.ID, old, user); err != nil {
+			r.undoLocked(olds, updated)
+			return nil, err
+		}
+		r.users[user.ID] = user
+		olds = append(olds, old)
+		updated = append(updated, user)
+	}
+	for i, user := range updated {
+		r.scheduleLocked(user)
+		r.changes.append(OpUpdate, &olds[i], &user)
+	}
+	return updated, nil
+}
+
+// undoLocked puts back the records UpdateMany replaced, newest first, so
+// each index entry is restored to what it was.
+func (r *InMemoryUserRepo) undoLocked(olds, updated []User) {
+	for i := len(updated) - 1; i >= 0; i-- {
+		_ = r.indexes.Update(olds[i].ID, updated[i], olds[i])
+		r.users[olds[i].ID] = olds[i]
+	}
+}
+
+func (r *InMemoryUserRepo) Delete(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	r.indexes.Remove(id, old)
+	delete(r.users, id)
+	r.expiry.Cancel(id)
+	r.changes.append(OpDelete, &old, nil)
+	return nil
+}
+
+/*
+-----------------------------------
+TTL EXPIRY
+-----------------------------------
+*/
+
+// RunExpiry removes users as their ExpiresAt passes, until ctx is done.
+// Each removal is recorded in the change feed as OpExpire.
+func (r *InMemoryUserRepo) RunExpiry(ctx context.Context) { r.expiry.Run(ctx) }
+
+// OnExpire registers a hook called (outside the repository lock) for every
+// user the sweeper removes, e.g. to publish an event.
+func (r *InMemoryUserRepo) OnExpire(fn func(User)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onExpire = fn
+}
+
+func (r *InMemoryUserRepo) scheduleLocked(u User) {
+	if u.ExpiresAt.IsZero() {
+		r.expiry.Cancel(u.ID)
+		return
+	}
+	r.expiry.Schedule(u.ID, u.ExpiresAt)
+}
+
+func (r *InMemoryUserRepo) expire(id int, _ time.Time) {
+	r.mu.Lock()
+	u, ok := r.users[id]
+	if !ok || !u.Expired(time.Now()) {
+		r.mu.Unlock()
+		return
+	}
+	r.indexes.Remove(id, u)
+	delete(r.users, id)
+	r.changes.append(OpExpire, &u, nil)
+	hook := r.onExpire
+	r.mu.Unlock()
+
+	if hook != nil {
+		hook(u)
+	}
+}
+
+/*
+-----------------------------------
+INDEX QUERIES
+-----------------------------------
+*/
+
+// GetByEmail looks a user up through the unique email index.
+func (r *InMemoryUserRepo) GetByEmail(email string) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := r.byEmail.Lookup(normalize.EmailKey(email))
+	if len(ids) == 0 {
+		return User{}, ErrUserNotFound
+	}
+	return r.users[ids[0]], nil
+}
+
+// ListCreatedBetween returns users with from <= CreatedAt < to, oldest first.
+func (r *InMemoryUserRepo) ListCreatedBetween(from, to time.Time) []User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(r.byCreated.Range(from, to))
+}
+
+// ListByEmail returns all users ordered by email.
+func (r *InMemoryUserRepo) ListByEmail() []User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]User, 0, len(r.users))
+	r.byEmail.Ascend(func(_ string, id int) bool {
+		result = append(result, r.users[id])
+		return true
+	})
+	return result
+}
+
+// SearchNamePrefix returns users whose name starts with prefix
+// (case-insensitive), ordered by name.
+func (r *InMemoryUserRepo) SearchNamePrefix(prefix string) []User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.resolveLocked(index.Prefix(r.byName, strings.ToLower(prefix)))
+}
+
+func (r *InMemoryUserRepo) resolveLocked(ids []int) []User {
+	result := make([]User, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, r.users[id])
+	}
+	return result
+}
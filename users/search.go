@@ -0,0 +1,137 @@
+package users
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"Go-Internals/index"
+	"Go-Internals/normalize"
+	"Go-Internals/query"
+)
+
+// Getter exposes a user's fields to query.Match.
+func Getter(u User) query.Getter {
+	return func(field string) (any, bool) {
+		switch field {
+		case query.FieldID:
+			return u.ID, true
+		case query.FieldName:
+			return u.Name, true
+		case query.FieldEmail:
+			return u.Email, true
+		case query.FieldCreatedAt:
+			return u.CreatedAt, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+// Filter applies spec to a slice; backends without their own planner use it.
+func Filter(all []User, spec query.Spec) ([]User, error) {
+	var result []User
+	for _, u := range all {
+		ok, err := query.Match(spec, Getter(u))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, u)
+		}
+	}
+	return result, nil
+}
+
+// SortByCreated orders users oldest first (ID breaks ties), the same order
+// List uses.
+func SortByCreated(list []User) {
+	slices.SortFunc(list, func(a, b User) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return a.ID - b.ID
+	})
+}
+
+/*
+-----------------------------------
+IN-MEMORY SEARCH (WITH INDEX PLANNING)
+-----------------------------------
+*/
+
+// Search returns users matching spec, oldest first.
+//
+// If spec (or one branch of a top-level And) is something an index can
+// answer — email equality, a created_at bound, a name prefix — only that
+// index's candidates are evaluated. Otherwise it falls back to a scan.
+func (r *InMemoryUserRepo) Search(spec query.Spec) ([]User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var candidates []User
+	if ids, ok := r.planLocked(spec); ok {
+		candidates = r.resolveLocked(ids)
+	} else {
+		candidates = make([]User, 0, len(r.users))
+		for _, u := range r.users {
+			candidates = append(candidates, u)
+		}
+	}
+
+	result, err := Filter(candidates, spec)
+	if err != nil {
+		return nil, err
+	}
+	SortByCreated(result)
+	return result, nil
+}
+
+// planLocked returns candidate IDs from an index, or false if no index
+// applies. Candidates are a superset of the answer: Match still runs on them.
+func (r *InMemoryUserRepo) planLocked(spec query.Spec) ([]int, bool) {
+	switch s := spec.(type) {
+	case query.And:
+		for _, child := range s {
+			if ids, ok := r.planLocked(child); ok {
+				return ids, true
+			}
+		}
+		return nil, false
+
+	case query.Cmp:
+		switch {
+		case s.Field == query.FieldEmail && s.Op == query.OpEq:
+			// The email index compares normalize.EmailKey, Match the
+			// strings: the index may return a superset, never miss a row.
+			if v, ok := s.Value.(string); ok {
+				return r.byEmail.Lookup(normalize.EmailKey(v)), true
+			}
+
+		case s.Field == query.FieldCreatedAt:
+			t, ok := s.Value.(time.Time)
+			if !ok {
+				return nil, false
+			}
+			lo, hi := time.Time{}, time.Unix(math.MaxInt64/2, 0)
+			switch s.Op {
+			case query.OpGt, query.OpGe:
+				lo = t
+			case query.OpLt, query.OpLe:
+				hi = t.Add(1) // Le needs t itself; Match trims Lt
+			case query.OpEq:
+				lo, hi = t, t.Add(1)
+			default:
+				return nil, false
+			}
+			return r.byCreated.Range(lo, hi), true
+
+		case s.Field == query.FieldName && s.Op == query.OpPrefix:
+			if v, ok := s.Value.(string); ok {
+				return index.Prefix(r.byName, strings.ToLower(v)), true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,813 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Go-Internals/audit"
+	"Go-Internals/breadcrumb"
+	"Go-Internals/ctxutil"
+	"Go-Internals/dryrun"
+	"Go-Internals/eventbus"
+	"Go-Internals/featureflag"
+	"Go-Internals/fieldmask"
+	"Go-Internals/i18n"
+	"Go-Internals/normalize"
+	"Go-Internals/query"
+	"Go-Internals/quota"
+	"Go-Internals/slowop"
+	"Go-Internals/tenant"
+)
+
+/*
+-----------------------------------
+SERVICE LAYER
+-----------------------------------
+*/
+
+type UserService struct {
+	repo  UserRepository
+	quota *quota.Tracker
+	flags *featureflag.Set
+	audit audit.Sink
+
+	norm       normalize.Options
+	validators []Validator
+	enrichers  []Enricher
+	events     *eventbus.Bus
+	slow       *slowop.Detector
+	ids        IDPeeker
+
+	// locks serialize changes to a user within the process, striped by
+	// ID; see lock.
+	locks [64]sync.Mutex
+}
+
+// ServiceOption configures optional UserService dependencies.
+type ServiceOption func(*UserService)
+
+// WithQuota enforces per-tenant quotas (tenant taken from the context):
+// every call counts as one API call, every registration as one stored item.
+func WithQuota(t *quota.Tracker) ServiceOption {
+	return func(s *UserService) { s.quota = t }
+}
+
+// WithFlags lets the service (and its callers, via FeatureEnabled) gate
+// behaviour on feature flags.
+func WithFlags(f *featureflag.Set) ServiceOption {
+	return func(s *UserService) { s.flags = f }
+}
+
+// WithAudit records every successful mutation to sink.
+func WithAudit(sink audit.Sink) ServiceOption {
+	return func(s *UserService) { s.audit = sink }
+}
+
+// WithNormalization sets the folds the service applies to every name and
+// email it is given, on top of the trimming, NFC and lowercasing it always
+// applies; see normalize.Options.
+func WithNormalization(o normalize.Options) ServiceOption {
+	return func(s *UserService) { s.norm = o }
+}
+
+// Validator checks a registration, or the record an update would leave,
+// before it is stored. Returning
+// Reject(reason) (or any error matching ErrInvalidInput) turns it down
+// with the error's text as the reason shown to the caller; any other
+// error fails the request as is.
+type Validator func(ctx context.Context, u User) error
+
+// Reject is the error a Validator returns to refuse a registration.
+func Reject(reason string) error { return rejection(reason) }
+
+type rejection string
+
+func (r rejection) Error() string        { return string(r) }
+func (r rejection) Is(target error) bool { return target == ErrInvalidInput }
+
+// WithValidator adds a check to every registration; validators run in the
+// order they were added and the first error wins.
+func WithValidator(v Validator) ServiceOption {
+	return func(s *UserService) { s.validators = append(s.validators, v) }
+}
+
+// Topics the service publishes on with WithEvents. The payload is the
+// User; for a deletion only its ID is set.
+const (
+	TopicUserCreated = "user.created"
+	TopicUserUpdated = "user.updated"
+	TopicUserDeleted = "user.deleted"
+)
+
+// WithEvents publishes every successful registration, update and deletion
+// to bus.
+// Publishing never fails the request: subscribers with a drop policy lose
+// events rather than slow the service down.
+func WithEvents(bus *eventbus.Bus) ServiceOption {
+	return func(s *UserService) { s.events = bus }
+}
+
+// WithSlowOps reports service calls that run past d's thresholds. The
+// operations are named users.register, users.get, users.get_many,
+// users.search, users.count, users.aggregate, users.update,
+// users.patch, users.update_where, users.set_avatar, users.delete,
+// users.export and users.list.
+func WithSlowOps(d *slowop.Detector) ServiceOption {
+	return func(s *UserService) { s.slow = d }
+}
+
+// WithIDPeeker lets a dry run say which ID a registration would get;
+// without it the ID is 0. Give it the backend itself: the repositories
+// wrapping one do not pass PeekID on.
+func WithIDPeeker(p IDPeeker) ServiceOption {
+	return func(s *UserService) { s.ids = p }
+}
+
+const (
+	opRegister    = "users.register"
+	opGetUser     = "users.get"
+	opGetUsers    = "users.get_many"
+	opSearch      = "users.search"
+	opCount       = "users.count"
+	opAggregate   = "users.aggregate"
+	opUpdate      = "users.update"
+	opPatch       = "users.patch"
+	opUpdateWhere = "users.update_where"
+	opSetAvatar   = "users.set_avatar"
+	opDelete      = "users.delete"
+	opExport      = "users.export"
+	opList        = "users.list"
+)
+
+func NewUserService(repo UserRepository, opts ...ServiceOption) *UserService {
+	s := &UserService{repo: repo, audit: audit.Discard}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Errors returned by service methods carry i18n message keys; render them
+// for users with i18n.Message(ctx, err). errors.Is still matches the
+// underlying sentinels (ErrUserNotFound, quota.ErrQuotaExceeded, ...).
+
+func (s *UserService) RegisterUser(ctx context.Context, name, email string) (User, error) {
+	defer s.begin(ctx, opRegister)()
+
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return User{}, err
+	}
+	name, email = s.norm.Name(name), s.norm.Email(email)
+	if name == "" || email == "" {
+		return User{}, i18n.Wrap(ErrInvalidInput, "user.name_email_required", nil)
+	}
+	email, err := checkEmail(email)
+	if err != nil {
+		return User{}, err
+	}
+	if err := s.consume(ctx, quota.StorageItems); err != nil {
+		return User{}, err
+	}
+
+	user := User{
+		Name:  name,
+		Email: email,
+	}
+	if err := s.validate(ctx, user); err != nil {
+		s.release(ctx, quota.StorageItems)
+		return User{}, err
+	}
+
+	end := breadcrumb.Stage(ctx, "repo.create")
+	created, err := s.create(ctx, user)
+	end()
+	if err != nil || dryrun.Enabled(ctx) {
+		// A dry run stores nothing, so takes up no room.
+		s.release(ctx, quota.StorageItems)
+	}
+	if err != nil {
+		return User{}, localize(err, i18n.Params{"email": email})
+	}
+	s.record(ctx, "create", created.ID, nil)
+	s.publish(ctx, TopicUserCreated, created)
+	return created, nil
+}
+
+func (s *UserService) GetUser(ctx context.Context, id int) (User, error) {
+	defer s.begin(ctx, opGetUser, "id", id)()
+
+	select {
+	case <-ctx.Done():
+		return User{}, i18n.Wrap(breadcrumb.Annotate(ctx, ctx.Err()), "request.cancelled", nil)
+	default:
+	}
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return User{}, err
+	}
+	end := breadcrumb.Stage(ctx, "repo.get_by_id")
+	user, err := s.repo.GetByID(id)
+	end()
+	return user, localize(err, i18n.Params{"id": id})
+}
+
+// GetUsers loads several users with one repository call, a Search over
+// their IDs, where GetUser would make one call each. IDs that do not
+// exist are absent from the map rather than an error.
+func (s *UserService) GetUsers(ctx context.Context, ids []int) (map[int]User, error) {
+	defer s.slow.Start(ctx, opGetUsers, "ids", len(ids)).End()
+
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return nil, err
+	}
+	found := make(map[int]User, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+	spec := make(query.Or, 0, len(ids))
+	for _, id := range ids {
+		spec = append(spec, query.Cmp{Field: query.FieldID, Op: query.OpEq, Value: id})
+	}
+	end := breadcrumb.Stage(ctx, "repo.search")
+	list, err := s.repo.Search(spec)
+	end()
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range list {
+		found[u.ID] = u
+	}
+	return found, nil
+}
+
+// SearchUsers returns the users matching spec, oldest first.
+func (s *UserService) SearchUsers(ctx context.Context, spec query.Spec) ([]User, error) {
+	defer s.begin(ctx, opSearch)()
+
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return nil, err
+	}
+	defer breadcrumb.Stage(ctx, "repo.search")()
+	return s.repo.Search(spec)
+}
+
+// CountUsers is how many users match spec; see Count.
+func (s *UserService) CountUsers(ctx context.Context, spec query.Spec) (int, error) {
+	defer s.begin(ctx, opCount)()
+
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return 0, err
+	}
+	defer breadcrumb.Stage(ctx, "repo.count")()
+	return Count(ctx, s.repo, spec)
+}
+
+// AggregateUsers summarizes the users matching spec; see Aggregate.
+func (s *UserService) AggregateUsers(ctx context.Context, spec query.Spec) (Aggregates, error) {
+	defer s.begin(ctx, opAggregate)()
+
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return Aggregates{}, err
+	}
+	defer breadcrumb.Stage(ctx, "repo.aggregate")()
+	return Aggregate(ctx, s.repo, spec)
+}
+
+// UpdateUser changes a user's name and email; an empty argument keeps the
+// current value. Both are normalized first, as for a registration or a
+// patch (see WithNormalization). The changed record goes through the validators, as a
+// registration does.
+func (s *UserService) UpdateUser(ctx context.Context, id int, name, email string) (User, error) {
+	defer s.begin(ctx, opUpdate, "id", id)()
+
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return User{}, err
+	}
+	defer s.lock(id)()
+	end := breadcrumb.Stage(ctx, "repo.get_by_id")
+	before, err := s.repo.GetByID(id)
+	end()
+	if err != nil {
+		return User{}, localize(err, i18n.Params{"id": id})
+	}
+	user := before
+	if name = s.norm.Name(name); name != "" {
+		user.Name = name
+	}
+	if email = s.norm.Email(email); email != "" {
+		if user.Email, err = checkEmail(email); err != nil {
+			return User{}, err
+		}
+	}
+	if err := s.validate(ctx, user); err != nil {
+		return User{}, err
+	}
+	end = breadcrumb.Stage(ctx, "repo.update")
+	updated, err := s.update(ctx, user)
+	end()
+	if err != nil {
+		return User{}, localize(err, i18n.Params{"id": id, "email": user.Email})
+	}
+	meta, _ := audit.Changes(before, updated)
+	s.record(ctx, "update", id, meta)
+	s.publish(ctx, TopicUserUpdated, updated)
+	return updated, nil
+}
+
+// PatchUser applies p to the user. A non-empty version must be the user's
+// current Version, the one the caller read, or the patch is refused with
+// ErrVersionConflict: someone changed the user since. The patched record
+// must keep a name and email and goes through the validators, as an
+// update does; the audit entry lists the fields that changed. A patch
+// that changes nothing stores and records nothing.
+func (s *UserService) PatchUser(ctx context.Context, id int, p Patch, version string) (User, error) {
+	defer s.begin(ctx, opPatch, "id", id)()
+
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return User{}, err
+	}
+	defer s.lock(id)()
+	end := breadcrumb.Stage(ctx, "repo.get_by_id")
+	user, err := s.repo.GetByID(id)
+	end()
+	if err != nil {
+		return User{}, localize(err, i18n.Params{"id": id})
+	}
+	if version != "" && version != s.Version(ctx, user) {
+		return User{}, i18n.Wrap(ErrVersionConflict, "user.version_conflict", i18n.Params{"id": id})
+	}
+	patched, changed, err := s.applyPatch(ctx, user, p)
+	if err != nil || !changed {
+		return patched, err
+	}
+	end = breadcrumb.Stage(ctx, "repo.update")
+	updated, err := s.update(ctx, patched)
+	end()
+	if err != nil {
+		return User{}, localize(err, i18n.Params{"id": id, "email": patched.Email})
+	}
+	meta, _ := audit.Changes(user, updated)
+	s.record(ctx, "update", id, meta)
+	s.publish(ctx, TopicUserUpdated, updated)
+	return updated, nil
+}
+
+// applyPatch is user with p applied, normalized and checked as PatchUser
+// stores it; changed is false, and user returned as is, if p changes
+// nothing.
+func (s *UserService) applyPatch(ctx context.Context, user User, p Patch) (patched User, changed bool, err error) {
+	patched, err = p.Apply(user)
+	if err != nil {
+		return User{}, false, i18n.Wrap(err, "user.patch_invalid", i18n.Params{"reason": err.Error()})
+	}
+	patched.Name, patched.Email = s.norm.Name(patched.Name), s.norm.Email(patched.Email)
+	if hashJSON(patched) == hashJSON(user) {
+		return user, false, nil
+	}
+	if patched.Name == "" || patched.Email == "" {
+		return User{}, false, i18n.Wrap(ErrInvalidInput, "user.name_email_required", nil)
+	}
+	if patched.Email != user.Email {
+		if patched.Email, err = checkEmail(patched.Email); err != nil {
+			return User{}, false, err
+		}
+	}
+	if err := s.validate(ctx, patched); err != nil {
+		return User{}, false, err
+	}
+	return patched, true, nil
+}
+
+// BulkUpdate is what UpdateWhere did.
+type BulkUpdate struct {
+	// Matched is how many users the spec matched, Updated how many of
+	// them the patch changed.
+	Matched int `json:"matched"`
+	Updated int `json:"updated"`
+	// IDs are the updated users', oldest first.
+	IDs []int `json:"ids"`
+}
+
+// UpdateWhere applies p to every user matching spec. Each patched record
+// is checked as PatchUser checks one, and if any fails, its error naming
+// the user, none is stored. A BulkUpdater backend stores them all at
+// once; on another they are stored one at a time and, should one fail,
+// those already stored are put back, which a concurrent writer elsewhere
+// may see. The audit log gets one entry for the lot, the event bus one
+// TopicUserUpdated per user. It holds every user's lock while it runs.
+func (s *UserService) UpdateWhere(ctx context.Context, spec query.Spec, p Patch) (BulkUpdate, error) {
+	defer s.begin(ctx, opUpdateWhere)()
+
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return BulkUpdate{}, err
+	}
+	defer s.lockAll()()
+	end := breadcrumb.Stage(ctx, "repo.search")
+	matched, err := s.repo.Search(spec)
+	end()
+	if err != nil {
+		return BulkUpdate{}, err
+	}
+	var before, after []User
+	for _, u := range matched {
+		patched, changed, err := s.applyPatch(ctx, u, p)
+		if err != nil {
+			return BulkUpdate{}, bulkFailed(ctx, u.ID, err)
+		}
+		if changed {
+			before, after = append(before, u), append(after, patched)
+		}
+	}
+	end = breadcrumb.Stage(ctx, "repo.update_many")
+	updated, err := s.updateMany(ctx, before, after)
+	end()
+	if err != nil {
+		return BulkUpdate{}, err
+	}
+
+	res := BulkUpdate{Matched: len(matched), Updated: len(updated), IDs: make([]int, len(updated))}
+	for i, u := range updated {
+		res.IDs[i] = u.ID
+	}
+	s.recordEntry(ctx, audit.Entry{
+		Action:   "bulk_update",
+		Resource: "user",
+		Meta: map[string]string{
+			"matched": strconv.Itoa(res.Matched),
+			"updated": strconv.Itoa(res.Updated),
+			"ids":     joinIDs(res.IDs),
+		},
+	})
+	for _, u := range updated {
+		s.publish(ctx, TopicUserUpdated, u)
+	}
+	return res, nil
+}
+
+// updateMany stores after, the records that replace before, for
+// UpdateWhere.
+func (s *UserService) updateMany(ctx context.Context, before, after []User) ([]User, error) {
+	if len(after) == 0 {
+		return nil, nil
+	}
+	if dryrun.Enabled(ctx) {
+		updated := make([]User, len(after))
+		for i, u := range after {
+			var err error
+			if updated[i], err = s.update(ctx, u); err != nil {
+				return nil, bulkFailed(ctx, u.ID, localize(err, i18n.Params{"id": u.ID, "email": u.Email}))
+			}
+		}
+		return updated, nil
+	}
+	if b, ok := s.repo.(BulkUpdater); ok {
+		updated, err := b.UpdateMany(after)
+		if errors.Is(err, ErrEmailTaken) {
+			// The backend does not say whose.
+			return nil, i18n.Wrap(err, "user.bulk_email_taken", nil)
+		}
+		return updated, localize(err, nil)
+	}
+	updated := make([]User, 0, len(after))
+	for _, u := range after {
+		stored, err := s.repo.Update(u)
+		if err != nil {
+			for i := len(updated) - 1; i >= 0; i-- {
+				_, _ = s.repo.Update(before[i])
+			}
+			return nil, bulkFailed(ctx, u.ID, localize(err, i18n.Params{"id": u.ID, "email": u.Email}))
+		}
+		updated = append(updated, stored)
+	}
+	return updated, nil
+}
+
+// bulkFailed is err, which turned down user id's part of a bulk change.
+func bulkFailed(ctx context.Context, id int, err error) error {
+	return i18n.Wrap(err, "user.bulk_failed", i18n.Params{"id": id, "reason": i18n.Message(ctx, err)})
+}
+
+func joinIDs(ids []int) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return strings.Join(s, ",")
+}
+
+// SetAvatarURL records where the user's avatar is served; empty clears
+// it. Storing the image is the caller's job (package avatar).
+func (s *UserService) SetAvatarURL(ctx context.Context, id int, url string) (User, error) {
+	defer s.begin(ctx, opSetAvatar, "id", id)()
+
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return User{}, err
+	}
+	defer s.lock(id)()
+	end := breadcrumb.Stage(ctx, "repo.get_by_id")
+	user, err := s.repo.GetByID(id)
+	end()
+	if err != nil {
+		return User{}, localize(err, i18n.Params{"id": id})
+	}
+	user.AvatarURL = url
+	end = breadcrumb.Stage(ctx, "repo.update")
+	updated, err := s.update(ctx, user)
+	end()
+	if err != nil {
+		return User{}, localize(err, i18n.Params{"id": id})
+	}
+	s.record(ctx, "avatar", id, nil)
+	s.publish(ctx, TopicUserUpdated, updated)
+	return updated, nil
+}
+
+func (s *UserService) DeleteUser(ctx context.Context, id int) error {
+	defer s.begin(ctx, opDelete, "id", id)()
+
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return err
+	}
+	end := breadcrumb.Stage(ctx, "repo.delete")
+	err := s.remove(ctx, id)
+	end()
+	if err != nil {
+		return localize(err, i18n.Params{"id": id})
+	}
+	if !dryrun.Enabled(ctx) {
+		s.release(ctx, quota.StorageItems)
+	}
+	s.record(ctx, "delete", id, nil)
+	s.publish(ctx, TopicUserDeleted, User{ID: id})
+	return nil
+}
+
+// ExportUsers streams every user to w as JSON lines, enriched. The
+// repository sees ctx, so a load-shedding repository can refuse batch
+// exports.
+func (s *UserService) ExportUsers(ctx context.Context, w io.Writer) (int, error) {
+	defer s.begin(ctx, opExport)()
+
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return 0, err
+	}
+	return exportJSONLines(ctx, s.repo, w, IterateOptions{}, func(u User) any { return s.Enrich(ctx, u) })
+}
+
+func (s *UserService) ListUsers(ctx context.Context) ([]User, error) {
+	defer s.begin(ctx, opList)()
+
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return nil, err
+	}
+	defer breadcrumb.Stage(ctx, "repo.list")()
+	return s.repo.List(), nil
+}
+
+// ListUsersBy is ListUsers shaped by opts: sorted by the backend if it
+// is a SortedLister, otherwise here, and masked to opts.Fields.
+func (s *UserService) ListUsersBy(ctx context.Context, opts ListOptions) ([]User, error) {
+	if len(opts.SortBy) == 0 && opts.Fields == nil {
+		return s.ListUsers(ctx)
+	}
+	defer s.begin(ctx, opList, "sort", opts.SortBy, "fields", opts.Fields)()
+
+	if err := s.consume(ctx, quota.APICalls); err != nil {
+		return nil, err
+	}
+	end := breadcrumb.Stage(ctx, "repo.list")
+	var list []User
+	if sl, ok := s.repo.(SortedLister); ok && len(opts.SortBy) > 0 {
+		list = sl.ListSorted(opts.SortBy)
+	} else {
+		list = s.repo.List()
+		SortUsers(list, opts.SortBy)
+	}
+	end()
+	if opts.Fields != nil {
+		for i, u := range list {
+			list[i] = fieldmask.Apply(opts.Fields, u)
+		}
+	}
+	return list, nil
+}
+
+// localize attaches message keys to the domain errors repositories return.
+// Unknown errors pass through unchanged.
+func localize(err error, params i18n.Params) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrUserNotFound):
+		return i18n.Wrap(err, "user.not_found", params)
+	case errors.Is(err, ErrEmailTaken):
+		return i18n.Wrap(err, "user.email_taken", params)
+	default:
+		return err
+	}
+}
+
+// FeatureEnabled evaluates a flag for a user. Without a flag set every
+// flag is off, so new behaviour stays dark by default.
+func (s *UserService) FeatureEnabled(flag string, userID int) bool {
+	return s.flags != nil && s.flags.Enabled(flag, userID)
+}
+
+/*
+-----------------------------------
+QUOTA HOOKS
+-----------------------------------
+*/
+
+func (s *UserService) consume(ctx context.Context, r quota.Resource) error {
+	if s.quota == nil {
+		return nil
+	}
+	defer breadcrumb.Stage(ctx, "quota")()
+	err := s.quota.Consume(tenant.From(ctx), r, 1)
+	return i18n.Wrap(err, "quota.exceeded", i18n.Params{"resource": r})
+}
+
+// record is best effort: a failing audit sink must not fail the request.
+// meta carries an update's before/after (audit.Changes).
+func (s *UserService) record(ctx context.Context, action string, id int, meta map[string]string) {
+	s.recordEntry(ctx, audit.Entry{
+		Action:     action,
+		Resource:   "user",
+		ResourceID: strconv.Itoa(id),
+		Meta:       meta,
+	})
+}
+
+// recordEntry is record for an entry of any shape, e's Actor set from
+// ctx.
+func (s *UserService) recordEntry(ctx context.Context, e audit.Entry) {
+	defer breadcrumb.Stage(ctx, "audit")()
+	var sink audit.Sink = s.audit
+	if p := dryrun.From(ctx); p != nil {
+		sink = p
+	}
+	e.Actor = ctxutil.UserID(ctx)
+	_ = sink.Record(ctx, e)
+}
+
+// lock serializes the read, check and write of one user's record, so the
+// version PatchUser checks is the one it replaces. It holds only within
+// this process: writers elsewhere sharing the backend are not excluded.
+func (s *UserService) lock(id int) func() {
+	m := &s.locks[uint(id)%uint(len(s.locks))]
+	m.Lock()
+	return m.Unlock
+}
+
+// lockAll takes every user's lock, for a change to many users at once.
+func (s *UserService) lockAll() func() {
+	for i := range s.locks {
+		s.locks[i].Lock()
+	}
+	return func() {
+		for i := range s.locks {
+			s.locks[i].Unlock()
+		}
+	}
+}
+
+func (s *UserService) validate(ctx context.Context, u User) error {
+	if len(s.validators) == 0 {
+		return nil
+	}
+	defer breadcrumb.Stage(ctx, "validate")()
+	for _, v := range s.validators {
+		err := v(ctx, u)
+		if errors.Is(err, ErrInvalidInput) {
+			return i18n.Wrap(err, "user.rejected", i18n.Params{"reason": err.Error()})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// begin marks the start of a service call, as a stage of the request's
+// breadcrumb trail and for the slow operation detector, and returns the
+// func that marks its end.
+func (s *UserService) begin(ctx context.Context, op string, args ...any) func() {
+	done := breadcrumb.Stage(ctx, op)
+	slow := s.slow.Start(ctx, op, args...)
+	return func() {
+		slow.End()
+		done()
+	}
+}
+
+func (s *UserService) publish(ctx context.Context, topic string, u User) {
+	if s.events == nil {
+		return
+	}
+	if p := dryrun.From(ctx); p != nil {
+		p.Publish(topic, u)
+		return
+	}
+	defer breadcrumb.Stage(ctx, "publish")()
+	_, _ = s.events.Publish(ctx, topic, u)
+}
+
+func (s *UserService) release(ctx context.Context, r quota.Resource) {
+	if s.quota != nil {
+		s.quota.Release(tenant.From(ctx), r, 1)
+	}
+}
+
+/*
+-----------------------------------
+DRY RUNS
+-----------------------------------
+*/
+
+// In a dry run (see package dryrun) a mutation is checked as it would
+// be otherwise, quotas and validators included, and then what it would
+// store goes to the plan instead of the repository, as do its audit
+// entry and event. A taken email is looked for with Search, so the
+// answer is that of a backend keeping emails unique, as Create's is.
+
+// create is s.repo.Create, or in a dry run the record it would store.
+func (s *UserService) create(ctx context.Context, u User) (User, error) {
+	p := dryrun.From(ctx)
+	if p == nil {
+		return s.repo.Create(u)
+	}
+	if err := s.emailFree(u); err != nil {
+		return User{}, err
+	}
+	if s.ids != nil {
+		id, err := s.ids.PeekID()
+		if err != nil {
+			return User{}, err
+		}
+		u.ID = id
+	}
+	u.CreatedAt = time.Now()
+	p.Write(dryrun.Write{Op: "create", Resource: "user", ID: planID(u.ID), Record: u})
+	return u, nil
+}
+
+// update is s.repo.Update, or in a dry run the record it would store.
+func (s *UserService) update(ctx context.Context, u User) (User, error) {
+	p := dryrun.From(ctx)
+	if p == nil {
+		return s.repo.Update(u)
+	}
+	old, err := s.repo.GetByID(u.ID)
+	if err != nil {
+		return User{}, err
+	}
+	if u.Email != old.Email {
+		if err := s.emailFree(u); err != nil {
+			return User{}, err
+		}
+	}
+	u.CreatedAt = old.CreatedAt
+	p.Write(dryrun.Write{Op: "update", Resource: "user", ID: planID(u.ID), Record: u})
+	return u, nil
+}
+
+// remove is s.repo.Delete, or in a dry run a check that there is a user
+// to delete.
+func (s *UserService) remove(ctx context.Context, id int) error {
+	p := dryrun.From(ctx)
+	if p == nil {
+		return s.repo.Delete(id)
+	}
+	if _, err := s.repo.GetByID(id); err != nil {
+		return err
+	}
+	p.Write(dryrun.Write{Op: "delete", Resource: "user", ID: planID(id)})
+	return nil
+}
+
+// emailFree is ErrEmailTaken if a user other than u has u's email.
+func (s *UserService) emailFree(u User) error {
+	found, err := s.repo.Search(query.Cmp{Field: query.FieldEmail, Op: query.OpEq, Value: u.Email})
+	if err != nil {
+		return err
+	}
+	for _, f := range found {
+		if f.ID != u.ID {
+			return ErrEmailTaken
+		}
+	}
+	return nil
+}
+
+// planID is id as a plan has it, "" for the 0 of an ID no one could tell.
+func planID(id int) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.Itoa(id)
+}
@@ -0,0 +1,307 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"Go-Internals/query"
+)
+
+/*
+-----------------------------------
+SHADOW READS
+-----------------------------------
+*/
+
+// ShadowOptions configures ShadowReads.
+type ShadowOptions struct {
+	// Sample is the fraction of reads also sent to the shadow, 0 to 1;
+	// default 1 (every read).
+	Sample float64
+	// MaxInFlight bounds concurrent shadow reads; a read arriving while
+	// that many are running is not shadowed (counted as Skipped), so a
+	// slow shadow cannot pile up goroutines. Default 8.
+	MaxInFlight int
+	// Logger receives one warning per mismatch, with the diff. Default
+	// slog.Default().
+	Logger *slog.Logger
+	// OnMismatch, if set, is called for every mismatch as well.
+	OnMismatch func(Mismatch)
+}
+
+// Mismatch is one read on which the shadow disagreed with the primary.
+type Mismatch struct {
+	Method string   `json:"method"`
+	Key    string   `json:"key"` // the read's argument: "id=5", the spec, ...
+	Diff   []string `json:"diff"`
+}
+
+// ShadowStat counts one method's comparisons.
+type ShadowStat struct {
+	Compared   int64 `json:"compared"`
+	Mismatched int64 `json:"mismatched"`
+	Skipped    int64 `json:"skipped"`
+}
+
+// DivergenceRate is the fraction of comparisons that mismatched.
+func (s ShadowStat) DivergenceRate() float64 {
+	if s.Compared == 0 {
+		return 0
+	}
+	return float64(s.Mismatched) / float64(s.Compared)
+}
+
+// ShadowRepo answers every call from the primary and, for reads, asks the
+// shadow the same question in the background and compares the answers.
+// It validates a new implementation against the one it replaces on real
+// traffic without the shadow's answers (or latency) reaching callers.
+// Writes go to the primary only: keep the shadow written some other way,
+// typically with datamove.DualWrite.
+//
+// A write between the primary read and its shadow read shows up as a
+// mismatch, so a low divergence rate under write load is expected; a
+// mismatch that persists on a quiet record is the finding. Errors count
+// as answers: not found on one side and a user on the other mismatches.
+// Iterate is compared only when the caller consumed the whole sequence.
+type ShadowRepo struct {
+	UserRepository
+	shadow UserRepository
+	opts   ShadowOptions
+	slots  chan struct{}
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	stats map[string]*ShadowStat
+}
+
+func ShadowReads(primary, shadow UserRepository, opts ShadowOptions) *ShadowRepo {
+	if opts.Sample <= 0 || opts.Sample > 1 {
+		opts.Sample = 1
+	}
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 8
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	r := &ShadowRepo{UserRepository: primary, shadow: shadow, opts: opts, slots: make(chan struct{}, opts.MaxInFlight), stats: make(map[string]*ShadowStat)}
+	for _, m := range []string{MethodGetByID, MethodList, MethodSearch, MethodIterate} {
+		r.stats[m] = &ShadowStat{}
+	}
+	return r
+}
+
+func (r *ShadowRepo) GetByID(id int) (User, error) {
+	u, err := r.UserRepository.GetByID(id)
+	r.compare(MethodGetByID, fmt.Sprint("id=", id), func() []string {
+		su, serr := r.shadow.GetByID(id)
+		return diffOne(u, err, su, serr)
+	})
+	return u, err
+}
+
+func (r *ShadowRepo) List() []User {
+	list := r.UserRepository.List()
+	r.compare(MethodList, "", func() []string { return diffList(list, nil, r.shadow.List(), nil, true) })
+	return list
+}
+
+func (r *ShadowRepo) Search(spec query.Spec) ([]User, error) {
+	found, err := r.UserRepository.Search(spec)
+	r.compare(MethodSearch, specKey(spec), func() []string {
+		sfound, serr := r.shadow.Search(spec)
+		return diffList(found, err, sfound, serr, true)
+	})
+	return found, err
+}
+
+// Iterate keeps what it yields to compare it once the sequence is done,
+// so a shadowed iteration holds the result set in memory.
+func (r *ShadowRepo) Iterate(ctx context.Context, opts IterateOptions) iter.Seq2[User, error] {
+	return func(yield func(User, error) bool) {
+		var seen []User
+		var ierr error
+		for u, err := range r.UserRepository.Iterate(ctx, opts) {
+			if err != nil {
+				ierr = err
+			} else {
+				seen = append(seen, u)
+			}
+			if !yield(u, err) {
+				return
+			}
+		}
+		r.compare(MethodIterate, specKey(opts.Filter), func() []string {
+			var shadow []User
+			var serr error
+			for u, err := range r.shadow.Iterate(context.Background(), opts) {
+				if err != nil {
+					serr = err
+					break
+				}
+				shadow = append(shadow, u)
+			}
+			return diffList(seen, ierr, shadow, serr, false)
+		})
+	}
+}
+
+// compare runs read against the shadow in the background, if this read
+// is sampled and a slot is free.
+func (r *ShadowRepo) compare(method, key string, read func() []string) {
+	if r.opts.Sample < 1 && rand.Float64() >= r.opts.Sample {
+		return
+	}
+	select {
+	case r.slots <- struct{}{}:
+	default:
+		r.count(method, func(s *ShadowStat) { s.Skipped++ })
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer func() { <-r.slots; r.wg.Done() }()
+		diff := read()
+		r.count(method, func(s *ShadowStat) {
+			s.Compared++
+			if len(diff) > 0 {
+				s.Mismatched++
+			}
+		})
+		if len(diff) == 0 {
+			return
+		}
+		m := Mismatch{Method: method, Key: key, Diff: diff}
+		r.opts.Logger.Warn("shadow read mismatch", "method", method, "key", key, "diff", diff)
+		if r.opts.OnMismatch != nil {
+			r.opts.OnMismatch(m)
+		}
+	}()
+}
+
+func (r *ShadowRepo) count(method string, f func(*ShadowStat)) {
+	r.mu.Lock()
+	f(r.stats[method])
+	r.mu.Unlock()
+}
+
+// Wait blocks until every shadow read started so far has been compared.
+func (r *ShadowRepo) Wait() { r.wg.Wait() }
+
+// Stats returns a copy of the counters keyed by method (MethodGetByID, ...).
+func (r *ShadowRepo) Stats() map[string]ShadowStat {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string]ShadowStat, len(r.stats))
+	for m, s := range r.stats {
+		out[m] = *s
+	}
+	return out
+}
+
+// maxDiffLines bounds a Mismatch's diff, so that comparing two very
+// different lists logs a summary rather than every record.
+const maxDiffLines = 10
+
+func diffOne(u User, err error, su User, serr error) []string {
+	switch {
+	case err != nil || serr != nil:
+		if errString(err) != errString(serr) {
+			return []string{fmt.Sprintf("error: %s != %s", errString(err), errString(serr))}
+		}
+		return nil
+	default:
+		return diffUser(u, su)
+	}
+}
+
+// diffUser lists the fields that differ. Times are compared as instants:
+// backends return them in different locations.
+func diffUser(a, b User) []string {
+	var d []string
+	field := func(name string, x, y any) { d = append(d, fmt.Sprintf("user %d %s: %v != %v", a.ID, name, x, y)) }
+	if a.ID != b.ID {
+		field("id", a.ID, b.ID)
+	}
+	if a.Name != b.Name {
+		field("name", fmt.Sprintf("%q", a.Name), fmt.Sprintf("%q", b.Name))
+	}
+	if a.Email != b.Email {
+		field("email", fmt.Sprintf("%q", a.Email), fmt.Sprintf("%q", b.Email))
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		field("created_at", a.CreatedAt.Format(time.RFC3339Nano), b.CreatedAt.Format(time.RFC3339Nano))
+	}
+	if !a.ExpiresAt.Equal(b.ExpiresAt) {
+		field("expires_at", a.ExpiresAt.Format(time.RFC3339Nano), b.ExpiresAt.Format(time.RFC3339Nano))
+	}
+	if a.AvatarURL != b.AvatarURL {
+		field("avatar_url", fmt.Sprintf("%q", a.AvatarURL), fmt.Sprintf("%q", b.AvatarURL))
+	}
+	return d
+}
+
+// diffList matches records by ID. With ordered (List and Search promise
+// oldest first; Iterate promises no order) a difference in order alone
+// is a mismatch too.
+func diffList(a []User, err error, b []User, serr error, ordered bool) []string {
+	if err != nil || serr != nil {
+		return diffOne(User{}, err, User{}, serr)
+	}
+	byID := make(map[int]User, len(b))
+	for _, u := range b {
+		byID[u.ID] = u
+	}
+	var d []string
+	add := func(lines ...string) {
+		for _, l := range lines {
+			if len(d) == maxDiffLines {
+				d = append(d, "...")
+			}
+			if len(d) <= maxDiffLines {
+				d = append(d, l)
+			}
+		}
+	}
+	for _, u := range a {
+		su, ok := byID[u.ID]
+		if !ok {
+			add(fmt.Sprintf("user %d: missing from shadow", u.ID))
+			continue
+		}
+		add(diffUser(u, su)...)
+		delete(byID, u.ID)
+	}
+	for _, u := range b {
+		if _, extra := byID[u.ID]; extra {
+			add(fmt.Sprintf("user %d: only in shadow", u.ID))
+		}
+	}
+	if len(d) == 0 && ordered {
+		for i := range a {
+			if a[i].ID != b[i].ID {
+				return []string{fmt.Sprintf("order: position %d is user %d, shadow has user %d", i, a[i].ID, b[i].ID)}
+			}
+		}
+	}
+	return d
+}
+
+func specKey(spec query.Spec) string {
+	if spec == nil {
+		return ""
+	}
+	return fmt.Sprint(spec)
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
@@ -0,0 +1,72 @@
+package users
+
+import (
+	"context"
+	"iter"
+
+	"Go-Internals/loadshed"
+	"Go-Internals/query"
+)
+
+/*
+-----------------------------------
+LOAD SHEDDING
+-----------------------------------
+*/
+
+// ShedRepo refuses work with a *loadshed.OverloadError when the shedder
+// says the process is overloaded. Iterate takes its priority from ctx (so
+// bulk exports tagged loadshed.Batch go first); the context-free methods
+// count as Interactive. List cannot fail and is never shed.
+type ShedRepo struct {
+	UserRepository
+	shed *loadshed.Shedder
+}
+
+func Shed(repo UserRepository, s *loadshed.Shedder) *ShedRepo {
+	return &ShedRepo{UserRepository: repo, shed: s}
+}
+
+func (r *ShedRepo) Create(u User) (User, error) {
+	if err := r.shed.Allow(loadshed.Interactive); err != nil {
+		return User{}, err
+	}
+	return r.UserRepository.Create(u)
+}
+
+func (r *ShedRepo) GetByID(id int) (User, error) {
+	if err := r.shed.Allow(loadshed.Interactive); err != nil {
+		return User{}, err
+	}
+	return r.UserRepository.GetByID(id)
+}
+
+func (r *ShedRepo) Update(u User) (User, error) {
+	if err := r.shed.Allow(loadshed.Interactive); err != nil {
+		return User{}, err
+	}
+	return r.UserRepository.Update(u)
+}
+
+func (r *ShedRepo) Delete(id int) error {
+	if err := r.shed.Allow(loadshed.Interactive); err != nil {
+		return err
+	}
+	return r.UserRepository.Delete(id)
+}
+
+func (r *ShedRepo) Search(spec query.Spec) ([]User, error) {
+	if err := r.shed.Allow(loadshed.Interactive); err != nil {
+		return nil, err
+	}
+	return r.UserRepository.Search(spec)
+}
+
+// Iterate is checked once, before the first batch: an export that has
+// started is allowed to finish.
+func (r *ShedRepo) Iterate(ctx context.Context, opts IterateOptions) iter.Seq2[User, error] {
+	if err := r.shed.AllowCtx(ctx); err != nil {
+		return func(yield func(User, error) bool) { yield(User{}, err) }
+	}
+	return r.UserRepository.Iterate(ctx, opts)
+}
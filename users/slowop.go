@@ -0,0 +1,74 @@
+package users
+
+import (
+	"context"
+	"iter"
+
+	"Go-Internals/query"
+	"Go-Internals/slowop"
+)
+
+/*
+-----------------------------------
+SLOW OPERATION REPORTS
+-----------------------------------
+*/
+
+// SlowOpRepo wraps a UserRepository and reports calls that run past the
+// detector's thresholds, named "repo." plus the Method constant
+// ("repo.get_by_id"). Repository calls carry no context, so the reports
+// have no request ID; the service call around them (WithSlowOps) has it.
+// As with InstrumentedRepo, optional backend interfaces are not
+// forwarded.
+type SlowOpRepo struct {
+	UserRepository
+	d *slowop.Detector
+}
+
+func WatchSlowOps(repo UserRepository, d *slowop.Detector) *SlowOpRepo {
+	return &SlowOpRepo{UserRepository: repo, d: d}
+}
+
+func (r *SlowOpRepo) start(method string, args ...any) *slowop.Op {
+	return r.d.Start(context.Background(), "repo."+method, args...)
+}
+
+func (r *SlowOpRepo) Create(u User) (User, error) {
+	defer r.start(MethodCreate).End()
+	return r.UserRepository.Create(u)
+}
+
+func (r *SlowOpRepo) GetByID(id int) (User, error) {
+	defer r.start(MethodGetByID, "id", id).End()
+	return r.UserRepository.GetByID(id)
+}
+
+func (r *SlowOpRepo) List() []User {
+	defer r.start(MethodList).End()
+	return r.UserRepository.List()
+}
+
+func (r *SlowOpRepo) Update(u User) (User, error) {
+	defer r.start(MethodUpdate, "id", u.ID).End()
+	return r.UserRepository.Update(u)
+}
+
+func (r *SlowOpRepo) Delete(id int) error {
+	defer r.start(MethodDelete, "id", id).End()
+	return r.UserRepository.Delete(id)
+}
+
+func (r *SlowOpRepo) Search(spec query.Spec) ([]User, error) {
+	defer r.start(MethodSearch).End()
+	return r.UserRepository.Search(spec)
+}
+
+// Iterate is timed from first pull to the consumer stopping, as
+// InstrumentedRepo times it, and reported with the iteration's context.
+func (r *SlowOpRepo) Iterate(ctx context.Context, opts IterateOptions) iter.Seq2[User, error] {
+	seq := r.UserRepository.Iterate(ctx, opts)
+	return func(yield func(User, error) bool) {
+		defer r.d.Start(ctx, "repo."+MethodIterate).End()
+		seq(yield)
+	}
+}
@@ -0,0 +1,157 @@
+package users
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"Go-Internals/fieldmask"
+)
+
+/*
+-----------------------------------
+SORTED LISTS
+-----------------------------------
+*/
+
+// SortField is what a list can be ordered by.
+type SortField string
+
+const (
+	SortID      SortField = "id"
+	SortName    SortField = "name" // case-insensitive
+	SortCreated SortField = "created_at"
+)
+
+// SortKey is one field of an ordering.
+type SortKey struct {
+	Field SortField
+	Desc  bool
+}
+
+// ListOptions shapes a list of users.
+type ListOptions struct {
+	// SortBy orders the list by each key in turn. Users equal on every
+	// key are ordered by ID, descending if the last key is, so the order
+	// is the same every time. Empty is List's order, oldest first.
+	SortBy []SortKey
+	// Fields keeps only these fields of each user and zeroes the rest;
+	// nil keeps them all. fieldmask.Select leaves the rest out of the
+	// JSON too.
+	Fields fieldmask.Mask
+}
+
+// SortedLister is implemented by backends that can order a list
+// themselves, as an SQL store would with ORDER BY, or from an index.
+// Other backends' lists are sorted by SortUsers.
+type SortedLister interface {
+	ListSorted(keys []SortKey) []User
+}
+
+// ParseSort reads a list of fields, each descending with a leading '-':
+// "name,-created_at".
+func ParseSort(s string) ([]SortKey, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var keys []SortKey
+	for f := range strings.SplitSeq(s, ",") {
+		f = strings.TrimSpace(f)
+		k := SortKey{}
+		if rest, ok := strings.CutPrefix(f, "-"); ok {
+			f, k.Desc = rest, true
+		}
+		switch k.Field = SortField(f); k.Field {
+		case SortID, SortName, SortCreated:
+		default:
+			return nil, fmt.Errorf("%w: cannot sort by %q (id, name or created_at)", ErrInvalidInput, f)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// ParseFields reads a list of fields of a user as read: "id,name", or
+// "derived" for the enrichers' fields.
+func ParseFields(s string) (fieldmask.Mask, error) {
+	m, err := fieldmask.Parse[Enriched](s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	return m, nil
+}
+
+// String is the ParseSort form.
+func (k SortKey) String() string {
+	if k.Desc {
+		return "-" + string(k.Field)
+	}
+	return string(k.Field)
+}
+
+// SortUsers orders list by keys, as ListOptions describes.
+func SortUsers(list []User, keys []SortKey) {
+	if len(keys) == 0 {
+		return
+	}
+	idDesc := keys[len(keys)-1].Desc
+	slices.SortStableFunc(list, func(a, b User) int {
+		for _, k := range keys {
+			c := compareBy(k.Field, a, b)
+			if k.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		if idDesc {
+			return cmp.Compare(b.ID, a.ID)
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+func compareBy(f SortField, a, b User) int {
+	switch f {
+	case SortName:
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	case SortCreated:
+		return a.CreatedAt.Compare(b.CreatedAt)
+	default:
+		return cmp.Compare(a.ID, b.ID)
+	}
+}
+
+// ListSorted walks the name or created_at index for a single key on
+// either; its entries are ordered by key then ID, which is SortUsers'
+// order ascending and, walked backwards, descending. Anything else is
+// List sorted.
+func (r *InMemoryUserRepo) ListSorted(keys []SortKey) []User {
+	if len(keys) != 1 || keys[0].Field == SortID {
+		list := r.List()
+		SortUsers(list, keys)
+		return list
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]User, 0, len(r.users))
+	add := func(id int) bool {
+		result = append(result, r.users[id])
+		return true
+	}
+	switch k := keys[0]; {
+	case k.Field == SortName && !k.Desc:
+		r.byName.Ascend(func(_ string, id int) bool { return add(id) })
+	case k.Field == SortName:
+		r.byName.Descend(func(_ string, id int) bool { return add(id) })
+	case !k.Desc:
+		r.byCreated.Ascend(func(_ time.Time, id int) bool { return add(id) })
+	default:
+		r.byCreated.Descend(func(_ time.Time, id int) bool { return add(id) })
+	}
+	return result
+}
@@ -0,0 +1,117 @@
+package users
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+var day = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// sortFixture has names and creation times that tie in several ways:
+// Ada twice (once lower case), and days 1 and 2 twice each.
+func sortFixture() []User {
+	return []User{
+		{ID: 4, Name: "bob", Email: "bob@example.com", CreatedAt: day.Add(1 * 24 * time.Hour)},
+		{ID: 1, Name: "Ada", Email: "ada@example.com", CreatedAt: day.Add(2 * 24 * time.Hour)},
+		{ID: 5, Name: "Cy", Email: "cy@example.com", CreatedAt: day.Add(2 * 24 * time.Hour)},
+		{ID: 3, Name: "ada", Email: "ada2@example.com", CreatedAt: day.Add(1 * 24 * time.Hour)},
+		{ID: 2, Name: "Bob", Email: "bob2@example.com", CreatedAt: day},
+	}
+}
+
+func ids(list []User) []int {
+	out := make([]int, len(list))
+	for i, u := range list {
+		out[i] = u.ID
+	}
+	return out
+}
+
+func TestSortUsers(t *testing.T) {
+	tests := []struct {
+		sort string
+		want []int
+	}{
+		{"", []int{4, 1, 5, 3, 2}}, // left as it is
+		{"id", []int{1, 2, 3, 4, 5}},
+		{"-id", []int{5, 4, 3, 2, 1}},
+		// Equal names fall back to ID, in the last key's direction.
+		{"name", []int{1, 3, 2, 4, 5}},
+		{"-name", []int{5, 4, 2, 3, 1}},
+		{"created_at", []int{2, 3, 4, 1, 5}},
+		{"-created_at", []int{5, 1, 4, 3, 2}},
+		// Each key in turn: the second orders only what ties on the first.
+		{"name,created_at", []int{3, 1, 2, 4, 5}},
+		{"name,-created_at", []int{1, 3, 4, 2, 5}},
+		{"-created_at,name", []int{1, 5, 3, 4, 2}},
+		{"created_at,-name", []int{2, 4, 3, 5, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			keys, err := ParseSort(tt.sort)
+			if err != nil {
+				t.Fatal(err)
+			}
+			list := sortFixture()
+			SortUsers(list, keys)
+			if got := ids(list); !slices.Equal(got, tt.want) {
+				t.Fatalf("SortUsers(%q) = %v, want %v", tt.sort, got, tt.want)
+			}
+		})
+	}
+}
+
+// Users equal on every key come out in the same order whatever order
+// they went in, so a page boundary does not move between requests.
+func TestSortUsersIgnoresInputOrder(t *testing.T) {
+	for _, sort := range []string{"name", "-name", "created_at", "-created_at", "name,created_at"} {
+		keys, _ := ParseSort(sort)
+		want := sortFixture()
+		SortUsers(want, keys)
+		for i := range 10 {
+			list := sortFixture()
+			// Every rotation and its reverse.
+			list = append(list[i%len(list):], list[:i%len(list)]...)
+			if i >= len(list) {
+				slices.Reverse(list)
+			}
+			SortUsers(list, keys)
+			if !slices.Equal(ids(list), ids(want)) {
+				t.Fatalf("SortUsers(%q) of input %d = %v, want %v", sort, i, ids(list), ids(want))
+			}
+		}
+	}
+}
+
+// The in-memory store's indexes give the same order SortUsers does.
+func TestListSortedMatchesSortUsers(t *testing.T) {
+	repo := NewInMemoryUserRepo()
+	for _, u := range sortFixture() {
+		if err := repo.Restore(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, sort := range []string{"id", "name", "-name", "created_at", "-created_at", "name,-created_at"} {
+		keys, _ := ParseSort(sort)
+		want := sortFixture()
+		SortUsers(want, keys)
+		if got := ids(repo.ListSorted(keys)); !slices.Equal(got, ids(want)) {
+			t.Errorf("ListSorted(%q) = %v, want %v", sort, got, ids(want))
+		}
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	keys, err := ParseSort(" name , -created_at ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SortKey{{Field: SortName}, {Field: SortCreated, Desc: true}}
+	if !slices.Equal(keys, want) {
+		t.Fatalf("ParseSort = %v, want %v", keys, want)
+	}
+	if _, err := ParseSort("email"); err == nil {
+		t.Fatal("ParseSort(email) succeeded")
+	}
+}
@@ -0,0 +1,44 @@
+// Package users is the user domain shared by every backend and transport:
+// the entity, the repository contract, the in-memory repository and the
+// service layer.
+package users
+
+import (
+	"errors"
+	"time"
+)
+
+// User represents a basic entity (like DB model)
+//
+// The encrypt tags mark PII for EncryptedRepo (package fieldcrypt); they
+// do nothing unless a repository is wrapped with EncryptFields.
+type User struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name" encrypt:"true" redact:"true"`
+	Email     string    `json:"email" encrypt:"deterministic" redact:"true"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt is optional; the zero value means the record never expires.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+
+	// AvatarURL is where the user's avatar is served, empty if they have
+	// none. It changes with every upload, so clients may cache it forever.
+	AvatarURL string `json:"avatar_url,omitempty"`
+}
+
+// Expired reports whether the record has an expiry and it has passed.
+func (u User) Expired(now time.Time) bool {
+	return !u.ExpiresAt.IsZero() && !now.Before(u.ExpiresAt)
+}
+
+// Custom errors
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrEmailTaken   = errors.New("email already registered")
+	ErrInvalidInput = errors.New("invalid input")
+	ErrUserExists   = errors.New("user ID already exists")
+
+	// ErrVersionConflict refuses a change made against a version of the
+	// user that is no longer current.
+	ErrVersionConflict = errors.New("user has changed since it was read")
+)
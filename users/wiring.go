@@ -0,0 +1,65 @@
+package users
+
+import "Go-Internals/wiring"
+
+// ProvideService registers how c builds the *UserService: on the
+// UserRepository c provides, with whichever of its optional dependencies
+// c provides too: an audit.Sink, *featureflag.Set, *quota.Tracker,
+// *eventbus.Bus, *slowop.Detector, IDPeeker, normalize.Options, and the
+// []Validator and []Enricher to run, in order. A nil one is the same as
+// none.
+func ProvideService(c *wiring.Container) {
+	wiring.Provide(c, func(c *wiring.Container) (*UserService, error) {
+		repo, err := wiring.Get[UserRepository](c)
+		if err != nil {
+			return nil, err
+		}
+		var opts []ServiceOption
+		if err := optional(c, &opts, WithAudit); err != nil {
+			return nil, err
+		}
+		if err := optional(c, &opts, WithFlags); err != nil {
+			return nil, err
+		}
+		if err := optional(c, &opts, WithQuota); err != nil {
+			return nil, err
+		}
+		if err := optional(c, &opts, WithEvents); err != nil {
+			return nil, err
+		}
+		if err := optional(c, &opts, WithSlowOps); err != nil {
+			return nil, err
+		}
+		if err := optional(c, &opts, WithIDPeeker); err != nil {
+			return nil, err
+		}
+		if err := optional(c, &opts, WithNormalization); err != nil {
+			return nil, err
+		}
+		validators, _, err := wiring.Lookup[[]Validator](c)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range validators {
+			opts = append(opts, WithValidator(v))
+		}
+		enrichers, _, err := wiring.Lookup[[]Enricher](c)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range enrichers {
+			opts = append(opts, WithEnricher(e))
+		}
+		return NewUserService(repo, opts...), nil
+	})
+}
+
+// optional appends with(v) to opts if c provides a non-nil T.
+func optional[T comparable](c *wiring.Container, opts *[]ServiceOption, with func(T) ServiceOption) error {
+	v, ok, err := wiring.Lookup[T](c)
+	var zero T
+	if ok && v != zero {
+		*opts = append(*opts, with(v))
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package vacuum
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+// Throttle caps IO at a byte rate. It is a token bucket holding up to one
+// second of budget, so a compaction that starts after idling can burst
+// briefly and then settles at the rate.
+//
+// A nil *Throttle does not limit.
+type Throttle struct {
+	rate float64 // bytes per second
+	clk  clock.Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewThrottle allows bytesPerSec. clk may be nil.
+func NewThrottle(bytesPerSec int64, clk clock.Clock) *Throttle {
+	clk = clock.OrReal(clk)
+	return &Throttle{rate: float64(bytesPerSec), clk: clk, tokens: float64(bytesPerSec), last: clk.Now()}
+}
+
+// Wait blocks until n bytes may be written, or ctx is done. A request
+// larger than the bucket is let through once the bucket is full, and
+// leaves it in debt.
+func (t *Throttle) Wait(ctx context.Context, n int) error {
+	if t == nil || t.rate <= 0 {
+		return ctx.Err()
+	}
+	t.mu.Lock()
+	now := t.clk.Now()
+	t.tokens = min(t.rate, t.tokens+now.Sub(t.last).Seconds()*t.rate)
+	t.last = now
+	need := min(float64(n), t.rate)
+	var wait time.Duration
+	if t.tokens < need {
+		wait = time.Duration((need - t.tokens) / t.rate * float64(time.Second))
+	}
+	// Take the tokens now: concurrent callers queue up behind the debt
+	// instead of all waking at once.
+	t.tokens -= float64(n)
+	t.mu.Unlock()
+
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := t.clk.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
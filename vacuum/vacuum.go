@@ -0,0 +1,163 @@
+// Package vacuum periodically rewrites file-based stores to give back the
+// space held by deleted and superseded records.
+//
+// The stores do the rewriting themselves, since only they know their
+// format; this package decides when, and how fast. Every store follows the
+// same crash-safety recipe: the compacted copy is written to a new file,
+// fsynced, and renamed over (or swapped in for) the old data, then the
+// directory is fsynced. A crash at any point leaves either the old data or
+// the new, never a mix.
+//
+// Rewrites are paced by a Throttle so a large vacuum does not starve the
+// request path of disk bandwidth. The Job is a runmode.Subsystem, so it
+// runs under the process supervisor and gets restarted if it panics.
+package vacuum
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"Go-Internals/clock"
+	"Go-Internals/ctxutil"
+)
+
+// Target is a store that can compact itself. pace is called before each
+// chunk of n bytes is written and returns an error to abort (ctx done);
+// it may be nil. before and after are the store's on-disk sizes.
+type Target interface {
+	Vacuum(ctx context.Context, pace func(ctx context.Context, n int) error) (before, after int64, err error)
+}
+
+// Result is one target's outcome in one run.
+type Result struct {
+	Target   string
+	Before   int64
+	After    int64
+	Duration time.Duration
+	Err      error
+}
+
+// Reclaimed is the space given back; never negative.
+func (r Result) Reclaimed() int64 { return max(0, r.Before-r.After) }
+
+// JobOptions configures NewJob.
+type JobOptions struct {
+	Interval time.Duration // between runs; default 1h
+	Throttle *Throttle     // nil = unthrottled
+	Clock    clock.Clock
+	Logger   *slog.Logger   // default ctxutil.Logger(ctx), a run at a time
+	OnResult func(r Result) // called after every target, e.g. for metrics
+}
+
+// Job vacuums a fixed set of targets, one at a time.
+type Job struct {
+	opts JobOptions
+	clk  clock.Clock
+	log  *slog.Logger
+
+	mu        sync.Mutex
+	targets   []namedTarget
+	runs      int
+	reclaimed int64
+	last      []Result
+}
+
+type namedTarget struct {
+	name string
+	t    Target
+}
+
+func NewJob(opts JobOptions) *Job {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Hour
+	}
+	return &Job{opts: opts, clk: clock.OrReal(opts.Clock), log: opts.Logger}
+}
+
+// logger is the Logger option or, without one, the logger of the run's
+// context: under the supervisor, tagged with the subsystem.
+func (j *Job) logger(ctx context.Context) *slog.Logger {
+	if j.log != nil {
+		return j.log
+	}
+	return ctxutil.Logger(ctx)
+}
+
+// Add registers a target. Targets are vacuumed in the order added.
+func (j *Job) Add(name string, t Target) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.targets = append(j.targets, namedTarget{name, t})
+}
+
+// RunOnce vacuums every target now. A failing target does not stop the
+// others; the joined errors are returned along with every result.
+func (j *Job) RunOnce(ctx context.Context) ([]Result, error) {
+	j.mu.Lock()
+	targets := append([]namedTarget(nil), j.targets...)
+	j.mu.Unlock()
+
+	log := j.logger(ctx)
+	results := make([]Result, 0, len(targets))
+	var errs []error
+	for _, nt := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+		start := j.clk.Now()
+		before, after, err := nt.t.Vacuum(ctx, j.opts.Throttle.Wait)
+		r := Result{Target: nt.name, Before: before, After: after, Duration: j.clk.Since(start), Err: err}
+		results = append(results, r)
+		if err != nil {
+			errs = append(errs, err)
+			log.Warn("vacuum failed", "target", nt.name, "err", err)
+		} else {
+			log.Info("vacuum done", "target", nt.name, "before", before, "after", after,
+				"reclaimed", r.Reclaimed(), "took", r.Duration)
+		}
+		if j.opts.OnResult != nil {
+			j.opts.OnResult(r)
+		}
+	}
+
+	j.mu.Lock()
+	j.runs++
+	for _, r := range results {
+		j.reclaimed += r.Reclaimed()
+	}
+	j.last = results
+	j.mu.Unlock()
+	return results, errors.Join(errs...)
+}
+
+// Run vacuums every Interval until ctx is done. Target errors are logged,
+// not returned: a store that fails to compact is retried next interval
+// rather than restarted by the supervisor.
+func (j *Job) Run(ctx context.Context) error {
+	t := j.clk.NewTicker(j.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C():
+			j.RunOnce(ctx)
+		}
+	}
+}
+
+// Stats summarizes the job so far.
+type Stats struct {
+	Runs      int
+	Reclaimed int64
+	Last      []Result
+}
+
+func (j *Job) Stats() Stats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return Stats{Runs: j.runs, Reclaimed: j.reclaimed, Last: append([]Result(nil), j.last...)}
+}
@@ -0,0 +1,76 @@
+package window
+
+import (
+	"sync"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+// Keyed keeps one Counter per key, for per-client limits and abuse
+// detection. Counters are created on first use; Prune drops the ones that
+// have gone quiet so the map doesn't grow with every key ever seen.
+type Keyed[K comparable] struct {
+	window time.Duration
+	n      int
+	clk    clock.Clock
+
+	mu       sync.Mutex
+	counters map[K]*Counter
+}
+
+func NewKeyed[K comparable](window time.Duration, n int, clk clock.Clock) *Keyed[K] {
+	return &Keyed[K]{window: window, n: n, clk: clock.OrReal(clk), counters: make(map[K]*Counter)}
+}
+
+// Add records n events for key and returns the key's count in the window
+// including them.
+func (k *Keyed[K]) Add(key K, n uint32) uint64 {
+	c := k.counter(key)
+	c.Add(n)
+	return c.Sum()
+}
+
+// Sum is key's count in the window (0 for unknown keys).
+func (k *Keyed[K]) Sum(key K) uint64 {
+	k.mu.Lock()
+	c, ok := k.counters[key]
+	k.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	return c.Sum()
+}
+
+// Len is the number of tracked keys.
+func (k *Keyed[K]) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.counters)
+}
+
+// Prune forgets keys with no events in the window and returns how many it
+// dropped. Call it periodically (e.g. once per window).
+func (k *Keyed[K]) Prune() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	dropped := 0
+	for key, c := range k.counters {
+		if c.Sum() == 0 {
+			delete(k.counters, key)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+func (k *Keyed[K]) counter(key K) *Counter {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	c, ok := k.counters[key]
+	if !ok {
+		c = New(k.window, k.n, k.clk)
+		k.counters[key] = c
+	}
+	return c
+}
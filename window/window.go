@@ -0,0 +1,109 @@
+// Package window counts events over a sliding time window ("requests in
+// the last minute") using a ring of sub-window buckets.
+//
+// A window of 60s split into 60 buckets answers with one-second
+// granularity: the oldest bucket drops out whole rather than event by
+// event, so a Sum can overcount by at most one bucket's worth of time.
+// More buckets mean a smoother window and a slower Sum.
+//
+// Each bucket is a single atomic word packing the bucket's epoch (which
+// bucket-width slice of time it holds) in the high 32 bits and its count in
+// the low 32. Rotating a stale bucket and adding to it is one CAS, so
+// there is no lock and no background goroutine: buckets are recycled
+// lazily by whoever touches them first. They only ever rotate forward: a
+// writer that read the clock before someone else rotated its bucket on
+// has an event already out of the window, and drops it.
+package window
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+// Counter is safe for concurrent use.
+type Counter struct {
+	clk     clock.Clock
+	width   time.Duration // one bucket
+	window  time.Duration
+	buckets []atomic.Uint64
+}
+
+// New counts over window using n buckets. clk may be nil (real time).
+func New(window time.Duration, n int, clk clock.Clock) *Counter {
+	if n <= 0 {
+		n = 10
+	}
+	width := window / time.Duration(n)
+	if width <= 0 {
+		width = 1
+	}
+	return &Counter{
+		clk:     clock.OrReal(clk),
+		width:   width,
+		window:  width * time.Duration(n),
+		buckets: make([]atomic.Uint64, n),
+	}
+}
+
+func (c *Counter) epoch() uint32 {
+	return uint32(c.clk.Now().UnixNano() / int64(c.width))
+}
+
+// Add records n events now. A single bucket saturates at 2^32-1 events.
+func (c *Counter) Add(n uint32) {
+	epoch := c.epoch()
+	b := &c.buckets[int(epoch)%len(c.buckets)]
+	for {
+		old := b.Load()
+		var next uint64
+		if uint32(old>>32) == epoch {
+			count := uint64(uint32(old))
+			next = old&^math.MaxUint32 | min(count+uint64(n), math.MaxUint32)
+		} else if c.live(uint32(old >> 32)) {
+			// The bucket has been rotated past epoch since it was read:
+			// these events are older than the window.
+			return
+		} else {
+			next = uint64(epoch)<<32 | uint64(n) // stale: rotate
+		}
+		if b.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// Inc is Add(1).
+func (c *Counter) Inc() { c.Add(1) }
+
+// Sum is the number of events in the window ending now.
+func (c *Counter) Sum() uint64 {
+	epoch := c.epoch()
+	var total uint64
+	for i := range c.buckets {
+		v := c.buckets[i].Load()
+		if c.within(epoch, uint32(v>>32)) {
+			total += uint64(uint32(v))
+		}
+	}
+	return total
+}
+
+// live reports whether a bucket of epoch is in the window ending now.
+func (c *Counter) live(epoch uint32) bool { return c.within(c.epoch(), epoch) }
+
+// within reports whether a bucket of epoch is in the window ending in
+// bucket now. Unsigned distance handles epoch wraparound.
+func (c *Counter) within(now, epoch uint32) bool {
+	return now-epoch < uint32(len(c.buckets))
+}
+
+// Rate is events per second over the window.
+func (c *Counter) Rate() float64 {
+	return float64(c.Sum()) / c.window.Seconds()
+}
+
+// Window is the effective window (rounded to whole buckets).
+func (c *Counter) Window() time.Duration { return c.window }
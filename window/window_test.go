@@ -0,0 +1,108 @@
+package window
+
+import (
+	"testing"
+	"time"
+
+	"Go-Internals/clock"
+)
+
+var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestCounterRotates(t *testing.T) {
+	clk := clock.NewFake(start)
+	c := New(10*time.Second, 10, clk)
+
+	c.Add(3)
+	clk.Advance(4 * time.Second)
+	c.Add(2)
+	if got := c.Sum(); got != 5 {
+		t.Fatalf("Sum = %d, want 5", got)
+	}
+
+	// The first bucket drops out whole once the window has passed it.
+	clk.Advance(6 * time.Second)
+	if got := c.Sum(); got != 2 {
+		t.Fatalf("Sum after the first bucket left = %d, want 2", got)
+	}
+
+	// The same bucket, a window on, starts again from the new events.
+	c.Add(7)
+	if got := c.Sum(); got != 9 {
+		t.Fatalf("Sum after reusing a bucket = %d, want 9", got)
+	}
+	clk.Advance(10 * time.Second)
+	if got := c.Sum(); got != 0 {
+		t.Fatalf("Sum after the window = %d, want 0", got)
+	}
+}
+
+func TestCounterSaturates(t *testing.T) {
+	c := New(time.Second, 1, clock.NewFake(start))
+	c.Add(1<<32 - 2)
+	c.Add(5)
+	if got := c.Sum(); got != 1<<32-1 {
+		t.Fatalf("Sum = %d, want %d", got, uint64(1<<32-1))
+	}
+}
+
+// laggingClock reads at first, in order, before it reads the Fake: a
+// writer that read the time and was descheduled before adding.
+type laggingClock struct {
+	*clock.Fake
+	at []time.Time
+}
+
+func (l *laggingClock) Now() time.Time {
+	if len(l.at) > 0 {
+		t := l.at[0]
+		l.at = l.at[1:]
+		return t
+	}
+	return l.Fake.Now()
+}
+
+func TestCounterStaleWriter(t *testing.T) {
+	fake := clock.NewFake(start)
+	clk := &laggingClock{Fake: fake}
+	c := New(10*time.Second, 10, clk)
+
+	c.Add(1)
+	// A window on, the same bucket is rotated forward.
+	fake.Advance(10 * time.Second)
+	c.Add(5)
+
+	// A writer that read the clock a window ago lands on that bucket:
+	// it must not rotate it back and lose the newer events.
+	clk.at = []time.Time{start}
+	c.Add(1)
+	if got := c.Sum(); got != 5 {
+		t.Fatalf("Sum = %d, want 5", got)
+	}
+	c.Add(1)
+	if got := c.Sum(); got != 6 {
+		t.Fatalf("Sum after another add = %d, want 6", got)
+	}
+}
+
+func TestKeyedPrune(t *testing.T) {
+	clk := clock.NewFake(start)
+	k := NewKeyed[string](10*time.Second, 10, clk)
+
+	if got := k.Add("a", 2); got != 2 {
+		t.Fatalf("Add(a) = %d, want 2", got)
+	}
+	clk.Advance(5 * time.Second)
+	k.Add("b", 1)
+	clk.Advance(6 * time.Second)
+
+	if got := k.Prune(); got != 1 {
+		t.Fatalf("Prune = %d, want 1", got)
+	}
+	if got, want := k.Sum("a"), uint64(0); got != want {
+		t.Fatalf("Sum(a) = %d, want %d", got, want)
+	}
+	if got := k.Sum("b"); got != 1 {
+		t.Fatalf("Sum(b) = %d, want 1", got)
+	}
+}
@@ -0,0 +1,164 @@
+// Package wiring builds a process's object graph from providers, so that
+// constructing something with many dependencies is a declaration of what
+// it needs rather than a chain of constructors in the right order.
+//
+// A provider says how to build one type, asking the container for what it
+// needs; Get builds a type and, first, everything it asks for, each once:
+//
+//	c := wiring.New()
+//	wiring.Value(c, auditRing)
+//	wiring.Provide(c, func(c *wiring.Container) (users.UserRepository, error) {
+//		return users.NewInMemoryUserRepo(), nil
+//	})
+//	users.ProvideService(c)
+//	service, err := wiring.Get[*users.UserService](c)
+//
+// Types are keyed exactly: a provider of *audit.Ring does not provide
+// audit.Sink. Providing a type again replaces its provider, which is how a
+// test swaps one dependency of a graph built by the code it tests; that
+// has to happen before anything built the type, and panics after.
+package wiring
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// ErrMissing is matched by the error Get returns for a type nothing
+// provides.
+var ErrMissing = errors.New("wiring: not provided")
+
+// Container holds providers and what they built. It is not safe for
+// concurrent use: it is filled and resolved on one goroutine, at startup.
+type Container struct {
+	providers map[reflect.Type]*provider
+	// building is the types being built, outermost first, for cycle
+	// errors and for naming who needed a missing type.
+	building []reflect.Type
+}
+
+type provider struct {
+	build func(*Container) (any, error)
+	state int // unbuilt, building, built
+	value any
+	err   error
+}
+
+const (
+	unbuilt = iota
+	building
+	built
+)
+
+func New() *Container {
+	return &Container{providers: make(map[reflect.Type]*provider)}
+}
+
+// Provide registers build as how c makes a T. It runs on the first Get of
+// T, or of anything that needs one; its result, or its error, is kept.
+func Provide[T any](c *Container, build func(c *Container) (T, error)) {
+	c.provide(reflect.TypeFor[T](), func(c *Container) (any, error) { return build(c) })
+}
+
+// Value registers v as c's T.
+func Value[T any](c *Container, v T) {
+	c.provide(reflect.TypeFor[T](), func(*Container) (any, error) { return v, nil })
+}
+
+func (c *Container) provide(t reflect.Type, build func(*Container) (any, error)) {
+	if p := c.providers[t]; p != nil && p.state != unbuilt {
+		panic("wiring: " + t.String() + " provided again after it was built")
+	}
+	c.providers[t] = &provider{build: build}
+}
+
+// Get returns c's T, building it first if need be. It fails if nothing
+// provides T or something T needs, if a provider fails, or if T ends up
+// needing itself.
+func Get[T any](c *Container) (T, error) {
+	v, err := c.get(reflect.TypeFor[T]())
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return v.(T), nil
+}
+
+// Lookup is Get for an optional dependency: ok is false, with no error,
+// if nothing provides T.
+func Lookup[T any](c *Container) (v T, ok bool, err error) {
+	if !Has[T](c) {
+		return v, false, nil
+	}
+	v, err = Get[T](c)
+	return v, err == nil, err
+}
+
+// MustGet is Get for main, where a graph that cannot be built is a
+// programming error: it panics instead of returning the error.
+func MustGet[T any](c *Container) T {
+	v, err := Get[T](c)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Has reports whether c has a provider for T.
+func Has[T any](c *Container) bool {
+	return c.providers[reflect.TypeFor[T]()] != nil
+}
+
+// Provided lists the types c has providers for, sorted.
+func (c *Container) Provided() []string {
+	out := make([]string, 0, len(c.providers))
+	for t := range c.providers {
+		out = append(out, t.String())
+	}
+	slices.Sort(out)
+	return out
+}
+
+func (c *Container) get(t reflect.Type) (any, error) {
+	p := c.providers[t]
+	if p == nil {
+		if len(c.building) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrMissing, t)
+		}
+		return nil, fmt.Errorf("%w: %s, needed by %s", ErrMissing, t, c.building[len(c.building)-1])
+	}
+	switch p.state {
+	case built:
+		return p.value, p.err
+	case building:
+		i := slices.Index(c.building, t)
+		return nil, fmt.Errorf("wiring: dependency cycle: %s", c.path(append(c.building[i:], t)))
+	}
+
+	p.state = building
+	c.building = append(c.building, t)
+	v, err := p.build(c)
+	c.building = c.building[:len(c.building)-1]
+	if err != nil && !isWiring(err) {
+		err = fmt.Errorf("wiring: building %s: %w", t, err)
+	}
+	p.state, p.value, p.err = built, v, err
+	return v, err
+}
+
+// isWiring reports whether err came from a Get inside the provider, so is
+// not wrapped again at every level of the graph.
+func isWiring(err error) bool {
+	return strings.HasPrefix(err.Error(), "wiring: ")
+}
+
+func (c *Container) path(ts []reflect.Type) string {
+	names := make([]string, len(ts))
+	for i, t := range ts {
+		names[i] = t.String()
+	}
+	return strings.Join(names, " -> ")
+}